@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// CommandDeleteDelay is how long after a delivery the command message is
+// deleted in chats with auto-delete on
+const CommandDeleteDelay = 5 * time.Second
+
+// MessageDeleter is implemented by Telegram APIs that can delete messages.
+// Messages in channels and supergroups are deleted with a different call
+// than those in basic groups
+type MessageDeleter interface {
+	MessagesDeleteMessages(ctx context.Context, request *tg.MessagesDeleteMessagesRequest) (*tg.MessagesAffectedMessages, error)
+	ChannelsDeleteMessages(ctx context.Context, request *tg.ChannelsDeleteMessagesRequest) (*tg.MessagesAffectedMessages, error)
+}
+
+// ChatAutoDelete holds the groups whose command messages are deleted once the
+// song is delivered, and whether each is a channel or supergroup
+type ChatAutoDelete struct {
+	mu    sync.RWMutex
+	chats map[int64]bool // Chat ID to whether it is a channel
+}
+
+// NewChatAutoDelete creates a set of chat settings with auto-delete off
+func NewChatAutoDelete() *ChatAutoDelete {
+	return &ChatAutoDelete{
+		chats: make(map[int64]bool),
+	}
+}
+
+// Enabled reports whether auto-delete is on for a chat, and whether the chat
+// is a channel or supergroup
+func (c *ChatAutoDelete) Enabled(chatID int64) (enabled bool, channel bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	channel, enabled = c.chats[chatID]
+	return enabled, channel
+}
+
+// Set turns auto-delete on or off for a chat
+func (c *ChatAutoDelete) Set(chatID int64, enabled bool, channel bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !enabled {
+		delete(c.chats, chatID)
+		return
+	}
+	c.chats[chatID] = channel
+}
+
+// deleteMessage deletes a message for everyone, with the call that matches
+// the kind of chat peer is
+func deleteMessage(ctx context.Context, api MessageDeleter, peer tg.InputPeerClass, messageID int) error {
+	var err error
+	if channel, ok := peer.(*tg.InputPeerChannel); ok {
+		_, err = api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+			ID:      []int{messageID},
+		})
+	} else {
+		_, err = api.MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
+			Revoke: true,
+			ID:     []int{messageID},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// uploadReplyTo returns the message the audio of a request replies to: its
+// command message, unless auto-delete removes that message after delivery
+func (h *SongHandler) uploadReplyTo(cmdCtx *CommandContext) int {
+	if h.autoDelete != nil {
+		if enabled, _ := h.autoDelete.Enabled(cmdCtx.ChatID); enabled {
+			return 0
+		}
+	}
+	return cmdCtx.MessageID
+}
+
+// scheduleCommandDeletion deletes the command message of a delivered request
+// after deleteDelay when auto-delete is on in its chat. Failures, such as a
+// missing delete permission or a message already deleted, are only logged.
+// The returned channel is closed once the attempt is over (nil when none is
+// scheduled)
+func (h *SongHandler) scheduleCommandDeletion(api downloader.TelegramAPI, cmdCtx *CommandContext) <-chan struct{} {
+	if h.autoDelete == nil || cmdCtx.MessageID == 0 {
+		return nil
+	}
+	if enabled, _ := h.autoDelete.Enabled(cmdCtx.ChatID); !enabled {
+		return nil
+	}
+
+	deleter, ok := api.(MessageDeleter)
+	if !ok {
+		h.logger.Debug("Skipping deletion of command message: the API cannot delete messages", logging.Int("Message", cmdCtx.MessageID), logging.Int64("Chat", cmdCtx.ChatID))
+		return nil
+	}
+
+	done := make(chan struct{})
+	time.AfterFunc(h.deleteDelay, func() {
+		defer close(done)
+
+		// The request's context may be gone by now
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := deleteMessage(ctx, deleter, h.client.Peers().InputPeer(cmdCtx.ChatID), cmdCtx.MessageID); err != nil {
+			h.logger.Warn("Could not delete command message", logging.Int("Message", cmdCtx.MessageID), logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
+			return
+		}
+		h.logger.Info("Deleted command message after delivery", logging.Int("Message", cmdCtx.MessageID), logging.Int64("Chat", cmdCtx.ChatID))
+	})
+	return done
+}
@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// deletingAPI records deletions on top of the reaction mock
+type deletingAPI struct {
+	mockReactionAPI
+	mu             sync.Mutex
+	deletes        []*tg.MessagesDeleteMessagesRequest
+	channelDeletes []*tg.ChannelsDeleteMessagesRequest
+	deleteErr      error
+}
+
+func (m *deletingAPI) MessagesDeleteMessages(ctx context.Context, request *tg.MessagesDeleteMessagesRequest) (*tg.MessagesAffectedMessages, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletes = append(m.deletes, request)
+	if m.deleteErr != nil {
+		return nil, m.deleteErr
+	}
+	return &tg.MessagesAffectedMessages{}, nil
+}
+
+func (m *deletingAPI) ChannelsDeleteMessages(ctx context.Context, request *tg.ChannelsDeleteMessagesRequest) (*tg.MessagesAffectedMessages, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channelDeletes = append(m.channelDeletes, request)
+	if m.deleteErr != nil {
+		return nil, m.deleteErr
+	}
+	return &tg.MessagesAffectedMessages{}, nil
+}
+
+// waitDeletion waits for a scheduled deletion to be attempted
+func waitDeletion(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	if done == nil {
+		t.Fatal("Expected a deletion to be scheduled")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the deletion")
+	}
+}
+
+func TestChatAutoDelete(t *testing.T) {
+	settings := NewChatAutoDelete()
+	if enabled, _ := settings.Enabled(1); enabled {
+		t.Error("Expected auto-delete to be off by default")
+	}
+
+	settings.Set(1, true, true)
+	if enabled, channel := settings.Enabled(1); !enabled || !channel {
+		t.Errorf("Enabled(1) = %v, %v, want on in a channel", enabled, channel)
+	}
+	if enabled, _ := settings.Enabled(2); enabled {
+		t.Error("Expected auto-delete on for chat 1 only")
+	}
+
+	settings.Set(1, false, true)
+	if enabled, _ := settings.Enabled(1); enabled {
+		t.Error("Expected auto-delete off again")
+	}
+}
+
+func TestScheduleCommandDeletion_Gating(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.deleteDelay = 0
+	api := &deletingAPI{}
+
+	// Off by default
+	if done := handler.scheduleCommandDeletion(api, &CommandContext{ChatID: 1, MessageID: 42}); done != nil {
+		t.Error("Expected no deletion while auto-delete is off")
+	}
+
+	// Nothing to delete without the command message
+	handler.GetAutoDelete().Set(1, true, false)
+	if done := handler.scheduleCommandDeletion(api, &CommandContext{ChatID: 1}); done != nil {
+		t.Error("Expected no deletion without a message ID")
+	}
+
+	// APIs that cannot delete are skipped rather than failing the request
+	if done := handler.scheduleCommandDeletion(&mockReactionAPI{}, &CommandContext{ChatID: 1, MessageID: 42}); done != nil {
+		t.Error("Expected no deletion with an API that cannot delete")
+	}
+
+	if len(api.deletes)+len(api.channelDeletes) != 0 {
+		t.Errorf("Expected no deletions, got %d", len(api.deletes)+len(api.channelDeletes))
+	}
+}
+
+func TestSongHandler_UploadReplyTo(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.GetAutoDelete().Set(-100, true, false)
+
+	if got := handler.uploadReplyTo(&CommandContext{ChatID: -200, MessageID: 42}); got != 42 {
+		t.Errorf("Expected the audio to reply to the command, got %d", got)
+	}
+	if got := handler.uploadReplyTo(&CommandContext{ChatID: -100, MessageID: 42}); got != 0 {
+		t.Errorf("Expected no reply to a command that is deleted, got %d", got)
+	}
+}
+
+func TestScheduleCommandDeletion_Success(t *testing.T) {
+	handler := NewSongHandler(&TelegramBot{peers: NewPeerResolver()}, logging.Discard())
+	handler.deleteDelay = 10 * time.Millisecond
+	handler.GetAutoDelete().Set(1, true, false)
+	handler.GetAutoDelete().Set(-1000000000002, true, true)
+	handler.client.Peers().Remember(-1000000000002, 77)
+	api := &deletingAPI{}
+
+	start := time.Now()
+	waitDeletion(t, handler.scheduleCommandDeletion(api, &CommandContext{ChatID: 1, MessageID: 42}))
+	if elapsed := time.Since(start); elapsed < handler.deleteDelay {
+		t.Errorf("Expected the deletion to wait %v, it came after %v", handler.deleteDelay, elapsed)
+	}
+	if len(api.deletes) != 1 || !api.deletes[0].Revoke || len(api.deletes[0].ID) != 1 || api.deletes[0].ID[0] != 42 {
+		t.Errorf("Expected message 42 to be deleted for everyone, got %+v", api.deletes)
+	}
+
+	// Supergroups and channels need the channel call
+	waitDeletion(t, handler.scheduleCommandDeletion(api, &CommandContext{ChatID: -1000000000002, MessageID: 7}))
+	if len(api.channelDeletes) != 1 || len(api.channelDeletes[0].ID) != 1 || api.channelDeletes[0].ID[0] != 7 {
+		t.Fatalf("Expected message 7 to be deleted from the channel, got %+v", api.channelDeletes)
+	}
+	if channel, ok := api.channelDeletes[0].Channel.(*tg.InputChannel); !ok || channel.ChannelID != 2 || channel.AccessHash != 77 {
+		t.Errorf("Expected channel 2 with its access hash, got %+v", api.channelDeletes[0].Channel)
+	}
+	if len(api.deletes) != 1 {
+		t.Errorf("Expected the channel message not to use the basic group call, got %d calls", len(api.deletes))
+	}
+}
+
+func TestScheduleCommandDeletion_FailureTolerated(t *testing.T) {
+	for _, err := range []error{
+		tgerr.New(403, "MESSAGE_DELETE_FORBIDDEN"),
+		tgerr.New(400, "MESSAGE_ID_INVALID"),
+	} {
+		var logs bytes.Buffer
+		handler := NewSongHandler(nil, logging.New(&logs, logging.LevelDebug))
+		handler.deleteDelay = 0
+		handler.GetAutoDelete().Set(1, true, false)
+		api := &deletingAPI{deleteErr: err}
+
+		waitDeletion(t, handler.scheduleCommandDeletion(api, &CommandContext{ChatID: 1, MessageID: 42}))
+		if !strings.Contains(logs.String(), "WARN: Could not delete command message | Message: 42 | Chat: 1") {
+			t.Errorf("Expected the failure %v to be logged, got %q", err, logs.String())
+		}
+	}
+}
+
+func TestChatKind(t *testing.T) {
+	testCases := []struct {
+		name        string
+		peer        tg.PeerClass
+		wantGroup   bool
+		wantChannel bool
+	}{
+		{"private chat", &tg.PeerUser{UserID: 1}, false, false},
+		{"basic group", &tg.PeerChat{ChatID: 2}, true, false},
+		{"supergroup", &tg.PeerChannel{ChannelID: 3}, true, true},
+	}
+
+	for _, tc := range testCases {
+		cmdCtx := &CommandContext{Update: &tg.UpdateNewMessage{Message: &tg.Message{PeerID: tc.peer}}}
+		if group, channel := chatKind(cmdCtx); group != tc.wantGroup || channel != tc.wantChannel {
+			t.Errorf("%s: chatKind() = %v, %v, want %v, %v", tc.name, group, channel, tc.wantGroup, tc.wantChannel)
+		}
+	}
+
+	if group, _ := chatKind(&CommandContext{}); group {
+		t.Error("Expected requests without an update not to count as groups")
+	}
+}
+
+func TestParseAutoDeleteArgs(t *testing.T) {
+	testCases := []struct {
+		args        string
+		current     bool
+		wantEnabled bool
+		wantChanged bool
+		wantErr     bool
+	}{
+		{args: "", current: true, wantEnabled: true},
+		{args: "on", wantEnabled: true, wantChanged: true},
+		{args: " OFF ", current: true, wantChanged: true},
+		{args: "later", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		enabled, changed, err := parseAutoDeleteArgs(tc.args, tc.current)
+		if enabled != tc.wantEnabled || changed != tc.wantChanged || (err != nil) != tc.wantErr {
+			t.Errorf("parseAutoDeleteArgs(%q, %v) = %v, %v, %v", tc.args, tc.current, enabled, changed, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// AutoDeleteHandler implements CommandHandler for the /autodelete command,
+// which turns deletion of delivered /song messages on or off for a group
+type AutoDeleteHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewAutoDeleteHandler creates a new AutoDeleteHandler instance
+func NewAutoDeleteHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *AutoDeleteHandler {
+	handler := &AutoDeleteHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *AutoDeleteHandler) Command() string {
+	return "autodelete"
+}
+
+// Handle processes the /autodelete command, showing or changing the chat setting
+func (h *AutoDeleteHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /autodelete command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	group, channel := chatKind(cmdCtx)
+	if !group {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Auto-delete is only available in groups.")
+	}
+
+	settings := h.songHandler.GetAutoDelete()
+	current, _ := settings.Enabled(cmdCtx.ChatID)
+
+	enabled, changed, err := parseAutoDeleteArgs(cmdCtx.Args, current)
+	if err != nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error())
+	}
+
+	if changed {
+		settings.Set(cmdCtx.ChatID, enabled, channel)
+		h.logger.Info("Auto-delete turned "+onOff(enabled), logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createAutoDeleteMessage(enabled, changed))
+}
+
+// chatKind reports whether a command was sent in a group, and whether that
+// group is a channel or supergroup rather than a basic group
+func chatKind(cmdCtx *CommandContext) (group bool, channel bool) {
+	if cmdCtx.Update == nil {
+		return false, false
+	}
+	message, ok := cmdCtx.Update.Message.(*tg.Message)
+	if !ok {
+		return false, false
+	}
+
+	switch message.PeerID.(type) {
+	case *tg.PeerChat:
+		return true, false
+	case *tg.PeerChannel:
+		return true, true
+	}
+	return false, false
+}
+
+// parseAutoDeleteArgs parses "on" or "off". No argument keeps the current setting
+func parseAutoDeleteArgs(args string, current bool) (enabled bool, changed bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		return current, false, nil
+	case "on":
+		return true, true, nil
+	case "off":
+		return false, true, nil
+	default:
+		return current, false, fmt.Errorf("Usage: /autodelete on|off")
+	}
+}
+
+// createAutoDeleteMessage describes the auto-delete setting of a group
+func createAutoDeleteMessage(enabled, changed bool) string {
+	var message string
+	if changed {
+		message = fmt.Sprintf("✅ Auto-delete is now %s for this group.", onOff(enabled))
+	} else {
+		message = fmt.Sprintf("Auto-delete is %s for this group.", onOff(enabled))
+	}
+
+	if enabled {
+		return message + "\n\n/song messages are deleted shortly after their audio is delivered. The bot needs permission to delete messages; without it they are left as they are."
+	}
+	return message + "\n\nUse /autodelete on to delete /song messages once their audio is delivered."
+}
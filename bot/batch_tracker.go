@@ -0,0 +1,467 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const (
+	// BatchEditInterval is the least time between two edits of a batch summary
+	BatchEditInterval = 3 * time.Second
+
+	// MaxBatchSummaryLength is Telegram's message limit in UTF-16 units
+	MaxBatchSummaryLength = 4096
+
+	maxBatchLabelLength  = 48
+	maxBatchReasonLength = 60
+)
+
+// BatchItemState is how far a batch item got
+type BatchItemState int
+
+const (
+	BatchItemQueued BatchItemState = iota
+	BatchItemRunning
+	BatchItemDone
+	BatchItemFailed
+)
+
+// BatchItem is one URL of a batch and its current status
+type BatchItem struct {
+	URL        string
+	RequestID  string // Empty for URLs rejected before queueing
+	Title      string
+	State      BatchItemState
+	Percentage float64
+	Reason     string
+	Rejected   bool // Valid, but not queued, e.g. over the user's limit
+}
+
+// finished reports whether the item will not change anymore
+func (item *BatchItem) finished() bool {
+	return item.State == BatchItemDone || item.State == BatchItemFailed
+}
+
+// batch is a live summary message and the items it shows
+type batch struct {
+	chatID    int64
+	messageID int // 0 until the summary is sent
+	items     []*BatchItem
+	lastText  string
+	lastEdit  time.Time
+	scheduled bool
+}
+
+// BatchTracker keeps one live summary message per batch of /song URLs up to
+// date with the queue events of its items, editing it at most once per
+// interval. The summary is frozen once every item finished
+type BatchTracker struct {
+	mu       sync.Mutex
+	batches  map[string]*batch
+	edit     func(ctx context.Context, chatID int64, messageID int, message string) error
+	logger   logging.Logger
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewBatchTracker creates a tracker editing summaries with edit
+func NewBatchTracker(edit func(ctx context.Context, chatID int64, messageID int, message string) error, logger logging.Logger) *BatchTracker {
+	return &BatchTracker{
+		batches:  make(map[string]*batch),
+		edit:     edit,
+		logger:   logger,
+		interval: BatchEditInterval,
+		now:      time.Now,
+	}
+}
+
+// Track starts following batchID, whose summary is sent to chatID by Attach.
+// Call it before queueing the items so no event is missed
+func (bt *BatchTracker) Track(batchID string, chatID int64, items []BatchItem) {
+	b := &batch{chatID: chatID, lastEdit: bt.now()}
+	for i := range items {
+		item := items[i]
+		b.items = append(b.items, &item)
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	bt.batches[batchID] = b
+}
+
+// Attach sends the summary of batchID with send once every item was queued
+// or rejected, and keeps the sent message up to date from then on. A batch
+// whose summary could not be sent is forgotten
+func (bt *BatchTracker) Attach(batchID string, send func(summary string) (int, error)) error {
+	bt.mu.Lock()
+	b, ok := bt.batches[batchID]
+	if !ok {
+		bt.mu.Unlock()
+		return nil
+	}
+	text := renderBatchSummary(b.snapshot())
+	b.lastText, b.lastEdit = text, bt.now()
+	bt.mu.Unlock()
+
+	messageID, err := send(text)
+
+	bt.mu.Lock()
+	if err != nil {
+		delete(bt.batches, batchID)
+		bt.mu.Unlock()
+		return err
+	}
+	b.messageID = messageID
+	bt.mu.Unlock()
+
+	// Catch up with the events seen while sending
+	bt.changed(batchID)
+	return nil
+}
+
+// Reject marks the item of requestID as not queued for reason
+func (bt *BatchTracker) Reject(batchID, requestID, reason string) {
+	bt.mu.Lock()
+	b, ok := bt.batches[batchID]
+	if !ok {
+		bt.mu.Unlock()
+		return
+	}
+	for _, item := range b.items {
+		if item.RequestID == requestID {
+			item.State, item.Reason, item.Rejected = BatchItemFailed, reason, true
+		}
+	}
+	bt.mu.Unlock()
+
+	bt.changed(batchID)
+}
+
+// OnQueueEvent updates the item of event and schedules a summary edit
+func (bt *BatchTracker) OnQueueEvent(event QueueEvent) {
+	if event.BatchID == "" {
+		return
+	}
+
+	bt.mu.Lock()
+	b, ok := bt.batches[event.BatchID]
+	if !ok {
+		bt.mu.Unlock()
+		return
+	}
+
+	var item *BatchItem
+	for _, candidate := range b.items {
+		if candidate.RequestID == event.RequestID {
+			item = candidate
+			break
+		}
+	}
+	if item == nil {
+		bt.mu.Unlock()
+		return
+	}
+
+	if event.Title != "" {
+		item.Title = event.Title
+	}
+	switch event.Kind {
+	case QueueEventQueued:
+		// Items start out queued, and a worker may pick the request up
+		// before it is reported queued. Retried items wait again
+		if event.Attempt > 1 {
+			item.State, item.Percentage = BatchItemQueued, 0
+		}
+	case QueueEventStarted:
+		item.State, item.Percentage, item.Reason = BatchItemRunning, 0, ""
+	case QueueEventProgress:
+		item.State, item.Percentage = BatchItemRunning, event.Percentage
+	case QueueEventCompleted:
+		item.State = BatchItemDone
+	case QueueEventFailed:
+		item.State, item.Reason = BatchItemFailed, event.Reason
+	}
+	bt.mu.Unlock()
+
+	bt.changed(event.BatchID)
+}
+
+// changed edits the summary of batchID now when the last edit is at least an
+// interval old or every item finished, and otherwise once the interval is over
+func (bt *BatchTracker) changed(batchID string) {
+	bt.mu.Lock()
+	b, ok := bt.batches[batchID]
+	if !ok || b.scheduled {
+		bt.mu.Unlock()
+		return
+	}
+
+	final := true
+	for _, item := range b.items {
+		if !item.finished() {
+			final = false
+			break
+		}
+	}
+
+	if wait := b.lastEdit.Add(bt.interval).Sub(bt.now()); !final && wait > 0 {
+		b.scheduled = true
+		bt.mu.Unlock()
+		time.AfterFunc(wait, func() { bt.flush(batchID) })
+		return
+	}
+	bt.mu.Unlock()
+
+	bt.flush(batchID)
+}
+
+// flush renders and edits the summary of batchID, freezing it when every item
+// finished
+func (bt *BatchTracker) flush(batchID string) {
+	bt.mu.Lock()
+	b, ok := bt.batches[batchID]
+	if !ok {
+		bt.mu.Unlock()
+		return
+	}
+	b.scheduled = false
+	if b.messageID == 0 {
+		// Attach sends the summary as it is then
+		bt.mu.Unlock()
+		return
+	}
+
+	items, final := b.snapshot()
+	if final {
+		delete(bt.batches, batchID)
+	}
+
+	// Telegram rejects edits that change nothing
+	text := renderBatchSummary(items, final)
+	if text == b.lastText {
+		bt.mu.Unlock()
+		return
+	}
+	b.lastText = text
+	b.lastEdit = bt.now()
+	chatID, messageID := b.chatID, b.messageID
+	bt.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bt.edit(ctx, chatID, messageID, text); err != nil {
+		bt.logger.Warn("Failed to update batch summary", logging.Err(err))
+	}
+}
+
+// snapshot copies the items of the batch, and reports whether every item
+// finished (must be called with the tracker's lock held)
+func (b *batch) snapshot() ([]BatchItem, bool) {
+	items := make([]BatchItem, len(b.items))
+	final := true
+	for i, item := range b.items {
+		items[i] = *item
+		final = final && item.finished()
+	}
+	return items, final
+}
+
+// renderBatchSummary renders the summary of a batch. Long batches collapse
+// their finished successes first, then leave out the last items
+func renderBatchSummary(items []BatchItem, final bool) string {
+	done, failed, invalid, rejected := 0, 0, 0, 0
+	for _, item := range items {
+		switch item.State {
+		case BatchItemDone:
+			done++
+		case BatchItemFailed:
+			failed++
+		}
+		switch {
+		case item.RequestID == "":
+			invalid++
+		case item.Rejected:
+			rejected++
+		}
+	}
+
+	var header string
+	if final {
+		header = fmt.Sprintf("📦 Batch of %d songs finished: %d done, %d failed", len(items), done, failed)
+	} else {
+		header = fmt.Sprintf("📦 Batch of %d songs: %d done, %d failed, %d to go", len(items), done, failed, len(items)-done-failed)
+	}
+	if invalid > 0 || rejected > 0 {
+		header += "\n" + batchAdmission(len(items)-invalid-rejected, invalid, rejected)
+	}
+
+	lines := make([]string, len(items))
+	for i := range items {
+		lines[i] = renderBatchItem(&items[i])
+	}
+	if text := header + "\n\n" + strings.Join(lines, "\n"); utf16Len(text) <= MaxBatchSummaryLength {
+		return text
+	}
+
+	var kept []string
+	for i := range items {
+		if items[i].State != BatchItemDone {
+			kept = append(kept, lines[i])
+		}
+	}
+	var footer []string
+	if done > 0 {
+		footer = append(footer, fmt.Sprintf("✅ %d more done", done))
+	}
+
+	omitted := 0
+	for {
+		tail := footer
+		if omitted > 0 {
+			tail = append([]string{fmt.Sprintf("… %d more", omitted)}, footer...)
+		}
+		text := header + "\n\n" + strings.Join(append(kept[:len(kept):len(kept)], tail...), "\n")
+		if utf16Len(text) <= MaxBatchSummaryLength || len(kept) == 0 {
+			return text
+		}
+		kept = kept[:len(kept)-1]
+		omitted++
+	}
+}
+
+// batchAdmission tells how many links of a batch were queued, how many were
+// not valid and how many the queue turned away, e.g. "✅ Queued 3 songs, ❌ 1
+// invalid link"
+func batchAdmission(queued, invalid, rejected int) string {
+	admission := fmt.Sprintf("✅ Queued %d %s", queued, pluralize(queued, "song", "songs"))
+	if invalid > 0 {
+		admission += fmt.Sprintf(", ❌ %d invalid %s", invalid, pluralize(invalid, "link", "links"))
+	}
+	if rejected > 0 {
+		admission += fmt.Sprintf(", ⚠️ %d not queued", rejected)
+	}
+	return admission
+}
+
+// renderBatchItem renders the status line of one item
+func renderBatchItem(item *BatchItem) string {
+	label := item.Title
+	if label == "" {
+		label = shortBatchURL(item.URL)
+	}
+	label = downloader.TruncateUTF16(label, maxBatchLabelLength)
+
+	switch item.State {
+	case BatchItemRunning:
+		return fmt.Sprintf("⬇️ %.0f%% %s", item.Percentage, label)
+	case BatchItemDone:
+		return "✅ " + label
+	case BatchItemFailed:
+		return fmt.Sprintf("❌ %s: %s", label, downloader.TruncateUTF16(item.Reason, maxBatchReasonLength))
+	default:
+		return "⏳ " + label
+	}
+}
+
+// shortBatchURL drops the scheme and host of an Apple Music URL
+func shortBatchURL(url string) string {
+	url = strings.TrimPrefix(url, "https://")
+	url = strings.TrimPrefix(url, "http://")
+	return strings.TrimPrefix(url, "music.apple.com/")
+}
+
+// addBatch queues every URL of a /song message with several of them and
+// sends one summary message for the batch instead of one reply per URL
+func (h *SongHandler) addBatch(ctx context.Context, cmdCtx *CommandContext, urls []string) error {
+	batchID := GenerateUniqueID(cmdCtx.UserID, cmdCtx.ChatID, cmdCtx.MessageID)
+	hints := h.storefrontHints(cmdCtx)
+
+	items := make([]BatchItem, len(urls))
+	final := true
+	for i, songURL := range urls {
+		items[i] = BatchItem{URL: songURL}
+
+		urlMeta := ExtractURLMetaWithHints(songURL, hints)
+		if urlMeta == nil {
+			items[i].State, items[i].Reason = BatchItemFailed, "not a valid Apple Music URL"
+			if IsLibraryURL(songURL) {
+				items[i].Reason = ErrLibraryURL.Error()
+			}
+			continue
+		}
+		if urlMeta.StorefrontInferred {
+			items[i].URL = WithStorefront(songURL, urlMeta.Storefront)
+		}
+		items[i].RequestID = BatchRequestID(batchID, i)
+		final = false
+	}
+
+	h.logger.Info("Received batch", logging.String("Batch", batchID), logging.Int("URLs", len(urls)), logging.Int64("User", cmdCtx.UserID))
+
+	// Track before queueing so events of items that start right away are seen
+	if !final {
+		h.batches.Track(batchID, cmdCtx.ChatID, items)
+	}
+
+	for i, item := range items {
+		if item.RequestID == "" {
+			continue
+		}
+		if _, err := h.queue.AddBatchRequest(batchID, i, cmdCtx.UserID, cmdCtx.ChatID, cmdCtx.MessageID, item.URL, cmdCtx.MessageText, cmdCtx.Entities); err != nil {
+			reason := err.Error()
+			var limitErr *UserLimitError
+			var duplicateErr *DuplicateRequestError
+			switch {
+			case errors.As(err, &limitErr):
+				reason = fmt.Sprintf("you already have %d songs queued", limitErr.Pending)
+			case errors.As(err, &duplicateErr):
+				reason = "already queued"
+			case strings.Contains(reason, "queue is full"):
+				reason = "queue is full"
+			}
+			h.batches.Reject(batchID, item.RequestID, reason)
+		}
+	}
+
+	// The summary is sent once it is known which songs were queued
+	send := func(summary string) (int, error) {
+		return h.sendMessageWithID(ctx, cmdCtx.ChatID, summary)
+	}
+	var err error
+	if final {
+		_, err = send(renderBatchSummary(items, true))
+	} else {
+		err = h.batches.Attach(batchID, send)
+	}
+	if err != nil {
+		h.logger.Error("Failed to send batch summary", logging.Err(err))
+	}
+	return err
+}
+
+// sendMessageWithID sends a markdown message to the specified chat and
+// returns its ID
+func (h *SongHandler) sendMessageWithID(ctx context.Context, chatID int64, message string) (int, error) {
+	sender := h.messages()
+	if sender == nil {
+		return 0, errClientNotInitialized
+	}
+
+	updates, err := sender.send(ctx, chatID, 0, message)
+	if err != nil {
+		return 0, err
+	}
+
+	if messageID := downloader.SentMessageID(updates); messageID != 0 {
+		return messageID, nil
+	}
+	return 0, fmt.Errorf("sent message has no ID")
+}
@@ -0,0 +1,311 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// summaryAPI records the batch summary sent as message 500 and its edits
+type summaryAPI struct {
+	mu    sync.Mutex
+	sent  []string
+	edits []string
+}
+
+func (a *summaryAPI) MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = append(a.sent, request.Message)
+	return &tg.UpdateShortSentMessage{ID: 500}, nil
+}
+
+func (a *summaryAPI) MessagesEditMessage(ctx context.Context, request *tg.MessagesEditMessageRequest) (tg.UpdatesClass, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if request.ID == 500 {
+		a.edits = append(a.edits, request.Message)
+	}
+	return &tg.Updates{}, nil
+}
+
+func (a *summaryAPI) MessagesSendReaction(ctx context.Context, request *tg.MessagesSendReactionRequest) (tg.UpdatesClass, error) {
+	return &tg.Updates{}, nil
+}
+
+func (a *summaryAPI) snapshot() ([]string, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.sent...), append([]string(nil), a.edits...)
+}
+
+func TestSongHandler_BatchSummary(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+	api := &summaryAPI{}
+	handler.api = api
+	handler.batches.interval = 0
+
+	queue := handler.GetQueue()
+	queue.requestDelay = 0
+	queue.SetUserLimit(0)
+	handled := make(chan struct{})
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		// The summary is sent once every song is queued
+		<-handled
+		progress := func(title string, percentage float64) {
+			queue.Notify(QueueEvent{Kind: QueueEventProgress, RequestID: cmdCtx.RequestID, BatchID: cmdCtx.BatchID, Title: title, Percentage: percentage})
+		}
+		switch {
+		case strings.Contains(cmdCtx.Args, "/1"):
+			progress("Song A", 42)
+			return nil
+		case strings.Contains(cmdCtx.Args, "/2"):
+			return errors.New("song not available")
+		default:
+			progress("Song C", 100)
+			return nil
+		}
+	}
+
+	err := handler.Handle(context.Background(), &CommandContext{
+		UserID:    1,
+		ChatID:    100,
+		MessageID: 7,
+		Args:      "https://music.apple.com/us/song/a/1 https://music.apple.com/us/song/b/2\nhttps://music.apple.com/us/song/c/3",
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	close(handled)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, edits := api.snapshot(); len(edits) > 0 && strings.Contains(edits[len(edits)-1], "finished") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the batch to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sent, edits := api.snapshot()
+	if len(sent) != 1 {
+		t.Fatalf("Expected a single summary message instead of per-URL replies, got %q", sent)
+	}
+	if want := "📦 Batch of 3 songs: 0 done, 0 failed, 3 to go\n\n⏳ us/song/a/1\n⏳ us/song/b/2\n⏳ us/song/c/3"; sent[0] != want {
+		t.Errorf("Initial summary = %q, want %q", sent[0], want)
+	}
+
+	want := []string{
+		"📦 Batch of 3 songs: 0 done, 0 failed, 3 to go\n\n⬇️ 0% us/song/a/1\n⏳ us/song/b/2\n⏳ us/song/c/3",
+		"📦 Batch of 3 songs: 0 done, 0 failed, 3 to go\n\n⬇️ 42% Song A\n⏳ us/song/b/2\n⏳ us/song/c/3",
+		"📦 Batch of 3 songs: 1 done, 0 failed, 2 to go\n\n✅ Song A\n⏳ us/song/b/2\n⏳ us/song/c/3",
+		"📦 Batch of 3 songs: 1 done, 0 failed, 2 to go\n\n✅ Song A\n⬇️ 0% us/song/b/2\n⏳ us/song/c/3",
+		"📦 Batch of 3 songs: 1 done, 1 failed, 1 to go\n\n✅ Song A\n❌ us/song/b/2: song not available\n⏳ us/song/c/3",
+		"📦 Batch of 3 songs: 1 done, 1 failed, 1 to go\n\n✅ Song A\n❌ us/song/b/2: song not available\n⬇️ 0% us/song/c/3",
+		"📦 Batch of 3 songs: 1 done, 1 failed, 1 to go\n\n✅ Song A\n❌ us/song/b/2: song not available\n⬇️ 100% Song C",
+		"📦 Batch of 3 songs finished: 2 done, 1 failed\n\n✅ Song A\n❌ us/song/b/2: song not available\n✅ Song C",
+	}
+	if len(edits) != len(want) {
+		t.Fatalf("Expected %d summary states, got %d:\n%s", len(want), len(edits), strings.Join(edits, "\n---\n"))
+	}
+	for i := range want {
+		if edits[i] != want[i] {
+			t.Errorf("Summary state %d = %q, want %q", i, edits[i], want[i])
+		}
+	}
+
+	// The final state is frozen
+	queue.Notify(QueueEvent{Kind: QueueEventStarted, RequestID: BatchRequestID(GenerateUniqueID(1, 100, 7), 0), BatchID: GenerateUniqueID(1, 100, 7)})
+	if _, after := api.snapshot(); len(after) != len(want) {
+		t.Errorf("Expected no edits after the batch finished, got %q", after[len(want):])
+	}
+}
+
+func TestSongHandler_BatchRejectsInvalidURLs(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+	api := &summaryAPI{}
+	handler.api = api
+
+	err := handler.Handle(context.Background(), &CommandContext{
+		UserID:    1,
+		ChatID:    100,
+		MessageID: 8,
+		Args:      "https://example.com/a https://example.com/b",
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	sent, _ := api.snapshot()
+	want := "📦 Batch of 2 songs finished: 0 done, 2 failed\n✅ Queued 0 songs, ❌ 2 invalid links\n\n❌ example.com/a: not a valid Apple Music URL\n❌ example.com/b: not a valid Apple Music URL"
+	if len(sent) != 1 || sent[0] != want {
+		t.Errorf("Expected the final summary right away, got %q", sent)
+	}
+	if size := handler.GetQueue().GetQueueSize(); size != 0 {
+		t.Errorf("Expected nothing queued, got %d", size)
+	}
+}
+
+func TestSongHandler_BatchMixedURLs(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &summaryAPI{}
+	handler.api = api
+	queue := handler.GetQueue()
+	queue.workers = 0
+	queue.SetUserLimit(0)
+
+	err := handler.Handle(context.Background(), &CommandContext{
+		UserID:    1,
+		ChatID:    100,
+		MessageID: 9,
+		Args:      "https://music.apple.com/us/song/a/1\nnot-a-link https://music.apple.com/us/song/b/2\n\thttps://music.apple.com/us/song/c/3",
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	sent, _ := api.snapshot()
+	want := "📦 Batch of 4 songs: 0 done, 1 failed, 3 to go\n✅ Queued 3 songs, ❌ 1 invalid link\n\n" +
+		"⏳ us/song/a/1\n❌ not-a-link: not a valid Apple Music URL\n⏳ us/song/b/2\n⏳ us/song/c/3"
+	if len(sent) != 1 || sent[0] != want {
+		t.Errorf("Summary = %q, want %q", sent, want)
+	}
+
+	// Every valid link is its own request, told apart by its index in the message
+	batchID := GenerateUniqueID(1, 100, 9)
+	queued := queue.GetRequestsBySender(100, 1).Queued
+	if len(queued) != 3 {
+		t.Fatalf("Expected 3 queued requests, got %d", len(queued))
+	}
+	for i, index := range []int{0, 2, 3} {
+		request := queued[i].Request
+		if request.UniqueID != BatchRequestID(batchID, index) || request.BatchID != batchID || request.MessageID != 9 {
+			t.Errorf("Request %d: ID %q in batch %q, want %q", i, request.UniqueID, request.BatchID, BatchRequestID(batchID, index))
+		}
+	}
+}
+
+func TestSongHandler_BatchCountsRejectedSongs(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &summaryAPI{}
+	handler.api = api
+	queue := handler.GetQueue()
+	queue.workers = 0
+	queue.SetUserLimit(2)
+
+	if _, err := queue.AddRequest(1, 100, 3, "https://music.apple.com/us/song/c/3"); err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+
+	err := handler.Handle(context.Background(), &CommandContext{
+		UserID:    1,
+		ChatID:    100,
+		MessageID: 9,
+		Args:      "https://music.apple.com/us/song/a/1 https://music.apple.com/us/song/c/3 https://music.apple.com/us/song/b/2 not-a-link",
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	sent, _ := api.snapshot()
+	want := "📦 Batch of 4 songs: 0 done, 3 failed, 1 to go\n✅ Queued 1 song, ❌ 1 invalid link, ⚠️ 2 not queued\n\n" +
+		"⏳ us/song/a/1\n❌ us/song/c/3: already queued\n❌ us/song/b/2: you already have 2 songs queued\n❌ not-a-link: not a valid Apple Music URL"
+	if len(sent) != 1 || sent[0] != want {
+		t.Errorf("Summary = %q, want %q", sent, want)
+	}
+}
+
+func TestBatchRequestID_Unique(t *testing.T) {
+	seen := map[string]bool{GenerateUniqueID(1, 100, 9): true, GenerateUniqueID(1, 100, 90): true}
+	for _, batchID := range []string{GenerateUniqueID(1, 100, 9), GenerateUniqueID(1, 100, 90)} {
+		for index := 0; index < 12; index++ {
+			id := BatchRequestID(batchID, index)
+			if seen[id] {
+				t.Errorf("Request ID %q is not unique", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestBatchTracker_ThrottlesEdits(t *testing.T) {
+	var mu sync.Mutex
+	var edits []string
+	tracker := NewBatchTracker(func(ctx context.Context, chatID int64, messageID int, message string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		edits = append(edits, message)
+		return nil
+	}, logging.New(os.Stdout, logging.LevelDebug))
+	tracker.interval = 50 * time.Millisecond
+
+	tracker.Track("b", 100, []BatchItem{{URL: "https://music.apple.com/us/song/a/1", RequestID: "b:0"}})
+	if err := tracker.Attach("b", func(string) (int, error) { return 500, nil }); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	tracker.OnQueueEvent(QueueEvent{Kind: QueueEventStarted, RequestID: "b:0", BatchID: "b"})
+	tracker.OnQueueEvent(QueueEvent{Kind: QueueEventProgress, RequestID: "b:0", BatchID: "b", Percentage: 10})
+	tracker.OnQueueEvent(QueueEvent{Kind: QueueEventProgress, RequestID: "b:0", BatchID: "b", Title: "Song A", Percentage: 20})
+
+	mu.Lock()
+	if len(edits) != 0 {
+		t.Errorf("Expected no edit within the interval, got %q", edits)
+	}
+	mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	tracker.OnQueueEvent(QueueEvent{Kind: QueueEventCompleted, RequestID: "b:0", BatchID: "b"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"📦 Batch of 1 songs: 0 done, 0 failed, 1 to go\n\n⬇️ 20% Song A",
+		"📦 Batch of 1 songs finished: 1 done, 0 failed\n\n✅ Song A",
+	}
+	if len(edits) != len(want) || edits[0] != want[0] || edits[1] != want[1] {
+		t.Errorf("Expected one coalesced edit and the final state, got %q", edits)
+	}
+}
+
+func TestRenderBatchSummary_CollapsesLongBatches(t *testing.T) {
+	var items []BatchItem
+	for i := 0; i < 200; i++ {
+		state := BatchItemDone
+		if i%4 == 0 {
+			state = BatchItemQueued
+		}
+		items = append(items, BatchItem{Title: strings.Repeat("Long Song Title ", 3), State: state})
+	}
+
+	summary := renderBatchSummary(items, false)
+	if utf16Len(summary) > MaxBatchSummaryLength {
+		t.Fatalf("Summary is %d units long", utf16Len(summary))
+	}
+	if !strings.Contains(summary, "✅ 150 more done") {
+		t.Errorf("Expected finished songs to be collapsed, got %q", summary[len(summary)-100:])
+	}
+	if !strings.HasPrefix(summary, "📦 Batch of 200 songs: 150 done, 0 failed, 50 to go") {
+		t.Errorf("Expected the full counts in the header, got %q", summary[:80])
+	}
+
+	for i := range items {
+		items[i].State = BatchItemFailed
+		items[i].Reason = strings.Repeat("x", 100)
+	}
+	summary = renderBatchSummary(items, true)
+	if utf16Len(summary) > MaxBatchSummaryLength || !strings.Contains(summary, " more") {
+		t.Errorf("Expected the failures to be cut with a count, got %d units", utf16Len(summary))
+	}
+}
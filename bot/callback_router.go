@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// MaxCallbackDataLength is the most bytes Telegram allows in button data
+const MaxCallbackDataLength = 64
+
+// CallbackHandler defines the interface for handling inline button presses
+type CallbackHandler interface {
+	// HandleCallback processes a button press and returns the short notice
+	// shown to the user who pressed it (may be empty)
+	HandleCallback(ctx context.Context, cbCtx *CallbackContext) (string, error)
+	// CallbackPrefix returns the data prefix of the buttons this handler
+	// processes; button data is "<prefix>:<data>"
+	CallbackPrefix() string
+}
+
+// CallbackContext provides context information for button press processing
+type CallbackContext struct {
+	// Update contains the original Telegram update
+	Update *tg.UpdateBotCallbackQuery
+	// QueryID is the ID the press must be answered with
+	QueryID int64
+	// UserID is the ID of the user who pressed the button
+	UserID int64
+	// ChatID is the ID of the chat the button's message is in
+	ChatID int64
+	// MessageID is the ID of the message the button belongs to
+	MessageID int
+	// Data is the button data after the handler's prefix and colon
+	Data string
+}
+
+// CallbackData builds the data of a button handled by the handler with prefix
+func CallbackData(prefix, data string) []byte {
+	return []byte(prefix + ":" + data)
+}
+
+// CallbackRouter handles routing of button presses to their respective handlers
+type CallbackRouter struct {
+	handlers map[string]CallbackHandler
+	logger   logging.Logger
+}
+
+// NewCallbackRouter creates a new callback router instance
+func NewCallbackRouter(logger logging.Logger) *CallbackRouter {
+	return &CallbackRouter{
+		handlers: make(map[string]CallbackHandler),
+		logger:   logger,
+	}
+}
+
+// RegisterHandler registers a callback handler for its data prefix
+func (r *CallbackRouter) RegisterHandler(handler CallbackHandler) {
+	prefix := handler.CallbackPrefix()
+	r.handlers[prefix] = handler
+	r.logger.Debug("Registered callback handler", logging.String("Prefix", prefix))
+}
+
+// RouteCallback routes a button press to the handler of its data prefix and
+// returns the notice to answer it with
+func (r *CallbackRouter) RouteCallback(ctx context.Context, update *tg.UpdateBotCallbackQuery) (string, error) {
+	prefix, data, ok := strings.Cut(string(update.Data), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed callback data %q", update.Data)
+	}
+
+	handler, exists := r.handlers[prefix]
+	if !exists {
+		r.logger.Debug("No handler found for callback", logging.String("Prefix", prefix))
+		return "", nil
+	}
+
+	cbCtx := &CallbackContext{
+		Update:    update,
+		QueryID:   update.QueryID,
+		UserID:    update.UserID,
+		ChatID:    ChatIDFromPeer(update.Peer),
+		MessageID: update.MsgID,
+		Data:      data,
+	}
+
+	r.logger.Info("Routing callback", logging.String("Prefix", prefix), logging.Int64("User", cbCtx.UserID), logging.Int64("Chat", cbCtx.ChatID))
+	return handler.HandleCallback(ctx, cbCtx)
+}
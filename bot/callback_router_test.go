@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// mockCallbackHandler records the button presses it is given
+type mockCallbackHandler struct {
+	prefix  string
+	calls   int
+	lastCtx *CallbackContext
+}
+
+func (m *mockCallbackHandler) HandleCallback(ctx context.Context, cbCtx *CallbackContext) (string, error) {
+	m.calls++
+	m.lastCtx = cbCtx
+	return "ok", nil
+}
+
+func (m *mockCallbackHandler) CallbackPrefix() string {
+	return m.prefix
+}
+
+func TestCallbackRouter_RouteCallback(t *testing.T) {
+	router := NewCallbackRouter(logging.Discard())
+	handler := &mockCallbackHandler{prefix: "ovr"}
+	router.RegisterHandler(handler)
+
+	notice, err := router.RouteCallback(context.Background(), &tg.UpdateBotCallbackQuery{
+		QueryID: 9,
+		UserID:  1,
+		Peer:    &tg.PeerUser{UserID: 100},
+		MsgID:   42,
+		Data:    CallbackData("ovr", "token:gb"),
+	})
+	if err != nil || notice != "ok" {
+		t.Fatalf("RouteCallback() = %q, %v", notice, err)
+	}
+
+	want := CallbackContext{QueryID: 9, UserID: 1, ChatID: 100, MessageID: 42, Data: "token:gb"}
+	got := *handler.lastCtx
+	got.Update = nil
+	if got != want {
+		t.Errorf("Callback context = %+v, want %+v", got, want)
+	}
+
+	// Presses in channels report the chat ID commands see
+	if _, err := router.RouteCallback(context.Background(), &tg.UpdateBotCallbackQuery{
+		Peer: &tg.PeerChannel{ChannelID: 1234},
+		Data: CallbackData("ovr", "token:gb"),
+	}); err != nil {
+		t.Fatalf("RouteCallback() error = %v", err)
+	}
+	if got := handler.lastCtx.ChatID; got != -1000000001234 {
+		t.Errorf("Expected channel chat ID -1000000001234, got %d", got)
+	}
+
+	// Unknown prefixes are ignored, data without a prefix is an error
+	if _, err := router.RouteCallback(context.Background(), &tg.UpdateBotCallbackQuery{Data: []byte("other:x")}); err != nil {
+		t.Errorf("Expected unknown prefix to be ignored, got %v", err)
+	}
+	if _, err := router.RouteCallback(context.Background(), &tg.UpdateBotCallbackQuery{Data: []byte("garbage")}); err == nil {
+		t.Error("Expected malformed data to be rejected")
+	}
+	if handler.calls != 2 {
+		t.Errorf("Expected 2 routed presses, got %d", handler.calls)
+	}
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// CancelHandler implements CommandHandler for the /cancel command
+type CancelHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewCancelHandler creates a new CancelHandler instance
+func NewCancelHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *CancelHandler {
+	handler := &CancelHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *CancelHandler) Command() string {
+	return "cancel"
+}
+
+// Handle processes the /cancel command and stops the caller's downloads
+func (h *CancelHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /cancel command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	message, err := h.cancel(cmdCtx)
+	if err != nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error())
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, message)
+}
+
+// cancel removes the queued requests of the caller, or of the user given as
+// argument by an admin, and stops those being processed. The returned error
+// is meant for the user
+func (h *CancelHandler) cancel(cmdCtx *CommandContext) (string, error) {
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return "", fmt.Errorf("Queue system is not available.")
+	}
+
+	userID := cmdCtx.UserID
+	if arg := strings.TrimSpace(cmdCtx.Args); arg != "" {
+		if h.client == nil || !h.client.IsAdmin(cmdCtx.UserID) {
+			return "", fmt.Errorf("Only administrators can cancel other users' downloads. Use /cancel to cancel your own.")
+		}
+		parsed, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("Usage: /cancel or /cancel <user ID>")
+		}
+		userID = parsed
+	}
+
+	removed := queue.RemoveByUser(userID)
+	stopped := queue.CancelProcessing(userID)
+
+	h.logger.Info("Cancelled requests", logging.Int64("User", cmdCtx.UserID), logging.Int64("Owner", userID),
+		logging.Int("Queued", len(removed)), logging.Int("Processing", stopped))
+
+	if len(removed) == 0 && stopped == 0 {
+		if userID != cmdCtx.UserID {
+			return fmt.Sprintf("User %d has no queued or running downloads.", userID), nil
+		}
+		return "You have no queued or running downloads.", nil
+	}
+
+	var parts []string
+	if stopped > 0 {
+		parts = append(parts, fmt.Sprintf("stopped %d running %s", stopped, pluralize(stopped, "download", "downloads")))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d queued %s", len(removed), pluralize(len(removed), "request", "requests")))
+	}
+	return "🛑 Cancelled: " + strings.Join(parts, ", ") + ".", nil
+}
+
+// pluralize returns singular for a count of one, plural otherwise
+func pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+// newCancelTestHandler creates a cancel handler whose queue processes one
+// request at a time until its context is cancelled
+func newCancelTestHandler(t *testing.T) (*CancelHandler, *SongQueue) {
+	bot, songHandler, queue := newBlockingSongHandler(t)
+	return NewCancelHandler(bot, logging.Discard(), songHandler), queue
+}
+
+func TestCancelHandler_Command(t *testing.T) {
+	handler, _ := newCancelTestHandler(t)
+	if got := handler.Command(); got != "cancel" {
+		t.Errorf("CancelHandler.Command() = %v, want cancel", got)
+	}
+}
+
+func TestCancelHandler_CancelsOwnRequests(t *testing.T) {
+	handler, queue := newCancelTestHandler(t)
+	defer queue.CancelProcessing(2)
+
+	add := func(senderID int64, messageID int, url string) {
+		if _, err := queue.AddRequest(senderID, 100, messageID, url); err != nil {
+			t.Fatalf("Failed to add request %d: %v", messageID, err)
+		}
+	}
+	add(1, 1, "https://music.apple.com/in/song/first/1")
+	waitFor(t, "the first request to start", queue.IsProcessing)
+	add(1, 2, "https://music.apple.com/in/song/second/2")
+	add(2, 3, "https://music.apple.com/in/song/third/3")
+
+	message, err := handler.cancel(&CommandContext{UserID: 1, ChatID: 100})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := "🛑 Cancelled: stopped 1 running download, removed 1 queued request."; message != want {
+		t.Errorf("cancel() = %q, want %q", message, want)
+	}
+
+	// The running request ends as cancelled, not failed, and the other user's
+	// request is processed next
+	waitFor(t, "the other user's request to start", func() bool {
+		current := queue.GetCurrentlyProcessing()
+		return current != nil && current.SenderID == 2
+	})
+	finished := queue.GetRequestsBySender(100, 1).Finished
+	if len(finished) != 1 || finished[0].Status != StatusCancelled {
+		t.Errorf("Expected the running request to finish as cancelled, got %+v", finished)
+	}
+	if failed := queue.Failed().List(100, 1); len(failed) != 0 {
+		t.Errorf("Expected cancelled requests not to be listed as failed, got %d", len(failed))
+	}
+	if queue.GetUserQueueCount(1) != 0 {
+		t.Errorf("Expected no requests left for user 1, got %d", queue.GetUserQueueCount(1))
+	}
+
+	if message, _ := handler.cancel(&CommandContext{UserID: 1, ChatID: 100}); message != "You have no queued or running downloads." {
+		t.Errorf("Unexpected message with nothing to cancel: %q", message)
+	}
+}
+
+func TestCancelHandler_OtherUsers(t *testing.T) {
+	handler, queue := newCancelTestHandler(t)
+	defer queue.CancelProcessing(2)
+
+	if _, err := queue.AddRequest(2, 100, 1, "https://music.apple.com/in/song/first/1"); err != nil {
+		t.Fatalf("Failed to add request: %v", err)
+	}
+	waitFor(t, "the request to start", queue.IsProcessing)
+
+	_, err := handler.cancel(&CommandContext{UserID: 1, ChatID: 100, Args: "2"})
+	if err == nil || !strings.Contains(err.Error(), "Only administrators") {
+		t.Fatalf("Expected non-admins to be refused, got %v", err)
+	}
+	if queue.GetUserQueueCount(2) != 1 {
+		t.Fatal("Expected the other user's request to be left alone")
+	}
+
+	message, err := handler.cancel(&CommandContext{UserID: 99, ChatID: 100, Args: "2"})
+	if err != nil || !strings.Contains(message, "stopped 1 running download") {
+		t.Fatalf("Expected an admin to cancel the request, got %q, %v", message, err)
+	}
+	waitFor(t, "the request to stop", func() bool { return queue.GetUserQueueCount(2) == 0 })
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go-alac-bot/downloader"
+)
+
+// DefaultUILanguage is used for messages when neither the chat nor the
+// requester has a supported language
+const DefaultUILanguage = "en"
+
+// languageTagRegex matches BCP 47 style tags such as "ja", "en-US" or
+// "zh-Hant-TW"
+var languageTagRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// ChatLanguage holds the two languages of a chat, which are set separately:
+// messages can be in English while tags are in Japanese, or the other way
+// around. Empty fields fall back to the defaults
+type ChatLanguage struct {
+	UILanguage       string `json:"ui_language,omitempty"`       // Language of the bot's messages
+	MetadataLanguage string `json:"metadata_language,omitempty"` // Catalog language of the tags
+}
+
+// ChatLanguages holds per-chat language settings
+type ChatLanguages struct {
+	mu    sync.RWMutex
+	chats map[int64]ChatLanguage
+}
+
+// NewChatLanguages creates an empty set of chat language settings
+func NewChatLanguages() *ChatLanguages {
+	return &ChatLanguages{
+		chats: make(map[int64]ChatLanguage),
+	}
+}
+
+// Get returns the language settings of a chat
+func (c *ChatLanguages) Get(chatID int64) ChatLanguage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.chats[chatID]
+}
+
+// SetUILanguage sets the message language of a chat; "" clears it
+func (c *ChatLanguages) SetUILanguage(chatID int64, language string) {
+	c.update(chatID, func(settings *ChatLanguage) { settings.UILanguage = language })
+}
+
+// SetMetadataLanguage sets the tag language of a chat; "" clears it
+func (c *ChatLanguages) SetMetadataLanguage(chatID int64, language string) {
+	c.update(chatID, func(settings *ChatLanguage) { settings.MetadataLanguage = language })
+}
+
+// update changes the settings of a chat, forgetting chats left with none
+func (c *ChatLanguages) update(chatID int64, change func(*ChatLanguage)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	settings := c.chats[chatID]
+	change(&settings)
+	if settings == (ChatLanguage{}) {
+		delete(c.chats, chatID)
+		return
+	}
+	c.chats[chatID] = settings
+}
+
+// NormalizeLanguage checks a language tag and returns it in canonical case:
+// "JA" becomes "ja", "en-us" becomes "en-US" and "zh-hant" becomes "zh-Hant"
+func NormalizeLanguage(tag string) (string, error) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if !languageTagRegex.MatchString(tag) {
+		return "", fmt.Errorf("%q is not a language code like ja or en-US", tag)
+	}
+
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// downloadOptions returns the download options of a chat. Only the metadata
+// language reaches the downloader, METADATA_LANG for chats without one; the
+// UI language is for messages
+func (h *SongHandler) downloadOptions(chatID int64) downloader.DownloadOptions {
+	options := downloader.DownloadOptions{MetadataLanguage: h.metadataLanguage}
+	if h.languages != nil {
+		if language := h.languages.Get(chatID).MetadataLanguage; language != "" {
+			options.MetadataLanguage = language
+		}
+	}
+	return options
+}
+
+// requestOptions returns the download options of a request: tags in the
+// language the request or its chat asks for, and songs without ALAC
+// downloaded as AAC when asked for
+func (h *SongHandler) requestOptions(cmdCtx *CommandContext) downloader.DownloadOptions {
+	options := h.downloadOptions(cmdCtx.ChatID)
+	if language := RequestedLanguage(cmdCtx.MessageText); language != "" {
+		options.MetadataLanguage = language
+	}
+	options.AACFallback = h.aacFallback || RequestsAAC(cmdCtx.MessageText) || cmdCtx.Override.AAC
+	return options
+}
+
+// localizer returns the localizer for messages to a chat: its UI language,
+// then the requester's Telegram language, then English
+func (h *SongHandler) localizer(chatID int64, languageCode string) *Localizer {
+	var uiLanguage string
+	if h.languages != nil {
+		uiLanguage = h.languages.Get(chatID).UILanguage
+	}
+	return NewLocalizer(uiLanguage, languageCode)
+}
+
+// Localizer renders messages in one language
+type Localizer struct {
+	language string
+}
+
+// uiMessages are the translated messages by language and key. English has
+// every key; other languages fall back to it for keys they lack
+var uiMessages = map[string]map[string]string{
+	"en": {
+		"language.settings": "Languages for this chat:\nMessages: %s\nTags: %s",
+		"language.updated":  "✅ Language updated.",
+		"language.default":  "default",
+		"language.usage":    "Usage: /language ui|tags <code|default>",
+	},
+	"es": {
+		"language.settings": "Idiomas de este chat:\nMensajes: %s\nEtiquetas: %s",
+		"language.updated":  "✅ Idioma actualizado.",
+		"language.default":  "predeterminado",
+	},
+	"de": {
+		"language.settings": "Sprachen für diesen Chat:\nNachrichten: %s\nTags: %s",
+		"language.updated":  "✅ Sprache aktualisiert.",
+		"language.default":  "Standard",
+	},
+	"ja": {
+		"language.settings": "このチャットの言語:\nメッセージ: %s\nタグ: %s",
+		"language.updated":  "✅ 言語を更新しました。",
+		"language.default":  "デフォルト",
+	},
+}
+
+// NewLocalizer creates a localizer for the first of languages that has
+// translations, matching region-specific tags by their base language.
+// Without one it uses DefaultUILanguage
+func NewLocalizer(languages ...string) *Localizer {
+	for _, language := range languages {
+		base, _, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(language, "_", "-")), "-")
+		if _, ok := uiMessages[base]; ok {
+			return &Localizer{language: base}
+		}
+	}
+	return &Localizer{language: DefaultUILanguage}
+}
+
+// Language returns the language messages are rendered in
+func (l *Localizer) Language() string {
+	return l.language
+}
+
+// T returns the message for key formatted with args
+func (l *Localizer) T(key string, args ...any) string {
+	message, ok := uiMessages[l.language][key]
+	if !ok {
+		message = uiMessages[DefaultUILanguage][key]
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
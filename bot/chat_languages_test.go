@@ -0,0 +1,192 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestChatLanguages(t *testing.T) {
+	settings := NewChatLanguages()
+	if got := settings.Get(1); got != (ChatLanguage{}) {
+		t.Errorf("Expected no languages by default, got %+v", got)
+	}
+
+	settings.SetUILanguage(1, "en")
+	settings.SetMetadataLanguage(1, "ja")
+	if got, want := settings.Get(1), (ChatLanguage{UILanguage: "en", MetadataLanguage: "ja"}); got != want {
+		t.Errorf("Get(1) = %+v, want %+v", got, want)
+	}
+	if got := settings.Get(2); got != (ChatLanguage{}) {
+		t.Errorf("Expected chat 2 to be unaffected, got %+v", got)
+	}
+
+	// Clearing one language keeps the other
+	settings.SetUILanguage(1, "")
+	if got, want := settings.Get(1), (ChatLanguage{MetadataLanguage: "ja"}); got != want {
+		t.Errorf("Get(1) = %+v, want %+v", got, want)
+	}
+	settings.SetMetadataLanguage(1, "")
+	if len(settings.chats) != 0 {
+		t.Errorf("Expected chats without settings to be forgotten, got %v", settings.chats)
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	testCases := []struct {
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{tag: "JA", want: "ja"},
+		{tag: "en-us", want: "en-US"},
+		{tag: "pt_BR", want: "pt-BR"},
+		{tag: "zh-hant-tw", want: "zh-Hant-TW"},
+		{tag: "japanese", wantErr: true},
+		{tag: "en-", wantErr: true},
+		{tag: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := NormalizeLanguage(tc.tag)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("NormalizeLanguage(%q) = %q, %v, want %q (error %v)", tc.tag, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseLanguageArgs(t *testing.T) {
+	testCases := []struct {
+		args         string
+		wantTarget   string
+		wantLanguage string
+		wantErr      bool
+	}{
+		{args: ""},
+		{args: "ui de", wantTarget: "ui", wantLanguage: "de"},
+		{args: "tags ja-jp", wantTarget: "tags", wantLanguage: "ja-JP"},
+		{args: "metadata en-US", wantTarget: "tags", wantLanguage: "en-US"},
+		{args: "TAGS default", wantTarget: "tags"},
+		{args: "ui", wantErr: true},
+		{args: "voice ja", wantErr: true},
+		{args: "ui klingon!", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		target, language, err := parseLanguageArgs(tc.args)
+		if target != tc.wantTarget || language != tc.wantLanguage || (err != nil) != tc.wantErr {
+			t.Errorf("parseLanguageArgs(%q) = %q, %q, %v", tc.args, target, language, err)
+		}
+	}
+}
+
+func TestNewLocalizer(t *testing.T) {
+	testCases := []struct {
+		languages []string
+		want      string
+	}{
+		{nil, "en"},
+		{[]string{"", "de-AT"}, "de"},
+		{[]string{"ja", "de"}, "ja"},
+		{[]string{"xx", "es_MX"}, "es"},
+		{[]string{"xx"}, "en"},
+	}
+
+	for _, tc := range testCases {
+		if got := NewLocalizer(tc.languages...).Language(); got != tc.want {
+			t.Errorf("NewLocalizer(%q).Language() = %q, want %q", tc.languages, got, tc.want)
+		}
+	}
+
+	// Keys without a translation fall back to English
+	if got := NewLocalizer("ja").T("language.usage"); !strings.HasPrefix(got, "Usage: /language") {
+		t.Errorf("Expected the English usage, got %q", got)
+	}
+}
+
+func TestSongHandler_Localizer_UsesUILanguage(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	languages := handler.GetLanguages()
+
+	// Tags in Japanese do not make the messages Japanese
+	languages.SetMetadataLanguage(1, "ja")
+	if got := handler.localizer(1, "").Language(); got != "en" {
+		t.Errorf("Expected English messages with only a tag language set, got %q", got)
+	}
+	if got := handler.localizer(1, "es").Language(); got != "es" {
+		t.Errorf("Expected the requester's language without a UI language, got %q", got)
+	}
+
+	languages.SetUILanguage(1, "de")
+	localizer := handler.localizer(1, "es")
+	if got := localizer.Language(); got != "de" {
+		t.Errorf("Expected the chat's UI language to win, got %q", got)
+	}
+	want := "✅ Sprache aktualisiert.\n\nSprachen für diesen Chat:\nNachrichten: de\nTags: ja"
+	if got := createLanguageMessage(localizer, languages.Get(1), true); got != want {
+		t.Errorf("createLanguageMessage() = %q, want %q", got, want)
+	}
+}
+
+// optionsDownloader records the options it was given
+type optionsDownloader struct {
+	scriptedDownloader
+	options []downloader.DownloadOptions
+}
+
+func (d *optionsDownloader) SetOptions(options downloader.DownloadOptions) {
+	d.options = append(d.options, options)
+}
+
+func TestSongHandler_RunDownload_MetadataLanguage(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+		return nil
+	}
+	handler.GetLanguages().SetUILanguage(1, "de")
+	handler.GetLanguages().SetMetadataLanguage(1, "ja")
+
+	songDownloader := &optionsDownloader{}
+	if err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1}, "https://music.apple.com/us/song/x/1", songDownloader, &recordingReporter{}, time.Now()); err != nil {
+		t.Fatalf("runDownload failed: %v", err)
+	}
+
+	// Only the tag language reaches the downloader
+	want := downloader.DownloadOptions{MetadataLanguage: "ja"}
+	if len(songDownloader.options) != 1 || songDownloader.options[0] != want {
+		t.Errorf("Downloader options = %+v, want [%+v]", songDownloader.options, want)
+	}
+
+	// Chats without settings download in the storefront's language
+	songDownloader = &optionsDownloader{}
+	if err := handler.runDownload(context.Background(), &CommandContext{ChatID: 2}, "https://music.apple.com/us/song/x/1", songDownloader, &recordingReporter{}, time.Now()); err != nil {
+		t.Fatalf("runDownload failed: %v", err)
+	}
+	if len(songDownloader.options) != 1 || songDownloader.options[0] != (downloader.DownloadOptions{}) {
+		t.Errorf("Downloader options = %+v, want the defaults", songDownloader.options)
+	}
+
+	// METADATA_LANG covers chats without settings, and lang= beats both
+	handler.metadataLanguage = "en-US"
+	requests := []struct {
+		cmdCtx *CommandContext
+		want   string
+	}{
+		{&CommandContext{ChatID: 2}, "en-US"},
+		{&CommandContext{ChatID: 1}, "ja"},
+		{&CommandContext{ChatID: 1, MessageText: "/song https://music.apple.com/us/song/x/1 lang=fr"}, "fr"},
+	}
+	for _, request := range requests {
+		songDownloader = &optionsDownloader{}
+		if err := handler.runDownload(context.Background(), request.cmdCtx, "https://music.apple.com/us/song/x/1", songDownloader, &recordingReporter{}, time.Now()); err != nil {
+			t.Fatalf("runDownload failed: %v", err)
+		}
+		if len(songDownloader.options) != 1 || songDownloader.options[0].MetadataLanguage != request.want {
+			t.Errorf("Downloader options for %q = %+v, want language %q", request.cmdCtx.MessageText, songDownloader.options, request.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// ClearQueueHandler implements CommandHandler for the admin /clearqueue command
+type ClearQueueHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewClearQueueHandler creates a new ClearQueueHandler instance
+func NewClearQueueHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *ClearQueueHandler {
+	handler := &ClearQueueHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *ClearQueueHandler) Command() string {
+	return "clearqueue"
+}
+
+// AdminOnly reports that only administrators may run /clearqueue
+func (h *ClearQueueHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /clearqueue command and removes every queued request.
+// Requests already being processed are left to finish
+func (h *ClearQueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /clearqueue command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Queue system is not available.")
+	}
+
+	cleared := queue.ClearQueue()
+	h.logger.Info("Cleared the queue", logging.Int64("User", cmdCtx.UserID), logging.Int("Removed", cleared))
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createClearQueueMessage(cleared))
+}
+
+// createClearQueueMessage creates the reply to /clearqueue
+func createClearQueueMessage(cleared int) string {
+	if cleared == 0 {
+		return "📭 The queue is already empty."
+	}
+	return fmt.Sprintf("🗑 Cleared %d queued %s.", cleared, pluralize(cleared, "request", "requests"))
+}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+func TestClearQueueHandler_Command(t *testing.T) {
+	logger := logging.Discard()
+	handler := NewClearQueueHandler(nil, logger, NewSongHandler(nil, logger))
+
+	if got := handler.Command(); got != "clearqueue" {
+		t.Errorf("ClearQueueHandler.Command() = %v, want clearqueue", got)
+	}
+	if !handler.AdminOnly() {
+		t.Error("Expected /clearqueue to be admin-only")
+	}
+}
+
+// failedEventRecorder records the failed events of a queue
+type failedEventRecorder struct {
+	events []QueueEvent
+}
+
+func (r *failedEventRecorder) OnQueueEvent(event QueueEvent) {
+	if event.Kind == QueueEventFailed {
+		r.events = append(r.events, event)
+	}
+}
+
+func TestSongQueue_ClearQueue(t *testing.T) {
+	logger := logging.Discard()
+	queue := NewSongQueue(context.Background(), logger, nil)
+	queue.workers = 0
+
+	listener := &failedEventRecorder{}
+	queue.AddListener(listener)
+
+	if _, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/in/song/test/1"); err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+	if _, err := queue.AddBatchRequest("batch", 0, 4, 2, 5, "https://music.apple.com/in/song/test/2", "", nil); err != nil {
+		t.Fatalf("AddBatchRequest() error = %v", err)
+	}
+
+	if cleared := queue.ClearQueue(); cleared != 2 {
+		t.Errorf("ClearQueue() = %d, want 2", cleared)
+	}
+	if size := queue.GetQueueSize(); size != 0 {
+		t.Errorf("Expected an empty queue, got %d requests", size)
+	}
+	events := listener.events
+	if len(events) != 1 || events[0].BatchID != "batch" || events[0].Reason != "cancelled" {
+		t.Errorf("Expected the batch to be told about its cleared request, got %+v", events)
+	}
+}
+
+func TestCreateClearQueueMessage(t *testing.T) {
+	testCases := map[int]string{
+		0: "📭 The queue is already empty.",
+		1: "🗑 Cleared 1 queued request.",
+		3: "🗑 Cleared 3 queued requests.",
+	}
+	for cleared, want := range testCases {
+		if got := createClearQueueMessage(cleared); got != want {
+			t.Errorf("createClearQueueMessage(%d) = %q, want %q", cleared, got, want)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -14,24 +13,33 @@ import (
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/celestix/gotgproto/types"
 	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"go-alac-bot/config"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
 	"go.uber.org/zap"
 )
 
 // TelegramBot wraps the gotgproto client and provides bot lifecycle management
 type TelegramBot struct {
 	client       *gotgproto.Client
-	logger       *log.Logger
+	logger       logging.Logger
 	config       *config.BotConfig
+	registry     *config.Registry
 	router       *CommandRouter
+	callbacks    *CallbackRouter
+	inline       *InlineHandler
 	errorHandler *ErrorHandler
+	latencies    *downloader.LatencyTracker
+	peers        *PeerResolver
+	queue        *SongQueue
 	ctx          context.Context
 	cancel       context.CancelFunc
 }
 
 // NewTelegramBot creates a new TelegramBot instance with proper gotgproto client setup
-func NewTelegramBot(cfg *config.BotConfig, logger *log.Logger) (*TelegramBot, error) {
+func NewTelegramBot(cfg *config.BotConfig, logger logging.Logger) (*TelegramBot, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
@@ -44,11 +52,15 @@ func NewTelegramBot(cfg *config.BotConfig, logger *log.Logger) (*TelegramBot, er
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	bot := &TelegramBot{
-		config: cfg,
-		logger: logger,
-		router: NewCommandRouter(logger),
-		ctx:    ctx,
-		cancel: cancel,
+		config:    cfg,
+		registry:  config.NewRegistry(cfg),
+		logger:    logger,
+		router:    NewCommandRouter(logger),
+		callbacks: NewCallbackRouter(logger),
+		latencies: downloader.NewLatencyTracker(),
+		peers:     NewPeerResolver(),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 	
 	// Initialize error handler
@@ -56,13 +68,16 @@ func NewTelegramBot(cfg *config.BotConfig, logger *log.Logger) (*TelegramBot, er
 	
 	// Set error handler in router
 	bot.router.SetErrorHandler(bot.errorHandler)
+
+	// Admin-only commands are checked against ADMIN_IDS
+	bot.router.SetAdminCheck(bot.IsAdmin, bot.SendText)
 	
 	return bot, nil
 }
 
 // Start initializes the gotgproto client and starts the bot
 func (b *TelegramBot) Start() error {
-	b.logger.Printf("Starting Telegram bot...")
+	b.logger.Info("Starting Telegram bot...")
 	
 	// Add panic recovery for the entire start process
 	defer func() {
@@ -89,6 +104,9 @@ func (b *TelegramBot) Start() error {
 	clientOpts := &gotgproto.ClientOpts{
 		Session: sessionMaker.SqlSession(sqlite.Open("bot_session.db")),
 		Logger:  zapLogger,
+
+		// Time sends, edits and uploads next to the downloads' external calls
+		Middlewares: []telegram.Middleware{latencyMiddleware(b.latencies)},
 	}
 	
 	// Initialize gotgproto client with retry logic for network errors
@@ -107,7 +125,8 @@ func (b *TelegramBot) Start() error {
 	}
 	
 	b.client = client
-	b.logger.Printf("Telegram bot client initialized successfully")
+	b.peers.SetLookup(b.lookupAccessHash)
+	b.logger.Info("Telegram bot client initialized successfully")
 	
 	// Set up update handler to route commands
 	b.setupUpdateHandler()
@@ -129,13 +148,22 @@ func (b *TelegramBot) Start() error {
 	// Get bot information to show logged in message
 	b.showBotInfo()
 	
-	b.logger.Printf("Telegram bot started successfully")
+	b.logger.Info("Telegram bot started successfully")
 	return nil
 }
 
-// Stop gracefully shuts down the bot
-func (b *TelegramBot) Stop() error {
-	b.logger.Printf("Stopping Telegram bot...")
+// Stop gracefully shuts down the bot, giving the song queue until ctx ends to
+// finish its downloads
+func (b *TelegramBot) Stop(ctx context.Context) error {
+	b.logger.Info("Stopping Telegram bot...")
+
+	// Let the downloads finish or re-queue them while the client can still
+	// edit their progress messages
+	if b.queue != nil {
+		if err := b.queue.Shutdown(ctx); err != nil {
+			b.logger.Warn("Song queue did not drain in time", logging.Err(err))
+		}
+	}
 	
 	if b.cancel != nil {
 		b.cancel()
@@ -144,13 +172,24 @@ func (b *TelegramBot) Stop() error {
 	if b.client != nil {
 		// Give the client time to clean up
 		time.Sleep(100 * time.Millisecond)
-		b.logger.Printf("Bot client stopped")
+		b.logger.Debug("Bot client stopped")
 	}
 	
-	b.logger.Printf("Telegram bot stopped successfully")
+	b.logger.Info("Telegram bot stopped successfully")
 	return nil
 }
 
+// SetSongQueue sets the queue Stop drains before the client is stopped
+func (b *TelegramBot) SetSongQueue(queue *SongQueue) {
+	b.queue = queue
+}
+
+// Latencies returns the tracker timing calls to Telegram and, through the song
+// handler's downloads, to the other external dependencies
+func (b *TelegramBot) Latencies() *downloader.LatencyTracker {
+	return b.latencies
+}
+
 // GetClient returns the underlying gotgproto client for advanced usage
 func (b *TelegramBot) GetClient() *gotgproto.Client {
 	return b.client
@@ -161,16 +200,85 @@ func (b *TelegramBot) IsRunning() bool {
 	return b.client != nil && b.ctx.Err() == nil
 }
 
+// Context returns the context cancelled when the bot stops, for work that
+// outlives the update it was started by. Without a bot it is never cancelled
+func (b *TelegramBot) Context() context.Context {
+	if b == nil || b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
 // RegisterCommandHandler registers a command handler with the bot's router
 func (b *TelegramBot) RegisterCommandHandler(handler CommandHandler) {
 	b.router.RegisterHandler(handler)
 }
 
+// RegisterCallbackHandler registers a handler for inline button presses
+func (b *TelegramBot) RegisterCallbackHandler(handler CallbackHandler) {
+	b.callbacks.RegisterHandler(handler)
+}
+
+// RegisterInlineHandler sets the handler answering inline queries; without
+// one they are ignored
+func (b *TelegramBot) RegisterInlineHandler(handler *InlineHandler) {
+	b.inline = handler
+}
+
 // GetRouter returns the command router for advanced usage
 func (b *TelegramBot) GetRouter() *CommandRouter {
 	return b.router
 }
 
+// GetConfig returns the configuration the bot was created with
+func (b *TelegramBot) GetConfig() *config.BotConfig {
+	return b.config
+}
+
+// GetConfigRegistry returns the registry of effective settings
+func (b *TelegramBot) GetConfigRegistry() *config.Registry {
+	return b.registry
+}
+
+// Peers returns the resolver of the peers messages are sent to
+func (b *TelegramBot) Peers() *PeerResolver {
+	if b == nil {
+		return nil
+	}
+	return b.peers
+}
+
+// InputPeer returns the peer of a chat ID, with the access hash supergroups
+// and channels need when it is known. It works on a nil bot, for tests
+func (b *TelegramBot) InputPeer(chatID int64) tg.InputPeerClass {
+	return b.Peers().InputPeer(chatID)
+}
+
+// lookupAccessHash finds the access hash of a user or channel in the
+// session's peer storage, which keys them by their raw ID
+func (b *TelegramBot) lookupAccessHash(chatID int64) (int64, bool) {
+	var id int64
+	switch peer := downloader.ChatInputPeer(chatID, 0).(type) {
+	case *tg.InputPeerUser:
+		id = peer.UserID
+	case *tg.InputPeerChannel:
+		id = peer.ChannelID
+	default:
+		return 0, false
+	}
+
+	peer := b.client.PeerStorage.GetPeerById(id)
+	if peer == nil || peer.AccessHash == 0 {
+		return 0, false
+	}
+	return peer.AccessHash, true
+}
+
+// IsAdmin reports whether the user is allowed to run admin commands
+func (b *TelegramBot) IsAdmin(userID int64) bool {
+	return b.config != nil && b.config.IsAdmin(userID)
+}
+
 // GetErrorHandler returns the error handler for advanced usage
 func (b *TelegramBot) GetErrorHandler() *ErrorHandler {
 	return b.errorHandler
@@ -178,12 +286,19 @@ func (b *TelegramBot) GetErrorHandler() *ErrorHandler {
 
 // setupUpdateHandler configures the update handler to route incoming messages to command handlers
 func (b *TelegramBot) setupUpdateHandler() {
-	b.logger.Printf("Setting up update handler for command routing...")
+	b.logger.Debug("Setting up update handler for command routing...")
 	
 	// Set up message handler for all text messages (including commands)
 	b.client.Dispatcher.AddHandler(handlers.NewMessage(filters.Message.Text, b.handleMessage))
+
+	// Set up callback handler for inline button presses
+	b.client.Dispatcher.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.All, b.handleCallbackQuery))
+
+	// Set up inline query handlers for "@bot <link>" in any chat
+	b.client.Dispatcher.AddHandler(handlers.NewInlineQuery(filters.InlineQuery.All, b.handleInlineQuery))
+	b.client.Dispatcher.AddHandler(handlers.NewAnyUpdate(b.handleChosenInlineResult))
 	
-	b.logger.Printf("Update handler configured successfully")
+	b.logger.Debug("Update handler configured successfully")
 }
 
 // handleMessage processes incoming text messages and routes commands to handlers
@@ -205,14 +320,84 @@ func (b *TelegramBot) handleMessage(ctx *ext.Context, update *ext.Update) error
 	if !strings.HasPrefix(msg.Text, "/") {
 		return nil // Not a command, ignore
 	}
+
+	// Replies to supergroups and channels need their access hash
+	b.peers.RememberEntities(update.Entities)
 	
 	// Extract basic information for command routing
 	// We'll create a simplified command context directly instead of converting to tg types
-	b.routeCommandDirect(ctx.Context, msg)
+	b.routeCommandDirect(ctx.Context, msg, update.EffectiveUser())
 	
 	return nil
 }
 
+// handleCallbackQuery routes inline button presses to callback handlers and
+// answers them, so the client stops showing a loading state
+func (b *TelegramBot) handleCallbackQuery(ctx *ext.Context, update *ext.Update) error {
+	defer func() {
+		if b.errorHandler != nil {
+			b.errorHandler.RecoverFromPanic()
+		}
+	}()
+
+	b.peers.RememberEntities(update.Entities)
+	query := update.CallbackQuery
+	notice, err := b.callbacks.RouteCallback(ctx.Context, query)
+	if err != nil {
+		b.logger.Error("Error handling callback query", logging.Err(err))
+		notice = "❌ Something went wrong, please try again."
+	}
+
+	answer := &tg.MessagesSetBotCallbackAnswerRequest{QueryID: query.QueryID}
+	if notice != "" {
+		answer.SetMessage(notice)
+	}
+	if _, err := b.client.API().MessagesSetBotCallbackAnswer(ctx.Context, answer); err != nil {
+		b.logger.Warn("Failed to answer callback query", logging.Err(err))
+	}
+	return nil
+}
+
+// handleInlineQuery answers inline queries through the inline handler
+func (b *TelegramBot) handleInlineQuery(ctx *ext.Context, update *ext.Update) error {
+	defer func() {
+		if b.errorHandler != nil {
+			b.errorHandler.RecoverFromPanic()
+		}
+	}()
+
+	if b.inline == nil || update.InlineQuery == nil {
+		return nil
+	}
+	if err := b.inline.HandleInlineQuery(ctx.Context, update.InlineQuery); err != nil {
+		b.logger.Error("Error handling inline query", logging.Err(err))
+	}
+	return nil
+}
+
+// handleChosenInlineResult passes the inline results users send to the
+// inline handler. Telegram only reports them when inline feedback is enabled
+// for the bot
+func (b *TelegramBot) handleChosenInlineResult(ctx *ext.Context, update *ext.Update) error {
+	chosen, ok := update.UpdateClass.(*tg.UpdateBotInlineSend)
+	if !ok || b.inline == nil {
+		return nil
+	}
+
+	defer func() {
+		if b.errorHandler != nil {
+			b.errorHandler.RecoverFromPanic()
+		}
+	}()
+
+	// The user is notified in their private chat with the bot
+	b.peers.RememberEntities(update.Entities)
+	if err := b.inline.HandleChosenResult(ctx.Context, chosen); err != nil {
+		b.logger.Error("Error handling chosen inline result", logging.Err(err))
+	}
+	return nil
+}
+
 // showBotInfo retrieves and displays bot information
 func (b *TelegramBot) showBotInfo() {
 	if b.client == nil {
@@ -222,7 +407,7 @@ func (b *TelegramBot) showBotInfo() {
 	// Get bot's own user info using the gotgproto API
 	self := b.client.Self
 	if self == nil {
-		b.logger.Printf("Could not retrieve bot info")
+		b.logger.Warn("Could not retrieve bot info")
 		return
 	}
 	
@@ -233,11 +418,11 @@ func (b *TelegramBot) showBotInfo() {
 		username = self.FirstName
 	}
 	
-	b.logger.Printf("Logged in as @%s", username)
+	b.logger.Info("Logged in", logging.String("Username", "@"+username))
 }
 
 // routeCommandDirect routes commands directly without converting to tg types
-func (b *TelegramBot) routeCommandDirect(ctx context.Context, msg *types.Message) {
+func (b *TelegramBot) routeCommandDirect(ctx context.Context, msg *types.Message, sender *tg.User) {
 	// Since types.Message embeds *tg.Message, we can use it directly
 	// Just need to create the UpdateNewMessage wrapper
 	updateNewMessage := &tg.UpdateNewMessage{
@@ -247,8 +432,8 @@ func (b *TelegramBot) routeCommandDirect(ctx context.Context, msg *types.Message
 	}
 	
 	// Use the existing router which handles all the logic
-	if err := b.router.RouteCommand(ctx, updateNewMessage); err != nil {
-		b.logger.Printf("Error routing command: %v", err)
+	if err := b.router.RouteCommandFrom(ctx, updateNewMessage, sender); err != nil {
+		b.logger.Error("Error routing command", logging.Err(err))
 		// Error is already handled by the router's error handler
 	}
 }
\ No newline at end of file
@@ -1,11 +1,12 @@
 package bot
 
 import (
-	"log"
+	"context"
 	"os"
 	"testing"
 
 	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 func TestNewTelegramBot(t *testing.T) {
@@ -18,7 +19,7 @@ func TestNewTelegramBot(t *testing.T) {
 	}
 	
 	// Create test logger
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	// Test successful creation
 	bot, err := NewTelegramBot(cfg, logger)
@@ -48,7 +49,7 @@ func TestNewTelegramBot(t *testing.T) {
 }
 
 func TestNewTelegramBot_NilConfig(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	bot, err := NewTelegramBot(nil, logger)
 	if err == nil {
@@ -96,7 +97,7 @@ func TestTelegramBot_IsRunning(t *testing.T) {
 		LogLevel: "INFO",
 	}
 	
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	bot, err := NewTelegramBot(cfg, logger)
 	if err != nil {
@@ -109,7 +110,7 @@ func TestTelegramBot_IsRunning(t *testing.T) {
 	}
 	
 	// After stopping context, should not be running
-	bot.Stop()
+	bot.Stop(context.Background())
 	if bot.IsRunning() {
 		t.Error("Expected bot to not be running after stop")
 	}
@@ -123,7 +124,7 @@ func TestTelegramBot_GetClient(t *testing.T) {
 		LogLevel: "INFO",
 	}
 	
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	bot, err := NewTelegramBot(cfg, logger)
 	if err != nil {
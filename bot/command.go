@@ -15,6 +15,13 @@ type CommandHandler interface {
 	Command() string
 }
 
+// AdminCommand is implemented by command handlers that declare whether only
+// bot administrators may run them. The router refuses everyone else before
+// the handler is called
+type AdminCommand interface {
+	AdminOnly() bool
+}
+
 // CommandContext provides context information for command processing
 type CommandContext struct {
 	// Update contains the original Telegram update
@@ -31,12 +38,33 @@ type CommandContext struct {
 	FirstName string
 	// LastName is the last name of the user (may be empty)
 	LastName string
+	// LanguageCode is the user's Telegram client language (may be empty)
+	LanguageCode string
 	// Command is the command string without the leading slash
 	Command string
 	// Args contains command arguments (text after the command)
 	Args string
+	// MessageText is the full text of the message containing the command
+	MessageText string
+	// Entities are the Telegram message entities attached to MessageText
+	Entities []tg.MessageEntityClass
 	// ReplyToMessageID is the ID of the message being replied to (0 if not a reply)
 	ReplyToMessageID int32
 	// Timestamp is when the command was received
 	Timestamp time.Time
+	// Delivery receives delivery receipts for queued requests (nil otherwise)
+	Delivery *DeliveryState
+	// RequestID is the unique ID of the queue request being processed (empty otherwise)
+	RequestID string
+	// Override changes how a re-queued request is downloaded (zero otherwise)
+	Override DownloadOverride
+	// BatchID is the batch the queue request was submitted in (empty otherwise)
+	BatchID string
+	// Attempt counts the tries of the queue request out of Attempts, which
+	// include the automatic retries after transient failures (0 otherwise)
+	Attempt  int
+	Attempts int
+	// StatusMessageID is the message that told the user where the queue
+	// request waited, carried on by its progress (0 otherwise)
+	StatusMessageID int
 }
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go-alac-bot/config"
+	"go-alac-bot/logging"
+)
+
+// ConfigHandler implements CommandHandler for the admin /config command
+type ConfigHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+}
+
+// NewConfigHandler creates a new ConfigHandler instance
+func NewConfigHandler(client *TelegramBot, logger logging.Logger) *ConfigHandler {
+	handler := &ConfigHandler{
+		client: client,
+		sender: client,
+		logger: logger,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *ConfigHandler) Command() string {
+	return "config"
+}
+
+// AdminOnly reports that only administrators may run /config
+func (h *ConfigHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /config command and shows the effective configuration
+func (h *ConfigHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /config command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	message, err := createConfigMessage(h.client.GetConfigRegistry().ConfigSnapshot())
+	if err != nil {
+		h.logger.Error("Failed to render configuration", logging.Err(err))
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Failed to render configuration.")
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, message)
+}
+
+// createConfigMessage renders a configuration snapshot as a JSON block. The
+// entries are expected to be masked already
+func createConfigMessage(entries []config.ConfigEntry) (string, error) {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("⚙️ **Effective Configuration**\n\nSources: env, default, or runtime (changed by commands such as /setqueue)\n\n```json\n%s\n```", data), nil
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go-alac-bot/config"
+	"go-alac-bot/logging"
+)
+
+func TestConfigHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewConfigHandler(nil, logger)
+
+	expected := "config"
+	if got := handler.Command(); got != expected {
+		t.Errorf("ConfigHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateConfigMessage(t *testing.T) {
+	const token = "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11"
+	t.Setenv("BOT_TOKEN", token)
+	t.Setenv("DATA_DIR", "")
+
+	registry := config.NewRegistry(&config.BotConfig{Token: token, DataDir: config.DefaultDataDir})
+	registry.SetOverride("QUEUE_SIZE", "12")
+
+	message, err := createConfigMessage(registry.ConfigSnapshot())
+	if err != nil {
+		t.Fatalf("createConfigMessage failed: %v", err)
+	}
+
+	if strings.Contains(message, token) || strings.Contains(message, "ABC-DEF") {
+		t.Errorf("Expected the token to be masked, got %q", message)
+	}
+	for _, want := range []string{
+		"```json\n",
+		`"name": "BOT_TOKEN",` + "\n    \"value\": \"1234***ew11\",\n    \"source\": \"env\"",
+		`"name": "DATA_DIR",` + "\n    \"value\": \"data\",\n    \"source\": \"default\"",
+		`"name": "QUEUE_SIZE",` + "\n    \"value\": \"12\",\n    \"source\": \"runtime\"",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Expected message to contain %q, got:\n%s", want, message)
+		}
+	}
+}
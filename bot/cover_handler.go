@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const (
+	// maxCaptionLength is Telegram's limit on media captions, in UTF-16 units
+	maxCaptionLength = 1024
+
+	// coverTimeout bounds fetching and uploading one cover
+	coverTimeout = time.Minute
+)
+
+// CoverHandler implements CommandHandler for the /cover command, which sends
+// the artwork and metadata of a song or album without downloading its audio
+type CoverHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewCoverHandler creates a new CoverHandler instance
+func NewCoverHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *CoverHandler {
+	handler := &CoverHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *CoverHandler) Command() string {
+	return "cover"
+}
+
+// Handle processes the /cover command. Covers need no device or decryption
+// work, so they are sent straight away instead of going through the queue
+func (h *CoverHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /cover command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	link := strings.TrimSpace(cmdCtx.Args)
+	if link == "" {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Please provide an Apple Music song or album URL.\n\nUsage: /cover <url>")
+	}
+
+	urlMeta := ExtractURLMetaWithHints(link, h.songHandler.storefrontHints(cmdCtx))
+	if urlMeta == nil || (urlMeta.URLType != "songs" && urlMeta.URLType != "albums") {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Please provide a valid Apple Music song or album URL.")
+	}
+	if ok, err := h.songHandler.allowLookup(timeoutCtx, cmdCtx); !ok {
+		return err
+	}
+
+	// The cover is sent after Handle returns, but not after the bot stops
+	go h.sendCover(h.client.Context(), cmdCtx.ChatID, &downloader.URLMeta{
+		Storefront: urlMeta.Storefront,
+		URLType:    urlMeta.URLType,
+		ID:         urlMeta.ID,
+	})
+
+	return nil
+}
+
+// sendCover fetches the cover for urlMeta and sends it to chatID
+func (h *CoverHandler) sendCover(ctx context.Context, chatID int64, urlMeta *downloader.URLMeta) {
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+
+	result, err := h.songHandler.manager.GetArtworkAndMetadata(ctx, urlMeta)
+	if err != nil {
+		h.logger.Error("Failed to fetch cover", logging.String("Type", urlMeta.URLType), logging.String("ID", urlMeta.ID), logging.Err(err))
+		if err := h.sender.SendMarkdown(ctx, chatID, "❌ Failed to fetch the cover: "+userFacingError(err)); err != nil {
+			h.logger.Error("Failed to send cover error", logging.Err(err))
+		}
+		return
+	}
+
+	if err := sendPhoto(ctx, h.client, chatID, result.Artwork, createCoverCaption(result)); err != nil {
+		h.logger.Error("Failed to send cover", logging.String("Type", urlMeta.URLType), logging.String("ID", urlMeta.ID), logging.Err(err))
+		return
+	}
+
+	h.logger.Info("Sent cover", logging.String("Type", urlMeta.URLType), logging.String("ID", urlMeta.ID), logging.Int64("Chat", chatID))
+}
+
+// createCoverCaption creates the caption sent with a cover. Album tracklists
+// are cut short to fit the caption limit
+func createCoverCaption(result *downloader.CoverResult) string {
+	var header strings.Builder
+	var tracks []string
+	totalTracks := 0
+
+	if song := result.Song; song != nil {
+		attrs := song.Attributes
+		fmt.Fprintf(&header, "🎵 %s — %s\n", attrs.Name, attrs.ArtistName)
+		if attrs.AlbumName != "" {
+			fmt.Fprintf(&header, "💿 %s\n", attrs.AlbumName)
+		}
+		writeCoverDetails(&header, attrs.ReleaseDate, attrs.GenreNames, "")
+		if attrs.ISRC != "" {
+			fmt.Fprintf(&header, "🔢 ISRC: %s\n", attrs.ISRC)
+		}
+		for _, album := range song.Relationships.Albums.Data {
+			if album.Attributes != nil && album.Attributes.UPC != "" {
+				fmt.Fprintf(&header, "🏷️ UPC: %s\n", album.Attributes.UPC)
+				break
+			}
+		}
+	}
+
+	if album := result.Album; album != nil {
+		attrs := album.Attributes
+		fmt.Fprintf(&header, "💿 %s — %s\n", attrs.Name, attrs.ArtistName)
+		writeCoverDetails(&header, attrs.ReleaseDate, attrs.GenreNames, attrs.RecordLabel)
+		if attrs.UPC != "" {
+			fmt.Fprintf(&header, "🏷️ UPC: %s\n", attrs.UPC)
+		}
+
+		data := album.Relationships.Tracks.Data
+		multiDisc := false
+		for _, track := range data {
+			if track.Attributes.DiscNumber > 1 {
+				multiDisc = true
+			}
+		}
+		for _, track := range data {
+			if multiDisc {
+				tracks = append(tracks, fmt.Sprintf("%d-%d. %s", track.Attributes.DiscNumber, track.Attributes.TrackNumber, track.Attributes.Name))
+			} else {
+				tracks = append(tracks, fmt.Sprintf("%d. %s", track.Attributes.TrackNumber, track.Attributes.Name))
+			}
+		}
+		totalTracks = attrs.TrackCount
+		if totalTracks < len(tracks) {
+			totalTracks = len(tracks)
+		}
+	}
+
+	caption := strings.TrimRight(header.String(), "\n")
+	if totalTracks == 0 {
+		return downloader.TruncateUTF16(caption, maxCaptionLength)
+	}
+
+	caption += "\n\n📃 Tracklist:"
+	for i, track := range tracks {
+		// Keep room for the note about the tracks left out
+		more := ""
+		if remaining := totalTracks - i - 1; remaining > 0 {
+			more = fmt.Sprintf("\n…and %d more tracks", remaining)
+		}
+		if utf16Len(caption+"\n"+track+more) > maxCaptionLength {
+			return downloader.TruncateUTF16(caption+fmt.Sprintf("\n…and %d more tracks", totalTracks-i), maxCaptionLength)
+		}
+		caption += "\n" + track
+	}
+	if totalTracks > len(tracks) {
+		caption += fmt.Sprintf("\n…and %d more tracks", totalTracks-len(tracks))
+	}
+
+	return downloader.TruncateUTF16(caption, maxCaptionLength)
+}
+
+// writeCoverDetails writes the optional release date, genre and label lines
+func writeCoverDetails(b *strings.Builder, releaseDate string, genres []string, label string) {
+	if releaseDate != "" {
+		fmt.Fprintf(b, "📅 %s\n", releaseDate)
+	}
+	if len(genres) > 0 {
+		fmt.Fprintf(b, "🎼 %s\n", genres[0])
+	}
+	if label != "" {
+		fmt.Fprintf(b, "🏢 %s\n", label)
+	}
+}
+
+// sendPhoto uploads an image and sends it to the specified chat with caption
+func sendPhoto(ctx context.Context, client *TelegramBot, chatID int64, image []byte, caption string) error {
+	if client == nil || client.GetClient() == nil {
+		return fmt.Errorf("bot client is not initialized")
+	}
+
+	uploaded, err := uploader.NewUploader(client.GetClient().API()).FromBytes(ctx, "cover.jpg", image)
+	if err != nil {
+		return fmt.Errorf("failed to upload photo: %w", err)
+	}
+
+	peer := client.InputPeer(chatID)
+
+	_, err = client.GetClient().API().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer:     peer,
+		Media:    &tg.InputMediaUploadedPhoto{File: uploaded},
+		Message:  caption,
+		RandomID: time.Now().UnixNano(),
+	})
+	if err != nil {
+		if downloader.IsMediaForbidden(err) {
+			return fmt.Errorf("chat does not allow sending photos: %w", err)
+		}
+		return fmt.Errorf("failed to send photo: %w", err)
+	}
+
+	return nil
+}
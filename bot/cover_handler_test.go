@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestCoverHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewCoverHandler(nil, logger, nil)
+
+	expected := "cover"
+	if got := handler.Command(); got != expected {
+		t.Errorf("CoverHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateCoverCaption_Song(t *testing.T) {
+	result := &downloader.CoverResult{
+		Song: &downloader.AutoSong{
+			ID: "100",
+			Attributes: downloader.SongAttributes{
+				Name:        "Song",
+				ArtistName:  "Artist",
+				AlbumName:   "Album",
+				ReleaseDate: "2021-03-19",
+				GenreNames:  []string{"Pop", "Music"},
+				ISRC:        "USABC1234567",
+			},
+			Relationships: downloader.Relationships{
+				Albums: downloader.Relationship{Data: []downloader.RelationshipData{
+					{ID: "200", Attributes: &downloader.AlbumAttributes{UPC: "012345678905"}},
+				}},
+			},
+		},
+	}
+
+	caption := createCoverCaption(result)
+
+	for _, want := range []string{
+		"🎵 Song — Artist", "💿 Album", "📅 2021-03-19", "🎼 Pop",
+		"ISRC: USABC1234567", "UPC: 012345678905",
+	} {
+		if !strings.Contains(caption, want) {
+			t.Errorf("Expected caption to contain %q, got %q", want, caption)
+		}
+	}
+	if strings.Contains(caption, "Tracklist") {
+		t.Errorf("Expected no tracklist for a song, got %q", caption)
+	}
+}
+
+func TestCreateCoverCaption_Album(t *testing.T) {
+	result := &downloader.CoverResult{
+		Album: albumWithTracks(3, 3),
+	}
+	result.Album.Relationships.Tracks.Data[2].Attributes.DiscNumber = 2
+	result.Album.Relationships.Tracks.Data[2].Attributes.TrackNumber = 1
+
+	caption := createCoverCaption(result)
+
+	for _, want := range []string{
+		"💿 Album — Artist", "🏢 Label", "UPC: 012345678905",
+		"📃 Tracklist:", "1-1. Track 1", "1-2. Track 2", "2-1. Track 3",
+	} {
+		if !strings.Contains(caption, want) {
+			t.Errorf("Expected caption to contain %q, got %q", want, caption)
+		}
+	}
+	if strings.Contains(caption, "more tracks") {
+		t.Errorf("Expected the full tracklist, got %q", caption)
+	}
+}
+
+func TestCreateCoverCaption_TruncatesTracklist(t *testing.T) {
+	tests := []struct {
+		name       string
+		listed     int
+		trackCount int
+	}{
+		{"long album", 150, 150},
+		{"tracks beyond the first page", 300, 450},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caption := createCoverCaption(&downloader.CoverResult{Album: albumWithTracks(tt.listed, tt.trackCount)})
+
+			if n := utf16Len(caption); n > maxCaptionLength {
+				t.Fatalf("Caption is %d UTF-16 units, want at most %d", n, maxCaptionLength)
+			}
+
+			lines := strings.Split(caption, "\n")
+			listed := 0
+			for _, line := range lines {
+				if strings.Contains(line, ". Track ") {
+					listed++
+				}
+			}
+			want := fmt.Sprintf("…and %d more tracks", tt.trackCount-listed)
+			if last := lines[len(lines)-1]; last != want {
+				t.Errorf("Last line = %q, want %q", last, want)
+			}
+			if listed == 0 {
+				t.Error("Expected some tracks to be listed")
+			}
+		})
+	}
+}
+
+// albumWithTracks builds an album listing n tracks out of trackCount
+func albumWithTracks(n, trackCount int) *downloader.AutoAlbum {
+	album := &downloader.AutoAlbum{
+		ID: "200",
+		Attributes: downloader.AlbumAttributes{
+			Name:        "Album",
+			ArtistName:  "Artist",
+			RecordLabel: "Label",
+			UPC:         "012345678905",
+			TrackCount:  trackCount,
+		},
+	}
+	for i := 1; i <= n; i++ {
+		album.Relationships.Tracks.Data = append(album.Relationships.Tracks.Data, downloader.AutoSong{
+			ID: fmt.Sprint(i),
+			Attributes: downloader.SongAttributes{
+				Name:        fmt.Sprintf("Track %d", i),
+				TrackNumber: i,
+				DiscNumber:  1,
+			},
+		})
+	}
+	return album
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DownloadHistoryFile is the name of the download history file inside the
+// data dir
+const DownloadHistoryFile = "download_history.jsonl"
+
+// HistoryRecord is a song delivered to a chat
+type HistoryRecord struct {
+	UserID           int64     `json:"user_id"`
+	ChatID           int64     `json:"chat_id"`
+	AppleMusicID     string    `json:"apple_music_id"`
+	Title            string    `json:"title"`
+	Artist           string    `json:"artist"`
+	FileSize         int64     `json:"file_size"`
+	Quality          string    `json:"quality,omitempty"`
+	ArchiveMessageID int       `json:"archive_message_id,omitempty"` // 0 = not archived
+	DownloadedAt     time.Time `json:"downloaded_at"`
+}
+
+// HistoryTotals counts the downloads of every user, for admins
+type HistoryTotals struct {
+	Downloads int   // Songs delivered
+	Songs     int   // Distinct songs
+	Users     int   // Distinct users
+	Chats     int   // Distinct chats
+	Bytes     int64 // Size of every delivered file
+	LastDay   int   // Songs delivered in the last 24 hours
+}
+
+// DownloadHistory stores the songs delivered to users
+type DownloadHistory interface {
+	// Add records a delivered song
+	Add(record HistoryRecord) error
+	// Recent returns up to limit of a user's downloads, newest first
+	Recent(userID int64, limit int) ([]HistoryRecord, error)
+	// Totals counts the downloads of every user as of now
+	Totals(now time.Time) (HistoryTotals, error)
+}
+
+// MemoryHistory is a DownloadHistory kept in memory only. Records are kept
+// in the order they were added
+type MemoryHistory struct {
+	mu      sync.RWMutex
+	records []HistoryRecord
+}
+
+// NewMemoryHistory creates an empty in-memory download history
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{}
+}
+
+// Add implements DownloadHistory
+func (h *MemoryHistory) Add(record HistoryRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, record)
+	return nil
+}
+
+// Recent implements DownloadHistory
+func (h *MemoryHistory) Recent(userID int64, limit int) ([]HistoryRecord, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var records []HistoryRecord
+	for i := len(h.records) - 1; i >= 0 && len(records) < limit; i-- {
+		if h.records[i].UserID == userID {
+			records = append(records, h.records[i])
+		}
+	}
+	return records, nil
+}
+
+// Totals implements DownloadHistory
+func (h *MemoryHistory) Totals(now time.Time) (HistoryTotals, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	songs := make(map[string]bool)
+	users := make(map[int64]bool)
+	chats := make(map[int64]bool)
+	totals := HistoryTotals{Downloads: len(h.records)}
+	for _, record := range h.records {
+		songs[record.AppleMusicID] = true
+		users[record.UserID] = true
+		chats[record.ChatID] = true
+		totals.Bytes += record.FileSize
+		if now.Sub(record.DownloadedAt) < 24*time.Hour {
+			totals.LastDay++
+		}
+	}
+	totals.Songs, totals.Users, totals.Chats = len(songs), len(users), len(chats)
+	return totals, nil
+}
+
+// FileHistory is a DownloadHistory saved to a file in the data dir, one JSON
+// record per line so that a download only appends to it
+type FileHistory struct {
+	*MemoryHistory
+
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileHistory creates a download history saved to path, loading the
+// records saved there. Unreadable lines are skipped
+func NewFileHistory(path string) (*FileHistory, error) {
+	history := &FileHistory{MemoryHistory: NewMemoryHistory(), path: path}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return history, fmt.Errorf("failed to read download history: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	skipped := 0
+	for scanner.Scan() {
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			skipped++
+			continue
+		}
+		history.records = append(history.records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return history, fmt.Errorf("failed to read download history: %w", err)
+	}
+	if skipped > 0 {
+		return history, fmt.Errorf("skipped %d unreadable download history records", skipped)
+	}
+	return history, nil
+}
+
+// Add implements DownloadHistory, appending the record to the file
+func (h *FileHistory) Add(record HistoryRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode download history record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open download history: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save download history record: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to save download history record: %w", err)
+	}
+
+	return h.MemoryHistory.Add(record)
+}
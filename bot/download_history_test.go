@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryHistory(t *testing.T) {
+	history := NewMemoryHistory()
+	now := time.Now()
+
+	for i, record := range []HistoryRecord{
+		{UserID: 1, ChatID: 100, AppleMusicID: "1", FileSize: 10, DownloadedAt: now.Add(-48 * time.Hour)},
+		{UserID: 2, ChatID: 200, AppleMusicID: "1", FileSize: 20, DownloadedAt: now.Add(-time.Hour)},
+		{UserID: 1, ChatID: 1, AppleMusicID: "2", FileSize: 30, DownloadedAt: now.Add(-time.Minute)},
+		{UserID: 1, ChatID: 100, AppleMusicID: "3", FileSize: 40, DownloadedAt: now},
+	} {
+		if err := history.Add(record); err != nil {
+			t.Fatalf("Add(%d) error = %v", i, err)
+		}
+	}
+
+	records, err := history.Recent(1, 2)
+	if err != nil {
+		t.Fatalf("Recent() error = %v", err)
+	}
+	if len(records) != 2 || records[0].AppleMusicID != "3" || records[1].AppleMusicID != "2" {
+		t.Errorf("Expected the user's last 2 downloads newest first, got %+v", records)
+	}
+	if records, _ := history.Recent(3, 10); len(records) != 0 {
+		t.Errorf("Expected no downloads of an unknown user, got %+v", records)
+	}
+
+	totals, err := history.Totals(now)
+	if err != nil {
+		t.Fatalf("Totals() error = %v", err)
+	}
+	want := HistoryTotals{Downloads: 4, Songs: 3, Users: 2, Chats: 3, Bytes: 100, LastDay: 3}
+	if totals != want {
+		t.Errorf("Totals() = %+v, want %+v", totals, want)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", DownloadHistoryFile)
+	history, err := NewFileHistory(path)
+	if err != nil {
+		t.Fatalf("NewFileHistory() error = %v", err)
+	}
+
+	downloadedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	record := HistoryRecord{UserID: 1, ChatID: 100, AppleMusicID: "1559523359", Title: "Song", Artist: "Artist", FileSize: 1 << 20, Quality: "24-bit / 96 kHz ALAC", ArchiveMessageID: 5, DownloadedAt: downloadedAt}
+	if err := history.Add(record); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := history.Add(HistoryRecord{UserID: 2, AppleMusicID: "2", DownloadedAt: downloadedAt}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The records are loaded again after a restart
+	loaded, err := NewFileHistory(path)
+	if err != nil {
+		t.Fatalf("NewFileHistory() error = %v", err)
+	}
+	records, _ := loaded.Recent(1, historyLimit)
+	if len(records) != 1 || records[0] != record {
+		t.Errorf("Expected the saved record back, got %+v", records)
+	}
+
+	// Unreadable lines are skipped, keeping the others
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{not json\n")
+	file.Close()
+
+	loaded, err = NewFileHistory(path)
+	if err == nil {
+		t.Error("Expected the unreadable line to be reported")
+	}
+	if totals, _ := loaded.Totals(downloadedAt); totals.Downloads != 2 {
+		t.Errorf("Expected the readable records to be kept, got %+v", totals)
+	}
+}
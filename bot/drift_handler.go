@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// maxDriftRawLength keeps a raw response shown by /drift within one message
+const maxDriftRawLength = 3500
+
+// DriftHandler implements CommandHandler for the admin /drift command, which
+// shows catalog responses with schema drift
+type DriftHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewDriftHandler creates a new DriftHandler instance
+func NewDriftHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *DriftHandler {
+	handler := &DriftHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *DriftHandler) Command() string {
+	return "drift"
+}
+
+// AdminOnly reports that only administrators may run /drift
+func (h *DriftHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /drift command: the list of anomalies without an
+// argument, the raw response of one with its number
+func (h *DriftHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /drift command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	monitor := h.songHandler.GetSchemaMonitor()
+	anomalies := monitor.Anomalies()
+
+	args := strings.TrimSpace(cmdCtx.Args)
+	if args == "" {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createDriftMessage(monitor.DriftCount(), anomalies, time.Now()))
+	}
+
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 || n > len(anomalies) {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, fmt.Sprintf("❌ Usage: /drift [1-%d]", max(len(anomalies), 1)))
+	}
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createDriftRawMessage(n, anomalies[n-1]))
+}
+
+// createDriftMessage lists the kept anomalies, newest first
+func createDriftMessage(total int64, anomalies []downloader.SchemaAnomaly, now time.Time) string {
+	if total == 0 {
+		return "✅ No catalog schema drift since the bot started."
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "⚠️ **Catalog schema drift:** %d responses since the bot started\n\n", total)
+	for i, anomaly := range anomalies {
+		fmt.Fprintf(&message, "%d. %s ago · %s\n", i+1, now.Sub(anomaly.Time).Round(time.Second), anomaly.Endpoint)
+		fmt.Fprintf(&message, "   Missing: %s\n", strings.Join(anomaly.Missing, ", "))
+		fmt.Fprintf(&message, "   Top-level keys: %s\n", strings.Join(anomaly.TopLevelKeys, ", "))
+	}
+	message.WriteString("\nUse /drift <number> to see the raw response.")
+
+	return message.String()
+}
+
+// createDriftRawMessage shows the raw response of an anomaly, cut to fit in
+// one message
+func createDriftRawMessage(n int, anomaly downloader.SchemaAnomaly) string {
+	raw := string(anomaly.Raw)
+	if len(raw) > maxDriftRawLength {
+		cut := maxDriftRawLength
+		for cut > 0 && !utf8.RuneStart(raw[cut]) {
+			cut--
+		}
+		raw = raw[:cut] + fmt.Sprintf("… (%d of %d bytes)", cut, len(anomaly.Raw))
+	}
+	return fmt.Sprintf("🧾 Response %d from %s at %s:\n\n%s", n, anomaly.Endpoint, anomaly.Time.Format(time.RFC3339), raw)
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"go-alac-bot/downloader"
+)
+
+func TestCreateDriftMessage(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := createDriftMessage(0, nil, now); !strings.HasPrefix(got, "✅") {
+		t.Errorf("Expected the all-clear without drift, got %q", got)
+	}
+
+	anomalies := []downloader.SchemaAnomaly{{
+		Time:         now.Add(-3 * time.Minute),
+		Endpoint:     "/v1/catalog/us/songs/1",
+		Missing:      []string{"attributes.extendedAssetUrls"},
+		TopLevelKeys: []string{"data", "meta"},
+	}}
+	got := createDriftMessage(4, anomalies, now)
+	for _, want := range []string{"4 responses", "1. 3m0s ago · /v1/catalog/us/songs/1", "Missing: attributes.extendedAssetUrls", "Top-level keys: data, meta", "/drift <number>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in %q", want, got)
+		}
+	}
+}
+
+func TestCreateDriftRawMessage(t *testing.T) {
+	anomaly := downloader.SchemaAnomaly{Endpoint: "/v1/catalog/jp/songs/1", Raw: []byte(`{"data":[]}`)}
+	if got := createDriftRawMessage(1, anomaly); !strings.HasSuffix(got, "\n\n{\"data\":[]}") {
+		t.Errorf("Expected the whole raw response, got %q", got)
+	}
+
+	// Long responses are cut on a character boundary
+	anomaly.Raw = []byte(strings.Repeat("夜", maxDriftRawLength))
+	got := createDriftRawMessage(1, anomaly)
+	if !utf8.ValidString(got) {
+		t.Error("Expected the cut response to stay valid UTF-8")
+	}
+	if len(got) > maxDriftRawLength+200 || !strings.Contains(got, "bytes)") {
+		t.Errorf("Expected the response to be cut with a note, got %d bytes", len(got))
+	}
+}
@@ -0,0 +1,14 @@
+package bot
+
+import "go-alac-bot/downloader"
+
+// utf16Len returns the length of s as counted by Telegram, in UTF-16 code
+// units. Entity offsets and lengths and message limits all use this unit
+func utf16Len(s string) int {
+	return downloader.UTF16Len(s)
+}
+
+// utf16Offset returns the entity offset of the byte index i of s
+func utf16Offset(s string, i int) int {
+	return utf16Len(s[:i])
+}
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// entitySpans decodes entities the way Telegram does, as UTF-16 ranges of
+// text, and returns what each one covers prefixed with its kind
+func entitySpans(t *testing.T, text string, entities []tg.MessageEntityClass) []string {
+	t.Helper()
+
+	units := utf16.Encode([]rune(text))
+	lowSurrogate := func(i int) bool { return i < len(units) && units[i] >= 0xDC00 && units[i] <= 0xDFFF }
+
+	var spans []string
+	for _, entity := range entities {
+		offset, length := entity.GetOffset(), entity.GetLength()
+		if offset < 0 || length <= 0 || offset+length > len(units) {
+			t.Fatalf("Entity %T [%d, %d) is outside the %d UTF-16 units of %q", entity, offset, offset+length, len(units), text)
+		}
+		if lowSurrogate(offset) || lowSurrogate(offset+length) {
+			t.Errorf("Entity %T [%d, %d) splits a surrogate pair", entity, offset, offset+length)
+		}
+
+		kind := "?"
+		switch entity.(type) {
+		case *tg.MessageEntityBold:
+			kind = "bold"
+		case *tg.MessageEntityCode:
+			kind = "code"
+		case *tg.MessageEntityPre:
+			kind = "pre"
+		case *tg.MessageEntityTextURL:
+			kind = "link"
+		}
+		spans = append(spans, kind+":"+string(utf16.Decode(units[offset:offset+length])))
+	}
+	return spans
+}
+
+func TestParseMarkdown_UTF16(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantPlain string
+		wantSpans []string
+	}{
+		{
+			name:      "ASCII",
+			text:      "Chat id: `12345`",
+			wantPlain: "Chat id: 12345",
+			wantSpans: []string{"code:12345"},
+		},
+		{
+			name:      "emoji before the span",
+			text:      "🎵🎶 id: `-100123`",
+			wantPlain: "🎵🎶 id: -100123",
+			wantSpans: []string{"code:-100123"},
+		},
+		{
+			name:      "CJK inside the span",
+			text:      "曲名: `夜に駆ける` by *YOASOBI*",
+			wantPlain: "曲名: 夜に駆ける by YOASOBI",
+			wantSpans: []string{"code:夜に駆ける", "bold:YOASOBI"},
+		},
+		{
+			name:      "emoji and combining characters inside spans",
+			text:      "*Café* `🇯🇵 été 👩‍👩‍👧` done",
+			wantPlain: "Café 🇯🇵 été 👩‍👩‍👧 done",
+			wantSpans: []string{"bold:Café", "code:🇯🇵 été 👩‍👩‍👧"},
+		},
+		{
+			name:      "unclosed and empty markers",
+			text:      "a `` b *c",
+			wantPlain: "a  b *c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, entities := ParseMarkdown(tt.text)
+			if plain != tt.wantPlain {
+				t.Errorf("plain = %q, want %q", plain, tt.wantPlain)
+			}
+			if got := entitySpans(t, plain, entities); strings.Join(got, "|") != strings.Join(tt.wantSpans, "|") {
+				t.Errorf("spans = %q, want %q", got, tt.wantSpans)
+			}
+		})
+	}
+}
+
+func TestUTF16Helpers(t *testing.T) {
+	s := "a😀é夜"
+	if got := utf16Len(s); got != 5 {
+		t.Errorf("utf16Len(%q) = %d, want 5", s, got)
+	}
+	if got := utf16Offset(s, strings.Index(s, "夜")); got != 4 {
+		t.Errorf("utf16Offset() = %d, want 4", got)
+	}
+}
+
+func TestHelpHandler_EntitiesMatchText(t *testing.T) {
+	handler := NewHelpHandler(nil, logging.Discard())
+	message := handler.createHelpMessage()
+
+	plain, entities := ParseMarkdown(message)
+	spans := entitySpans(t, plain, entities)
+	if len(spans) == 0 {
+		t.Fatal("Expected the help message to have entities")
+	}
+
+	// The emoji in the queue section come before the examples, so byte
+	// offsets would shift every example span
+	for _, span := range spans {
+		kind, covered, _ := strings.Cut(span, ":")
+		switch kind {
+		case "code":
+			if !strings.HasPrefix(covered, "/") {
+				t.Errorf("Expected every code span to be a command, got %q", covered)
+			}
+		case "bold":
+			if strings.ContainsAny(covered, "*`\n") {
+				t.Errorf("Expected bold spans to be headings, got %q", covered)
+			}
+		}
+	}
+}
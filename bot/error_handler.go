@@ -3,14 +3,15 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"math"
 	"net"
+	"os"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/gotd/td/tg"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
 )
 
 // ErrorType represents different categories of errors
@@ -50,15 +51,17 @@ type ErrorContext struct {
 
 // ErrorHandler provides centralized error management for the bot
 type ErrorHandler struct {
-	logger *log.Logger
+	logger logging.Logger
 	client *TelegramBot
+	sender MessageSender
 }
 
 // NewErrorHandler creates a new ErrorHandler instance
-func NewErrorHandler(logger *log.Logger, client *TelegramBot) *ErrorHandler {
+func NewErrorHandler(logger logging.Logger, client *TelegramBot) *ErrorHandler {
 	return &ErrorHandler{
 		logger: logger,
 		client: client,
+		sender: client,
 	}
 }
 
@@ -67,7 +70,7 @@ func NewErrorHandler(logger *log.Logger, client *TelegramBot) *ErrorHandler {
 func (e *ErrorHandler) HandleConfigError(err error) {
 	e.logStructuredError(ErrorTypeConfiguration, err, nil, "Configuration error occurred")
 	// Configuration errors are fatal - log and exit
-	e.logger.Fatalf("FATAL: Configuration error: %v", err)
+	os.Exit(1)
 }
 
 // HandleNetworkError handles network-related errors with retry logic
@@ -105,8 +108,8 @@ func (e *ErrorHandler) HandleCommandError(err error, cmdCtx *CommandContext) {
 	
 	// Send user-friendly error message
 	if err := e.sendUserErrorMessage(cmdCtx.ChatID, err, errorCtx.CorrelationID); err != nil {
-		e.logger.Printf("ERROR: Failed to send error message to user (chat: %d, correlation: %s): %v", 
-			cmdCtx.ChatID, errorCtx.CorrelationID, err)
+		e.logger.Error("Failed to send error message to user", logging.Int64("Chat", cmdCtx.ChatID),
+			logging.String("Correlation", errorCtx.CorrelationID), logging.Err(err))
 	}
 }
 
@@ -125,44 +128,38 @@ func (e *ErrorHandler) HandleRuntimeError(err error) {
 
 // logStructuredError logs errors with structured information
 func (e *ErrorHandler) logStructuredError(errorType ErrorType, err error, ctx *ErrorContext, message string) {
-	logEntry := fmt.Sprintf("[%s] %s: %v", errorType.String(), message, err)
+	fields := []logging.Field{logging.String("Type", errorType.String()), logging.Err(err)}
 	
 	if ctx != nil {
-		logEntry += fmt.Sprintf(" | Correlation: %s | Timestamp: %s", 
-			ctx.CorrelationID, ctx.Timestamp.Format(time.RFC3339))
+		fields = append(fields, logging.String("Correlation", ctx.CorrelationID),
+			logging.String("Timestamp", ctx.Timestamp.Format(time.RFC3339)))
 		
 		if ctx.UserID != 0 {
-			logEntry += fmt.Sprintf(" | User: %d", ctx.UserID)
+			fields = append(fields, logging.Int64("User", ctx.UserID))
 		}
 		
 		if ctx.ChatID != 0 {
-			logEntry += fmt.Sprintf(" | Chat: %d", ctx.ChatID)
+			fields = append(fields, logging.Int64("Chat", ctx.ChatID))
 		}
 		
 		if ctx.Command != "" {
-			logEntry += fmt.Sprintf(" | Command: /%s", ctx.Command)
+			fields = append(fields, logging.String("Command", "/"+ctx.Command))
 		}
 	}
 	
 	// Log based on error severity
 	switch errorType {
 	case ErrorTypeConfiguration:
-		e.logger.Printf("FATAL: %s", logEntry)
-	case ErrorTypeNetwork, ErrorTypeRuntime:
-		e.logger.Printf("ERROR: %s", logEntry)
+		e.logger.Fatal(message, fields...)
 	case ErrorTypeCommand:
-		e.logger.Printf("WARN: %s", logEntry)
+		e.logger.Warn(message, fields...)
 	default:
-		e.logger.Printf("ERROR: %s", logEntry)
+		e.logger.Error(message, fields...)
 	}
 }
 
 // sendUserErrorMessage sends a user-friendly error message to the chat
 func (e *ErrorHandler) sendUserErrorMessage(chatID int64, err error, correlationID string) error {
-	if e.client == nil || e.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not available")
-	}
-	
 	// Create user-friendly error message based on error type
 	userMessage := e.createUserFriendlyMessage(err, correlationID)
 	
@@ -170,24 +167,8 @@ func (e *ErrorHandler) sendUserErrorMessage(chatID int64, err error, correlation
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
-	// Determine peer type for the chat
-	var peer tg.InputPeerClass
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-	
-	// Create and send the error message
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  userMessage,
-		RandomID: time.Now().UnixNano(),
-	}
-	
-	_, err = e.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send error message via Telegram API: %w", err)
+	if err := e.sender.SendMarkdown(ctx, chatID, userMessage); err != nil {
+		return fmt.Errorf("failed to send error message: %w", err)
 	}
 	
 	return nil
@@ -200,7 +181,22 @@ func (e *ErrorHandler) createUserFriendlyMessage(err error, correlationID string
 	
 	var userMessage string
 	
+	floodWait, isFloodWait := downloader.IsFloodWait(err)
+	downloadErr, isDownloadErr := downloader.AsDownloadError(err)
+	
+	// Download errors say what went wrong; keywords are only guessed from
+	// other errors
 	switch {
+	case downloader.IsDeviceTimeout(err):
+		userMessage = "⏱️ The device service timed out. Please try again in a moment."
+	case isDownloadErr:
+		userMessage = "❌ " + downloadErr.UserMessage() + "."
+	case isFloodWait:
+		userMessage = fmt.Sprintf("🚦 Telegram is rate limiting me right now. Please try again in %s.", floodWait.Round(time.Second))
+	case downloader.IsMediaForbidden(err):
+		userMessage = "🔒 I'm not allowed to send messages or media in this chat. Please check my permissions."
+	case downloader.IsPeerInvalid(err):
+		userMessage = "🔍 I can't reach this chat. Please make sure I'm still a member and try again."
 	case strings.Contains(errorMsg, "network") || strings.Contains(errorMsg, "connection"):
 		userMessage = "🌐 I'm having trouble connecting to Telegram's servers. Please try again in a moment."
 	case strings.Contains(errorMsg, "timeout"):
@@ -217,7 +213,7 @@ func (e *ErrorHandler) createUserFriendlyMessage(err error, correlationID string
 	
 	// Add correlation ID for debugging (only show first 8 characters)
 	if len(correlationID) >= 8 {
-		userMessage += fmt.Sprintf("\n\n🔧 Error ID: %s", correlationID[:8])
+		userMessage += fmt.Sprintf("\n\n🔧 Error ID: `%s`", correlationID[:8])
 	}
 	
 	return userMessage
@@ -237,14 +233,19 @@ func (e *ErrorHandler) retryWithBackoff(operation func() error, maxRetries int,
 				delay = 30 * time.Second
 			}
 			
-			e.logger.Printf("INFO: Retrying operation in %v (attempt %d/%d)", delay, attempt+1, maxRetries+1)
+			// Never retry sooner than Telegram asked us to
+			if floodWait, ok := downloader.IsFloodWait(lastErr); ok && floodWait > delay {
+				delay = floodWait
+			}
+			
+			e.logger.Info("Retrying operation", logging.Duration("Delay", delay), logging.Int("Attempt", attempt+1), logging.Int("Attempts", maxRetries+1))
 			time.Sleep(delay)
 		}
 		
 		err := operation()
 		if err == nil {
 			if attempt > 0 {
-				e.logger.Printf("INFO: Operation succeeded after %d retries", attempt)
+				e.logger.Info("Operation succeeded after retrying", logging.Int("Retries", attempt))
 			}
 			return nil
 		}
@@ -253,11 +254,11 @@ func (e *ErrorHandler) retryWithBackoff(operation func() error, maxRetries int,
 		
 		// Check if error is retryable
 		if !e.isRetryableError(err) {
-			e.logger.Printf("WARN: Non-retryable error encountered: %v", err)
+			e.logger.Warn("Non-retryable error encountered", logging.Err(err))
 			break
 		}
 		
-		e.logger.Printf("WARN: Operation failed (attempt %d/%d): %v", attempt+1, maxRetries+1, err)
+		e.logger.Warn("Operation failed", logging.Int("Attempt", attempt+1), logging.Int("Attempts", maxRetries+1), logging.Err(err))
 	}
 	
 	return fmt.Errorf("operation failed after %d retries: %w", maxRetries+1, lastErr)
@@ -265,10 +266,25 @@ func (e *ErrorHandler) retryWithBackoff(operation func() error, maxRetries int,
 
 // isRetryableError determines if an error is retryable
 func (e *ErrorHandler) isRetryableError(err error) bool {
+	return IsRetryableError(err)
+}
+
+// IsRetryableError reports whether an operation that failed with err may
+// succeed when tried again, as after flood waits, network failures and timeouts
+func IsRetryableError(err error) bool {
 	if err == nil {
 		return false
 	}
 	
+	// Flood waits are retryable once the requested wait has elapsed
+	if _, ok := downloader.IsFloodWait(err); ok {
+		return true
+	}
+	
+	if downloadErr, ok := downloader.AsDownloadError(err); ok {
+		return downloadErr.IsRetryable()
+	}
+	
 	errorMsg := strings.ToLower(err.Error())
 	
 	// Network errors that are typically retryable
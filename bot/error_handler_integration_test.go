@@ -3,12 +3,12 @@ package bot
 import (
 	"context"
 	"errors"
-	"log"
 	"strings"
 	"testing"
 	"time"
 
 	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 func TestErrorHandlerIntegration(t *testing.T) {
@@ -22,7 +22,7 @@ func TestErrorHandlerIntegration(t *testing.T) {
 	
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "TEST: ", log.LstdFlags)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	// Create bot with error handler
 	bot, err := NewTelegramBot(cfg, logger)
@@ -77,7 +77,7 @@ func TestErrorHandlerWithRouter(t *testing.T) {
 	
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "TEST: ", log.LstdFlags)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	// Create bot with error handler
 	bot, err := NewTelegramBot(cfg, logger)
@@ -126,7 +126,7 @@ func TestErrorHandlerWithRouter(t *testing.T) {
 func TestErrorHandlerNetworkRetry(t *testing.T) {
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "TEST: ", log.LstdFlags)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	errorHandler := NewErrorHandler(logger, nil)
 	
@@ -171,7 +171,7 @@ func TestErrorHandlerNetworkRetry(t *testing.T) {
 func TestErrorHandlerPanicRecovery(t *testing.T) {
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "TEST: ", log.LstdFlags)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	errorHandler := NewErrorHandler(logger, nil)
 	
@@ -2,17 +2,20 @@ package bot
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net"
 	"os"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
 )
 
 func TestNewErrorHandler(t *testing.T) {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	client := &TelegramBot{}
 	
 	handler := NewErrorHandler(logger, client)
@@ -53,7 +56,7 @@ func TestErrorType_String(t *testing.T) {
 func TestHandleRuntimeError(t *testing.T) {
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "", 0)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	handler := NewErrorHandler(logger, nil)
 	
@@ -61,7 +64,11 @@ func TestHandleRuntimeError(t *testing.T) {
 	handler.HandleRuntimeError(testError)
 	
 	logContent := logOutput.String()
-	if !strings.Contains(logContent, "RUNTIME") {
+	if !strings.HasPrefix(logContent, "ERROR: Runtime error occurred") {
+		t.Errorf("Runtime errors should be logged at ERROR level, got %q", logContent)
+	}
+	
+	if !strings.Contains(logContent, "Type: RUNTIME") {
 		t.Error("Log should contain RUNTIME error type")
 	}
 	
@@ -77,7 +84,7 @@ func TestHandleRuntimeError(t *testing.T) {
 func TestHandleCommandError(t *testing.T) {
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "", 0)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	handler := NewErrorHandler(logger, nil)
 	
@@ -91,7 +98,11 @@ func TestHandleCommandError(t *testing.T) {
 	handler.HandleCommandError(testError, cmdCtx)
 	
 	logContent := logOutput.String()
-	if !strings.Contains(logContent, "COMMAND") {
+	if !strings.HasPrefix(logContent, "WARN: Command processing error occurred") {
+		t.Errorf("Command errors should be logged at WARN level, got %q", logContent)
+	}
+	
+	if !strings.Contains(logContent, "Type: COMMAND") {
 		t.Error("Log should contain COMMAND error type")
 	}
 	
@@ -113,7 +124,7 @@ func TestHandleCommandError(t *testing.T) {
 }
 
 func TestIsRetryableError(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	tests := []struct {
 		name     string
@@ -160,6 +171,16 @@ func TestIsRetryableError(t *testing.T) {
 			err:      errors.New("permission denied"),
 			expected: false,
 		},
+		{
+			name:     "wrapped download network failure",
+			err:      fmt.Errorf("download failed: %w", downloader.NewDownloadError(downloader.ErrorNetworkFailure, "failed to fetch metadata")),
+			expected: true,
+		},
+		{
+			name:     "download error type wins over keywords",
+			err:      downloader.NewDownloadErrorWithCause(downloader.ErrorALACNotAvailable, "no ALAC stream", errors.New("connection reset")),
+			expected: false,
+		},
 	}
 	
 	for _, test := range tests {
@@ -173,7 +194,7 @@ func TestIsRetryableError(t *testing.T) {
 }
 
 func TestIsNetworkError(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	tests := []struct {
 		name     string
@@ -223,7 +244,7 @@ func TestIsNetworkError(t *testing.T) {
 }
 
 func TestCreateUserFriendlyMessage(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	tests := []struct {
 		name           string
@@ -261,6 +282,36 @@ func TestCreateUserFriendlyMessage(t *testing.T) {
 			correlationID:  "12345678",
 			expectedSubstr: "🔍 The requested resource was not found",
 		},
+		{
+			name:           "flood wait error",
+			err:            errors.New("rpc error code 420: FLOOD_WAIT (30)"),
+			correlationID:  "12345678",
+			expectedSubstr: "🚦 Telegram is rate limiting me right now. Please try again in 30s",
+		},
+		{
+			name:           "media forbidden error",
+			err:            errors.New("rpc error code 403: CHAT_SEND_MEDIA_FORBIDDEN"),
+			correlationID:  "12345678",
+			expectedSubstr: "🔒 I'm not allowed to send messages or media",
+		},
+		{
+			name:           "wrapped download error",
+			err:            fmt.Errorf("download failed: %w", downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "no ALAC stream in the master playlist")),
+			correlationID:  "12345678",
+			expectedSubstr: "❌ This song isn't available in lossless ALAC.",
+		},
+		{
+			name:           "download error with network cause",
+			err:            downloader.NewDownloadErrorWithCause(downloader.ErrorDecryptionFailure, "decryptor closed the connection", errors.New("connection reset")),
+			correlationID:  "12345678",
+			expectedSubstr: "❌ The song couldn't be decrypted",
+		},
+		{
+			name:           "device timeout",
+			err:            downloader.NewDownloadErrorWithCause(downloader.ErrorTimeout, "device service timed out", &downloader.DeviceError{Op: "read", Err: os.ErrDeadlineExceeded}),
+			correlationID:  "12345678",
+			expectedSubstr: "⏱️ The device service timed out",
+		},
 		{
 			name:           "generic error",
 			err:            errors.New("something went wrong"),
@@ -289,7 +340,7 @@ func TestCreateUserFriendlyMessage(t *testing.T) {
 }
 
 func TestGenerateCorrelationID(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	// Generate multiple correlation IDs
 	id1 := handler.generateCorrelationID()
@@ -317,7 +368,7 @@ func TestGenerateCorrelationID(t *testing.T) {
 }
 
 func TestRetryWithBackoff(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	t.Run("successful operation", func(t *testing.T) {
 		callCount := 0
@@ -402,7 +453,7 @@ func TestRetryWithBackoff(t *testing.T) {
 func TestRecoverFromPanic(t *testing.T) {
 	// Capture log output
 	var logOutput strings.Builder
-	logger := log.New(&logOutput, "", 0)
+	logger := logging.New(&logOutput, logging.LevelDebug)
 	
 	handler := NewErrorHandler(logger, nil)
 	
@@ -438,7 +489,7 @@ func (e *mockNetError) Timeout() bool   { return e.timeout }
 func (e *mockNetError) Temporary() bool { return e.temporary }
 
 func TestIsRetryableError_NetworkErrors(t *testing.T) {
-	handler := NewErrorHandler(log.New(os.Stdout, "", 0), nil)
+	handler := NewErrorHandler(logging.New(os.Stdout, logging.LevelDebug), nil)
 	
 	tests := []struct {
 		name     string
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// maxErrorMessageLength keeps each /errors entry short enough for all of them
+// to fit in one Telegram message
+const maxErrorMessageLength = 150
+
+// ErrorsHandler implements CommandHandler for the admin /errors command
+type ErrorsHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	errorLog     *logging.Deduplicator
+}
+
+// NewErrorsHandler creates a new ErrorsHandler showing the errors recorded by errorLog
+func NewErrorsHandler(client *TelegramBot, logger logging.Logger, errorLog *logging.Deduplicator) *ErrorsHandler {
+	handler := &ErrorsHandler{
+		client:   client,
+		sender:   client,
+		logger:   logger,
+		errorLog: errorLog,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *ErrorsHandler) Command() string {
+	return "errors"
+}
+
+// AdminOnly reports that only administrators may run /errors
+func (h *ErrorsHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /errors command and lists the most recent unique errors
+func (h *ErrorsHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /errors command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if h.errorLog == nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Error tracking is not available.")
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createErrorsMessage(h.errorLog.RecentErrors(), time.Now()))
+}
+
+// createErrorsMessage creates the list of recent unique errors shown by /errors
+func createErrorsMessage(errors []logging.ErrorSummary, now time.Time) string {
+	if len(errors) == 0 {
+		return "✅ No errors recorded since the bot started."
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "🧾 **Recent Errors (%d unique):**\n\n", len(errors))
+
+	for i, summary := range errors {
+		text := summary.Message
+		if runes := []rune(text); len(runes) > maxErrorMessageLength {
+			text = string(runes[:maxErrorMessageLength]) + "…"
+		}
+
+		fmt.Fprintf(&message, "%d. ×%d %s\n", i+1, summary.Count, text)
+		fmt.Fprintf(&message, "   Last seen %s ago", now.Sub(summary.LastSeen).Round(time.Second))
+		if n := len(summary.CorrelationIDs); n > 0 {
+			fmt.Fprintf(&message, " · ID `%s`", summary.CorrelationIDs[n-1])
+		}
+		message.WriteString("\n")
+	}
+
+	return message.String()
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+func TestErrorsHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewErrorsHandler(nil, logger, nil)
+
+	expected := "errors"
+	if got := handler.Command(); got != expected {
+		t.Errorf("ErrorsHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateErrorsMessage(t *testing.T) {
+	now := time.Now()
+
+	if message := createErrorsMessage(nil, now); !strings.Contains(message, "No errors recorded") {
+		t.Errorf("Expected empty message, got %q", message)
+	}
+
+	errors := []logging.ErrorSummary{
+		{
+			Level:          "ERROR",
+			Message:        "ERROR: [NETWORK] Network error occurred: connection refused",
+			Count:          47,
+			LastSeen:       now.Add(-30 * time.Second),
+			CorrelationIDs: []string{"old-id", "new-id"},
+		},
+		{
+			Level:    "WARN",
+			Message:  "WARN: " + strings.Repeat("x", 500),
+			Count:    1,
+			LastSeen: now,
+		},
+	}
+
+	message := createErrorsMessage(errors, now)
+	if !strings.Contains(message, "2 unique") {
+		t.Errorf("Expected unique count, got %q", message)
+	}
+	if !strings.Contains(message, "1. ×47 ERROR: [NETWORK]") || !strings.Contains(message, "30s ago") {
+		t.Errorf("Expected first error with count and age, got %q", message)
+	}
+	if !strings.Contains(message, "`new-id`") || strings.Contains(message, "old-id") {
+		t.Errorf("Expected only the latest correlation ID, got %q", message)
+	}
+	if strings.Contains(message, strings.Repeat("x", maxErrorMessageLength+1)) {
+		t.Errorf("Expected long messages to be truncated, got %q", message)
+	}
+}
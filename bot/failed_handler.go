@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// FailedHandler implements CommandHandler for the /failed command
+type FailedHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewFailedHandler creates a new FailedHandler instance
+func NewFailedHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *FailedHandler {
+	handler := &FailedHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *FailedHandler) Command() string {
+	return "failed"
+}
+
+// Handle processes the /failed command and lists the caller's failed requests
+func (h *FailedHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /failed command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Queue system is not available.")
+	}
+
+	entries := queue.Failed().List(cmdCtx.ChatID, cmdCtx.UserID)
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createFailedListMessage(entries))
+}
+
+// createFailedListMessage creates the numbered list shown by /failed
+func createFailedListMessage(entries []*FailedRequest) string {
+	if len(entries) == 0 {
+		return "✅ You have no recently failed requests."
+	}
+
+	message := fmt.Sprintf("📋 **Recently Failed Requests (%d):**\n\n", len(entries))
+	for i, entry := range entries {
+		message += fmt.Sprintf("%d. %s\n", i+1, entry.Request.URL)
+		message += fmt.Sprintf("   ❌ %s (%s ago)\n", entry.Reason, time.Since(entry.FailedAt).Round(time.Second))
+	}
+	message += "\n💡 Use `/retry` for the most recent one or `/retry <n>` for a specific one"
+
+	return message
+}
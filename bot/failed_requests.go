@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go-alac-bot/config"
+)
+
+// MaxFailedPerChat is how many failed requests are remembered per chat
+const MaxFailedPerChat = 10
+
+// FailedRequest is a failed queue request kept around for /retry
+type FailedRequest struct {
+	Request  *QueueRequest
+	Reason   string
+	FailedAt time.Time
+}
+
+// FailedRequests keeps the most recent failed requests of each chat
+type FailedRequests struct {
+	mu     sync.Mutex
+	byChat map[int64][]*FailedRequest
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewFailedRequests creates a failed request list whose entries expire after ttl
+func NewFailedRequests(ttl time.Duration) *FailedRequests {
+	if ttl <= 0 {
+		ttl = config.DefaultFailedRequestTTL
+	}
+
+	return &FailedRequests{
+		byChat: make(map[int64][]*FailedRequest),
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Record adds a failed request to its chat's list, dropping the oldest entry
+// when the list is full
+func (f *FailedRequests) Record(request *QueueRequest, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := f.pruneLocked(request.ChatID)
+
+	// Newest first, and only one entry per request
+	updated := []*FailedRequest{{Request: request, Reason: reason, FailedAt: f.now()}}
+	for _, entry := range entries {
+		if entry.Request.UniqueID != request.UniqueID {
+			updated = append(updated, entry)
+		}
+	}
+	if len(updated) > MaxFailedPerChat {
+		updated = updated[:MaxFailedPerChat]
+	}
+
+	f.byChat[request.ChatID] = updated
+}
+
+// List returns the unexpired failed requests of a user in a chat, newest first
+func (f *FailedRequests) List(chatID, senderID int64) []*FailedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*FailedRequest
+	for _, entry := range f.pruneLocked(chatID) {
+		if entry.Request.SenderID == senderID {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Take removes and returns the nth (1-based) entry of List(chatID, senderID)
+func (f *FailedRequests) Take(chatID, senderID int64, n int) (*FailedRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := f.pruneLocked(chatID)
+
+	position := 0
+	for i, entry := range entries {
+		if entry.Request.SenderID != senderID {
+			continue
+		}
+
+		position++
+		if position == n {
+			f.byChat[chatID] = append(entries[:i:i], entries[i+1:]...)
+			return entry, nil
+		}
+	}
+
+	if position == 0 {
+		return nil, fmt.Errorf("no failed requests to retry")
+	}
+	return nil, fmt.Errorf("there is no failed request #%d (you have %d)", n, position)
+}
+
+// Find returns the unexpired entry of the request with uniqueID
+func (f *FailedRequests) Find(chatID int64, uniqueID string) (*FailedRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, entry := range f.pruneLocked(chatID) {
+		if entry.Request.UniqueID == uniqueID {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// TakeRequest removes and returns the unexpired entry of the request with uniqueID
+func (f *FailedRequests) TakeRequest(chatID int64, uniqueID string) (*FailedRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := f.pruneLocked(chatID)
+	for i, entry := range entries {
+		if entry.Request.UniqueID == uniqueID {
+			f.byChat[chatID] = append(entries[:i:i], entries[i+1:]...)
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// pruneLocked drops expired entries of a chat and returns the rest
+// (must be called with lock held)
+func (f *FailedRequests) pruneLocked(chatID int64) []*FailedRequest {
+	entries := f.byChat[chatID]
+	cutoff := f.now().Add(-f.ttl)
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.FailedAt.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(f.byChat, chatID)
+		return nil
+	}
+
+	f.byChat[chatID] = kept
+	return kept
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newFailedTestRequest(senderID, chatID int64, messageID int) *QueueRequest {
+	return &QueueRequest{
+		UniqueID:  GenerateUniqueID(senderID, chatID, messageID),
+		SenderID:  senderID,
+		ChatID:    chatID,
+		MessageID: messageID,
+		URL:       fmt.Sprintf("https://music.apple.com/in/song/test/%d", messageID),
+	}
+}
+
+func TestFailedRequests_RecordAndList(t *testing.T) {
+	failed := NewFailedRequests(time.Hour)
+
+	failed.Record(newFailedTestRequest(1, 100, 1), "first")
+	failed.Record(newFailedTestRequest(1, 100, 2), "second")
+	failed.Record(newFailedTestRequest(2, 100, 3), "other user")
+	failed.Record(newFailedTestRequest(1, 200, 4), "other chat")
+
+	entries := failed.List(100, 1)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Reason != "second" || entries[1].Reason != "first" {
+		t.Errorf("Expected newest first, got %q then %q", entries[0].Reason, entries[1].Reason)
+	}
+
+	// Recording the same request again replaces its entry
+	failed.Record(newFailedTestRequest(1, 100, 1), "first again")
+	entries = failed.List(100, 1)
+	if len(entries) != 2 || entries[0].Reason != "first again" {
+		t.Errorf("Expected re-recorded request to move to the top, got %d entries", len(entries))
+	}
+}
+
+func TestFailedRequests_BoundedPerChat(t *testing.T) {
+	failed := NewFailedRequests(time.Hour)
+
+	for i := 1; i <= MaxFailedPerChat+5; i++ {
+		failed.Record(newFailedTestRequest(1, 100, i), fmt.Sprintf("failure %d", i))
+	}
+
+	entries := failed.List(100, 1)
+	if len(entries) != MaxFailedPerChat {
+		t.Fatalf("Expected %d entries, got %d", MaxFailedPerChat, len(entries))
+	}
+	if entries[0].Request.MessageID != MaxFailedPerChat+5 {
+		t.Errorf("Expected the newest entry to be kept, got message %d", entries[0].Request.MessageID)
+	}
+}
+
+func TestFailedRequests_Take(t *testing.T) {
+	failed := NewFailedRequests(time.Hour)
+
+	failed.Record(newFailedTestRequest(1, 100, 1), "first")
+	failed.Record(newFailedTestRequest(2, 100, 2), "other user")
+	failed.Record(newFailedTestRequest(1, 100, 3), "third")
+
+	// Numbering only counts the caller's own entries
+	entry, err := failed.Take(100, 1, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entry.Request.MessageID != 1 {
+		t.Errorf("Expected entry #2 to be message 1, got %d", entry.Request.MessageID)
+	}
+
+	if _, err := failed.Take(100, 1, 2); err == nil {
+		t.Error("Expected error for an out of range entry")
+	}
+
+	if remaining := failed.List(100, 1); len(remaining) != 1 || remaining[0].Request.MessageID != 3 {
+		t.Errorf("Expected only message 3 to remain, got %v", remaining)
+	}
+
+	if _, err := failed.Take(100, 3, 1); err == nil {
+		t.Error("Expected error for a user without failed requests")
+	}
+}
+
+func TestFailedRequests_Expiry(t *testing.T) {
+	failed := NewFailedRequests(time.Hour)
+
+	now := time.Now()
+	failed.now = func() time.Time { return now }
+	failed.Record(newFailedTestRequest(1, 100, 1), "old")
+
+	now = now.Add(30 * time.Minute)
+	failed.Record(newFailedTestRequest(1, 100, 2), "recent")
+
+	now = now.Add(45 * time.Minute)
+	entries := failed.List(100, 1)
+	if len(entries) != 1 || entries[0].Reason != "recent" {
+		t.Fatalf("Expected only the recent entry to survive, got %d entries", len(entries))
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := failed.Take(100, 1, 1); err == nil {
+		t.Error("Expected expired entries to be unavailable for retry")
+	}
+}
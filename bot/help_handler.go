@@ -3,23 +3,24 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/gotd/td/tg"
+	"go-alac-bot/logging"
 )
 
 // HelpHandler implements CommandHandler for the /help command
 type HelpHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	sender       MessageSender
+	logger       logging.Logger
 	errorHandler *ErrorHandler
 }
 
 // NewHelpHandler creates a new HelpHandler instance
-func NewHelpHandler(client *TelegramBot, logger *log.Logger) *HelpHandler {
+func NewHelpHandler(client *TelegramBot, logger logging.Logger) *HelpHandler {
 	handler := &HelpHandler{
 		client: client,
+		sender: client,
 		logger: logger,
 	}
 
@@ -40,7 +41,7 @@ func (h *HelpHandler) Command() string {
 func (h *HelpHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	startTime := time.Now()
 
-	h.logger.Printf("Processing /help command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing /help command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 
 	// Create context with timeout to meet 5-second requirement
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -50,8 +51,8 @@ func (h *HelpHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	helpMessage := h.createHelpMessage()
 
 	// Send the help message with markdown formatting
-	if err := h.sendMarkdownMessage(timeoutCtx, cmdCtx.ChatID, helpMessage); err != nil {
-		h.logger.Printf("Failed to send help message to chat %d: %v", cmdCtx.ChatID, err)
+	if err := h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, helpMessage); err != nil {
+		h.logger.Error("Failed to send help message", logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
 
 		// Use error handler if available for network errors
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
@@ -63,8 +64,8 @@ func (h *HelpHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 
 	// Log successful processing with timing
 	processingTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed /help command for user %d (took %v)",
-		cmdCtx.UserID, processingTime)
+	h.logger.Debug("Processed /help command", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Took", processingTime))
 
 	return nil
 }
@@ -74,16 +75,29 @@ func (h *HelpHandler) createHelpMessage() string {
 	return `*Available Commands*
 
 /help - Show this help message
-/id - Get chat or user ID (reply to message for user ID)
+/id [@username] - Get chat or user ID (reply to message for user and forward origin IDs)
 /song - Download a single song (queued processing)
+/cover - Get the artwork and metadata of a song or album
+/info - Show a song's details and whether it is available in ALAC
 /queue - Check current song queue status
+/my - Show your own queued, processing and recent requests
+/history - List your last downloaded songs
+/failed - List your recently failed requests
+/retry - Retry a failed request
+/cancel - Stop your downloads and clear your queued requests
+/status - Check whether downloads can currently work
+/strict - Warn about missing tags (on/off)
+/language - Set the message and tag languages
+/storefront - Set the storefront assumed for links without one
+/autodelete - Delete /song messages after delivery in groups (on/off)
+/reminders - List or cancel your release reminders
 /album - Download entire albums (WIP)
 
 *Queue System*
 
 🎵 Songs are processed one at a time in order
 📊 Maximum 7 requests can be queued
-⏱️ Use /queue to check your position
+⏱️ Use /my to check your position
 
 *Examples*
 
@@ -92,6 +106,12 @@ func (h *HelpHandler) createHelpMessage() string {
 
 ` + "`/song https://music.apple.com/in/album/never-gonna-give-you-up/1559523357?i=1559523359`" + `
 
+*AAC when a song has no ALAC:*
+` + "`/song https://music.apple.com/in/song/never-gonna-give-you-up/1559523359 aac`" + `
+
+*Tags in another language:*
+` + "`/song https://music.apple.com/jp/song/yoru-ni-kakeru/1474379093 lang=en-US`" + `
+
 *Queue status:*
 ` + "`/queue`" + `
 
@@ -100,133 +120,3 @@ func (h *HelpHandler) createHelpMessage() string {
 
 *Tip:* Tap on any URL above to copy it!`
 }
-
-// sendMarkdownMessage sends a text message with markdown formatting to the specified chat
-func (h *HelpHandler) sendMarkdownMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
-	}
-
-	// For bot API, we need to determine the correct peer type
-	// In most cases for bots, we're dealing with private chats (users)
-	var peer tg.InputPeerClass
-
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		// For now, we'll treat it as a chat (group)
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-
-	// Create message entities for markdown formatting
-	entities := h.parseMarkdownEntities(message)
-
-	// Create the message request with markdown entities
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  h.stripMarkdownSyntax(message),
-		RandomID: time.Now().UnixNano(),
-		Entities: entities,
-	}
-
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send markdown message via Telegram API: %w", err)
-	}
-
-	return nil
-}
-
-// parseMarkdownEntities parses markdown syntax and creates message entities
-func (h *HelpHandler) parseMarkdownEntities(text string) []tg.MessageEntityClass {
-	var entities []tg.MessageEntityClass
-	offset := 0
-	cleanText := ""
-
-	i := 0
-	for i < len(text) {
-		char := text[i]
-
-		switch char {
-		case '*':
-			// Find closing asterisk for bold
-			if end := h.findClosing(text, i+1, '*'); end != -1 {
-				boldText := text[i+1 : end]
-				entities = append(entities, &tg.MessageEntityBold{
-					Offset: offset,
-					Length: len(boldText),
-				})
-				cleanText += boldText
-				offset += len(boldText)
-				i = end + 1
-				continue
-			}
-		case '`':
-			// Find closing backtick for code
-			if end := h.findClosing(text, i+1, '`'); end != -1 {
-				codeText := text[i+1 : end]
-				entities = append(entities, &tg.MessageEntityCode{
-					Offset: offset,
-					Length: len(codeText),
-				})
-				cleanText += codeText
-				offset += len(codeText)
-				i = end + 1
-				continue
-			}
-		}
-
-		// Add regular character
-		cleanText += string(char)
-		offset++
-		i++
-	}
-
-	return entities
-}
-
-// findClosing finds the closing character for markdown syntax
-func (h *HelpHandler) findClosing(text string, start int, char byte) int {
-	for i := start; i < len(text); i++ {
-		if text[i] == char {
-			return i
-		}
-	}
-	return -1
-}
-
-// stripMarkdownSyntax removes markdown syntax characters from text
-func (h *HelpHandler) stripMarkdownSyntax(text string) string {
-	result := ""
-	i := 0
-
-	for i < len(text) {
-		char := text[i]
-
-		switch char {
-		case '*':
-			// Skip bold markers
-			if end := h.findClosing(text, i+1, '*'); end != -1 {
-				result += text[i+1 : end]
-				i = end + 1
-				continue
-			}
-		case '`':
-			// Skip code markers
-			if end := h.findClosing(text, i+1, '`'); end != -1 {
-				result += text[i+1 : end]
-				i = end + 1
-				continue
-			}
-		}
-
-		// Add regular character
-		result += string(char)
-		i++
-	}
-
-	return result
-}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// historyLimit is how many downloads /history lists
+const historyLimit = 10
+
+// HistoryHandler implements CommandHandler for the /history command, listing
+// the caller's last downloads. Admins see the totals of every user with
+// "/history all"
+type HistoryHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewHistoryHandler creates a new HistoryHandler instance
+func NewHistoryHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *HistoryHandler {
+	handler := &HistoryHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *HistoryHandler) Command() string {
+	return "history"
+}
+
+// Handle processes the /history command
+func (h *HistoryHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /history command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	history := h.songHandler.history
+	if strings.EqualFold(strings.TrimSpace(cmdCtx.Args), "all") {
+		if h.client == nil || !h.client.IsAdmin(cmdCtx.UserID) {
+			return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, "❌ Only admins can see the downloads of every user.")
+		}
+		totals, err := history.Totals(time.Now())
+		if err != nil {
+			h.logger.Error("Failed to count download history", logging.Err(err))
+			return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, "❌ Download history is not available right now.")
+		}
+		return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, createHistoryTotalsMessage(totals))
+	}
+
+	records, err := history.Recent(cmdCtx.UserID, historyLimit)
+	if err != nil {
+		h.logger.Error("Failed to read download history", logging.Int64("User", cmdCtx.UserID), logging.Err(err))
+		return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, "❌ Download history is not available right now.")
+	}
+	return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, createHistoryMessage(records))
+}
+
+// createHistoryMessage lists a user's downloads, newest first
+func createHistoryMessage(records []HistoryRecord) string {
+	if len(records) == 0 {
+		return "📭 You haven't downloaded any songs yet.\n\n💡 Use `/song <url>` to download one"
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "🕘 **Your Last %d Downloads**\n", len(records))
+	for _, record := range records {
+		title := record.Title
+		if title == "" {
+			title = "Song " + record.AppleMusicID
+		}
+		if record.Artist != "" {
+			title += " — " + record.Artist
+		}
+
+		details := []string{record.DownloadedAt.UTC().Format("2006-01-02")}
+		if record.Quality != "" {
+			details = append(details, record.Quality)
+		}
+		if record.FileSize > 0 {
+			details = append(details, downloader.FormatBytes(record.FileSize))
+		}
+		fmt.Fprintf(&message, "\n• %s\n   %s", title, strings.Join(details, " · "))
+	}
+	return message.String()
+}
+
+// createHistoryTotalsMessage shows the downloads of every user
+func createHistoryTotalsMessage(totals HistoryTotals) string {
+	return fmt.Sprintf("📊 **Download History**\n\n"+
+		"🎵 Songs delivered: %d (%d distinct)\n"+
+		"👤 Users: %d\n"+
+		"💬 Chats: %d\n"+
+		"💾 Total size: %s\n"+
+		"🕐 Last 24 hours: %d",
+		totals.Downloads, totals.Songs, totals.Users, totals.Chats,
+		downloader.FormatBytes(totals.Bytes), totals.LastDay)
+}
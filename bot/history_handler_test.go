@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/config"
+	"go-alac-bot/logging"
+)
+
+func TestCreateHistoryMessage(t *testing.T) {
+	if message := createHistoryMessage(nil); !strings.Contains(message, "haven't downloaded any songs") {
+		t.Errorf("Expected an empty history, got %q", message)
+	}
+
+	message := createHistoryMessage([]HistoryRecord{
+		{AppleMusicID: "2", Title: "Song", Artist: "Artist", Quality: "24-bit / 96 kHz ALAC", FileSize: 45 << 20, DownloadedAt: time.Date(2024, 5, 2, 23, 0, 0, 0, time.UTC)},
+		{AppleMusicID: "1", DownloadedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	})
+	for _, want := range []string{
+		"Your Last 2 Downloads",
+		"• Song — Artist\n   2024-05-02 · 24-bit / 96 kHz ALAC · 45.0 MB",
+		"• Song 1\n   2024-05-01",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Expected message to contain %q, got %q", want, message)
+		}
+	}
+}
+
+func TestHistoryHandler_Handle(t *testing.T) {
+	logger := logging.Discard()
+	bot, err := NewTelegramBot(&config.BotConfig{Token: "test_token", APIID: 12345, APIHash: "test_hash", AdminIDs: []int64{99}}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	songHandler := NewSongHandler(bot, logger)
+	songHandler.history = NewMemoryHistory()
+	songHandler.history.Add(HistoryRecord{UserID: 1, ChatID: 100, AppleMusicID: "1", Title: "Mine", DownloadedAt: time.Now()})
+	songHandler.history.Add(HistoryRecord{UserID: 2, ChatID: 100, AppleMusicID: "2", Title: "Theirs", DownloadedAt: time.Now()})
+
+	sender := &recordingSender{}
+	handler := NewHistoryHandler(bot, logger, songHandler)
+	handler.sender = sender
+	handle := func(userID int64, args string) string {
+		if err := handler.Handle(context.Background(), &CommandContext{UserID: userID, ChatID: 100, MessageID: 7, Args: args}); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+		sent := sender.sent()
+		return sent[len(sent)-1].message
+	}
+
+	if message := handle(1, ""); !strings.Contains(message, "Mine") || strings.Contains(message, "Theirs") {
+		t.Errorf("Expected only the caller's downloads, got %q", message)
+	}
+	if message := handle(1, "all"); !strings.Contains(message, "Only admins") {
+		t.Errorf("Expected users to be refused the totals, got %q", message)
+	}
+	if message := handle(99, "all"); !strings.Contains(message, "Songs delivered: 2 (2 distinct)") || !strings.Contains(message, "Users: 2") {
+		t.Errorf("Expected admins to see the totals, got %q", message)
+	}
+}
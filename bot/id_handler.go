@@ -3,23 +3,45 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
 	"github.com/gotd/td/tg"
 )
 
+// copyHint ends the ID messages, whose IDs are code spans
+const copyHint = "\n(Click/Tap to copy)"
+
+// idAPI is the part of the Telegram API the /id command looks messages and
+// usernames up with
+type idAPI interface {
+	MessagesGetMessages(ctx context.Context, id []tg.InputMessageClass) (tg.MessagesMessagesClass, error)
+	ChannelsGetMessages(ctx context.Context, request *tg.ChannelsGetMessagesRequest) (tg.MessagesMessagesClass, error)
+	ContactsResolveUsername(ctx context.Context, request *tg.ContactsResolveUsernameRequest) (*tg.ContactsResolvedPeer, error)
+}
+
+// forwardNameReplacer drops the markdown markers from the names of hidden
+// forward senders
+var forwardNameReplacer = strings.NewReplacer("*", "", "`", "", "[", "", "]", "")
+
 // IDHandler implements CommandHandler for the /id command
 type IDHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	sender       MessageSender
+	logger       logging.Logger
 	errorHandler *ErrorHandler
+	
+	api idAPI // Overrides the client's API, for tests
 }
 
 // NewIDHandler creates a new IDHandler instance
-func NewIDHandler(client *TelegramBot, logger *log.Logger) *IDHandler {
+func NewIDHandler(client *TelegramBot, logger logging.Logger) *IDHandler {
 	handler := &IDHandler{
 		client: client,
+		sender: client,
 		logger: logger,
 	}
 	
@@ -36,11 +58,12 @@ func (h *IDHandler) Command() string {
 	return "id"
 }
 
-// Handle processes the /id command and returns chat or user ID
+// Handle processes the /id command and returns the ID of the chat, of the
+// sender and origin of the replied message or of the username given
 func (h *IDHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	startTime := time.Now()
 	
-	h.logger.Printf("Processing /id command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing /id command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 	
 	// Create context with timeout
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -48,16 +71,18 @@ func (h *IDHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	
 	var message string
 	
-	// Check if this is a reply to another message
-	if cmdCtx.ReplyToMessageID != 0 {
+	if username := strings.TrimSpace(cmdCtx.Args); username != "" {
+		// A username to look up
+		message = h.createUsernameMessage(timeoutCtx, username)
+	} else if cmdCtx.ReplyToMessageID != 0 {
 		// Get the replied message to extract user ID
 		repliedMessage, err := h.getRepliedMessage(timeoutCtx, cmdCtx)
 		if err != nil {
-			h.logger.Printf("Failed to get replied message: %v", err)
+			h.logger.Warn("Failed to get replied message", logging.Err(err))
 			// Fallback to showing chat ID
 			message = h.createChatIDMessage(cmdCtx.ChatID)
 		} else {
-			message = h.createUserIDMessage(repliedMessage.FromID)
+			message = h.createRepliedMessage(repliedMessage)
 		}
 	} else {
 		// No reply, show chat ID
@@ -65,8 +90,8 @@ func (h *IDHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	}
 	
 	// Send the ID message with markdown formatting
-	if err := h.sendMarkdownMessage(timeoutCtx, cmdCtx.ChatID, message); err != nil {
-		h.logger.Printf("Failed to send ID message to chat %d: %v", cmdCtx.ChatID, err)
+	if err := h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, message); err != nil {
+		h.logger.Error("Failed to send ID message", logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
 		
 		// Use error handler if available for network errors
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
@@ -78,169 +103,189 @@ func (h *IDHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	
 	// Log successful processing with timing
 	processingTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed /id command for user %d (took %v)", 
-		cmdCtx.UserID, processingTime)
+	h.logger.Debug("Processed /id command", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Took", processingTime))
 	
 	return nil
 }
 
-// getRepliedMessage retrieves the message that was replied to
-func (h *IDHandler) getRepliedMessage(ctx context.Context, cmdCtx *CommandContext) (*tg.Message, error) {
+// telegramAPI returns the API IDs are looked up with, nil before the bot
+// client is created
+func (h *IDHandler) telegramAPI() idAPI {
+	if h.api != nil {
+		return h.api
+	}
 	if h.client == nil || h.client.GetClient() == nil {
-		return nil, fmt.Errorf("bot client is not initialized")
+		return nil
+	}
+	return h.client.GetClient().API()
+}
+
+// getRepliedMessage retrieves the message that was replied to. Messages of
+// supergroups and channels are fetched from the channel
+func (h *IDHandler) getRepliedMessage(ctx context.Context, cmdCtx *CommandContext) (*tg.Message, error) {
+	api := h.telegramAPI()
+	if api == nil {
+		return nil, errClientNotInitialized
 	}
 	
-	// Get the message by ID - use the correct API method
 	messageIDs := []tg.InputMessageClass{
 		&tg.InputMessageID{ID: int(cmdCtx.ReplyToMessageID)},
 	}
 	
-	response, err := h.client.GetClient().API().MessagesGetMessages(ctx, messageIDs)
+	var response tg.MessagesMessagesClass
+	var err error
+	if channel, ok := h.client.InputPeer(cmdCtx.ChatID).(*tg.InputPeerChannel); ok {
+		response, err = api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+			ID:      messageIDs,
+		})
+	} else {
+		response, err = api.MessagesGetMessages(ctx, messageIDs)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get replied message: %w", err)
 	}
 	
 	// Extract messages from response
+	var messages []tg.MessageClass
 	switch msgs := response.(type) {
 	case *tg.MessagesMessages:
-		if len(msgs.Messages) > 0 {
-			if msg, ok := msgs.Messages[0].(*tg.Message); ok {
-				return msg, nil
-			}
-		}
+		messages = msgs.Messages
 	case *tg.MessagesMessagesSlice:
-		if len(msgs.Messages) > 0 {
-			if msg, ok := msgs.Messages[0].(*tg.Message); ok {
-				return msg, nil
-			}
+		messages = msgs.Messages
+	case *tg.MessagesChannelMessages:
+		messages = msgs.Messages
+	}
+	if len(messages) > 0 {
+		if msg, ok := messages[0].(*tg.Message); ok {
+			return msg, nil
 		}
 	}
 	
 	return nil, fmt.Errorf("replied message not found")
 }
 
-// createUserIDMessage creates a message showing the user ID
-func (h *IDHandler) createUserIDMessage(fromID tg.PeerClass) string {
-	var userID int64
+// createUsernameMessage resolves a username and shows the ID and type of the
+// user, bot or chat it belongs to, or why it could not be resolved
+func (h *IDHandler) createUsernameMessage(ctx context.Context, username string) string {
+	username = strings.TrimPrefix(username, "https://")
+	username = strings.TrimPrefix(username, "t.me/")
+	username = strings.TrimPrefix(username, "@")
 	
-	switch peer := fromID.(type) {
-	case *tg.PeerUser:
-		userID = peer.UserID
-	case *tg.PeerChat:
-		userID = peer.ChatID
-	case *tg.PeerChannel:
-		userID = peer.ChannelID
-	default:
-		return "Unable to determine user ID"
+	api := h.telegramAPI()
+	if api == nil {
+		return fmt.Sprintf("❌ Could not look up @%s right now, please try again later", username)
+	}
+	
+	resolved, err := api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{Username: username})
+	if err != nil {
+		h.logger.Warn("Failed to resolve username", logging.String("Username", "@"+username), logging.Err(err))
+		switch {
+		case downloader.IsUsernameNotOccupied(err):
+			return fmt.Sprintf("❌ No user, bot or channel is called @%s", username)
+		case downloader.IsUsernameInvalid(err):
+			return fmt.Sprintf("❌ @%s is not a valid username", username)
+		}
+		return fmt.Sprintf("❌ Could not look up @%s right now, please try again later", username)
 	}
 	
-	return fmt.Sprintf("User id: `%d`\n(Click/Tap to copy)", userID)
+	kind, id := resolvedPeerID(resolved)
+	if kind == "" {
+		return fmt.Sprintf("❌ No user, bot or channel is called @%s", username)
+	}
+	return fmt.Sprintf("@%s is a %s\n%s id: `%d`%s", username, kind, capitalize(kind), id, copyHint)
 }
 
-// createChatIDMessage creates a message showing the chat ID
-func (h *IDHandler) createChatIDMessage(chatID int64) string {
-	return fmt.Sprintf("Chat id: `%d`\n(Click/Tap to copy)", chatID)
+// resolvedPeerID returns the type (user, bot, group or channel) and ID of a
+// resolved username, channels with the -100 prefix. The type is empty when the
+// peer is unknown
+func resolvedPeerID(resolved *tg.ContactsResolvedPeer) (string, int64) {
+	switch peer := resolved.Peer.(type) {
+	case *tg.PeerUser:
+		for _, user := range resolved.Users {
+			if user, ok := user.(*tg.User); ok && user.ID == peer.UserID && user.Bot {
+				return "bot", peer.UserID
+			}
+		}
+		return "user", peer.UserID
+	case *tg.PeerChannel:
+		for _, chat := range resolved.Chats {
+			if channel, ok := chat.(*tg.Channel); ok && channel.ID == peer.ChannelID && channel.Megagroup {
+				return "group", downloader.ChannelChatID(peer.ChannelID)
+			}
+		}
+		return "channel", downloader.ChannelChatID(peer.ChannelID)
+	case *tg.PeerChat:
+		return "group", -peer.ChatID
+	}
+	return "", 0
 }
 
-// sendMarkdownMessage sends a text message with markdown formatting to the specified chat
-func (h *IDHandler) sendMarkdownMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
+// createRepliedMessage shows the ID of the sender of a replied message and,
+// for forwards, of where it was forwarded from
+func (h *IDHandler) createRepliedMessage(msg *tg.Message) string {
+	from, ok := msg.GetFromID()
+	if !ok {
+		// Messages of private chats come from the chat itself
+		from = msg.PeerID
 	}
 	
-	// For bot API, we need to determine the correct peer type
-	var peer tg.InputPeerClass
-	
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-	
-	// Create message entities for markdown formatting
-	entities := h.parseMarkdownEntities(message)
-	
-	// Create the message request with markdown entities
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  h.stripMarkdownSyntax(message),
-		RandomID: time.Now().UnixNano(),
-		Entities: entities,
+	fwd, ok := msg.GetFwdFrom()
+	if !ok {
+		return h.createUserIDMessage(from)
 	}
 	
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send markdown message via Telegram API: %w", err)
+	sender := peerIDLine(from)
+	if sender == "" {
+		sender = "Unable to determine user ID"
 	}
-	
-	return nil
+	return sender + "\n" + forwardOriginLine(fwd) + copyHint
 }
 
-// parseMarkdownEntities parses markdown syntax and creates message entities
-func (h *IDHandler) parseMarkdownEntities(text string) []tg.MessageEntityClass {
-	var entities []tg.MessageEntityClass
-	offset := 0
-	
-	i := 0
-	for i < len(text) {
-		char := text[i]
-		
-		if char == '`' {
-			// Find closing backtick for code
-			if end := h.findClosing(text, i+1, '`'); end != -1 {
-				codeText := text[i+1 : end]
-				entities = append(entities, &tg.MessageEntityCode{
-					Offset: offset,
-					Length: len(codeText),
-				})
-				offset += len(codeText)
-				i = end + 1
-				continue
-			}
-		}
-		
-		// Add regular character
-		offset++
-		i++
+// createUserIDMessage creates a message showing the user ID
+func (h *IDHandler) createUserIDMessage(fromID tg.PeerClass) string {
+	line := peerIDLine(fromID)
+	if line == "" {
+		return "Unable to determine user ID"
 	}
-	
-	return entities
+	return line + copyHint
 }
 
-// findClosing finds the closing character for markdown syntax
-func (h *IDHandler) findClosing(text string, start int, char byte) int {
-	for i := start; i < len(text); i++ {
-		if text[i] == char {
-			return i
-		}
+// peerIDLine shows the ID of a message sender, channels with the -100 prefix.
+// It is empty for unknown peers
+func peerIDLine(peer tg.PeerClass) string {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		return fmt.Sprintf("User id: `%d`", peer.UserID)
+	case *tg.PeerChat:
+		return fmt.Sprintf("User id: `%d`", peer.ChatID)
+	case *tg.PeerChannel:
+		return fmt.Sprintf("Channel id: `%d`", downloader.ChannelChatID(peer.ChannelID))
 	}
-	return -1
+	return ""
 }
 
-// stripMarkdownSyntax removes markdown syntax characters from text
-func (h *IDHandler) stripMarkdownSyntax(text string) string {
-	result := ""
-	i := 0
-	
-	for i < len(text) {
-		char := text[i]
-		
-		if char == '`' {
-			// Skip code markers
-			if end := h.findClosing(text, i+1, '`'); end != -1 {
-				result += text[i+1 : end]
-				i = end + 1
-				continue
-			}
+// forwardOriginLine shows where a forwarded message comes from, or that its
+// sender hides their account in forwards
+func forwardOriginLine(fwd tg.MessageFwdHeader) string {
+	if from, ok := fwd.GetFromID(); ok {
+		switch peer := from.(type) {
+		case *tg.PeerUser:
+			return fmt.Sprintf("Forwarded from user id: `%d`", peer.UserID)
+		case *tg.PeerChat:
+			return fmt.Sprintf("Forwarded from group id: `%d`", -peer.ChatID)
+		case *tg.PeerChannel:
+			return fmt.Sprintf("Forwarded from channel id: `%d`", downloader.ChannelChatID(peer.ChannelID))
 		}
-		
-		// Add regular character
-		result += string(char)
-		i++
 	}
-	
-	return result
-}
\ No newline at end of file
+	if name, ok := fwd.GetFromName(); ok && name != "" {
+		return fmt.Sprintf("🔒 Forwarded from %s, who hides their account in forwards", forwardNameReplacer.Replace(name))
+	}
+	return "🔒 The original sender hides their account in forwards"
+}
+
+// createChatIDMessage creates a message showing the chat ID
+func (h *IDHandler) createChatIDMessage(chatID int64) string {
+	return fmt.Sprintf("Chat id: `%d`%s", chatID, copyHint)
+}
@@ -2,16 +2,19 @@ package bot
 
 import (
 	"context"
-	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"go-alac-bot/logging"
+
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
 func TestIDHandler_Command(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewIDHandler(nil, logger)
 	
 	expected := "id"
@@ -21,7 +24,7 @@ func TestIDHandler_Command(t *testing.T) {
 }
 
 func TestIDHandler_Handle_ChatID(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	// Create a mock bot client (nil for testing)
 	handler := NewIDHandler(nil, logger)
@@ -47,7 +50,7 @@ func TestIDHandler_Handle_ChatID(t *testing.T) {
 }
 
 func TestIDHandler_CreateChatIDMessage(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewIDHandler(nil, logger)
 	
 	chatID := int64(12345)
@@ -60,7 +63,7 @@ func TestIDHandler_CreateChatIDMessage(t *testing.T) {
 }
 
 func TestIDHandler_CreateUserIDMessage(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewIDHandler(nil, logger)
 	
 	// Test with PeerUser
@@ -83,11 +86,11 @@ func TestIDHandler_CreateUserIDMessage(t *testing.T) {
 }
 
 func TestIDHandler_ParseMarkdownEntities(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewIDHandler(nil, logger)
 	
-	text := "Chat id: `12345`\n(Click/Tap to copy)"
-	entities := handler.parseMarkdownEntities(text)
+	text := handler.createChatIDMessage(12345)
+	_, entities := ParseMarkdown(text)
 	
 	// Should have one code entity
 	if len(entities) != 1 {
@@ -109,14 +112,168 @@ func TestIDHandler_ParseMarkdownEntities(t *testing.T) {
 }
 
 func TestIDHandler_StripMarkdownSyntax(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewIDHandler(nil, logger)
 	
-	text := "Chat id: `12345`\n(Click/Tap to copy)"
+	text := handler.createChatIDMessage(12345)
 	expected := "Chat id: 12345\n(Click/Tap to copy)"
 	
-	result := handler.stripMarkdownSyntax(text)
+	result, _ := ParseMarkdown(text)
 	if result != expected {
-		t.Errorf("stripMarkdownSyntax() = %v, want %v", result, expected)
+		t.Errorf("ParseMarkdown() = %v, want %v", result, expected)
+	}
+}
+
+// mockIDAPI answers message and username lookups of the /id command
+type mockIDAPI struct {
+	message         *tg.Message // Replied message
+	resolved        *tg.ContactsResolvedPeer
+	resolveErr      error
+	channelRequests []*tg.ChannelsGetMessagesRequest
+	usernames       []string
+}
+
+func (m *mockIDAPI) MessagesGetMessages(ctx context.Context, id []tg.InputMessageClass) (tg.MessagesMessagesClass, error) {
+	return &tg.MessagesMessages{Messages: []tg.MessageClass{m.message}}, nil
+}
+
+func (m *mockIDAPI) ChannelsGetMessages(ctx context.Context, request *tg.ChannelsGetMessagesRequest) (tg.MessagesMessagesClass, error) {
+	m.channelRequests = append(m.channelRequests, request)
+	return &tg.MessagesChannelMessages{Messages: []tg.MessageClass{m.message}}, nil
+}
+
+func (m *mockIDAPI) ContactsResolveUsername(ctx context.Context, request *tg.ContactsResolveUsernameRequest) (*tg.ContactsResolvedPeer, error) {
+	m.usernames = append(m.usernames, request.Username)
+	return m.resolved, m.resolveErr
+}
+
+// handleID runs /id with a mocked API and returns the message it sent
+func handleID(t *testing.T, api *mockIDAPI, cmdCtx *CommandContext) string {
+	t.Helper()
+	handler := NewIDHandler(nil, logging.Discard())
+	handler.api = api
+	sender := &recordingSender{}
+	handler.sender = sender
+
+	if err := handler.Handle(context.Background(), cmdCtx); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	sent := sender.sent()
+	if len(sent) != 1 || sent[0].chatID != cmdCtx.ChatID {
+		t.Fatalf("Expected 1 message to chat %d, got %+v", cmdCtx.ChatID, sent)
+	}
+	return sent[0].message
+}
+
+func TestIDHandler_Handle_Reply(t *testing.T) {
+	fromUser111 := func() *tg.Message {
+		message := &tg.Message{ID: 5, PeerID: &tg.PeerChat{ChatID: 600}}
+		message.SetFromID(&tg.PeerUser{UserID: 111})
+		return message
 	}
-}
\ No newline at end of file
+	forward := func(fwd tg.MessageFwdHeader) *tg.Message {
+		message := fromUser111()
+		message.SetFwdFrom(fwd)
+		return message
+	}
+	hidden := tg.MessageFwdHeader{}
+	hidden.SetFromName("Hidden *Person*")
+	fromUser := tg.MessageFwdHeader{}
+	fromUser.SetFromID(&tg.PeerUser{UserID: 222})
+	fromChannel := tg.MessageFwdHeader{}
+	fromChannel.SetFromID(&tg.PeerChannel{ChannelID: 1234567890})
+
+	testCases := []struct {
+		name    string
+		message *tg.Message
+		want    []string
+	}{
+		{"sender", fromUser111(), []string{"User id: `111`"}},
+		{"private chat sender", &tg.Message{ID: 5, PeerID: &tg.PeerUser{UserID: 333}}, []string{"User id: `333`"}},
+		{"forward from a user", forward(fromUser), []string{"User id: `111`", "Forwarded from user id: `222`"}},
+		{"forward from a channel", forward(fromChannel), []string{"Forwarded from channel id: `-1001234567890`"}},
+		{"privacy-restricted forward", forward(hidden), []string{"Forwarded from Hidden Person, who hides their account"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := handleID(t, &mockIDAPI{message: tc.message}, &CommandContext{ChatID: -600, ReplyToMessageID: 5})
+			for _, want := range tc.want {
+				if !strings.Contains(message, want) {
+					t.Errorf("Expected %q in %q", want, message)
+				}
+			}
+		})
+	}
+}
+
+func TestIDHandler_Handle_ReplyInSupergroup(t *testing.T) {
+	message := &tg.Message{ID: 5, PeerID: &tg.PeerChannel{ChannelID: 99}}
+	message.SetFromID(&tg.PeerChannel{ChannelID: 42})
+	api := &mockIDAPI{message: message}
+
+	got := handleID(t, api, &CommandContext{ChatID: -10099, ReplyToMessageID: 5})
+	if len(api.channelRequests) != 1 {
+		t.Fatalf("Expected the message to be fetched from the channel, got %d requests", len(api.channelRequests))
+	}
+	if channel, ok := api.channelRequests[0].Channel.(*tg.InputChannel); !ok || channel.ChannelID != 99 {
+		t.Errorf("Expected channel 99, got %v", api.channelRequests[0].Channel)
+	}
+	if !strings.Contains(got, "Channel id: `-10042`") {
+		t.Errorf("Expected the -100 prefixed channel ID, got %q", got)
+	}
+}
+
+func TestIDHandler_Handle_Username(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     string
+		resolved *tg.ContactsResolvedPeer
+		err      error
+		want     string
+	}{
+		{
+			name:     "user",
+			args:     "@someone",
+			resolved: &tg.ContactsResolvedPeer{Peer: &tg.PeerUser{UserID: 111}, Users: []tg.UserClass{&tg.User{ID: 111}}},
+			want:     "@someone is a user\nUser id: `111`",
+		},
+		{
+			name:     "bot",
+			args:     "some_bot",
+			resolved: &tg.ContactsResolvedPeer{Peer: &tg.PeerUser{UserID: 222}, Users: []tg.UserClass{&tg.User{ID: 222, Bot: true}}},
+			want:     "@some_bot is a bot\nBot id: `222`",
+		},
+		{
+			name:     "channel",
+			args:     "https://t.me/music",
+			resolved: &tg.ContactsResolvedPeer{Peer: &tg.PeerChannel{ChannelID: 333}, Chats: []tg.ChatClass{&tg.Channel{ID: 333, Broadcast: true}}},
+			want:     "@music is a channel\nChannel id: `-100333`",
+		},
+		{
+			name: "not found",
+			args: "@nobody",
+			err:  tgerr.New(400, "USERNAME_NOT_OCCUPIED"),
+			want: "No user, bot or channel is called @nobody",
+		},
+		{
+			name: "invalid",
+			args: "@a",
+			err:  tgerr.New(400, "USERNAME_INVALID"),
+			want: "@a is not a valid username",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &mockIDAPI{resolved: tc.resolved, resolveErr: tc.err}
+			got := handleID(t, api, &CommandContext{ChatID: 67890, Args: tc.args})
+			if !strings.Contains(got, tc.want) {
+				t.Errorf("Expected %q in %q", tc.want, got)
+			}
+			if len(api.usernames) != 1 || strings.ContainsAny(api.usernames[0], "@/") {
+				t.Errorf("Expected one lookup of the bare username, got %q", api.usernames)
+			}
+		})
+	}
+}
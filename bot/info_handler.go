@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// InfoHandler implements CommandHandler for the /info command, which shows the
+// metadata of a song and whether it can be downloaded in ALAC, without
+// downloading it
+type InfoHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewInfoHandler creates a new InfoHandler instance
+func NewInfoHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *InfoHandler {
+	handler := &InfoHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *InfoHandler) Command() string {
+	return "info"
+}
+
+// Handle processes the /info command. Only the catalog is asked, so the info
+// is sent straight away instead of going through the queue
+func (h *InfoHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /info command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	link := strings.TrimSpace(cmdCtx.Args)
+	if link == "" {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Please provide an Apple Music song URL.\n\nUsage: /info <url>")
+	}
+
+	urlMeta := ExtractURLMetaWithHints(link, h.songHandler.storefrontHints(cmdCtx))
+	if urlMeta == nil || urlMeta.URLType != "songs" {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Please provide a valid Apple Music song URL.")
+	}
+	if ok, err := h.songHandler.allowLookup(timeoutCtx, cmdCtx); !ok {
+		return err
+	}
+
+	// The info is sent after Handle returns, but not after the bot stops
+	go h.sendInfo(h.client.Context(), cmdCtx.ChatID, &downloader.URLMeta{
+		Storefront: urlMeta.Storefront,
+		URLType:    urlMeta.URLType,
+		ID:         urlMeta.ID,
+	})
+
+	return nil
+}
+
+// sendInfo fetches the details of the song of urlMeta and sends them to
+// chatID, on its artwork when that could be fetched
+func (h *InfoHandler) sendInfo(ctx context.Context, chatID int64, urlMeta *downloader.URLMeta) {
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+
+	details, err := h.songHandler.manager.GetSongDetails(ctx, urlMeta)
+	if err != nil {
+		h.logger.Error("Failed to fetch song info", logging.String("ID", urlMeta.ID), logging.Err(err))
+		if err := h.sender.SendMarkdown(ctx, chatID, "❌ Failed to fetch the song info: "+userFacingError(err)); err != nil {
+			h.logger.Error("Failed to send song info error", logging.Err(err))
+		}
+		return
+	}
+
+	caption := createInfoCaption(details)
+	if details.Artwork != nil {
+		err := sendPhoto(ctx, h.client, chatID, details.Artwork, caption)
+		if err == nil {
+			h.logger.Info("Sent song info", logging.String("ID", urlMeta.ID), logging.Int64("Chat", chatID))
+			return
+		}
+		h.logger.Warn("Failed to send song info with artwork, sending it as text", logging.String("ID", urlMeta.ID), logging.Err(err))
+	}
+
+	if err := h.sender.SendText(ctx, chatID, caption); err != nil {
+		h.logger.Error("Failed to send song info", logging.String("ID", urlMeta.ID), logging.Err(err))
+		return
+	}
+	h.logger.Info("Sent song info", logging.String("ID", urlMeta.ID), logging.Int64("Chat", chatID))
+}
+
+// createInfoCaption creates the card sent by /info
+func createInfoCaption(details *downloader.SongDetails) string {
+	attrs := details.Song.Attributes
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎵 %s — %s\n", attrs.Name, attrs.ArtistName)
+	if attrs.AlbumName != "" {
+		fmt.Fprintf(&b, "💿 %s\n", attrs.AlbumName)
+	}
+	writeCoverDetails(&b, attrs.ReleaseDate, attrs.GenreNames, "")
+	if attrs.ISRC != "" {
+		fmt.Fprintf(&b, "🔢 ISRC: %s\n", attrs.ISRC)
+	}
+	if attrs.DurationInMillis > 0 {
+		fmt.Fprintf(&b, "⏱️ %s\n", formatTrackLength(time.Duration(attrs.DurationInMillis)*time.Millisecond))
+	}
+
+	if details.ALAC {
+		b.WriteString("🎧 ALAC: available\n")
+	} else {
+		b.WriteString("🎧 ALAC: not available\n")
+	}
+	switch {
+	case attrs.HasTimeSyncedLyrics:
+		b.WriteString("📝 Lyrics: time-synced\n")
+	case details.Lyrics:
+		b.WriteString("📝 Lyrics: available\n")
+	default:
+		b.WriteString("📝 Lyrics: none\n")
+	}
+	if details.Storefront != "" {
+		fmt.Fprintf(&b, "🌍 Storefront: %s\n", strings.ToUpper(details.Storefront))
+	}
+
+	return downloader.TruncateUTF16(strings.TrimRight(b.String(), "\n"), maxCaptionLength)
+}
+
+// formatTrackLength formats the length of a song as m:ss, or h:mm:ss from an
+// hour on
+func formatTrackLength(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestInfoHandler_Command(t *testing.T) {
+	handler := NewInfoHandler(nil, logging.Discard(), nil)
+
+	expected := "info"
+	if got := handler.Command(); got != expected {
+		t.Errorf("InfoHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestInfoHandler_RejectsOtherLinks(t *testing.T) {
+	sender := &recordingSender{}
+	handler := NewInfoHandler(nil, logging.Discard(), NewSongHandler(nil, logging.Discard()))
+	handler.sender = sender
+
+	for _, args := range []string{"", "https://music.apple.com/us/album/album/1559523357", "not a link"} {
+		if err := handler.Handle(context.Background(), &CommandContext{ChatID: 1, Args: args}); err != nil {
+			t.Fatalf("Handle(%q) error = %v", args, err)
+		}
+	}
+
+	sent := sender.sent()
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 replies, got %d", len(sent))
+	}
+	if !strings.Contains(sent[0].message, "Usage: /info <url>") {
+		t.Errorf("Expected usage without a link, got %q", sent[0].message)
+	}
+	for _, message := range sent[1:] {
+		if !strings.Contains(message.message, "valid Apple Music song URL") {
+			t.Errorf("Expected only song links to be accepted, got %q", message.message)
+		}
+	}
+}
+
+func TestCreateInfoCaption(t *testing.T) {
+	details := &downloader.SongDetails{
+		Song: &downloader.AutoSong{
+			ID: "100",
+			Attributes: downloader.SongAttributes{
+				Name:                "Song",
+				ArtistName:          "Artist",
+				AlbumName:           "Album",
+				ReleaseDate:         "2021-03-19",
+				GenreNames:          []string{"Pop", "Music"},
+				ISRC:                "USABC1234567",
+				DurationInMillis:    225400,
+				HasTimeSyncedLyrics: true,
+			},
+		},
+		Storefront: "jp",
+		ALAC:       true,
+		Lyrics:     true,
+	}
+
+	caption := createInfoCaption(details)
+	for _, want := range []string{
+		"🎵 Song — Artist", "💿 Album", "📅 2021-03-19", "🎼 Pop", "🔢 ISRC: USABC1234567",
+		"⏱️ 3:45", "🎧 ALAC: available", "📝 Lyrics: time-synced", "🌍 Storefront: JP",
+	} {
+		if !strings.Contains(caption, want) {
+			t.Errorf("Expected caption to contain %q, got %q", want, caption)
+		}
+	}
+
+	// Songs without ALAC, lyrics or optional tags say so briefly
+	details = &downloader.SongDetails{
+		Song:       &downloader.AutoSong{Attributes: downloader.SongAttributes{Name: "Song", ArtistName: "Artist"}},
+		Storefront: "us",
+	}
+	caption = createInfoCaption(details)
+	for _, want := range []string{"🎧 ALAC: not available", "📝 Lyrics: none"} {
+		if !strings.Contains(caption, want) {
+			t.Errorf("Expected caption to contain %q, got %q", want, caption)
+		}
+	}
+	for _, absent := range []string{"💿", "📅", "ISRC", "⏱️"} {
+		if strings.Contains(caption, absent) {
+			t.Errorf("Expected no %q line for missing tags, got %q", absent, caption)
+		}
+	}
+}
+
+func TestFormatTrackLength(t *testing.T) {
+	tests := []struct {
+		length time.Duration
+		want   string
+	}{
+		{0, "0:00"},
+		{59*time.Second + 600*time.Millisecond, "1:00"},
+		{3*time.Minute + 5*time.Second, "3:05"},
+		{time.Hour + 2*time.Minute + 3*time.Second, "1:02:03"},
+	}
+	for _, tt := range tests {
+		if got := formatTrackLength(tt.length); got != tt.want {
+			t.Errorf("formatTrackLength(%v) = %q, want %q", tt.length, got, tt.want)
+		}
+	}
+}
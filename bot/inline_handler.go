@@ -0,0 +1,250 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// inlineQueryLimit is how many inline queries a user gets answered per
+	// minute; clients send one per keystroke
+	inlineQueryLimit = 20
+
+	// inlineCacheTime is how long Telegram may reuse an answer, in seconds
+	inlineCacheTime = 300
+
+	// inlineTimeout bounds answering one inline query or chosen result
+	inlineTimeout = 10 * time.Second
+
+	// Result ID prefixes: songs to queue and archived songs sent as is
+	inlineQueuePrefix    = "queue:"
+	inlineArchivedPrefix = "archived:"
+)
+
+// inlineAPI is the part of the Telegram API inline queries are answered with
+type inlineAPI interface {
+	MessagesSetInlineBotResults(ctx context.Context, request *tg.MessagesSetInlineBotResultsRequest) (bool, error)
+}
+
+// InlineHandler answers "@bot <link>" inline queries with the song the link
+// points to. Archived songs are sent straight into the chat; other songs are
+// queued when chosen and sent to the user's private chat with the bot
+type InlineHandler struct {
+	client      *TelegramBot
+	sender      MessageSender
+	logger      logging.Logger
+	songHandler *SongHandler
+	limiter     *RateLimiter // Inline queries of each user; they have no chat
+
+	// api overrides the client's API and preview fetches the songs shown in
+	// answers, for tests
+	api     inlineAPI
+	preview func(ctx context.Context, urlMeta *downloader.URLMeta) (*downloader.SongPreview, error)
+	now     func() time.Time
+}
+
+// NewInlineHandler creates a new InlineHandler instance
+func NewInlineHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *InlineHandler {
+	handler := &InlineHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+		limiter:     NewRateLimiter(inlineQueryLimit, 0),
+		now:         time.Now,
+	}
+	handler.preview = func(ctx context.Context, urlMeta *downloader.URLMeta) (*downloader.SongPreview, error) {
+		if songHandler.manager == nil {
+			return nil, errors.New("downloader is not initialized")
+		}
+		return songHandler.manager.GetSongPreview(ctx, urlMeta)
+	}
+	return handler
+}
+
+// HandleInlineQuery answers an inline query with the song its link points
+// to, or with a hint when there is none
+func (h *InlineHandler) HandleInlineQuery(ctx context.Context, query *tg.UpdateBotInlineQuery) error {
+	ctx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+
+	answer := &tg.MessagesSetInlineBotResultsRequest{
+		QueryID:    query.QueryID,
+		Results:    []tg.InputBotInlineResultClass{},
+		CacheTime:  inlineCacheTime,
+		IsPersonal: true,
+	}
+
+	songURL, urlMeta := inlineSongURL(query.Query)
+	switch {
+	case strings.TrimSpace(query.Query) == "":
+		answer.SwitchPm = inlineHint("Paste an Apple Music song link")
+	case urlMeta == nil:
+		answer.SwitchPm = inlineHint("Only Apple Music song links are supported")
+	case !h.allow(query.UserID):
+		h.logger.Info("Rate limited inline query", logging.Int64("User", query.UserID))
+		answer.CacheTime = 0
+		answer.SwitchPm = inlineHint("Too many requests, please wait a minute")
+	default:
+		preview, err := h.preview(ctx, &downloader.URLMeta{Storefront: urlMeta.Storefront, URLType: urlMeta.URLType, ID: urlMeta.ID})
+		if err != nil {
+			h.logger.Warn("Failed to fetch inline preview", logging.String("Song", urlMeta.ID), logging.Err(err))
+			answer.CacheTime = 0
+			answer.SwitchPm = inlineHint("Couldn't find that song, please try again")
+			break
+		}
+		archived, _ := h.songHandler.archive.Get(urlMeta.ID)
+		answer.Results = inlineResults(songURL, urlMeta.ID, preview, archived)
+	}
+
+	api := h.telegramAPI()
+	if api == nil {
+		return errClientNotInitialized
+	}
+	if _, err := api.MessagesSetInlineBotResults(ctx, answer); err != nil {
+		return fmt.Errorf("failed to answer inline query: %w", err)
+	}
+	return nil
+}
+
+// HandleChosenResult queues the song of a chosen inline result. Archived
+// songs were already sent by Telegram
+func (h *InlineHandler) HandleChosenResult(ctx context.Context, chosen *tg.UpdateBotInlineSend) error {
+	if !strings.HasPrefix(chosen.ID, inlineQueuePrefix) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+
+	songURL, urlMeta := inlineSongURL(chosen.Query)
+	if urlMeta == nil {
+		return fmt.Errorf("chosen inline result %q has no song link", chosen.ID)
+	}
+
+	resultID := urlMeta.ID + ":" + strconv.FormatInt(h.now().UnixNano(), 10)
+	var message string
+	request, err := h.songHandler.queue.AddInlineRequest(resultID, chosen.UserID, songURL)
+	if err != nil {
+		h.logger.Warn("Failed to queue inline request", logging.Int64("User", chosen.UserID), logging.String("URL", songURL), logging.Err(err))
+		message = inlineQueueFailure(err)
+	} else {
+		h.logger.Info("Queued inline request", logging.String("Request", request.UniqueID), logging.Int64("User", chosen.UserID))
+		message = "🎵 Your song has been queued, I'll send it here when it's ready."
+	}
+
+	// Bots can only reach users who started them
+	if err := h.sender.SendMarkdown(ctx, chosen.UserID, message); err != nil {
+		return fmt.Errorf("failed to notify user %d of inline request: %w", chosen.UserID, err)
+	}
+	return nil
+}
+
+// telegramAPI returns the API inline queries are answered with, nil before
+// the bot client is created
+func (h *InlineHandler) telegramAPI() inlineAPI {
+	if h.api != nil {
+		return h.api
+	}
+	if h.client == nil || h.client.GetClient() == nil {
+		return nil
+	}
+	return h.client.GetClient().API()
+}
+
+// inlineSongURL returns the song link of an inline query with its storefront
+// filled in, and nil metadata when the query is not a song link
+func inlineSongURL(query string) (string, *URLMeta) {
+	songURL := strings.TrimSpace(query)
+	urlMeta := ExtractURLMeta(songURL)
+	if urlMeta == nil || urlMeta.URLType != "songs" {
+		return "", nil
+	}
+	if urlMeta.StorefrontInferred {
+		songURL = WithStorefront(songURL, urlMeta.Storefront)
+	}
+	return songURL, urlMeta
+}
+
+// inlineResults builds the answer for a song: the archived audio when every
+// part of it fits in one message, and an article queueing the download
+func inlineResults(songURL, songID string, preview *downloader.SongPreview, archived ArchivedSong) []tg.InputBotInlineResultClass {
+	var results []tg.InputBotInlineResultClass
+	if len(archived.Documents) == 1 {
+		document := archived.Documents[0]
+		results = append(results, &tg.InputBotInlineResultDocument{
+			ID:    inlineArchivedPrefix + songID,
+			Type:  "audio",
+			Title: preview.Title,
+			Document: &tg.InputDocument{
+				ID:            document.DocumentID,
+				AccessHash:    document.AccessHash,
+				FileReference: document.FileReference,
+			},
+			SendMessage: &tg.InputBotInlineMessageMediaAuto{},
+		})
+	}
+
+	text, entities := ParseMarkdown(fmt.Sprintf("🎵 **%s** — %s\n%s", preview.Title, preview.Artist, songURL))
+	article := &tg.InputBotInlineResult{
+		ID:          inlineQueuePrefix + songID,
+		Type:        "article",
+		Title:       preview.Title,
+		Description: inlineDescription(preview),
+		SendMessage: &tg.InputBotInlineMessageText{
+			Message:  text,
+			Entities: entities,
+		},
+	}
+	if preview.ArtworkURL != "" {
+		article.Thumb = &tg.InputWebDocument{
+			URL:        preview.ArtworkURL,
+			MimeType:   "image/jpeg",
+			Attributes: []tg.DocumentAttributeClass{},
+		}
+	}
+	return append(results, article)
+}
+
+// inlineDescription is the line shown under a song's title in inline results
+func inlineDescription(preview *downloader.SongPreview) string {
+	if preview.Album == "" {
+		return preview.Artist
+	}
+	return preview.Artist + " — " + preview.Album
+}
+
+// inlineHint is the button shown above inline results, opening a private chat
+// with the bot
+func inlineHint(text string) *tg.InlineBotSwitchPM {
+	return &tg.InlineBotSwitchPM{Text: text, StartParam: "inline"}
+}
+
+// inlineQueueFailure tells the user why their inline pick was not queued
+func inlineQueueFailure(err error) string {
+	var limitErr *UserLimitError
+	var duplicateErr *DuplicateRequestError
+	switch {
+	case errors.As(err, &limitErr):
+		return fmt.Sprintf("❌ You already have %d songs queued. Please wait for them to finish before adding more.", limitErr.Pending)
+	case errors.As(err, &duplicateErr):
+		return duplicateRequestMessage(duplicateErr)
+	}
+	return "❌ Couldn't queue your song, please try again later."
+}
+
+// allow takes an inline query of userID from the limiter and reports whether
+// it is within their limit
+func (h *InlineHandler) allow(userID int64) bool {
+	ok, _, _ := h.limiter.Allow(userID, 0)
+	return ok
+}
@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// mockInlineAPI records the answers to inline queries
+type mockInlineAPI struct {
+	answers []*tg.MessagesSetInlineBotResultsRequest
+}
+
+func (m *mockInlineAPI) MessagesSetInlineBotResults(ctx context.Context, request *tg.MessagesSetInlineBotResultsRequest) (bool, error) {
+	m.answers = append(m.answers, request)
+	return true, nil
+}
+
+// newTestInlineHandler creates an inline handler with a mocked API, sender
+// and previews, whose song queue never starts downloads
+func newTestInlineHandler() (*InlineHandler, *mockInlineAPI, *recordingSender) {
+	logger := logging.Discard()
+	songHandler := NewSongHandler(nil, logger)
+	songHandler.queue.workers = 0
+
+	api := &mockInlineAPI{}
+	sender := &recordingSender{}
+	handler := NewInlineHandler(nil, logger, songHandler)
+	handler.api = api
+	handler.sender = sender
+	handler.preview = func(ctx context.Context, urlMeta *downloader.URLMeta) (*downloader.SongPreview, error) {
+		return &downloader.SongPreview{Title: "Song", Artist: "Artist", Album: "Album", ArtworkURL: "https://example.com/300x300bb.jpg"}, nil
+	}
+	return handler, api, sender
+}
+
+func TestInlineSongURL(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		wantURL string
+		wantID  string
+	}{
+		{"song link", " https://music.apple.com/us/song/song/1559523359 ", "https://music.apple.com/us/song/song/1559523359", "1559523359"},
+		{"song in an album", "https://music.apple.com/us/album/album/1559523357?i=1559523359", "https://music.apple.com/us/album/album/1559523357?i=1559523359", "1559523359"},
+		{"missing storefront", "https://music.apple.com/song/song/1559523359", "https://music.apple.com/us/song/song/1559523359", "1559523359"},
+		{"album", "https://music.apple.com/us/album/album/1559523357", "", ""},
+		{"playlist", "https://music.apple.com/us/playlist/mix/pl.u-123", "", ""},
+		{"not a link", "never gonna give you up", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			songURL, urlMeta := inlineSongURL(tc.query)
+			if tc.wantID == "" {
+				if urlMeta != nil {
+					t.Errorf("Expected %q to be rejected, got %+v", tc.query, urlMeta)
+				}
+				return
+			}
+			if urlMeta == nil || urlMeta.ID != tc.wantID {
+				t.Fatalf("Expected song %s, got %+v", tc.wantID, urlMeta)
+			}
+			if songURL != tc.wantURL {
+				t.Errorf("songURL = %q, want %q", songURL, tc.wantURL)
+			}
+		})
+	}
+}
+
+func TestInlineResults(t *testing.T) {
+	preview := &downloader.SongPreview{Title: "Song", Artist: "Artist", Album: "Album", ArtworkURL: "https://example.com/art.jpg"}
+	songURL := "https://music.apple.com/us/song/song/100"
+
+	t.Run("song to queue", func(t *testing.T) {
+		results := inlineResults(songURL, "100", preview, ArchivedSong{})
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 result, got %d", len(results))
+		}
+		article, ok := results[0].(*tg.InputBotInlineResult)
+		if !ok || article.ID != "queue:100" || article.Type != "article" {
+			t.Fatalf("Expected a queue article, got %#v", results[0])
+		}
+		if article.Title != "Song" || article.Description != "Artist — Album" {
+			t.Errorf("Unexpected title %q and description %q", article.Title, article.Description)
+		}
+		if article.Thumb == nil || article.Thumb.URL != preview.ArtworkURL {
+			t.Errorf("Expected the artwork as thumbnail, got %+v", article.Thumb)
+		}
+		message, ok := article.SendMessage.(*tg.InputBotInlineMessageText)
+		if !ok || message.Message != "🎵 Song — Artist\n"+songURL {
+			t.Fatalf("Unexpected message %#v", article.SendMessage)
+		}
+		if spans := entitySpans(t, message.Message, message.Entities); strings.Join(spans, "|") != "bold:Song" {
+			t.Errorf("spans = %q", spans)
+		}
+	})
+
+	t.Run("archived song", func(t *testing.T) {
+		archived := ArchivedSong{Parts: 1, Documents: []ArchivedDocument{{MessageID: 5, DocumentID: 7, AccessHash: 8, FileReference: []byte{9}}}}
+		results := inlineResults(songURL, "100", preview, archived)
+		if len(results) != 2 {
+			t.Fatalf("Expected the audio and the article, got %d results", len(results))
+		}
+		document, ok := results[0].(*tg.InputBotInlineResultDocument)
+		if !ok || document.ID != "archived:100" || document.Type != "audio" {
+			t.Fatalf("Expected the archived audio first, got %#v", results[0])
+		}
+		if input, ok := document.Document.(*tg.InputDocument); !ok || input.ID != 7 || input.AccessHash != 8 {
+			t.Errorf("Expected archived document 7, got %#v", document.Document)
+		}
+	})
+
+	t.Run("split archived song", func(t *testing.T) {
+		archived := ArchivedSong{Parts: 2, Documents: []ArchivedDocument{{DocumentID: 7}, {DocumentID: 8}}}
+		if results := inlineResults(songURL, "100", &downloader.SongPreview{Title: "Song"}, archived); len(results) != 1 {
+			t.Errorf("Expected only the article for a split song, got %d results", len(results))
+		} else if article := results[0].(*tg.InputBotInlineResult); article.Thumb != nil || article.Description != "" {
+			t.Errorf("Expected no thumbnail or album without them, got %+v", article)
+		}
+	})
+}
+
+func TestInlineHandler_HandleInlineQuery(t *testing.T) {
+	t.Run("song link", func(t *testing.T) {
+		handler, api, _ := newTestInlineHandler()
+		query := &tg.UpdateBotInlineQuery{QueryID: 1, UserID: 42, Query: "https://music.apple.com/us/song/song/100"}
+
+		if err := handler.HandleInlineQuery(context.Background(), query); err != nil {
+			t.Fatalf("HandleInlineQuery() error = %v", err)
+		}
+		if len(api.answers) != 1 {
+			t.Fatalf("Expected 1 answer, got %d", len(api.answers))
+		}
+		answer := api.answers[0]
+		if answer.QueryID != 1 || len(answer.Results) != 1 || !answer.IsPersonal {
+			t.Errorf("Unexpected answer %+v", answer)
+		}
+	})
+
+	t.Run("invalid queries get a hint", func(t *testing.T) {
+		handler, api, _ := newTestInlineHandler()
+		previews := 0
+		handler.preview = func(context.Context, *downloader.URLMeta) (*downloader.SongPreview, error) {
+			previews++
+			return nil, errors.New("unexpected preview")
+		}
+
+		for _, text := range []string{"", "hello", "https://music.apple.com/us/album/album/1559523357"} {
+			if err := handler.HandleInlineQuery(context.Background(), &tg.UpdateBotInlineQuery{UserID: 42, Query: text}); err != nil {
+				t.Fatalf("HandleInlineQuery(%q) error = %v", text, err)
+			}
+		}
+		for _, answer := range api.answers {
+			if len(answer.Results) != 0 || answer.SwitchPm == nil {
+				t.Errorf("Expected a hint without results, got %+v", answer)
+			}
+		}
+		if previews != 0 {
+			t.Errorf("Expected no previews for invalid queries, got %d", previews)
+		}
+	})
+
+	t.Run("rate limited per user", func(t *testing.T) {
+		handler, api, _ := newTestInlineHandler()
+		handler.limiter = NewRateLimiter(2, 0)
+		query := func(userID int64) {
+			update := &tg.UpdateBotInlineQuery{UserID: userID, Query: "https://music.apple.com/us/song/song/100"}
+			if err := handler.HandleInlineQuery(context.Background(), update); err != nil {
+				t.Fatalf("HandleInlineQuery() error = %v", err)
+			}
+		}
+
+		query(42)
+		query(42)
+		query(42)
+		query(43)
+		if got := len(api.answers[2].Results); got != 0 || api.answers[2].SwitchPm == nil {
+			t.Errorf("Expected the third query to be rate limited, got %d results", got)
+		}
+		if got := len(api.answers[3].Results); got != 1 {
+			t.Errorf("Expected other users to be answered, got %d results", got)
+		}
+	})
+}
+
+func TestInlineHandler_HandleChosenResult(t *testing.T) {
+	handler, _, sender := newTestInlineHandler()
+	chosen := &tg.UpdateBotInlineSend{UserID: 42, Query: "https://music.apple.com/us/song/song/100", ID: "queue:100"}
+
+	if err := handler.HandleChosenResult(context.Background(), chosen); err != nil {
+		t.Fatalf("HandleChosenResult() error = %v", err)
+	}
+	requests := handler.songHandler.queue.GetRequestsBySender(42, 42).Queued
+	if len(requests) != 1 || requests[0].Request.URL != chosen.Query {
+		t.Fatalf("Expected the song queued for the user's private chat, got %+v", requests)
+	}
+	sent := sender.sent()
+	if len(sent) != 1 || sent[0].chatID != 42 || !strings.Contains(sent[0].message, "queued") {
+		t.Errorf("Expected the user to be told in their private chat, got %+v", sent)
+	}
+
+	// Choosing the song again while it waits reports its position
+	if err := handler.HandleChosenResult(context.Background(), chosen); err != nil {
+		t.Fatalf("HandleChosenResult() error = %v", err)
+	}
+	if sent := sender.sent(); len(sent) != 2 || !strings.Contains(sent[1].message, "already in the queue") {
+		t.Errorf("Expected a duplicate notice, got %+v", sent)
+	}
+
+	// Archived songs were sent by Telegram already
+	archived := &tg.UpdateBotInlineSend{UserID: 42, Query: chosen.Query, ID: "archived:100"}
+	if err := handler.HandleChosenResult(context.Background(), archived); err != nil {
+		t.Fatalf("HandleChosenResult() error = %v", err)
+	}
+	if sent := sender.sent(); len(sent) != 2 {
+		t.Errorf("Expected nothing sent for an archived song, got %+v", sent[2:])
+	}
+}
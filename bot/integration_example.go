@@ -1,9 +1,10 @@
 package bot
 
 import (
-	"log"
+	"os"
 
 	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 // ExampleBotIntegration demonstrates how to integrate all command handlers with the bot
@@ -15,7 +16,7 @@ func ExampleBotIntegration() error {
 	}
 
 	// Create logger
-	logger := log.New(log.Writer(), "[BOT] ", log.LstdFlags)
+	logger := logging.New(os.Stderr, logging.LevelInfo)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -26,17 +27,17 @@ func ExampleBotIntegration() error {
 	// Create and register the StartHandler
 	startHandler := NewStartHandler(bot, logger)
 	bot.RegisterCommandHandler(startHandler)
-	logger.Printf("StartHandler registered successfully for command: /%s", startHandler.Command())
+	logger.Info("StartHandler registered successfully", logging.String("Command", "/"+startHandler.Command()))
 
 	// Create and register the PingHandler
 	pingHandler := NewPingHandler(bot, logger)
 	bot.RegisterCommandHandler(pingHandler)
-	logger.Printf("PingHandler registered successfully for command: /%s", pingHandler.Command())
+	logger.Info("PingHandler registered successfully", logging.String("Command", "/"+pingHandler.Command()))
 
 	// Create and register the HelpHandler
 	helpHandler := NewHelpHandler(bot, logger)
 	bot.RegisterCommandHandler(helpHandler)
-	logger.Printf("HelpHandler registered successfully for command: /%s", helpHandler.Command())
+	logger.Info("HelpHandler registered successfully", logging.String("Command", "/"+helpHandler.Command()))
 
 	// At this point, the bot would be ready to handle /start, /ping, and /help commands
 	// In a real application, you would call bot.Start() here
@@ -53,7 +54,7 @@ func ExampleStartHandlerIntegration() error {
 	}
 
 	// Create logger
-	logger := log.New(log.Writer(), "[BOT] ", log.LstdFlags)
+	logger := logging.New(os.Stderr, logging.LevelInfo)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -65,7 +66,7 @@ func ExampleStartHandlerIntegration() error {
 	startHandler := NewStartHandler(bot, logger)
 	bot.RegisterCommandHandler(startHandler)
 
-	logger.Printf("StartHandler registered successfully for command: /%s", startHandler.Command())
+	logger.Info("StartHandler registered successfully", logging.String("Command", "/"+startHandler.Command()))
 
 	// At this point, the bot would be ready to handle /start commands
 	// In a real application, you would call bot.Start() here
@@ -82,7 +83,7 @@ func ExamplePingHandlerIntegration() error {
 	}
 
 	// Create logger
-	logger := log.New(log.Writer(), "[BOT] ", log.LstdFlags)
+	logger := logging.New(os.Stderr, logging.LevelInfo)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -94,7 +95,7 @@ func ExamplePingHandlerIntegration() error {
 	pingHandler := NewPingHandler(bot, logger)
 	bot.RegisterCommandHandler(pingHandler)
 
-	logger.Printf("PingHandler registered successfully for command: /%s", pingHandler.Command())
+	logger.Info("PingHandler registered successfully", logging.String("Command", "/"+pingHandler.Command()))
 
 	// At this point, the bot would be ready to handle /ping commands
 	// In a real application, you would call bot.Start() here
@@ -111,7 +112,7 @@ func ExampleHelpHandlerIntegration() error {
 	}
 
 	// Create logger
-	logger := log.New(log.Writer(), "[BOT] ", log.LstdFlags)
+	logger := logging.New(os.Stderr, logging.LevelInfo)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -123,7 +124,7 @@ func ExampleHelpHandlerIntegration() error {
 	helpHandler := NewHelpHandler(bot, logger)
 	bot.RegisterCommandHandler(helpHandler)
 
-	logger.Printf("HelpHandler registered successfully for command: /%s", helpHandler.Command())
+	logger.Info("HelpHandler registered successfully", logging.String("Command", "/"+helpHandler.Command()))
 
 	// At this point, the bot would be ready to handle /help commands
 	// In a real application, you would call bot.Start() here
@@ -1,11 +1,11 @@
 package bot
 
 import (
-	"log"
 	"os"
 	"testing"
 
 	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 func TestStartHandlerIntegration(t *testing.T) {
@@ -26,7 +26,7 @@ func TestStartHandlerIntegration(t *testing.T) {
 	}
 
 	// Create logger
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -98,7 +98,7 @@ func TestPingHandlerIntegration(t *testing.T) {
 	}
 
 	// Create logger
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 
 	// Create bot instance
 	bot, err := NewTelegramBot(cfg, logger)
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// LanguageHandler implements CommandHandler for the /language command, which
+// sets the message language and the tag language of a chat
+type LanguageHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewLanguageHandler creates a new LanguageHandler instance
+func NewLanguageHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *LanguageHandler {
+	handler := &LanguageHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *LanguageHandler) Command() string {
+	return "language"
+}
+
+// Handle processes the /language command, showing or changing the chat settings
+func (h *LanguageHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /language command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	settings := h.songHandler.GetLanguages()
+
+	target, language, err := parseLanguageArgs(cmdCtx.Args)
+	if err != nil {
+		localizer := h.songHandler.localizer(cmdCtx.ChatID, cmdCtx.LanguageCode)
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error()+"\n"+localizer.T("language.usage"))
+	}
+
+	switch target {
+	case "ui":
+		settings.SetUILanguage(cmdCtx.ChatID, language)
+	case "tags":
+		settings.SetMetadataLanguage(cmdCtx.ChatID, language)
+	}
+	if target != "" {
+		h.logger.Info("Set chat language", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID), logging.String("Target", target), logging.String("Language", language))
+	}
+
+	// Replies are in the UI language as it is after the change
+	localizer := h.songHandler.localizer(cmdCtx.ChatID, cmdCtx.LanguageCode)
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createLanguageMessage(localizer, settings.Get(cmdCtx.ChatID), target != ""))
+}
+
+// parseLanguageArgs parses "ui <code>" or "tags <code>", where "default"
+// clears the setting. No argument returns an empty target, to show the
+// settings
+func parseLanguageArgs(args string) (target string, language string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", "", nil
+	}
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected a setting and a language")
+	}
+
+	target = strings.ToLower(fields[0])
+	switch target {
+	case "ui":
+	case "tags", "metadata":
+		target = "tags"
+	default:
+		return "", "", fmt.Errorf("unknown setting %q", fields[0])
+	}
+
+	if strings.EqualFold(fields[1], "default") {
+		return target, "", nil
+	}
+	language, err = NormalizeLanguage(fields[1])
+	if err != nil {
+		return "", "", err
+	}
+	return target, language, nil
+}
+
+// createLanguageMessage describes the language settings of a chat
+func createLanguageMessage(localizer *Localizer, settings ChatLanguage, changed bool) string {
+	orDefault := func(language string) string {
+		if language == "" {
+			return localizer.T("language.default")
+		}
+		return language
+	}
+
+	message := localizer.T("language.settings", orDefault(settings.UILanguage), orDefault(settings.MetadataLanguage))
+	if changed {
+		return localizer.T("language.updated") + "\n\n" + message
+	}
+	return message
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+)
+
+// latencyMiddleware records the duration of Telegram sends, edits and uploads
+// in tracker. RPC results arrive whole, so the first byte and total durations
+// of a call are the same
+func latencyMiddleware(tracker *downloader.LatencyTracker) telegram.MiddlewareFunc {
+	return func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			dep, ok := telegramDependency(input)
+			if !ok {
+				return next.Invoke(ctx, input, output)
+			}
+
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+			elapsed := time.Since(start)
+			tracker.Record(dep, elapsed, elapsed)
+			return err
+		}
+	}
+}
+
+// telegramDependency returns the dependency a Telegram request is timed as,
+// false for requests that are not timed
+func telegramDependency(input bin.Encoder) (downloader.Dependency, bool) {
+	switch input.(type) {
+	case *tg.MessagesSendMessageRequest, *tg.MessagesSendMediaRequest:
+		return downloader.DepTelegramSend, true
+	case *tg.MessagesEditMessageRequest:
+		return downloader.DepTelegramEdit, true
+	case *tg.UploadSaveFilePartRequest, *tg.UploadSaveBigFilePartRequest:
+		return downloader.DepTelegramUpload, true
+	default:
+		return "", false
+	}
+}
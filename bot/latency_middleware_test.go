@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+)
+
+func TestLatencyMiddleware(t *testing.T) {
+	tracker := downloader.NewLatencyTracker()
+	next := telegram.InvokeFunc(func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	})
+	invoker := latencyMiddleware(tracker).Handle(next)
+
+	for _, input := range []bin.Encoder{
+		&tg.MessagesSendMessageRequest{},
+		&tg.MessagesSendMediaRequest{},
+		&tg.MessagesEditMessageRequest{},
+		&tg.UploadSaveBigFilePartRequest{},
+		&tg.UploadSaveFilePartRequest{},
+		&tg.UploadSaveFilePartRequest{},
+		&tg.HelpGetConfigRequest{},
+	} {
+		if err := invoker.Invoke(context.Background(), input, nil); err != nil {
+			t.Fatalf("Invoke() error = %v", err)
+		}
+	}
+
+	samples := make(map[downloader.Dependency]int)
+	for _, s := range tracker.Stats() {
+		samples[s.Dependency] = s.Samples
+		if s.TotalP50 < 2*time.Millisecond || s.FirstByteP50 != s.TotalP50 {
+			t.Errorf("Expected %s calls timed as a whole, got %+v", s.Dependency, s)
+		}
+	}
+	want := map[downloader.Dependency]int{
+		downloader.DepTelegramSend:   2,
+		downloader.DepTelegramEdit:   1,
+		downloader.DepTelegramUpload: 3,
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, samples)
+	}
+	for dep, n := range want {
+		if samples[dep] != n {
+			t.Errorf("Expected %d %s calls, got %d", n, dep, samples[dep])
+		}
+	}
+}
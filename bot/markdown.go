@@ -0,0 +1,13 @@
+package bot
+
+import (
+	"go-alac-bot/downloader"
+
+	"github.com/gotd/td/tg"
+)
+
+// ParseMarkdown strips the markdown from text and returns the plain text with
+// the entities it stood for, as the progress messages are formatted
+func ParseMarkdown(text string) (string, []tg.MessageEntityClass) {
+	return downloader.ParseMarkdown(text)
+}
@@ -0,0 +1,29 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+func TestSongHandler_SendMessageFormatsMarkdown(t *testing.T) {
+	api := &mockReactionAPI{}
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.api = api
+
+	// The mock answers without a message ID, only the request matters here
+	_, _ = handler.sendMessageWithID(context.Background(), 12345, "📋 **Queued:** 🎵 `Señorita 💃`")
+	if len(api.sends) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(api.sends))
+	}
+	request := api.sends[0]
+	if strings.ContainsAny(request.Message, "*`") {
+		t.Errorf("Expected the markdown to be stripped, got %q", request.Message)
+	}
+	spans := entitySpans(t, request.Message, request.Entities)
+	if want := "bold:Queued:|code:Señorita 💃"; strings.Join(spans, "|") != want {
+		t.Errorf("spans = %q, want %q", spans, want)
+	}
+}
@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-alac-bot/downloader"
+
+	"github.com/gotd/td/tg"
+)
+
+// errClientNotInitialized is returned when a message is sent before the bot
+// client is created
+var errClientNotInitialized = errors.New("bot client is not initialized")
+
+// MessageSender sends and edits the bot's messages. Markdown messages are
+// formatted with ParseMarkdown
+type MessageSender interface {
+	// SendText sends text as is
+	SendText(ctx context.Context, chatID int64, text string) error
+	// SendMarkdown sends a markdown message
+	SendMarkdown(ctx context.Context, chatID int64, message string) error
+	// Reply sends a markdown message replying to messageID (0 = none), or
+	// without the reply when that message was deleted
+	Reply(ctx context.Context, chatID int64, messageID int, message string) error
+	// EditMessage replaces the text of a message with a markdown one,
+	// removing its buttons
+	EditMessage(ctx context.Context, chatID int64, messageID int, message string) error
+}
+
+// apiSender is the MessageSender of a Telegram API, sending to the peers a
+// resolver builds
+type apiSender struct {
+	api   downloader.TelegramAPI
+	peers *PeerResolver
+}
+
+// SendText implements MessageSender
+func (s *apiSender) SendText(ctx context.Context, chatID int64, text string) error {
+	_, err := s.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:     s.peers.InputPeer(chatID),
+		Message:  text,
+		RandomID: time.Now().UnixNano(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message via Telegram API: %w", err)
+	}
+	return nil
+}
+
+// SendMarkdown implements MessageSender
+func (s *apiSender) SendMarkdown(ctx context.Context, chatID int64, message string) error {
+	_, err := s.send(ctx, chatID, 0, message)
+	return err
+}
+
+// Reply implements MessageSender
+func (s *apiSender) Reply(ctx context.Context, chatID int64, messageID int, message string) error {
+	_, err := s.send(ctx, chatID, messageID, message)
+	return err
+}
+
+// EditMessage implements MessageSender
+func (s *apiSender) EditMessage(ctx context.Context, chatID int64, messageID int, message string) error {
+	return downloader.SendFormatted(message, func(text string, entities []tg.MessageEntityClass) error {
+		_, err := s.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+			Peer:     s.peers.InputPeer(chatID),
+			ID:       messageID,
+			Message:  text,
+			Entities: entities,
+		})
+		return err
+	})
+}
+
+// send sends a markdown message replying to messageID (0 = none) and returns
+// the updates Telegram answered with
+func (s *apiSender) send(ctx context.Context, chatID int64, messageID int, message string) (tg.UpdatesClass, error) {
+	var updates tg.UpdatesClass
+	err := downloader.SendReplying(messageID, func(replyTo tg.InputReplyToClass) error {
+		return downloader.SendFormatted(message, func(text string, entities []tg.MessageEntityClass) error {
+			var err error
+			updates, err = s.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+				Peer:     s.peers.InputPeer(chatID),
+				Message:  text,
+				Entities: entities,
+				ReplyTo:  replyTo,
+				RandomID: time.Now().UnixNano(),
+			})
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message via Telegram API: %w", err)
+	}
+	return updates, nil
+}
+
+// messages returns the sender of the bot's messages, nil before the client
+// is created
+func (b *TelegramBot) messages() *apiSender {
+	if b == nil || b.client == nil {
+		return nil
+	}
+	return &apiSender{api: b.client.API(), peers: b.peers}
+}
+
+// SendText sends a plain text message to the specified chat
+func (b *TelegramBot) SendText(ctx context.Context, chatID int64, text string) error {
+	sender := b.messages()
+	if sender == nil {
+		return errClientNotInitialized
+	}
+	return sender.SendText(ctx, chatID, text)
+}
+
+// SendMarkdown sends a markdown message to the specified chat
+func (b *TelegramBot) SendMarkdown(ctx context.Context, chatID int64, message string) error {
+	sender := b.messages()
+	if sender == nil {
+		return errClientNotInitialized
+	}
+	return sender.SendMarkdown(ctx, chatID, message)
+}
+
+// Reply sends a markdown message replying to a message of the chat
+func (b *TelegramBot) Reply(ctx context.Context, chatID int64, messageID int, message string) error {
+	sender := b.messages()
+	if sender == nil {
+		return errClientNotInitialized
+	}
+	return sender.Reply(ctx, chatID, messageID, message)
+}
+
+// EditMessage replaces the text of a message sent by the bot
+func (b *TelegramBot) EditMessage(ctx context.Context, chatID int64, messageID int, message string) error {
+	sender := b.messages()
+	if sender == nil {
+		return errClientNotInitialized
+	}
+	return sender.EditMessage(ctx, chatID, messageID, message)
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// sentMessage is a message a recordingSender was asked to send or edit
+type sentMessage struct {
+	kind      string // text, markdown, reply or edit
+	chatID    int64
+	messageID int // Message replied to or edited
+	message   string
+}
+
+// recordingSender is a MessageSender keeping the messages handlers send
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []sentMessage
+	err      error // Returned by every send when set
+}
+
+func (s *recordingSender) record(message sentMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+func (s *recordingSender) sent() []sentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sentMessage(nil), s.messages...)
+}
+
+func (s *recordingSender) SendText(ctx context.Context, chatID int64, text string) error {
+	return s.record(sentMessage{kind: "text", chatID: chatID, message: text})
+}
+
+func (s *recordingSender) SendMarkdown(ctx context.Context, chatID int64, message string) error {
+	return s.record(sentMessage{kind: "markdown", chatID: chatID, message: message})
+}
+
+func (s *recordingSender) Reply(ctx context.Context, chatID int64, messageID int, message string) error {
+	return s.record(sentMessage{kind: "reply", chatID: chatID, messageID: messageID, message: message})
+}
+
+func (s *recordingSender) EditMessage(ctx context.Context, chatID int64, messageID int, message string) error {
+	return s.record(sentMessage{kind: "edit", chatID: chatID, messageID: messageID, message: message})
+}
+
+// deletedReplyReactionAPI rejects replies as Telegram does once the replied
+// message was deleted
+type deletedReplyReactionAPI struct {
+	*mockReactionAPI
+}
+
+func (a deletedReplyReactionAPI) MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error) {
+	if request.ReplyTo != nil {
+		a.sends = append(a.sends, request)
+		return nil, tgerr.New(400, "REPLY_MESSAGE_ID_INVALID")
+	}
+	return a.mockReactionAPI.MessagesSendMessage(ctx, request)
+}
+
+func TestAPISender_SendMarkdown(t *testing.T) {
+	api := &mockReactionAPI{}
+	sender := &apiSender{api: api}
+
+	if err := sender.SendMarkdown(context.Background(), 12345, "✅ **Done:** `Song`"); err != nil {
+		t.Fatalf("SendMarkdown() error = %v", err)
+	}
+	if len(api.sends) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(api.sends))
+	}
+	request := api.sends[0]
+	if request.Message != "✅ Done: Song" || request.ReplyTo != nil {
+		t.Errorf("Expected a plain message without reply, got %q replying to %v", request.Message, request.ReplyTo)
+	}
+	if spans := entitySpans(t, request.Message, request.Entities); strings.Join(spans, "|") != "bold:Done:|code:Song" {
+		t.Errorf("spans = %q", spans)
+	}
+}
+
+func TestAPISender_Reply(t *testing.T) {
+	t.Run("replies to the message", func(t *testing.T) {
+		api := &mockReactionAPI{}
+		sender := &apiSender{api: api}
+
+		if err := sender.Reply(context.Background(), 12345, 42, "hello"); err != nil {
+			t.Fatalf("Reply() error = %v", err)
+		}
+		replyTo, ok := api.sends[0].ReplyTo.(*tg.InputReplyToMessage)
+		if !ok || replyTo.ReplyToMsgID != 42 {
+			t.Errorf("Expected a reply to message 42, got %v", api.sends[0].ReplyTo)
+		}
+	})
+
+	t.Run("sends without the reply once the message is deleted", func(t *testing.T) {
+		api := deletedReplyReactionAPI{&mockReactionAPI{}}
+		sender := &apiSender{api: api}
+
+		if err := sender.Reply(context.Background(), 12345, 42, "hello"); err != nil {
+			t.Fatalf("Reply() error = %v", err)
+		}
+		if len(api.sends) != 2 || api.sends[1].ReplyTo != nil {
+			t.Errorf("Expected a retry without the reply, got %d sends", len(api.sends))
+		}
+	})
+}
+
+func TestAPISender_EditMessage(t *testing.T) {
+	api := &mockReactionAPI{}
+	sender := &apiSender{api: api}
+
+	if err := sender.EditMessage(context.Background(), 12345, 9, "*Cancelled*"); err != nil {
+		t.Fatalf("EditMessage() error = %v", err)
+	}
+	if len(api.edits) != 1 {
+		t.Fatalf("Expected 1 edit, got %d", len(api.edits))
+	}
+	if edit := api.edits[0]; edit.ID != 9 || edit.Message != "Cancelled" || len(edit.Entities) != 1 {
+		t.Errorf("Unexpected edit %+v", edit)
+	}
+}
+
+func TestTelegramBot_SendWithoutClient(t *testing.T) {
+	var bot *TelegramBot
+	if err := bot.SendMarkdown(context.Background(), 12345, "hello"); !errors.Is(err, errClientNotInitialized) {
+		t.Errorf("Expected errClientNotInitialized, got %v", err)
+	}
+
+	// Handlers fall back to the bot, which fails the same way
+	handler := NewSongHandler(nil, logging.Discard())
+	if err := handler.sender().SendMarkdown(context.Background(), 12345, "hello"); !errors.Is(err, errClientNotInitialized) {
+		t.Errorf("Expected errClientNotInitialized, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// MyHandler implements CommandHandler for the /my command
+type MyHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewMyHandler creates a new MyHandler instance
+func NewMyHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *MyHandler {
+	handler := &MyHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *MyHandler) Command() string {
+	return "my"
+}
+
+// Handle processes the /my command and shows the caller's own requests in this chat
+func (h *MyHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /my command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, "❌ Queue system is not available.")
+	}
+
+	requests := queue.GetRequestsBySender(cmdCtx.ChatID, cmdCtx.UserID)
+	return h.sender.Reply(timeoutCtx, cmdCtx.ChatID, cmdCtx.MessageID, createMyRequestsMessage(requests, time.Now()))
+}
+
+// createMyRequestsMessage creates the /my message. It only ever contains the
+// caller's own requests, so it is safe to send in groups
+func createMyRequestsMessage(requests SenderRequests, now time.Time) string {
+	if requests.IsEmpty() {
+		return "📭 You have no pending or recent requests in this chat.\n\n💡 Use `/song <url>` to add a song to the queue"
+	}
+
+	var message strings.Builder
+	message.WriteString("📋 **Your Requests**\n")
+
+	if len(requests.Processing) > 0 {
+		message.WriteString("\n🎵 **Processing:**\n")
+		for _, info := range requests.Processing {
+			fmt.Fprintf(&message, "• %s\n", info.Request.URL)
+			if !info.HasStatus {
+				fmt.Fprintf(&message, "   Starting (%s)\n", downloader.FormatDuration(downloader.Elapsed(info.Request.StartedAt, now)))
+				continue
+			}
+
+			status := fmt.Sprintf("   %s", capitalize(info.Status.Phase.String()))
+			if info.Status.Progress.TotalBytes > 0 {
+				status += fmt.Sprintf(" — %.0f%%", info.Status.Progress.Percentage)
+			}
+			if info.Status.SongName != "" {
+				status += fmt.Sprintf(" · %s", info.Status.SongName)
+			}
+			message.WriteString(status + "\n")
+		}
+	}
+
+	if len(requests.Queued) > 0 {
+		message.WriteString("\n⏳ **Queued:**\n")
+		for _, info := range requests.Queued {
+			fmt.Fprintf(&message, "• #%d — %s\n   Starts in about %s\n",
+				info.Position, info.Request.URL, formatETA(info.ETA))
+		}
+	}
+
+	if len(requests.Finished) > 0 {
+		message.WriteString("\n🕐 **Last hour:**\n")
+		for _, request := range requests.Finished {
+			ago := downloader.FormatDuration(downloader.Elapsed(request.FinishedAt, now))
+			switch request.Status {
+			case StatusFailed:
+				fmt.Fprintf(&message, "• ❌ %s\n   %s (%s ago)\n", request.URL, request.FailureReason, ago)
+			case StatusCancelled:
+				fmt.Fprintf(&message, "• 🛑 %s\n   cancelled (%s ago)\n", request.URL, ago)
+			default:
+				fmt.Fprintf(&message, "• ✅ %s (%s ago)\n", request.URL, ago)
+			}
+		}
+	}
+
+	return message.String()
+}
+
+// formatETA rounds an estimate to whole minutes, or seconds below a minute
+func formatETA(eta time.Duration) string {
+	if eta < time.Minute || eta > downloader.MaxRenderedDuration {
+		return downloader.FormatDuration(eta)
+	}
+	return eta.Round(time.Minute).String()
+}
+
+// capitalize upper-cases the first letter of s
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestMyHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewMyHandler(nil, logger, NewSongHandler(nil, logger))
+
+	expected := "my"
+	if got := handler.Command(); got != expected {
+		t.Errorf("MyHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateMyRequestsMessage(t *testing.T) {
+	now := time.Now()
+
+	requests := SenderRequests{
+		Queued: []QueuedRequestInfo{
+			{Request: QueueRequest{URL: "https://music.apple.com/in/song/queued/5"}, Position: 3, ETA: 150 * time.Second},
+		},
+		Processing: []ProcessingRequestInfo{
+			{
+				Request: QueueRequest{URL: "https://music.apple.com/in/song/slow/3", StartedAt: now},
+				Status: downloader.DownloadStatus{
+					Phase:    downloader.PhaseDecrypting,
+					Progress: downloader.Progress{TotalBytes: 100, Percentage: 61.4},
+					SongName: "Test Song",
+				},
+				HasStatus: true,
+			},
+		},
+		Finished: []QueueRequest{
+			{URL: "https://music.apple.com/in/song/fail/2", Status: StatusFailed, FailureReason: "download failed: boom", FinishedAt: now.Add(-2 * time.Minute)},
+			{URL: "https://music.apple.com/in/song/ok/1", Status: StatusCompleted, FinishedAt: now.Add(-5 * time.Minute)},
+		},
+	}
+
+	message := createMyRequestsMessage(requests, now)
+
+	expected := []string{
+		"Your Requests",
+		"Decrypting — 61% · Test Song",
+		"#3 — https://music.apple.com/in/song/queued/5",
+		"Starts in about 3m0s",
+		"❌ https://music.apple.com/in/song/fail/2",
+		"download failed: boom (2m0s ago)",
+		"✅ https://music.apple.com/in/song/ok/1 (5m0s ago)",
+	}
+	for _, part := range expected {
+		if !strings.Contains(message, part) {
+			t.Errorf("Expected %q in message:\n%s", part, message)
+		}
+	}
+}
+
+func TestCreateMyRequestsMessage_Empty(t *testing.T) {
+	message := createMyRequestsMessage(SenderRequests{}, time.Now())
+	if !strings.Contains(message, "no pending or recent requests") {
+		t.Errorf("Expected empty message, got %q", message)
+	}
+}
+
+func TestCreateMyRequestsMessage_ClockSkew(t *testing.T) {
+	now := time.Now().Round(0)
+
+	requests := SenderRequests{
+		Queued: []QueuedRequestInfo{
+			{Request: QueueRequest{URL: "https://music.apple.com/in/song/queued/5"}, Position: 1, ETA: 1000 * time.Hour},
+		},
+		Processing: []ProcessingRequestInfo{
+			// Started "in the future" after the wall clock was stepped back
+			{Request: QueueRequest{URL: "https://music.apple.com/in/song/slow/3", StartedAt: now.Add(time.Hour)}},
+		},
+		Finished: []QueueRequest{
+			{URL: "https://music.apple.com/in/song/ok/1", Status: StatusCompleted, FinishedAt: now.Add(10 * time.Minute)},
+		},
+	}
+
+	message := createMyRequestsMessage(requests, now)
+
+	for _, part := range []string{"Starting (0s)", "Starts in about >24h", "(0s ago)"} {
+		if !strings.Contains(message, part) {
+			t.Errorf("Expected %q in message:\n%s", part, message)
+		}
+	}
+	if strings.Contains(message, "-") {
+		t.Errorf("Expected no negative durations:\n%s", message)
+	}
+}
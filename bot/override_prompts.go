@@ -0,0 +1,306 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const (
+	// OverridePromptTTL is how long the storefront choices offered for a song
+	// without ALAC can be answered
+	OverridePromptTTL = 10 * time.Minute
+
+	overrideCallbackPrefix = "ovr"
+	overrideCancelChoice   = "cancel"
+	overrideAACChoice      = "aac"
+	overrideTokenBytes     = 6
+	maxOverrideChoices     = 3
+)
+
+// overrideCandidateStorefronts are checked for ALAC, after the default
+// storefront, when a song has none in the storefronts tried
+var overrideCandidateStorefronts = []string{"us", "gb", "jp"}
+
+var (
+	errPromptExpired  = errors.New("prompt expired")
+	errPromptNotYours = errors.New("prompt belongs to another user")
+	errPromptChoice   = errors.New("not one of the offered choices")
+)
+
+// OverridePrompt is an unanswered choice of storefronts, or AAC, offered for
+// a failed request. The buttons only carry its token, the request stays server-side
+type OverridePrompt struct {
+	RequestID   string
+	ChatID      int64
+	UserID      int64
+	MessageID   int
+	Storefronts []string
+	createdAt   time.Time
+}
+
+// OverridePrompts keeps the unanswered prompts until they are answered or expire
+type OverridePrompts struct {
+	mu      sync.Mutex
+	prompts map[string]*OverridePrompt
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewOverridePrompts creates an empty prompt store
+func NewOverridePrompts() *OverridePrompts {
+	return &OverridePrompts{
+		prompts: make(map[string]*OverridePrompt),
+		ttl:     OverridePromptTTL,
+		now:     time.Now,
+	}
+}
+
+// Create stores prompt and returns the token its buttons refer to
+func (op *OverridePrompts) Create(prompt *OverridePrompt) (string, error) {
+	buf := make([]byte, overrideTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	prompt.createdAt = op.now()
+	op.prompts[token] = prompt
+	return token, nil
+}
+
+// setMessageID records the message showing the prompt of token
+func (op *OverridePrompts) setMessageID(token string, messageID int) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if prompt, ok := op.prompts[token]; ok {
+		prompt.MessageID = messageID
+	}
+}
+
+// Answer removes and returns the prompt of token answered by userID with
+// choice. Presses by other users or with unknown choices leave it in place
+func (op *OverridePrompts) Answer(token string, userID int64, choice string) (*OverridePrompt, error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	prompt, ok := op.prompts[token]
+	if !ok || op.now().Sub(prompt.createdAt) > op.ttl {
+		delete(op.prompts, token)
+		return nil, errPromptExpired
+	}
+	if prompt.UserID != userID {
+		return nil, errPromptNotYours
+	}
+	if choice != overrideCancelChoice && choice != overrideAACChoice && !slices.Contains(prompt.Storefronts, choice) {
+		return nil, errPromptChoice
+	}
+
+	delete(op.prompts, token)
+	return prompt, nil
+}
+
+// Expire removes and returns the prompt of token if it is still unanswered
+func (op *OverridePrompts) Expire(token string) (*OverridePrompt, bool) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	prompt, ok := op.prompts[token]
+	delete(op.prompts, token)
+	return prompt, ok
+}
+
+// overrideCandidates returns the storefronts worth checking for ALAC after
+// the ones in tried had none
+func overrideCandidates(tried []string, defaultStorefront string) []string {
+	var candidates []string
+	for _, storefront := range append([]string{defaultStorefront}, overrideCandidateStorefronts...) {
+		if storefront != "" && !slices.Contains(tried, storefront) && !slices.Contains(candidates, storefront) {
+			candidates = append(candidates, storefront)
+		}
+	}
+	return candidates
+}
+
+// createOverridePromptMessage creates the question asked when the song has no
+// ALAC in the requested storefront, listing the stores that have it if any
+func createOverridePromptMessage(storefront string, hasStorefronts bool) string {
+	where := "in this store"
+	if storefront != "" {
+		where = fmt.Sprintf("in the %s store", strings.ToUpper(storefront))
+	}
+	if !hasStorefronts {
+		return fmt.Sprintf("⚠️ This song isn't available in ALAC %s.\n\nGet it as AAC 256 instead, or cancel:", where)
+	}
+	return fmt.Sprintf("⚠️ This song isn't available in ALAC %s.\n\nIt is in the stores below. Choose one to try, get AAC 256 instead, or cancel:", where)
+}
+
+// createOverrideMarkup creates one button per storefront, an AAC button and a
+// cancel button. Button data is "ovr:<token>:<choice>"
+func createOverrideMarkup(token string, storefronts []string) *tg.ReplyInlineMarkup {
+	markup := &tg.ReplyInlineMarkup{}
+	for _, storefront := range storefronts {
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{
+			&tg.KeyboardButtonCallback{
+				Text: fmt.Sprintf("Try %s store", strings.ToUpper(storefront)),
+				Data: CallbackData(overrideCallbackPrefix, token+":"+storefront),
+			},
+		}})
+	}
+	markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{
+		&tg.KeyboardButtonCallback{
+			Text: "Get AAC 256 instead",
+			Data: CallbackData(overrideCallbackPrefix, token+":"+overrideAACChoice),
+		},
+	}})
+	markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{
+		&tg.KeyboardButtonCallback{
+			Text: "Cancel",
+			Data: CallbackData(overrideCallbackPrefix, token+":"+overrideCancelChoice),
+		},
+	}})
+	return markup
+}
+
+// promptOverride asks the user to pick another storefront that has ALAC, or
+// AAC instead, when err says the song has no ALAC in any storefront tried. It
+// reports whether the prompt replaced the error report
+func (h *SongHandler) promptOverride(ctx context.Context, cmdCtx *CommandContext, songURL string, songDownloader downloader.SongDownloader, reporter downloader.ProgressReporter, tried []string, err error) bool {
+	// Only queued requests can be re-queued, and a chosen storefront or AAC is final
+	if !downloader.IsDownloadError(err, downloader.ErrorALACNotAvailable) || cmdCtx.RequestID == "" || cmdCtx.Override.Storefront != "" || cmdCtx.Override.AAC {
+		return false
+	}
+	checker, ok := songDownloader.(downloader.ALACChecker)
+	if !ok {
+		return false
+	}
+	prompter, ok := reporter.(downloader.ChoicePrompter)
+	if !ok {
+		return false
+	}
+
+	// AAC is still offered when no other storefront has ALAC
+	available, checkErr := checker.ALACStorefronts(ctx, songURL, overrideCandidates(tried, h.defaultStorefront))
+	if checkErr != nil {
+		h.logger.Warn("Failed to check other storefronts for ALAC", logging.Err(checkErr))
+		available = nil
+	}
+	if len(available) > maxOverrideChoices {
+		available = available[:maxOverrideChoices]
+	}
+
+	token, tokenErr := h.prompts.Create(&OverridePrompt{
+		RequestID:   cmdCtx.RequestID,
+		ChatID:      cmdCtx.ChatID,
+		UserID:      cmdCtx.UserID,
+		Storefronts: available,
+	})
+	if tokenErr != nil {
+		h.logger.Error("Failed to create storefront prompt", logging.Err(tokenErr))
+		return false
+	}
+
+	requested := ""
+	if urlMeta := ExtractURLMeta(songURL); urlMeta != nil {
+		requested = urlMeta.Storefront
+	}
+	messageID, promptErr := prompter.ReportChoices(createOverridePromptMessage(requested, len(available) > 0), createOverrideMarkup(token, available))
+	if promptErr != nil {
+		h.logger.Error("Failed to show storefront prompt", logging.Err(promptErr))
+		h.prompts.Expire(token)
+		return false
+	}
+	h.prompts.setMessageID(token, messageID)
+
+	h.logger.Info("Offered storefronts and AAC for request without ALAC", logging.Any("Storefronts", available), logging.String("Request", cmdCtx.RequestID))
+	time.AfterFunc(h.prompts.ttl, func() { h.expirePrompt(token) })
+	return true
+}
+
+// expirePrompt tells the user an unanswered prompt can no longer be used
+func (h *SongHandler) expirePrompt(token string) {
+	prompt, ok := h.prompts.Expire(token)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.sender().EditMessage(ctx, prompt.ChatID, prompt.MessageID, "⌛ No store was chosen in time. Use /retry to try the song again."); err != nil {
+		h.logger.Warn("Failed to expire storefront prompt", logging.Err(err))
+	}
+}
+
+// CallbackPrefix returns the data prefix of the storefront prompt buttons
+func (h *SongHandler) CallbackPrefix() string {
+	return overrideCallbackPrefix
+}
+
+// HandleCallback processes a press on a storefront prompt button, re-queueing
+// the failed request with the chosen storefront on the same progress message
+func (h *SongHandler) HandleCallback(ctx context.Context, cbCtx *CallbackContext) (string, error) {
+	token, choice, _ := strings.Cut(cbCtx.Data, ":")
+
+	prompt, err := h.prompts.Answer(token, cbCtx.UserID, choice)
+	switch {
+	case errors.Is(err, errPromptExpired):
+		return "⌛ This choice has expired.", nil
+	case errors.Is(err, errPromptNotYours):
+		return "Only the person who requested this song can choose.", nil
+	case err != nil:
+		return "", fmt.Errorf("invalid storefront choice %q: %w", choice, err)
+	}
+
+	if choice == overrideCancelChoice {
+		h.logger.Info("Cancelled request after ALAC was unavailable", logging.Int64("User", cbCtx.UserID), logging.String("Request", prompt.RequestID))
+		return "Cancelled", h.sender().EditMessage(ctx, prompt.ChatID, prompt.MessageID, "❌ Cancelled. Use /retry to try the song again.")
+	}
+
+	queue := h.GetQueue()
+	entry, ok := queue.Failed().TakeRequest(prompt.ChatID, prompt.RequestID)
+	if !ok {
+		return "This request can no longer be retried.", nil
+	}
+
+	override := DownloadOverride{Storefront: choice, ProgressMessageID: prompt.MessageID}
+	if choice == overrideAACChoice {
+		override = DownloadOverride{AAC: true, ProgressMessageID: prompt.MessageID}
+	}
+	request, err := queue.RequeueWithOverride(entry.Request, override)
+	if err != nil {
+		// Keep the entry so the user can use /retry later
+		queue.Failed().Record(entry.Request, entry.Reason)
+		if strings.Contains(err.Error(), "queue is full") {
+			return "Queue is full, please try again later.", nil
+		}
+		return "", fmt.Errorf("failed to re-queue request: %w", err)
+	}
+
+	h.logger.Info("Chose storefront for request", logging.Int64("User", cbCtx.UserID), logging.String("Storefront", choice),
+		logging.String("Request", request.UniqueID), logging.String("Correlation", request.CorrelationID), logging.Int("Attempt", request.Attempt))
+
+	action := fmt.Sprintf("Trying the %s store", strings.ToUpper(choice))
+	if choice == overrideAACChoice {
+		action = "Getting AAC 256"
+	}
+	message := "🔁 " + action + "..."
+	if position := queue.GetQueuePosition(request.UniqueID); position > 0 {
+		message = fmt.Sprintf("🔁 %s (queue position %d)", action, position)
+	}
+	return "", h.sender().EditMessage(ctx, prompt.ChatID, prompt.MessageID, message)
+}
@@ -0,0 +1,321 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// alacMissingDownloader fails like a song without ALAC and reports the
+// storefronts listed in available as having it
+type alacMissingDownloader struct {
+	scriptedDownloader
+	available []string
+	checked   []string
+	urls      []string
+}
+
+func (d *alacMissingDownloader) Download(ctx context.Context, url string, callbacks downloader.ProgressCallbacks) (*downloader.DownloadResult, error) {
+	d.urls = append(d.urls, url)
+	return d.scriptedDownloader.Download(ctx, url, callbacks)
+}
+
+func (d *alacMissingDownloader) ALACStorefronts(ctx context.Context, songURL string, candidates []string) ([]string, error) {
+	d.checked = candidates
+	var available []string
+	for _, storefront := range candidates {
+		if slices.Contains(d.available, storefront) {
+			available = append(available, storefront)
+		}
+	}
+	return available, nil
+}
+
+// promptingReporter records the choices offered on the progress message
+type promptingReporter struct {
+	recordingReporter
+	message string
+	markup  tg.ReplyMarkupClass
+}
+
+func (r *promptingReporter) ReportChoices(message string, markup tg.ReplyMarkupClass) (int, error) {
+	r.message = message
+	r.markup = markup
+	return 42, nil
+}
+
+func TestCreateOverrideMarkup(t *testing.T) {
+	token := "AbCdEfGh"
+	markup := createOverrideMarkup(token, []string{"us", "gb"})
+
+	var texts, data []string
+	for _, row := range markup.Rows {
+		for _, button := range row.Buttons {
+			callback := button.(*tg.KeyboardButtonCallback)
+			texts = append(texts, callback.Text)
+			data = append(data, string(callback.Data))
+			if len(callback.Data) > MaxCallbackDataLength {
+				t.Errorf("Button data %q is longer than %d bytes", callback.Data, MaxCallbackDataLength)
+			}
+		}
+	}
+
+	wantTexts := []string{"Try US store", "Try GB store", "Get AAC 256 instead", "Cancel"}
+	wantData := []string{"ovr:AbCdEfGh:us", "ovr:AbCdEfGh:gb", "ovr:AbCdEfGh:aac", "ovr:AbCdEfGh:cancel"}
+	if !slices.Equal(texts, wantTexts) || !slices.Equal(data, wantData) {
+		t.Errorf("Buttons = %v %v, want %v %v", texts, data, wantTexts, wantData)
+	}
+}
+
+func TestOverrideCandidates(t *testing.T) {
+	got := overrideCandidates([]string{"in", "us"}, "in")
+	if want := []string{"gb", "jp"}; !slices.Equal(got, want) {
+		t.Errorf("overrideCandidates() = %v, want %v", got, want)
+	}
+
+	got = overrideCandidates([]string{"in"}, "de")
+	if want := []string{"de", "us", "gb", "jp"}; !slices.Equal(got, want) {
+		t.Errorf("overrideCandidates() = %v, want %v", got, want)
+	}
+}
+
+func TestOverridePrompts_Answer(t *testing.T) {
+	prompts := NewOverridePrompts()
+	now := time.Now()
+	prompts.now = func() time.Time { return now }
+
+	token, err := prompts.Create(&OverridePrompt{RequestID: "1:100:1", ChatID: 100, UserID: 1, Storefronts: []string{"us"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := prompts.Answer(token, 2, "us"); err != errPromptNotYours {
+		t.Errorf("Answer() by another user error = %v, want %v", err, errPromptNotYours)
+	}
+	if _, err := prompts.Answer(token, 1, "jp"); err != errPromptChoice {
+		t.Errorf("Answer() with an unoffered store error = %v, want %v", err, errPromptChoice)
+	}
+
+	prompt, err := prompts.Answer(token, 1, "us")
+	if err != nil || prompt.RequestID != "1:100:1" {
+		t.Fatalf("Answer() = %+v, %v", prompt, err)
+	}
+	if _, err := prompts.Answer(token, 1, "us"); err != errPromptExpired {
+		t.Errorf("Second Answer() error = %v, want %v", err, errPromptExpired)
+	}
+
+	// Unanswered prompts stop working after the TTL
+	token, _ = prompts.Create(&OverridePrompt{UserID: 1, Storefronts: []string{"us"}})
+	now = now.Add(OverridePromptTTL + time.Second)
+	if _, err := prompts.Answer(token, 1, "us"); err != errPromptExpired {
+		t.Errorf("Answer() after TTL error = %v, want %v", err, errPromptExpired)
+	}
+}
+
+func TestSongHandler_RunDownload_PromptsForOtherStorefronts(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.defaultStorefront = "us"
+
+	songDownloader := &alacMissingDownloader{
+		scriptedDownloader: scriptedDownloader{err: downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "ALAC format not available for this song")},
+		available:          []string{"gb", "jp"},
+	}
+	reporter := &promptingReporter{}
+	cmdCtx := &CommandContext{UserID: 1, ChatID: 100, RequestID: "1:100:1"}
+
+	err := handler.runDownload(context.Background(), cmdCtx, "https://music.apple.com/in/song/x/1", songDownloader, reporter, time.Now())
+	if err == nil {
+		t.Fatal("Expected the request to fail so it can be re-queued")
+	}
+
+	if len(reporter.errors) != 0 {
+		t.Errorf("Expected the prompt instead of an error report, got %v", reporter.errors)
+	}
+	if want := []string{"us", "gb", "jp"}; !slices.Equal(songDownloader.checked, want) {
+		t.Errorf("Checked %v, want %v", songDownloader.checked, want)
+	}
+	if !strings.Contains(reporter.message, "in the IN store") {
+		t.Errorf("Expected the prompt to name the requested store, got %q", reporter.message)
+	}
+
+	markup, ok := reporter.markup.(*tg.ReplyInlineMarkup)
+	if !ok || len(markup.Rows) != 4 {
+		t.Fatalf("Expected buttons for gb, jp, AAC and cancel, got %#v", reporter.markup)
+	}
+	data := string(markup.Rows[0].Buttons[0].(*tg.KeyboardButtonCallback).Data)
+	token := strings.Split(data, ":")[1]
+	prompt, err := handler.prompts.Answer(token, 1, "gb")
+	if err != nil || prompt.MessageID != 42 || prompt.RequestID != "1:100:1" {
+		t.Errorf("Expected the prompt to be stored for the request, got %+v, %v", prompt, err)
+	}
+}
+
+func TestSongHandler_RunDownload_PromptsAACWithoutAlternatives(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	songDownloader := &alacMissingDownloader{
+		scriptedDownloader: scriptedDownloader{err: downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "ALAC format not available for this song")},
+	}
+	reporter := &promptingReporter{}
+
+	handler.runDownload(context.Background(), &CommandContext{ChatID: 100, RequestID: "1:100:1"}, "https://music.apple.com/in/song/x/1", songDownloader, reporter, time.Now())
+
+	markup, ok := reporter.markup.(*tg.ReplyInlineMarkup)
+	if len(reporter.errors) != 0 || !ok || len(markup.Rows) != 2 {
+		t.Fatalf("Expected AAC and cancel buttons, got errors %v and markup %#v", reporter.errors, reporter.markup)
+	}
+	if text := markup.Rows[0].Buttons[0].(*tg.KeyboardButtonCallback).Text; text != "Get AAC 256 instead" {
+		t.Errorf("Expected the AAC button first, got %q", text)
+	}
+	if !strings.Contains(reporter.message, "AAC 256") {
+		t.Errorf("Expected the prompt to offer AAC, got %q", reporter.message)
+	}
+}
+
+func TestSongHandler_RunDownload_NoPromptAfterAACChosen(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	songDownloader := &alacMissingDownloader{
+		scriptedDownloader: scriptedDownloader{err: downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "ALAC format not available for this song")},
+		available:          []string{"gb"},
+	}
+	reporter := &promptingReporter{}
+	cmdCtx := &CommandContext{ChatID: 100, RequestID: "1:100:1", Override: DownloadOverride{AAC: true}}
+
+	handler.runDownload(context.Background(), cmdCtx, "https://music.apple.com/in/song/x/1", songDownloader, reporter, time.Now())
+
+	if len(reporter.errors) != 1 || reporter.markup != nil {
+		t.Errorf("Expected a flat failure, got errors %v and markup %v", reporter.errors, reporter.markup)
+	}
+	if !handler.requestOptions(cmdCtx).AACFallback {
+		t.Errorf("Expected the AAC choice to enable the AAC fallback")
+	}
+}
+
+func TestSongHandler_HandleCallback_RequeuesWithOverride(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &mockReactionAPI{}
+	handler.api = api
+
+	processed := make(chan *CommandContext, 1)
+	queue := handler.GetQueue()
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		processed <- cmdCtx
+		return nil
+	}
+	queue.requestDelay = 0
+
+	failed := newFailedTestRequest(1, 100, 7)
+	failed.URL = "https://music.apple.com/in/song/x/1"
+	queue.Failed().Record(failed, "download failed")
+
+	token, _ := handler.prompts.Create(&OverridePrompt{RequestID: failed.UniqueID, ChatID: 100, UserID: 1, MessageID: 42, Storefronts: []string{"gb"}})
+
+	notice, err := handler.HandleCallback(context.Background(), &CallbackContext{UserID: 2, ChatID: 100, Data: token + ":gb"})
+	if err != nil || !strings.Contains(notice, "Only the person") {
+		t.Errorf("Expected other users to be turned away, got %q, %v", notice, err)
+	}
+
+	if _, err := handler.HandleCallback(context.Background(), &CallbackContext{UserID: 1, ChatID: 100, Data: token + ":gb"}); err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+
+	select {
+	case cmdCtx := <-processed:
+		want := DownloadOverride{Storefront: "gb", ProgressMessageID: 42}
+		if cmdCtx.Override != want || cmdCtx.Args != failed.URL {
+			t.Errorf("Processed %q with override %+v, want %q with %+v", cmdCtx.Args, cmdCtx.Override, failed.URL, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to be re-queued")
+	}
+
+	if len(api.edits) != 1 || api.edits[0].ID != 42 || !strings.Contains(api.edits[0].Message, "GB store") {
+		t.Errorf("Expected the prompt message to be edited, got %+v", api.edits)
+	}
+	if len(queue.Failed().List(100, 1)) != 0 {
+		t.Errorf("Expected the failed entry to be taken")
+	}
+}
+
+func TestSongHandler_HandleCallback_RequeuesForAAC(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &mockReactionAPI{}
+	handler.api = api
+
+	processed := make(chan *CommandContext, 1)
+	queue := handler.GetQueue()
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		processed <- cmdCtx
+		return nil
+	}
+	queue.requestDelay = 0
+
+	failed := newFailedTestRequest(1, 100, 7)
+	queue.Failed().Record(failed, "download failed")
+
+	token, _ := handler.prompts.Create(&OverridePrompt{RequestID: failed.UniqueID, ChatID: 100, UserID: 1, MessageID: 42})
+	if _, err := handler.HandleCallback(context.Background(), &CallbackContext{UserID: 1, ChatID: 100, Data: token + ":aac"}); err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+
+	select {
+	case cmdCtx := <-processed:
+		if want := (DownloadOverride{AAC: true, ProgressMessageID: 42}); cmdCtx.Override != want {
+			t.Errorf("Processed with override %+v, want %+v", cmdCtx.Override, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to be re-queued")
+	}
+
+	if len(api.edits) != 1 || !strings.Contains(api.edits[0].Message, "AAC 256") {
+		t.Errorf("Expected the prompt message to say AAC is coming, got %+v", api.edits)
+	}
+}
+
+func TestSongHandler_RunDownload_UsesOverrideStorefront(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.manager.SetFallbackStorefronts([]string{"jp"})
+	handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+		return nil
+	}
+
+	songDownloader := &alacMissingDownloader{}
+	cmdCtx := &CommandContext{ChatID: 100, RequestID: "1:100:1", Override: DownloadOverride{Storefront: "gb"}}
+	if err := handler.runDownload(context.Background(), cmdCtx, "https://music.apple.com/in/song/x/1", songDownloader, &recordingReporter{}, time.Now()); err != nil {
+		t.Fatalf("runDownload() error = %v", err)
+	}
+
+	if want := []string{"https://music.apple.com/gb/song/x/1"}; !slices.Equal(songDownloader.urls, want) {
+		t.Errorf("Downloaded %v, want %v", songDownloader.urls, want)
+	}
+}
+
+func TestSongHandler_ExpirePrompt(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &mockReactionAPI{}
+	handler.api = api
+
+	token, _ := handler.prompts.Create(&OverridePrompt{ChatID: 100, UserID: 1, MessageID: 42, Storefronts: []string{"gb"}})
+	handler.expirePrompt(token)
+
+	if len(api.edits) != 1 || api.edits[0].ID != 42 || !strings.Contains(api.edits[0].Message, "No store was chosen") {
+		t.Errorf("Expected the prompt message to be expired, got %+v", api.edits)
+	}
+	if _, err := handler.prompts.Answer(token, 1, "gb"); err != errPromptExpired {
+		t.Errorf("Answer() after expiry error = %v, want %v", err, errPromptExpired)
+	}
+
+	// Answered prompts are left alone
+	token, _ = handler.prompts.Create(&OverridePrompt{ChatID: 100, UserID: 1, MessageID: 43, Storefronts: []string{"gb"}})
+	handler.prompts.Answer(token, 1, overrideCancelChoice)
+	handler.expirePrompt(token)
+	if len(api.edits) != 1 {
+		t.Errorf("Expected no edit for an answered prompt, got %d", len(api.edits))
+	}
+}
@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"sync"
+
+	"go-alac-bot/downloader"
+
+	"github.com/gotd/td/tg"
+)
+
+// ChatIDFromPeer returns the chat ID of a peer the way the Bot API writes
+// them: users keep their ID, basic groups are negative and supergroups and
+// channels are -100<channel ID>
+func ChatIDFromPeer(peer tg.PeerClass) int64 {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		return peer.UserID
+	case *tg.PeerChat:
+		return -peer.ChatID
+	case *tg.PeerChannel:
+		return downloader.ChannelChatID(peer.ChannelID)
+	}
+	return 0
+}
+
+// PeerResolver builds the input peers messages are sent to from chat IDs. It
+// remembers the access hashes of the users and channels seen in updates, as
+// supergroups and channels cannot be reached without one
+type PeerResolver struct {
+	mu     sync.RWMutex
+	hashes map[int64]int64 // Access hash by chat ID
+
+	// lookup finds the access hash of chats not seen since the start, e.g.
+	// in the session's peer storage; nil looks nowhere else
+	lookup func(chatID int64) (int64, bool)
+}
+
+// NewPeerResolver creates a resolver that knows no access hashes yet
+func NewPeerResolver() *PeerResolver {
+	return &PeerResolver{hashes: make(map[int64]int64)}
+}
+
+// SetLookup sets where access hashes of chats not seen in updates are looked
+// up
+func (r *PeerResolver) SetLookup(lookup func(chatID int64) (int64, bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookup = lookup
+}
+
+// Remember records the access hash of a chat
+func (r *PeerResolver) Remember(chatID, accessHash int64) {
+	if accessHash == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashes[chatID] = accessHash
+}
+
+// RememberEntities records the access hashes of the users and channels that
+// came with an update
+func (r *PeerResolver) RememberEntities(entities *tg.Entities) {
+	if entities == nil {
+		return
+	}
+	for id, user := range entities.Users {
+		r.Remember(id, user.AccessHash)
+	}
+	for id, channel := range entities.Channels {
+		r.Remember(downloader.ChannelChatID(id), channel.AccessHash)
+	}
+}
+
+// InputPeer returns the peer of a chat ID with its access hash when known. A
+// nil resolver builds peers without access hashes
+func (r *PeerResolver) InputPeer(chatID int64) tg.InputPeerClass {
+	return downloader.ChatInputPeer(chatID, r.accessHash(chatID))
+}
+
+// accessHash returns the remembered or looked up access hash of a chat, 0
+// when it is unknown
+func (r *PeerResolver) accessHash(chatID int64) int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	accessHash, ok := r.hashes[chatID]
+	lookup := r.lookup
+	r.mu.RUnlock()
+	if ok || lookup == nil {
+		return accessHash
+	}
+
+	if accessHash, ok := lookup(chatID); ok {
+		r.Remember(chatID, accessHash)
+		return accessHash
+	}
+	return 0
+}
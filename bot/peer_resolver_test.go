@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestChatIDFromPeer(t *testing.T) {
+	testCases := []struct {
+		name string
+		peer tg.PeerClass
+		want int64
+	}{
+		{"user", &tg.PeerUser{UserID: 12345}, 12345},
+		{"basic group", &tg.PeerChat{ChatID: 98765}, -98765},
+		{"supergroup", &tg.PeerChannel{ChannelID: 1234567890}, -1001234567890},
+		{"unknown", nil, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := ChatIDFromPeer(tc.peer); got != tc.want {
+			t.Errorf("%s: ChatIDFromPeer() = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPeerResolver_InputPeer(t *testing.T) {
+	resolver := NewPeerResolver()
+	resolver.RememberEntities(&tg.Entities{
+		Users:    map[int64]*tg.User{12345: {ID: 12345, AccessHash: 11}},
+		Channels: map[int64]*tg.Channel{1234567890: {ID: 1234567890, AccessHash: 22}},
+	})
+
+	testCases := []struct {
+		name   string
+		chatID int64
+		want   tg.InputPeerClass
+	}{
+		{"user", 12345, &tg.InputPeerUser{UserID: 12345, AccessHash: 11}},
+		{"basic group", -98765, &tg.InputPeerChat{ChatID: 98765}},
+		{"supergroup", -1001234567890, &tg.InputPeerChannel{ChannelID: 1234567890, AccessHash: 22}},
+		{"unseen channel", -1009999999999, &tg.InputPeerChannel{ChannelID: 9999999999}},
+	}
+
+	for _, tc := range testCases {
+		if got := resolver.InputPeer(tc.chatID); got.String() != tc.want.String() {
+			t.Errorf("%s: InputPeer(%d) = %v, want %v", tc.name, tc.chatID, got, tc.want)
+		}
+	}
+
+	var nilResolver *PeerResolver
+	if got, ok := nilResolver.InputPeer(-1001234567890).(*tg.InputPeerChannel); !ok || got.AccessHash != 0 {
+		t.Errorf("Expected a nil resolver to build the channel peer without a hash, got %v", got)
+	}
+}
+
+func TestPeerResolver_Lookup(t *testing.T) {
+	resolver := NewPeerResolver()
+	lookups := 0
+	resolver.SetLookup(func(chatID int64) (int64, bool) {
+		lookups++
+		return 33, chatID == -1001234567890
+	})
+
+	for i := 0; i < 2; i++ {
+		if got := resolver.InputPeer(-1001234567890).(*tg.InputPeerChannel); got.AccessHash != 33 {
+			t.Errorf("Expected the looked up access hash, got %d", got.AccessHash)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected the looked up hash to be remembered, got %d lookups", lookups)
+	}
+	if got := resolver.InputPeer(-1005555555555).(*tg.InputPeerChannel); got.AccessHash != 0 {
+		t.Errorf("Expected no hash for a chat the lookup does not know, got %d", got.AccessHash)
+	}
+}
+
+// Messages to supergroups used to go to a basic group or user peer of the
+// channel ID and never arrived
+func TestSupergroupMessages_UseChannelPeer(t *testing.T) {
+	router := NewCommandRouter(logging.Discard())
+	cmdCtx, err := router.extractCommandContext(&tg.UpdateNewMessage{Message: &tg.Message{
+		Message: "/song https://music.apple.com/us/song/x/1",
+		PeerID:  &tg.PeerChannel{ChannelID: 1234567890},
+		FromID:  &tg.PeerUser{UserID: 12345},
+	}})
+	if err != nil {
+		t.Fatalf("extractCommandContext() error = %v", err)
+	}
+	if cmdCtx.ChatID != -1001234567890 {
+		t.Fatalf("Expected chat ID -1001234567890, got %d", cmdCtx.ChatID)
+	}
+
+	bot := &TelegramBot{peers: NewPeerResolver()}
+	bot.peers.Remember(cmdCtx.ChatID, 42)
+	api := &mockReactionAPI{}
+	handler := NewSongHandler(bot, logging.Discard())
+	handler.api = api
+
+	if _, err := handler.sendMessageWithID(context.Background(), cmdCtx.ChatID, "Queued"); err == nil {
+		t.Fatal("Expected an error for a reply without a message ID")
+	}
+	if len(api.sends) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(api.sends))
+	}
+	peer, ok := api.sends[0].Peer.(*tg.InputPeerChannel)
+	if !ok || peer.ChannelID != 1234567890 || peer.AccessHash != 42 {
+		t.Errorf("Expected channel 1234567890 with its access hash, got %v", api.sends[0].Peer)
+	}
+}
@@ -3,23 +3,24 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/gotd/td/tg"
+	"go-alac-bot/logging"
 )
 
 // PingHandler implements CommandHandler for the /ping command
 type PingHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	sender       MessageSender
+	logger       logging.Logger
 	errorHandler *ErrorHandler
 }
 
 // NewPingHandler creates a new PingHandler instance
-func NewPingHandler(client *TelegramBot, logger *log.Logger) *PingHandler {
+func NewPingHandler(client *TelegramBot, logger logging.Logger) *PingHandler {
 	handler := &PingHandler{
 		client: client,
+		sender: client,
 		logger: logger,
 	}
 	
@@ -40,7 +41,7 @@ func (h *PingHandler) Command() string {
 func (h *PingHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	startTime := time.Now()
 	
-	h.logger.Printf("Processing /ping command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing /ping command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 	
 	// Create context with short timeout for immediate response
 	timeoutCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
@@ -53,8 +54,8 @@ func (h *PingHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	pongMessage := h.createPongMessage(startTime, commandLatency)
 	
 	// Send the pong response immediately with error handling
-	if err := h.sendMessage(timeoutCtx, cmdCtx.ChatID, pongMessage); err != nil {
-		h.logger.Printf("Failed to send pong message to chat %d: %v", cmdCtx.ChatID, err)
+	if err := h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, pongMessage); err != nil {
+		h.logger.Error("Failed to send pong message", logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
 		
 		// Use error handler if available for network errors
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
@@ -66,8 +67,8 @@ func (h *PingHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	
 	// Log successful processing with total response time
 	totalResponseTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed /ping command for user %d (response time: %v, command latency: %v)", 
-		cmdCtx.UserID, totalResponseTime, commandLatency)
+	h.logger.Debug("Processed /ping command", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Response", totalResponseTime), logging.Duration("Latency", commandLatency))
 	
 	return nil
 }
@@ -81,38 +82,3 @@ func (h *PingHandler) createPongMessage(responseTime time.Time, commandLatency t
 		responseTime.Format("15:04:05"),
 		commandLatency.Round(time.Millisecond))
 }
-
-// sendMessage sends a text message to the specified chat
-func (h *PingHandler) sendMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
-	}
-	
-	// For bot API, we need to determine the correct peer type
-	// In most cases for bots, we're dealing with private chats (users)
-	var peer tg.InputPeerClass
-	
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		// For now, we'll treat it as a chat (group)
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-	
-	// Create the message request
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  message,
-		RandomID: time.Now().UnixNano(), // Add random ID to prevent duplicate messages
-	}
-	
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send message via Telegram API: %w", err)
-	}
-	
-	return nil
-}
\ No newline at end of file
@@ -2,17 +2,18 @@ package bot
 
 import (
 	"context"
-	"log"
+	"errors"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/gotd/td/tg"
 	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 func TestPingHandler_Command(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	cfg := &config.BotConfig{
 		Token:   "test_token",
 		APIID:   12345,
@@ -32,8 +33,8 @@ func TestPingHandler_Command(t *testing.T) {
 	}
 }
 
-func TestPingHandler_Handle_WithoutClient(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+func TestPingHandler_Handle(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	cfg := &config.BotConfig{
 		Token:   "test_token",
 		APIID:   12345,
@@ -65,23 +66,31 @@ func TestPingHandler_Handle_WithoutClient(t *testing.T) {
 		Timestamp: time.Now(),
 	}
 	
-	ctx := context.Background()
+	sender := &recordingSender{}
+	handler.sender = sender
+	
+	if err := handler.Handle(context.Background(), cmdCtx); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
 	
-	// This should fail because the client is not initialized (no actual Telegram connection)
-	err = handler.Handle(ctx, cmdCtx)
-	if err == nil {
-		t.Error("Expected error when client is not initialized, but got nil")
+	// The pong goes to the chat the command came from
+	sent := sender.sent()
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(sent))
+	}
+	if sent[0].kind != "markdown" || sent[0].chatID != 67890 || !containsString(sent[0].message, "Pong!") {
+		t.Errorf("Expected a pong sent to chat 67890, got %+v", sent[0])
 	}
 	
-	// Check that error message indicates client initialization issue
-	expectedErrorSubstring := "bot client is not initialized"
-	if err != nil && !containsString(err.Error(), expectedErrorSubstring) {
-		t.Errorf("Expected error to contain '%s', but got: %v", expectedErrorSubstring, err)
+	// Send failures are returned
+	sender.err = errors.New("connection reset")
+	if err := handler.Handle(context.Background(), cmdCtx); err == nil || !containsString(err.Error(), "connection reset") {
+		t.Errorf("Expected the send error, got %v", err)
 	}
 }
 
 func TestPingHandler_CreatePongMessage(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	cfg := &config.BotConfig{
 		Token:   "test_token",
 		APIID:   12345,
@@ -119,7 +128,7 @@ func TestPingHandler_CreatePongMessage(t *testing.T) {
 }
 
 func TestPingHandler_TimingRequirements(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	cfg := &config.BotConfig{
 		Token:   "test_token",
 		APIID:   12345,
@@ -168,7 +177,7 @@ func TestPingHandler_TimingRequirements(t *testing.T) {
 }
 
 func TestNewPingHandler(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	cfg := &config.BotConfig{
 		Token:   "test_token",
 		APIID:   12345,
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	// progressCallbackPrefix is the data prefix of the progress message
+	// buttons. Button data is "prg:<action>:<request ID>"
+	progressCallbackPrefix = "prg"
+	progressActionCancel   = "c"
+	progressActionRetry    = "r"
+)
+
+// progressControls returns the buttons of a request's progress message: Cancel
+// while it runs and Retry once it failed. Requests whose ID does not fit in
+// button data get none
+func progressControls(requestID string) (active, failed tg.ReplyMarkupClass) {
+	if requestID == "" || len(CallbackData(progressCallbackPrefix, progressActionCancel+":"+requestID)) > MaxCallbackDataLength {
+		return nil, nil
+	}
+	return progressButton("❌ Cancel", progressActionCancel, requestID), progressButton("🔁 Retry", progressActionRetry, requestID)
+}
+
+// progressButton creates a markup with a single button for action on a request
+func progressButton(text, action, requestID string) *tg.ReplyInlineMarkup {
+	return &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{Buttons: []tg.KeyboardButtonClass{
+		&tg.KeyboardButtonCallback{
+			Text: text,
+			Data: CallbackData(progressCallbackPrefix, action+":"+requestID),
+		},
+	}}}}
+}
+
+// ProgressControlsHandler implements CallbackHandler for the Cancel and Retry
+// buttons of progress messages. Only the user who requested the song or an
+// admin may press them
+type ProgressControlsHandler struct {
+	client      *TelegramBot
+	sender      MessageSender
+	logger      logging.Logger
+	songHandler *SongHandler
+}
+
+// NewProgressControlsHandler creates a new ProgressControlsHandler instance
+func NewProgressControlsHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *ProgressControlsHandler {
+	return &ProgressControlsHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+}
+
+// CallbackPrefix returns the data prefix of the progress message buttons
+func (h *ProgressControlsHandler) CallbackPrefix() string {
+	return progressCallbackPrefix
+}
+
+// HandleCallback cancels or retries the request of a pressed button
+func (h *ProgressControlsHandler) HandleCallback(ctx context.Context, cbCtx *CallbackContext) (string, error) {
+	action, requestID, ok := strings.Cut(cbCtx.Data, ":")
+	if !ok || requestID == "" {
+		return "", fmt.Errorf("malformed progress button data %q", cbCtx.Data)
+	}
+
+	switch action {
+	case progressActionCancel:
+		return h.cancel(cbCtx, requestID), nil
+	case progressActionRetry:
+		return h.retry(ctx, cbCtx, requestID)
+	}
+	return "", fmt.Errorf("unknown progress button action %q", action)
+}
+
+// cancel stops a running or queued request and returns the notice for the
+// user. The progress message shows the cancellation once the download stops
+func (h *ProgressControlsHandler) cancel(cbCtx *CallbackContext, requestID string) string {
+	queue := h.songHandler.GetQueue()
+	request, ok := queue.FindRequest(requestID)
+	if !ok {
+		return "This download has already finished."
+	}
+	if !h.allowed(request.SenderID, cbCtx.UserID) {
+		return "This isn't your download."
+	}
+	if !queue.CancelRequest(requestID) {
+		return "This download has already finished."
+	}
+
+	h.logger.Info("Cancelled request from its progress message", logging.Int64("User", cbCtx.UserID), logging.String("Request", requestID))
+	return "Cancelling..."
+}
+
+// retry re-queues a failed request on the same progress message and returns
+// the notice for the user
+func (h *ProgressControlsHandler) retry(ctx context.Context, cbCtx *CallbackContext, requestID string) (string, error) {
+	queue := h.songHandler.GetQueue()
+	entry, ok := queue.Failed().Find(cbCtx.ChatID, requestID)
+	if !ok {
+		return "This request can no longer be retried.", nil
+	}
+	if !h.allowed(entry.Request.SenderID, cbCtx.UserID) {
+		return "This isn't your download.", nil
+	}
+	if entry, ok = queue.Failed().TakeRequest(cbCtx.ChatID, requestID); !ok {
+		return "This request can no longer be retried.", nil
+	}
+
+	request, err := h.songHandler.requeueFailed(entry, DownloadOverride{ProgressMessageID: cbCtx.MessageID})
+	if err != nil {
+		return err.Error(), nil
+	}
+
+	h.logger.Info("Retried request from its progress message", logging.Int64("User", cbCtx.UserID),
+		logging.String("Request", request.UniqueID), logging.String("Correlation", request.CorrelationID), logging.Int("Attempt", request.Attempt))
+
+	message := "🔁 Retrying..."
+	if position := queue.GetQueuePosition(request.UniqueID); position > 0 {
+		message = fmt.Sprintf("🔁 Retrying (queue position %d)", position)
+	}
+	return "", h.sender.EditMessage(ctx, cbCtx.ChatID, cbCtx.MessageID, message)
+}
+
+// allowed reports whether userID may press the buttons of a request sent by
+// senderID
+func (h *ProgressControlsHandler) allowed(senderID, userID int64) bool {
+	return senderID == userID || (h.client != nil && h.client.IsAdmin(userID))
+}
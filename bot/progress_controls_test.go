@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// newControlsTestHandler creates a progress controls handler with admin 99,
+// whose queue processes one request at a time until its context is cancelled
+func newControlsTestHandler(t *testing.T) (*ProgressControlsHandler, *SongQueue, *recordingSender) {
+	bot, songHandler, queue := newBlockingSongHandler(t)
+
+	sender := &recordingSender{}
+	handler := NewProgressControlsHandler(bot, logging.Discard(), songHandler)
+	handler.sender = sender
+	return handler, queue, sender
+}
+
+func TestProgressControls(t *testing.T) {
+	active, failed := progressControls("1:100:7")
+
+	buttonOf := func(markup tg.ReplyMarkupClass) *tg.KeyboardButtonCallback {
+		inline, ok := markup.(*tg.ReplyInlineMarkup)
+		if !ok || len(inline.Rows) != 1 || len(inline.Rows[0].Buttons) != 1 {
+			t.Fatalf("Expected a single button, got %#v", markup)
+		}
+		return inline.Rows[0].Buttons[0].(*tg.KeyboardButtonCallback)
+	}
+	if button := buttonOf(active); button.Text != "❌ Cancel" || string(button.Data) != "prg:c:1:100:7" {
+		t.Errorf("Unexpected cancel button %q with data %q", button.Text, button.Data)
+	}
+	if button := buttonOf(failed); button.Text != "🔁 Retry" || string(button.Data) != "prg:r:1:100:7" {
+		t.Errorf("Unexpected retry button %q with data %q", button.Text, button.Data)
+	}
+
+	// Requests outside the queue and IDs too long for button data get none
+	for _, requestID := range []string{"", strings.Repeat("9", MaxCallbackDataLength)} {
+		if active, failed := progressControls(requestID); active != nil || failed != nil {
+			t.Errorf("Expected no buttons for request ID %q", requestID)
+		}
+	}
+}
+
+func TestProgressControlsHandler_Cancel(t *testing.T) {
+	handler, queue, _ := newControlsTestHandler(t)
+	defer queue.CancelProcessing(1)
+
+	running, err := queue.AddRequest(1, 100, 1, "https://music.apple.com/in/song/first/1")
+	if err != nil {
+		t.Fatalf("Failed to add request: %v", err)
+	}
+	waitFor(t, "the request to start", queue.IsProcessing)
+	queued, err := queue.AddRequest(1, 100, 2, "https://music.apple.com/in/song/second/2")
+	if err != nil {
+		t.Fatalf("Failed to add request: %v", err)
+	}
+
+	press := func(userID int64, requestID string) string {
+		notice, err := handler.HandleCallback(context.Background(), &CallbackContext{UserID: userID, ChatID: 100, MessageID: 42, Data: "c:" + requestID})
+		if err != nil {
+			t.Fatalf("HandleCallback() error = %v", err)
+		}
+		return notice
+	}
+
+	if notice := press(2, running.UniqueID); !strings.Contains(notice, "isn't your download") {
+		t.Errorf("Expected other users to be turned away, got %q", notice)
+	}
+	if !queue.IsProcessing() {
+		t.Fatal("Expected the request to keep running after another user's press")
+	}
+
+	// The queued request is removed, the running one stopped by an admin
+	if notice := press(1, queued.UniqueID); notice != "Cancelling..." {
+		t.Errorf("Expected the queued request to be cancelled, got %q", notice)
+	}
+	if queue.GetUserQueueCount(1) != 1 {
+		t.Errorf("Expected only the running request to remain, got %d", queue.GetUserQueueCount(1))
+	}
+	if notice := press(99, running.UniqueID); notice != "Cancelling..." {
+		t.Errorf("Expected admins to cancel any download, got %q", notice)
+	}
+	waitFor(t, "the request to stop", func() bool { return !queue.IsProcessing() })
+
+	finished := queue.GetRequestsBySender(100, 1).Finished
+	if len(finished) != 1 || finished[0].Status != StatusCancelled {
+		t.Errorf("Expected the running request to finish as cancelled, got %+v", finished)
+	}
+	if notice := press(1, running.UniqueID); !strings.Contains(notice, "already finished") {
+		t.Errorf("Expected a finished request not to be cancelled again, got %q", notice)
+	}
+}
+
+func TestProgressControlsHandler_Retry(t *testing.T) {
+	handler, queue, sender := newControlsTestHandler(t)
+	defer queue.CancelProcessing(1)
+
+	failed := newFailedTestRequest(1, 100, 7)
+	queue.Failed().Record(failed, "download failed")
+	press := func(userID int64) string {
+		notice, err := handler.HandleCallback(context.Background(), &CallbackContext{UserID: userID, ChatID: 100, MessageID: 42, Data: "r:" + failed.UniqueID})
+		if err != nil {
+			t.Fatalf("HandleCallback() error = %v", err)
+		}
+		return notice
+	}
+
+	if notice := press(2); !strings.Contains(notice, "isn't your download") {
+		t.Errorf("Expected other users to be turned away, got %q", notice)
+	}
+	if len(queue.Failed().List(100, 1)) != 1 {
+		t.Fatal("Expected the failed request to stay retryable after another user's press")
+	}
+
+	if notice := press(1); notice != "" {
+		t.Errorf("Expected no notice for a retry, got %q", notice)
+	}
+	var request QueueRequest
+	waitFor(t, "the retry to start", func() bool {
+		var ok bool
+		request, ok = queue.FindRequest(failed.UniqueID)
+		return ok
+	})
+	if request.Attempt != failed.Attempt+1 {
+		t.Errorf("Expected attempt %d, got %d", failed.Attempt+1, request.Attempt)
+	}
+	if processing := queue.GetProcessing(); len(processing) != 1 || processing[0].Override.ProgressMessageID != 42 {
+		t.Errorf("Expected the retry to continue on message 42, got %+v", processing)
+	}
+
+	sent := sender.sent()
+	if len(sent) != 1 || sent[0].kind != "edit" || sent[0].messageID != 42 || !strings.Contains(sent[0].message, "Retrying") {
+		t.Errorf("Expected the progress message to show the retry, got %+v", sent)
+	}
+	if notice := press(1); !strings.Contains(notice, "no longer be retried") {
+		t.Errorf("Expected a retried request not to be retried again, got %q", notice)
+	}
+}
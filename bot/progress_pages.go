@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+)
+
+const (
+	// ProgressPagePath is the URL prefix progress pages are served under
+	ProgressPagePath = "/p/"
+	// ProgressPageTTL is how long a page stays available after its request finishes
+	ProgressPageTTL = 5 * time.Minute
+
+	progressTokenBytes     = 16
+	progressRefreshSeconds = 2
+)
+
+// ProgressPayload is the JSON shape served at /p/<token>.json
+type ProgressPayload struct {
+	Song           string  `json:"song"`
+	Phase          string  `json:"phase"`
+	Percentage     float64 `json:"percentage"`
+	Speed          int64   `json:"speed_bytes_per_second"`
+	ETASeconds     float64 `json:"eta_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Done           bool    `json:"done"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// progressPage tracks one request's download behind a token
+type progressPage struct {
+	status     DownloadStatusProvider
+	startedAt  time.Time
+	finishedAt time.Time
+	final      *ProgressPayload
+}
+
+// ProgressPages serves per-request progress pages for users watching outside
+// Telegram. Tokens are unguessable and expire shortly after completion
+type ProgressPages struct {
+	mu      sync.Mutex
+	baseURL string
+	pages   map[string]*progressPage
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewProgressPages creates progress pages linked from baseURL (e.g.
+// https://bot.example.com)
+func NewProgressPages(baseURL string) *ProgressPages {
+	return &ProgressPages{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		pages:   make(map[string]*progressPage),
+		ttl:     ProgressPageTTL,
+		now:     time.Now,
+	}
+}
+
+// Create registers a page for a starting download and returns its token and
+// public URL
+func (pp *ProgressPages) Create(status DownloadStatusProvider) (string, string, error) {
+	buf := make([]byte, progressTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.pruneLocked()
+	pp.pages[token] = &progressPage{status: status, startedAt: pp.now()}
+	return token, pp.baseURL + ProgressPagePath + token, nil
+}
+
+// Finish freezes a page at its final state and starts its expiry countdown
+func (pp *ProgressPages) Finish(token string, err error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	page, ok := pp.pages[token]
+	if !ok || page.final != nil {
+		return
+	}
+
+	now := pp.now()
+	final := payloadFromStatus(page.status.GetStatus(), page.startedAt, now)
+	final.Done = true
+	final.ETASeconds = 0
+	if err != nil {
+		final.Phase = downloader.PhaseError.String()
+		final.Error = userFacingError(err)
+	} else {
+		final.Phase = downloader.PhaseComplete.String()
+		final.Percentage = 100
+	}
+
+	page.final = &final
+	page.finishedAt = now
+}
+
+// Len returns the number of pages that have not expired
+func (pp *ProgressPages) Len() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.pruneLocked()
+	return len(pp.pages)
+}
+
+// ServeHTTP serves /p/<token> as HTML and /p/<token>.json as JSON
+func (pp *ProgressPages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, ProgressPagePath)
+	asJSON := strings.HasSuffix(token, ".json")
+	token = strings.TrimSuffix(token, ".json")
+
+	payload, ok := pp.payload(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(payload)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	progressPageTemplate.Execute(w, progressPageView{ProgressPayload: payload, Refresh: progressRefreshSeconds})
+}
+
+// payload returns the current state of a page, or false for unknown and
+// expired tokens
+func (pp *ProgressPages) payload(token string) (ProgressPayload, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	pp.pruneLocked()
+	page, ok := pp.pages[token]
+	if !ok {
+		return ProgressPayload{}, false
+	}
+	if page.final != nil {
+		return *page.final, true
+	}
+	return payloadFromStatus(page.status.GetStatus(), page.startedAt, pp.now()), true
+}
+
+// pruneLocked drops pages whose request finished more than ttl ago
+func (pp *ProgressPages) pruneLocked() {
+	now := pp.now()
+	for token, page := range pp.pages {
+		if page.final != nil && now.Sub(page.finishedAt) > pp.ttl {
+			delete(pp.pages, token)
+		}
+	}
+}
+
+// payloadFromStatus converts a live download status into a page payload
+func payloadFromStatus(status downloader.DownloadStatus, startedAt, now time.Time) ProgressPayload {
+	return ProgressPayload{
+		Song:           status.SongName,
+		Phase:          status.Phase.String(),
+		Percentage:     status.Progress.Percentage,
+		Speed:          status.Progress.Speed,
+		ETASeconds:     status.Progress.ETA.Seconds(),
+		ElapsedSeconds: downloader.Elapsed(startedAt, now).Round(time.Second).Seconds(),
+	}
+}
+
+// userFacingError returns the message shown for a failed request
+func userFacingError(err error) string {
+	if de, ok := downloader.AsDownloadError(err); ok {
+		return de.UserMessage()
+	}
+	return "The request failed"
+}
+
+// progressPageView is the data rendered by progressPageTemplate
+type progressPageView struct {
+	ProgressPayload
+	Refresh int
+}
+
+// progressPageFuncs are the helpers available to progressPageTemplate
+var progressPageFuncs = template.FuncMap{
+	"bytes": downloader.FormatBytes,
+}
+
+var progressPageTemplate = template.Must(template.New("progress").Funcs(progressPageFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if not .Done}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>{{if .Song}}{{.Song}}{{else}}Download{{end}} — progress</title>
+<style>
+body { font-family: sans-serif; max-width: 32em; margin: 2em auto; padding: 0 1em; }
+progress { width: 100%; }
+</style>
+</head>
+<body>
+<h1>{{if .Song}}{{.Song}}{{else}}Download{{end}}</h1>
+<p>Phase: {{.Phase}}</p>
+<progress max="100" value="{{printf "%.1f" .Percentage}}"></progress>
+<p>{{printf "%.1f" .Percentage}}%{{if .Speed}} • {{bytes .Speed}}/s{{end}}{{if .ETASeconds}} • ETA {{printf "%.0f" .ETASeconds}}s{{end}}</p>
+<p>Elapsed: {{printf "%.0f" .ElapsedSeconds}}s</p>
+{{if .Error}}<p>Error: {{.Error}}</p>{{else if .Done}}<p>Done — the file has been sent in Telegram.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+)
+
+// newTestProgressPages returns pages on a fake clock the test can advance
+func newTestProgressPages() (*ProgressPages, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	pages := NewProgressPages("https://bot.example.com/")
+	pages.now = func() time.Time { return now }
+	return pages, &now
+}
+
+func getProgressPage(pages *ProgressPages, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	pages.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestProgressPages_Create(t *testing.T) {
+	pages, _ := newTestProgressPages()
+
+	token, url, err := pages.Create(&fakeDownloadStatus{})
+	if err != nil {
+		t.Fatalf("Failed to create page: %v", err)
+	}
+	if len(token) < 20 {
+		t.Errorf("Expected an unguessable token, got %q", token)
+	}
+	if url != "https://bot.example.com/p/"+token {
+		t.Errorf("Unexpected page URL %q", url)
+	}
+
+	other, _, _ := pages.Create(&fakeDownloadStatus{})
+	if other == token {
+		t.Error("Expected distinct tokens for distinct requests")
+	}
+}
+
+func TestProgressPages_JSON(t *testing.T) {
+	pages, now := newTestProgressPages()
+	status := &fakeDownloadStatus{status: downloader.DownloadStatus{
+		Phase:    downloader.PhaseDownloading,
+		SongName: "Song - Artist",
+		Progress: downloader.Progress{
+			BytesProcessed: 42,
+			TotalBytes:     100,
+			Percentage:     42,
+			Speed:          2048,
+			ETA:            30 * time.Second,
+		},
+		IsActive: true,
+	}}
+	token, _, _ := pages.Create(status)
+	*now = now.Add(10 * time.Second)
+
+	rec := getProgressPage(pages, "/p/"+token+".json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", ct)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	expected := map[string]interface{}{
+		"song":                   "Song - Artist",
+		"phase":                  "downloading",
+		"percentage":             42.0,
+		"speed_bytes_per_second": 2048.0,
+		"eta_seconds":            30.0,
+		"elapsed_seconds":        10.0,
+		"done":                   false,
+	}
+	for key, want := range expected {
+		if got, ok := fields[key]; !ok || got != want {
+			t.Errorf("Expected %s = %v, got %v", key, want, got)
+		}
+	}
+	if _, ok := fields["error"]; ok {
+		t.Error("Expected no error field while in progress")
+	}
+}
+
+func TestProgressPages_HTML(t *testing.T) {
+	pages, _ := newTestProgressPages()
+	token, _, _ := pages.Create(&fakeDownloadStatus{status: downloader.DownloadStatus{
+		Phase:    downloader.PhaseDecrypting,
+		SongName: "<Song>",
+		Progress: downloader.Progress{TotalBytes: 100, Percentage: 75, Speed: 2048},
+	}})
+
+	rec := getProgressPage(pages, "/p/"+token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`http-equiv="refresh"`, "decrypting", "75.0%", "2.0 KB/s", "&lt;Song&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected page to contain %q", want)
+		}
+	}
+
+	pages.Finish(token, nil)
+	if body := getProgressPage(pages, "/p/"+token).Body.String(); strings.Contains(body, `http-equiv="refresh"`) {
+		t.Error("Expected finished page to stop refreshing")
+	}
+}
+
+func TestProgressPages_Finish(t *testing.T) {
+	pages, _ := newTestProgressPages()
+	status := &fakeDownloadStatus{status: downloader.DownloadStatus{
+		Phase:    downloader.PhaseDownloading,
+		Progress: downloader.Progress{Percentage: 60, ETA: time.Minute},
+	}}
+
+	completed, _, _ := pages.Create(status)
+	failed, _, _ := pages.Create(status)
+	pages.Finish(completed, nil)
+	pages.Finish(failed, downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "ALAC is not available"))
+
+	var payload ProgressPayload
+	json.Unmarshal(getProgressPage(pages, "/p/"+completed+".json").Body.Bytes(), &payload)
+	if !payload.Done || payload.Phase != "complete" || payload.Percentage != 100 || payload.ETASeconds != 0 {
+		t.Errorf("Unexpected completed payload: %+v", payload)
+	}
+
+	payload = ProgressPayload{}
+	json.Unmarshal(getProgressPage(pages, "/p/"+failed+".json").Body.Bytes(), &payload)
+	if !payload.Done || payload.Phase != "error" || payload.Error != "This song isn't available in lossless ALAC" {
+		t.Errorf("Unexpected failed payload: %+v", payload)
+	}
+}
+
+func TestProgressPages_Expiry(t *testing.T) {
+	pages, now := newTestProgressPages()
+	token, _, _ := pages.Create(&fakeDownloadStatus{})
+
+	// Pages of running requests never expire
+	*now = now.Add(time.Hour)
+	if rec := getProgressPage(pages, "/p/"+token); rec.Code != http.StatusOK {
+		t.Fatalf("Expected running page to be served, got %d", rec.Code)
+	}
+
+	pages.Finish(token, nil)
+	*now = now.Add(ProgressPageTTL)
+	if rec := getProgressPage(pages, "/p/"+token+".json"); rec.Code != http.StatusOK {
+		t.Errorf("Expected finished page to be served until the TTL, got %d", rec.Code)
+	}
+
+	*now = now.Add(time.Second)
+	if rec := getProgressPage(pages, "/p/"+token+".json"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected expired page to 404, got %d", rec.Code)
+	}
+	if pages.Len() != 0 {
+		t.Errorf("Expected expired page to be removed, %d left", pages.Len())
+	}
+}
+
+func TestProgressPages_UnknownToken(t *testing.T) {
+	pages, _ := newTestProgressPages()
+	pages.Create(&fakeDownloadStatus{})
+
+	for _, path := range []string{"/p/unknown", "/p/unknown.json", "/p/", "/p/.json"} {
+		if rec := getProgressPage(pages, path); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package bot
+
+import "time"
+
+// QueueEventKind is what happened to a queued request
+type QueueEventKind int
+
+const (
+	QueueEventQueued QueueEventKind = iota
+	QueueEventStarted
+	QueueEventProgress
+	QueueEventCompleted
+	QueueEventFailed
+	QueueEventMoved
+)
+
+// QueueEvent describes a change in the state of a queued request
+type QueueEvent struct {
+	Kind      QueueEventKind
+	RequestID string
+	BatchID   string // Empty for requests submitted on their own
+
+	Title      string  // Song title, once the metadata is known
+	Percentage float64 // Progress of the current phase, for progress events
+	Reason     string  // Why the request failed, for failed events
+	Attempt    int     // Try of a request queued again after failing, for queued events (0 = first)
+
+	// ChatID and StatusMessageID locate the status message of a request that
+	// moved up in the queue, now at Position of QueueSize and expected to
+	// start in ETA. Position is 0 once processing started
+	ChatID          int64
+	StatusMessageID int
+	Position        int
+	QueueSize       int
+	ETA             time.Duration
+}
+
+// QueueListener receives the events of queued requests. Events are delivered
+// on the goroutine that caused them, so listeners must not block for long
+type QueueListener interface {
+	OnQueueEvent(event QueueEvent)
+}
+
+// AddListener registers listener for the events of every request
+func (sq *SongQueue) AddListener(listener QueueListener) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.listeners = append(sq.listeners, listener)
+}
+
+// Notify delivers event to the listeners (must be called without the lock held)
+func (sq *SongQueue) Notify(event QueueEvent) {
+	sq.mu.RLock()
+	listeners := sq.listeners
+	sq.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener.OnQueueEvent(event)
+	}
+}
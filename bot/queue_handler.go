@@ -3,24 +3,26 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/gotd/td/tg"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
 )
 
 // QueueHandler implements CommandHandler for the /queue command
 type QueueHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	sender       MessageSender
+	logger       logging.Logger
 	errorHandler *ErrorHandler
 	songHandler  *SongHandler
 }
 
 // NewQueueHandler creates a new QueueHandler instance
-func NewQueueHandler(client *TelegramBot, logger *log.Logger, songHandler *SongHandler) *QueueHandler {
+func NewQueueHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *QueueHandler {
 	handler := &QueueHandler{
 		client:      client,
+		sender:      client,
 		logger:      logger,
 		songHandler: songHandler,
 	}
@@ -42,7 +44,7 @@ func (h *QueueHandler) Command() string {
 func (h *QueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	startTime := time.Now()
 
-	h.logger.Printf("Processing /queue command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing /queue command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 
 	// Create context with timeout
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -58,8 +60,8 @@ func (h *QueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	message := h.createQueueStatusMessage(queue)
 
 	// Send the queue status message
-	if err := h.sendMessage(timeoutCtx, cmdCtx.ChatID, message); err != nil {
-		h.logger.Printf("Failed to send queue status message: %v", err)
+	if err := h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, message); err != nil {
+		h.logger.Error("Failed to send queue status message", logging.Err(err))
 
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
 			return h.errorHandler.HandleNetworkError(err, false)
@@ -70,8 +72,8 @@ func (h *QueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 
 	// Log successful processing with timing
 	processingTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed /queue command for user %d (took %v)",
-		cmdCtx.UserID, processingTime)
+	h.logger.Debug("Processed /queue command", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Took", processingTime))
 
 	return nil
 }
@@ -79,21 +81,23 @@ func (h *QueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 // createQueueStatusMessage creates a formatted queue status message
 func (h *QueueHandler) createQueueStatusMessage(queue *SongQueue) string {
 	queueSize := queue.GetQueueSize()
-	isProcessing := queue.IsProcessing()
-	currentlyProcessing := queue.GetCurrentlyProcessing()
+	processing := queue.GetProcessing()
 
 	message := "📊 **Song Queue Status**\n\n"
 
 	// Queue capacity
-	message += fmt.Sprintf("**Capacity:** %d/%d requests\n\n", queueSize, MaxQueueSize)
+	message += fmt.Sprintf("**Capacity:** %d/%d requests\n", queueSize, queue.MaxSize())
+	message += fmt.Sprintf("**Workers:** %d\n\n", queue.Workers())
 
 	// Current processing status
-	if isProcessing && currentlyProcessing != nil {
+	if len(processing) > 0 {
 		message += fmt.Sprintf("🎵 **Currently Processing:**\n")
-		message += fmt.Sprintf("• Request ID: `%s`\n", currentlyProcessing.UniqueID)
-		message += fmt.Sprintf("• From user: %d\n", currentlyProcessing.SenderID)
-		elapsed := time.Since(currentlyProcessing.RequestTime)
-		message += fmt.Sprintf("• Processing time: %s\n\n", elapsed.Round(time.Second))
+		for _, currentlyProcessing := range processing {
+			message += fmt.Sprintf("• Request ID: `%s`\n", currentlyProcessing.UniqueID)
+			message += fmt.Sprintf("• From user: %d\n", currentlyProcessing.SenderID)
+			elapsed := downloader.Elapsed(currentlyProcessing.RequestTime, time.Now())
+			message += fmt.Sprintf("• Processing time: %s\n\n", downloader.FormatDuration(elapsed))
+		}
 	} else {
 		message += "🎵 **Currently Processing:** None\n\n"
 	}
@@ -108,7 +112,7 @@ func (h *QueueHandler) createQueueStatusMessage(queue *SongQueue) string {
 			message += fmt.Sprintf("%d. User %d (requested %s ago)\n",
 				i+1,
 				request.SenderID,
-				time.Since(request.RequestTime).Round(time.Second))
+				downloader.FormatDuration(downloader.Elapsed(request.RequestTime, time.Now())))
 		}
 	} else {
 		message += "📋 **Queue:** Empty\n"
@@ -121,38 +125,5 @@ func (h *QueueHandler) createQueueStatusMessage(queue *SongQueue) string {
 
 // sendErrorMessage sends an error message to the user
 func (h *QueueHandler) sendErrorMessage(ctx context.Context, chatID int64, errorMsg string) error {
-	return h.sendMessage(ctx, chatID, "❌ "+errorMsg)
-}
-
-// sendMessage sends a text message to the specified chat
-func (h *QueueHandler) sendMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
-	}
-
-	// For bot API, we need to determine the correct peer type
-	var peer tg.InputPeerClass
-
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-
-	// Create the message request
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  message,
-		RandomID: time.Now().UnixNano(),
-	}
-
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send message via Telegram API: %w", err)
-	}
-
-	return nil
+	return h.sender.SendMarkdown(ctx, chatID, "❌ "+errorMsg)
 }
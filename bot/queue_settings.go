@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// QueueSettingsFile is the name of the queue settings file inside the data dir
+const QueueSettingsFile = "queue_settings.json"
+
+// QueueSettings holds the queue limits that survive restarts
+type QueueSettings struct {
+	MaxSize int `json:"max_size"`
+	Workers int `json:"workers"`
+}
+
+// LoadQueueSettings reads saved queue settings. It returns nil without an
+// error when nothing has been saved yet
+func LoadQueueSettings(path string) (*QueueSettings, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue settings: %w", err)
+	}
+
+	var settings QueueSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse queue settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+// SaveQueueSettings writes queue settings to path, replacing the previous file
+// atomically
+func SaveQueueSettings(path string, settings QueueSettings) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode queue settings: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write queue settings: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save queue settings: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// QueueStateVersion is the format version of the saved queue. Files with a
+// newer version are not loaded
+const QueueStateVersion = 1
+
+// queueState is the saved form of the waiting requests
+type queueState struct {
+	Version  int            `json:"version"`
+	Requests []savedRequest `json:"requests"`
+}
+
+// savedRequest is a waiting request as it is saved. Only the link entities of
+// the original message are kept, as they are all re-validation looks at
+type savedRequest struct {
+	UniqueID      string           `json:"unique_id"`
+	CorrelationID string           `json:"correlation_id"`
+	Attempt       int              `json:"attempt"`
+	SenderID      int64            `json:"sender_id"`
+	ChatID        int64            `json:"chat_id"`
+	MessageID     int              `json:"message_id"`
+	URL           string           `json:"url"`
+	RequestTime   time.Time        `json:"request_time"`
+	OriginalText  string           `json:"original_text,omitempty"`
+	LinkEntities  []savedEntity    `json:"link_entities,omitempty"`
+	Override      DownloadOverride `json:"override"`
+	BatchID       string           `json:"batch_id,omitempty"`
+	StatusMessage int              `json:"status_message_id,omitempty"`
+}
+
+// savedEntity is a URL entity, or a text link when URL is set
+type savedEntity struct {
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+}
+
+// saveRequest converts a waiting request to its saved form
+func saveRequest(request *QueueRequest) savedRequest {
+	saved := savedRequest{
+		UniqueID:      request.UniqueID,
+		CorrelationID: request.CorrelationID,
+		Attempt:       request.Attempt,
+		SenderID:      request.SenderID,
+		ChatID:        request.ChatID,
+		MessageID:     request.MessageID,
+		URL:           request.URL,
+		RequestTime:   request.RequestTime,
+		OriginalText:  request.OriginalText,
+		Override:      request.Override,
+		BatchID:       request.BatchID,
+		StatusMessage: request.StatusMessageID,
+	}
+
+	for _, entity := range request.OriginalEntities {
+		switch e := entity.(type) {
+		case *tg.MessageEntityURL:
+			saved.LinkEntities = append(saved.LinkEntities, savedEntity{Offset: e.Offset, Length: e.Length})
+		case *tg.MessageEntityTextURL:
+			saved.LinkEntities = append(saved.LinkEntities, savedEntity{Offset: e.Offset, Length: e.Length, URL: e.URL})
+		}
+	}
+
+	return saved
+}
+
+// request converts a saved request back to a waiting one
+func (saved savedRequest) request() *QueueRequest {
+	request := &QueueRequest{
+		UniqueID:        saved.UniqueID,
+		CorrelationID:   saved.CorrelationID,
+		Attempt:         saved.Attempt,
+		SenderID:        saved.SenderID,
+		ChatID:          saved.ChatID,
+		MessageID:       saved.MessageID,
+		URL:             saved.URL,
+		RequestTime:     saved.RequestTime,
+		Status:          StatusQueued,
+		OriginalText:    saved.OriginalText,
+		Override:        saved.Override,
+		BatchID:         saved.BatchID,
+		StatusMessageID: saved.StatusMessage,
+	}
+
+	for _, entity := range saved.LinkEntities {
+		if entity.URL != "" {
+			request.OriginalEntities = append(request.OriginalEntities, &tg.MessageEntityTextURL{Offset: entity.Offset, Length: entity.Length, URL: entity.URL})
+		} else {
+			request.OriginalEntities = append(request.OriginalEntities, &tg.MessageEntityURL{Offset: entity.Offset, Length: entity.Length})
+		}
+	}
+
+	return request
+}
+
+// LoadQueuedRequests reads the requests saved to path. It returns nil without
+// an error when nothing has been saved yet
+func LoadQueuedRequests(path string) ([]*QueueRequest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queued requests: %w", err)
+	}
+
+	var state queueState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse queued requests: %w", err)
+	}
+	if state.Version < 1 || state.Version > QueueStateVersion {
+		return nil, fmt.Errorf("unsupported queued requests version %d (supported: %d)", state.Version, QueueStateVersion)
+	}
+
+	requests := make([]*QueueRequest, len(state.Requests))
+	for i, saved := range state.Requests {
+		requests[i] = saved.request()
+	}
+	return requests, nil
+}
+
+// SaveQueuedRequests writes the waiting requests to path, replacing the
+// previous file atomically
+func SaveQueuedRequests(path string, requests []*QueueRequest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	state := queueState{Version: QueueStateVersion, Requests: make([]savedRequest, len(requests))}
+	for i, request := range requests {
+		state.Requests[i] = saveRequest(request)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode queued requests: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write queued requests: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save queued requests: %w", err)
+	}
+
+	return nil
+}
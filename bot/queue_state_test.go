@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestQueuedRequests_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	text := "/song check this out"
+	requests := []*QueueRequest{
+		{
+			UniqueID:         "1:2:3",
+			CorrelationID:    "1:2:3",
+			Attempt:          2,
+			SenderID:         1,
+			ChatID:           2,
+			MessageID:        3,
+			URL:              "https://music.apple.com/in/song/test/123",
+			RequestTime:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Status:           StatusQueued,
+			OriginalText:     text,
+			OriginalEntities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 5}, &tg.MessageEntityTextURL{Offset: 6, Length: 5, URL: "https://music.apple.com/in/song/test/123"}},
+			Override:         DownloadOverride{Storefront: "gb", ProgressMessageID: 9},
+		},
+		{
+			UniqueID:         "batch:0",
+			CorrelationID:    "batch:0",
+			Attempt:          1,
+			SenderID:         4,
+			ChatID:           5,
+			URL:              "https://music.apple.com/us/song/other/456",
+			RequestTime:      time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC),
+			Status:           StatusQueued,
+			OriginalText:     "/song https://music.apple.com/us/song/other/456",
+			OriginalEntities: []tg.MessageEntityClass{&tg.MessageEntityURL{Offset: 6, Length: 41}},
+			BatchID:          "batch",
+		},
+	}
+
+	if err := SaveQueuedRequests(path, requests); err != nil {
+		t.Fatalf("SaveQueuedRequests() error = %v", err)
+	}
+	loaded, err := LoadQueuedRequests(path)
+	if err != nil {
+		t.Fatalf("LoadQueuedRequests() error = %v", err)
+	}
+
+	// Only the link entities are kept
+	requests[0].OriginalEntities = requests[0].OriginalEntities[1:]
+	if !reflect.DeepEqual(loaded, requests) {
+		t.Errorf("Round trip changed the requests:\ngot  %+v\nwant %+v", loaded, requests)
+	}
+}
+
+func TestLoadQueuedRequests_Version(t *testing.T) {
+	dir := t.TempDir()
+
+	if requests, err := LoadQueuedRequests(filepath.Join(dir, "missing.json")); requests != nil || err != nil {
+		t.Errorf("Expected nothing for a missing file, got %v, %v", requests, err)
+	}
+
+	for _, content := range []string{`{"requests":[]}`, `{"version":2,"requests":[]}`} {
+		path := filepath.Join(dir, "queue.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadQueuedRequests(path); err == nil || !strings.Contains(err.Error(), "unsupported queued requests version") {
+			t.Errorf("Expected %s to be rejected, got %v", content, err)
+		}
+	}
+}
+
+func TestSongQueue_RequestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	logger := logging.Discard()
+
+	// Nothing is processed, so every request stays queued
+	queue := NewSongQueue(context.Background(), logger, nil)
+	queue.workers = 0
+	if _, err := queue.EnableRequestPersistence(path, time.Hour); err != nil {
+		t.Fatalf("EnableRequestPersistence() error = %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := queue.AddRequest(int64(i), 2, i, fmt.Sprintf("https://music.apple.com/in/song/test/%d", i)); err != nil {
+			t.Fatalf("AddRequest() error = %v", err)
+		}
+	}
+
+	// The oldest request has been waiting too long by the time of the restart
+	queue.mu.Lock()
+	queue.queue[0].RequestTime = time.Now().Add(-2 * time.Hour)
+	queue.persistLocked()
+	queue.mu.Unlock()
+
+	restarted := NewSongQueue(context.Background(), logger, nil)
+	restarted.requestDelay = 0
+	processed := make(chan string, 3)
+	restarted.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		processed <- cmdCtx.RequestID
+		return nil
+	}
+
+	loaded, err := restarted.EnableRequestPersistence(path, time.Hour)
+	if err != nil {
+		t.Fatalf("EnableRequestPersistence() error = %v", err)
+	}
+	if loaded != 2 {
+		t.Fatalf("Expected 2 requests to be loaded, got %d", loaded)
+	}
+	if position := restarted.GetQueuePosition(GenerateUniqueID(2, 2, 2)); position != 1 {
+		t.Errorf("Expected the second request first in the queue, got position %d", position)
+	}
+
+	// Loaded requests wait for Resume
+	select {
+	case id := <-processed:
+		t.Fatalf("Request %s was processed before Resume", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	restarted.Resume()
+	for _, want := range []string{GenerateUniqueID(2, 2, 2), GenerateUniqueID(3, 2, 3)} {
+		select {
+		case id := <-processed:
+			if id != want {
+				t.Errorf("Processed %s, want %s", id, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+
+	// Requests taken by a worker are no longer saved
+	waitFor(t, "the saved queue to empty", func() bool {
+		requests, err := LoadQueuedRequests(path)
+		return err == nil && len(requests) == 0
+	})
+}
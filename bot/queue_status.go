@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// queueStatusEditTimeout bounds one edit of a status message
+const queueStatusEditTimeout = 10 * time.Second
+
+// queueStatusEdit is the latest text of one status message
+type queueStatusEdit struct {
+	chatID    int64
+	messageID int
+	message   string
+}
+
+// QueueStatusUpdater edits the status messages of queued requests as they
+// move up in the queue. Edits run in the background paced by the edit rate
+// controller, and edits of a message that were not sent yet are replaced by
+// its latest one
+type QueueStatusUpdater struct {
+	mu      sync.Mutex
+	pending map[string]queueStatusEdit
+	order   []string
+	sending string // Request whose edit is being sent, cleared when it starts
+	running bool
+	edit    func(ctx context.Context, chatID int64, messageID int, message string) error
+	pacer   *downloader.EditPacer
+	logger  logging.Logger
+}
+
+// NewQueueStatusUpdater creates an updater editing status messages with edit,
+// paced by pacer (nil sends them as fast as they come)
+func NewQueueStatusUpdater(edit func(ctx context.Context, chatID int64, messageID int, message string) error, pacer *downloader.EditPacer, logger logging.Logger) *QueueStatusUpdater {
+	return &QueueStatusUpdater{
+		pending: make(map[string]queueStatusEdit),
+		edit:    edit,
+		pacer:   pacer,
+		logger:  logger,
+	}
+}
+
+// OnQueueEvent implements QueueListener
+func (u *QueueStatusUpdater) OnQueueEvent(event QueueEvent) {
+	if event.Kind != QueueEventMoved || event.StatusMessageID == 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// A started request's progress takes the message over, so a queue
+	// position still waiting to be shown would only overwrite it
+	if event.Position == 0 {
+		if u.sending == event.RequestID {
+			u.sending = ""
+		}
+		if _, ok := u.pending[event.RequestID]; ok {
+			delete(u.pending, event.RequestID)
+			u.order = slices.DeleteFunc(u.order, func(id string) bool { return id == event.RequestID })
+		}
+		return
+	}
+	message := queuedStatusMessage(event.Position, event.QueueSize, event.ETA)
+
+	if _, ok := u.pending[event.RequestID]; !ok {
+		u.order = append(u.order, event.RequestID)
+	}
+	u.pending[event.RequestID] = queueStatusEdit{chatID: event.ChatID, messageID: event.StatusMessageID, message: message}
+	if !u.running {
+		u.running = true
+		go u.flush()
+	}
+}
+
+// flush sends the pending edits until there are none left. An edit answered
+// with FLOOD_WAIT is sent again once it expires unless a newer one replaced it
+func (u *QueueStatusUpdater) flush() {
+	for {
+		u.mu.Lock()
+		if len(u.order) == 0 {
+			u.running = false
+			u.mu.Unlock()
+			return
+		}
+		requestID := u.order[0]
+		u.order = u.order[1:]
+		edit := u.pending[requestID]
+		delete(u.pending, requestID)
+		u.sending = requestID
+		u.mu.Unlock()
+
+		// A request that started while the edit waited for the pacer has its
+		// progress on the message by now
+		u.pacer.Wait(context.Background())
+		if !u.stillSending(requestID) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), queueStatusEditTimeout)
+		err := u.edit(ctx, edit.chatID, edit.messageID, edit.message)
+		cancel()
+		u.pacer.Result(err)
+
+		if wait, ok := downloader.IsFloodWait(err); ok {
+			u.logger.Debug("Queue status edit hit FLOOD_WAIT, retrying", logging.String("Request", requestID), logging.Duration("Wait", wait))
+			u.retry(requestID, edit)
+		} else if err != nil {
+			u.logger.Warn("Failed to update queue status", logging.String("Request", requestID), logging.Err(err))
+		}
+	}
+}
+
+// stillSending reports whether the request whose edit is being sent has not
+// started yet
+func (u *QueueStatusUpdater) stillSending(requestID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.sending == requestID
+}
+
+// retry puts edit back at the front of the pending edits unless its request
+// started meanwhile or a newer edit of it is already pending
+func (u *QueueStatusUpdater) retry(requestID string, edit queueStatusEdit) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.pending[requestID]; ok || u.sending != requestID {
+		return
+	}
+	u.pending[requestID] = edit
+	u.order = append([]string{requestID}, u.order...)
+}
+
+// queuedStatusMessage tells the user where their request is in the queue
+func queuedStatusMessage(position, size int, eta time.Duration) string {
+	return fmt.Sprintf("⏳ Position %d of %d, est. wait %s", position, size, formatWait(eta))
+}
+
+// formatWait formats an estimated wait in whole minutes, e.g. "~6 min"
+func formatWait(eta time.Duration) string {
+	minutes := int(eta.Round(time.Minute) / time.Minute)
+	switch {
+	case minutes < 1:
+		return "<1 min"
+	case minutes < 60:
+		return fmt.Sprintf("~%d min", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("~%d h", minutes/60)
+	default:
+		return fmt.Sprintf("~%d h %d min", minutes/60, minutes%60)
+	}
+}
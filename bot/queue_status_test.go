@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+
+	"go-alac-bot/logging"
+)
+
+func TestQueuedStatusMessage(t *testing.T) {
+	testCases := []struct {
+		position int
+		size     int
+		eta      time.Duration
+		expected string
+	}{
+		{2, 5, 6 * time.Minute, "⏳ Position 2 of 5, est. wait ~6 min"},
+		{1, 1, 20 * time.Second, "⏳ Position 1 of 1, est. wait <1 min"},
+		{7, 9, 2 * time.Hour, "⏳ Position 7 of 9, est. wait ~2 h"},
+		{12, 40, 95 * time.Minute, "⏳ Position 12 of 40, est. wait ~1 h 35 min"},
+	}
+
+	for _, tc := range testCases {
+		if got := queuedStatusMessage(tc.position, tc.size, tc.eta); got != tc.expected {
+			t.Errorf("queuedStatusMessage(%d, %d, %v) = %q, want %q", tc.position, tc.size, tc.eta, got, tc.expected)
+		}
+	}
+}
+
+// statusEdits records the edits of a QueueStatusUpdater, blocking each until
+// release is closed
+type statusEdits struct {
+	mu      sync.Mutex
+	edits   []sentMessage
+	release chan struct{}
+}
+
+func (e *statusEdits) edit(ctx context.Context, chatID int64, messageID int, message string) error {
+	<-e.release
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.edits = append(e.edits, sentMessage{kind: "edit", chatID: chatID, messageID: messageID, message: message})
+	return nil
+}
+
+func (e *statusEdits) sent() []sentMessage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]sentMessage(nil), e.edits...)
+}
+
+func TestQueueStatusUpdater_KeepsLatestEdit(t *testing.T) {
+	edits := &statusEdits{release: make(chan struct{})}
+	updater := NewQueueStatusUpdater(edits.edit, nil, logging.Discard())
+
+	// The first edit is in flight while the request moves up twice more
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "a", ChatID: 2, StatusMessageID: 10, Position: 3, QueueSize: 3, ETA: 3 * time.Minute})
+	waitFor(t, "the first edit to start", func() bool {
+		updater.mu.Lock()
+		defer updater.mu.Unlock()
+		return len(updater.order) == 0
+	})
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "a", ChatID: 2, StatusMessageID: 10, Position: 2, QueueSize: 2, ETA: 2 * time.Minute})
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "a", ChatID: 2, StatusMessageID: 10, Position: 1, QueueSize: 1, ETA: time.Minute})
+
+	// A started request's pending position is dropped, its progress takes
+	// the message over
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "b", ChatID: 2, StatusMessageID: 11, Position: 2, QueueSize: 2, ETA: 2 * time.Minute})
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "b", ChatID: 2, StatusMessageID: 11})
+
+	// Events of other kinds and of requests without status message are ignored
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventStarted, RequestID: "a", ChatID: 2, StatusMessageID: 10})
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "c", ChatID: 2, Position: 1, QueueSize: 1})
+	close(edits.release)
+
+	waitFor(t, "the status edits", func() bool { return len(edits.sent()) >= 2 })
+	time.Sleep(20 * time.Millisecond)
+
+	got := edits.sent()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 edits, got %+v", got)
+	}
+	if got[0].messageID != 10 || got[0].message != "⏳ Position 3 of 3, est. wait ~3 min" {
+		t.Errorf("Unexpected first edit: %+v", got[0])
+	}
+	if got[1].messageID != 10 || got[1].message != "⏳ Position 1 of 1, est. wait ~1 min" {
+		t.Errorf("Expected the pending edits to be replaced by the latest, got %+v", got[1])
+	}
+}
+
+func TestQueueStatusUpdater_RetriesAfterFloodWait(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []string
+	edit := func(ctx context.Context, chatID int64, messageID int, message string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, message)
+		if len(attempts) == 1 {
+			return tgerr.New(420, "FLOOD_WAIT_1")
+		}
+		return nil
+	}
+	updater := NewQueueStatusUpdater(edit, nil, logging.Discard())
+
+	updater.OnQueueEvent(QueueEvent{Kind: QueueEventMoved, RequestID: "a", ChatID: 2, StatusMessageID: 10, Position: 2, QueueSize: 2, ETA: 2 * time.Minute})
+
+	waitFor(t, "the edit to be sent again", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == 2
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts[1] != attempts[0] {
+		t.Errorf("Expected the same edit to be sent again, got %q", attempts)
+	}
+}
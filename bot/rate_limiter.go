@@ -0,0 +1,163 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimiterPruneInterval is how often buckets that refilled completely
+	// are forgotten
+	rateLimiterPruneInterval = 10 * time.Minute
+
+	// lookupRateFactor is how many times the /song limits a user or chat may
+	// send /cover and /info, which need no decryption
+	lookupRateFactor = 4
+)
+
+// RateLimitStats describes a RateLimiter for /stats
+type RateLimitStats struct {
+	UserRate int // Requests per minute per user (0 = unlimited)
+	ChatRate int // Requests per minute per chat (0 = unlimited)
+	Users    int // Users currently tracked
+	Chats    int // Chats currently tracked
+	Allowed  int // Requests allowed since startup
+	Limited  int // Requests refused since startup
+}
+
+// RateLimiter limits how many requests of a kind each user and each chat may
+// make per minute; /song, lookups and inline queries each have their own.
+// Both are token buckets holding up to a minute of requests, so a full
+// minute's worth may be sent at once. It is safe for concurrent use
+type RateLimiter struct {
+	mu       sync.Mutex
+	userRate int
+	chatRate int
+	users    map[int64]*tokenBucket
+	chats    map[int64]*tokenBucket
+	allowed  int
+	limited  int
+	pruned   time.Time
+
+	now func() time.Time
+}
+
+// tokenBucket holds the requests a user or chat may still make
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter allowing userRate requests per minute per
+// user and chatRate per chat (0 = unlimited)
+func NewRateLimiter(userRate, chatRate int) *RateLimiter {
+	return &RateLimiter{
+		userRate: max(userRate, 0),
+		chatRate: max(chatRate, 0),
+		users:    make(map[int64]*tokenBucket),
+		chats:    make(map[int64]*tokenBucket),
+		now:      time.Now,
+	}
+}
+
+// Allow takes a request of a user in a chat. When either is over its limit
+// nothing is taken, and Allow returns false with the wait until the request
+// would be allowed and whether the chat is the one limited
+func (l *RateLimiter) Allow(userID, chatID int64) (ok bool, wait time.Duration, chatLimited bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.pruneLocked(now)
+
+	user := l.bucketLocked(l.users, userID, l.userRate, now)
+	chat := l.bucketLocked(l.chats, chatID, l.chatRate, now)
+	if wait = user.wait(l.userRate); wait == 0 {
+		if wait = chat.wait(l.chatRate); wait > 0 {
+			chatLimited = true
+		}
+	}
+	if wait > 0 {
+		l.limited++
+		return false, wait, chatLimited
+	}
+
+	if user != nil {
+		user.tokens--
+	}
+	if chat != nil {
+		chat.tokens--
+	}
+	l.allowed++
+	return true, 0, false
+}
+
+// Stats returns the limits and how many requests were allowed and refused
+func (l *RateLimiter) Stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(l.now())
+	return RateLimitStats{
+		UserRate: l.userRate,
+		ChatRate: l.chatRate,
+		Users:    len(l.users),
+		Chats:    len(l.chats),
+		Allowed:  l.allowed,
+		Limited:  l.limited,
+	}
+}
+
+// bucketLocked returns the bucket of id refilled until now, nil when rate is
+// unlimited
+func (l *RateLimiter) bucketLocked(buckets map[int64]*tokenBucket, id int64, rate int, now time.Time) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	bucket, ok := buckets[id]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rate), updated: now}
+		buckets[id] = bucket
+	}
+	bucket.refill(rate, now)
+	return bucket
+}
+
+// pruneLocked forgets the buckets that refilled completely, at most once per
+// rateLimiterPruneInterval
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.pruned) < rateLimiterPruneInterval {
+		return
+	}
+	l.pruned = now
+
+	for _, limit := range []struct {
+		buckets map[int64]*tokenBucket
+		rate    int
+	}{{l.users, l.userRate}, {l.chats, l.chatRate}} {
+		for id, bucket := range limit.buckets {
+			bucket.refill(limit.rate, now)
+			if bucket.tokens >= float64(limit.rate) {
+				delete(limit.buckets, id)
+			}
+		}
+	}
+}
+
+// refill adds the tokens earned since the bucket was last updated, up to a
+// minute of requests
+func (b *tokenBucket) refill(rate int, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(float64(rate), b.tokens+elapsed.Minutes()*float64(rate))
+		b.updated = now
+	}
+}
+
+// wait returns how long until the bucket holds a token, 0 when it does or
+// when it is nil
+func (b *tokenBucket) wait(rate int) time.Duration {
+	if b == nil || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(float64(time.Minute) * (1 - b.tokens) / float64(rate))
+}
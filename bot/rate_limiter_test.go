@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter creates a limiter whose clock is moved by the returned
+// function
+func newTestRateLimiter(userRate, chatRate int) (*RateLimiter, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(userRate, chatRate)
+	limiter.now = func() time.Time { return now }
+	return limiter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	limiter, advance := newTestRateLimiter(3, 0)
+
+	// A minute's worth of requests is allowed at once
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := limiter.Allow(1, 100); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait, chatLimited := limiter.Allow(1, 100)
+	if ok || chatLimited {
+		t.Fatalf("Expected the user to be limited, got ok=%v chatLimited=%v", ok, chatLimited)
+	}
+	if wait != 20*time.Second {
+		t.Errorf("Expected to wait for one token (20s), got %s", wait)
+	}
+
+	// Other users have their own bucket
+	if ok, _, _ := limiter.Allow(2, 100); !ok {
+		t.Error("Expected another user to be allowed")
+	}
+
+	advance(15 * time.Second)
+	if _, wait, _ := limiter.Allow(1, 100); wait != 5*time.Second {
+		t.Errorf("Expected the wait to shrink to 5s, got %s", wait)
+	}
+	advance(6 * time.Second)
+	if ok, _, _ := limiter.Allow(1, 100); !ok {
+		t.Error("Expected a request to be allowed once a token was refilled")
+	}
+
+	// Buckets refill to at most a minute's worth
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.Allow(1, 100)
+	}
+	if ok, _, _ := limiter.Allow(1, 100); ok {
+		t.Error("Expected an idle hour not to allow more than the burst")
+	}
+}
+
+func TestRateLimiter_Chat(t *testing.T) {
+	limiter, _ := newTestRateLimiter(2, 3)
+
+	limiter.Allow(1, 100)
+	limiter.Allow(2, 100)
+	limiter.Allow(3, 100)
+	ok, wait, chatLimited := limiter.Allow(4, 100)
+	if ok || !chatLimited || wait != 20*time.Second {
+		t.Errorf("Expected the chat to be limited for 20s, got ok=%v wait=%s chatLimited=%v", ok, wait, chatLimited)
+	}
+
+	// A request refused by the chat takes nothing from the user
+	if ok, _, _ := limiter.Allow(4, 200); !ok {
+		t.Fatal("Expected the user to be allowed in another chat")
+	}
+	if ok, _, _ := limiter.Allow(4, 300); !ok {
+		t.Error("Expected the refused request not to count against the user")
+	}
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	limiter, _ := newTestRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if ok, _, _ := limiter.Allow(1, 100); !ok {
+			t.Fatalf("Expected request %d to be allowed without limits", i+1)
+		}
+	}
+	if stats := limiter.Stats(); stats.Users != 0 || stats.Chats != 0 || stats.Allowed != 100 {
+		t.Errorf("Expected nothing tracked without limits, got %+v", stats)
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	limiter, advance := newTestRateLimiter(2, 10)
+
+	limiter.Allow(1, 100)
+	limiter.Allow(1, 100)
+	limiter.Allow(1, 100)
+	advance(rateLimiterPruneInterval)
+	limiter.Allow(2, 200)
+
+	stats := limiter.Stats()
+	if stats.Users != 1 || stats.Chats != 1 {
+		t.Errorf("Expected only the active user and chat to be tracked, got %+v", stats)
+	}
+	if stats.UserRate != 2 || stats.ChatRate != 10 || stats.Allowed != 3 || stats.Limited != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Buckets still refilling are kept
+	limiter.Allow(2, 200)
+	advance(rateLimiterPruneInterval)
+	limiter.users[2].tokens = 0
+	limiter.users[2].updated = limiter.now()
+	if stats := limiter.Stats(); stats.Users != 1 {
+		t.Errorf("Expected the refilling user to be kept, got %+v", stats)
+	}
+}
+
+func TestRateLimiter_Concurrent(t *testing.T) {
+	limiter := NewRateLimiter(50, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				limiter.Allow(1, 100)
+				limiter.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stats := limiter.Stats(); stats.Allowed+stats.Limited != 100 || stats.Allowed < 50 {
+		t.Errorf("Expected 100 requests with the burst allowed, got %+v", stats)
+	}
+}
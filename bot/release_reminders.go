@@ -0,0 +1,474 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const (
+	// ReleaseRemindersFile is the name of the release reminders file inside the data dir
+	ReleaseRemindersFile = "release_reminders.json"
+
+	// MaxRemindersPerUser is how many release reminders one user can have
+	MaxRemindersPerUser = 10
+
+	// ReleaseOfferTTL is how long the reminder buttons of a not-released
+	// message can be pressed
+	ReleaseOfferTTL = 24 * time.Hour
+
+	// ReleaseCheckInterval is how often reminders past their release date are
+	// checked against the catalog
+	ReleaseCheckInterval = time.Hour
+
+	// ReleaseGiveUpAfter is how long after its release date a song that is
+	// still not out is checked for before the reminder is dropped
+	ReleaseGiveUpAfter = 14 * 24 * time.Hour
+
+	reminderCallbackPrefix = "rmd"
+	reminderNotifyChoice   = "notify"
+	reminderAutoChoice     = "auto"
+	reminderIDBytes        = 4
+)
+
+var (
+	errReminderOfferExpired = errors.New("reminder offer expired")
+	errReminderLimit        = errors.New("too many release reminders")
+)
+
+// ReleaseReminder is a user's subscription to the release of a song
+type ReleaseReminder struct {
+	ID           string    `json:"id"`
+	UserID       int64     `json:"user_id"`
+	ChatID       int64     `json:"chat_id"`
+	SongID       string    `json:"song_id"`
+	SongURL      string    `json:"song_url"`
+	Title        string    `json:"title"`
+	Artist       string    `json:"artist"`
+	ReleaseDate  time.Time `json:"release_date"`
+	AutoDownload bool      `json:"auto_download"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// releaseOffer is a not-released song whose reminder buttons were shown
+type releaseOffer struct {
+	songURL   string
+	status    downloader.ReleaseStatus
+	createdAt time.Time
+}
+
+// ReleaseReminders keeps the release reminders, saved to a file in the data
+// dir so they survive restarts, and the offers their buttons refer to
+type ReleaseReminders struct {
+	mu        sync.Mutex
+	path      string
+	reminders []*ReleaseReminder
+	offers    map[string]*releaseOffer
+	now       func() time.Time
+}
+
+// NewReleaseReminders creates a reminder store saved to path, loading the
+// reminders saved there. An empty path keeps them in memory only
+func NewReleaseReminders(path string) (*ReleaseReminders, error) {
+	rr := &ReleaseReminders{
+		path:   path,
+		offers: make(map[string]*releaseOffer),
+		now:    time.Now,
+	}
+	if path == "" {
+		return rr, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rr, nil
+	}
+	if err != nil {
+		return rr, fmt.Errorf("failed to read release reminders: %w", err)
+	}
+	if err := json.Unmarshal(data, &rr.reminders); err != nil {
+		return rr, fmt.Errorf("failed to parse release reminders: %w", err)
+	}
+	return rr, nil
+}
+
+// Offer stores a not-released song and returns the token its buttons refer to
+func (rr *ReleaseReminders) Offer(songURL string, status downloader.ReleaseStatus) (string, error) {
+	buf := make([]byte, overrideTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	// Drop the offers nobody pressed in time
+	now := rr.now()
+	for t, offer := range rr.offers {
+		if now.Sub(offer.createdAt) > ReleaseOfferTTL {
+			delete(rr.offers, t)
+		}
+	}
+
+	rr.offers[token] = &releaseOffer{songURL: songURL, status: status, createdAt: now}
+	return token, nil
+}
+
+// Subscribe creates the reminder of userID in chatID for the song offered
+// with token. Subscribing to a song again only changes whether it is
+// downloaded on release
+func (rr *ReleaseReminders) Subscribe(token string, userID, chatID int64, autoDownload bool) (ReleaseReminder, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	offer, ok := rr.offers[token]
+	if !ok || rr.now().Sub(offer.createdAt) > ReleaseOfferTTL {
+		delete(rr.offers, token)
+		return ReleaseReminder{}, errReminderOfferExpired
+	}
+
+	count := 0
+	for _, reminder := range rr.reminders {
+		if reminder.UserID != userID {
+			continue
+		}
+		if reminder.SongID == offer.status.SongID && reminder.ChatID == chatID {
+			reminder.AutoDownload = autoDownload
+			return *reminder, rr.saveLocked()
+		}
+		count++
+	}
+	if count >= MaxRemindersPerUser {
+		return ReleaseReminder{}, errReminderLimit
+	}
+
+	buf := make([]byte, reminderIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return ReleaseReminder{}, err
+	}
+
+	reminder := &ReleaseReminder{
+		ID:           hex.EncodeToString(buf),
+		UserID:       userID,
+		ChatID:       chatID,
+		SongID:       offer.status.SongID,
+		SongURL:      offer.songURL,
+		Title:        offer.status.Title,
+		Artist:       offer.status.Artist,
+		ReleaseDate:  offer.status.ReleaseDate,
+		AutoDownload: autoDownload,
+		CreatedAt:    rr.now(),
+	}
+	rr.reminders = append(rr.reminders, reminder)
+	return *reminder, rr.saveLocked()
+}
+
+// List returns the reminders of userID, soonest release first
+func (rr *ReleaseReminders) List(userID int64) []ReleaseReminder {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var reminders []ReleaseReminder
+	for _, reminder := range rr.reminders {
+		if reminder.UserID == userID {
+			reminders = append(reminders, *reminder)
+		}
+	}
+	slices.SortStableFunc(reminders, func(a, b ReleaseReminder) int {
+		return a.ReleaseDate.Compare(b.ReleaseDate)
+	})
+	return reminders
+}
+
+// Cancel removes the reminder id of userID and reports whether there was one
+func (rr *ReleaseReminders) Cancel(userID int64, id string) (ReleaseReminder, bool, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for i, reminder := range rr.reminders {
+		if reminder.UserID == userID && reminder.ID == id {
+			rr.reminders = slices.Delete(rr.reminders, i, i+1)
+			return *reminder, true, rr.saveLocked()
+		}
+	}
+	return ReleaseReminder{}, false, nil
+}
+
+// Due returns the reminders whose release date has passed at now
+func (rr *ReleaseReminders) Due(now time.Time) []ReleaseReminder {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var due []ReleaseReminder
+	for _, reminder := range rr.reminders {
+		if !reminder.ReleaseDate.After(now) {
+			due = append(due, *reminder)
+		}
+	}
+	return due
+}
+
+// Remove deletes the reminder id, once it has been acted on
+func (rr *ReleaseReminders) Remove(id string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.reminders = slices.DeleteFunc(rr.reminders, func(reminder *ReleaseReminder) bool {
+		return reminder.ID == id
+	})
+	return rr.saveLocked()
+}
+
+// Postpone moves the release date of the reminder id, when the catalog moved it
+func (rr *ReleaseReminders) Postpone(id string, releaseDate time.Time) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for _, reminder := range rr.reminders {
+		if reminder.ID == id {
+			reminder.ReleaseDate = releaseDate
+		}
+	}
+	return rr.saveLocked()
+}
+
+// saveLocked writes the reminders to the file, replacing the previous one
+// atomically (must be called with lock held)
+func (rr *ReleaseReminders) saveLocked() error {
+	if rr.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(rr.path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(rr.reminders, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode release reminders: %w", err)
+	}
+
+	tmpPath := rr.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write release reminders: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, rr.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save release reminders: %w", err)
+	}
+
+	return nil
+}
+
+// songLabel names a song as "Title — Artist", or by its ID when the catalog
+// gave no title
+func songLabel(title, artist, songID string) string {
+	switch {
+	case title == "":
+		return "song " + songID
+	case artist == "":
+		return title
+	default:
+		return title + " — " + artist
+	}
+}
+
+// formatReleaseDate renders a release date, or "an unknown date"
+func formatReleaseDate(date time.Time) string {
+	if date.IsZero() {
+		return "an unknown date"
+	}
+	return date.Format("2006-01-02")
+}
+
+// createNotReleasedMessage tells the user the song is not out yet
+func createNotReleasedMessage(status *downloader.ReleaseStatus) string {
+	return fmt.Sprintf("⏳ %s isn't released yet. It comes out on %s.\n\nWant to hear about it when it does?",
+		songLabel(status.Title, status.Artist, status.SongID), formatReleaseDate(status.ReleaseDate))
+}
+
+// createReminderMarkup creates the buttons of a not-released message. Button
+// data is "rmd:<token>:<choice>"
+func createReminderMarkup(token string) *tg.ReplyInlineMarkup {
+	return &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{
+		{Buttons: []tg.KeyboardButtonClass{&tg.KeyboardButtonCallback{
+			Text: "🔔 Remind me on release",
+			Data: CallbackData(reminderCallbackPrefix, token+":"+reminderNotifyChoice),
+		}}},
+		{Buttons: []tg.KeyboardButtonClass{&tg.KeyboardButtonCallback{
+			Text: "⬇️ Download on release",
+			Data: CallbackData(reminderCallbackPrefix, token+":"+reminderAutoChoice),
+		}}},
+	}}
+}
+
+// offerReminder replaces the error report of a song that is not released yet
+// with buttons to be reminded of its release. It reports whether it did
+func (h *SongHandler) offerReminder(songURL string, reporter downloader.ProgressReporter, err error) bool {
+	status, ok := downloader.NotReleasedStatus(err)
+	if !ok || h.reminders == nil {
+		return false
+	}
+	prompter, ok := reporter.(downloader.ChoicePrompter)
+	if !ok {
+		return false
+	}
+
+	token, offerErr := h.reminders.Offer(songURL, *status)
+	if offerErr != nil {
+		h.logger.Error("Failed to offer a release reminder", logging.Err(offerErr))
+		return false
+	}
+	if _, promptErr := prompter.ReportChoices(createNotReleasedMessage(status), createReminderMarkup(token)); promptErr != nil {
+		h.logger.Error("Failed to show release reminder buttons", logging.Err(promptErr))
+		return false
+	}
+	return true
+}
+
+// GetReminders returns the release reminders
+func (h *SongHandler) GetReminders() *ReleaseReminders {
+	return h.reminders
+}
+
+// StartReleaseChecker checks the due release reminders every interval until
+// the returned function is called
+func (h *SongHandler) StartReleaseChecker(interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			h.checkReleases(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// checkReleases looks the songs of due reminders up in the catalog. Songs that
+// came out are announced, and queued for those who asked; songs that are still
+// not out are checked again later, until ReleaseGiveUpAfter. Each song is
+// looked up once per check
+func (h *SongHandler) checkReleases(ctx context.Context) {
+	now := h.reminders.now()
+	statuses := make(map[string]*downloader.ReleaseStatus)
+	for _, reminder := range h.reminders.Due(now) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		status, ok := statuses[reminder.SongURL]
+		if !ok {
+			checker, isChecker := h.newDownloader().(downloader.ReleaseChecker)
+			if !isChecker {
+				return
+			}
+			var err error
+			if status, err = checker.ReleaseStatus(ctx, reminder.SongURL); err != nil {
+				h.logger.Warn("Failed to check song release", logging.String("Song", reminder.SongID), logging.Err(err))
+				continue
+			}
+			statuses[reminder.SongURL] = status
+		}
+
+		if !status.Released {
+			h.handleUnreleased(ctx, reminder, status, now)
+			continue
+		}
+
+		h.announceRelease(ctx, reminder, status)
+		if err := h.reminders.Remove(reminder.ID); err != nil {
+			h.logger.Error("Failed to remove release reminder", logging.String("Reminder", reminder.ID), logging.Err(err))
+		}
+	}
+}
+
+// handleUnreleased keeps waiting for a song that is still not out, following
+// a moved release date, or drops the reminder once it is overdue for too long
+func (h *SongHandler) handleUnreleased(ctx context.Context, reminder ReleaseReminder, status *downloader.ReleaseStatus, now time.Time) {
+	if status.ReleaseDate.After(reminder.ReleaseDate) {
+		h.logger.Info("Song release moved", logging.String("Song", reminder.SongID), logging.String("Date", formatReleaseDate(status.ReleaseDate)))
+		if err := h.reminders.Postpone(reminder.ID, status.ReleaseDate); err != nil {
+			h.logger.Error("Failed to save release reminder", logging.String("Reminder", reminder.ID), logging.Err(err))
+		}
+		return
+	}
+	// Songs without a release date are waited for from the subscription on
+	waitingSince := reminder.ReleaseDate
+	if waitingSince.IsZero() {
+		waitingSince = reminder.CreatedAt
+	}
+	if now.Sub(waitingSince) < ReleaseGiveUpAfter {
+		return
+	}
+
+	message := fmt.Sprintf("⌛ %s still isn't out, so I stopped waiting for it. Use /song %s to try again later.",
+		songLabel(reminder.Title, reminder.Artist, reminder.SongID), reminder.SongURL)
+	if err := h.sender().SendMarkdown(ctx, reminder.ChatID, message); err != nil {
+		h.logger.Error("Failed to send release reminder", logging.String("Reminder", reminder.ID), logging.Err(err))
+	}
+	if err := h.reminders.Remove(reminder.ID); err != nil {
+		h.logger.Error("Failed to remove release reminder", logging.String("Reminder", reminder.ID), logging.Err(err))
+	}
+}
+
+// announceRelease tells the subscriber a song is out, queueing it for them
+// when they asked for it to be downloaded
+func (h *SongHandler) announceRelease(ctx context.Context, reminder ReleaseReminder, status *downloader.ReleaseStatus) {
+	label := songLabel(status.Title, status.Artist, reminder.SongID)
+
+	var message string
+	switch {
+	case !status.ALAC:
+		message = fmt.Sprintf("🔔 %s is out, but not in ALAC. Use /song %s to try another store.", label, reminder.SongURL)
+	case reminder.AutoDownload:
+		request, err := h.queue.AddReminderRequest(reminder.ID, reminder.UserID, reminder.ChatID, reminder.SongURL)
+		if err != nil {
+			h.logger.Error("Failed to queue released song", logging.String("Song", reminder.SongID), logging.Int64("User", reminder.UserID), logging.Err(err))
+			message = fmt.Sprintf("🔔 %s is out! I couldn't queue it, use /song %s to download it.", label, reminder.SongURL)
+			break
+		}
+		h.logger.Info("Queued released song", logging.String("Song", reminder.SongID), logging.Int64("User", reminder.UserID), logging.String("Request", request.UniqueID))
+		message = fmt.Sprintf("🔔 %s is out! It has been queued for you.", label)
+	default:
+		message = fmt.Sprintf("🔔 %s is out! Use /song %s to download it.", label, reminder.SongURL)
+	}
+
+	if err := h.sender().SendMarkdown(ctx, reminder.ChatID, message); err != nil {
+		h.logger.Error("Failed to send release reminder", logging.String("Reminder", reminder.ID), logging.Err(err))
+	}
+}
+
+// formatReminder renders one reminder of a /reminders list
+func formatReminder(index int, reminder ReleaseReminder) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d. %s\n", index, songLabel(reminder.Title, reminder.Artist, reminder.SongID))
+	fmt.Fprintf(&b, "   📅 %s", formatReleaseDate(reminder.ReleaseDate))
+	if reminder.AutoDownload {
+		b.WriteString(" · ⬇️ download on release")
+	}
+	fmt.Fprintf(&b, " · ID %s", reminder.ID)
+	return b.String()
+}
@@ -0,0 +1,285 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const releaseTestURL = "https://music.apple.com/us/song/upcoming/1700000001"
+
+// releasingDownloader fails like a song that is not out yet, and answers
+// release checks with status
+type releasingDownloader struct {
+	scriptedDownloader
+	status  downloader.ReleaseStatus
+	checked []string
+}
+
+func (d *releasingDownloader) ReleaseStatus(ctx context.Context, songURL string) (*downloader.ReleaseStatus, error) {
+	d.checked = append(d.checked, songURL)
+	status := d.status
+	return &status, nil
+}
+
+// newReleaseTestHandler returns a song handler whose reminders are saved in a
+// temporary data dir and whose clock is at now
+func newReleaseTestHandler(t *testing.T, now *time.Time) (*SongHandler, *mockReactionAPI) {
+	t.Helper()
+
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &mockReactionAPI{}
+	handler.api = api
+
+	reminders, err := NewReleaseReminders(filepath.Join(t.TempDir(), ReleaseRemindersFile))
+	if err != nil {
+		t.Fatalf("NewReleaseReminders() error = %v", err)
+	}
+	reminders.now = func() time.Time { return *now }
+	handler.reminders = reminders
+	return handler, api
+}
+
+// offerTestReminder runs a download of a song that is not out yet and returns
+// the token of the reminder buttons offered instead of the error
+func offerTestReminder(t *testing.T, handler *SongHandler, releaseDate time.Time) string {
+	t.Helper()
+
+	notReleased := downloader.NewDownloadError(downloader.ErrorNotReleased, "song is not released yet").
+		WithContext("song_id", "1700000001").
+		WithContext("title", "Upcoming").
+		WithContext("artist", "Artist").
+		WithContext("release_date", releaseDate)
+	reporter := &promptingReporter{}
+	cmdCtx := &CommandContext{UserID: 1, ChatID: 100, RequestID: "1:100:1"}
+
+	if err := handler.runDownload(context.Background(), cmdCtx, releaseTestURL, &releasingDownloader{scriptedDownloader: scriptedDownloader{err: notReleased}}, reporter, time.Now()); err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+	if len(reporter.errors) != 0 {
+		t.Errorf("Expected the reminder offer instead of an error report, got %v", reporter.errors)
+	}
+	if !strings.Contains(reporter.message, "Upcoming — Artist isn't released yet") || !strings.Contains(reporter.message, releaseDate.Format("2006-01-02")) {
+		t.Errorf("Unexpected not-released message %q", reporter.message)
+	}
+
+	markup, ok := reporter.markup.(*tg.ReplyInlineMarkup)
+	if !ok || len(markup.Rows) != 2 {
+		t.Fatalf("Expected remind and download buttons, got %#v", reporter.markup)
+	}
+	data := string(markup.Rows[0].Buttons[0].(*tg.KeyboardButtonCallback).Data)
+	if len(data) > MaxCallbackDataLength {
+		t.Errorf("Button data %q is longer than %d bytes", data, MaxCallbackDataLength)
+	}
+	return strings.Split(data, ":")[1]
+}
+
+func TestRemindersHandler_HandleCallback_Subscribes(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	releaseDate := time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)
+	handler, _ := newReleaseTestHandler(t, &now)
+	remindersHandler := NewRemindersHandler(nil, logging.Discard(), handler)
+
+	token := offerTestReminder(t, handler, releaseDate)
+
+	notice, err := remindersHandler.HandleCallback(context.Background(), &CallbackContext{UserID: 2, ChatID: 100, Data: token + ":" + reminderAutoChoice})
+	if err != nil || !strings.Contains(notice, "download it for you") || !strings.Contains(notice, "2026-10-09") {
+		t.Fatalf("HandleCallback() = %q, %v", notice, err)
+	}
+
+	// Anyone in the chat can subscribe, and pressing again changes the choice
+	remindersHandler.HandleCallback(context.Background(), &CallbackContext{UserID: 1, ChatID: 100, Data: token + ":" + reminderNotifyChoice})
+	remindersHandler.HandleCallback(context.Background(), &CallbackContext{UserID: 1, ChatID: 100, Data: token + ":" + reminderAutoChoice})
+	notice, _ = remindersHandler.HandleCallback(context.Background(), &CallbackContext{UserID: 1, ChatID: 100, Data: token + ":" + reminderNotifyChoice})
+	if !strings.Contains(notice, "remind you") {
+		t.Errorf("Expected a reminder notice, got %q", notice)
+	}
+
+	reminders := handler.GetReminders().List(1)
+	if len(reminders) != 1 {
+		t.Fatalf("Expected one reminder for user 1, got %+v", reminders)
+	}
+	want := ReleaseReminder{ID: reminders[0].ID, UserID: 1, ChatID: 100, SongID: "1700000001", SongURL: releaseTestURL, Title: "Upcoming", Artist: "Artist", ReleaseDate: releaseDate, CreatedAt: now}
+	if reminders[0] != want {
+		t.Errorf("Reminder = %+v, want %+v", reminders[0], want)
+	}
+
+	// Reminders survive a restart
+	restarted, err := NewReleaseReminders(handler.reminders.path)
+	if err != nil {
+		t.Fatalf("NewReleaseReminders() error = %v", err)
+	}
+	if got := restarted.List(2); len(got) != 1 || !got[0].AutoDownload {
+		t.Errorf("Expected the auto-download reminder of user 2 to be saved, got %+v", got)
+	}
+
+	// Offers expire
+	now = now.Add(ReleaseOfferTTL + time.Second)
+	if notice, _ := remindersHandler.HandleCallback(context.Background(), &CallbackContext{UserID: 3, ChatID: 100, Data: token + ":" + reminderNotifyChoice}); !strings.Contains(notice, "expired") {
+		t.Errorf("Expected the offer to have expired, got %q", notice)
+	}
+}
+
+func TestReleaseReminders_LimitAndCancel(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	handler, _ := newReleaseTestHandler(t, &now)
+	reminders := handler.GetReminders()
+
+	for i := 0; i < MaxRemindersPerUser; i++ {
+		token, _ := reminders.Offer(releaseTestURL, downloader.ReleaseStatus{SongID: string(rune('a' + i)), ReleaseDate: now.AddDate(0, 0, MaxRemindersPerUser-i)})
+		if _, err := reminders.Subscribe(token, 1, 100, false); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+	}
+	token, _ := reminders.Offer(releaseTestURL, downloader.ReleaseStatus{SongID: "z"})
+	if _, err := reminders.Subscribe(token, 1, 100, false); err != errReminderLimit {
+		t.Errorf("Subscribe() over the cap error = %v, want %v", err, errReminderLimit)
+	}
+
+	list := reminders.List(1)
+	if list[0].SongID != string(rune('a'+MaxRemindersPerUser-1)) {
+		t.Errorf("Expected the soonest release first, got %s", list[0].SongID)
+	}
+	message := createRemindersListMessage(list)
+	if !strings.Contains(message, "(10/10)") || !strings.Contains(message, "ID "+list[0].ID) {
+		t.Errorf("Unexpected list message %q", message)
+	}
+
+	if _, ok, _ := reminders.Cancel(2, list[0].ID); ok {
+		t.Error("Expected other users not to cancel the reminder")
+	}
+	cancelled, ok, err := reminders.Cancel(1, list[0].ID)
+	if !ok || err != nil || cancelled.SongID != list[0].SongID {
+		t.Fatalf("Cancel() = %+v, %t, %v", cancelled, ok, err)
+	}
+
+	restarted, _ := NewReleaseReminders(reminders.path)
+	if got := restarted.List(1); len(got) != MaxRemindersPerUser-1 {
+		t.Errorf("Expected the cancellation to be saved, got %d reminders", len(got))
+	}
+	if _, err := reminders.Subscribe(token, 1, 100, false); err != nil {
+		t.Errorf("Expected room for a new reminder after cancelling, got %v", err)
+	}
+}
+
+func TestSongHandler_CheckReleases(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	releaseDate := time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)
+	handler, api := newReleaseTestHandler(t, &now)
+
+	processed := make(chan *CommandContext, 1)
+	queue := handler.GetQueue()
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		processed <- cmdCtx
+		return nil
+	}
+	queue.requestDelay = 0
+
+	token := offerTestReminder(t, handler, releaseDate)
+	notify, _ := handler.reminders.Subscribe(token, 1, 100, false)
+	auto, _ := handler.reminders.Subscribe(token, 2, 200, true)
+
+	songDownloader := &releasingDownloader{status: downloader.ReleaseStatus{SongID: "1700000001", Title: "Upcoming", Artist: "Artist", ReleaseDate: releaseDate}}
+	handler.downloader = songDownloader
+
+	// Nothing is checked before the release date
+	handler.checkReleases(context.Background())
+	if len(songDownloader.checked) != 0 {
+		t.Fatalf("Expected no catalog lookups before the release date, got %v", songDownloader.checked)
+	}
+
+	// Still a pre-release on the day: wait
+	now = releaseDate.Add(time.Hour)
+	handler.checkReleases(context.Background())
+	if len(songDownloader.checked) != 1 || len(api.sends) != 0 || len(handler.reminders.List(1)) != 1 {
+		t.Fatalf("Expected one lookup for both reminders and no message, got %d lookups and %d messages", len(songDownloader.checked), len(api.sends))
+	}
+
+	// Out with ALAC: announce to one, queue for the other
+	songDownloader.status.Released, songDownloader.status.ALAC = true, true
+	handler.checkReleases(context.Background())
+
+	if len(api.sends) != 2 {
+		t.Fatalf("Expected two announcements, got %d", len(api.sends))
+	}
+	for _, send := range api.sends {
+		switch peer := send.Peer.(*tg.InputPeerUser); peer.UserID {
+		case 100:
+			if !strings.Contains(send.Message, "is out! Use /song "+releaseTestURL) {
+				t.Errorf("Unexpected announcement %q", send.Message)
+			}
+		case 200:
+			if !strings.Contains(send.Message, "queued for you") {
+				t.Errorf("Unexpected auto-download announcement %q", send.Message)
+			}
+		}
+	}
+
+	select {
+	case cmdCtx := <-processed:
+		if cmdCtx.Args != releaseTestURL || cmdCtx.UserID != 2 || cmdCtx.ChatID != 200 || cmdCtx.RequestID != "reminder:"+auto.ID {
+			t.Errorf("Queued %+v, want %s for user 2 in chat 200", cmdCtx, releaseTestURL)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the released song to be queued")
+	}
+
+	if len(handler.reminders.List(1))+len(handler.reminders.List(2)) != 0 {
+		t.Errorf("Expected reminders %s and %s to be removed", notify.ID, auto.ID)
+	}
+}
+
+func TestSongHandler_CheckReleases_MovedAndAbandoned(t *testing.T) {
+	now := time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC)
+	releaseDate := time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)
+	handler, api := newReleaseTestHandler(t, &now)
+
+	token, _ := handler.reminders.Offer(releaseTestURL, downloader.ReleaseStatus{SongID: "1700000001", ReleaseDate: releaseDate})
+	handler.reminders.Subscribe(token, 1, 100, true)
+
+	moved := releaseDate.AddDate(0, 1, 0)
+	songDownloader := &releasingDownloader{status: downloader.ReleaseStatus{SongID: "1700000001", ReleaseDate: moved}}
+	handler.downloader = songDownloader
+
+	handler.checkReleases(context.Background())
+	if got := handler.reminders.List(1); len(got) != 1 || !got[0].ReleaseDate.Equal(moved) {
+		t.Fatalf("Expected the reminder to follow the new release date, got %+v", got)
+	}
+
+	now = moved.Add(ReleaseGiveUpAfter)
+	handler.checkReleases(context.Background())
+	if len(handler.reminders.List(1)) != 0 || len(api.sends) != 1 || !strings.Contains(api.sends[0].Message, "stopped waiting") {
+		t.Errorf("Expected the overdue reminder to be dropped with a notice, got %d messages", len(api.sends))
+	}
+}
+
+func TestSongHandler_CheckReleases_NoReleaseDate(t *testing.T) {
+	now := time.Date(2026, 10, 10, 0, 0, 0, 0, time.UTC)
+	handler, api := newReleaseTestHandler(t, &now)
+
+	token, _ := handler.reminders.Offer(releaseTestURL, downloader.ReleaseStatus{SongID: "1700000001"})
+	handler.reminders.Subscribe(token, 1, 100, false)
+
+	songDownloader := &releasingDownloader{status: downloader.ReleaseStatus{SongID: "1700000001"}}
+	handler.downloader = songDownloader
+
+	// Checked right away, but kept while the song is not out
+	handler.checkReleases(context.Background())
+	if len(songDownloader.checked) != 1 || len(handler.reminders.List(1)) != 1 || len(api.sends) != 0 {
+		t.Fatalf("Expected the reminder to be checked and kept, got %d lookups and %d messages", len(songDownloader.checked), len(api.sends))
+	}
+
+	// Given up on counting from the subscription
+	now = now.Add(ReleaseGiveUpAfter)
+	handler.checkReleases(context.Background())
+	if len(handler.reminders.List(1)) != 0 || len(api.sends) != 1 {
+		t.Errorf("Expected the reminder to be dropped after %v, got %d messages", ReleaseGiveUpAfter, len(api.sends))
+	}
+}
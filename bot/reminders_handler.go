@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// RemindersHandler implements CommandHandler for the /reminders command, and
+// CallbackHandler for the buttons of not-released messages
+type RemindersHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewRemindersHandler creates a new RemindersHandler instance
+func NewRemindersHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *RemindersHandler {
+	handler := &RemindersHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *RemindersHandler) Command() string {
+	return "reminders"
+}
+
+// Handle processes the /reminders command, listing the caller's release
+// reminders or cancelling one with "/reminders cancel <id>"
+func (h *RemindersHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /reminders command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	reminders := h.songHandler.GetReminders()
+
+	fields := strings.Fields(cmdCtx.Args)
+	switch {
+	case len(fields) == 0:
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createRemindersListMessage(reminders.List(cmdCtx.UserID)))
+	case len(fields) == 2 && strings.EqualFold(fields[0], "cancel"):
+		reminder, ok, err := reminders.Cancel(cmdCtx.UserID, fields[1])
+		if err != nil {
+			h.logger.Error("Failed to save release reminders", logging.Err(err))
+		}
+		if !ok {
+			return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, fmt.Sprintf("❌ You have no reminder with ID %s. Use /reminders to list them.", fields[1]))
+		}
+		h.logger.Info("Cancelled release reminder", logging.Int64("User", cmdCtx.UserID), logging.String("Reminder", reminder.ID), logging.String("Song", reminder.SongID))
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, fmt.Sprintf("✅ Cancelled the reminder for %s.", songLabel(reminder.Title, reminder.Artist, reminder.SongID)))
+	default:
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ Usage: /reminders or /reminders cancel <id>")
+	}
+}
+
+// createRemindersListMessage creates the list shown by /reminders
+func createRemindersListMessage(reminders []ReleaseReminder) string {
+	if len(reminders) == 0 {
+		return "🔕 You have no release reminders. Songs that aren't out yet offer one when you send them with /song."
+	}
+
+	message := fmt.Sprintf("🔔 **Release Reminders (%d/%d):**\n\n", len(reminders), MaxRemindersPerUser)
+	for i, reminder := range reminders {
+		message += formatReminder(i+1, reminder) + "\n"
+	}
+	message += "\n💡 Use `/reminders cancel <id>` to cancel one"
+
+	return message
+}
+
+// CallbackPrefix returns the data prefix of the reminder buttons
+func (h *RemindersHandler) CallbackPrefix() string {
+	return reminderCallbackPrefix
+}
+
+// HandleCallback subscribes whoever pressed a reminder button to the release
+// of the song, downloading it on release for the download button
+func (h *RemindersHandler) HandleCallback(ctx context.Context, cbCtx *CallbackContext) (string, error) {
+	token, choice, _ := strings.Cut(cbCtx.Data, ":")
+	if choice != reminderNotifyChoice && choice != reminderAutoChoice {
+		return "", fmt.Errorf("invalid reminder choice %q", choice)
+	}
+
+	reminder, err := h.songHandler.GetReminders().Subscribe(token, cbCtx.UserID, cbCtx.ChatID, choice == reminderAutoChoice)
+	switch {
+	case errors.Is(err, errReminderOfferExpired):
+		return "⌛ This offer has expired. Send the link again.", nil
+	case errors.Is(err, errReminderLimit):
+		return fmt.Sprintf("You already have %d reminders. Cancel one with /reminders first.", MaxRemindersPerUser), nil
+	case err != nil && reminder.ID == "":
+		return "", fmt.Errorf("failed to create release reminder: %w", err)
+	case err != nil:
+		// The reminder works until the next restart
+		h.logger.Error("Failed to save release reminders", logging.Err(err))
+	}
+
+	h.logger.Info("Subscribed to song release", logging.Int64("User", cbCtx.UserID),
+		logging.String("Song", reminder.SongID), logging.String("Reminder", reminder.ID), logging.Bool("AutoDownload", reminder.AutoDownload))
+
+	if reminder.AutoDownload {
+		return fmt.Sprintf("⬇️ I'll download it for you when it comes out on %s.", formatReleaseDate(reminder.ReleaseDate)), nil
+	}
+	return fmt.Sprintf("🔔 I'll remind you when it comes out on %s.", formatReleaseDate(reminder.ReleaseDate)), nil
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// maxRetagFilesListed caps the changed and skipped files named in the summary
+const maxRetagFilesListed = 10
+
+// RetagHandler implements CommandHandler for the admin /retag command
+type RetagHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+
+	mu      sync.Mutex
+	running bool
+}
+
+// NewRetagHandler creates a new RetagHandler instance
+func NewRetagHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *RetagHandler {
+	handler := &RetagHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *RetagHandler) Command() string {
+	return "retag"
+}
+
+// AdminOnly reports that only administrators may run /retag
+func (h *RetagHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /retag command. Retagging fetches metadata for every
+// file, so it runs in the background and reports a summary when done
+func (h *RetagHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /retag command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	dir := strings.TrimSpace(cmdCtx.Args)
+	if dir == "" {
+		dir = h.songHandler.manager.DownloadDir()
+	}
+
+	h.mu.Lock()
+	if h.running {
+		h.mu.Unlock()
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "⏳ A retag is already running.")
+	}
+	h.running = true
+	h.mu.Unlock()
+
+	go h.retag(cmdCtx.ChatID, dir)
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, fmt.Sprintf("🏷️ Retagging files in `%s`...", dir))
+}
+
+// retag retags dir and sends the summary to chatID
+func (h *RetagHandler) retag(chatID int64, dir string) {
+	defer func() {
+		h.mu.Lock()
+		h.running = false
+		h.mu.Unlock()
+	}()
+
+	startTime := time.Now()
+	summary, err := h.songHandler.manager.RetagDir(context.Background(), dir, h.songHandler.defaultStorefront)
+
+	var message string
+	if err != nil {
+		h.logger.Error("Retag failed", logging.String("Dir", dir), logging.Err(err))
+		message = fmt.Sprintf("❌ Retag failed: %v", err)
+		if summary != nil {
+			message += "\n\n" + createRetagMessage(summary, dir, time.Since(startTime))
+		}
+	} else {
+		h.logger.Info("Retagged files", logging.String("Dir", dir), logging.Int("Changed", len(summary.Changed)),
+			logging.Int("Unchanged", summary.Unchanged), logging.Int("Skipped", len(summary.Skipped)), logging.Int("Failed", len(summary.Failed)))
+		message = createRetagMessage(summary, dir, time.Since(startTime))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.sender.SendMarkdown(ctx, chatID, message); err != nil {
+		h.logger.Error("Failed to send retag summary", logging.Err(err))
+	}
+}
+
+// createRetagMessage creates the summary sent when a retag finishes
+func createRetagMessage(summary *downloader.RetagSummary, dir string, duration time.Duration) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "🏷️ **Retag of `%s` finished** in %s\n\n", dir, duration.Round(time.Second))
+	fmt.Fprintf(&message, "✏️ Changed: %d\n", len(summary.Changed))
+	fmt.Fprintf(&message, "✅ Already current: %d\n", summary.Unchanged)
+	fmt.Fprintf(&message, "⏭️ Skipped (not written by the bot): %d\n", len(summary.Skipped))
+	fmt.Fprintf(&message, "❌ Failed: %d\n", len(summary.Failed))
+
+	writeFiles := func(title string, paths []string) {
+		if len(paths) == 0 {
+			return
+		}
+		fmt.Fprintf(&message, "\n%s:\n", title)
+		for i, path := range paths {
+			if i == maxRetagFilesListed {
+				fmt.Fprintf(&message, "…and %d more\n", len(paths)-maxRetagFilesListed)
+				break
+			}
+			fmt.Fprintf(&message, "• %s\n", filepath.Base(path))
+		}
+	}
+	writeFiles("Changed", summary.Changed)
+	writeFiles("Failed", summary.Failed)
+
+	return message.String()
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestRetagHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewRetagHandler(nil, logger, nil)
+
+	expected := "retag"
+	if got := handler.Command(); got != expected {
+		t.Errorf("RetagHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateRetagMessage(t *testing.T) {
+	var changed []string
+	for i := 0; i < maxRetagFilesListed+2; i++ {
+		changed = append(changed, fmt.Sprintf("downloads/Song %d - Artist.m4a", i))
+	}
+	summary := &downloader.RetagSummary{
+		Changed:   changed,
+		Unchanged: 3,
+		Skipped:   []string{"downloads/other.m4a"},
+		Failed:    []string{"downloads/broken.m4a"},
+	}
+
+	message := createRetagMessage(summary, "downloads", 90*time.Second)
+
+	for _, want := range []string{
+		"`downloads`", "1m30s",
+		fmt.Sprintf("Changed: %d", len(changed)),
+		"Already current: 3",
+		"Skipped (not written by the bot): 1",
+		"Failed: 1",
+		"• Song 0 - Artist.m4a",
+		"…and 2 more",
+		"• broken.m4a",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Expected message to contain %q, got %q", want, message)
+		}
+	}
+	if strings.Contains(message, "downloads/Song") {
+		t.Error("Expected file names without their directory")
+	}
+	if strings.Contains(message, fmt.Sprintf("Song %d - Artist", maxRetagFilesListed)) {
+		t.Error("Expected the changed list to be capped")
+	}
+}
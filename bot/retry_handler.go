@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// RetryHandler implements CommandHandler for the /retry command
+type RetryHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewRetryHandler creates a new RetryHandler instance
+func NewRetryHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *RetryHandler {
+	handler := &RetryHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *RetryHandler) Command() string {
+	return "retry"
+}
+
+// Handle processes the /retry command and re-enqueues a failed request
+func (h *RetryHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /retry command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	message, err := h.retry(cmdCtx)
+	if err != nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error())
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, message)
+}
+
+// retry takes the selected failed request, checks its URL again and puts it
+// back in the queue. The returned error is meant for the user
+func (h *RetryHandler) retry(cmdCtx *CommandContext) (string, error) {
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return "", fmt.Errorf("Queue system is not available.")
+	}
+
+	n := 1
+	if arg := strings.TrimSpace(cmdCtx.Args); arg != "" {
+		parsed, err := strconv.Atoi(arg)
+		if err != nil || parsed < 1 {
+			return "", fmt.Errorf("Usage: /retry or /retry <n> (see /failed for the numbers)")
+		}
+		n = parsed
+	}
+
+	entry, err := queue.Failed().Take(cmdCtx.ChatID, cmdCtx.UserID, n)
+	if err != nil {
+		return "", fmt.Errorf("Cannot retry: %v.", err)
+	}
+
+	request, err := h.songHandler.requeueFailed(entry, DownloadOverride{})
+	if err != nil {
+		return "", err
+	}
+
+	h.logger.Info("Retried request", logging.Int64("User", cmdCtx.UserID), logging.String("Request", request.UniqueID),
+		logging.String("Correlation", request.CorrelationID), logging.Int("Attempt", request.Attempt))
+
+	position := queue.GetQueuePosition(request.UniqueID)
+	if position > 0 {
+		return fmt.Sprintf("🔁 Retrying %s (queue position %d)", request.URL, position), nil
+	}
+	return fmt.Sprintf("🔁 Retrying %s", request.URL), nil
+}
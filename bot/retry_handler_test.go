@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+// newRetryTestHandler creates a retry handler whose queue never starts processing
+func newRetryTestHandler() (*RetryHandler, *SongQueue, chan struct{}) {
+	logger := logging.Discard()
+	songHandler := NewSongHandler(nil, logger)
+
+	release := make(chan struct{})
+	queue := songHandler.GetQueue()
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		<-release
+		return nil
+	}
+	queue.requestDelay = 0
+
+	return NewRetryHandler(nil, logger, songHandler), queue, release
+}
+
+func TestRetryHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewRetryHandler(nil, logger, NewSongHandler(nil, logger))
+
+	expected := "retry"
+	if got := handler.Command(); got != expected {
+		t.Errorf("RetryHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestRetryHandler_RetryMostRecent(t *testing.T) {
+	handler, queue, release := newRetryTestHandler()
+	defer close(release)
+
+	first := newFailedTestRequest(1, 100, 1)
+	first.URL = "https://music.apple.com/in/song/first/111"
+	first.CorrelationID = first.UniqueID
+	first.Attempt = 1
+	second := newFailedTestRequest(1, 100, 2)
+	second.URL = "https://music.apple.com/in/song/second/222"
+	second.CorrelationID = second.UniqueID
+	second.Attempt = 1
+
+	queue.Failed().Record(first, "download failed")
+	queue.Failed().Record(second, "upload failed")
+
+	message, err := handler.retry(&CommandContext{UserID: 1, ChatID: 100})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(message, second.URL) {
+		t.Errorf("Expected the most recent failure to be retried, got %q", message)
+	}
+
+	if remaining := queue.Failed().List(100, 1); len(remaining) != 1 || remaining[0].Request != first {
+		t.Errorf("Expected the retried entry to be cleared")
+	}
+}
+
+func TestRetryHandler_RetryNumbered(t *testing.T) {
+	handler, queue, release := newRetryTestHandler()
+	defer close(release)
+
+	for i := 1; i <= 3; i++ {
+		request := newFailedTestRequest(1, 100, i)
+		request.CorrelationID = "corr-" + request.UniqueID
+		request.Attempt = i
+		queue.Failed().Record(request, "download failed")
+	}
+
+	// Entry #3 is the oldest failure, message 1
+	if _, err := handler.retry(&CommandContext{UserID: 1, ChatID: 100, Args: "3"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	requests := append(queue.GetProcessing(), queue.GetQueueInfo()...)
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 re-queued request, got %d", len(requests))
+	}
+
+	requeued := requests[0]
+	if requeued.MessageID != 1 {
+		t.Errorf("Expected message 1 to be retried, got %d", requeued.MessageID)
+	}
+	if requeued.CorrelationID != "corr-"+requeued.UniqueID {
+		t.Errorf("Expected original correlation ID, got %q", requeued.CorrelationID)
+	}
+	if requeued.Attempt != 2 {
+		t.Errorf("Expected attempt 2, got %d", requeued.Attempt)
+	}
+
+	if _, err := handler.retry(&CommandContext{UserID: 1, ChatID: 100, Args: "5"}); err == nil {
+		t.Error("Expected error for an out of range entry")
+	}
+	if _, err := handler.retry(&CommandContext{UserID: 1, ChatID: 100, Args: "abc"}); err == nil {
+		t.Error("Expected usage error for a non-numeric argument")
+	}
+}
+
+func TestRetryHandler_PreflightFailure(t *testing.T) {
+	handler, queue, release := newRetryTestHandler()
+	defer close(release)
+
+	request := newFailedTestRequest(1, 100, 1)
+	request.URL = "https://music.apple.com/in/song/test/123"
+	request.OriginalText = "/song https://open.spotify.com/track/123"
+	queue.Failed().Record(request, "download failed")
+
+	_, err := handler.retry(&CommandContext{UserID: 1, ChatID: 100})
+	if err == nil {
+		t.Fatal("Expected the pre-flight check to fail")
+	}
+	if !strings.Contains(err.Error(), "not a supported Apple Music URL") {
+		t.Errorf("Expected the new pre-flight error, got %q", err.Error())
+	}
+
+	if size := queue.GetQueueSize(); size != 0 || queue.IsProcessing() {
+		t.Error("Expected nothing to be re-queued")
+	}
+
+	entries := queue.Failed().List(100, 1)
+	if len(entries) != 1 || !strings.Contains(entries[0].Reason, "not a supported Apple Music URL") {
+		t.Errorf("Expected the failed entry to carry the new reason, got %v", entries)
+	}
+}
@@ -3,22 +3,28 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
+	"go-alac-bot/logging"
+
 	"github.com/gotd/td/tg"
 )
 
+// AdminOnlyMessage is the reply to users who run an admin-only command
+const AdminOnlyMessage = "🔒 Admin only"
+
 // CommandRouter handles routing of commands to their respective handlers
 type CommandRouter struct {
 	handlers     map[string]CommandHandler
-	logger       *log.Logger
+	logger       logging.Logger
 	errorHandler *ErrorHandler
+	isAdmin      func(userID int64) bool
+	reply        func(ctx context.Context, chatID int64, text string) error
 }
 
 // NewCommandRouter creates a new command router instance
-func NewCommandRouter(logger *log.Logger) *CommandRouter {
+func NewCommandRouter(logger logging.Logger) *CommandRouter {
 	return &CommandRouter{
 		handlers: make(map[string]CommandHandler),
 		logger:   logger,
@@ -30,21 +36,49 @@ func (r *CommandRouter) SetErrorHandler(errorHandler *ErrorHandler) {
 	r.errorHandler = errorHandler
 }
 
+// SetAdminCheck sets how admin-only commands are authorized and how refused
+// users are told. Until it is set, admin-only commands are refused to everyone
+func (r *CommandRouter) SetAdminCheck(isAdmin func(userID int64) bool, reply func(ctx context.Context, chatID int64, text string) error) {
+	r.isAdmin = isAdmin
+	r.reply = reply
+}
+
+// IsAuthorized reports whether the user may run the handler's command
+func (r *CommandRouter) IsAuthorized(handler CommandHandler, userID int64) bool {
+	if admin, ok := handler.(AdminCommand); !ok || !admin.AdminOnly() {
+		return true
+	}
+	return r.isAdmin != nil && r.isAdmin(userID)
+}
+
 // RegisterHandler registers a command handler for a specific command
 func (r *CommandRouter) RegisterHandler(handler CommandHandler) {
 	command := handler.Command()
 	r.handlers[command] = handler
-	r.logger.Printf("Registered handler for command: /%s", command)
+	r.logger.Debug("Registered command handler", logging.String("Command", "/"+command))
 }
 
 // RouteCommand processes an incoming message and routes it to the appropriate handler
 func (r *CommandRouter) RouteCommand(ctx context.Context, update *tg.UpdateNewMessage) error {
+	return r.RouteCommandFrom(ctx, update, nil)
+}
+
+// RouteCommandFrom routes a message like RouteCommand, filling in the sender's
+// profile (name, language) when the sender's user object is available
+func (r *CommandRouter) RouteCommandFrom(ctx context.Context, update *tg.UpdateNewMessage, sender *tg.User) error {
 	// Extract command context from the update
 	cmdCtx, err := r.extractCommandContext(update)
 	if err != nil {
 		return fmt.Errorf("failed to extract command context: %w", err)
 	}
 
+	if sender != nil && cmdCtx.Command != "" {
+		cmdCtx.Username = sender.Username
+		cmdCtx.FirstName = sender.FirstName
+		cmdCtx.LastName = sender.LastName
+		cmdCtx.LanguageCode = sender.LangCode
+	}
+
 	// Skip if not a command
 	if cmdCtx.Command == "" {
 		return nil
@@ -53,13 +87,25 @@ func (r *CommandRouter) RouteCommand(ctx context.Context, update *tg.UpdateNewMe
 	// Find the appropriate handler
 	handler, exists := r.handlers[cmdCtx.Command]
 	if !exists {
-		r.logger.Printf("No handler found for command: /%s", cmdCtx.Command)
+		r.logger.Debug("No handler found for command", logging.String("Command", "/"+cmdCtx.Command))
 		return nil // Not an error, just no handler available
 	}
 
+	if !r.IsAuthorized(handler, cmdCtx.UserID) {
+		r.logger.Warn("Refused admin-only command", logging.String("Command", "/"+cmdCtx.Command),
+			logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+		if r.reply == nil {
+			return nil
+		}
+		if err := r.reply(ctx, cmdCtx.ChatID, AdminOnlyMessage); err != nil {
+			return fmt.Errorf("failed to refuse command /%s: %w", cmdCtx.Command, err)
+		}
+		return nil
+	}
+
 	// Execute the handler with panic recovery
-	r.logger.Printf("Routing command /%s to handler (user: %d, chat: %d)",
-		cmdCtx.Command, cmdCtx.UserID, cmdCtx.ChatID)
+	r.logger.Info("Routing command", logging.String("Command", "/"+cmdCtx.Command),
+		logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 
 	// Add panic recovery for command handlers
 	defer func() {
@@ -117,16 +163,8 @@ func (r *CommandRouter) extractCommandContext(update *tg.UpdateNewMessage) (*Com
 		userID = fromUser.UserID
 	}
 
-	// Extract chat ID
-	var chatID int64
-	switch peer := message.PeerID.(type) {
-	case *tg.PeerUser:
-		chatID = peer.UserID
-	case *tg.PeerChat:
-		chatID = peer.ChatID
-	case *tg.PeerChannel:
-		chatID = peer.ChannelID
-	}
+	// Extract chat ID, negative for groups and channels
+	chatID := ChatIDFromPeer(message.PeerID)
 
 	// Extract reply message ID if this is a reply
 	var replyToMessageID int32
@@ -146,6 +184,8 @@ func (r *CommandRouter) extractCommandContext(update *tg.UpdateNewMessage) (*Com
 		LastName:         lastName,
 		Command:          command,
 		Args:             args,
+		MessageText:      messageText,
+		Entities:         message.Entities,
 		ReplyToMessageID: replyToMessageID,
 		Timestamp:        time.Now(),
 	}, nil
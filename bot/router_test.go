@@ -2,11 +2,13 @@ package bot
 
 import (
 	"context"
-	"log"
 	"os"
 	"testing"
 
 	"github.com/gotd/td/tg"
+
+	"go-alac-bot/config"
+	"go-alac-bot/logging"
 )
 
 // MockCommandHandler is a test implementation of CommandHandler
@@ -27,7 +29,7 @@ func (m *MockCommandHandler) Command() string {
 }
 
 func TestCommandRouter_RegisterHandler(t *testing.T) {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	router := NewCommandRouter(logger)
 
 	handler := &MockCommandHandler{command: "test"}
@@ -44,7 +46,7 @@ func TestCommandRouter_RegisterHandler(t *testing.T) {
 }
 
 func TestCommandRouter_ExtractCommandContext(t *testing.T) {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	router := NewCommandRouter(logger)
 
 	// Create a mock update with a command message
@@ -81,7 +83,7 @@ func TestCommandRouter_ExtractCommandContext(t *testing.T) {
 }
 
 func TestCommandRouter_RouteCommand(t *testing.T) {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	router := NewCommandRouter(logger)
 
 	handler := &MockCommandHandler{command: "ping"}
@@ -114,7 +116,7 @@ func TestCommandRouter_RouteCommand(t *testing.T) {
 }
 
 func TestCommandRouter_NonCommand(t *testing.T) {
-	logger := log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	router := NewCommandRouter(logger)
 
 	handler := &MockCommandHandler{command: "test"}
@@ -141,4 +143,88 @@ func TestCommandRouter_NonCommand(t *testing.T) {
 	if handler.handleCalls != 0 {
 		t.Errorf("Expected handler not to be called for non-command, got: %d calls", handler.handleCalls)
 	}
-}
\ No newline at end of file
+}
+
+// mockAdminHandler is a MockCommandHandler that only administrators may run
+type mockAdminHandler struct {
+	MockCommandHandler
+}
+
+func (m *mockAdminHandler) AdminOnly() bool {
+	return true
+}
+
+// adminCommandUpdate creates an update with /admin sent by userID
+func adminCommandUpdate(userID int64) *tg.UpdateNewMessage {
+	return &tg.UpdateNewMessage{
+		Message: &tg.Message{
+			Message: "/admin",
+			PeerID:  &tg.PeerUser{UserID: userID},
+			FromID:  &tg.PeerUser{UserID: userID},
+		},
+	}
+}
+
+func TestCommandRouter_AdminOnly(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+
+	testCases := []struct {
+		name       string
+		adminIDs   []int64
+		userID     int64
+		wantCalled bool
+	}{
+		{name: "authorized", adminIDs: []int64{1, 2}, userID: 2, wantCalled: true},
+		{name: "unauthorized", adminIDs: []int64{1, 2}, userID: 3, wantCalled: false},
+		{name: "no admins configured", adminIDs: nil, userID: 1, wantCalled: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.BotConfig{AdminIDs: tc.adminIDs}
+			router := NewCommandRouter(logger)
+			var replies []string
+			router.SetAdminCheck(cfg.IsAdmin, func(ctx context.Context, chatID int64, text string) error {
+				replies = append(replies, text)
+				return nil
+			})
+
+			handler := &mockAdminHandler{MockCommandHandler{command: "admin"}}
+			router.RegisterHandler(handler)
+
+			if err := router.RouteCommand(context.Background(), adminCommandUpdate(tc.userID)); err != nil {
+				t.Fatalf("Failed to route command: %v", err)
+			}
+
+			if called := handler.handleCalls == 1; called != tc.wantCalled {
+				t.Errorf("Expected handler called = %v, got %d calls", tc.wantCalled, handler.handleCalls)
+			}
+			if tc.wantCalled && len(replies) != 0 {
+				t.Errorf("Expected no refusal, got %v", replies)
+			}
+			if !tc.wantCalled && (len(replies) != 1 || replies[0] != AdminOnlyMessage) {
+				t.Errorf("Expected the %q reply, got %v", AdminOnlyMessage, replies)
+			}
+		})
+	}
+}
+
+func TestCommandRouter_AdminOnlyWithoutCheck(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	router := NewCommandRouter(logger)
+
+	admin := &mockAdminHandler{MockCommandHandler{command: "admin"}}
+	router.RegisterHandler(admin)
+
+	if err := router.RouteCommand(context.Background(), adminCommandUpdate(1)); err != nil {
+		t.Fatalf("Failed to route command: %v", err)
+	}
+	if admin.handleCalls != 0 {
+		t.Error("Expected admin-only commands to be refused without an admin check")
+	}
+
+	// Other commands are not affected
+	if !router.IsAuthorized(&MockCommandHandler{command: "ping"}, 1) {
+		t.Error("Expected commands that are not admin-only to be authorized")
+	}
+}
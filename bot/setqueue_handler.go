@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// SetQueueHandler implements CommandHandler for the admin /setqueue command
+type SetQueueHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewSetQueueHandler creates a new SetQueueHandler instance
+func NewSetQueueHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *SetQueueHandler {
+	handler := &SetQueueHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *SetQueueHandler) Command() string {
+	return "setqueue"
+}
+
+// AdminOnly reports that only administrators may run /setqueue
+func (h *SetQueueHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /setqueue command and adjusts the queue limits
+func (h *SetQueueHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /setqueue command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	queue := h.songHandler.GetQueue()
+	if queue == nil {
+		return h.sendErrorMessage(timeoutCtx, cmdCtx.ChatID, "Queue system is not available.")
+	}
+
+	size, workers, err := parseSetQueueArgs(cmdCtx.Args, queue.MaxSize(), queue.Workers())
+	if err != nil {
+		return h.sendErrorMessage(timeoutCtx, cmdCtx.ChatID, err.Error())
+	}
+
+	if err := queue.SetLimits(size, workers); err != nil {
+		h.logger.Error("Failed to set queue limits", logging.Err(err))
+		return h.sendErrorMessage(timeoutCtx, cmdCtx.ChatID, fmt.Sprintf("Failed to update queue limits: %v", err))
+	}
+
+	h.logger.Info("Set queue limits", logging.Int64("User", cmdCtx.UserID), logging.Int("Size", size), logging.Int("Workers", workers))
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createSetQueueMessage(size, workers, queue.GetQueueSize()))
+}
+
+// parseSetQueueArgs parses "size=<n> workers=<m>" arguments. Omitted values keep
+// their current setting
+func parseSetQueueArgs(args string, currentSize, currentWorkers int) (int, int, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("Usage: /setqueue size=<%d-%d> workers=<%d-%d>",
+			MinQueueLimit, MaxQueueLimit, MinQueueWorkers, MaxQueueWorkers)
+	}
+
+	size, workers := currentSize, currentWorkers
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return 0, 0, fmt.Errorf("Invalid argument %q, expected key=value", field)
+		}
+
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid value for %s: %q is not a number", key, value)
+		}
+
+		switch strings.ToLower(key) {
+		case "size":
+			size = number
+		case "workers":
+			workers = number
+		default:
+			return 0, 0, fmt.Errorf("Unknown setting %q, expected size or workers", key)
+		}
+	}
+
+	if err := ValidateQueueLimits(size, workers); err != nil {
+		return 0, 0, fmt.Errorf("Invalid queue limits: %v", err)
+	}
+
+	return size, workers, nil
+}
+
+// createSetQueueMessage creates the confirmation message for updated limits
+func createSetQueueMessage(size, workers, queued int) string {
+	message := fmt.Sprintf("✅ Queue limits updated: size %d, workers %d", size, workers)
+	if queued >= size {
+		message += fmt.Sprintf("\n\n⚠️ %d requests are already queued. New requests are blocked until the queue drains below %d.", queued, size)
+	}
+	return message
+}
+
+// sendErrorMessage sends an error message to the user
+func (h *SetQueueHandler) sendErrorMessage(ctx context.Context, chatID int64, errorMsg string) error {
+	return h.sender.SendMarkdown(ctx, chatID, "❌ "+errorMsg)
+}
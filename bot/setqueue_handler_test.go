@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/config"
+	"go-alac-bot/logging"
+)
+
+func TestSetQueueHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSetQueueHandler(nil, logger, NewSongHandler(nil, logger))
+
+	expected := "setqueue"
+	if got := handler.Command(); got != expected {
+		t.Errorf("SetQueueHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestSetQueueHandler_Handle_NonAdmin(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	cfg := &config.BotConfig{
+		Token:    "test_token",
+		APIID:    12345,
+		APIHash:  "test_hash",
+		AdminIDs: []int64{1},
+	}
+
+	bot, err := NewTelegramBot(cfg, logger)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	songHandler := NewSongHandler(bot, logger)
+	handler := NewSetQueueHandler(bot, logger, songHandler)
+
+	router := bot.GetRouter()
+	router.RegisterHandler(handler)
+	if router.IsAuthorized(handler, 12345) {
+		t.Fatal("Expected non-admins not to be authorized for /setqueue")
+	}
+
+	update := &tg.UpdateNewMessage{
+		Message: &tg.Message{
+			Message: "/setqueue size=20 workers=2",
+			PeerID:  &tg.PeerUser{UserID: 12345},
+			FromID:  &tg.PeerUser{UserID: 12345},
+		},
+	}
+
+	// Sending the rejection fails without a running client, but the limits must stay untouched
+	_ = router.RouteCommand(context.Background(), update)
+
+	if size := songHandler.GetQueue().MaxSize(); size != MaxQueueSize {
+		t.Errorf("Expected queue size to stay %d, got %d", MaxQueueSize, size)
+	}
+	if workers := songHandler.GetQueue().Workers(); workers != MinQueueWorkers {
+		t.Errorf("Expected workers to stay %d, got %d", MinQueueWorkers, workers)
+	}
+}
+
+func TestParseSetQueueArgs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		args            string
+		expectedSize    int
+		expectedWorkers int
+		expectedError   string
+	}{
+		{
+			name:            "both values",
+			args:            "size=20 workers=3",
+			expectedSize:    20,
+			expectedWorkers: 3,
+		},
+		{
+			name:            "size only keeps current workers",
+			args:            "size=10",
+			expectedSize:    10,
+			expectedWorkers: 2,
+		},
+		{
+			name:            "workers only keeps current size",
+			args:            "WORKERS=4",
+			expectedSize:    7,
+			expectedWorkers: 4,
+		},
+		{
+			name:          "no arguments",
+			args:          "",
+			expectedError: "Usage",
+		},
+		{
+			name:          "missing equals sign",
+			args:          "size 10",
+			expectedError: "expected key=value",
+		},
+		{
+			name:          "non-numeric value",
+			args:          "size=big",
+			expectedError: "not a number",
+		},
+		{
+			name:          "unknown setting",
+			args:          "threads=2",
+			expectedError: "Unknown setting",
+		},
+		{
+			name:          "size out of bounds",
+			args:          "size=51",
+			expectedError: "queue size must be between 1 and 50",
+		},
+		{
+			name:          "workers out of bounds",
+			args:          "workers=5",
+			expectedError: "worker count must be between 1 and 4",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			size, workers, err := parseSetQueueArgs(tc.args, 7, 2)
+
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("Expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if size != tc.expectedSize || workers != tc.expectedWorkers {
+				t.Errorf("Got size %d workers %d, want size %d workers %d",
+					size, workers, tc.expectedSize, tc.expectedWorkers)
+			}
+		})
+	}
+}
+
+func TestCreateSetQueueMessage(t *testing.T) {
+	message := createSetQueueMessage(10, 2, 3)
+	if !strings.Contains(message, "size 10, workers 2") {
+		t.Errorf("Expected new limits in message, got %q", message)
+	}
+	if strings.Contains(message, "blocked") {
+		t.Errorf("Did not expect a capacity warning, got %q", message)
+	}
+
+	message = createSetQueueMessage(2, 1, 5)
+	if !strings.Contains(message, "New requests are blocked") {
+		t.Errorf("Expected a capacity warning, got %q", message)
+	}
+}
@@ -0,0 +1,382 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+// SongArchiveFile is the name of the archived songs file inside the data dir
+const SongArchiveFile = "song_archive.json"
+
+// errArchivedMessageGone is returned when an archived message could not be
+// forwarded because it no longer exists
+var errArchivedMessageGone = errors.New("archived message no longer exists")
+
+// ArchivedDocument is one audio message in the archive channel, with the file
+// reference it can be sent again by
+type ArchivedDocument struct {
+	MessageID     int    `json:"message_id"`
+	DocumentID    int64  `json:"document_id"`
+	AccessHash    int64  `json:"access_hash"`
+	FileReference []byte `json:"file_reference"`
+}
+
+// ArchivedSong is a song uploaded to the archive channel, one document per
+// part for split songs
+type ArchivedSong struct {
+	Parts      int                `json:"parts"`
+	Documents  []ArchivedDocument `json:"documents"`
+	ArchivedAt time.Time          `json:"archived_at"`
+
+	// Song and FileSize describe the archived copy for the history of
+	// forwards, once it was delivered whole (nil and 0 until then)
+	Song     *downloader.SongMetadata `json:"song,omitempty"`
+	FileSize int64                    `json:"file_size,omitempty"`
+}
+
+// complete reports whether every part of the song was archived
+func (s *ArchivedSong) complete() bool {
+	return len(s.Documents) == s.Parts
+}
+
+// messageIDs returns the archived messages of the song in part order
+func (s *ArchivedSong) messageIDs() []int {
+	ids := make([]int, len(s.Documents))
+	for i, document := range s.Documents {
+		ids[i] = document.MessageID
+	}
+	return ids
+}
+
+// SongArchive maps Apple Music IDs to their messages in the archive channel,
+// saved to a file in the data dir so repeat requests are forwarded across
+// restarts. AAC copies and copies tagged in another language are kept under
+// keys of their own (see SongHandler.archiveKey)
+type SongArchive struct {
+	mu    sync.Mutex
+	path  string
+	songs map[string]*ArchivedSong
+	now   func() time.Time
+}
+
+// NewSongArchive creates an archive index saved to path, loading the songs
+// saved there. An empty path keeps it in memory only
+func NewSongArchive(path string) (*SongArchive, error) {
+	sa := &SongArchive{
+		path:  path,
+		songs: make(map[string]*ArchivedSong),
+		now:   time.Now,
+	}
+	if path == "" {
+		return sa, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sa, nil
+	}
+	if err != nil {
+		return sa, fmt.Errorf("failed to read song archive: %w", err)
+	}
+	if err := json.Unmarshal(data, &sa.songs); err != nil {
+		return sa, fmt.Errorf("failed to parse song archive: %w", err)
+	}
+	return sa, nil
+}
+
+// Get returns the archived song with an Apple Music ID, when all its parts
+// were archived
+func (sa *SongArchive) Get(songID string) (ArchivedSong, bool) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	song, ok := sa.songs[songID]
+	if !ok || !song.complete() {
+		return ArchivedSong{}, false
+	}
+	return *song, true
+}
+
+// Record adds part (1-based) of parts of a song to the archive. Recording
+// the first part starts the song over
+func (sa *SongArchive) Record(songID string, part, parts int, document ArchivedDocument) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	parts = max(parts, 1)
+	song, ok := sa.songs[songID]
+	if part <= 1 || !ok || song.Parts != parts || len(song.Documents) != part-1 {
+		if part > 1 {
+			// An earlier part is missing, the song cannot be completed
+			delete(sa.songs, songID)
+			return sa.saveLocked()
+		}
+		song = &ArchivedSong{Parts: parts}
+		sa.songs[songID] = song
+	}
+	song.Documents = append(song.Documents, document)
+	song.ArchivedAt = sa.now()
+	return sa.saveLocked()
+}
+
+// Describe records the metadata and size of an archived song
+func (sa *SongArchive) Describe(songID string, meta *downloader.SongMetadata, fileSize int64) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	song, ok := sa.songs[songID]
+	if !ok {
+		return nil
+	}
+	song.Song = meta
+	song.FileSize = fileSize
+	return sa.saveLocked()
+}
+
+// Remove forgets an archived song, e.g. once its message was deleted
+func (sa *SongArchive) Remove(songID string) error {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	if _, ok := sa.songs[songID]; !ok {
+		return nil
+	}
+	delete(sa.songs, songID)
+	return sa.saveLocked()
+}
+
+// saveLocked writes the archive to its file; the caller holds the lock
+func (sa *SongArchive) saveLocked() error {
+	if sa.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(sa.path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(sa.songs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode song archive: %w", err)
+	}
+
+	tmpPath := sa.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write song archive: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, sa.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save song archive: %w", err)
+	}
+
+	return nil
+}
+
+// ArchiveAPI is implemented by Telegram APIs that can send songs to the
+// archive channel and forward them from there
+type ArchiveAPI interface {
+	MessagesSendMedia(ctx context.Context, request *tg.MessagesSendMediaRequest) (tg.UpdatesClass, error)
+	MessagesForwardMessages(ctx context.Context, request *tg.MessagesForwardMessagesRequest) (tg.UpdatesClass, error)
+}
+
+// archiveKey returns the key a song is archived under: its Apple Music ID,
+// followed by the codec and tag language of copies other than ALAC with the
+// default language
+func (h *SongHandler) archiveKey(songID, codec, language string) string {
+	key := songID
+	if codec != "" {
+		key += ":" + codec
+	}
+	if language != h.metadataLanguage {
+		key += ":lang=" + language
+	}
+	return key
+}
+
+// archiveAPI returns the API songs are archived with, nil when there is no
+// archive channel or the API cannot forward
+func (h *SongHandler) archiveAPI() ArchiveAPI {
+	if h.archiveChatID == 0 || h.archive == nil {
+		return nil
+	}
+	api, _ := h.telegramAPI().(ArchiveAPI)
+	return api
+}
+
+// deliverArchived forwards a song that is in the archive channel to the chat
+// of the request, so it need not be downloaded. It reports whether the song
+// was delivered. An archived song whose message was deleted is forgotten and
+// the request goes through the full download
+func (h *SongHandler) deliverArchived(ctx context.Context, cmdCtx *CommandContext, songURL string) bool {
+	api := h.archiveAPI()
+	if api == nil {
+		return false
+	}
+	urlMeta := ExtractURLMeta(songURL)
+	if urlMeta == nil || urlMeta.URLType != "songs" {
+		return false
+	}
+	// Copies in another codec or tag language are archived apart
+	options := h.requestOptions(cmdCtx)
+	key := h.archiveKey(urlMeta.ID, "", options.MetadataLanguage)
+	song, ok := h.archive.Get(key)
+	if !ok && options.AACFallback {
+		key = h.archiveKey(urlMeta.ID, downloader.CodecAAC, options.MetadataLanguage)
+		song, ok = h.archive.Get(key)
+	}
+	if !ok {
+		return false
+	}
+
+	err := h.forwardArchived(ctx, api, cmdCtx.ChatID, song.messageIDs())
+	if err == nil {
+		h.logger.Info("Forwarded archived song", logging.String("Song", urlMeta.ID), logging.Int64("Chat", cmdCtx.ChatID))
+		h.finishStatusMessage(ctx, cmdCtx, "✅ Sent from the archive")
+		h.recordArchivedHistory(cmdCtx, urlMeta.ID, song)
+		return true
+	}
+
+	if errors.Is(err, errArchivedMessageGone) || downloader.IsMessageIDInvalid(err) {
+		h.logger.Info("Archived song was deleted from the archive channel, downloading it again", logging.String("Song", urlMeta.ID))
+		if err := h.archive.Remove(key); err != nil {
+			h.logger.Warn("Failed to remove archived song", logging.Err(err))
+		}
+	} else {
+		h.logger.Warn("Failed to forward archived song, downloading it again", logging.String("Song", urlMeta.ID), logging.Err(err))
+	}
+	return false
+}
+
+// finishStatusMessage replaces the queue position or storefront prompt a
+// request carries with message, for requests delivered without a progress
+// message
+func (h *SongHandler) finishStatusMessage(ctx context.Context, cmdCtx *CommandContext, message string) {
+	messageID := cmdCtx.Override.ProgressMessageID
+	if messageID == 0 {
+		messageID = cmdCtx.StatusMessageID
+	}
+	sender := h.messages()
+	if messageID == 0 || sender == nil {
+		return
+	}
+	if err := sender.EditMessage(ctx, cmdCtx.ChatID, messageID, message); err != nil {
+		h.logger.Debug("Failed to finish status message", logging.Int("Message", messageID), logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
+	}
+}
+
+// forwardArchived copies messages of the archive channel to a chat, without
+// the "forwarded from" header
+func (h *SongHandler) forwardArchived(ctx context.Context, api ArchiveAPI, chatID int64, messageIDs []int) error {
+	randomIDs := make([]int64, len(messageIDs))
+	for i := range randomIDs {
+		randomIDs[i] = time.Now().UnixNano() + int64(i)
+	}
+
+	updates, err := api.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+		FromPeer:   h.client.InputPeer(h.archiveChatID),
+		ID:         messageIDs,
+		RandomID:   randomIDs,
+		ToPeer:     h.client.InputPeer(chatID),
+		DropAuthor: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to forward archived song: %w", err)
+	}
+
+	// Deleted messages are skipped without an error
+	if len(downloader.SentMessages(updates)) < len(messageIDs) {
+		return errArchivedMessageGone
+	}
+	return nil
+}
+
+// sendThroughArchive sends an uploaded song to the archive channel, records
+// it and forwards it to the chat. When the archive cannot be used the song is
+// sent to the chat directly, replying to message replyTo. Forwarded copies
+// are not replies
+func (h *SongHandler) sendThroughArchive(ctx context.Context, api ArchiveAPI, chatID int64, replyTo int, result *downloader.DownloadResult, media *tg.InputMediaUploadedDocument, caption string) error {
+	updates, err := api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer:     h.client.InputPeer(h.archiveChatID),
+		Media:    media,
+		Message:  createUploadCaption(result, ""),
+		RandomID: time.Now().UnixNano(),
+	})
+	var document *ArchivedDocument
+	if err == nil {
+		document = archivedDocument(updates)
+	}
+	if document == nil {
+		h.logger.Warn("Could not archive song, sending it directly", logging.Err(err))
+		return downloader.SendReplying(replyTo, func(replyTo tg.InputReplyToClass) error {
+			_, err := api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+				Peer:     h.client.InputPeer(chatID),
+				Media:    media,
+				Message:  caption,
+				ReplyTo:  replyTo,
+				RandomID: time.Now().UnixNano(),
+			})
+			return err
+		})
+	}
+
+	if meta := result.SongMeta; meta != nil && meta.AppleMusicID != "" {
+		if err := h.archive.Record(h.archiveKey(meta.AppleMusicID, meta.Codec, meta.Language), result.PartIndex, result.PartCount, *document); err != nil {
+			h.logger.Warn("Failed to record archived song", logging.Err(err))
+		}
+	}
+
+	err = h.forwardArchived(ctx, api, chatID, []int{document.MessageID})
+	if err == nil {
+		return nil
+	}
+	h.logger.Warn("Failed to forward archived song, sending the archived file instead", logging.Err(err))
+
+	// Send the archived file by its reference, without uploading it again
+	return downloader.SendReplying(replyTo, func(replyTo tg.InputReplyToClass) error {
+		_, err := api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+			Peer: h.client.InputPeer(chatID),
+			Media: &tg.InputMediaDocument{
+				ID: &tg.InputDocument{
+					ID:            document.DocumentID,
+					AccessHash:    document.AccessHash,
+					FileReference: document.FileReference,
+				},
+			},
+			Message:  caption,
+			ReplyTo:  replyTo,
+			RandomID: time.Now().UnixNano(),
+		})
+		return err
+	})
+}
+
+// archivedDocument returns the audio document of a message sent to the
+// archive channel, nil when the updates hold none
+func archivedDocument(updates tg.UpdatesClass) *ArchivedDocument {
+	for _, msg := range downloader.SentMessages(updates) {
+		media, ok := msg.Media.(*tg.MessageMediaDocument)
+		if !ok {
+			continue
+		}
+		if doc, ok := media.Document.(*tg.Document); ok {
+			return &ArchivedDocument{
+				MessageID:     msg.ID,
+				DocumentID:    doc.ID,
+				AccessHash:    doc.AccessHash,
+				FileReference: doc.FileReference,
+			}
+		}
+	}
+	return nil
+}
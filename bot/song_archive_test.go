@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const testArchiveChatID = -1001234567890
+
+// archiveTestAPI records the media sent and the messages forwarded
+type archiveTestAPI struct {
+	mockReactionAPI
+	media       []*tg.MessagesSendMediaRequest
+	forwards    []*tg.MessagesForwardMessagesRequest
+	forwardErr  error
+	forwardNone bool // Forwards succeed without any message, as for deleted ones
+}
+
+func (a *archiveTestAPI) MessagesSendMedia(ctx context.Context, request *tg.MessagesSendMediaRequest) (tg.UpdatesClass, error) {
+	a.media = append(a.media, request)
+	return &tg.Updates{Updates: []tg.UpdateClass{
+		&tg.UpdateNewChannelMessage{Message: &tg.Message{
+			ID: 100 + len(a.media),
+			Media: &tg.MessageMediaDocument{Document: &tg.Document{
+				ID:            7,
+				AccessHash:    8,
+				FileReference: []byte{9},
+			}},
+		}},
+	}}, nil
+}
+
+func (a *archiveTestAPI) MessagesForwardMessages(ctx context.Context, request *tg.MessagesForwardMessagesRequest) (tg.UpdatesClass, error) {
+	a.forwards = append(a.forwards, request)
+	if a.forwardErr != nil {
+		return nil, a.forwardErr
+	}
+	updates := &tg.Updates{}
+	if !a.forwardNone {
+		for _, id := range request.ID {
+			updates.Updates = append(updates.Updates, &tg.UpdateNewMessage{Message: &tg.Message{ID: id + 1000}})
+		}
+	}
+	return updates, nil
+}
+
+// newArchiveTestHandler returns a handler archiving to testArchiveChatID
+// through api
+func newArchiveTestHandler(api *archiveTestAPI) *SongHandler {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.api = api
+	handler.archiveChatID = testArchiveChatID
+	return handler
+}
+
+func TestSongArchive_RecordsPartsAndSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SongArchiveFile)
+	archive, err := NewSongArchive(path)
+	if err != nil {
+		t.Fatalf("NewSongArchive() error = %v", err)
+	}
+
+	if err := archive.Record("1440818839", 1, 2, ArchivedDocument{MessageID: 1}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if _, ok := archive.Get("1440818839"); ok {
+		t.Error("Expected a song with a part missing not to be served")
+	}
+	if err := archive.Record("1440818839", 2, 2, ArchivedDocument{MessageID: 2}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	reloaded, err := NewSongArchive(path)
+	if err != nil {
+		t.Fatalf("NewSongArchive() error = %v", err)
+	}
+	song, ok := reloaded.Get("1440818839")
+	if !ok {
+		t.Fatal("Expected the archived song after a restart")
+	}
+	if ids := song.messageIDs(); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("Expected messages [1 2], got %v", ids)
+	}
+
+	if err := reloaded.Remove("1440818839"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	reloaded, _ = NewSongArchive(path)
+	if _, ok := reloaded.Get("1440818839"); ok {
+		t.Error("Expected the removed song to stay removed after a restart")
+	}
+}
+
+func TestSongArchive_DropsSongWithMissingPart(t *testing.T) {
+	archive, _ := NewSongArchive("")
+	archive.Record("1", 2, 2, ArchivedDocument{MessageID: 2})
+
+	if _, ok := archive.Get("1"); ok {
+		t.Error("Expected a song whose first part was never archived not to be served")
+	}
+}
+
+func TestSongHandler_DeliverArchived(t *testing.T) {
+	songURL := "https://music.apple.com/us/song/test/1440818839"
+	cmdCtx := &CommandContext{ChatID: 12345}
+
+	t.Run("forwards archived songs", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+		handler.archive.Record("1440818839", 0, 0, ArchivedDocument{MessageID: 55})
+
+		if !handler.deliverArchived(context.Background(), cmdCtx, songURL) {
+			t.Fatal("Expected the archived song to be delivered")
+		}
+		if len(api.forwards) != 1 {
+			t.Fatalf("Expected 1 forward, got %d", len(api.forwards))
+		}
+		forward := api.forwards[0]
+		if channel, ok := forward.FromPeer.(*tg.InputPeerChannel); !ok || channel.ChannelID != 1234567890 {
+			t.Errorf("Expected a forward from channel 1234567890, got %v", forward.FromPeer)
+		}
+		if len(forward.ID) != 1 || forward.ID[0] != 55 || !forward.DropAuthor {
+			t.Errorf("Expected message 55 copied without author, got %v (drop author %v)", forward.ID, forward.DropAuthor)
+		}
+		if len(api.edits) != 0 {
+			t.Errorf("Expected no edit without a status message, got %d", len(api.edits))
+		}
+	})
+
+	t.Run("records forwards in the history", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+		handler.archive.Record("1440818839", 0, 0, ArchivedDocument{MessageID: 55})
+
+		// Songs archived before they were described only have their ID
+		request := &CommandContext{UserID: 7, ChatID: 12345}
+		handler.deliverArchived(context.Background(), request, songURL)
+
+		// Downloading the song describes its archived copy
+		meta := &downloader.SongMetadata{AppleMusicID: "1440818839", Title: "Song", Artist: "Artist", BitDepth: 24, SampleRateHz: 96000}
+		handler.recordHistory(request, &downloader.DownloadResult{SongMeta: meta, FileSize: 1234})
+		handler.deliverArchived(context.Background(), request, songURL)
+
+		records, _ := handler.history.Recent(7, 10)
+		if len(records) != 3 {
+			t.Fatalf("Expected 3 history records, got %d", len(records))
+		}
+		for _, record := range records {
+			if record.AppleMusicID != "1440818839" || record.ArchiveMessageID != 55 {
+				t.Errorf("Expected archived song 1440818839, got %+v", record)
+			}
+		}
+		described := records[0]
+		if described.Title != "Song" || described.FileSize != 1234 || described.Quality != "24-bit / 96 kHz ALAC" {
+			t.Errorf("Expected the forward to be described like the download, got %+v", described)
+		}
+	})
+
+	t.Run("finishes the queue status message", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+		handler.archive.Record("1440818839", 0, 0, ArchivedDocument{MessageID: 55})
+
+		queued := &CommandContext{ChatID: 12345, StatusMessageID: 77}
+		if !handler.deliverArchived(context.Background(), queued, songURL) {
+			t.Fatal("Expected the archived song to be delivered")
+		}
+		if len(api.edits) != 1 || api.edits[0].ID != 77 || !strings.Contains(api.edits[0].Message, "Sent from the archive") {
+			t.Errorf("Expected status message 77 to be finished, got %+v", api.edits)
+		}
+
+		// The storefront prompt takes over from the queue message
+		prompted := &CommandContext{ChatID: 12345, StatusMessageID: 77}
+		prompted.Override.ProgressMessageID = 88
+		handler.deliverArchived(context.Background(), prompted, songURL)
+		if len(api.edits) != 2 || api.edits[1].ID != 88 {
+			t.Errorf("Expected the storefront prompt 88 to be finished, got %+v", api.edits)
+		}
+	})
+
+	t.Run("downloads songs that are not archived", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+
+		if handler.deliverArchived(context.Background(), cmdCtx, songURL) {
+			t.Error("Expected a song that is not archived to be downloaded")
+		}
+		if len(api.forwards) != 0 {
+			t.Errorf("Expected no forward, got %d", len(api.forwards))
+		}
+	})
+
+	t.Run("keeps copies of other codecs and languages apart", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+		handler.archive.Record("1440818839:aac", 0, 0, ArchivedDocument{MessageID: 56})
+		handler.archive.Record("1440818839:lang=ja", 0, 0, ArchivedDocument{MessageID: 57})
+
+		testCases := []struct {
+			text      string
+			delivered int // Archived message forwarded, 0 for none
+		}{
+			{"/song " + songURL, 0},
+			{"/song " + songURL + " aac", 56},
+			{"/song " + songURL + " lang=ja", 57},
+			{"/song " + songURL + " lang=en-US", 0},
+		}
+		for _, tc := range testCases {
+			api.forwards = nil
+			request := &CommandContext{ChatID: 12345, MessageText: tc.text}
+			if delivered := handler.deliverArchived(context.Background(), request, songURL); delivered != (tc.delivered != 0) {
+				t.Errorf("%q: delivered = %v, want %v", tc.text, delivered, tc.delivered != 0)
+				continue
+			}
+			if tc.delivered != 0 && (len(api.forwards) != 1 || api.forwards[0].ID[0] != tc.delivered) {
+				t.Errorf("%q: expected message %d forwarded, got %+v", tc.text, tc.delivered, api.forwards)
+			}
+		}
+	})
+
+	deleted := []struct {
+		name string
+		api  *archiveTestAPI
+	}{
+		{"message id invalid", &archiveTestAPI{forwardErr: tgerr.New(400, "MESSAGE_ID_INVALID")}},
+		{"nothing forwarded", &archiveTestAPI{forwardNone: true}},
+	}
+	for _, tc := range deleted {
+		t.Run("forgets deleted messages: "+tc.name, func(t *testing.T) {
+			handler := newArchiveTestHandler(tc.api)
+			handler.archive.Record("1440818839", 0, 0, ArchivedDocument{MessageID: 55})
+
+			if handler.deliverArchived(context.Background(), cmdCtx, songURL) {
+				t.Fatal("Expected a deleted archived song to be downloaded again")
+			}
+			if _, ok := handler.archive.Get("1440818839"); ok {
+				t.Error("Expected the deleted song to be removed from the archive")
+			}
+		})
+	}
+}
+
+func TestSongHandler_SendThroughArchive(t *testing.T) {
+	result := &downloader.DownloadResult{SongMeta: &downloader.SongMetadata{AppleMusicID: "1440818839"}}
+	media := &tg.InputMediaUploadedDocument{MimeType: "audio/mp4"}
+
+	t.Run("archives and forwards", func(t *testing.T) {
+		api := &archiveTestAPI{}
+		handler := newArchiveTestHandler(api)
+
+		if err := handler.sendThroughArchive(context.Background(), api, 12345, 0, result, media, "caption"); err != nil {
+			t.Fatalf("sendThroughArchive() error = %v", err)
+		}
+		if len(api.media) != 1 {
+			t.Fatalf("Expected the song to be sent once, got %d sends", len(api.media))
+		}
+		if _, ok := api.media[0].Peer.(*tg.InputPeerChannel); !ok {
+			t.Errorf("Expected the song to be sent to the archive channel, got %v", api.media[0].Peer)
+		}
+		if len(api.forwards) != 1 || api.forwards[0].ID[0] != 101 {
+			t.Errorf("Expected archived message 101 to be forwarded, got %v", api.forwards)
+		}
+
+		song, ok := handler.archive.Get("1440818839")
+		if !ok {
+			t.Fatal("Expected the song to be archived")
+		}
+		if doc := song.Documents[0]; doc.MessageID != 101 || doc.DocumentID != 7 || doc.AccessHash != 8 {
+			t.Errorf("Expected message 101 with document 7, got %+v", doc)
+		}
+
+		// AAC copies do not take the place of the ALAC one
+		aac := &downloader.DownloadResult{SongMeta: &downloader.SongMetadata{AppleMusicID: "1440818840", Codec: downloader.CodecAAC}}
+		if err := handler.sendThroughArchive(context.Background(), api, 12345, 0, aac, media, "caption"); err != nil {
+			t.Fatalf("sendThroughArchive() error = %v", err)
+		}
+		if _, ok := handler.archive.Get("1440818840"); ok {
+			t.Error("Expected the AAC copy not to be archived as the ALAC one")
+		}
+		if _, ok := handler.archive.Get("1440818840:aac"); !ok {
+			t.Error("Expected the AAC copy to be archived under its codec")
+		}
+	})
+
+	t.Run("sends the archived file when forwarding fails", func(t *testing.T) {
+		api := &archiveTestAPI{forwardErr: tgerr.New(403, "CHAT_WRITE_FORBIDDEN")}
+		handler := newArchiveTestHandler(api)
+
+		if err := handler.sendThroughArchive(context.Background(), api, 12345, 77, result, media, "caption"); err != nil {
+			t.Fatalf("sendThroughArchive() error = %v", err)
+		}
+		if len(api.media) != 2 {
+			t.Fatalf("Expected the archived file to be sent to the chat, got %d sends", len(api.media))
+		}
+		document, ok := api.media[1].Media.(*tg.InputMediaDocument)
+		if !ok {
+			t.Fatalf("Expected the file to be sent by reference, got %T", api.media[1].Media)
+		}
+		if input, ok := document.ID.(*tg.InputDocument); !ok || input.ID != 7 || input.AccessHash != 8 {
+			t.Errorf("Expected document 7, got %v", document.ID)
+		}
+		if api.media[1].Message != "caption" {
+			t.Errorf("Expected the chat's caption, got %q", api.media[1].Message)
+		}
+		if replyTo, ok := api.media[1].ReplyTo.(*tg.InputReplyToMessage); !ok || replyTo.ReplyToMsgID != 77 {
+			t.Errorf("Expected the file to reply to message 77, got %v", api.media[1].ReplyTo)
+		}
+	})
+}
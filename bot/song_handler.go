@@ -2,8 +2,9 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"mime"
 	"os"
 	"path/filepath"
@@ -11,40 +12,271 @@ import (
 	"sync"
 	"time"
 
+	"go-alac-bot/config"
 	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
 
 	"github.com/gotd/td/telegram/uploader"
 	"github.com/gotd/td/tg"
 )
 
+// restartingMessage replaces the progress of downloads interrupted by a shutdown
+const restartingMessage = "⏸️ Bot restarting, your request was re-queued"
+
 // SongHandler implements CommandHandler for the /song command
 type SongHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	logger       logging.Logger
 	errorHandler *ErrorHandler
 	downloader   downloader.SongDownloader
+	manager      *downloader.Manager
 	queue        *SongQueue
+	storefronts  *ChatStorefronts
+	pages        *ProgressPages
+
+	// strictMetadata holds the chats that are told about missing tags
+	strictMetadata *ChatStrictMetadata
+
+	// languages holds the message and tag languages of each chat
+	languages *ChatLanguages
+
+	// autoDelete holds the groups whose command messages are deleted after
+	// delivery, deleteDelay after it
+	autoDelete  *ChatAutoDelete
+	deleteDelay time.Duration
+
+	// prompts holds the storefront choices offered for songs without ALAC
+	prompts *OverridePrompts
+
+	// batches keeps the summaries of /song messages with several URLs live
+	batches *BatchTracker
+
+	// limiter limits the /song commands of each user and chat
+	limiter *RateLimiter
+
+	// lookupLimiter limits /cover and /info, which only ask the catalog, more
+	// loosely than limiter
+	lookupLimiter *RateLimiter
+
+	// history records the songs delivered to each user
+	history DownloadHistory
+
+	// reminders holds the subscriptions to songs that are not released yet
+	reminders *ReleaseReminders
+
+	// api replaces the client's Telegram API when set
+	api downloader.TelegramAPI
+
+	// checkpoints lets big uploads resume after a restart (nil = no data dir)
+	checkpoints *UploadCheckpoints
+
+	// uploadLimit is the largest file uploaded (0 = any), uploadPartSize the
+	// part size of big files and uploadRetries the times a failed part is
+	// sent again, first after uploadRetryDelay
+	uploadLimit      int64
+	uploadPartSize   int
+	uploadRetries    int
+	uploadRetryDelay time.Duration
+
+	// metadataLanguage is the tag language of chats without their own
+	// (empty = storefront's)
+	metadataLanguage string
+
+	// aacFallback downloads the AAC stream of songs without ALAC for every
+	// request, not only those with the "aac" flag
+	aacFallback bool
+
+	// archive holds the songs uploaded to the archive channel archiveChatID
+	// (0 = none), which are forwarded instead of downloaded again
+	archive       *SongArchive
+	archiveChatID int64
+
+	// preflight checks a song before it is queued; nil skips the check
+	preflight func(ctx context.Context, url string) error
+
+	// upload sends a downloaded file to the chat; defaults to uploadFile
+	upload func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error
+
+	deliveryReaction  string
+	defaultStorefront string
 }
 
 // NewSongHandler creates a new SongHandler instance
-func NewSongHandler(client *TelegramBot, logger *log.Logger) *SongHandler {
+func NewSongHandler(client *TelegramBot, logger logging.Logger) *SongHandler {
 	handler := &SongHandler{
-		client:     client,
-		logger:     logger,
-		downloader: downloader.NewSongDownloaderImpl(),
+		client:            client,
+		logger:            logger,
+		manager:           downloader.NewManager(0),
+		storefronts:       NewChatStorefronts(),
+		strictMetadata:    NewChatStrictMetadata(),
+		languages:         NewChatLanguages(),
+		autoDelete:        NewChatAutoDelete(),
+		deleteDelay:       CommandDeleteDelay,
+		prompts:           NewOverridePrompts(),
+		limiter:           NewRateLimiter(0, 0),
+		lookupLimiter:     NewRateLimiter(0, 0),
+		history:           NewMemoryHistory(),
+		uploadLimit:       config.MaxSplitMB << 20,
+		uploadPartSize:    resumablePartSize,
+		uploadRetries:     config.DefaultUploadRetries,
+		uploadRetryDelay:  defaultUploadRetryDelay,
+		deliveryReaction:  config.DefaultDeliveryReaction,
+		defaultStorefront: config.DefaultStorefront,
 	}
 
+	handler.upload = handler.uploadFile
+
+	// Without a data dir reminders and archived songs are kept in memory
+	handler.reminders, _ = NewReleaseReminders("")
+	handler.archive, _ = NewSongArchive("")
+
 	// Set error handler if client is available
 	if client != nil {
 		handler.errorHandler = client.GetErrorHandler()
+
+		if cfg := client.GetConfig(); cfg != nil {
+			if cfg.DeliveryReaction != "" {
+				handler.deliveryReaction = cfg.DeliveryReaction
+			}
+			if cfg.DefaultStorefront != "" {
+				handler.defaultStorefront = cfg.DefaultStorefront
+			}
+			handler.manager = downloader.NewManager(int64(cfg.MaxMemoryMB) << 20)
+			handler.manager.SetFallbackStorefronts(cfg.FallbackStorefronts)
+			handler.manager.SetSplitLimit(int64(cfg.SplitMaxMB) << 20)
+			handler.manager.SetArtworkLimits(cfg.ArtworkMaxDimension, int64(cfg.ArtworkMaxKB)<<10)
+			handler.manager.SetMediaRetries(cfg.DownloadRetries)
+			handler.manager.SetMediaChunks(cfg.DownloadChunks)
+			handler.manager.SetDecryptTimeout(cfg.DecryptTimeout)
+			handler.manager.SetDecryptLimit(cfg.DecryptLimit)
+			handler.manager.SetStallTimeout(cfg.StallTimeout)
+			handler.manager.SetDeviceTimeout(cfg.DeviceTimeout)
+			if cfg.PreflightEnabled {
+				handler.preflight = handler.manager.Validate
+			}
+			if cfg.DownloadDir != "" {
+				handler.manager.SetDownloadDir(cfg.DownloadDir)
+			}
+			handler.manager.SetMinFreeDisk(uint64(cfg.MinFreeDiskMB) << 20)
+			if cfg.StaleTempAge > 0 {
+				removed, err := handler.manager.CleanStaleTemp(cfg.StaleTempAge)
+				if err != nil {
+					logger.Warn("Failed to clean up unfinished downloads", logging.Err(err))
+				} else if removed > 0 {
+					logger.Info("Removed unfinished downloads", logging.Int("Removed", removed), logging.Duration("OlderThan", cfg.StaleTempAge))
+				}
+			}
+			if cfg.UploadMaxMB > 0 {
+				handler.uploadLimit = int64(cfg.UploadMaxMB) << 20
+			}
+			if cfg.UploadPartKB > 0 {
+				handler.uploadPartSize = cfg.UploadPartKB << 10
+			}
+			handler.uploadRetries = cfg.UploadRetries
+			handler.limiter = NewRateLimiter(cfg.SongRateLimit, cfg.ChatSongRateLimit)
+			handler.lookupLimiter = NewRateLimiter(cfg.SongRateLimit*lookupRateFactor, cfg.ChatSongRateLimit*lookupRateFactor)
+			handler.aacFallback = cfg.AACFallback
+			if cfg.MetadataLanguage != "" {
+				language, err := NormalizeLanguage(cfg.MetadataLanguage)
+				if err != nil {
+					logger.Warn("Ignoring METADATA_LANG", logging.Err(err))
+				}
+				handler.metadataLanguage = language
+			}
+			handler.manager.SetBandwidthLimits(int64(cfg.DownloadRateLimit*(1<<20)), int64(cfg.UploadRateLimit*(1<<20)))
+			if err := handler.manager.SetExpectedMetadata(cfg.ExpectedMetadata); err != nil {
+				logger.Warn("Ignoring EXPECTED_METADATA", logging.Err(err))
+			}
+			if cfg.DataDir != "" {
+				handler.checkpoints = NewUploadCheckpoints(filepath.Join(cfg.DataDir, UploadCheckpointsDir))
+				reminders, err := NewReleaseReminders(filepath.Join(cfg.DataDir, ReleaseRemindersFile))
+				if err != nil {
+					logger.Warn("Failed to load release reminders", logging.Err(err))
+				}
+				handler.reminders = reminders
+				archive, err := NewSongArchive(filepath.Join(cfg.DataDir, SongArchiveFile))
+				if err != nil {
+					logger.Warn("Failed to load archived songs", logging.Err(err))
+				}
+				handler.archive = archive
+				history, err := NewFileHistory(filepath.Join(cfg.DataDir, DownloadHistoryFile))
+				if err != nil {
+					logger.Warn("Failed to load download history", logging.Err(err))
+				}
+				handler.history = history
+			}
+			handler.archiveChatID = cfg.DumpChatID
+		}
+		handler.manager.SetLatencyTracker(client.Latencies())
 	}
 
 	// Initialize queue
-	handler.queue = NewSongQueue(logger, handler)
+	// Requests are cancelled when the bot stops
+	queueCtx := context.Background()
+	if client != nil && client.ctx != nil {
+		queueCtx = client.ctx
+	}
+	handler.queue = NewSongQueue(queueCtx, logger, handler)
+	handler.batches = NewBatchTracker(func(ctx context.Context, chatID int64, messageID int, message string) error {
+		return handler.sender().EditMessage(ctx, chatID, messageID, message)
+	}, logger)
+	handler.queue.AddListener(handler.batches)
+	handler.queue.AddListener(NewQueueStatusUpdater(func(ctx context.Context, chatID int64, messageID int, message string) error {
+		return handler.sender().EditMessage(ctx, chatID, messageID, message)
+	}, handler.manager.EditRate().NewPacer(), logger))
+	if client != nil && client.GetConfig() != nil {
+		handler.configureQueue(client.GetConfig())
+	}
 
 	return handler
 }
 
+// configureQueue applies the queue limits from the environment, then any limits
+// saved in the data dir by /setqueue
+func (h *SongHandler) configureQueue(cfg *config.BotConfig) {
+	size, workers := cfg.QueueSize, cfg.QueueWorkers
+	if size == 0 {
+		size = MaxQueueSize
+	}
+	if workers == 0 {
+		workers = MinQueueWorkers
+	}
+
+	if err := h.queue.SetLimits(size, workers); err != nil {
+		h.logger.Warn("Ignoring queue limits from environment", logging.Err(err))
+	}
+
+	h.queue.SetFailedRequestTTL(cfg.FailedRequestTTL)
+	h.queue.SetRequestTimeout(cfg.RequestTimeout)
+	h.queue.SetUserLimit(cfg.UserQueueLimit)
+	h.queue.SetAutoRetries(cfg.AutoRetries)
+
+	// Waiting requests survive restarts; they are resumed once the bot is connected
+	if cfg.QueueFile != "" {
+		if _, err := h.queue.EnableRequestPersistence(cfg.QueueFile, cfg.QueuedRequestTTL); err != nil {
+			h.logger.Warn("Failed to load saved requests", logging.Err(err))
+		}
+	}
+
+	// Limits set from here on are runtime overrides of the environment
+	if h.client != nil {
+		h.queue.SetConfigRegistry(h.client.GetConfigRegistry())
+	}
+
+	if cfg.DataDir == "" {
+		return
+	}
+
+	if err := h.queue.EnableSettingsPersistence(filepath.Join(cfg.DataDir, QueueSettingsFile)); err != nil {
+		h.logger.Warn("Failed to load saved queue settings", logging.Err(err))
+	}
+}
+
+// SetProgressPages enables "watch live" web progress pages for downloads
+func (h *SongHandler) SetProgressPages(pages *ProgressPages) {
+	h.pages = pages
+}
+
 // Command returns the command string this handler processes
 func (h *SongHandler) Command() string {
 	return "song"
@@ -55,34 +287,201 @@ func (h *SongHandler) GetQueue() *SongQueue {
 	return h.queue
 }
 
+// GetStorefronts returns the per-chat storefront settings
+func (h *SongHandler) GetStorefronts() *ChatStorefronts {
+	return h.storefronts
+}
+
+// GetStrictMetadata returns the per-chat strict metadata settings
+func (h *SongHandler) GetStrictMetadata() *ChatStrictMetadata {
+	return h.strictMetadata
+}
+
+// GetLanguages returns the per-chat language settings
+func (h *SongHandler) GetLanguages() *ChatLanguages {
+	return h.languages
+}
+
+// GetSchemaMonitor returns the monitor of catalog schema drift
+func (h *SongHandler) GetSchemaMonitor() *downloader.SchemaMonitor {
+	return h.manager.SchemaMonitor()
+}
+
+// CheckDependencies probes the services and disk space downloads depend on
+func (h *SongHandler) CheckDependencies(ctx context.Context) (downloader.DependencyStatus, error) {
+	return h.manager.CheckDependencies(ctx)
+}
+
+// GetAutoDelete returns the per-chat auto-delete settings
+func (h *SongHandler) GetAutoDelete() *ChatAutoDelete {
+	return h.autoDelete
+}
+
 // Handle processes the /song command and manages queueing
 func (h *SongHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
-	h.logger.Printf("Received /song command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Received /song command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 
-	// Check if URL is provided
-	if strings.TrimSpace(cmdCtx.Args) == "" {
+	// Check if URL is provided. The "aac" and "lang=" flags are read again
+	// from the message text when the song is downloaded
+	args, _ := SplitAACFlag(cmdCtx.Args)
+	args, language := SplitLanguageFlag(args)
+	if strings.TrimSpace(args) == "" {
 		return h.sendErrorMessage(ctx, cmdCtx.ChatID, "Please provide a song URL.")
 	}
+	if language != "" {
+		if _, err := NormalizeLanguage(language); err != nil {
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, fmt.Sprintf("Invalid lang: %v.", err))
+		}
+	}
 
-	// Parse and validate the URL
-	songURL := strings.TrimSpace(cmdCtx.Args)
-	urlMeta := ExtractURLMeta(songURL)
+	// Users sending songs too fast are told when to try again; admins are not
+	// limited
+	if h.client == nil || !h.client.IsAdmin(cmdCtx.UserID) {
+		if ok, wait, chatLimited := h.limiter.Allow(cmdCtx.UserID, cmdCtx.ChatID); !ok {
+			h.logger.Info("Rate limited /song command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID), logging.Duration("Wait", wait))
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, rateLimitMessage(wait, chatLimited))
+		}
+	}
+
+	// Region-neutral share links are queued as the link they redirect to
+	args = h.resolveGeoLinks(ctx, args)
+
+	// Several URLs are queued as one batch with a single summary message. A
+	// track ID may be followed by its storefront instead
+	if urls := strings.Fields(args); len(urls) > 1 && !IsTrackIDInput(urls[0]) {
+		return h.addBatch(ctx, cmdCtx, urls)
+	}
+
+	// Parse and validate the URL or track ID
+	songURL, urlMeta := ParseSongInput(args, h.storefrontHints(cmdCtx))
 	if urlMeta == nil {
+		if IsLibraryURL(args) {
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, capitalize(ErrLibraryURL.Error())+".")
+		}
 		return h.sendErrorMessage(ctx, cmdCtx.ChatID, "Please provide a valid Apple Music URL.")
 	}
 
+	// Links without a storefront are queued with the inferred one filled in
+	notice := ""
+	if urlMeta.StorefrontInferred {
+		songURL = WithStorefront(songURL, urlMeta.Storefront)
+		notice = storefrontNotice(urlMeta.Storefront)
+		if IsTrackIDInput(strings.Fields(args)[0]) {
+			notice = trackIDStorefrontNotice(urlMeta.Storefront)
+		}
+		h.logger.Debug("Inferred storefront", logging.String("Storefront", urlMeta.Storefront), logging.String("Source", string(urlMeta.StorefrontSource)), logging.Int64("User", cmdCtx.UserID))
+	}
+
 	// Add to queue
-	return h.addToQueue(ctx, cmdCtx, songURL)
+	return h.addToQueue(ctx, cmdCtx, songURL, notice)
+}
+
+// resolveGeoLinks replaces the geo.music.apple.com links among args with the
+// links they redirect to. A link that cannot be resolved keeps its path on
+// music.apple.com, so that its storefront is inferred like for other links
+// without one
+func (h *SongHandler) resolveGeoLinks(ctx context.Context, args string) string {
+	fields := strings.Fields(args)
+	changed := false
+	for i, field := range fields {
+		if !IsGeoURL(field) {
+			continue
+		}
+		resolveCtx, cancel := context.WithTimeout(ctx, geoRedirectTimeout)
+		resolved, err := ResolveGeoURL(resolveCtx, geoClient, field)
+		cancel()
+		if err != nil {
+			h.logger.Warn("Failed to resolve geo link", logging.String("URL", field), logging.Err(err))
+			resolved = GeoFallbackURL(field)
+		}
+		fields[i] = resolved
+		changed = true
+	}
+	if !changed {
+		return args
+	}
+	return strings.Join(fields, " ")
+}
+
+// rateLimitMessage tells the user how long to wait before sending another song
+func rateLimitMessage(wait time.Duration, chatLimited bool) string {
+	// Waits are rounded up so that retrying on time is allowed
+	after := downloader.FormatDuration((wait + time.Second - 1).Truncate(time.Second))
+	if chatLimited {
+		return fmt.Sprintf("Too many songs were requested in this chat. Please try again in %s.", after)
+	}
+	return fmt.Sprintf("You're requesting songs too fast. Please try again in %s.", after)
+}
+
+// allowLookup takes a /cover or /info request from the lookup limiter. When
+// the user or chat is over the limit, they are told when to try again and
+// false is returned with the error of sending that. Admins are not limited
+func (h *SongHandler) allowLookup(ctx context.Context, cmdCtx *CommandContext) (bool, error) {
+	if h.client != nil && h.client.IsAdmin(cmdCtx.UserID) {
+		return true, nil
+	}
+	ok, wait, chatLimited := h.lookupLimiter.Allow(cmdCtx.UserID, cmdCtx.ChatID)
+	if ok {
+		return true, nil
+	}
+	h.logger.Info("Rate limited lookup", logging.String("Command", cmdCtx.Command), logging.Int64("User", cmdCtx.UserID),
+		logging.Int64("Chat", cmdCtx.ChatID), logging.Duration("Wait", wait))
+	return false, h.sendErrorMessage(ctx, cmdCtx.ChatID, lookupRateLimitMessage(wait, chatLimited))
+}
+
+// lookupRateLimitMessage tells the user how long to wait before sending
+// another /cover or /info
+func lookupRateLimitMessage(wait time.Duration, chatLimited bool) string {
+	after := downloader.FormatDuration((wait + time.Second - 1).Truncate(time.Second))
+	if chatLimited {
+		return fmt.Sprintf("Too many lookups were requested in this chat. Please try again in %s.", after)
+	}
+	return fmt.Sprintf("You're looking songs up too fast. Please try again in %s.", after)
+}
+
+// storefrontHints collects the storefront hints for a request
+func (h *SongHandler) storefrontHints(cmdCtx *CommandContext) StorefrontHints {
+	return StorefrontHints{
+		ChatStorefront: h.storefronts.Get(cmdCtx.ChatID),
+		LanguageCode:   cmdCtx.LanguageCode,
+		Default:        h.defaultStorefront,
+	}
+}
+
+// storefrontNotice tells the user which store was assumed for their link
+func storefrontNotice(storefront string) string {
+	return fmt.Sprintf("ℹ️ Assuming %s store — add /%s/ to the link to override.", strings.ToUpper(storefront), storefront)
+}
+
+// trackIDStorefrontNotice tells the user which store was assumed for a track ID
+func trackIDStorefrontNotice(storefront string) string {
+	return fmt.Sprintf("ℹ️ Assuming %s store — add a storefront after the ID (e.g. \"/song <id> %s\") to override.", strings.ToUpper(storefront), storefront)
 }
 
 // addToQueue adds a request to the song queue
-func (h *SongHandler) addToQueue(ctx context.Context, cmdCtx *CommandContext, songURL string) error {
+func (h *SongHandler) addToQueue(ctx context.Context, cmdCtx *CommandContext, songURL string, notice string) error {
+	// Songs that cannot be downloaded right now are refused before queueing
+	if h.preflight != nil {
+		if err := h.preflight(ctx, songURL); err != nil {
+			h.logger.Info("Pre-flight check failed", logging.String("URL", songURL), logging.Err(err))
+			return h.sender().SendMarkdown(ctx, cmdCtx.ChatID, preflightMessage(err))
+		}
+	}
+
 	// Try to add request to queue
-	request, err := h.queue.AddRequest(cmdCtx.UserID, cmdCtx.ChatID, cmdCtx.MessageID, songURL)
+	request, err := h.queue.AddRequestWithMessage(cmdCtx.UserID, cmdCtx.ChatID, cmdCtx.MessageID, songURL, cmdCtx.MessageText, cmdCtx.Entities)
 	if err != nil {
-		// Check if queue is full
-		if strings.Contains(err.Error(), "queue is full") {
-			return h.sendErrorMessage(ctx, cmdCtx.ChatID, fmt.Sprintf("❌ Queue is full! Current limit is %d requests. Please wait some time before adding new requests.", MaxQueueSize))
+		var limitErr *UserLimitError
+		var duplicateErr *DuplicateRequestError
+		switch {
+		case errors.As(err, &limitErr):
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, fmt.Sprintf("❌ You already have %d songs queued. Please wait for them to finish before adding more.", limitErr.Pending))
+		case errors.As(err, &duplicateErr):
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, duplicateRequestMessage(duplicateErr))
+		case errors.Is(err, ErrShuttingDown):
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, "⏸️ The bot is restarting. Please send your request again in a minute.")
+		case strings.Contains(err.Error(), "queue is full"):
+			return h.sendErrorMessage(ctx, cmdCtx.ChatID, fmt.Sprintf("❌ Queue is full! Current limit is %d requests. Please wait some time before adding new requests.", h.queue.MaxSize()))
 		}
 		return h.sendErrorMessage(ctx, cmdCtx.ChatID, fmt.Sprintf("❌ Failed to add request to queue: %v", err))
 	}
@@ -92,31 +491,106 @@ func (h *SongHandler) addToQueue(ctx context.Context, cmdCtx *CommandContext, so
 	isCurrentlyProcessing := h.queue.IsProcessing()
 
 	var message string
+	position := -1
 	if queueSize == 0 && !isCurrentlyProcessing {
 		message = "🎵 Processing your request..."
 	} else {
-		position := h.queue.GetQueuePosition(request.UniqueID)
+		position = h.queue.GetQueuePosition(request.UniqueID)
 		if position > 0 {
-			message = fmt.Sprintf("🎵 Your request is in queue at position %d", position)
+			message = queuedStatusMessage(position, queueSize, h.queue.EstimateWait(position))
 		} else {
 			message = "🎵 Your request has been queued for processing"
 		}
 	}
 
-	return h.sendMessage(ctx, cmdCtx.ChatID, message)
+	if notice != "" {
+		message += "\n" + notice
+	}
+
+	if position <= 0 {
+		return h.sender().SendMarkdown(ctx, cmdCtx.ChatID, message)
+	}
+
+	// The status message is edited as the request moves up
+	messageID, err := h.sendMessageWithID(ctx, cmdCtx.ChatID, message)
+	if err != nil {
+		return err
+	}
+	h.queue.SetStatusMessage(request.UniqueID, messageID)
+	return nil
+}
+
+// requeueFailed checks the URL of a failed request again and puts it back in
+// the queue, downloaded the way override says. A request that cannot be
+// re-queued is kept for later. The returned error is meant for the user
+func (h *SongHandler) requeueFailed(entry *FailedRequest, override DownloadOverride) (*QueueRequest, error) {
+	queue := h.GetQueue()
+	failed := entry.Request
+
+	// Pre-flight check with the current URL rules before queueing again
+	_, reason := h.revalidateURL(&CommandContext{
+		Args:        failed.URL,
+		MessageText: failed.OriginalText,
+		Entities:    failed.OriginalEntities,
+	})
+	if reason != "" {
+		h.logger.Info("Retry failed pre-flight", logging.String("Request", failed.UniqueID),
+			logging.String("Correlation", failed.CorrelationID), logging.String("Reason", reason))
+		queue.Failed().Record(failed, reason)
+		return nil, fmt.Errorf("%s", reason)
+	}
+
+	request, err := queue.RequeueWithOverride(failed, override)
+	if err != nil {
+		// Keep the entry so the user can try again later
+		queue.Failed().Record(failed, entry.Reason)
+		if strings.Contains(err.Error(), "queue is full") {
+			return nil, fmt.Errorf("Queue is full! Current limit is %d requests. Please try again later.", queue.MaxSize())
+		}
+		return nil, fmt.Errorf("Failed to re-queue request: %v", err)
+	}
+	return request, nil
+}
+
+// failureReason returns why a request failed as shown to its user: the
+// user message of a download error, the error text for anything else
+func failureReason(err error) string {
+	if downloadErr, ok := downloader.AsDownloadError(err); ok {
+		return downloadErr.UserMessage()
+	}
+	return err.Error()
+}
+
+// preflightMessage tells the user why a song was not queued
+func preflightMessage(err error) string {
+	if downloader.IsDownloadError(err, downloader.ErrorBackendUnavailable) {
+		return "⚠️ Decryption backend unavailable, try later."
+	}
+	return "❌ " + failureReason(err) + "."
+}
+
+// duplicateRequestMessage tells the user where the song they asked for again is
+func duplicateRequestMessage(err *DuplicateRequestError) string {
+	if err.Position == 0 {
+		return "⏳ This song is already being downloaded in this chat."
+	}
+	return fmt.Sprintf("⏳ This song is already in the queue at position %d.", err.Position)
 }
 
 // ProcessDownload processes the actual song download (called by queue)
-func (h *SongHandler) ProcessDownload(ctx context.Context, cmdCtx *CommandContext) error {
+func (h *SongHandler) ProcessDownload(ctx context.Context, cmdCtx *CommandContext) (err error) {
 	startTime := time.Now()
 
-	h.logger.Printf("Processing song download for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing song download", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 
-	// Parse and validate the URL again (for safety)
-	songURL := strings.TrimSpace(cmdCtx.Args)
-	urlMeta := ExtractURLMeta(songURL)
-	if urlMeta == nil {
-		return h.sendErrorMessage(ctx, cmdCtx.ChatID, "Please provide a valid Apple Music URL.")
+	// Re-validate the URL against the original message with the current parsing logic
+	songURL, reason := h.revalidateURL(cmdCtx)
+	if reason != "" {
+		h.logger.Info("Re-validation failed", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID), logging.String("Reason", reason))
+		if err := h.sendErrorMessage(ctx, cmdCtx.ChatID, reason); err != nil {
+			h.logger.Error("Failed to send re-validation error", logging.Err(err))
+		}
+		return errors.New(reason)
 	}
 
 	// Create Telegram progress reporter
@@ -125,20 +599,76 @@ func (h *SongHandler) ProcessDownload(ctx context.Context, cmdCtx *CommandContex
 	}
 
 	reporter := downloader.NewTelegramProgressReporter(h.client.GetClient().API())
+	reporter.SetPeerResolver(h.client.Peers())
+	reporter.SetReplyTo(cmdCtx.MessageID)
+	reporter.SetControls(progressControls(cmdCtx.RequestID))
+	reporter.SetEditRateController(h.manager.EditRate())
+	reporter.SetMetrics(h.metrics())
+	reporter.SetAttempt(cmdCtx.Attempt, cmdCtx.Attempts)
+	if cmdCtx.Override.ProgressMessageID != 0 {
+		// Continue on the message that asked for the storefront
+		reporter.ResumeMessage(cmdCtx.Override.ProgressMessageID)
+	} else if cmdCtx.StatusMessageID != 0 {
+		// Continue on the message that showed the queue position
+		reporter.ResumeMessage(cmdCtx.StatusMessageID)
+	}
+
+	// Register the download so /my and the web progress page can show its live status
+	songDownloader := h.newDownloader()
+	if cmdCtx.RequestID != "" && h.queue != nil {
+		h.queue.RegisterInFlight(cmdCtx.RequestID, songDownloader)
+	}
+	if h.pages != nil {
+		token, watchURL, pageErr := h.pages.Create(songDownloader)
+		if pageErr != nil {
+			h.logger.Warn("Failed to create progress page", logging.Err(pageErr))
+		} else {
+			reporter.SetWatchURL(watchURL)
+			defer func() { h.pages.Finish(token, err) }()
+		}
+	}
 
-	// Start progress tracking
-	if err := reporter.StartTracking(ctx, cmdCtx.ChatID, "Unknown Song"); err != nil {
-		h.logger.Printf("Failed to start progress tracking: %v", err)
+	if err := h.runDownload(ctx, cmdCtx, songURL, songDownloader, reporter, startTime); err != nil {
+		return err
+	}
+
+	// Mark the original command message as delivered, then tidy it away in
+	// groups that asked for it
+	h.recordDelivery(ctx, h.client.GetClient().API(), cmdCtx)
+	h.scheduleCommandDeletion(h.client.GetClient().API(), cmdCtx)
+
+	// Log successful processing with timing
+	processingTime := time.Since(startTime)
+	h.logger.Info("Processed song download", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Took", processingTime))
+
+	return nil
+}
+
+// runDownload downloads and uploads a song, reporting both on one progress
+// message. The handler owns the reporter: the downloader's completion only
+// ends the download phase, and a single ReportComplete with the total time is
+// sent once the audio has been delivered, whichever path the download took
+func (h *SongHandler) runDownload(ctx context.Context, cmdCtx *CommandContext, songURL string, songDownloader downloader.SongDownloader, reporter downloader.ProgressReporter, startTime time.Time) error {
+	// Songs in the archive channel are forwarded instead of downloaded
+	if h.deliverArchived(ctx, cmdCtx, songURL) {
+		return nil
+	}
+
+	// Start progress tracking, named once the song's metadata is known
+	if err := reporter.StartTracking(ctx, cmdCtx.ChatID, ""); err != nil {
+		h.logger.Error("Failed to start progress tracking", logging.Err(err))
 		return h.sendErrorMessage(ctx, cmdCtx.ChatID, "Failed to initialize progress tracking.")
 	}
 	defer reporter.Stop()
 
-	// Create progress tracker with 2-second update interval
-	tracker := downloader.NewProgressTracker(reporter)
+	// Create progress tracker with 2-second update interval. Stopping the
+	// tracker must not end tracking before the completion is reported
+	tracker := downloader.NewProgressTracker(handlerOwnedReporter{reporter})
 	if err := tracker.Start(ctx); err != nil {
-		h.logger.Printf("Failed to start progress tracker: %v", err)
+		h.logger.Error("Failed to start progress tracker", logging.Err(err))
 		reporter.ReportError(fmt.Errorf("failed to start progress tracker: %w", err))
-		return nil
+		return fmt.Errorf("failed to start progress tracker: %w", err)
 	}
 	defer tracker.Stop()
 
@@ -146,51 +676,470 @@ func (h *SongHandler) ProcessDownload(ctx context.Context, cmdCtx *CommandContex
 	callbacks := downloader.ProgressCallbacks{
 		OnProgress: func(phase downloader.Phase, progress downloader.Progress) {
 			tracker.UpdateProgress(phase, progress)
+			if cmdCtx.BatchID != "" && h.queue != nil {
+				h.queue.Notify(QueueEvent{
+					Kind:       QueueEventProgress,
+					RequestID:  cmdCtx.RequestID,
+					BatchID:    cmdCtx.BatchID,
+					Title:      strings.TrimSuffix(songDownloader.GetStatus().SongName, ".m4a"),
+					Percentage: progress.Percentage,
+				})
+			}
 		},
 		OnPhaseChange: func(oldPhase, newPhase downloader.Phase) {
 			tracker.UpdateProgress(newPhase, downloader.Progress{})
 		},
+		OnMetadata: func(meta *downloader.SongMetadata) {
+			reporter.UpdateSongName(fmt.Sprintf("%s - %s", meta.Title, meta.Artist))
+		},
 		OnError: func(err error) {
-			h.logger.Printf("Download error: %v", err)
-			reporter.ReportError(err)
+			// Reported once the last storefront has been tried
+			h.logger.Warn("Download error", logging.Err(err))
 		},
 		OnComplete: func(result *downloader.DownloadResult) {
-			h.logger.Printf("Download completed: %s", result.FilePath)
-			reporter.ReportComplete(time.Since(startTime), result.FilePath)
+			h.logger.Debug("Download completed", logging.String("File", result.FilePath))
 		},
+		OnWarning: func(message string) {
+			h.logger.Warn("Download warning", logging.String("Warning", message))
+		},
+	}
+
+	if setter, ok := songDownloader.(downloader.OptionsSetter); ok {
+		setter.SetOptions(h.requestOptions(cmdCtx))
 	}
 
-	// Download the song with progress tracking
-	result, err := h.downloader.Download(ctx, songURL, callbacks)
+	// Download the song with progress tracking, moving on to the fallback
+	// storefronts while failures point at the storefront
+	requested := ""
+	storefronts := []string{""}
+	if urlMeta := ExtractURLMeta(songURL); urlMeta != nil {
+		requested = urlMeta.Storefront
+		storefronts = h.manager.StorefrontOrder(urlMeta.Storefront)
+	}
+	if cmdCtx.Override.Storefront != "" {
+		// The user chose this storefront after the others had no ALAC
+		storefronts = []string{cmdCtx.Override.Storefront}
+	}
+
+	var result *downloader.DownloadResult
+	var err error
+	for _, storefront := range storefronts {
+		attemptURL := songURL
+		if storefront != requested {
+			attemptURL = ReplaceStorefront(songURL, storefront)
+			h.logger.Info("Retrying download from another storefront", logging.String("Storefront", storefront), logging.String("URL", attemptURL))
+		}
+
+		result, err = songDownloader.Download(ctx, attemptURL, callbacks)
+		if storefront != "" {
+			h.manager.RecordStorefrontOutcome(storefront, err)
+		}
+		if err == nil || !downloader.IsStorefrontFailure(err) {
+			break
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		err = cancellationError(ctx, "download")
+		h.reportCancelled(ctx, reporter, err)
+		return err
+	}
 	if err != nil {
-		h.logger.Printf("Failed to download song: %v", err)
+		h.logger.Error("Failed to download song", logging.Err(err))
+
+		// Songs that are not out yet can be waited for
+		if h.offerReminder(songURL, reporter, err) {
+			return fmt.Errorf("download failed: %w", err)
+		}
+
+		// Other storefronts may have the ALAC this one lacks; let the user pick
+		if h.promptOverride(ctx, cmdCtx, songURL, songDownloader, reporter, storefronts, err) {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		reportFailure(cmdCtx, reporter, err)
 
 		// Use error handler if available for network errors
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
 			return h.errorHandler.HandleNetworkError(err, true)
 		}
 
-		// Error is already reported through callbacks, so we just return
+		// Error is already reported, so we only hand it to the queue
+		return fmt.Errorf("download failed: %w", err)
+	}
+
+	// Upload the downloaded file, or its parts in order, to Telegram,
+	// reporting on the same message. A failed upload keeps the file for a
+	// retry, but gives up this job's hold on it
+	uploadStart := time.Now()
+	for _, upload := range uploadResults(result) {
+		if err := h.upload(ctx, cmdCtx.ChatID, h.uploadReplyTo(cmdCtx), upload, tracker.UpdateProgress); err != nil {
+			downloader.ReleaseDownload(result.FilePath, false)
+			tracker.Stop()
+			if ctx.Err() != nil {
+				err = cancellationError(ctx, "upload")
+				h.reportCancelled(ctx, reporter, err)
+				return err
+			}
+			h.logger.Error("Failed to upload file", logging.Err(err))
+			reportFailure(cmdCtx, reporter, fmt.Errorf("failed to upload file: %w", err))
+			return fmt.Errorf("upload failed: %w", err)
+		}
+	}
+
+	if result.PhaseDurations == nil {
+		result.PhaseDurations = make(map[downloader.Phase]time.Duration)
+	}
+	result.PhaseDurations[downloader.PhaseUploading] += downloader.Elapsed(uploadStart, time.Now())
+
+	h.recordHistory(cmdCtx, result)
+
+	var notes []string
+	if len(result.Parts) > 0 {
+		// The parts were sent instead of the whole file
+		if _, err := downloader.ReleaseDownload(result.FilePath, true); err != nil {
+			h.logger.Warn("Failed to delete split file after upload", logging.Err(err))
+		}
+		notes = append(notes, fmt.Sprintf("✂️ Split into %d parts to fit the upload size limit", len(result.Parts)))
+	}
+	if warning := h.metadataWarning(cmdCtx.ChatID, result.MissingFields); warning != "" {
+		notes = append(notes, warning)
+	}
+	if noter, ok := reporter.(downloader.CompletionNoter); ok && len(notes) > 0 {
+		noter.SetCompletionNote(strings.Join(notes, "\n"))
+	}
+	if detailer, ok := reporter.(downloader.CompletionDetailer); ok {
+		detailer.SetCompletionDetails(result.FileSize, result.SongMeta.QualityLabel())
+	}
+	if timer, ok := reporter.(downloader.PhaseTimer); ok {
+		timer.SetPhaseDurations(result.PhaseDurations)
+	}
+
+	// Stop periodic updates so none can overwrite the completion
+	tracker.Stop()
+	reporter.ReportComplete(downloader.Elapsed(startTime, time.Now()), result.FilePath)
+
+	return nil
+}
+
+// recordHistory adds a downloaded song to the download history, and
+// describes its archived copy for the history of later forwards. Failures
+// are logged, since the song has already been delivered
+func (h *SongHandler) recordHistory(cmdCtx *CommandContext, result *downloader.DownloadResult) {
+	record := newHistoryRecord(cmdCtx, result.SongMeta, result.FileSize)
+	if meta := result.SongMeta; meta != nil && h.archiveChatID != 0 && h.archive != nil {
+		key := h.archiveKey(meta.AppleMusicID, meta.Codec, meta.Language)
+		if archived, ok := h.archive.Get(key); ok {
+			record.ArchiveMessageID = archived.Documents[0].MessageID
+			if err := h.archive.Describe(key, meta, result.FileSize); err != nil {
+				h.logger.Warn("Failed to describe archived song", logging.Err(err))
+			}
+		}
+	}
+	h.addHistory(record)
+}
+
+// recordArchivedHistory adds a song forwarded from the archive to the
+// download history
+func (h *SongHandler) recordArchivedHistory(cmdCtx *CommandContext, songID string, song ArchivedSong) {
+	record := newHistoryRecord(cmdCtx, song.Song, song.FileSize)
+	record.AppleMusicID = songID
+	record.ArchiveMessageID = song.Documents[0].MessageID
+	h.addHistory(record)
+}
+
+// newHistoryRecord returns the history record of a song of fileSize bytes
+// delivered for a request
+func newHistoryRecord(cmdCtx *CommandContext, meta *downloader.SongMetadata, fileSize int64) HistoryRecord {
+	record := HistoryRecord{
+		UserID:       cmdCtx.UserID,
+		ChatID:       cmdCtx.ChatID,
+		FileSize:     fileSize,
+		Quality:      meta.QualityLabel(),
+		DownloadedAt: time.Now(),
+	}
+	if meta != nil {
+		record.AppleMusicID, record.Title, record.Artist = meta.AppleMusicID, meta.Title, meta.Artist
+	}
+	return record
+}
+
+// addHistory adds a record to the download history, logging failures
+func (h *SongHandler) addHistory(record HistoryRecord) {
+	if err := h.history.Add(record); err != nil {
+		h.logger.Warn("Failed to record download history", logging.Err(err))
+	}
+}
+
+// cancellationError is the error of a request whose context ended during stage:
+// a timeout error when it ran too long, or a cancellation
+func cancellationError(ctx context.Context, stage string) error {
+	if errors.Is(context.Cause(ctx), ErrRequestTimeout) {
+		return downloader.NewDownloadErrorWithCause(downloader.ErrorTimeout, stage+" timed out", ErrRequestTimeout)
+	}
+	return fmt.Errorf("%s cancelled: %w", stage, ctx.Err())
+}
+
+// reportCancelled shows on the progress message why ctx ended the request with
+// err: the timeout error, that the user cancelled it, or that it was re-queued
+// when the bot is restarting, falling back to an error for reporters that
+// cannot show the last two
+func (h *SongHandler) reportCancelled(ctx context.Context, reporter downloader.ProgressReporter, err error) {
+	if downloader.IsDownloadError(err, downloader.ErrorTimeout) {
+		reporter.ReportError(err)
+		return
+	}
+	if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+		if interrupter, ok := reporter.(downloader.InterruptionReporter); ok {
+			interrupter.ReportInterrupted(restartingMessage)
+			return
+		}
+		reporter.ReportError(ErrShuttingDown)
+		return
+	}
+	if canceller, ok := reporter.(downloader.CancellationReporter); ok {
+		canceller.ReportCancelled()
+		return
+	}
+	reporter.ReportError(errors.New("cancelled by user"))
+}
+
+// reportFailure shows err on the progress message, or that the request is
+// tried again when the queue retries it
+func reportFailure(cmdCtx *CommandContext, reporter downloader.ProgressReporter, err error) {
+	if interrupter, ok := reporter.(downloader.InterruptionReporter); ok && retryable(cmdCtx.Attempt, cmdCtx.Attempts, err) {
+		interrupter.ReportInterrupted(retryingMessage(cmdCtx.Attempt, cmdCtx.Attempts, err))
+		return
+	}
+	reporter.ReportError(err)
+}
+
+// retryingMessage tells the user that the attempt-th of attempts tries failed
+// and the request is queued again
+func retryingMessage(attempt, attempts int, err error) string {
+	return fmt.Sprintf("⚠️ Attempt %d of %d failed: %s\n🔁 Trying again shortly…", attempt, attempts, failureReason(err))
+}
+
+// uploadResults returns what to upload for a download: the result itself, or
+// one result per part when the file was split
+func uploadResults(result *downloader.DownloadResult) []*downloader.DownloadResult {
+	if len(result.Parts) == 0 {
+		return []*downloader.DownloadResult{result}
+	}
+
+	uploads := make([]*downloader.DownloadResult, len(result.Parts))
+	for i, part := range result.Parts {
+		upload := *result
+		upload.FilePath = part.FilePath
+		upload.FileSize = part.FileSize
+		upload.Parts = nil
+		upload.PartIndex = i + 1
+		upload.PartCount = len(result.Parts)
+
+		if result.SongMeta != nil {
+			meta := *result.SongMeta
+			meta.Title = fmt.Sprintf("%s (Part %d/%d)", meta.Title, i+1, len(result.Parts))
+			meta.Duration = part.Duration
+			meta.DurationMillis = int(part.Duration.Milliseconds())
+			upload.SongMeta = &meta
+		}
+		uploads[i] = &upload
+	}
+	return uploads
+}
+
+// handlerOwnedReporter hands a reporter to a ProgressTracker without letting
+// the tracker stop it
+type handlerOwnedReporter struct {
+	downloader.ProgressReporter
+}
+
+// Stop is a no-op; the handler stops the reporter after reporting completion
+func (handlerOwnedReporter) Stop() {}
+
+// newDownloader returns the downloader for a single job. Each job gets its own
+// downloader from the manager so queue workers can download in parallel
+func (h *SongHandler) newDownloader() downloader.SongDownloader {
+	if h.downloader != nil {
+		return h.downloader
+	}
+	return h.manager.NewDownloader()
+}
+
+// telegramAPI returns the Telegram API used outside of the progress reporter
+func (h *SongHandler) telegramAPI() downloader.TelegramAPI {
+	if h.api != nil {
+		return h.api
+	}
+	if h.client == nil || h.client.GetClient() == nil {
 		return nil
 	}
+	return h.client.GetClient().API()
+}
 
-	// Upload the downloaded file to Telegram
-	if err := h.uploadFile(ctx, cmdCtx.ChatID, result); err != nil {
-		h.logger.Printf("Failed to upload file: %v", err)
-		reporter.ReportError(fmt.Errorf("failed to upload file: %w", err))
+// messages returns the sender of the handler's messages through
+// telegramAPI, nil before the bot client is created
+func (h *SongHandler) messages() *apiSender {
+	api := h.telegramAPI()
+	if api == nil {
 		return nil
 	}
+	return &apiSender{api: api, peers: h.client.Peers()}
+}
 
-	// Log successful processing with timing
-	processingTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed song download for user %d (took %v)",
-		cmdCtx.UserID, processingTime)
+// sender returns the MessageSender of the handler's messages. Without a
+// client it is the bot, which reports that it is not initialized
+func (h *SongHandler) sender() MessageSender {
+	if messages := h.messages(); messages != nil {
+		return messages
+	}
+	return h.client
+}
+
+// metrics returns where downloads, uploads and progress edits are counted
+func (h *SongHandler) metrics() downloader.Metrics {
+	if h.manager == nil {
+		return downloader.NoopMetrics{}
+	}
+	return h.manager.Metrics()
+}
+
+// SetMetrics makes downloads, uploads, progress edits and the queue report
+// their counters and timings to metrics
+func (h *SongHandler) SetMetrics(metrics downloader.Metrics) {
+	if h.manager != nil {
+		h.manager.SetMetrics(metrics)
+	}
+	h.queue.SetMetrics(metrics)
+}
+
+// uploadLimiter returns the limiter uploads share (nil when unlimited)
+func (h *SongHandler) uploadLimiter() *downloader.BandwidthLimiter {
+	if h.manager == nil {
+		return nil
+	}
+	return h.manager.UploadLimiter()
+}
+
+// revalidateURL re-runs URL extraction on the original command message (when it
+// was stored) and returns the URL to download, or a user-facing reason why the
+// request can no longer be processed
+func (h *SongHandler) revalidateURL(cmdCtx *CommandContext) (string, string) {
+	songURL := strings.TrimSpace(cmdCtx.Args)
+
+	// Requests without a stored message can only be checked as-is
+	if cmdCtx.MessageText == "" {
+		if ExtractURLMeta(songURL) == nil {
+			return "", "Please provide a valid Apple Music URL."
+		}
+		return songURL, ""
+	}
+
+	candidates := ExtractURLCandidates(cmdCtx.MessageText, cmdCtx.Entities)
+	if len(candidates) == 0 {
+		// Requests by track ID have no link, the message must still name the track
+		if queuedMeta := ExtractURLMeta(songURL); queuedMeta != nil && commandArgsTrackID(cmdCtx.MessageText) == queuedMeta.ID {
+			return songURL, ""
+		}
+		return "", "Your request could not be processed: the original message no longer contains a link."
+	}
+
+	// Links without a storefront get the one chosen when the request was queued
+	storefront := ""
+	queuedMeta := ExtractURLMeta(songURL)
+	if queuedMeta != nil && !queuedMeta.StorefrontInferred {
+		storefront = queuedMeta.Storefront
+	}
+	for i, candidate := range candidates {
+		// Geo links were resolved to the queued link
+		if IsGeoURL(candidate) && queuedMeta != nil {
+			candidates[i] = songURL
+			continue
+		}
+		if meta := ExtractURLMeta(candidate); meta != nil && meta.StorefrontInferred {
+			if storefront == "" {
+				storefront = meta.Storefront
+			}
+			candidates[i] = WithStorefront(candidate, storefront)
+		}
+	}
+
+	// Prefer the URL that was queued, then any other valid link in the message
+	for _, candidate := range candidates {
+		if candidate == songURL && ExtractURLMeta(candidate) != nil {
+			return candidate, ""
+		}
+	}
+	for _, candidate := range candidates {
+		if ExtractURLMeta(candidate) != nil {
+			return candidate, ""
+		}
+	}
+
+	return "", fmt.Sprintf("Your request could not be processed: %s is not a supported Apple Music URL.", candidates[0])
+}
+
+// commandArgsTrackID returns the track ID a command message asks for, or ""
+// when its arguments are not a valid track ID
+func commandArgsTrackID(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		fields = fields[1:]
+	}
+	args, _ := SplitAACFlag(strings.Join(fields, " "))
+	args, _ = SplitLanguageFlag(args)
+	fields = strings.Fields(args)
+	if _, meta := ParseSongInput(args, StorefrontHints{}); meta != nil && IsTrackIDInput(fields[0]) {
+		return meta.ID
+	}
+	return ""
+}
+
+// recordDelivery updates the delivery state of the request and reacts to the
+// original command message. Reaction failures are logged and otherwise ignored,
+// since the song has already been delivered at this point
+func (h *SongHandler) recordDelivery(ctx context.Context, api downloader.TelegramAPI, cmdCtx *CommandContext) {
+	if cmdCtx.Delivery != nil {
+		cmdCtx.Delivery.Uploaded = true
+		cmdCtx.Delivery.UploadedAt = time.Now()
+	}
+
+	if err := h.sendDeliveryReaction(ctx, api, cmdCtx.ChatID, cmdCtx.MessageID); err != nil {
+		h.logger.Debug("Skipping delivery reaction", logging.Int("Message", cmdCtx.MessageID), logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
+		return
+	}
+
+	if cmdCtx.Delivery != nil {
+		cmdCtx.Delivery.Reacted = true
+	}
+}
+
+// sendDeliveryReaction reacts to a message with the configured delivery emoji
+func (h *SongHandler) sendDeliveryReaction(ctx context.Context, api downloader.TelegramAPI, chatID int64, messageID int) error {
+	if api == nil {
+		return fmt.Errorf("telegram API is not available")
+	}
+	if messageID == 0 || h.deliveryReaction == "" {
+		return fmt.Errorf("no message to react to")
+	}
+
+	peer := h.client.InputPeer(chatID)
+
+	_, err := api.MessagesSendReaction(ctx, &tg.MessagesSendReactionRequest{
+		Peer:  peer,
+		MsgID: messageID,
+		Reaction: []tg.ReactionClass{
+			&tg.ReactionEmoji{Emoticon: h.deliveryReaction},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send reaction: %w", err)
+	}
 
 	return nil
 }
 
-// uploadFile uploads the downloaded file to Telegram as an audio file
-func (h *SongHandler) uploadFile(ctx context.Context, chatID int64, result *downloader.DownloadResult) error {
+// uploadFile uploads the downloaded file to Telegram as an audio file replying
+// to message replyTo (0 = none), passing upload progress to onProgress
+func (h *SongHandler) uploadFile(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
 	// Get file information
 	fileInfo, err := os.Stat(result.FilePath)
 	if err != nil {
@@ -205,12 +1154,13 @@ func (h *SongHandler) uploadFile(ctx context.Context, chatID int64, result *down
 		fileMime = "audio/mp4" // Default for M4A files
 	}
 
-	// Create caption with song ID from Apple Music
-	songID := "unknown"
-	if result.SongMeta != nil && result.SongMeta.AppleMusicID != "" {
-		songID = result.SongMeta.AppleMusicID
+	// Files Telegram would reject are not uploaded at all
+	if err := h.checkUploadSize(fileSize); err != nil {
+		return err
 	}
-	caption := fmt.Sprintf("song `%s`", songID)
+
+	// Create caption with song ID from Apple Music
+	caption := createUploadCaption(result, h.metadataWarning(chatID, result.MissingFields))
 
 	// Check if SongMeta is nil
 	if result.SongMeta == nil {
@@ -220,50 +1170,20 @@ func (h *SongHandler) uploadFile(ctx context.Context, chatID int64, result *down
 	// Convert duration to seconds from song metadata
 	durationSeconds := int(result.SongMeta.Duration.Seconds())
 
-	// Create upload progress reporter
-	uploadReporter := downloader.NewTelegramProgressReporter(h.client.GetClient().API())
-	uploadDisplayName := fmt.Sprintf("📤 %s", fileName)
-	if err := uploadReporter.StartTracking(ctx, chatID, uploadDisplayName); err != nil {
-		h.logger.Printf("Failed to start upload progress tracking: %v", err)
-		return fmt.Errorf("failed to start upload progress tracking: %w", err)
-	}
-	defer uploadReporter.Stop()
-
 	// Report upload phase start
-	uploadReporter.ReportPhaseChange(downloader.PhaseComplete, downloader.PhaseUploading)
-
-	// Show initial upload progress
-	uploadReporter.UpdateProgress(downloader.PhaseUploading, downloader.Progress{
+	onProgress(downloader.PhaseUploading, downloader.Progress{
 		BytesProcessed: 0,
 		TotalBytes:     fileSize,
 		Percentage:     0,
 	})
 
 	// Upload file with real progress tracking using gotd/td uploader
-	uploadStartTime := time.Now()
-	uploadedFile, err := h.uploadFileWithRealProgress(ctx, result.FilePath, fileSize, uploadReporter)
+	uploadedFile, err := h.uploadFileWithRealProgress(ctx, result.FilePath, fileSize, onProgress)
 	if err != nil {
-		uploadReporter.ReportError(fmt.Errorf("upload failed: %w", err))
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	// Report upload completion
-	uploadDuration := time.Since(uploadStartTime)
-	uploadReporter.UpdateProgress(downloader.PhaseComplete, downloader.Progress{
-		BytesProcessed: fileSize,
-		TotalBytes:     fileSize,
-		Speed:          int64(float64(fileSize) / uploadDuration.Seconds()),
-		Percentage:     100,
-	})
-	uploadReporter.ReportComplete(uploadDuration, fileName)
-
-	// Determine peer type for chat
-	var peer tg.InputPeerClass
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
+	peer := h.client.InputPeer(chatID)
 
 	// Create audio media with proper attributes
 	media := &tg.InputMediaUploadedDocument{
@@ -281,33 +1201,107 @@ func (h *SongHandler) uploadFile(ctx context.Context, chatID int64, result *down
 		},
 	}
 
-	// Send the audio using direct API call
-	_, err = h.client.GetClient().API().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
-		Peer:     peer,
-		Media:    media,
-		Message:  caption,
-		RandomID: time.Now().UnixNano(),
-	})
+	// Clients show the album art instead of a generic file icon
+	if thumb := h.uploadThumbnail(ctx, result.Thumbnail); thumb != nil {
+		media.SetThumb(thumb)
+	}
+
+	// Send the audio through the archive channel when there is one, so it
+	// can be forwarded to later requests, and directly otherwise
+	if api := h.archiveAPI(); api != nil {
+		err = h.sendThroughArchive(ctx, api, chatID, replyTo, result, media, caption)
+	} else {
+		err = downloader.SendReplying(replyTo, func(replyTo tg.InputReplyToClass) error {
+			_, err := h.client.GetClient().API().MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+				Peer:     peer,
+				Media:    media,
+				Message:  caption,
+				ReplyTo:  replyTo,
+				RandomID: time.Now().UnixNano(),
+			})
+			return err
+		})
+	}
 
 	if err != nil {
+		if downloader.IsMediaForbidden(err) {
+			return fmt.Errorf("chat does not allow sending audio: %w", err)
+		}
+		h.discardBrokenUpload(result.FilePath, err)
 		return fmt.Errorf("failed to send audio: %w", err)
 	}
 
-	// Delete the file after successful upload
-	if err := os.Remove(result.FilePath); err != nil {
-		h.logger.Printf("Warning: Failed to delete file after upload: %v", err)
-	} else {
-		h.logger.Printf("File deleted after successful upload: %s", result.FilePath)
+	if h.checkpoints != nil {
+		if err := h.checkpoints.Remove(result.FilePath); err != nil {
+			h.logger.Warn("Failed to remove upload checkpoint", logging.Err(err))
+		}
 	}
 
-	h.logger.Printf("Successfully uploaded audio file: %s - %s (%.2f seconds, %s)",
-		result.SongMeta.Artist, result.SongMeta.Title, result.SongMeta.Duration.Seconds(), h.formatBytes(fileSize))
+	// Delete the file after successful upload, unless another job for the
+	// same song still holds it
+	if deleted, err := downloader.ReleaseDownload(result.FilePath, true); err != nil {
+		h.logger.Warn("Failed to delete file after upload", logging.Err(err))
+	} else if deleted {
+		h.logger.Debug("File deleted after successful upload", logging.String("File", result.FilePath))
+	}
+
+	h.metrics().AddUploadBytes(fileSize)
+	h.logger.Info("Uploaded audio file", logging.String("Artist", result.SongMeta.Artist), logging.String("Title", result.SongMeta.Title),
+		logging.Duration("Duration", result.SongMeta.Duration), logging.String("Size", downloader.FormatBytes(fileSize)))
 
 	return nil
 }
 
+// uploadThumbnail uploads the JPEG thumbnail of a song. It returns nil when
+// there is none or the upload failed, the song is then sent without one
+func (h *SongHandler) uploadThumbnail(ctx context.Context, thumbnail []byte) tg.InputFileClass {
+	if len(thumbnail) == 0 {
+		return nil
+	}
+
+	thumb, err := uploader.NewUploader(h.client.GetClient().API()).FromBytes(ctx, "thumb.jpg", thumbnail)
+	if err != nil {
+		h.logger.Warn("Failed to upload thumbnail", logging.Err(err))
+		return nil
+	}
+	return thumb
+}
+
+// createUploadCaption creates the caption of an uploaded song: its Apple
+// Music ID, the part number of split songs, the audio quality and artwork
+// size when known and an optional warning line
+func createUploadCaption(result *downloader.DownloadResult, warning string) string {
+	songID := "unknown"
+	if result.SongMeta != nil && result.SongMeta.AppleMusicID != "" {
+		songID = result.SongMeta.AppleMusicID
+	}
+	caption := fmt.Sprintf("song `%s`", songID)
+	if result.PartCount > 1 {
+		caption += fmt.Sprintf(" · part %d of %d", result.PartIndex, result.PartCount)
+	}
+	var details []string
+	if quality := result.SongMeta.QualityLabel(); quality != "" {
+		details = append(details, quality)
+	}
+	if result.ArtworkWidth > 0 && result.ArtworkHeight > 0 {
+		details = append(details, fmt.Sprintf("🖼️ %d×%d", result.ArtworkWidth, result.ArtworkHeight))
+	}
+	if len(details) > 0 {
+		caption += "\n" + strings.Join(details, " · ")
+	}
+	if warning != "" {
+		caption += "\n" + warning
+	}
+	return caption
+}
+
 // uploadFileWithRealProgress uploads a file with actual progress tracking using gotd/td
-func (h *SongHandler) uploadFileWithRealProgress(ctx context.Context, filePath string, fileSize int64, reporter downloader.ProgressReporter) (tg.InputFileClass, error) {
+func (h *SongHandler) uploadFileWithRealProgress(ctx context.Context, filePath string, fileSize int64, onProgress func(downloader.Phase, downloader.Progress)) (tg.InputFileClass, error) {
+	// Big files go part by part so a restart does not start them over
+	if h.checkpoints != nil && fileSize >= ResumableUploadThreshold {
+		return h.uploadResumable(ctx, h.client.GetClient().API(), filePath, onProgress)
+	}
+
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -317,15 +1311,15 @@ func (h *SongHandler) uploadFileWithRealProgress(ctx context.Context, filePath s
 
 	// Create a progress reader that tracks actual bytes read
 	progressReader := &UploadProgressReader{
-		reader:     file,
+		reader:     h.uploadLimiter().Reader(ctx, file),
 		totalSize:  fileSize,
-		reporter:   reporter,
+		onProgress: onProgress,
 		startTime:  time.Now(),
 		lastUpdate: time.Now(),
 	}
 
-	// Use gotd/td uploader with our progress reader
-	u := uploader.NewUploader(h.client.GetClient().API())
+	// Use gotd/td uploader with our progress reader, retrying failed parts
+	u := uploader.NewUploader(h.uploadClient(h.client.GetClient().API())).WithPartSize(h.partSize(fileSize))
 	fileName := filepath.Base(filePath)
 
 	// Upload using FromReader which will call our Read method
@@ -334,10 +1328,10 @@ func (h *SongHandler) uploadFileWithRealProgress(ctx context.Context, filePath s
 
 // UploadProgressReader wraps a file reader to track actual upload progress
 type UploadProgressReader struct {
-	reader     *os.File
+	reader     io.Reader
 	totalSize  int64
 	bytesRead  int64
-	reporter   downloader.ProgressReporter
+	onProgress func(downloader.Phase, downloader.Progress)
 	startTime  time.Time
 	lastUpdate time.Time
 	mu         sync.Mutex
@@ -372,21 +1366,17 @@ func (upr *UploadProgressReader) updateProgress(bytesRead int64, now time.Time)
 		percentage = 100
 	}
 
-	elapsed := now.Sub(upr.startTime)
+	elapsed := downloader.Elapsed(upr.startTime, now)
 	var speed int64
 	if elapsed.Seconds() > 0 {
 		speed = int64(float64(bytesRead) / elapsed.Seconds())
 	}
 
-	var eta time.Duration
-	if speed > 0 && bytesRead < upr.totalSize {
-		remaining := upr.totalSize - bytesRead
-		eta = time.Duration(float64(remaining)/float64(speed)) * time.Second
-	}
+	eta := downloader.EstimateETA(upr.totalSize-bytesRead, speed)
 
 	// Report progress to Telegram
-	if upr.reporter != nil {
-		upr.reporter.UpdateProgress(downloader.PhaseUploading, downloader.Progress{
+	if upr.onProgress != nil {
+		upr.onProgress(downloader.PhaseUploading, downloader.Progress{
 			BytesProcessed: bytesRead,
 			TotalBytes:     upr.totalSize,
 			Speed:          speed,
@@ -404,57 +1394,7 @@ func getStringOrDefault(value, defaultValue string) string {
 	return value
 }
 
-// formatBytes formats byte count into human-readable format
-func (h *SongHandler) formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-
-	units := []string{"KB", "MB", "GB", "TB"}
-	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
-}
-
 // sendErrorMessage sends an error message to the user
 func (h *SongHandler) sendErrorMessage(ctx context.Context, chatID int64, errorMsg string) error {
-	return h.sendMessage(ctx, chatID, "❌ "+errorMsg)
-}
-
-// sendMessage sends a text message to the specified chat
-func (h *SongHandler) sendMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
-	}
-
-	// For bot API, we need to determine the correct peer type
-	var peer tg.InputPeerClass
-
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-
-	// Create the message request
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  message,
-		RandomID: time.Now().UnixNano(),
-	}
-
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send message via Telegram API: %w", err)
-	}
-
-	return nil
+	return h.sender().SendMarkdown(ctx, chatID, "❌ "+errorMsg)
 }
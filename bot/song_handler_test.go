@@ -2,14 +2,23 @@ package bot
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
 )
 
 func TestSongHandler_Command(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewSongHandler(nil, logger)
 	
 	expected := "song"
@@ -42,7 +51,7 @@ func TestSongHandler_URLParsing(t *testing.T) {
 }
 
 func TestSongHandler_Handle_NoURL(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewSongHandler(nil, logger)
 	
 	// Create test command context without URL
@@ -64,7 +73,7 @@ func TestSongHandler_Handle_NoURL(t *testing.T) {
 }
 
 func TestSongHandler_Handle_WithURL(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewSongHandler(nil, logger)
 	
 	// Create test command context with valid URL
@@ -85,3 +94,867 @@ func TestSongHandler_Handle_WithURL(t *testing.T) {
 	}
 }
 
+
+func TestSongHandler_RevalidateURL(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+
+	songURL := "https://music.apple.com/in/song/never-gonna-give-you-up/1559523359"
+
+	testCases := []struct {
+		name           string
+		cmdCtx         *CommandContext
+		expectedURL    string
+		expectedReason string
+	}{
+		{
+			name:        "no stored message, valid args",
+			cmdCtx:      &CommandContext{Args: songURL},
+			expectedURL: songURL,
+		},
+		{
+			name:           "no stored message, invalid args",
+			cmdCtx:         &CommandContext{Args: "https://spotify.com/track/123"},
+			expectedReason: "Please provide a valid Apple Music URL.",
+		},
+		{
+			name: "stored message still contains the URL",
+			cmdCtx: &CommandContext{
+				Args:        songURL,
+				MessageText: "/song " + songURL,
+			},
+			expectedURL: songURL,
+		},
+		{
+			name: "stored message with hidden text link",
+			cmdCtx: &CommandContext{
+				Args:        songURL,
+				MessageText: "/song this one",
+				Entities: []tg.MessageEntityClass{
+					&tg.MessageEntityTextURL{Offset: 6, Length: 8, URL: songURL},
+				},
+			},
+			expectedURL: songURL,
+		},
+		{
+			name: "stored message without any link",
+			cmdCtx: &CommandContext{
+				Args:        songURL,
+				MessageText: "/song",
+			},
+			expectedReason: "the original message no longer contains a link",
+		},
+		{
+			name: "stored message with unsupported link",
+			cmdCtx: &CommandContext{
+				Args:        songURL,
+				MessageText: "/song https://spotify.com/track/123",
+			},
+			expectedReason: "https://spotify.com/track/123 is not a supported Apple Music URL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotURL, reason := handler.revalidateURL(tc.cmdCtx)
+
+			if gotURL != tc.expectedURL {
+				t.Errorf("URL = %q, want %q", gotURL, tc.expectedURL)
+			}
+
+			if tc.expectedReason == "" && reason != "" {
+				t.Errorf("Expected no failure reason, got %q", reason)
+			}
+
+			if tc.expectedReason != "" && !strings.Contains(reason, tc.expectedReason) {
+				t.Errorf("Reason = %q, want it to contain %q", reason, tc.expectedReason)
+			}
+		})
+	}
+}
+
+// mockReactionAPI records reactions, messages and edits sent by the song handler
+type mockReactionAPI struct {
+	reactions []*tg.MessagesSendReactionRequest
+	sends     []*tg.MessagesSendMessageRequest
+	edits     []*tg.MessagesEditMessageRequest
+	err       error
+}
+
+func (m *mockReactionAPI) MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error) {
+	m.sends = append(m.sends, request)
+	return &tg.Updates{}, nil
+}
+
+func (m *mockReactionAPI) MessagesEditMessage(ctx context.Context, request *tg.MessagesEditMessageRequest) (tg.UpdatesClass, error) {
+	m.edits = append(m.edits, request)
+	return &tg.Updates{}, nil
+}
+
+func (m *mockReactionAPI) MessagesSendReaction(ctx context.Context, request *tg.MessagesSendReactionRequest) (tg.UpdatesClass, error) {
+	m.reactions = append(m.reactions, request)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &tg.Updates{}, nil
+}
+
+func TestSongHandler_RecordDelivery(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+
+	t.Run("reacts to the original message", func(t *testing.T) {
+		api := &mockReactionAPI{}
+		delivery := &DeliveryState{}
+		cmdCtx := &CommandContext{ChatID: 12345, MessageID: 42, Delivery: delivery}
+
+		handler.recordDelivery(context.Background(), api, cmdCtx)
+
+		if len(api.reactions) != 1 {
+			t.Fatalf("Expected 1 reaction, got %d", len(api.reactions))
+		}
+		if api.reactions[0].MsgID != 42 {
+			t.Errorf("Expected reaction on message 42, got %d", api.reactions[0].MsgID)
+		}
+
+		emoji, ok := api.reactions[0].Reaction[0].(*tg.ReactionEmoji)
+		if !ok || emoji.Emoticon != handler.deliveryReaction {
+			t.Errorf("Expected %q reaction, got %v", handler.deliveryReaction, api.reactions[0].Reaction)
+		}
+
+		if !delivery.Uploaded || !delivery.Reacted {
+			t.Errorf("Expected uploaded and reacted delivery state, got %+v", delivery)
+		}
+	})
+
+	t.Run("reaction failure keeps the request delivered", func(t *testing.T) {
+		api := &mockReactionAPI{err: fmt.Errorf("rpc error code 400: REACTION_INVALID")}
+		delivery := &DeliveryState{}
+		cmdCtx := &CommandContext{ChatID: -100, MessageID: 7, Delivery: delivery}
+
+		handler.recordDelivery(context.Background(), api, cmdCtx)
+
+		if len(api.reactions) != 1 || api.reactions[0].MsgID != 7 {
+			t.Fatalf("Expected a reaction attempt on message 7, got %v", api.reactions)
+		}
+		if !delivery.Uploaded {
+			t.Error("Expected request to stay marked as uploaded")
+		}
+		if delivery.Reacted {
+			t.Error("Expected request not to be marked as reacted")
+		}
+	})
+
+	t.Run("requests without delivery tracking", func(t *testing.T) {
+		api := &mockReactionAPI{}
+		cmdCtx := &CommandContext{ChatID: 12345, MessageID: 1}
+
+		handler.recordDelivery(context.Background(), api, cmdCtx)
+
+		if len(api.reactions) != 1 {
+			t.Errorf("Expected 1 reaction, got %d", len(api.reactions))
+		}
+	})
+}
+
+func TestSongHandler_RevalidateURL_InferredStorefront(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+
+	// The request was queued with the inferred storefront filled in
+	cmdCtx := &CommandContext{
+		Args:        "https://music.apple.com/jp/album/test/123?i=456",
+		MessageText: "/song https://music.apple.com/album/test/123?i=456",
+	}
+
+	gotURL, reason := handler.revalidateURL(cmdCtx)
+	if reason != "" {
+		t.Fatalf("Unexpected failure: %s", reason)
+	}
+	if gotURL != cmdCtx.Args {
+		t.Errorf("Expected %q, got %q", cmdCtx.Args, gotURL)
+	}
+}
+
+func TestSongHandler_RevalidateURL_TrackID(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+
+	cmdCtx := &CommandContext{
+		Args:        SongURL("us", "1559523359"),
+		MessageText: "/song 1559523359 us",
+	}
+	if gotURL, reason := handler.revalidateURL(cmdCtx); reason != "" || gotURL != cmdCtx.Args {
+		t.Errorf("Expected %q, got %q (%s)", cmdCtx.Args, gotURL, reason)
+	}
+
+	cmdCtx.MessageText = "/song 1559523360 us"
+	if _, reason := handler.revalidateURL(cmdCtx); !strings.Contains(reason, "no longer contains a link") {
+		t.Errorf("Expected an edited track ID to be rejected, got %q", reason)
+	}
+}
+
+func TestSongHandler_RevalidateURL_GeoLink(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+
+	// The geo link of the message was resolved to the queued link
+	cmdCtx := &CommandContext{
+		Args:        "https://music.apple.com/gb/album/_/1559523357?i=1559523359",
+		MessageText: "/song https://geo.music.apple.com/album/_/1559523357?i=1559523359",
+	}
+	if gotURL, reason := handler.revalidateURL(cmdCtx); reason != "" || gotURL != cmdCtx.Args {
+		t.Errorf("Expected %q, got %q (%s)", cmdCtx.Args, gotURL, reason)
+	}
+}
+
+func TestSongHandler_LibraryURL(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	api := &mockReactionAPI{}
+	handler.api = api
+
+	err := handler.Handle(context.Background(), &CommandContext{
+		UserID: 1,
+		ChatID: 100,
+		Args:   "https://music.apple.com/library/playlist/p.vMO5kRQiX1xGMr",
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if len(api.sends) != 1 || api.sends[0].Message != "❌ Library playlists are private and cannot be fetched." {
+		t.Errorf("Expected the library error, got %+v", api.sends)
+	}
+	if size := handler.GetQueue().GetQueueSize(); size != 0 {
+		t.Errorf("Expected nothing queued, got %d requests", size)
+	}
+}
+
+func TestSongHandler_StorefrontHints(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewSongHandler(nil, logger)
+	handler.GetStorefronts().Set(100, "fr")
+
+	hints := handler.storefrontHints(&CommandContext{ChatID: 100, LanguageCode: "de"})
+	if hints.ChatStorefront != "fr" || hints.LanguageCode != "de" || hints.Default != DefaultStorefront {
+		t.Errorf("Unexpected hints: %+v", hints)
+	}
+
+	notice := storefrontNotice("us")
+	if !strings.Contains(notice, "Assuming US store") || !strings.Contains(notice, "/us/") {
+		t.Errorf("Unexpected notice: %q", notice)
+	}
+}
+
+// recordingReporter is a ProgressReporter recording the calls it receives
+type recordingReporter struct {
+	mu        sync.Mutex
+	started   int
+	completes []time.Duration
+	errors    []error
+	phases    []downloader.Phase
+	stopped   int
+	note      string
+	names     []string
+
+	interruptions []string
+}
+
+func (r *recordingReporter) StartTracking(ctx context.Context, chatID int64, songName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started++
+	return nil
+}
+
+func (r *recordingReporter) UpdateProgress(phase downloader.Phase, progress downloader.Progress) error {
+	return nil
+}
+
+func (r *recordingReporter) ReportPhaseChange(oldPhase, newPhase downloader.Phase) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phases = append(r.phases, newPhase)
+	return nil
+}
+
+func (r *recordingReporter) ReportError(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, err)
+	return nil
+}
+
+func (r *recordingReporter) ReportComplete(duration time.Duration, filePath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completes = append(r.completes, duration)
+	return nil
+}
+
+func (r *recordingReporter) UpdateSongName(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, name)
+}
+
+func (r *recordingReporter) SetCompletionNote(note string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.note = note
+}
+
+func (r *recordingReporter) ReportInterrupted(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interruptions = append(r.interruptions, reason)
+	return nil
+}
+
+func (r *recordingReporter) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped++
+}
+
+// scriptedDownloader replays the callbacks of one download path
+type scriptedDownloader struct {
+	phases []downloader.Phase // Phases entered before completing
+	err    error
+
+	filePath string                   // Result file, downloads/song.m4a when empty
+	meta     *downloader.SongMetadata // Reported before the first phase (nil = none)
+	parts    []downloader.SplitPart
+	missing  []string
+}
+
+func (d *scriptedDownloader) Download(ctx context.Context, url string, callbacks downloader.ProgressCallbacks) (*downloader.DownloadResult, error) {
+	if d.meta != nil && callbacks.OnMetadata != nil {
+		callbacks.OnMetadata(d.meta)
+	}
+	previous := downloader.PhaseValidating
+	for _, phase := range d.phases {
+		callbacks.OnPhaseChange(previous, phase)
+		previous = phase
+	}
+	if d.err != nil {
+		callbacks.OnError(d.err)
+		return nil, d.err
+	}
+
+	result := &downloader.DownloadResult{FilePath: "downloads/song.m4a", SongMeta: &downloader.SongMetadata{}, Parts: d.parts, MissingFields: d.missing}
+	if d.filePath != "" {
+		result.FilePath = d.filePath
+	}
+	callbacks.OnPhaseChange(previous, downloader.PhaseComplete)
+	callbacks.OnComplete(result)
+	return result, nil
+}
+
+func (d *scriptedDownloader) Cancel(ctx context.Context) error { return nil }
+
+func (d *scriptedDownloader) GetStatus() downloader.DownloadStatus { return downloader.DownloadStatus{} }
+
+func TestSongHandler_RunDownload_CompletionReportedOnce(t *testing.T) {
+	const uploadTime = 20 * time.Millisecond
+
+	testCases := []struct {
+		name       string
+		downloader *scriptedDownloader
+		uploadErr  error
+		wantErr    bool
+	}{
+		{
+			name: "fresh download",
+			downloader: &scriptedDownloader{phases: []downloader.Phase{
+				downloader.PhaseDownloading, downloader.PhaseDecrypting, downloader.PhaseWriting,
+			}},
+		},
+		{
+			name:       "cached file",
+			downloader: &scriptedDownloader{},
+		},
+		{
+			name:       "upload failure",
+			downloader: &scriptedDownloader{},
+			uploadErr:  fmt.Errorf("connection reset"),
+			wantErr:    true,
+		},
+		{
+			name: "download failure",
+			downloader: &scriptedDownloader{
+				phases: []downloader.Phase{downloader.PhaseDownloading},
+				err:    downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "ALAC format not available"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewSongHandler(nil, logging.Discard())
+			uploads := 0
+			handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+				uploads++
+				if replyTo != 7 {
+					t.Errorf("Expected the upload to reply to message 7, got %d", replyTo)
+				}
+				onProgress(downloader.PhaseUploading, downloader.Progress{TotalBytes: 100, BytesProcessed: 100, Percentage: 100})
+				time.Sleep(uploadTime)
+				return tc.uploadErr
+			}
+
+			reporter := &recordingReporter{}
+			startTime := time.Now().Add(-time.Second) // Time already spent in the queue worker
+			err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1, MessageID: 7}, "https://music.apple.com/us/song/x/1", tc.downloader, reporter, startTime)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if reporter.started != 1 || reporter.stopped != 1 {
+				t.Errorf("Expected tracking to start and stop once, got %d starts and %d stops", reporter.started, reporter.stopped)
+			}
+
+			records, _ := handler.history.Recent(0, historyLimit)
+			if tc.wantErr {
+				if len(records) != 0 {
+					t.Errorf("Expected a failed request not to be recorded, got %+v", records)
+				}
+				if len(reporter.completes) != 0 {
+					t.Errorf("Expected no completion for a failed request, got %d", len(reporter.completes))
+				}
+				if len(reporter.errors) != 1 {
+					t.Errorf("Expected the failure to be reported once, got %d", len(reporter.errors))
+				}
+				return
+			}
+
+			if uploads != 1 {
+				t.Errorf("Expected 1 upload, got %d", uploads)
+			}
+			if len(records) != 1 || records[0].ChatID != 1 {
+				t.Errorf("Expected the delivery to be recorded in the download history, got %+v", records)
+			}
+			if len(reporter.completes) != 1 {
+				t.Fatalf("Expected exactly 1 completion, got %d", len(reporter.completes))
+			}
+			// The completion covers the whole request, including the upload
+			if duration := reporter.completes[0]; duration < time.Second+uploadTime {
+				t.Errorf("Expected completion duration to span the whole request, got %v", duration)
+			}
+		})
+	}
+}
+
+func TestSongHandler_RunDownload_NamesSong(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+		return nil
+	}
+
+	reporter := &recordingReporter{}
+	songDownloader := &scriptedDownloader{
+		phases: []downloader.Phase{downloader.PhaseDownloading},
+		meta:   &downloader.SongMetadata{Title: "Song", Artist: "Artist"},
+	}
+	if err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1}, "https://music.apple.com/us/song/x/1", songDownloader, reporter, time.Now()); err != nil {
+		t.Fatalf("runDownload() error = %v", err)
+	}
+
+	if len(reporter.names) != 1 || reporter.names[0] != "Song - Artist" {
+		t.Errorf("Expected the progress message to be named after the song, got %q", reporter.names)
+	}
+}
+
+// slowDownloader is a download that runs until its context is cancelled
+type slowDownloader struct {
+	started chan struct{}
+}
+
+func (d *slowDownloader) Download(ctx context.Context, url string, callbacks downloader.ProgressCallbacks) (*downloader.DownloadResult, error) {
+	callbacks.OnPhaseChange(downloader.PhaseValidating, downloader.PhaseDecrypting)
+	close(d.started)
+	<-ctx.Done()
+	return nil, downloader.NewDownloadErrorWithCause(downloader.ErrorCancelled, "download cancelled", ctx.Err())
+}
+
+func (d *slowDownloader) Cancel(ctx context.Context) error { return nil }
+
+func (d *slowDownloader) GetStatus() downloader.DownloadStatus { return downloader.DownloadStatus{} }
+
+func TestSongHandler_ShutdownDuringDownload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	handler := NewSongHandler(nil, logging.Discard())
+	reporter := &recordingReporter{}
+	songDownloader := &slowDownloader{started: make(chan struct{})}
+
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.requestDelay = 0
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		return handler.runDownload(ctx, cmdCtx, cmdCtx.Args, songDownloader, reporter, time.Now())
+	}
+	if _, err := queue.EnableRequestPersistence(path, time.Hour); err != nil {
+		t.Fatalf("EnableRequestPersistence() error = %v", err)
+	}
+	request, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/us/song/x/1")
+	if err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+	<-songDownloader.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := queue.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the download to be interrupted, got %v", err)
+	}
+
+	// The user is told about the restart instead of a cancellation
+	reporter.mu.Lock()
+	if len(reporter.interruptions) != 1 || reporter.interruptions[0] != restartingMessage {
+		t.Errorf("Expected the restart to be reported, got %q", reporter.interruptions)
+	}
+	if len(reporter.errors) != 0 {
+		t.Errorf("Expected no error to be reported, got %v", reporter.errors)
+	}
+	reporter.mu.Unlock()
+
+	// The request is saved for the next start
+	saved, err := LoadQueuedRequests(path)
+	if err != nil {
+		t.Fatalf("LoadQueuedRequests() error = %v", err)
+	}
+	if len(saved) != 1 || saved[0].UniqueID != request.UniqueID || saved[0].Status != StatusQueued {
+		t.Errorf("Expected the interrupted request to be saved as queued, got %+v", saved)
+	}
+	if failed := queue.Failed().List(2, 1); len(failed) != 0 {
+		t.Errorf("Expected the interrupted request not to count as failed, got %d", len(failed))
+	}
+}
+
+func TestSongHandler_RequestTimeout(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+		return nil
+	}
+	reporter := &recordingReporter{}
+
+	// The first download hangs until it is cancelled, the second completes
+	stuck := &slowDownloader{started: make(chan struct{})}
+	completed := make(chan string, 1)
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.requestDelay = 0
+	queue.SetRequestTimeout(20 * time.Millisecond)
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		if cmdCtx.MessageID == 1 {
+			return handler.runDownload(ctx, cmdCtx, cmdCtx.Args, stuck, reporter, time.Now())
+		}
+		err := handler.runDownload(ctx, cmdCtx, cmdCtx.Args, &scriptedDownloader{}, &recordingReporter{}, time.Now())
+		completed <- cmdCtx.RequestID
+		return err
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := queue.AddRequest(int64(i), 2, i, fmt.Sprintf("https://music.apple.com/us/song/x/%d", i)); err != nil {
+			t.Fatalf("AddRequest() error = %v", err)
+		}
+	}
+
+	select {
+	case id := <-completed:
+		if id != GenerateUniqueID(2, 2, 2) {
+			t.Errorf("Expected the second request to complete, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the queue to move on")
+	}
+
+	// The stuck request failed with a timeout, reported on its progress message
+	failed := queue.Failed().List(2, 1)
+	if len(failed) != 1 || failed[0].Request.UniqueID != GenerateUniqueID(1, 2, 1) {
+		t.Fatalf("Expected the stuck request to be retryable, got %+v", failed)
+	}
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	if len(reporter.errors) != 1 || !downloader.IsDownloadError(reporter.errors[0], downloader.ErrorTimeout) {
+		t.Errorf("Expected a timeout error to be reported, got %v", reporter.errors)
+	}
+}
+
+func TestSongHandler_RunDownload_UploadsSplitParts(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "song.m4a")
+	if err := os.WriteFile(original, []byte("whole song"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	handler := NewSongHandler(nil, logging.Discard())
+	var uploaded []*downloader.DownloadResult
+	handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+		uploaded = append(uploaded, result)
+		return nil
+	}
+
+	songDownloader := &scriptedDownloader{filePath: original, parts: []downloader.SplitPart{
+		{FilePath: filepath.Join(dir, "song (Part 1 of 2).m4a"), Duration: 40 * time.Minute},
+		{FilePath: filepath.Join(dir, "song (Part 2 of 2).m4a"), Duration: 35 * time.Minute},
+	}}
+	reporter := &recordingReporter{}
+	if err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1}, "https://music.apple.com/us/song/x/1", songDownloader, reporter, time.Now()); err != nil {
+		t.Fatalf("runDownload failed: %v", err)
+	}
+
+	if len(uploaded) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(uploaded))
+	}
+	for i, upload := range uploaded {
+		if upload.FilePath != songDownloader.parts[i].FilePath || upload.PartIndex != i+1 || upload.PartCount != 2 {
+			t.Errorf("Upload %d = %s (part %d of %d)", i+1, upload.FilePath, upload.PartIndex, upload.PartCount)
+		}
+		if upload.SongMeta.Duration != songDownloader.parts[i].Duration {
+			t.Errorf("Upload %d has duration %v, want %v", i+1, upload.SongMeta.Duration, songDownloader.parts[i].Duration)
+		}
+	}
+	if !strings.Contains(uploaded[1].SongMeta.Title, "(Part 2/2)") {
+		t.Errorf("Expected the part number in the title, got %q", uploaded[1].SongMeta.Title)
+	}
+
+	if len(reporter.completes) != 1 || !strings.Contains(reporter.note, "Split into 2 parts") {
+		t.Errorf("Expected one completion noting the split, got %d completions and note %q", len(reporter.completes), reporter.note)
+	}
+	if _, err := os.Stat(original); !os.IsNotExist(err) {
+		t.Errorf("Expected the unsplit file to be removed, got %v", err)
+	}
+}
+
+// storefrontDownloader fails in the unavailable storefronts and records the
+// URLs it was asked to download
+type storefrontDownloader struct {
+	unavailable map[string]bool
+	err         error // Returned instead of the storefront failure when set
+	urls        []string
+}
+
+func (d *storefrontDownloader) Download(ctx context.Context, url string, callbacks downloader.ProgressCallbacks) (*downloader.DownloadResult, error) {
+	d.urls = append(d.urls, url)
+
+	if meta := ExtractURLMeta(url); meta != nil && d.unavailable[meta.Storefront] {
+		err := d.err
+		if err == nil {
+			err = downloader.NewDownloadErrorWithCause(downloader.ErrorNetworkFailure, "failed to get song metadata", downloader.ErrNotInStorefront)
+		}
+		callbacks.OnError(err)
+		return nil, err
+	}
+
+	return &downloader.DownloadResult{FilePath: "downloads/song.m4a", SongMeta: &downloader.SongMetadata{}}, nil
+}
+
+func (d *storefrontDownloader) Cancel(ctx context.Context) error { return nil }
+
+func (d *storefrontDownloader) GetStatus() downloader.DownloadStatus { return downloader.DownloadStatus{} }
+
+func TestSongHandler_RunDownload_StorefrontFallback(t *testing.T) {
+	testCases := []struct {
+		name        string
+		unavailable map[string]bool
+		err         error
+		wantURLs    []string
+		wantScores  string
+		wantErr     bool
+	}{
+		{
+			name:       "requested storefront works",
+			wantURLs:   []string{"https://music.apple.com/us/song/x/1"},
+			wantScores: "us 1.00",
+		},
+		{
+			name:        "falls back to the next storefront",
+			unavailable: map[string]bool{"us": true},
+			wantURLs:    []string{"https://music.apple.com/us/song/x/1", "https://music.apple.com/gb/song/x/1"},
+			wantScores:  "gb 1.00, us 0.70",
+		},
+		{
+			name:        "unavailable everywhere",
+			unavailable: map[string]bool{"us": true, "gb": true, "jp": true},
+			wantURLs:    []string{"https://music.apple.com/us/song/x/1", "https://music.apple.com/gb/song/x/1", "https://music.apple.com/jp/song/x/1"},
+			wantScores:  "gb 0.70, jp 0.70, us 0.70",
+			wantErr:     true,
+		},
+		{
+			name:        "other failures are not retried elsewhere",
+			unavailable: map[string]bool{"us": true},
+			err:         downloader.NewDownloadError(downloader.ErrorFileSystemError, "failed to write M4A file"),
+			wantURLs:    []string{"https://music.apple.com/us/song/x/1"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewSongHandler(nil, logging.Discard())
+			handler.manager.SetFallbackStorefronts([]string{"gb", "jp"})
+			handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+				return nil
+			}
+
+			songDownloader := &storefrontDownloader{unavailable: tc.unavailable, err: tc.err}
+			reporter := &recordingReporter{}
+			err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1}, "https://music.apple.com/us/song/x/1", songDownloader, reporter, time.Now())
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if strings.Join(songDownloader.urls, " ") != strings.Join(tc.wantURLs, " ") {
+				t.Errorf("Expected URLs %v, got %v", tc.wantURLs, songDownloader.urls)
+			}
+
+			// Only the final outcome reaches the user
+			if tc.wantErr && (len(reporter.errors) != 1 || len(reporter.completes) != 0) {
+				t.Errorf("Expected 1 error and no completion, got %d errors and %d completions", len(reporter.errors), len(reporter.completes))
+			}
+			if !tc.wantErr && (len(reporter.errors) != 0 || len(reporter.completes) != 1) {
+				t.Errorf("Expected 1 completion and no errors, got %d errors and %d completions", len(reporter.errors), len(reporter.completes))
+			}
+
+			if got := formatStorefrontScores(handler.manager.StorefrontScores()); got != tc.wantScores {
+				t.Errorf("Expected storefront health %q, got %q", tc.wantScores, got)
+			}
+		})
+	}
+}
+
+func TestUploadProgressReader_ClockSkew(t *testing.T) {
+	now := time.Now().Round(0)
+
+	testCases := []struct {
+		name      string
+		startTime time.Time
+		wantSpeed bool
+	}{
+		{name: "start after a step back", startTime: now.Add(time.Hour)},
+		{name: "start long ago", startTime: now.Add(-10 * 24 * time.Hour), wantSpeed: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reported downloader.Progress
+			reader := &UploadProgressReader{
+				totalSize:  100 << 30,
+				startTime:  tc.startTime,
+				onProgress: func(phase downloader.Phase, progress downloader.Progress) { reported = progress },
+			}
+			reader.updateProgress(1<<30, now)
+
+			if reported.Speed < 0 || reported.ETA < 0 {
+				t.Fatalf("Expected no negative speed or ETA, got %d B/s and %v", reported.Speed, reported.ETA)
+			}
+			if (reported.Speed > 0) != tc.wantSpeed {
+				t.Errorf("Expected speed known %v, got %d B/s", tc.wantSpeed, reported.Speed)
+			}
+			if got := downloader.FormatDuration(reported.ETA); tc.wantSpeed && got != ">24h" {
+				t.Errorf("Expected the ETA to render as >24h, got %q", got)
+			}
+		})
+	}
+}
+
+func TestSongHandler_PreflightRefusesBeforeQueueing(t *testing.T) {
+	logger := logging.Discard()
+	handler := NewSongHandler(nil, logger)
+	handler.queue.workers = 0
+
+	var checked string
+	handler.preflight = func(ctx context.Context, url string) error {
+		checked = url
+		return downloader.NewDownloadError(downloader.ErrorBackendUnavailable, "Decryptor (DEC_URL) is unavailable")
+	}
+
+	songURL := "https://music.apple.com/in/song/test/123"
+	handler.addToQueue(context.Background(), &CommandContext{UserID: 1, ChatID: 2, MessageID: 3}, songURL, "")
+	if checked != songURL {
+		t.Errorf("Expected the pre-flight check of %s, got %q", songURL, checked)
+	}
+	if size := handler.queue.GetQueueSize(); size != 0 {
+		t.Errorf("Expected nothing to be queued, got %d requests", size)
+	}
+
+	handler.preflight = func(ctx context.Context, url string) error { return nil }
+	handler.addToQueue(context.Background(), &CommandContext{UserID: 1, ChatID: 2, MessageID: 3}, songURL, "")
+	if size := handler.queue.GetQueueSize(); size != 1 {
+		t.Errorf("Expected the request to be queued after a passing check, got %d requests", size)
+	}
+}
+
+func TestSongHandler_HandleRateLimited(t *testing.T) {
+	logger := logging.Discard()
+	handler := NewSongHandler(nil, logger)
+	handler.queue.workers = 0
+	handler.limiter = NewRateLimiter(1, 0)
+
+	for i, songURL := range []string{"https://music.apple.com/in/song/test/1", "https://music.apple.com/in/song/test/2"} {
+		handler.Handle(context.Background(), &CommandContext{UserID: 1, ChatID: 2, MessageID: i + 1, Args: songURL})
+	}
+	if size := handler.queue.GetQueueSize(); size != 1 {
+		t.Errorf("Expected only the first song to be queued, got %d requests", size)
+	}
+	if stats := handler.limiter.Stats(); stats.Allowed != 1 || stats.Limited != 1 {
+		t.Errorf("Expected 1 allowed and 1 limited request, got %+v", stats)
+	}
+}
+
+func TestSongHandler_AllowLookup(t *testing.T) {
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.limiter = NewRateLimiter(1, 0)
+	handler.lookupLimiter = NewRateLimiter(1, 0)
+	cmdCtx := &CommandContext{UserID: 1, ChatID: 2, Command: "cover"}
+
+	if ok, err := handler.allowLookup(context.Background(), cmdCtx); !ok || err != nil {
+		t.Fatalf("Expected the first lookup to be allowed, got %v, %v", ok, err)
+	}
+	if ok, _ := handler.allowLookup(context.Background(), cmdCtx); ok {
+		t.Error("Expected the second lookup to be limited")
+	}
+
+	// Lookups leave the /song bucket alone
+	if stats := handler.limiter.Stats(); stats.Allowed != 0 || stats.Limited != 0 {
+		t.Errorf("Expected no /song requests to be counted, got %+v", stats)
+	}
+}
+
+func TestRateLimitMessage(t *testing.T) {
+	if message := rateLimitMessage(11200*time.Millisecond, false); !strings.Contains(message, "too fast") || !strings.Contains(message, "12s") {
+		t.Errorf("Expected the user's wait rounded up, got %q", message)
+	}
+	if message := rateLimitMessage(time.Minute, true); !strings.Contains(message, "in this chat") || !strings.Contains(message, "1m0s") {
+		t.Errorf("Expected the chat's wait, got %q", message)
+	}
+}
+
+func TestPreflightMessage(t *testing.T) {
+	backend := downloader.NewDownloadError(downloader.ErrorBackendUnavailable, "Device (M3U8_URL) is unavailable")
+	if got := preflightMessage(backend); got != "⚠️ Decryption backend unavailable, try later." {
+		t.Errorf("preflightMessage() = %q for an unavailable backend", got)
+	}
+
+	token := downloader.NewDownloadError(downloader.ErrorTokenUnavailable, "failed to get authentication token")
+	if got := preflightMessage(token); got != "❌ Apple Music access is unavailable right now. Please try again later." {
+		t.Errorf("preflightMessage() = %q for an unavailable token", got)
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"download error", fmt.Errorf("download failed: %w", downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "no ALAC variant")), "This song isn't available in lossless ALAC"},
+		{"other error", errors.New("download failed: boom"), "download failed: boom"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := failureReason(test.err); got != test.want {
+				t.Errorf("failureReason() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
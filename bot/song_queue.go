@@ -2,16 +2,65 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
+
+	"go-alac-bot/config"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
 )
 
 const (
+	// MaxQueueSize is the default queue capacity
 	MaxQueueSize = 7
+
+	// DefaultUserQueueLimit is how many queued and processing requests one
+	// user may have at a time
+	DefaultUserQueueLimit = 2
+
+	// Bounds for the queue limits adjustable at runtime
+	MinQueueLimit   = 1
+	MaxQueueLimit   = 50
+	MinQueueWorkers = 1
+	MaxQueueWorkers = 4
+
+	// MaxStoredMessageText caps how many bytes of the original command
+	// message are kept on a queued request
+	MaxStoredMessageText = 4096
+
+	// RecentFinishedWindow is how long finished requests are kept for /my
+	RecentFinishedWindow = time.Hour
+	// MaxRecentFinished caps how many finished requests are kept
+	MaxRecentFinished = 100
+
+	// defaultRequestDuration estimates how long a request takes before any
+	// request has finished
+	defaultRequestDuration = time.Minute
+
+	// RecentDurationWindow is how many of the last request durations the
+	// rolling average of the ETA estimates is taken over
+	RecentDurationWindow = 10
+
+	// defaultShutdownGrace is how long Shutdown waits for the requests it
+	// interrupts to stop
+	defaultShutdownGrace = 2 * time.Second
 )
 
+// ErrShuttingDown is returned for requests added while the queue shuts down,
+// and is the cause of the cancelled contexts of the requests it interrupts
+var ErrShuttingDown = errors.New("bot is shutting down")
+
+// ErrRequestTimeout is the cause of the cancelled contexts of requests that
+// ran longer than the request timeout
+var ErrRequestTimeout = errors.New("request timed out")
+
 // QueueRequest represents a single song download request in the queue
 type QueueRequest struct {
 	UniqueID    string
@@ -21,6 +70,50 @@ type QueueRequest struct {
 	URL         string
 	RequestTime time.Time
 	Status      QueueStatus
+
+	// CorrelationID stays the same across retries of the same request, and
+	// Attempt counts them starting at 1
+	CorrelationID string
+	Attempt       int
+
+	// OriginalText and OriginalEntities hold the command message the URL was
+	// extracted from, so the URL can be re-validated when processing starts
+	OriginalText     string
+	OriginalEntities []tg.MessageEntityClass
+
+	// Delivery records how far the result got once the download finished
+	Delivery DeliveryState
+
+	// Override is set when the request was re-queued with a choice the user
+	// made after it failed
+	Override DownloadOverride
+
+	// BatchID groups the requests submitted together in one /song message
+	BatchID string
+
+	// StatusMessageID is the message telling the user where the request is
+	// in the queue, kept up to date as it moves up (0 = none)
+	StatusMessageID int
+
+	// StartedAt and FinishedAt are set when processing starts and ends, and
+	// FailureReason when it fails
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	FailureReason string
+}
+
+// DownloadOverride changes how a re-queued request is downloaded
+type DownloadOverride struct {
+	Storefront        string // Download from this storefront only
+	AAC               bool   // Download the best AAC stream when the song has no ALAC
+	ProgressMessageID int    // Report progress on this message instead of a new one
+}
+
+// DeliveryState tracks the delivery receipts of a completed request
+type DeliveryState struct {
+	Uploaded   bool      // Audio was sent to the chat
+	Reacted    bool      // Delivery reaction was set on the command message
+	UploadedAt time.Time // When the audio was sent
 }
 
 // QueueStatus represents the current status of a queue request
@@ -31,6 +124,7 @@ const (
 	StatusProcessing
 	StatusCompleted
 	StatusFailed
+	StatusCancelled
 )
 
 // String returns string representation of queue status
@@ -44,29 +138,358 @@ func (s QueueStatus) String() string {
 		return "completed"
 	case StatusFailed:
 		return "failed"
+	case StatusCancelled:
+		return "cancelled"
 	default:
 		return "unknown"
 	}
 }
 
+// UserLimitError is returned when a user already has as many pending requests
+// as the per-user limit allows
+type UserLimitError struct {
+	Pending int // Queued and processing requests of the user
+	Limit   int
+}
+
+func (e *UserLimitError) Error() string {
+	return fmt.Sprintf("user already has %d pending requests (max %d)", e.Pending, e.Limit)
+}
+
+// DuplicateRequestError is returned when the same song is already queued or
+// being processed in the chat
+type DuplicateRequestError struct {
+	RequestID string
+	Position  int // 1-based position in the queue, 0 while processing
+}
+
+func (e *DuplicateRequestError) Error() string {
+	if e.Position == 0 {
+		return fmt.Sprintf("song is already being processed as request %s", e.RequestID)
+	}
+	return fmt.Sprintf("song is already queued as request %s (position: %d)", e.RequestID, e.Position)
+}
+
+// DownloadStatusProvider reports the live status of an in-flight download
+type DownloadStatusProvider interface {
+	GetStatus() downloader.DownloadStatus
+}
+
+// QueuedRequestInfo is a waiting request with its place in the queue
+type QueuedRequestInfo struct {
+	Request  QueueRequest
+	Position int           // 1-based position in the whole queue
+	ETA      time.Duration // Estimated wait until processing starts
+}
+
+// ProcessingRequestInfo is a request being processed with its live status
+type ProcessingRequestInfo struct {
+	Request   QueueRequest
+	Status    downloader.DownloadStatus
+	HasStatus bool // False until the download has registered itself
+}
+
+// SenderRequests holds one user's requests in one chat
+type SenderRequests struct {
+	Queued     []QueuedRequestInfo
+	Processing []ProcessingRequestInfo
+	Finished   []QueueRequest // Finished within RecentFinishedWindow, newest first
+}
+
+// IsEmpty reports whether the user has no requests at all
+func (r SenderRequests) IsEmpty() bool {
+	return len(r.Queued) == 0 && len(r.Processing) == 0 && len(r.Finished) == 0
+}
+
 // SongQueue manages the queue of song download requests
 type SongQueue struct {
-	queue           []*QueueRequest
-	processing      *QueueRequest
-	mu              sync.RWMutex
-	logger          *log.Logger
-	songHandler     *SongHandler
-	processingMutex sync.Mutex
-	isProcessing    bool
+	// ctx is the parent of the requests' contexts, which are cancelled after
+	// requestTimeout (0 = never)
+	ctx            context.Context
+	requestTimeout time.Duration
+
+	queue        []*QueueRequest
+	processing   []*QueueRequest
+	mu           sync.RWMutex
+	logger       logging.Logger
+	songHandler  *SongHandler
+	maxSize      int
+	userLimit    int
+	autoRetries  int
+	workers      int
+	running      int
+	settingsPath string
+	statePath    string
+	failed       *FailedRequests
+
+	// inFlight maps processing request IDs to their downloads, finished keeps
+	// recently finished requests, and averageDuration, the rolling average of
+	// recentDurations, feeds the ETA estimates
+	inFlight        map[string]DownloadStatusProvider
+	cancels         map[string]context.CancelCauseFunc
+	finished        []*QueueRequest
+	recentDurations []time.Duration
+	averageDuration time.Duration
+
+	// process runs a single request; defaults to the song handler's ProcessDownload
+	process func(ctx context.Context, cmdCtx *CommandContext) error
+	// requestDelay is the pause a worker takes between requests
+	requestDelay time.Duration
+
+	// registry records limits changed at runtime for /config
+	registry *config.Registry
+
+	// listeners receive the events of every request
+	listeners []QueueListener
+
+	// metrics receives the queue depth after every change
+	metrics downloader.Metrics
+
+	// closed refuses new requests once Shutdown has started, and drained is
+	// closed when no request is processing anymore after that
+	closed        bool
+	drained       chan struct{}
+	shutdownGrace time.Duration
+}
+
+// NewSongQueue creates a new song queue manager. Cancelling ctx cancels the
+// requests being processed
+func NewSongQueue(ctx context.Context, logger logging.Logger, songHandler *SongHandler) *SongQueue {
+	sq := &SongQueue{
+		ctx:            ctx,
+		requestTimeout: config.DefaultRequestTimeout,
+		queue:          make([]*QueueRequest, 0),
+		logger:         logger,
+		songHandler:    songHandler,
+		maxSize:        MaxQueueSize,
+		userLimit:      DefaultUserQueueLimit,
+		autoRetries:    config.DefaultAutoRetries,
+		workers:        MinQueueWorkers,
+		failed:         NewFailedRequests(config.DefaultFailedRequestTTL),
+		inFlight:       make(map[string]DownloadStatusProvider),
+		cancels:        make(map[string]context.CancelCauseFunc),
+		requestDelay:   1 * time.Second,
+		metrics:        downloader.NoopMetrics{},
+		shutdownGrace:  defaultShutdownGrace,
+	}
+
+	if songHandler != nil {
+		sq.process = songHandler.ProcessDownload
+	}
+
+	return sq
+}
+
+// ValidateQueueLimits checks queue size and worker count against the allowed bounds
+func ValidateQueueLimits(size, workers int) error {
+	if size < MinQueueLimit || size > MaxQueueLimit {
+		return fmt.Errorf("queue size must be between %d and %d, got %d", MinQueueLimit, MaxQueueLimit, size)
+	}
+	if workers < MinQueueWorkers || workers > MaxQueueWorkers {
+		return fmt.Errorf("worker count must be between %d and %d, got %d", MinQueueWorkers, MaxQueueWorkers, workers)
+	}
+	return nil
+}
+
+// Failed returns the list of recently failed requests
+func (sq *SongQueue) Failed() *FailedRequests {
+	return sq.failed
+}
+
+// SetFailedRequestTTL changes how long failed requests can be retried
+func (sq *SongQueue) SetFailedRequestTTL(ttl time.Duration) {
+	sq.failed.mu.Lock()
+	defer sq.failed.mu.Unlock()
+	if ttl > 0 {
+		sq.failed.ttl = ttl
+	}
+}
+
+// SetRequestTimeout changes how long a request may be processed before it is
+// cancelled and fails (0 = no limit)
+func (sq *SongQueue) SetRequestTimeout(timeout time.Duration) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.requestTimeout = timeout
+}
+
+// MaxSize returns the current queue capacity
+func (sq *SongQueue) MaxSize() int {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.maxSize
+}
+
+// SetUserLimit changes how many pending requests one user may have (0 = unlimited)
+func (sq *SongQueue) SetUserLimit(limit int) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if limit >= 0 {
+		sq.userLimit = limit
+	}
+}
+
+// SetAutoRetries sets how many times a request failing transiently is queued
+// again before it fails (0 = never)
+func (sq *SongQueue) SetAutoRetries(retries int) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if retries >= 0 {
+		sq.autoRetries = retries
+	}
+}
+
+// Workers returns the target number of queue workers
+func (sq *SongQueue) Workers() int {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.workers
+}
+
+// SetLimits changes the queue capacity and worker count at runtime.
+// Shrinking the capacity keeps already queued requests; new requests are
+// rejected until the queue drains below the new size. Extra workers start
+// immediately, while surplus workers exit after finishing their current request.
+// The new limits are persisted when settings persistence is enabled.
+func (sq *SongQueue) SetLimits(size, workers int) error {
+	if err := ValidateQueueLimits(size, workers); err != nil {
+		return err
+	}
+
+	sq.mu.Lock()
+	sq.maxSize = size
+	sq.workers = workers
+	settingsPath := sq.settingsPath
+	registry := sq.registry
+	sq.startWorkers()
+	sq.mu.Unlock()
+
+	recordLimitOverrides(registry, size, workers)
+
+	sq.logger.Info("Queue limits set", logging.Int("Size", size), logging.Int("Workers", workers))
+
+	if settingsPath == "" {
+		return nil
+	}
+
+	if err := SaveQueueSettings(settingsPath, QueueSettings{MaxSize: size, Workers: workers}); err != nil {
+		return fmt.Errorf("limits applied but could not be saved: %w", err)
+	}
+
+	return nil
+}
+
+// SetConfigRegistry makes later limit changes, including limits loaded by
+// EnableSettingsPersistence, show up as runtime overrides in registry
+func (sq *SongQueue) SetConfigRegistry(registry *config.Registry) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.registry = registry
+}
+
+// recordLimitOverrides records queue limits set at runtime in registry
+func recordLimitOverrides(registry *config.Registry, size, workers int) {
+	if registry == nil {
+		return
+	}
+	registry.SetOverride("QUEUE_SIZE", strconv.Itoa(size))
+	registry.SetOverride("QUEUE_WORKERS", strconv.Itoa(workers))
 }
 
-// NewSongQueue creates a new song queue manager
-func NewSongQueue(logger *log.Logger, songHandler *SongHandler) *SongQueue {
-	return &SongQueue{
-		queue:       make([]*QueueRequest, 0),
-		logger:      logger,
-		songHandler: songHandler,
+// EnableSettingsPersistence makes SetLimits save to path and applies limits
+// previously saved there, which take precedence over the current values
+func (sq *SongQueue) EnableSettingsPersistence(path string) error {
+	settings, err := LoadQueueSettings(path)
+	if err != nil {
+		return err
+	}
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.settingsPath = path
+	if settings == nil {
+		return nil
+	}
+
+	if err := ValidateQueueLimits(settings.MaxSize, settings.Workers); err != nil {
+		return fmt.Errorf("invalid saved queue settings: %w", err)
 	}
+
+	sq.maxSize = settings.MaxSize
+	sq.workers = settings.Workers
+	recordLimitOverrides(sq.registry, settings.MaxSize, settings.Workers)
+	sq.logger.Info("Loaded saved queue limits", logging.Int("Size", settings.MaxSize), logging.Int("Workers", settings.Workers))
+
+	return nil
+}
+
+// EnableRequestPersistence makes the queue save its waiting requests to path
+// whenever they change, and loads the requests saved there by a previous run.
+// Requests queued more than ttl ago are dropped (0 = none). Loaded requests wait until
+// Resume is called, so that they are not processed before the bot is connected
+func (sq *SongQueue) EnableRequestPersistence(path string, ttl time.Duration) (int, error) {
+	requests, err := LoadQueuedRequests(path)
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.statePath = path
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	cutoff := time.Now().Add(-ttl)
+	for _, request := range requests {
+		if ttl > 0 && request.RequestTime.Before(cutoff) {
+			sq.logger.Info("Dropping expired saved request", logging.String("Request", request.UniqueID),
+				logging.Int64("User", request.SenderID), logging.String("Queued", request.RequestTime.Format(time.RFC3339)), logging.Duration("TTL", ttl))
+			continue
+		}
+		if sq.findRequestByID(request.UniqueID) != nil {
+			continue
+		}
+		sq.queue = append(sq.queue, request)
+		loaded++
+	}
+
+	if loaded != len(requests) {
+		sq.persistLocked()
+	}
+	if loaded > 0 {
+		sq.logger.Info("Loaded saved requests", logging.Int("Requests", loaded))
+	}
+
+	return loaded, nil
+}
+
+// Resume starts processing the requests loaded by EnableRequestPersistence
+func (sq *SongQueue) Resume() {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.startWorkers()
+}
+
+// persistLocked records a change of the waiting requests: their number in the
+// metrics and, when persistence is enabled, the requests themselves (must be
+// called with lock held). Failures are logged, the queue keeps working in memory
+func (sq *SongQueue) persistLocked() {
+	sq.metrics.SetQueueDepth(len(sq.queue))
+	if sq.statePath == "" {
+		return
+	}
+	if err := SaveQueuedRequests(sq.statePath, sq.queue); err != nil {
+		sq.logger.Warn("Failed to save queued requests", logging.Err(err))
+	}
+}
+
+// SetMetrics makes the queue report its depth to metrics
+func (sq *SongQueue) SetMetrics(metrics downloader.Metrics) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	sq.metrics = metrics
+	sq.metrics.SetQueueDepth(len(sq.queue))
 }
 
 // GenerateUniqueID creates a unique ID for a request
@@ -74,41 +497,154 @@ func GenerateUniqueID(senderID, chatID int64, messageID int) string {
 	return fmt.Sprintf("%d:%d:%d", senderID, chatID, messageID)
 }
 
+// BatchRequestID returns the unique ID of the index-th request of a batch
+func BatchRequestID(batchID string, index int) string {
+	return fmt.Sprintf("%s:%d", batchID, index)
+}
+
 // AddRequest adds a new request to the queue
 func (sq *SongQueue) AddRequest(senderID, chatID int64, messageID int, url string) (*QueueRequest, error) {
+	return sq.AddRequestWithMessage(senderID, chatID, messageID, url, "", nil)
+}
+
+// AddRequestWithMessage adds a new request to the queue, keeping a bounded copy
+// of the original message text and entities for re-validation at processing time
+func (sq *SongQueue) AddRequestWithMessage(senderID, chatID int64, messageID int, url string, text string, entities []tg.MessageEntityClass) (*QueueRequest, error) {
+	return sq.addRequest(GenerateUniqueID(senderID, chatID, messageID), "", senderID, chatID, messageID, url, text, entities)
+}
+
+// AddBatchRequest adds the index-th URL of a message submitting several at
+// once, as part of batch batchID
+func (sq *SongQueue) AddBatchRequest(batchID string, index int, senderID, chatID int64, messageID int, url string, text string, entities []tg.MessageEntityClass) (*QueueRequest, error) {
+	request, err := sq.addRequest(BatchRequestID(batchID, index), batchID, senderID, chatID, messageID, url, text, entities)
+	if err != nil {
+		return nil, err
+	}
+
+	sq.Notify(QueueEvent{Kind: QueueEventQueued, RequestID: request.UniqueID, BatchID: batchID})
+	return request, nil
+}
+
+// AddReminderRequest queues a song that came out for the user who asked for
+// it to be downloaded by release reminder reminderID
+func (sq *SongQueue) AddReminderRequest(reminderID string, senderID, chatID int64, url string) (*QueueRequest, error) {
+	return sq.addRequest("reminder:"+reminderID, "", senderID, chatID, 0, url, "", nil)
+}
+
+// AddInlineRequest queues a song a user chose in inline mode, to be sent to
+// their private chat with the bot. resultID tells the user's picks apart
+func (sq *SongQueue) AddInlineRequest(resultID string, senderID int64, url string) (*QueueRequest, error) {
+	return sq.addRequest(fmt.Sprintf("inline:%d:%s", senderID, resultID), "", senderID, senderID, 0, url, "", nil)
+}
+
+// addRequest adds a new request with the given ID to the queue
+func (sq *SongQueue) addRequest(uniqueID, batchID string, senderID, chatID int64, messageID int, url string, text string, entities []tg.MessageEntityClass) (*QueueRequest, error) {
 	sq.mu.Lock()
 	defer sq.mu.Unlock()
 
-	// Check if queue is full
-	if len(sq.queue) >= MaxQueueSize {
-		return nil, fmt.Errorf("queue is full (max %d requests)", MaxQueueSize)
+	if sq.closed {
+		return nil, ErrShuttingDown
 	}
 
-	// Generate unique ID
-	uniqueID := GenerateUniqueID(senderID, chatID, messageID)
+	// Check if queue is full
+	if len(sq.queue) >= sq.maxSize {
+		return nil, fmt.Errorf("queue is full (max %d requests)", sq.maxSize)
+	}
 
 	// Check if request already exists
 	if sq.findRequestByID(uniqueID) != nil {
 		return nil, fmt.Errorf("request with ID %s already exists", uniqueID)
 	}
 
+	// The same song is only downloaded once per chat at a time
+	if existing, position := sq.findByURLLocked(chatID, url); existing != nil {
+		return nil, &DuplicateRequestError{RequestID: existing.UniqueID, Position: position}
+	}
+
+	// One user must not fill the queue for everyone else
+	if pending := sq.userQueueCountLocked(senderID); sq.userLimit > 0 && pending >= sq.userLimit {
+		return nil, &UserLimitError{Pending: pending, Limit: sq.userLimit}
+	}
+
 	// Create new request
+	originalText, originalEntities := boundOriginalMessage(text, entities)
 	request := &QueueRequest{
-		UniqueID:    uniqueID,
-		SenderID:    senderID,
-		ChatID:      chatID,
-		MessageID:   messageID,
-		URL:         url,
-		RequestTime: time.Now(),
-		Status:      StatusQueued,
+		UniqueID:         uniqueID,
+		CorrelationID:    uniqueID,
+		Attempt:          1,
+		SenderID:         senderID,
+		ChatID:           chatID,
+		MessageID:        messageID,
+		URL:              url,
+		RequestTime:      time.Now(),
+		Status:           StatusQueued,
+		OriginalText:     originalText,
+		OriginalEntities: originalEntities,
+		BatchID:          batchID,
 	}
 
 	// Add to queue
 	sq.queue = append(sq.queue, request)
-	sq.logger.Printf("Added request %s to queue (position: %d)", uniqueID, len(sq.queue))
+	sq.logger.Info("Added request to queue", logging.String("Request", uniqueID), logging.Int("Position", len(sq.queue)))
+	sq.persistLocked()
 
-	// Start processing if not already processing
-	go sq.processQueue()
+	// Start a worker if one is free
+	sq.startWorkers()
+
+	return request, nil
+}
+
+// Requeue adds a previously failed request back to the queue. The new request
+// keeps the original correlation ID so its logs can be followed across attempts
+func (sq *SongQueue) Requeue(failed *QueueRequest) (*QueueRequest, error) {
+	return sq.RequeueWithOverride(failed, DownloadOverride{})
+}
+
+// RequeueWithOverride re-queues a failed request like Requeue, downloading it
+// the way override says
+func (sq *SongQueue) RequeueWithOverride(failed *QueueRequest, override DownloadOverride) (*QueueRequest, error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if sq.closed {
+		return nil, ErrShuttingDown
+	}
+
+	if len(sq.queue) >= sq.maxSize {
+		return nil, fmt.Errorf("queue is full (max %d requests)", sq.maxSize)
+	}
+
+	if sq.findRequestByID(failed.UniqueID) != nil {
+		return nil, fmt.Errorf("request with ID %s already exists", failed.UniqueID)
+	}
+
+	correlationID := failed.CorrelationID
+	if correlationID == "" {
+		correlationID = failed.UniqueID
+	}
+
+	request := &QueueRequest{
+		UniqueID:         failed.UniqueID,
+		CorrelationID:    correlationID,
+		Attempt:          failed.Attempt + 1,
+		SenderID:         failed.SenderID,
+		ChatID:           failed.ChatID,
+		MessageID:        failed.MessageID,
+		URL:              failed.URL,
+		RequestTime:      time.Now(),
+		Status:           StatusQueued,
+		OriginalText:     failed.OriginalText,
+		OriginalEntities: failed.OriginalEntities,
+		Override:         override,
+		BatchID:          failed.BatchID,
+	}
+
+	sq.queue = append(sq.queue, request)
+	sq.logger.Info("Re-queued request", logging.String("Request", request.UniqueID), logging.Int("Attempt", request.Attempt),
+		logging.String("Correlation", correlationID), logging.Int("Position", len(sq.queue)))
+	sq.persistLocked()
+
+	sq.startWorkers()
 
 	return request, nil
 }
@@ -117,13 +653,27 @@ func (sq *SongQueue) AddRequest(senderID, chatID int64, messageID int, url strin
 func (sq *SongQueue) GetQueuePosition(uniqueID string) int {
 	sq.mu.RLock()
 	defer sq.mu.RUnlock()
+	return sq.positionLocked(uniqueID)
+}
 
-	for i, request := range sq.queue {
-		if request.UniqueID == uniqueID {
-			return i + 1 // 1-based position
-		}
+// SetStatusMessage records messageID as the status message of the queued
+// request with uniqueID, to be edited whenever the request moves up
+func (sq *SongQueue) SetStatusMessage(uniqueID string, messageID int) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if request := sq.findRequestByID(uniqueID); request != nil {
+		request.StatusMessageID = messageID
+		sq.persistLocked()
 	}
-	return -1 // Not found
+}
+
+// EstimateWait estimates how long the request at a queue position waits
+// before a worker picks it up
+func (sq *SongQueue) EstimateWait(position int) time.Duration {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.estimateWaitLocked(position)
 }
 
 // GetQueueSize returns the current size of the queue
@@ -137,14 +687,262 @@ func (sq *SongQueue) GetQueueSize() int {
 func (sq *SongQueue) IsProcessing() bool {
 	sq.mu.RLock()
 	defer sq.mu.RUnlock()
-	return sq.processing != nil
+	return len(sq.processing) > 0
 }
 
-// GetCurrentlyProcessing returns the currently processing request
+// GetCurrentlyProcessing returns the longest-running request being processed
 func (sq *SongQueue) GetCurrentlyProcessing() *QueueRequest {
 	sq.mu.RLock()
 	defer sq.mu.RUnlock()
-	return sq.processing
+	if len(sq.processing) == 0 {
+		return nil
+	}
+	return sq.processing[0]
+}
+
+// GetProcessing returns all requests currently being processed
+func (sq *SongQueue) GetProcessing() []*QueueRequest {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+
+	processingCopy := make([]*QueueRequest, len(sq.processing))
+	copy(processingCopy, sq.processing)
+	return processingCopy
+}
+
+// RegisterInFlight attaches the download of a processing request so its live
+// status can be looked up. It is dropped when the request finishes
+func (sq *SongQueue) RegisterInFlight(uniqueID string, provider DownloadStatusProvider) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	for _, request := range sq.processing {
+		if request.UniqueID == uniqueID {
+			sq.inFlight[uniqueID] = provider
+			return
+		}
+	}
+}
+
+// GetInFlightStatus returns the live download status of a processing request
+func (sq *SongQueue) GetInFlightStatus(uniqueID string) (downloader.DownloadStatus, bool) {
+	sq.mu.RLock()
+	provider, ok := sq.inFlight[uniqueID]
+	sq.mu.RUnlock()
+
+	if !ok {
+		return downloader.DownloadStatus{}, false
+	}
+	return provider.GetStatus(), true
+}
+
+// GetRequestsBySender returns the queued, processing and recently finished
+// requests a user made in a chat. Requests of other users or other chats are
+// never included
+func (sq *SongQueue) GetRequestsBySender(chatID, senderID int64) SenderRequests {
+	sq.mu.RLock()
+	var result SenderRequests
+	var providers []DownloadStatusProvider
+
+	for i, request := range sq.queue {
+		if request.ChatID == chatID && request.SenderID == senderID {
+			result.Queued = append(result.Queued, QueuedRequestInfo{
+				Request:  *request,
+				Position: i + 1,
+				ETA:      sq.estimateWaitLocked(i + 1),
+			})
+		}
+	}
+
+	for _, request := range sq.processing {
+		if request.ChatID == chatID && request.SenderID == senderID {
+			result.Processing = append(result.Processing, ProcessingRequestInfo{Request: processingSnapshot(request)})
+			providers = append(providers, sq.inFlight[request.UniqueID])
+		}
+	}
+
+	cutoff := time.Now().Add(-RecentFinishedWindow)
+	for i := len(sq.finished) - 1; i >= 0; i-- {
+		request := sq.finished[i]
+		if request.FinishedAt.After(cutoff) && request.ChatID == chatID && request.SenderID == senderID {
+			result.Finished = append(result.Finished, *request)
+		}
+	}
+	sq.mu.RUnlock()
+
+	// Downloads take their own lock, so their status is read without holding ours
+	for i, provider := range providers {
+		if provider != nil {
+			result.Processing[i].Status = provider.GetStatus()
+			result.Processing[i].HasStatus = true
+		}
+	}
+
+	return result
+}
+
+// processingSnapshot copies a processing request without its delivery state,
+// which the download writes without holding the queue lock
+func processingSnapshot(request *QueueRequest) QueueRequest {
+	return QueueRequest{
+		UniqueID:      request.UniqueID,
+		SenderID:      request.SenderID,
+		ChatID:        request.ChatID,
+		MessageID:     request.MessageID,
+		URL:           request.URL,
+		RequestTime:   request.RequestTime,
+		Status:        request.Status,
+		CorrelationID: request.CorrelationID,
+		Attempt:       request.Attempt,
+		StartedAt:     request.StartedAt,
+	}
+}
+
+// estimateWaitLocked estimates how long the request at a queue position waits
+// before a worker picks it up (must be called with lock held)
+func (sq *SongQueue) estimateWaitLocked(position int) time.Duration {
+	duration := sq.averageDuration
+	if duration == 0 {
+		duration = defaultRequestDuration
+	}
+
+	workers := sq.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Every worker is busy with one request, then the queue ahead drains workers at a time
+	rounds := (position-1)/workers + 1
+	return time.Duration(rounds) * duration
+}
+
+// recordFinishedLocked keeps a finished request for /my and updates the
+// average processing time (must be called with lock held)
+func (sq *SongQueue) recordFinishedLocked(request *QueueRequest) {
+	if !request.StartedAt.IsZero() {
+		sq.recordDurationLocked(downloader.Elapsed(request.StartedAt, request.FinishedAt))
+	}
+
+	cutoff := time.Now().Add(-RecentFinishedWindow)
+	kept := sq.finished[:0]
+	for _, finished := range sq.finished {
+		if finished.FinishedAt.After(cutoff) {
+			kept = append(kept, finished)
+		}
+	}
+	kept = append(kept, request)
+	if len(kept) > MaxRecentFinished {
+		kept = kept[len(kept)-MaxRecentFinished:]
+	}
+	sq.finished = kept
+}
+
+// recordDurationLocked adds the processing time of a finished request to the
+// last RecentDurationWindow ones and averages them (must be called with lock held)
+func (sq *SongQueue) recordDurationLocked(duration time.Duration) {
+	sq.recentDurations = append(sq.recentDurations, duration)
+	if len(sq.recentDurations) > RecentDurationWindow {
+		sq.recentDurations = sq.recentDurations[len(sq.recentDurations)-RecentDurationWindow:]
+	}
+
+	var total time.Duration
+	for _, d := range sq.recentDurations {
+		total += d
+	}
+	sq.averageDuration = total / time.Duration(len(sq.recentDurations))
+}
+
+// movedEventsLocked returns the moved events of the queued requests from index
+// from on that have a status message, to be delivered once the lock is
+// released (must be called with lock held)
+func (sq *SongQueue) movedEventsLocked(from int) []QueueEvent {
+	var events []QueueEvent
+	for i := from; i < len(sq.queue); i++ {
+		request := sq.queue[i]
+		if request.StatusMessageID == 0 {
+			continue
+		}
+		events = append(events, QueueEvent{
+			Kind:            QueueEventMoved,
+			RequestID:       request.UniqueID,
+			BatchID:         request.BatchID,
+			ChatID:          request.ChatID,
+			StatusMessageID: request.StatusMessageID,
+			Position:        i + 1,
+			QueueSize:       len(sq.queue),
+			ETA:             sq.estimateWaitLocked(i + 1),
+		})
+	}
+	return events
+}
+
+// notifyAll delivers events to the listeners (must be called without the lock held)
+func (sq *SongQueue) notifyAll(events []QueueEvent) {
+	for _, event := range events {
+		sq.Notify(event)
+	}
+}
+
+// GetUserQueueCount returns how many requests of a user are queued or being
+// processed, across all chats
+func (sq *SongQueue) GetUserQueueCount(senderID int64) int {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.userQueueCountLocked(senderID)
+}
+
+// userQueueCountLocked counts the pending requests of a user (must be called
+// with lock held)
+func (sq *SongQueue) userQueueCountLocked(senderID int64) int {
+	count := 0
+	for _, request := range sq.queue {
+		if request.SenderID == senderID {
+			count++
+		}
+	}
+	for _, request := range sq.processing {
+		if request.SenderID == senderID {
+			count++
+		}
+	}
+	return count
+}
+
+// FindByURL returns the request for the same song as url that is queued or
+// being processed in a chat, with its 1-based queue position (0 while
+// processing). It returns nil when there is none
+func (sq *SongQueue) FindByURL(chatID int64, url string) (*QueueRequest, int) {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.findByURLLocked(chatID, url)
+}
+
+// findByURLLocked looks up a pending request for the same song (must be called
+// with lock held)
+func (sq *SongQueue) findByURLLocked(chatID int64, url string) (*QueueRequest, int) {
+	key := normalizeSongURL(url)
+	for _, request := range sq.processing {
+		if request.ChatID == chatID && normalizeSongURL(request.URL) == key {
+			return request, 0
+		}
+	}
+	for i, request := range sq.queue {
+		if request.ChatID == chatID && normalizeSongURL(request.URL) == key {
+			return request, i + 1
+		}
+	}
+	return nil, -1
+}
+
+// normalizeSongURL reduces an Apple Music URL to the storefront, type and ID
+// it refers to, so that links differing only in their slug or query compare
+// equal. Other URLs are compared as-is
+func normalizeSongURL(url string) string {
+	meta := ExtractURLMeta(strings.TrimSpace(url))
+	if meta == nil {
+		return strings.TrimSpace(url)
+	}
+	return meta.Storefront + "/" + meta.URLType + "/" + meta.ID
 }
 
 // findRequestByID finds a request by its unique ID (must be called with lock held)
@@ -157,85 +955,450 @@ func (sq *SongQueue) findRequestByID(uniqueID string) *QueueRequest {
 	return nil
 }
 
+// positionLocked returns the 1-based queue position of the request with
+// uniqueID, -1 when it is not queued (must be called with lock held)
+func (sq *SongQueue) positionLocked(uniqueID string) int {
+	for i, request := range sq.queue {
+		if request.UniqueID == uniqueID {
+			return i + 1
+		}
+	}
+	return -1
+}
+
 // removeRequest removes a request from the queue (must be called with lock held)
 func (sq *SongQueue) removeRequest(uniqueID string) bool {
 	for i, request := range sq.queue {
 		if request.UniqueID == uniqueID {
 			// Remove from slice
 			sq.queue = append(sq.queue[:i], sq.queue[i+1:]...)
-			sq.logger.Printf("Removed request %s from queue", uniqueID)
+			sq.logger.Info("Removed request from queue", logging.String("Request", uniqueID))
+			sq.persistLocked()
 			return true
 		}
 	}
 	return false
 }
 
-// processQueue processes requests from the queue one at a time
-func (sq *SongQueue) processQueue() {
-	// Prevent multiple processing goroutines
-	sq.processingMutex.Lock()
-	if sq.isProcessing {
-		sq.processingMutex.Unlock()
-		return
+// RemoveByUser removes the queued requests of a user from the queue and
+// returns them. Requests being processed are left to CancelProcessing
+func (sq *SongQueue) RemoveByUser(senderID int64) []*QueueRequest {
+	sq.mu.Lock()
+	var removed []*QueueRequest
+	var moved []QueueEvent
+	first := -1
+	kept := sq.queue[:0]
+	for i, request := range sq.queue {
+		if request.SenderID == senderID {
+			request.Status = StatusCancelled
+			removed = append(removed, request)
+			if first < 0 {
+				first = i
+			}
+		} else {
+			kept = append(kept, request)
+		}
+	}
+	sq.queue = kept
+	if len(removed) > 0 {
+		sq.logger.Info("Removed queued requests of user", logging.Int("Requests", len(removed)), logging.Int64("User", senderID))
+		sq.persistLocked()
+		moved = sq.movedEventsLocked(first)
 	}
-	sq.isProcessing = true
-	sq.processingMutex.Unlock()
+	sq.mu.Unlock()
 
-	defer func() {
-		sq.processingMutex.Lock()
-		sq.isProcessing = false
-		sq.processingMutex.Unlock()
-	}()
+	sq.notifyAll(moved)
 
-	for {
-		// Get next request from queue
-		sq.mu.Lock()
-		if len(sq.queue) == 0 {
-			sq.mu.Unlock()
-			break
+	for _, request := range removed {
+		if request.BatchID != "" {
+			sq.Notify(QueueEvent{Kind: QueueEventFailed, RequestID: request.UniqueID, BatchID: request.BatchID, Reason: "cancelled"})
+		}
+	}
+
+	return removed
+}
+
+// CancelProcessing stops the requests of a user that are being processed and
+// returns how many there were. Their downloads are cancelled and the requests
+// finish as cancelled instead of failed
+func (sq *SongQueue) CancelProcessing(senderID int64) int {
+	sq.mu.Lock()
+	cancelled, cancellers := sq.cancelProcessingLocked(func(request *QueueRequest) bool {
+		return request.SenderID == senderID
+	}, nil)
+	sq.mu.Unlock()
+
+	// Downloads take their own lock, so they are cancelled without holding ours
+	cancelDownloads(cancellers)
+
+	if cancelled > 0 {
+		sq.logger.Info("Cancelled processing requests of user", logging.Int("Requests", cancelled), logging.Int64("User", senderID))
+	}
+	return cancelled
+}
+
+// FindRequest returns a copy of the queued or processing request with
+// uniqueID
+func (sq *SongQueue) FindRequest(uniqueID string) (QueueRequest, bool) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if request := sq.findRequestByID(uniqueID); request != nil {
+		return processingSnapshot(request), true
+	}
+	for _, request := range sq.processing {
+		if request.UniqueID == uniqueID {
+			return processingSnapshot(request), true
 		}
+	}
+	return QueueRequest{}, false
+}
 
-		// Take the first request
-		request := sq.queue[0]
-		sq.queue = sq.queue[1:]
-		sq.processing = request
+// CancelRequest removes the request with uniqueID from the queue, or stops it
+// like CancelProcessing when it is being processed. It reports whether there
+// was such a request
+func (sq *SongQueue) CancelRequest(uniqueID string) bool {
+	sq.mu.Lock()
+	if request := sq.findRequestByID(uniqueID); request != nil {
+		position := sq.positionLocked(uniqueID)
+		request.Status = StatusCancelled
+		sq.removeRequest(uniqueID)
+		moved := sq.movedEventsLocked(position - 1)
 		sq.mu.Unlock()
 
-		// Update request status
-		request.Status = StatusProcessing
-		sq.logger.Printf("Processing request %s: %s", request.UniqueID, request.URL)
+		sq.notifyAll(moved)
+
+		if request.BatchID != "" {
+			sq.Notify(QueueEvent{Kind: QueueEventFailed, RequestID: request.UniqueID, BatchID: request.BatchID, Reason: "cancelled"})
+		}
+		return true
+	}
+
+	cancelled, cancellers := sq.cancelProcessingLocked(func(request *QueueRequest) bool {
+		return request.UniqueID == uniqueID
+	}, nil)
+	sq.mu.Unlock()
+
+	cancelDownloads(cancellers)
+	if cancelled > 0 {
+		sq.logger.Info("Cancelled processing request", logging.String("Request", uniqueID))
+	}
+	return cancelled > 0
+}
+
+// downloadCanceller is a download that can be stopped
+type downloadCanceller interface {
+	Cancel(ctx context.Context) error
+}
+
+// cancelProcessingLocked cancels the contexts of the processing requests
+// matching with cause (nil = cancelled by the user) and returns how many there
+// were, with their downloads to cancel once the lock is released (must be
+// called with lock held)
+func (sq *SongQueue) cancelProcessingLocked(matches func(request *QueueRequest) bool, cause error) (int, []downloadCanceller) {
+	var cancellers []downloadCanceller
+	cancelled := 0
+	for _, request := range sq.processing {
+		if !matches(request) {
+			continue
+		}
+		if cancel, ok := sq.cancels[request.UniqueID]; ok {
+			cancel(cause)
+			cancelled++
+		}
+		if canceller, ok := sq.inFlight[request.UniqueID].(downloadCanceller); ok {
+			cancellers = append(cancellers, canceller)
+		}
+	}
+	return cancelled, cancellers
+}
+
+// cancelDownloads cancels downloads returned by cancelProcessingLocked
+func cancelDownloads(cancellers []downloadCanceller) {
+	for _, canceller := range cancellers {
+		canceller.Cancel(context.Background())
+	}
+}
+
+// startWorkers launches workers until the target count is reached or every
+// queued request has a free worker (must be called with lock held)
+func (sq *SongQueue) startWorkers() {
+	for !sq.closed && sq.running < sq.workers && sq.running-len(sq.processing) < len(sq.queue) {
+		sq.running++
+		go sq.worker()
+	}
+}
+
+// nextRequest takes the next queued request with the context it is processed
+// under and the moved events of the requests behind it, or returns nil when
+// the worker should exit because the queue is empty or the pool was scaled
+// down or shut down
+func (sq *SongQueue) nextRequest() (*QueueRequest, context.Context, []QueueEvent) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if len(sq.queue) == 0 || sq.running > sq.workers || sq.closed {
+		sq.running--
+		return nil, nil, nil
+	}
+
+	request := sq.queue[0]
+	sq.queue = sq.queue[1:]
+	sq.processing = append(sq.processing, request)
+	sq.persistLocked()
+	request.Status = StatusProcessing
+	request.StartedAt = time.Now()
+
+	ctx, cancel := context.WithCancelCause(sq.ctx)
+	sq.cancels[request.UniqueID] = cancel
+	if sq.requestTimeout > 0 {
+		timer := time.AfterFunc(sq.requestTimeout, func() { cancel(ErrRequestTimeout) })
+		sq.cancels[request.UniqueID] = func(cause error) {
+			timer.Stop()
+			cancel(cause)
+		}
+	}
+
+	moved := sq.movedEventsLocked(0)
+	if request.StatusMessageID != 0 {
+		// Position 0 tells the status message that processing started
+		moved = append(moved, QueueEvent{Kind: QueueEventMoved, RequestID: request.UniqueID, BatchID: request.BatchID,
+			ChatID: request.ChatID, StatusMessageID: request.StatusMessageID})
+	}
+	return request, ctx, moved
+}
+
+// worker processes requests from the queue one at a time
+func (sq *SongQueue) worker() {
+	for {
+		request, ctx, moved := sq.nextRequest()
+		if request == nil {
+			break
+		}
+		sq.notifyAll(moved)
+
+		sq.logger.Info("Processing request", logging.String("Request", request.UniqueID),
+			logging.String("Correlation", request.CorrelationID), logging.Int("Attempt", request.Attempt), logging.String("URL", request.URL))
 
 		// Create command context for the request
 		cmdCtx := &CommandContext{
-			Command:   "song",
-			Args:      request.URL,
-			UserID:    request.SenderID,
-			ChatID:    request.ChatID,
-			MessageID: request.MessageID,
-			Timestamp: request.RequestTime,
+			Command:     "song",
+			Args:        request.URL,
+			MessageText: request.OriginalText,
+			Entities:    request.OriginalEntities,
+			UserID:      request.SenderID,
+			ChatID:      request.ChatID,
+			MessageID:   request.MessageID,
+			Timestamp:   request.RequestTime,
+			Delivery:    &request.Delivery,
+			RequestID:   request.UniqueID,
+			Override:    request.Override,
+			BatchID:     request.BatchID,
+			Attempt:     request.Attempt,
+			Attempts:    sq.attempts(),
+
+			StatusMessageID: request.StatusMessageID,
 		}
 
-		// Process the request
-		ctx := context.Background()
-		err := sq.songHandler.ProcessDownload(ctx, cmdCtx)
+		sq.Notify(QueueEvent{Kind: QueueEventStarted, RequestID: request.UniqueID, BatchID: request.BatchID})
+
+		// Process the request; /cancel cancels its context
+		var err error
+		if sq.process != nil {
+			err = sq.process(ctx, cmdCtx)
+		} else {
+			err = fmt.Errorf("no request processor configured")
+		}
+		// Requests that took too long fail rather than being cancelled
+		timedOut := errors.Is(context.Cause(ctx), ErrRequestTimeout)
+		cancelled := ctx.Err() != nil && !timedOut
 
 		// Update request status based on result
 		sq.mu.Lock()
-		if err != nil {
+		sq.cancels[request.UniqueID](nil)
+		delete(sq.cancels, request.UniqueID)
+		if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+			if err != nil {
+				// Shutdown stopped the request; it runs again after the restart
+				delete(sq.inFlight, request.UniqueID)
+				sq.requeueInterruptedLocked(request)
+				sq.notifyDrainedLocked()
+				sq.mu.Unlock()
+				continue
+			}
+			// Finished despite the shutdown, so it must not run again
+			sq.removeRequest(request.UniqueID)
+			cancelled = false
+		}
+		request.FinishedAt = time.Now()
+		var retry *QueueRequest
+		if cancelled {
+			request.Status = StatusCancelled
+			request.FailureReason = "cancelled by user"
+			sq.logger.Info("Request was cancelled", logging.String("Request", request.UniqueID), logging.String("Correlation", request.CorrelationID))
+		} else if err != nil {
 			request.Status = StatusFailed
-			sq.logger.Printf("Request %s failed: %v", request.UniqueID, err)
+			request.FailureReason = failureReason(err)
+			if !timedOut && retryable(cmdCtx.Attempt, cmdCtx.Attempts, err) {
+				retry = sq.retryLocked(request)
+			} else {
+				sq.logger.Error("Request failed", logging.String("Request", request.UniqueID), logging.String("Correlation", request.CorrelationID), logging.Err(err))
+				sq.failed.Record(request, request.FailureReason)
+			}
 		} else {
 			request.Status = StatusCompleted
-			sq.logger.Printf("Request %s completed successfully", request.UniqueID)
+			sq.logger.Info("Request completed successfully", logging.String("Request", request.UniqueID))
+		}
+		for i, active := range sq.processing {
+			if active == request {
+				sq.processing = append(sq.processing[:i], sq.processing[i+1:]...)
+				break
+			}
+		}
+		delete(sq.inFlight, request.UniqueID)
+		if retry != nil {
+			// The request is not finished, but its time still counts towards the ETAs
+			sq.recordDurationLocked(downloader.Elapsed(request.StartedAt, request.FinishedAt))
+		} else {
+			sq.recordFinishedLocked(request)
 		}
-		sq.processing = nil
+		sq.notifyDrainedLocked()
 		sq.mu.Unlock()
 
+		if cancelled {
+			sq.Notify(QueueEvent{Kind: QueueEventFailed, RequestID: request.UniqueID, BatchID: request.BatchID, Reason: "cancelled"})
+		} else if retry != nil {
+			sq.Notify(QueueEvent{Kind: QueueEventQueued, RequestID: retry.UniqueID, BatchID: retry.BatchID, Attempt: retry.Attempt})
+		} else if err != nil {
+			sq.Notify(QueueEvent{Kind: QueueEventFailed, RequestID: request.UniqueID, BatchID: request.BatchID, Reason: failureReason(err)})
+		} else {
+			sq.Notify(QueueEvent{Kind: QueueEventCompleted, RequestID: request.UniqueID, BatchID: request.BatchID})
+		}
+
 		// Small delay between requests to avoid overwhelming
-		time.Sleep(1 * time.Second)
+		time.Sleep(sq.requestDelay)
+	}
+
+	sq.logger.Debug("Queue worker stopped")
+}
+
+// Shutdown stops the queue before the bot exits. New requests are refused with
+// ErrShuttingDown and the processing ones may finish until ctx ends. Those
+// still processing then are cancelled and put back at the front of the queue,
+// which is saved for Resume on the next start when persistence is enabled.
+// Returns ctx's error when requests had to be interrupted
+func (sq *SongQueue) Shutdown(ctx context.Context) error {
+	sq.mu.Lock()
+	sq.closed = true
+	sq.drained = make(chan struct{})
+	drained := sq.drained
+	processing := len(sq.processing)
+	sq.notifyDrainedLocked()
+	sq.mu.Unlock()
+
+	if processing > 0 {
+		sq.logger.Info("Waiting for processing requests to finish", logging.Int("Requests", processing))
 	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	sq.mu.Lock()
+	interrupted, cancellers := sq.cancelProcessingLocked(func(request *QueueRequest) bool { return true }, ErrShuttingDown)
+	sq.mu.Unlock()
+	cancelDownloads(cancellers)
+	sq.logger.Warn("Interrupting processing requests", logging.Int("Requests", interrupted))
 
-	sq.logger.Printf("Queue processing completed")
+	// The interrupted requests tell their users about the restart on the way out
+	select {
+	case <-drained:
+	case <-time.After(sq.shutdownGrace):
+		sq.logger.Warn("Processing requests did not stop in time", logging.Duration("Grace", sq.shutdownGrace))
+	}
+
+	sq.mu.Lock()
+	for len(sq.processing) > 0 {
+		sq.requeueInterruptedLocked(sq.processing[0])
+	}
+	sq.mu.Unlock()
+	return ctx.Err()
+}
+
+// requeueInterruptedLocked moves a request interrupted by Shutdown from the
+// processing ones back to the front of the queue, unless it was moved already
+// (must be called with lock held)
+func (sq *SongQueue) requeueInterruptedLocked(request *QueueRequest) {
+	for i, active := range sq.processing {
+		if active != request {
+			continue
+		}
+		sq.processing = append(sq.processing[:i], sq.processing[i+1:]...)
+		request.Status = StatusQueued
+		request.StartedAt = time.Time{}
+		sq.queue = append([]*QueueRequest{request}, sq.queue...)
+		sq.persistLocked()
+		sq.logger.Info("Re-queued interrupted request", logging.String("Request", request.UniqueID),
+			logging.String("Correlation", request.CorrelationID), logging.Bool("Saved", sq.statePath != ""))
+		return
+	}
+}
+
+// attempts returns how many times a request is tried at most
+func (sq *SongQueue) attempts() int {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+	return sq.autoRetries + 1
+}
+
+// retryable reports whether a request that failed with err on the attempt-th
+// of attempts tries is queued again
+func retryable(attempt, attempts int, err error) bool {
+	return attempt < attempts && IsRetryableError(err)
+}
+
+// retryLocked queues a request that failed transiently again at the end of the
+// queue. It already had its place, so the queue size does not limit it, and
+// after Shutdown it is saved with the rest (must be called with lock held)
+func (sq *SongQueue) retryLocked(failed *QueueRequest) *QueueRequest {
+	correlationID := failed.CorrelationID
+	if correlationID == "" {
+		correlationID = failed.UniqueID
+	}
+
+	request := &QueueRequest{
+		UniqueID:         failed.UniqueID,
+		CorrelationID:    correlationID,
+		Attempt:          failed.Attempt + 1,
+		SenderID:         failed.SenderID,
+		ChatID:           failed.ChatID,
+		MessageID:        failed.MessageID,
+		URL:              failed.URL,
+		RequestTime:      time.Now(),
+		Status:           StatusQueued,
+		OriginalText:     failed.OriginalText,
+		OriginalEntities: failed.OriginalEntities,
+		Override:         failed.Override,
+		BatchID:          failed.BatchID,
+		StatusMessageID:  failed.StatusMessageID,
+	}
+
+	sq.queue = append(sq.queue, request)
+	sq.logger.Warn("Retrying failed request", logging.String("Request", request.UniqueID), logging.String("Correlation", correlationID),
+		logging.Int("Attempt", request.Attempt), logging.Int("Position", len(sq.queue)), logging.String("Reason", failed.FailureReason))
+	sq.persistLocked()
+	sq.startWorkers()
+	return request
+}
+
+// notifyDrainedLocked closes drained once Shutdown has started and no request
+// is processing anymore (must be called with lock held)
+func (sq *SongQueue) notifyDrainedLocked() {
+	if sq.drained != nil && len(sq.processing) == 0 {
+		close(sq.drained)
+		sq.drained = nil
+	}
 }
 
 // GetQueueStatus returns the current queue status for display
@@ -244,10 +1407,13 @@ func (sq *SongQueue) GetQueueStatus() string {
 	defer sq.mu.RUnlock()
 
 	status := fmt.Sprintf("📊 Queue Status:\n")
-	status += fmt.Sprintf("• Queue size: %d/%d\n", len(sq.queue), MaxQueueSize)
+	status += fmt.Sprintf("• Queue size: %d/%d\n", len(sq.queue), sq.maxSize)
+	status += fmt.Sprintf("• Workers: %d\n", sq.workers)
 
-	if sq.processing != nil {
-		status += fmt.Sprintf("• Currently processing: %s\n", sq.processing.UniqueID)
+	if len(sq.processing) > 0 {
+		for _, request := range sq.processing {
+			status += fmt.Sprintf("• Currently processing: %s\n", request.UniqueID)
+		}
 	} else {
 		status += "• Currently processing: None\n"
 	}
@@ -273,13 +1439,48 @@ func (sq *SongQueue) GetQueueInfo() []*QueueRequest {
 	return queueCopy
 }
 
-// ClearQueue clears all requests from the queue (for admin use)
+// ClearQueue clears all requests from the queue (for admin use). Requests
+// being processed are not affected
 func (sq *SongQueue) ClearQueue() int {
 	sq.mu.Lock()
-	defer sq.mu.Unlock()
-
-	cleared := len(sq.queue)
+	cleared := sq.queue
+	for _, request := range cleared {
+		request.Status = StatusCancelled
+	}
 	sq.queue = make([]*QueueRequest, 0)
-	sq.logger.Printf("Cleared %d requests from queue", cleared)
-	return cleared
+	sq.logger.Info("Cleared requests from queue", logging.Int("Requests", len(cleared)))
+	sq.persistLocked()
+	sq.mu.Unlock()
+
+	for _, request := range cleared {
+		if request.BatchID != "" {
+			sq.Notify(QueueEvent{Kind: QueueEventFailed, RequestID: request.UniqueID, BatchID: request.BatchID, Reason: "cancelled"})
+		}
+	}
+
+	return len(cleared)
+}
+
+// boundOriginalMessage truncates the stored message text to MaxStoredMessageText
+// bytes on a rune boundary and drops entities that no longer fit inside it
+func boundOriginalMessage(text string, entities []tg.MessageEntityClass) (string, []tg.MessageEntityClass) {
+	if len(text) <= MaxStoredMessageText {
+		return text, entities
+	}
+
+	cut := MaxStoredMessageText
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	text = text[:cut]
+
+	textLength := utf16Len(text)
+	var kept []tg.MessageEntityClass
+	for _, entity := range entities {
+		if entity.GetOffset()+entity.GetLength() <= textLength {
+			kept = append(kept, entity)
+		}
+	}
+
+	return text, kept
 }
@@ -0,0 +1,917 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-alac-bot/config"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestSongQueue_AddRequestWithMessage_StoresOriginal(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+
+	text := "/song https://music.apple.com/in/song/test/123"
+	entities := []tg.MessageEntityClass{&tg.MessageEntityURL{Offset: 6, Length: 40}}
+
+	// Block the worker so the request stays queued
+	release := make(chan struct{})
+	defer close(release)
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		<-release
+		return nil
+	}
+
+	request, err := queue.AddRequestWithMessage(1, 2, 3, "https://music.apple.com/in/song/test/123", text, entities)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if request.OriginalText != text {
+		t.Errorf("OriginalText = %q, want %q", request.OriginalText, text)
+	}
+
+	if len(request.OriginalEntities) != 1 {
+		t.Errorf("Expected 1 stored entity, got %d", len(request.OriginalEntities))
+	}
+}
+
+func TestBoundOriginalMessage(t *testing.T) {
+	t.Run("short text is kept as-is", func(t *testing.T) {
+		entities := []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 5}}
+		text, kept := boundOriginalMessage("hello", entities)
+		if text != "hello" || len(kept) != 1 {
+			t.Errorf("Expected text and entities unchanged, got %q and %d entities", text, len(kept))
+		}
+	})
+
+	t.Run("long text is truncated and entities beyond it dropped", func(t *testing.T) {
+		long := strings.Repeat("a", MaxStoredMessageText+100)
+		entities := []tg.MessageEntityClass{
+			&tg.MessageEntityBold{Offset: 0, Length: 10},
+			&tg.MessageEntityURL{Offset: MaxStoredMessageText + 10, Length: 20},
+		}
+
+		text, kept := boundOriginalMessage(long, entities)
+		if len(text) != MaxStoredMessageText {
+			t.Errorf("Expected %d bytes, got %d", MaxStoredMessageText, len(text))
+		}
+		if len(kept) != 1 {
+			t.Errorf("Expected 1 entity to survive truncation, got %d", len(kept))
+		}
+	})
+
+	t.Run("truncation respects rune boundaries", func(t *testing.T) {
+		// Each "é" is two bytes, so an odd byte limit falls mid-rune
+		long := "a" + strings.Repeat("é", MaxStoredMessageText)
+
+		text, _ := boundOriginalMessage(long, nil)
+		if len(text) > MaxStoredMessageText {
+			t.Errorf("Expected at most %d bytes, got %d", MaxStoredMessageText, len(text))
+		}
+		if !strings.HasSuffix(text, "é") {
+			t.Error("Expected truncated text to end on a complete rune")
+		}
+	})
+}
+
+// blockingProcessor is a queue processor whose requests run until released
+type blockingProcessor struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	release chan struct{}
+}
+
+func newBlockingProcessor() *blockingProcessor {
+	return &blockingProcessor{release: make(chan struct{})}
+}
+
+func (p *blockingProcessor) process(ctx context.Context, cmdCtx *CommandContext) error {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.peak {
+		p.peak = p.running
+	}
+	p.mu.Unlock()
+
+	<-p.release
+
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *blockingProcessor) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// newTestQueue creates a queue that runs requests through the given processor
+func newTestQueue(p *blockingProcessor) *SongQueue {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.process = p.process
+	queue.requestDelay = 0
+	// The requests all come from one user
+	queue.userLimit = 0
+	return queue
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newBlockingSongHandler creates a song handler for a bot with admin 99, whose
+// queue processes one request at a time until its context is cancelled
+func newBlockingSongHandler(t *testing.T) (*TelegramBot, *SongHandler, *SongQueue) {
+	t.Helper()
+	logger := logging.Discard()
+	bot, err := NewTelegramBot(&config.BotConfig{
+		Token:    "test_token",
+		APIID:    12345,
+		APIHash:  "test_hash",
+		AdminIDs: []int64{99},
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	songHandler := NewSongHandler(bot, logger)
+	queue := songHandler.GetQueue()
+	queue.requestDelay = 0
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return bot, songHandler, queue
+}
+
+func addTestRequests(t *testing.T, queue *SongQueue, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if _, err := queue.AddRequest(1, 2, i+1, fmt.Sprintf("https://music.apple.com/in/song/test/%d", i+1)); err != nil {
+			t.Fatalf("Failed to add request %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestSongQueue_SetLimits_Validation(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+
+	testCases := []struct {
+		size      int
+		workers   int
+		expectErr bool
+	}{
+		{size: 1, workers: 1},
+		{size: 50, workers: 4},
+		{size: 0, workers: 1, expectErr: true},
+		{size: 51, workers: 1, expectErr: true},
+		{size: 10, workers: 0, expectErr: true},
+		{size: 10, workers: 5, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		err := queue.SetLimits(tc.size, tc.workers)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("SetLimits(%d, %d) error = %v, expectErr %v", tc.size, tc.workers, err, tc.expectErr)
+		}
+	}
+}
+
+func TestSongQueue_ScaleUp(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	defer close(p.release)
+
+	addTestRequests(t, queue, 3)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	if err := queue.SetLimits(MaxQueueSize, 3); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+
+	waitFor(t, "all requests to start", func() bool { return p.Running() == 3 })
+	if size := queue.GetQueueSize(); size != 0 {
+		t.Errorf("Expected empty queue, got %d", size)
+	}
+}
+
+func TestSongQueue_ParentContextCancelsRequests(t *testing.T) {
+	parent, stop := context.WithCancel(context.Background())
+	queue := NewSongQueue(parent, logging.Discard(), nil)
+	queue.requestDelay = 0
+	started := make(chan struct{})
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	request, err := queue.AddRequest(1, 2, 1, "https://music.apple.com/in/song/test/1")
+	if err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+	<-started
+	stop()
+
+	waitFor(t, "the request to stop", func() bool { return !queue.IsProcessing() })
+	if request.Status != StatusCancelled {
+		t.Errorf("Expected the request to be cancelled, got %s", request.Status)
+	}
+}
+
+func TestSongQueue_ShutdownWaitsForProcessing(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+
+	addTestRequests(t, queue, 2)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	done := make(chan error, 1)
+	go func() { done <- queue.Shutdown(context.Background()) }()
+
+	// New requests are refused while the running one finishes
+	waitFor(t, "the queue to close", func() bool {
+		_, err := queue.AddRequest(1, 2, 9, "https://music.apple.com/in/song/test/9")
+		return errors.Is(err, ErrShuttingDown)
+	})
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v before the request finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(p.release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for Shutdown")
+	}
+
+	// The waiting request is kept for the next start instead of being started
+	if queue.IsProcessing() || queue.GetQueueSize() != 1 {
+		t.Errorf("Expected 1 waiting request and none processing, got %d waiting", queue.GetQueueSize())
+	}
+}
+
+func TestSongQueue_ShutdownRequeuesInterrupted(t *testing.T) {
+	p := newBlockingProcessor()
+	defer close(p.release)
+	queue := newTestQueue(p)
+	queue.shutdownGrace = 10 * time.Millisecond
+
+	addTestRequests(t, queue, 2)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	// The processor ignores the cancellation, so the request is re-queued by
+	// Shutdown once the grace period is over
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := queue.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline error, got %v", err)
+	}
+
+	info := queue.GetQueueInfo()
+	if len(info) != 2 || info[0].UniqueID != GenerateUniqueID(1, 2, 1) || info[0].Status != StatusQueued {
+		t.Errorf("Expected the interrupted request back at the front of the queue, got %+v", info)
+	}
+	if queue.IsProcessing() {
+		t.Error("Expected no request processing after Shutdown")
+	}
+}
+
+// depthMetrics records the queue depths reported to it
+type depthMetrics struct {
+	downloader.NoopMetrics
+	mu    sync.Mutex
+	depth int
+}
+
+func (m *depthMetrics) SetQueueDepth(depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depth = depth
+}
+
+func (m *depthMetrics) Depth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.depth
+}
+
+func TestSongQueue_ReportsDepth(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	defer close(p.release)
+
+	addTestRequests(t, queue, 3)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	// Setting the metrics reports the current depth
+	metrics := &depthMetrics{depth: -1}
+	queue.SetMetrics(metrics)
+	if depth := metrics.Depth(); depth != 2 {
+		t.Errorf("Expected depth 2, got %d", depth)
+	}
+
+	if _, err := queue.AddRequest(1, 2, 4, "https://music.apple.com/in/song/test/4"); err != nil {
+		t.Fatalf("Failed to add request: %v", err)
+	}
+	if depth := metrics.Depth(); depth != 3 {
+		t.Errorf("Expected depth 3 after adding a request, got %d", depth)
+	}
+
+	if err := queue.SetLimits(MaxQueueSize, 4); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	waitFor(t, "the queue to drain", func() bool { return metrics.Depth() == 0 })
+}
+
+func TestSongQueue_ScaleDownIsGraceful(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	defer close(p.release)
+
+	if err := queue.SetLimits(MaxQueueSize, 2); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+
+	addTestRequests(t, queue, 4)
+	waitFor(t, "two requests to start", func() bool { return p.Running() == 2 })
+
+	if err := queue.SetLimits(MaxQueueSize, 1); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+
+	// Running jobs are not interrupted by the scale-down
+	time.Sleep(20 * time.Millisecond)
+	if running := p.Running(); running != 2 {
+		t.Fatalf("Expected both running requests to continue, got %d", running)
+	}
+
+	// The worker that finishes first exits instead of taking the next request
+	p.release <- struct{}{}
+	waitFor(t, "one request to finish", func() bool { return p.Running() == 1 })
+	time.Sleep(20 * time.Millisecond)
+	if running := p.Running(); running != 1 {
+		t.Errorf("Expected 1 running request after scale-down, got %d", running)
+	}
+	if size := queue.GetQueueSize(); size != 2 {
+		t.Errorf("Expected 2 requests left in queue, got %d", size)
+	}
+
+	// The remaining worker picks up the next request when its job is done
+	p.release <- struct{}{}
+	waitFor(t, "next request to start", func() bool { return queue.GetQueueSize() == 1 })
+	waitFor(t, "next request to run", func() bool { return p.Running() == 1 })
+
+	p.mu.Lock()
+	peak := p.peak
+	p.mu.Unlock()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", peak)
+	}
+}
+
+func TestSongQueue_ShrinkBelowLength(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	defer close(p.release)
+
+	addTestRequests(t, queue, 5)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	if err := queue.SetLimits(2, 1); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+
+	if size := queue.GetQueueSize(); size != 4 {
+		t.Errorf("Expected existing requests to be kept, got queue size %d", size)
+	}
+
+	if _, err := queue.AddRequest(1, 2, 100, "https://music.apple.com/in/song/test/123"); err == nil {
+		t.Error("Expected new requests to be rejected while the queue is over capacity")
+	}
+}
+
+func TestSongQueue_LimitsPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), QueueSettingsFile)
+	logger := logging.Discard()
+
+	queue := NewSongQueue(context.Background(), logger, nil)
+	if err := queue.EnableSettingsPersistence(path); err != nil {
+		t.Fatalf("EnableSettingsPersistence failed: %v", err)
+	}
+	if err := queue.SetLimits(12, 3); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+
+	// A restarted queue starts from environment defaults, then loads the saved limits
+	restarted := NewSongQueue(context.Background(), logger, nil)
+	if err := restarted.SetLimits(MaxQueueSize, 1); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	if err := restarted.EnableSettingsPersistence(path); err != nil {
+		t.Fatalf("EnableSettingsPersistence failed: %v", err)
+	}
+
+	if size := restarted.MaxSize(); size != 12 {
+		t.Errorf("Expected saved size 12, got %d", size)
+	}
+	if workers := restarted.Workers(); workers != 3 {
+		t.Errorf("Expected saved workers 3, got %d", workers)
+	}
+}
+
+func TestSongQueue_LimitsRecordedAsOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), QueueSettingsFile)
+	if err := SaveQueueSettings(path, QueueSettings{MaxSize: 12, Workers: 3}); err != nil {
+		t.Fatalf("SaveQueueSettings failed: %v", err)
+	}
+
+	t.Setenv("QUEUE_SIZE", "")
+	t.Setenv("QUEUE_WORKERS", "")
+	registry := config.NewRegistry(&config.BotConfig{QueueSize: MaxQueueSize, QueueWorkers: 1})
+	sources := func() map[string]string {
+		values := make(map[string]string)
+		for _, entry := range registry.ConfigSnapshot() {
+			values[entry.Name] = fmt.Sprintf("%s (%s)", entry.Value, entry.Source)
+		}
+		return values
+	}
+
+	// Limits from the environment are not overrides
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	if err := queue.SetLimits(MaxQueueSize, 1); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	queue.SetConfigRegistry(registry)
+	if got := sources()["QUEUE_SIZE"]; got != "7 (default)" {
+		t.Errorf("QUEUE_SIZE before loading saved limits = %q", got)
+	}
+
+	// Saved /setqueue limits are
+	if err := queue.EnableSettingsPersistence(path); err != nil {
+		t.Fatalf("EnableSettingsPersistence failed: %v", err)
+	}
+	if got := sources(); got["QUEUE_SIZE"] != "12 (runtime)" || got["QUEUE_WORKERS"] != "3 (runtime)" {
+		t.Errorf("Queue limits after loading saved limits = %q, %q", got["QUEUE_SIZE"], got["QUEUE_WORKERS"])
+	}
+
+	if err := queue.SetLimits(20, 2); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	if got := sources(); got["QUEUE_SIZE"] != "20 (runtime)" || got["QUEUE_WORKERS"] != "2 (runtime)" {
+		t.Errorf("Queue limits after SetLimits = %q, %q", got["QUEUE_SIZE"], got["QUEUE_WORKERS"])
+	}
+}
+
+func TestSongQueue_RecordsFailedRequests(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.requestDelay = 0
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		return fmt.Errorf("download failed: boom")
+	}
+
+	if _, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/in/song/test/123"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	waitFor(t, "request to fail", func() bool { return len(queue.Failed().List(2, 1)) == 1 })
+
+	entry := queue.Failed().List(2, 1)[0]
+	if entry.Reason != "download failed: boom" {
+		t.Errorf("Expected failure reason to be kept, got %q", entry.Reason)
+	}
+	if entry.Request.CorrelationID != entry.Request.UniqueID {
+		t.Errorf("Expected correlation ID %q, got %q", entry.Request.UniqueID, entry.Request.CorrelationID)
+	}
+}
+
+// scriptedProcessor fails the tries of a request with the next error of its
+// script, succeeding once the script runs out
+type scriptedProcessor struct {
+	mu       sync.Mutex
+	script   []error
+	attempts []string
+}
+
+func (p *scriptedProcessor) process(ctx context.Context, cmdCtx *CommandContext) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts = append(p.attempts, fmt.Sprintf("%d/%d", cmdCtx.Attempt, cmdCtx.Attempts))
+	if len(p.script) == 0 {
+		return nil
+	}
+	err := p.script[0]
+	p.script = p.script[1:]
+	return err
+}
+
+func (p *scriptedProcessor) tries() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.attempts...)
+}
+
+func TestSongQueue_AutoRetry(t *testing.T) {
+	networkErr := fmt.Errorf("download failed: %w", downloader.NewDownloadError(downloader.ErrorNetworkFailure, "CDN returned 503"))
+	floodErr := fmt.Errorf("upload failed: %w", errors.New("FLOOD_WAIT_5"))
+	noALACErr := fmt.Errorf("download failed: %w", downloader.NewDownloadError(downloader.ErrorALACNotAvailable, "no ALAC stream"))
+
+	testCases := []struct {
+		name       string
+		retries    int
+		script     []error
+		tries      []string
+		failed     bool
+		lastStatus QueueStatus
+	}{
+		{"succeeds after transient failures", 2, []error{networkErr, floodErr}, []string{"1/3", "2/3", "3/3"}, false, StatusCompleted},
+		{"gives up after the last attempt", 1, []error{networkErr, networkErr, networkErr}, []string{"1/2", "2/2"}, true, StatusFailed},
+		{"fails permanent errors at once", 2, []error{noALACErr}, []string{"1/3"}, true, StatusFailed},
+		{"never retries when disabled", 0, []error{networkErr}, []string{"1/1"}, true, StatusFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &scriptedProcessor{script: tc.script}
+			queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+			queue.process = p.process
+			queue.requestDelay = 0
+			queue.SetAutoRetries(tc.retries)
+
+			request, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/in/song/test/123")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			waitFor(t, "the request to finish", func() bool {
+				result := queue.GetRequestsBySender(2, 1)
+				return len(result.Finished) == 1 && len(result.Queued) == 0 && len(result.Processing) == 0
+			})
+
+			if got := p.tries(); strings.Join(got, ",") != strings.Join(tc.tries, ",") {
+				t.Errorf("Expected tries %v, got %v", tc.tries, got)
+			}
+			finished := queue.GetRequestsBySender(2, 1).Finished[0]
+			if finished.Status != tc.lastStatus || finished.Attempt != len(tc.tries) {
+				t.Errorf("Expected attempt %d to end %s, got attempt %d %s", len(tc.tries), tc.lastStatus, finished.Attempt, finished.Status)
+			}
+			if finished.CorrelationID != request.CorrelationID {
+				t.Errorf("Expected the retries to keep correlation ID %q, got %q", request.CorrelationID, finished.CorrelationID)
+			}
+			if got := len(queue.Failed().List(2, 1)) == 1; got != tc.failed {
+				t.Errorf("Expected failed record %v, got %v", tc.failed, got)
+			}
+		})
+	}
+}
+
+func TestSongQueue_RetryKeepsStatusMessage(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.requestDelay = 0
+	statusIDs := make(chan int, 1)
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		statusIDs <- cmdCtx.StatusMessageID
+		return nil
+	}
+
+	queue.mu.Lock()
+	queue.retryLocked(&QueueRequest{UniqueID: "req-1", SenderID: 2, ChatID: 1, URL: "https://music.apple.com/in/song/test/123", StatusMessageID: 77})
+	queue.mu.Unlock()
+
+	select {
+	case id := <-statusIDs:
+		if id != 77 {
+			t.Errorf("Expected the retry to continue on status message 77, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the retry to be processed")
+	}
+}
+
+// fakeDownloadStatus is an in-flight download reporting a fixed status
+type fakeDownloadStatus struct {
+	status downloader.DownloadStatus
+}
+
+func (f *fakeDownloadStatus) GetStatus() downloader.DownloadStatus {
+	return f.status
+}
+
+func TestSongQueue_GetRequestsBySender(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.requestDelay = 0
+	queue.userLimit = 0
+
+	release := make(chan struct{})
+	defer close(release)
+
+	live := &fakeDownloadStatus{status: downloader.DownloadStatus{
+		Phase:    downloader.PhaseDownloading,
+		Progress: downloader.Progress{BytesProcessed: 42, TotalBytes: 100, Percentage: 42},
+		IsActive: true,
+	}}
+
+	queue.process = func(ctx context.Context, cmdCtx *CommandContext) error {
+		switch {
+		case strings.Contains(cmdCtx.Args, "ok"):
+			return nil
+		case strings.Contains(cmdCtx.Args, "fail"):
+			return fmt.Errorf("download failed: boom")
+		default:
+			queue.RegisterInFlight(cmdCtx.RequestID, live)
+			<-release
+			return nil
+		}
+	}
+
+	const chatID, userID, otherUserID = int64(-100), int64(10), int64(20)
+	add := func(senderID int64, messageID int, url string) {
+		if _, err := queue.AddRequest(senderID, chatID, messageID, url); err != nil {
+			t.Fatalf("Failed to add request %d: %v", messageID, err)
+		}
+	}
+
+	add(userID, 1, "https://music.apple.com/in/song/ok/1")
+	add(userID, 2, "https://music.apple.com/in/song/fail/2")
+	add(userID, 3, "https://music.apple.com/in/song/slow/3")
+	waitFor(t, "the slow request to be in flight", func() bool {
+		_, ok := queue.GetInFlightStatus(GenerateUniqueID(userID, chatID, 3))
+		return ok
+	})
+
+	add(otherUserID, 4, "https://music.apple.com/in/song/other/4")
+	add(userID, 5, "https://music.apple.com/in/song/queued/5")
+
+	requests := queue.GetRequestsBySender(chatID, userID)
+
+	if len(requests.Queued) != 1 {
+		t.Fatalf("Expected 1 queued request, got %d", len(requests.Queued))
+	}
+	queued := requests.Queued[0]
+	if queued.Request.MessageID != 5 || queued.Position != 2 {
+		t.Errorf("Expected message 5 at position 2, got message %d at %d", queued.Request.MessageID, queued.Position)
+	}
+	if queued.ETA <= 0 {
+		t.Errorf("Expected a positive ETA, got %v", queued.ETA)
+	}
+
+	if len(requests.Processing) != 1 {
+		t.Fatalf("Expected 1 processing request, got %d", len(requests.Processing))
+	}
+	processing := requests.Processing[0]
+	if !processing.HasStatus || processing.Status.Phase != downloader.PhaseDownloading || processing.Status.Progress.Percentage != 42 {
+		t.Errorf("Expected live downloading status at 42%%, got %+v", processing)
+	}
+
+	if len(requests.Finished) != 2 {
+		t.Fatalf("Expected 2 finished requests, got %d", len(requests.Finished))
+	}
+	if requests.Finished[0].MessageID != 2 || requests.Finished[0].Status != StatusFailed || requests.Finished[0].FailureReason != "download failed: boom" {
+		t.Errorf("Expected the failed request first, got %+v", requests.Finished[0])
+	}
+	if requests.Finished[1].MessageID != 1 || requests.Finished[1].Status != StatusCompleted {
+		t.Errorf("Expected the completed request second, got %+v", requests.Finished[1])
+	}
+
+	// Nothing of the other user is included
+	for _, info := range requests.Queued {
+		if info.Request.SenderID != userID {
+			t.Errorf("Leaked request of user %d", info.Request.SenderID)
+		}
+	}
+}
+
+func TestSongQueue_GetRequestsBySender_None(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.process = newBlockingProcessor().process
+
+	addTestRequests(t, queue, 2)
+
+	if requests := queue.GetRequestsBySender(2, 99); !requests.IsEmpty() {
+		t.Errorf("Expected no requests for another user, got %+v", requests)
+	}
+	if requests := queue.GetRequestsBySender(3, 1); !requests.IsEmpty() {
+		t.Errorf("Expected no requests for the same user in another chat, got %+v", requests)
+	}
+}
+
+func TestSongQueue_EstimateWait(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+	queue.workers = 2
+	queue.averageDuration = time.Minute
+
+	testCases := []struct {
+		position int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 3 * time.Minute},
+	}
+
+	for _, tc := range testCases {
+		if got := queue.estimateWaitLocked(tc.position); got != tc.expected {
+			t.Errorf("estimateWaitLocked(%d) = %v, want %v", tc.position, got, tc.expected)
+		}
+	}
+}
+
+func TestSongQueue_RollingAverageDuration(t *testing.T) {
+	queue := NewSongQueue(context.Background(), logging.Discard(), nil)
+
+	queue.recordDurationLocked(time.Minute)
+	queue.recordDurationLocked(3 * time.Minute)
+	if queue.averageDuration != 2*time.Minute {
+		t.Errorf("Expected an average of 2m, got %v", queue.averageDuration)
+	}
+
+	// Only the last RecentDurationWindow requests count
+	for i := 0; i < RecentDurationWindow; i++ {
+		queue.recordDurationLocked(30 * time.Second)
+	}
+	if queue.averageDuration != 30*time.Second || len(queue.recentDurations) != RecentDurationWindow {
+		t.Errorf("Expected an average of 30s over %d requests, got %v over %d", RecentDurationWindow, queue.averageDuration, len(queue.recentDurations))
+	}
+}
+
+// movedEventRecorder records the moved events of a queue
+type movedEventRecorder struct {
+	mu     sync.Mutex
+	events []QueueEvent
+}
+
+func (r *movedEventRecorder) OnQueueEvent(event QueueEvent) {
+	if event.Kind == QueueEventMoved {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, event)
+	}
+}
+
+func (r *movedEventRecorder) take() []QueueEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestSongQueue_MovedEvents(t *testing.T) {
+	p := newBlockingProcessor()
+	defer close(p.release)
+	queue := newTestQueue(p)
+	queue.averageDuration = time.Minute
+
+	listener := &movedEventRecorder{}
+	queue.AddListener(listener)
+
+	// The first request starts right away, the next three wait
+	addTestRequests(t, queue, 1)
+	waitFor(t, "the first request to start", func() bool { return p.Running() == 1 })
+	for i := 2; i <= 4; i++ {
+		request, err := queue.AddRequest(1, 2, i, fmt.Sprintf("https://music.apple.com/in/song/test/%d", i))
+		if err != nil {
+			t.Fatalf("Failed to add request %d: %v", i, err)
+		}
+		queue.SetStatusMessage(request.UniqueID, 100+i)
+	}
+
+	// Cancelling the second queued request moves up only the one behind it
+	if !queue.CancelRequest(GenerateUniqueID(1, 2, 3)) {
+		t.Fatal("Expected the queued request to be cancelled")
+	}
+	events := listener.take()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 moved event, got %+v", events)
+	}
+	if event := events[0]; event.RequestID != GenerateUniqueID(1, 2, 4) || event.ChatID != 2 || event.StatusMessageID != 104 ||
+		event.Position != 2 || event.QueueSize != 2 || event.ETA != 2*time.Minute {
+		t.Errorf("Unexpected moved event: %+v", event)
+	}
+
+	// A worker picking up the first queued request moves up the rest and
+	// marks its own status message as processing
+	queue.SetLimits(queue.MaxSize(), 2)
+	waitFor(t, "the second request to start", func() bool { return p.Running() == 2 })
+	events = listener.take()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 moved events, got %+v", events)
+	}
+	if event := events[0]; event.RequestID != GenerateUniqueID(1, 2, 4) || event.Position != 1 || event.QueueSize != 1 {
+		t.Errorf("Unexpected moved event: %+v", event)
+	}
+	if event := events[1]; event.RequestID != GenerateUniqueID(1, 2, 2) || event.StatusMessageID != 102 || event.Position != 0 {
+		t.Errorf("Expected the started request at position 0, got %+v", event)
+	}
+}
+
+func TestSongQueue_UserLimit(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	queue.SetUserLimit(2)
+	defer close(p.release)
+
+	addTestRequests(t, queue, 2)
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+
+	// The processing request still counts against the limit
+	if count := queue.GetUserQueueCount(1); count != 2 {
+		t.Errorf("GetUserQueueCount() = %d, want 2", count)
+	}
+
+	_, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/in/song/test/3")
+	var limitErr *UserLimitError
+	if !errors.As(err, &limitErr) || limitErr.Pending != 2 || limitErr.Limit != 2 {
+		t.Fatalf("Expected a UserLimitError for the third request, got %v", err)
+	}
+
+	// Other users and chats are unaffected by the count
+	if _, err := queue.AddRequest(7, 2, 4, "https://music.apple.com/in/song/test/4"); err != nil {
+		t.Errorf("Expected another user's request to be accepted, got %v", err)
+	}
+	if count := queue.GetUserQueueCount(7); count != 1 {
+		t.Errorf("GetUserQueueCount() for another user = %d, want 1", count)
+	}
+
+	// Finishing a request frees a slot
+	p.release <- struct{}{}
+	waitFor(t, "a slot to free up", func() bool { return queue.GetUserQueueCount(1) == 1 })
+	if _, err := queue.AddRequest(1, 2, 5, "https://music.apple.com/in/song/test/5"); err != nil {
+		t.Errorf("Expected a request to be accepted once a slot is free, got %v", err)
+	}
+}
+
+func TestSongQueue_RejectsDuplicateURL(t *testing.T) {
+	p := newBlockingProcessor()
+	queue := newTestQueue(p)
+	defer close(p.release)
+
+	const chatID = int64(-100)
+	if _, err := queue.AddRequest(1, chatID, 1, "https://music.apple.com/in/song/first/1"); err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+	waitFor(t, "first request to start", func() bool { return p.Running() == 1 })
+	queued, err := queue.AddRequest(1, chatID, 2, "https://music.apple.com/in/album/second/20?i=2")
+	if err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+
+	testCases := []struct {
+		name             string
+		url              string
+		expectedID       string
+		expectedPosition int
+	}{
+		{"processing song", "https://music.apple.com/in/song/other-slug/1", GenerateUniqueID(1, chatID, 1), 0},
+		{"queued song from another link", "https://music.apple.com/in/song/second/2", queued.UniqueID, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, position := queue.FindByURL(chatID, tc.url)
+			if request == nil || request.UniqueID != tc.expectedID || position != tc.expectedPosition {
+				t.Fatalf("FindByURL() = %v at %d, want %s at %d", request, position, tc.expectedID, tc.expectedPosition)
+			}
+
+			_, err := queue.AddRequest(2, chatID, 10, tc.url)
+			var duplicateErr *DuplicateRequestError
+			if !errors.As(err, &duplicateErr) || duplicateErr.RequestID != tc.expectedID || duplicateErr.Position != tc.expectedPosition {
+				t.Errorf("Expected a DuplicateRequestError for %s at %d, got %v", tc.expectedID, tc.expectedPosition, err)
+			}
+		})
+	}
+
+	// The same song in another chat or storefront is a different request
+	if request, _ := queue.FindByURL(chatID+1, "https://music.apple.com/in/song/first/1"); request != nil {
+		t.Errorf("Expected no match in another chat, got %s", request.UniqueID)
+	}
+	if _, err := queue.AddRequest(2, chatID, 11, "https://music.apple.com/us/song/first/1"); err != nil {
+		t.Errorf("Expected the song from another storefront to be accepted, got %v", err)
+	}
+}
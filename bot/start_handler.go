@@ -3,23 +3,24 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/gotd/td/tg"
+	"go-alac-bot/logging"
 )
 
 // StartHandler implements CommandHandler for the /start command
 type StartHandler struct {
 	client       *TelegramBot
-	logger       *log.Logger
+	sender       MessageSender
+	logger       logging.Logger
 	errorHandler *ErrorHandler
 }
 
 // NewStartHandler creates a new StartHandler instance
-func NewStartHandler(client *TelegramBot, logger *log.Logger) *StartHandler {
+func NewStartHandler(client *TelegramBot, logger logging.Logger) *StartHandler {
 	handler := &StartHandler{
 		client: client,
+		sender: client,
 		logger: logger,
 	}
 	
@@ -40,7 +41,7 @@ func (h *StartHandler) Command() string {
 func (h *StartHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
 	startTime := time.Now()
 	
-	h.logger.Printf("Processing /start command for user %d in chat %d", cmdCtx.UserID, cmdCtx.ChatID)
+	h.logger.Debug("Processing /start command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
 	
 	// Create context with timeout to meet 5-second requirement
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -53,8 +54,8 @@ func (h *StartHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	welcomeMessage := h.createWelcomeMessage(userName)
 	
 	// Send the welcome message with error handling
-	if err := h.sendMessage(timeoutCtx, cmdCtx.ChatID, welcomeMessage); err != nil {
-		h.logger.Printf("Failed to send welcome message to chat %d: %v", cmdCtx.ChatID, err)
+	if err := h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, welcomeMessage); err != nil {
+		h.logger.Error("Failed to send welcome message", logging.Int64("Chat", cmdCtx.ChatID), logging.Err(err))
 		
 		// Use error handler if available for network errors
 		if h.errorHandler != nil && h.errorHandler.IsNetworkError(err) {
@@ -66,8 +67,8 @@ func (h *StartHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error
 	
 	// Log successful processing with timing
 	processingTime := time.Since(startTime)
-	h.logger.Printf("Successfully processed /start command for user %d (took %v)", 
-		cmdCtx.UserID, processingTime)
+	h.logger.Debug("Processed /start command", logging.Int64("User", cmdCtx.UserID),
+		logging.Duration("Took", processingTime))
 	
 	return nil
 }
@@ -93,38 +94,3 @@ func (h *StartHandler) extractUserName(cmdCtx *CommandContext) string {
 func (h *StartHandler) createWelcomeMessage(userName string) string {
 	return "Welcome to apple bot. use /help to see how to use it.\n\nFor now this bot only works in permitted group."
 }
-
-// sendMessage sends a text message to the specified chat
-func (h *StartHandler) sendMessage(ctx context.Context, chatID int64, message string) error {
-	if h.client == nil || h.client.GetClient() == nil {
-		return fmt.Errorf("bot client is not initialized")
-	}
-	
-	// For bot API, we need to determine the correct peer type
-	// In most cases for bots, we're dealing with private chats (users)
-	var peer tg.InputPeerClass
-	
-	// If chatID is positive, it's likely a user chat
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		// For negative chat IDs, it could be a group or channel
-		// For now, we'll treat it as a chat (group)
-		peer = &tg.InputPeerChat{ChatID: -chatID}
-	}
-	
-	// Create the message request
-	request := &tg.MessagesSendMessageRequest{
-		Peer:    peer,
-		Message: message,
-		RandomID: time.Now().UnixNano(), // Add random ID to prevent duplicate messages
-	}
-	
-	// Send the message using gotgproto client
-	_, err := h.client.GetClient().API().MessagesSendMessage(ctx, request)
-	if err != nil {
-		return fmt.Errorf("failed to send message via Telegram API: %w", err)
-	}
-	
-	return nil
-}
\ No newline at end of file
@@ -2,14 +2,15 @@ package bot
 
 import (
 	"context"
-	"log"
 	"os"
 	"testing"
 	"time"
+
+	"go-alac-bot/logging"
 )
 
 func TestStartHandler_Command(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewStartHandler(nil, logger)
 	
 	expected := "start"
@@ -19,7 +20,7 @@ func TestStartHandler_Command(t *testing.T) {
 }
 
 func TestStartHandler_ExtractUserName(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewStartHandler(nil, logger)
 	
 	tests := []struct {
@@ -71,7 +72,7 @@ func TestStartHandler_ExtractUserName(t *testing.T) {
 }
 
 func TestStartHandler_CreateWelcomeMessage(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewStartHandler(nil, logger)
 	
 	tests := []struct {
@@ -119,7 +120,7 @@ func TestStartHandler_CreateWelcomeMessage(t *testing.T) {
 }
 
 func TestStartHandler_Handle_WithoutClient(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	handler := NewStartHandler(nil, logger)
 	
 	ctx := context.Background()
@@ -139,7 +140,7 @@ func TestStartHandler_Handle_WithoutClient(t *testing.T) {
 }
 
 func TestStartHandler_Handle_Timing(t *testing.T) {
-	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	logger := logging.New(os.Stdout, logging.LevelDebug)
 	
 	// Create a mock bot that will simulate a slow response
 	mockBot := &TelegramBot{}
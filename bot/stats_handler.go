@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// StatsHandler implements CommandHandler for the admin /stats command
+type StatsHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewStatsHandler creates a new StatsHandler instance
+func NewStatsHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *StatsHandler {
+	handler := &StatsHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *StatsHandler) Command() string {
+	return "stats"
+}
+
+// AdminOnly reports that only administrators may run /stats
+func (h *StatsHandler) AdminOnly() bool {
+	return true
+}
+
+// Handle processes the /stats command and shows download statistics
+func (h *StatsHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /stats command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	manager := h.songHandler.manager
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createStatsMessage(manager.StorefrontScores(), manager.Latencies().Stats(), manager.EditRate().Stats(), h.songHandler.limiter.Stats()))
+}
+
+// createStatsMessage creates the statistics shown by /stats
+func createStatsMessage(scores []downloader.StorefrontScore, latencies []downloader.LatencyStats, edits downloader.EditRateStats, limits RateLimitStats) string {
+	var message strings.Builder
+	message.WriteString("📊 **Bot Stats**\n\n")
+
+	if len(scores) == 0 {
+		message.WriteString("🌍 Storefront health: no downloads yet")
+	} else {
+		fmt.Fprintf(&message, "🌍 Storefront health: %s", formatStorefrontScores(scores))
+	}
+
+	message.WriteString("\n\n⏱ Latency (first byte / total, p50 · p95):")
+	if len(latencies) == 0 {
+		message.WriteString(" no calls yet")
+	}
+	for _, l := range latencies {
+		fmt.Fprintf(&message, "\n• %s: %s / %s · %s / %s (%d calls)", l.Dependency,
+			formatLatency(l.FirstByteP50), formatLatency(l.TotalP50),
+			formatLatency(l.FirstByteP95), formatLatency(l.TotalP95), l.Samples)
+	}
+
+	fmt.Fprintf(&message, "\n\n✏️ Progress edits: every %s per message, %.1f/s (limit %.1f/s), %d FLOOD_WAITs in the last %s",
+		edits.Interval, edits.Rate, edits.Limit, edits.FloodWaits, formatStatsWindow(downloader.FloodWaitStatsWindow))
+
+	fmt.Fprintf(&message, "\n\n🚦 Song requests: %s per user, %s per chat; %d allowed, %d rate limited (%d users and %d chats tracked)",
+		formatRateLimit(limits.UserRate), formatRateLimit(limits.ChatRate), limits.Allowed, limits.Limited, limits.Users, limits.Chats)
+
+	return message.String()
+}
+
+// formatStatsWindow renders a whole-minute window as "10m"
+func formatStatsWindow(d time.Duration) string {
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// formatRateLimit renders a requests per minute limit as "5/min"
+func formatRateLimit(rate int) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/min", rate)
+}
+
+// formatLatency renders d in milliseconds below a second, seconds otherwise
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// formatStorefrontScores renders scores as "us 0.98, gb 0.91, jp 0.40"
+func formatStorefrontScores(scores []downloader.StorefrontScore) string {
+	parts := make([]string, len(scores))
+	for i, score := range scores {
+		parts[i] = fmt.Sprintf("%s %.2f", score.Storefront, score.Score)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestStatsHandler_Command(t *testing.T) {
+	logger := logging.New(os.Stdout, logging.LevelDebug)
+	handler := NewStatsHandler(nil, logger, nil)
+
+	expected := "stats"
+	if got := handler.Command(); got != expected {
+		t.Errorf("StatsHandler.Command() = %v, want %v", got, expected)
+	}
+}
+
+func TestCreateStatsMessage(t *testing.T) {
+	if message := createStatsMessage(nil, nil, downloader.EditRateStats{}, RateLimitStats{}); !strings.Contains(message, "Storefront health: no downloads yet") {
+		t.Errorf("Expected empty storefront health, got %q", message)
+	}
+
+	message := createStatsMessage([]downloader.StorefrontScore{
+		{Storefront: "us", Score: 0.981},
+		{Storefront: "gb", Score: 0.912},
+		{Storefront: "jp", Score: 0.4},
+	}, nil, downloader.EditRateStats{}, RateLimitStats{})
+	if want := "Storefront health: us 0.98, gb 0.91, jp 0.40"; !strings.Contains(message, want) {
+		t.Errorf("Expected message to contain %q, got %q", want, message)
+	}
+}
+
+func TestCreateStatsMessage_Latency(t *testing.T) {
+	if message := createStatsMessage(nil, nil, downloader.EditRateStats{}, RateLimitStats{}); !strings.Contains(message, "no calls yet") {
+		t.Errorf("Expected no latency calls, got %q", message)
+	}
+
+	message := createStatsMessage(nil, []downloader.LatencyStats{{
+		Dependency:   downloader.DepCatalogAPI,
+		Samples:      12,
+		FirstByteP50: 120 * time.Millisecond,
+		FirstByteP95: 900 * time.Millisecond,
+		TotalP50:     150 * time.Millisecond,
+		TotalP95:     2500 * time.Millisecond,
+	}}, downloader.EditRateStats{}, RateLimitStats{})
+	if want := "catalog_api: 120ms / 150ms · 900ms / 2.5s (12 calls)"; !strings.Contains(message, want) {
+		t.Errorf("Expected message to contain %q, got %q", want, message)
+	}
+}
+
+func TestCreateStatsMessage_EditRate(t *testing.T) {
+	message := createStatsMessage(nil, nil, downloader.EditRateStats{Interval: 4 * time.Second, Rate: 2.75, Limit: 1.5, FloodWaits: 3}, RateLimitStats{})
+	if want := "Progress edits: every 4s per message, 2.8/s (limit 1.5/s), 3 FLOOD_WAITs in the last 10m"; !strings.Contains(message, want) {
+		t.Errorf("Expected message to contain %q, got %q", want, message)
+	}
+}
+
+func TestCreateStatsMessage_RateLimits(t *testing.T) {
+	message := createStatsMessage(nil, nil, downloader.EditRateStats{}, RateLimitStats{UserRate: 5, Allowed: 12, Limited: 3, Users: 2, Chats: 1})
+	if want := "Song requests: 5/min per user, unlimited per chat; 12 allowed, 3 rate limited (2 users and 1 chats tracked)"; !strings.Contains(message, want) {
+		t.Errorf("Expected message to contain %q, got %q", want, message)
+	}
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+// statusCheckTimeout bounds all dependency checks of /status together
+const statusCheckTimeout = 5 * time.Second
+
+// StatusHandler implements CommandHandler for the /status command
+type StatusHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewStatusHandler creates a new StatusHandler instance
+func NewStatusHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *StatusHandler {
+	handler := &StatusHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *StatusHandler) Command() string {
+	return "status"
+}
+
+// Handle processes the /status command and reports whether downloads can
+// currently succeed
+func (h *StatusHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /status command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	checkCtx, cancelChecks := context.WithTimeout(ctx, statusCheckTimeout)
+	status, err := h.songHandler.CheckDependencies(checkCtx)
+	cancelChecks()
+	if err != nil {
+		h.logger.Warn("Dependency checks failed", logging.Err(err))
+	}
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createStatusMessage(status, h.songHandler.GetQueue()))
+}
+
+// createStatusMessage formats the dependency checks and queue occupancy
+func createStatusMessage(status downloader.DependencyStatus, queue *SongQueue) string {
+	var b strings.Builder
+	b.WriteString("🩺 Bot Status\n\n")
+
+	for _, check := range status.Checks() {
+		name := check.Name
+		if check.Target != "" {
+			name += " " + check.Target
+		}
+		if check.OK() {
+			fmt.Fprintf(&b, "✅ %s: %s\n", name, check.Latency.Round(time.Millisecond))
+		} else {
+			fmt.Fprintf(&b, "❌ %s: %v\n", name, check.Err)
+		}
+	}
+
+	if status.DiskErr != nil {
+		fmt.Fprintf(&b, "❌ Disk space %s: %v\n", status.DownloadDir, status.DiskErr)
+	} else {
+		fmt.Fprintf(&b, "💾 Disk space %s: %s free\n", status.DownloadDir, downloader.FormatBytes(int64(status.DiskFree)))
+	}
+
+	if queue == nil {
+		b.WriteString("\n📋 Queue system is not available.")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n📋 Queue: %d/%d queued, %d/%d %s busy",
+		queue.GetQueueSize(), queue.MaxSize(), len(queue.GetProcessing()), queue.Workers(),
+		pluralize(queue.Workers(), "worker", "workers"))
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestStatusHandler_Command(t *testing.T) {
+	logger := logging.Discard()
+	handler := NewStatusHandler(nil, logger, NewSongHandler(nil, logger))
+
+	if got := handler.Command(); got != "status" {
+		t.Errorf("StatusHandler.Command() = %v, want status", got)
+	}
+}
+
+func TestCreateStatusMessage(t *testing.T) {
+	logger := logging.Discard()
+	queue := NewSongQueue(context.Background(), logger, nil)
+	queue.workers = 0
+	if _, err := queue.AddRequest(1, 2, 3, "https://music.apple.com/in/song/test/1"); err != nil {
+		t.Fatalf("AddRequest() error = %v", err)
+	}
+
+	status := downloader.DependencyStatus{
+		Device:      downloader.DependencyCheck{Name: "Device (M3U8_URL)", Target: "127.0.0.1:20020", Latency: 3 * time.Millisecond},
+		Decryptor:   downloader.DependencyCheck{Name: "Decryptor (DEC_URL)", Target: "127.0.0.1:10020", Err: errors.New("connection refused")},
+		Token:       downloader.DependencyCheck{Name: "Apple Music token", Latency: 120 * time.Millisecond},
+		DownloadDir: "downloads",
+		DiskFree:    3 << 30,
+	}
+
+	message := createStatusMessage(status, queue)
+	for _, want := range []string{
+		"✅ Device (M3U8_URL) 127.0.0.1:20020: 3ms",
+		"❌ Decryptor (DEC_URL) 127.0.0.1:10020: connection refused",
+		"✅ Apple Music token: 120ms",
+		"💾 Disk space downloads: 3.0 GB free",
+		"📋 Queue: 1/",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("Expected %q in the status message, got:\n%s", want, message)
+		}
+	}
+
+	status.DiskErr = errors.New("no such file or directory")
+	if message := createStatusMessage(status, nil); !strings.Contains(message, "❌ Disk space downloads: no such file or directory") ||
+		!strings.Contains(message, "Queue system is not available") {
+		t.Errorf("Unexpected status message without disk space and queue:\n%s", message)
+	}
+}
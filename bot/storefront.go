@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultStorefront is used when no other storefront hint is available
+const DefaultStorefront = "us"
+
+// StorefrontSource describes where an inferred storefront came from
+type StorefrontSource string
+
+const (
+	StorefrontFromChat     StorefrontSource = "chat"
+	StorefrontFromLanguage StorefrontSource = "language"
+	StorefrontFromDefault  StorefrontSource = "default"
+)
+
+// StorefrontHints are the inputs used to infer a storefront for URLs that
+// don't specify one, in order of precedence
+type StorefrontHints struct {
+	ChatStorefront string // Storefront configured for the chat
+	LanguageCode   string // Telegram language_code of the requester
+	Default        string // Global DEFAULT_STOREFRONT
+}
+
+// languageStorefronts maps Telegram language codes to the Apple Music
+// storefront most users of that language are on. Region-specific codes are
+// looked up before their base language
+var languageStorefronts = map[string]string{
+	"en":      "us",
+	"en-gb":   "gb",
+	"en-au":   "au",
+	"en-ca":   "ca",
+	"en-in":   "in",
+	"de":      "de",
+	"fr":      "fr",
+	"es":      "es",
+	"it":      "it",
+	"pt":      "br",
+	"pt-br":   "br",
+	"pt-pt":   "pt",
+	"nl":      "nl",
+	"sv":      "se",
+	"da":      "dk",
+	"nb":      "no",
+	"no":      "no",
+	"fi":      "fi",
+	"pl":      "pl",
+	"cs":      "cz",
+	"hu":      "hu",
+	"ro":      "ro",
+	"el":      "gr",
+	"tr":      "tr",
+	"ru":      "ru",
+	"uk":      "ua",
+	"ja":      "jp",
+	"ko":      "kr",
+	"zh":      "cn",
+	"zh-hans": "cn",
+	"zh-hant": "tw",
+	"hi":      "in",
+	"id":      "id",
+	"th":      "th",
+	"vi":      "vn",
+	"ar":      "sa",
+	"he":      "il",
+}
+
+// StorefrontForLanguage returns the storefront for a Telegram language code
+func StorefrontForLanguage(languageCode string) (string, bool) {
+	code := strings.ToLower(strings.TrimSpace(languageCode))
+	if code == "" {
+		return "", false
+	}
+
+	if storefront, ok := languageStorefronts[code]; ok {
+		return storefront, true
+	}
+
+	if base, _, found := strings.Cut(code, "-"); found {
+		if storefront, ok := languageStorefronts[base]; ok {
+			return storefront, true
+		}
+	}
+
+	return "", false
+}
+
+// InferStorefront picks a storefront from the chat setting, then the
+// requester's language, then the global default
+func InferStorefront(hints StorefrontHints) (string, StorefrontSource) {
+	if hints.ChatStorefront != "" {
+		return strings.ToLower(hints.ChatStorefront), StorefrontFromChat
+	}
+
+	if storefront, ok := StorefrontForLanguage(hints.LanguageCode); ok {
+		return storefront, StorefrontFromLanguage
+	}
+
+	if hints.Default != "" {
+		return strings.ToLower(hints.Default), StorefrontFromDefault
+	}
+
+	return DefaultStorefront, StorefrontFromDefault
+}
+
+// ChatStorefronts holds per-chat storefront settings
+type ChatStorefronts struct {
+	mu          sync.RWMutex
+	storefronts map[int64]string
+}
+
+// NewChatStorefronts creates an empty set of chat storefront settings
+func NewChatStorefronts() *ChatStorefronts {
+	return &ChatStorefronts{
+		storefronts: make(map[int64]string),
+	}
+}
+
+// Get returns the storefront configured for a chat, or "" if none is set
+func (c *ChatStorefronts) Get(chatID int64) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.storefronts[chatID]
+}
+
+// Set configures the storefront for a chat; an empty storefront clears it
+func (c *ChatStorefronts) Set(chatID int64, storefront string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if storefront == "" {
+		delete(c.storefronts, chatID)
+		return
+	}
+	c.storefronts[chatID] = strings.ToLower(storefront)
+}
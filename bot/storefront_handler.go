@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// StorefrontHandler implements CommandHandler for the /storefront command,
+// which sets the storefront assumed for links without one in a chat
+type StorefrontHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewStorefrontHandler creates a new StorefrontHandler instance
+func NewStorefrontHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *StorefrontHandler {
+	handler := &StorefrontHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *StorefrontHandler) Command() string {
+	return "storefront"
+}
+
+// Handle processes the /storefront command, showing or changing the chat setting
+func (h *StorefrontHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /storefront command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	settings := h.songHandler.GetStorefronts()
+
+	storefront, changed, err := parseStorefrontArgs(cmdCtx.Args, settings.Get(cmdCtx.ChatID))
+	if err != nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error())
+	}
+
+	if changed {
+		settings.Set(cmdCtx.ChatID, storefront)
+		h.logger.Info("Chat storefront set", logging.String("Storefront", storefront), logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createStorefrontMessage(storefront, changed))
+}
+
+// parseStorefrontArgs parses a two-letter country code, or "default" to clear
+// the setting. No argument keeps the current setting
+func parseStorefrontArgs(args, current string) (storefront string, changed bool, err error) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+	switch {
+	case arg == "":
+		return current, false, nil
+	case arg == "default":
+		return "", true, nil
+	case len(arg) == 2 && arg[0] >= 'a' && arg[0] <= 'z' && arg[1] >= 'a' && arg[1] <= 'z':
+		return arg, true, nil
+	default:
+		return current, false, fmt.Errorf("Usage: /storefront <country code>|default, e.g. /storefront gb")
+	}
+}
+
+// createStorefrontMessage describes the storefront setting of a chat
+func createStorefrontMessage(storefront string, changed bool) string {
+	if storefront == "" {
+		message := "This chat has no storefront set."
+		if changed {
+			message = "✅ This chat's storefront was cleared."
+		}
+		return message + "\n\nLinks without a storefront use your Telegram language, then the bot's default. Use /storefront <country code> to pick one."
+	}
+
+	message := fmt.Sprintf("This chat's storefront is %s.", strings.ToUpper(storefront))
+	if changed {
+		message = fmt.Sprintf("✅ This chat's storefront is now %s.", strings.ToUpper(storefront))
+	}
+	return message + "\n\nLinks without a storefront are looked up there. Use /storefront default to clear it."
+}
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+func TestParseStorefrontArgs(t *testing.T) {
+	testCases := []struct {
+		args        string
+		current     string
+		want        string
+		wantChanged bool
+		wantErr     bool
+	}{
+		{"", "jp", "jp", false, false},
+		{"GB", "", "gb", true, false},
+		{" de ", "jp", "de", true, false},
+		{"default", "jp", "", true, false},
+		{"usa", "jp", "jp", false, true},
+		{"1a", "", "", false, true},
+	}
+
+	for _, tc := range testCases {
+		storefront, changed, err := parseStorefrontArgs(tc.args, tc.current)
+		if storefront != tc.want || changed != tc.wantChanged || (err != nil) != tc.wantErr {
+			t.Errorf("parseStorefrontArgs(%q, %q) = %q, %v, %v", tc.args, tc.current, storefront, changed, err)
+		}
+	}
+}
+
+func TestStorefrontHandler_SetsChatStorefront(t *testing.T) {
+	sender := &recordingSender{}
+	songHandler := NewSongHandler(nil, logging.Discard())
+	handler := NewStorefrontHandler(nil, logging.Discard(), songHandler)
+	handler.sender = sender
+
+	if err := handler.Handle(context.Background(), &CommandContext{ChatID: 1, Args: "gb"}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if got := songHandler.GetStorefronts().Get(1); got != "gb" {
+		t.Errorf("Expected storefront gb for the chat, got %q", got)
+	}
+
+	// Links without a storefront now use the chat's
+	if got, source := InferStorefront(songHandler.storefrontHints(&CommandContext{ChatID: 1, LanguageCode: "ja"})); got != "gb" || source != StorefrontFromChat {
+		t.Errorf("Expected the chat storefront to be inferred, got %q from %s", got, source)
+	}
+
+	if err := handler.Handle(context.Background(), &CommandContext{ChatID: 1, Args: "default"}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if got := songHandler.GetStorefronts().Get(1); got != "" {
+		t.Errorf("Expected the storefront to be cleared, got %q", got)
+	}
+
+	sent := sender.sent()
+	if len(sent) != 2 || !strings.Contains(sent[0].message, "now GB") || !strings.Contains(sent[1].message, "cleared") {
+		t.Errorf("Unexpected replies %+v", sent)
+	}
+}
@@ -0,0 +1,105 @@
+package bot
+
+import "testing"
+
+func TestStorefrontForLanguage(t *testing.T) {
+	testCases := []struct {
+		languageCode string
+		expected     string
+		found        bool
+	}{
+		{"en", "us", true},
+		{"EN", "us", true},
+		{"en-gb", "gb", true},
+		{"en-nz", "us", true}, // Falls back to the base language
+		{"de", "de", true},
+		{"ja", "jp", true},
+		{"ko", "kr", true},
+		{"pt-br", "br", true},
+		{"pt-pt", "pt", true},
+		{"zh-hant", "tw", true},
+		{"uk", "ua", true},
+		{"sv", "se", true},
+		{"xx", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.languageCode, func(t *testing.T) {
+			storefront, found := StorefrontForLanguage(tc.languageCode)
+			if storefront != tc.expected || found != tc.found {
+				t.Errorf("StorefrontForLanguage(%q) = %q, %v; want %q, %v",
+					tc.languageCode, storefront, found, tc.expected, tc.found)
+			}
+		})
+	}
+}
+
+func TestStorefrontMappingUsesValidCodes(t *testing.T) {
+	for language, storefront := range languageStorefronts {
+		if len(storefront) != 2 {
+			t.Errorf("Language %q maps to invalid storefront %q", language, storefront)
+		}
+	}
+}
+
+func TestInferStorefront(t *testing.T) {
+	testCases := []struct {
+		name           string
+		hints          StorefrontHints
+		expected       string
+		expectedSource StorefrontSource
+	}{
+		{
+			name:           "chat setting wins",
+			hints:          StorefrontHints{ChatStorefront: "GB", LanguageCode: "de", Default: "in"},
+			expected:       "gb",
+			expectedSource: StorefrontFromChat,
+		},
+		{
+			name:           "language before default",
+			hints:          StorefrontHints{LanguageCode: "ja", Default: "in"},
+			expected:       "jp",
+			expectedSource: StorefrontFromLanguage,
+		},
+		{
+			name:           "unknown language uses default",
+			hints:          StorefrontHints{LanguageCode: "xx", Default: "in"},
+			expected:       "in",
+			expectedSource: StorefrontFromDefault,
+		},
+		{
+			name:           "no hints",
+			hints:          StorefrontHints{},
+			expected:       DefaultStorefront,
+			expectedSource: StorefrontFromDefault,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storefront, source := InferStorefront(tc.hints)
+			if storefront != tc.expected || source != tc.expectedSource {
+				t.Errorf("InferStorefront() = %q, %q; want %q, %q", storefront, source, tc.expected, tc.expectedSource)
+			}
+		})
+	}
+}
+
+func TestChatStorefronts(t *testing.T) {
+	storefronts := NewChatStorefronts()
+
+	if got := storefronts.Get(100); got != "" {
+		t.Errorf("Expected no storefront, got %q", got)
+	}
+
+	storefronts.Set(100, "DE")
+	if got := storefronts.Get(100); got != "de" {
+		t.Errorf("Expected de, got %q", got)
+	}
+
+	storefronts.Set(100, "")
+	if got := storefronts.Get(100); got != "" {
+		t.Errorf("Expected storefront to be cleared, got %q", got)
+	}
+}
@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// StrictHandler implements CommandHandler for the /strict command, which
+// turns strict metadata mode on or off for a chat
+type StrictHandler struct {
+	client       *TelegramBot
+	sender       MessageSender
+	logger       logging.Logger
+	errorHandler *ErrorHandler
+	songHandler  *SongHandler
+}
+
+// NewStrictHandler creates a new StrictHandler instance
+func NewStrictHandler(client *TelegramBot, logger logging.Logger, songHandler *SongHandler) *StrictHandler {
+	handler := &StrictHandler{
+		client:      client,
+		sender:      client,
+		logger:      logger,
+		songHandler: songHandler,
+	}
+
+	// Set error handler if client is available
+	if client != nil {
+		handler.errorHandler = client.GetErrorHandler()
+	}
+
+	return handler
+}
+
+// Command returns the command string this handler processes
+func (h *StrictHandler) Command() string {
+	return "strict"
+}
+
+// Handle processes the /strict command, showing or changing the chat setting
+func (h *StrictHandler) Handle(ctx context.Context, cmdCtx *CommandContext) error {
+	h.logger.Debug("Processing /strict command", logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+
+	// Create context with timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	settings := h.songHandler.GetStrictMetadata()
+
+	enabled, changed, err := parseStrictArgs(cmdCtx.Args, settings.Enabled(cmdCtx.ChatID))
+	if err != nil {
+		return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, "❌ "+err.Error())
+	}
+
+	if changed {
+		settings.Set(cmdCtx.ChatID, enabled)
+		h.logger.Info("Strict metadata turned "+onOff(enabled), logging.Int64("User", cmdCtx.UserID), logging.Int64("Chat", cmdCtx.ChatID))
+	}
+
+	return h.sender.SendMarkdown(timeoutCtx, cmdCtx.ChatID, createStrictMessage(enabled, changed))
+}
+
+// parseStrictArgs parses "on" or "off". No argument keeps the current setting
+func parseStrictArgs(args string, current bool) (enabled bool, changed bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		return current, false, nil
+	case "on":
+		return true, true, nil
+	case "off":
+		return false, true, nil
+	default:
+		return current, false, fmt.Errorf("Usage: /strict on|off")
+	}
+}
+
+// createStrictMessage describes the strict metadata setting of a chat
+func createStrictMessage(enabled, changed bool) string {
+	var message string
+	if changed {
+		message = fmt.Sprintf("✅ Strict metadata is now %s for this chat.", onOff(enabled))
+	} else {
+		message = fmt.Sprintf("Strict metadata is %s for this chat.", onOff(enabled))
+	}
+
+	if enabled {
+		return message + "\n\nSongs missing composer, ISRC, UPC, record label or lyrics are delivered with a ⚠️ line listing what is missing."
+	}
+	return message + "\n\nUse /strict on to be told when songs are missing composer, ISRC, UPC, record label or lyrics."
+}
+
+// onOff renders a setting as "on" or "off"
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
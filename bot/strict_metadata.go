@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+)
+
+// ChatStrictMetadata holds which chats want to hear about missing tags
+type ChatStrictMetadata struct {
+	mu    sync.RWMutex
+	chats map[int64]bool
+}
+
+// NewChatStrictMetadata creates a set of chat settings with strict mode off
+func NewChatStrictMetadata() *ChatStrictMetadata {
+	return &ChatStrictMetadata{
+		chats: make(map[int64]bool),
+	}
+}
+
+// Enabled reports whether strict metadata mode is on for a chat
+func (c *ChatStrictMetadata) Enabled(chatID int64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.chats[chatID]
+}
+
+// Set turns strict metadata mode on or off for a chat
+func (c *ChatStrictMetadata) Set(chatID int64, enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !enabled {
+		delete(c.chats, chatID)
+		return
+	}
+	c.chats[chatID] = true
+}
+
+// formatMissingFields renders the warning line for tags absent from a file,
+// "" when nothing is missing
+func formatMissingFields(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	return "⚠️ missing: " + strings.Join(fields, ", ")
+}
+
+// metadataWarning returns the missing-tags warning for a download when strict
+// metadata mode is on in chatID, "" otherwise
+func (h *SongHandler) metadataWarning(chatID int64, missing []string) string {
+	if h.strictMetadata == nil || !h.strictMetadata.Enabled(chatID) {
+		return ""
+	}
+	return formatMissingFields(missing)
+}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestFormatMissingFields(t *testing.T) {
+	testCases := []struct {
+		fields []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"lyrics"}, "⚠️ missing: lyrics"},
+		{[]string{"composer", "lyrics"}, "⚠️ missing: composer, lyrics"},
+		{[]string{"composer", "ISRC", "UPC", "record label", "lyrics"}, "⚠️ missing: composer, ISRC, UPC, record label, lyrics"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatMissingFields(tc.fields); got != tc.want {
+			t.Errorf("formatMissingFields(%q) = %q, want %q", tc.fields, got, tc.want)
+		}
+	}
+}
+
+func TestChatStrictMetadata(t *testing.T) {
+	settings := NewChatStrictMetadata()
+	if settings.Enabled(1) {
+		t.Error("Expected strict metadata to be off by default")
+	}
+
+	settings.Set(1, true)
+	if !settings.Enabled(1) || settings.Enabled(2) {
+		t.Error("Expected strict metadata on for chat 1 only")
+	}
+
+	settings.Set(1, false)
+	if settings.Enabled(1) {
+		t.Error("Expected strict metadata off again")
+	}
+}
+
+func TestParseStrictArgs(t *testing.T) {
+	testCases := []struct {
+		args        string
+		current     bool
+		wantEnabled bool
+		wantChanged bool
+		wantErr     bool
+	}{
+		{args: "", current: true, wantEnabled: true},
+		{args: "on", wantEnabled: true, wantChanged: true},
+		{args: " OFF ", current: true, wantChanged: true},
+		{args: "maybe", current: true, wantEnabled: true, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		enabled, changed, err := parseStrictArgs(tc.args, tc.current)
+		if enabled != tc.wantEnabled || changed != tc.wantChanged || (err != nil) != tc.wantErr {
+			t.Errorf("parseStrictArgs(%q, %v) = %v, %v, %v", tc.args, tc.current, enabled, changed, err)
+		}
+	}
+}
+
+func TestCreateUploadCaption(t *testing.T) {
+	result := &downloader.DownloadResult{SongMeta: &downloader.SongMetadata{AppleMusicID: "1559523359"}, PartIndex: 2, PartCount: 3}
+
+	if got, want := createUploadCaption(result, ""), "song `1559523359` · part 2 of 3"; got != want {
+		t.Errorf("Caption = %q, want %q", got, want)
+	}
+	if got, want := createUploadCaption(result, "⚠️ missing: lyrics"), "song `1559523359` · part 2 of 3\n⚠️ missing: lyrics"; got != want {
+		t.Errorf("Caption = %q, want %q", got, want)
+	}
+	result.SongMeta.BitDepth, result.SongMeta.SampleRateHz = 24, 96000
+	if got, want := createUploadCaption(result, ""), "song `1559523359` · part 2 of 3\n24-bit / 96 kHz ALAC"; got != want {
+		t.Errorf("Caption = %q, want %q", got, want)
+	}
+	result.ArtworkWidth, result.ArtworkHeight = 1400, 1400
+	if got, want := createUploadCaption(result, ""), "song `1559523359` · part 2 of 3\n24-bit / 96 kHz ALAC · 🖼️ 1400×1400"; got != want {
+		t.Errorf("Caption = %q, want %q", got, want)
+	}
+}
+
+func TestSongHandler_RunDownload_MissingFieldsWarning(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		handler := NewSongHandler(nil, logging.Discard())
+		handler.GetStrictMetadata().Set(1, strict)
+		var warnings []string
+		handler.upload = func(ctx context.Context, chatID int64, replyTo int, result *downloader.DownloadResult, onProgress func(downloader.Phase, downloader.Progress)) error {
+			warnings = append(warnings, handler.metadataWarning(chatID, result.MissingFields))
+			return nil
+		}
+
+		songDownloader := &scriptedDownloader{missing: []string{"composer", "lyrics"}}
+		reporter := &recordingReporter{}
+		if err := handler.runDownload(context.Background(), &CommandContext{ChatID: 1}, "https://music.apple.com/us/song/x/1", songDownloader, reporter, time.Now()); err != nil {
+			t.Fatalf("runDownload failed: %v", err)
+		}
+
+		// The warning is informational; the song is delivered either way
+		if len(reporter.completes) != 1 || len(warnings) != 1 {
+			t.Fatalf("Expected one upload and completion, got %d and %d", len(warnings), len(reporter.completes))
+		}
+
+		want := ""
+		if strict {
+			want = "⚠️ missing: composer, lyrics"
+		}
+		if reporter.note != want || warnings[0] != want {
+			t.Errorf("strict=%v: note %q and caption warning %q, want %q", strict, reporter.note, warnings[0], want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-alac-bot/downloader"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+const (
+	// smallUploadPartSize is the part size of files below
+	// ResumableUploadThreshold, the uploader's default of 512 KB
+	smallUploadPartSize = 512 << 10
+
+	// defaultUploadRetryDelay is the wait before the first retry of a failed
+	// part, doubled for each further retry
+	defaultUploadRetryDelay = 500 * time.Millisecond
+)
+
+// errPartNotSaved is returned when Telegram answered a part without saving it
+var errPartNotSaved = errors.New("part was not saved")
+
+// checkUploadSize rejects files above the upload limit before any part of
+// them is sent
+func (h *SongHandler) checkUploadSize(fileSize int64) error {
+	if h.uploadLimit <= 0 || fileSize <= h.uploadLimit {
+		return nil
+	}
+	message := fmt.Sprintf("This song is %s, more than the %s I can upload to Telegram. Try requesting the AAC version instead",
+		downloader.FormatBytes(fileSize), downloader.FormatBytes(h.uploadLimit))
+	return downloader.NewDownloadError(downloader.ErrorFileTooLarge, message).
+		WithContext("size", fileSize).
+		WithContext("limit", h.uploadLimit)
+}
+
+// partSize returns the part size a file of fileSize is uploaded in: small
+// parts for small files and bigPartSize for bigger ones
+func (h *SongHandler) partSize(fileSize int64) int {
+	if fileSize < ResumableUploadThreshold {
+		return min(h.bigPartSize(), smallUploadPartSize)
+	}
+	return h.bigPartSize()
+}
+
+// bigPartSize returns the configured part size of big files, by default the
+// largest Telegram accepts
+func (h *SongHandler) bigPartSize() int {
+	if h.uploadPartSize > 0 {
+		return h.uploadPartSize
+	}
+	return resumablePartSize
+}
+
+// retryPart sends one part with save, waiting out flood waits and sending it
+// again up to retries times, after delay and twice as long each next time,
+// when it failed or Telegram did not save it
+func retryPart(ctx context.Context, retries int, delay time.Duration, part int, save func() (bool, error)) error {
+	failures := 0
+	for {
+		saved, err := save()
+		if flood, _ := tgerr.FloodWait(ctx, err); flood {
+			continue
+		}
+		if err == nil && saved {
+			return nil
+		}
+		if err == nil {
+			err = errPartNotSaved
+		}
+		if failures >= retries || ctx.Err() != nil {
+			return fmt.Errorf("failed to upload part %d: %w", part, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to upload part %d: %w", part, ctx.Err())
+		case <-time.After(delay << failures):
+		}
+		failures++
+	}
+}
+
+// retryingUploadClient is the Telegram API of uploads, sending the parts
+// Telegram failed to save again
+type retryingUploadClient struct {
+	api     uploader.Client
+	retries int
+	delay   time.Duration
+}
+
+// uploadClient wraps api to retry failed parts as configured
+func (h *SongHandler) uploadClient(api uploader.Client) *retryingUploadClient {
+	return &retryingUploadClient{api: api, retries: h.uploadRetries, delay: h.uploadRetryDelay}
+}
+
+// UploadSaveFilePart implements uploader.Client
+func (c *retryingUploadClient) UploadSaveFilePart(ctx context.Context, request *tg.UploadSaveFilePartRequest) (bool, error) {
+	err := retryPart(ctx, c.retries, c.delay, request.FilePart, func() (bool, error) {
+		return c.api.UploadSaveFilePart(ctx, request)
+	})
+	return err == nil, err
+}
+
+// UploadSaveBigFilePart implements uploader.Client
+func (c *retryingUploadClient) UploadSaveBigFilePart(ctx context.Context, request *tg.UploadSaveBigFilePartRequest) (bool, error) {
+	err := retryPart(ctx, c.retries, c.delay, request.FilePart, func() (bool, error) {
+		return c.api.UploadSaveBigFilePart(ctx, request)
+	})
+	return err == nil, err
+}
@@ -0,0 +1,111 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func TestUploadFile_RejectsFileAboveLimit(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "Song - Artist.m4a")
+	if err := os.WriteFile(filePath, make([]byte, 3<<10), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	handler := NewSongHandler(nil, logging.Discard())
+	handler.uploadLimit = 2 << 10
+
+	var uploadStarted bool
+	onProgress := func(downloader.Phase, downloader.Progress) { uploadStarted = true }
+	result := &downloader.DownloadResult{
+		FilePath: filePath,
+		SongMeta: &downloader.SongMetadata{AppleMusicID: "1440818839"},
+	}
+
+	err := handler.uploadFile(context.Background(), 12345, 0, result, onProgress)
+	if !downloader.IsDownloadError(err, downloader.ErrorFileTooLarge) {
+		t.Fatalf("Expected ErrorFileTooLarge, got %v", err)
+	}
+	downloadErr, _ := downloader.AsDownloadError(err)
+	if !strings.Contains(downloadErr.Message, "3.0 KB") || !strings.Contains(downloadErr.Message, "AAC") {
+		t.Errorf("Expected the size and the AAC suggestion in the message, got %q", downloadErr.Message)
+	}
+	if uploadStarted {
+		t.Error("Expected no upload to start for a file above the limit")
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Errorf("Expected the rejected file to be kept, got %v", err)
+	}
+}
+
+func TestCheckUploadSize(t *testing.T) {
+	handler := &SongHandler{uploadLimit: 2000 << 20}
+
+	if err := handler.checkUploadSize(2000 << 20); err != nil {
+		t.Errorf("Expected a file at the limit to be uploaded, got %v", err)
+	}
+	if err := handler.checkUploadSize(2000<<20 + 1); err == nil {
+		t.Error("Expected a file above the limit to be rejected")
+	}
+
+	handler.uploadLimit = 0
+	if err := handler.checkUploadSize(4000 << 20); err != nil {
+		t.Errorf("Expected no limit when none is set, got %v", err)
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	handler := &SongHandler{}
+	if got := handler.partSize(1 << 20); got != smallUploadPartSize {
+		t.Errorf("Expected %d byte parts for a small file, got %d", smallUploadPartSize, got)
+	}
+	if got := handler.partSize(100 << 20); got != resumablePartSize {
+		t.Errorf("Expected %d byte parts for a big file, got %d", resumablePartSize, got)
+	}
+
+	handler.uploadPartSize = 64 << 10
+	if got := handler.partSize(1 << 20); got != 64<<10 {
+		t.Errorf("Expected the configured part size when it is smaller, got %d", got)
+	}
+}
+
+func TestRetryPart(t *testing.T) {
+	testCases := []struct {
+		name      string
+		results   []error // nil saves the part
+		retries   int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"saved at once", []error{nil}, 3, false, 1},
+		{"saved after failures", []error{errors.New("connection lost"), errPartNotSaved, nil}, 3, false, 3},
+		{"gives up after retries", []error{errors.New("a"), errors.New("b"), errors.New("c")}, 2, true, 3},
+		{"no retries", []error{errors.New("connection lost"), nil}, 0, true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := retryPart(context.Background(), tc.retries, 0, 7, func() (bool, error) {
+				result := tc.results[calls]
+				calls++
+				if errors.Is(result, errPartNotSaved) {
+					return false, nil
+				}
+				return result == nil, result
+			})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("retryPart() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("Expected %d attempts, got %d", tc.wantCalls, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+const (
+	// UploadCheckpointsDir is the directory inside the data dir holding upload checkpoints
+	UploadCheckpointsDir = "uploads"
+
+	// UploadCheckpointTTL is how long an interrupted upload can be resumed.
+	// Telegram drops uploaded parts that are never used after a while
+	UploadCheckpointTTL = 24 * time.Hour
+
+	// ResumableUploadThreshold is the size from which files are uploaded as
+	// big files, part by part, so they can be resumed
+	ResumableUploadThreshold = 10 << 20
+
+	// resumablePartSize is the default part size of big files
+	resumablePartSize      = uploader.MaximumPartSize
+	checkpointEveryNthPart = 20
+)
+
+// UploadCheckpoint records how far the big file upload of a file got
+type UploadCheckpoint struct {
+	FilePath      string    `json:"file_path"`
+	FileSize      int64     `json:"file_size"`
+	ModTime       time.Time `json:"mod_time"`
+	FileID        int64     `json:"file_id"`
+	PartSize      int       `json:"part_size"`
+	TotalParts    int       `json:"total_parts"`
+	PartsUploaded int       `json:"parts_uploaded"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UploadCheckpoints stores upload checkpoints in a directory, one file per
+// uploaded file, so uploads interrupted by a restart can be resumed
+type UploadCheckpoints struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewUploadCheckpoints creates a checkpoint store in dir
+func NewUploadCheckpoints(dir string) *UploadCheckpoints {
+	return &UploadCheckpoints{
+		dir: dir,
+		ttl: UploadCheckpointTTL,
+		now: time.Now,
+	}
+}
+
+// path returns the checkpoint file of the upload of filePath
+func (uc *UploadCheckpoints) path(filePath string) string {
+	sum := sha1.Sum([]byte(filePath))
+	return filepath.Join(uc.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load returns the checkpoint of the upload of filePath. It returns nil
+// without an error when there is none
+func (uc *UploadCheckpoints) Load(filePath string) (*UploadCheckpoint, error) {
+	data, err := os.ReadFile(uc.path(filePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload checkpoint: %w", err)
+	}
+
+	var checkpoint UploadCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse upload checkpoint: %w", err)
+	}
+
+	return &checkpoint, nil
+}
+
+// Save writes checkpoint, replacing the previous one of its file atomically
+func (uc *UploadCheckpoints) Save(checkpoint *UploadCheckpoint) error {
+	if err := os.MkdirAll(uc.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload checkpoint directory: %w", err)
+	}
+
+	checkpoint.UpdatedAt = uc.now()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload checkpoint: %w", err)
+	}
+
+	path := uc.path(checkpoint.FilePath)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save upload checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// Remove deletes the checkpoint of the upload of filePath, if any
+func (uc *UploadCheckpoints) Remove(filePath string) error {
+	if err := os.Remove(uc.path(filePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove upload checkpoint: %w", err)
+	}
+	return nil
+}
+
+// resumable reports whether checkpoint can resume the upload of the file
+// described by info in parts of partSize: same file contents as far as size
+// and modification time tell, same part size, and not older than the TTL
+func (uc *UploadCheckpoints) resumable(checkpoint *UploadCheckpoint, info os.FileInfo, partSize int) bool {
+	return checkpoint != nil &&
+		checkpoint.FileSize == info.Size() &&
+		checkpoint.ModTime.Equal(info.ModTime()) &&
+		checkpoint.PartSize == partSize &&
+		checkpoint.PartsUploaded <= checkpoint.TotalParts &&
+		uc.now().Sub(checkpoint.UpdatedAt) <= uc.ttl
+}
+
+// BigFilePartSaver is the part of the Telegram API used by resumable uploads
+type BigFilePartSaver interface {
+	UploadSaveBigFilePart(ctx context.Context, request *tg.UploadSaveBigFilePartRequest) (bool, error)
+}
+
+// uploadResumable uploads filePath as a big file part by part, resuming from
+// its checkpoint when a fresh one exists, and checkpoints its progress every
+// checkpointEveryNthPart parts. The checkpoint is kept until the caller
+// removes it once the file was sent, so a failed send does not upload again,
+// or drops it with discardBrokenUpload when Telegram rejects the parts
+func (h *SongHandler) uploadResumable(ctx context.Context, api BigFilePartSaver, filePath string, onProgress func(downloader.Phase, downloader.Progress)) (tg.InputFileClass, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	checkpoint, err := h.checkpoints.Load(filePath)
+	if err != nil {
+		h.logger.Warn("Ignoring upload checkpoint", logging.String("File", filePath), logging.Err(err))
+		checkpoint = nil
+	}
+
+	partSize := h.bigPartSize()
+	if h.checkpoints.resumable(checkpoint, info, partSize) {
+		h.logger.Info("Resuming upload", logging.String("File", filepath.Base(filePath)), logging.Int("Part", checkpoint.PartsUploaded), logging.Int("Parts", checkpoint.TotalParts))
+	} else {
+		fileID, err := randomFileID()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint = &UploadCheckpoint{
+			FilePath:   filePath,
+			FileSize:   info.Size(),
+			ModTime:    info.ModTime(),
+			FileID:     fileID,
+			PartSize:   partSize,
+			TotalParts: int((info.Size() + int64(partSize) - 1) / int64(partSize)),
+		}
+	}
+
+	offset := int64(checkpoint.PartsUploaded) * int64(partSize)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to part %d: %w", checkpoint.PartsUploaded, err)
+	}
+
+	progressReader := &UploadProgressReader{
+		reader:     h.uploadLimiter().Reader(ctx, file),
+		totalSize:  info.Size(),
+		bytesRead:  offset,
+		onProgress: onProgress,
+		startTime:  time.Now(),
+		lastUpdate: time.Now(),
+	}
+
+	buf := make([]byte, partSize)
+	for part := checkpoint.PartsUploaded; part < checkpoint.TotalParts; part++ {
+		n, err := io.ReadFull(progressReader, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("failed to read part %d: %w", part, err)
+		}
+
+		request := &tg.UploadSaveBigFilePartRequest{
+			FileID:         checkpoint.FileID,
+			FilePart:       part,
+			FileTotalParts: checkpoint.TotalParts,
+			Bytes:          buf[:n],
+		}
+		if err := retryPart(ctx, h.uploadRetries, h.uploadRetryDelay, part, func() (bool, error) {
+			return api.UploadSaveBigFilePart(ctx, request)
+		}); err != nil {
+			h.saveCheckpoint(checkpoint)
+			return nil, err
+		}
+		checkpoint.PartsUploaded = part + 1
+
+		if checkpoint.PartsUploaded%checkpointEveryNthPart == 0 {
+			h.saveCheckpoint(checkpoint)
+		}
+	}
+	h.saveCheckpoint(checkpoint)
+
+	return &tg.InputFileBig{
+		ID:    checkpoint.FileID,
+		Parts: checkpoint.TotalParts,
+		Name:  filepath.Base(filePath),
+	}, nil
+}
+
+// discardBrokenUpload removes the checkpoint of filePath when sending it failed
+// because Telegram lost or rejected parts of its upload, so the next attempt
+// uploads the parts again instead of sending the same file
+func (h *SongHandler) discardBrokenUpload(filePath string, err error) {
+	if h.checkpoints == nil || !downloader.IsFilePartsInvalid(err) {
+		return
+	}
+	h.logger.Warn("Telegram rejected the uploaded parts, uploading again next time", logging.String("File", filepath.Base(filePath)), logging.Err(err))
+	if err := h.checkpoints.Remove(filePath); err != nil {
+		h.logger.Warn("Failed to remove upload checkpoint", logging.Err(err))
+	}
+}
+
+// saveCheckpoint saves checkpoint, logging failures since the upload itself
+// can go on without it
+func (h *SongHandler) saveCheckpoint(checkpoint *UploadCheckpoint) {
+	if err := h.checkpoints.Save(checkpoint); err != nil {
+		h.logger.Warn("Failed to save upload checkpoint", logging.Err(err))
+	}
+}
+
+// randomFileID returns a new client-chosen upload file ID
+func randomFileID() (int64, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate upload file ID: %w", err)
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
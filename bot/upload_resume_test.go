@@ -0,0 +1,202 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// fakePartSaver records the big file parts it receives and fails at failAt
+type fakePartSaver struct {
+	fileIDs []int64
+	parts   []int
+	sizes   []int
+	failAt  int
+}
+
+func (f *fakePartSaver) UploadSaveBigFilePart(ctx context.Context, request *tg.UploadSaveBigFilePartRequest) (bool, error) {
+	if f.failAt > 0 && request.FilePart == f.failAt {
+		return false, errors.New("connection lost")
+	}
+	f.fileIDs = append(f.fileIDs, request.FileID)
+	f.parts = append(f.parts, request.FilePart)
+	f.sizes = append(f.sizes, len(request.Bytes))
+	return true, nil
+}
+
+// newResumeTest creates a file of two and a half parts and a handler keeping
+// checkpoints in a temporary directory
+func newResumeTest(t *testing.T) (*SongHandler, string) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "Song - Artist.m4a")
+	if err := os.WriteFile(filePath, bytes.Repeat([]byte{1}, 2*resumablePartSize+resumablePartSize/2), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	handler := &SongHandler{
+		logger:      logging.New(os.Stdout, logging.LevelDebug),
+		checkpoints: NewUploadCheckpoints(filepath.Join(dir, UploadCheckpointsDir)),
+	}
+	return handler, filePath
+}
+
+// saveTestCheckpoint records that parts of filePath were uploaded as file 42
+func saveTestCheckpoint(t *testing.T, uc *UploadCheckpoints, filePath string, parts int) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if err := uc.Save(&UploadCheckpoint{
+		FilePath:      filePath,
+		FileSize:      info.Size(),
+		ModTime:       info.ModTime(),
+		FileID:        42,
+		PartSize:      resumablePartSize,
+		TotalParts:    3,
+		PartsUploaded: parts,
+	}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+}
+
+func TestUploadResumable_ResumesFromCheckpoint(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+	saveTestCheckpoint(t, handler.checkpoints, filePath, 1)
+
+	api := &fakePartSaver{}
+	file, err := handler.uploadResumable(context.Background(), api, filePath, nil)
+	if err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+
+	if len(api.parts) != 2 || api.parts[0] != 1 || api.parts[1] != 2 {
+		t.Fatalf("Expected only parts 1 and 2 to be sent, got %v", api.parts)
+	}
+	if api.fileIDs[0] != 42 || api.fileIDs[1] != 42 {
+		t.Errorf("Expected the checkpoint's file ID, got %v", api.fileIDs)
+	}
+	if api.sizes[0] != resumablePartSize || api.sizes[1] != resumablePartSize/2 {
+		t.Errorf("Expected a full part then the remainder, got sizes %v", api.sizes)
+	}
+
+	big, ok := file.(*tg.InputFileBig)
+	if !ok || big.ID != 42 || big.Parts != 3 || big.Name != "Song - Artist.m4a" {
+		t.Errorf("Expected big file 42 with 3 parts, got %+v", file)
+	}
+}
+
+func TestUploadResumable_StaleCheckpointStartsOver(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+
+	handler.checkpoints.now = func() time.Time { return time.Now().Add(-UploadCheckpointTTL - time.Hour) }
+	saveTestCheckpoint(t, handler.checkpoints, filePath, 2)
+	handler.checkpoints.now = time.Now
+
+	api := &fakePartSaver{}
+	if _, err := handler.uploadResumable(context.Background(), api, filePath, nil); err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+
+	if len(api.parts) != 3 || api.parts[0] != 0 {
+		t.Fatalf("Expected a fresh upload of all 3 parts, got %v", api.parts)
+	}
+	if api.fileIDs[0] == 42 {
+		t.Error("Expected a new file ID for a fresh upload")
+	}
+}
+
+func TestUploadResumable_ChangedFileStartsOver(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+	saveTestCheckpoint(t, handler.checkpoints, filePath, 2)
+
+	// A re-download replaces the file
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filePath, later, later); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	api := &fakePartSaver{}
+	if _, err := handler.uploadResumable(context.Background(), api, filePath, nil); err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+	if len(api.parts) != 3 {
+		t.Errorf("Expected all 3 parts for a changed file, got %v", api.parts)
+	}
+}
+
+func TestUploadResumable_InterruptedUploadCheckpoints(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+
+	api := &fakePartSaver{failAt: 2}
+	if _, err := handler.uploadResumable(context.Background(), api, filePath, nil); err == nil {
+		t.Fatal("Expected the upload to fail at part 2")
+	}
+
+	checkpoint, err := handler.checkpoints.Load(filePath)
+	if err != nil || checkpoint == nil || checkpoint.PartsUploaded != 2 {
+		t.Fatalf("Expected a checkpoint after 2 parts, got %+v, %v", checkpoint, err)
+	}
+
+	// After a restart only the missing part is sent
+	restarted := &SongHandler{logger: handler.logger, checkpoints: NewUploadCheckpoints(handler.checkpoints.dir)}
+	api = &fakePartSaver{}
+	if _, err := restarted.uploadResumable(context.Background(), api, filePath, nil); err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+	if len(api.parts) != 1 || api.parts[0] != 2 || api.fileIDs[0] != checkpoint.FileID {
+		t.Errorf("Expected only part 2 of file %d, got parts %v of %v", checkpoint.FileID, api.parts, api.fileIDs)
+	}
+
+	if err := restarted.checkpoints.Remove(filePath); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if checkpoint, _ := restarted.checkpoints.Load(filePath); checkpoint != nil {
+		t.Errorf("Expected no checkpoint after removal, got %+v", checkpoint)
+	}
+}
+
+func TestUploadResumable_MissingPartsUploadAgain(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+
+	api := &fakePartSaver{}
+	if _, err := handler.uploadResumable(context.Background(), api, filePath, nil); err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+	firstID := api.fileIDs[0]
+
+	// Telegram lost a part by the time the file is sent
+	handler.discardBrokenUpload(filePath, tgerr.New(400, "FILE_PART_0_MISSING"))
+	if checkpoint, _ := handler.checkpoints.Load(filePath); checkpoint != nil {
+		t.Fatalf("Expected the checkpoint to be removed, got %+v", checkpoint)
+	}
+
+	api = &fakePartSaver{}
+	if _, err := handler.uploadResumable(context.Background(), api, filePath, nil); err != nil {
+		t.Fatalf("uploadResumable() error = %v", err)
+	}
+	if len(api.parts) != 3 || api.parts[0] != 0 {
+		t.Fatalf("Expected the retry to upload all 3 parts again, got %v", api.parts)
+	}
+	if api.fileIDs[0] == firstID {
+		t.Error("Expected a new file ID for the retry")
+	}
+}
+
+func TestDiscardBrokenUpload_KeepsCheckpointOnOtherErrors(t *testing.T) {
+	handler, filePath := newResumeTest(t)
+	saveTestCheckpoint(t, handler.checkpoints, filePath, 3)
+
+	handler.discardBrokenUpload(filePath, tgerr.New(400, "CHAT_SEND_AUDIOS_FORBIDDEN"))
+	if checkpoint, _ := handler.checkpoints.Load(filePath); checkpoint == nil {
+		t.Error("Expected the checkpoint to be kept")
+	}
+}
@@ -1,8 +1,17 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
 )
 
 // URLMeta represents the parsed metadata from an Apple Music URL
@@ -10,13 +19,48 @@ type URLMeta struct {
 	Storefront string `json:"storefront"`
 	URLType    string `json:"urlType"`
 	ID         string `json:"id"`
+
+	// StorefrontInferred is set when the URL had no storefront segment and
+	// Storefront was filled in from StorefrontSource
+	StorefrontInferred bool             `json:"storefrontInferred,omitempty"`
+	StorefrontSource   StorefrontSource `json:"storefrontSource,omitempty"`
+}
+
+// reAlbumOrSongOrPlaylist matches album, song, and playlist URLs, including
+// full playlist IDs with hyphens. The storefront segment is optional since some
+// share flows drop it
+var reAlbumOrSongOrPlaylist = regexp.MustCompile(`https://music\.apple\.com/(?:(?P<storefront>[a-z]{2})/)?(?P<type>album|song|playlist)/.*/(?P<id>[0-9a-zA-Z\-.]+)`)
+
+const (
+	// geoHost serves the region-neutral links of Apple's share flows, which
+	// redirect to the link for the visitor's storefront
+	geoHost = "geo.music.apple.com"
+
+	// geoRedirectTimeout bounds resolving one geo link
+	geoRedirectTimeout = 5 * time.Second
+)
+
+// ErrLibraryURL is returned for links to an item of a user's library, which
+// only its owner can see
+var ErrLibraryURL = errors.New("library playlists are private and cannot be fetched")
+
+// geoClient resolves geo links, stopping at the first redirect
+var geoClient = &http.Client{
+	Timeout: geoRedirectTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
 }
 
-// ExtractURLMeta extracts metadata from Apple Music URLs
+// ExtractURLMeta extracts metadata from Apple Music URLs. URLs without a
+// storefront segment fall back to DefaultStorefront
 func ExtractURLMeta(inputURL string) *URLMeta {
-	// Regex to match album, song, and playlist URLs, including full playlist IDs with hyphens
-	reAlbumOrSongOrPlaylist := regexp.MustCompile(`https://music\.apple\.com/(?P<storefront>[a-z]{2})/(?P<type>album|song|playlist)/.*/(?P<id>[0-9a-zA-Z\-.]+)`)
-	
+	return ExtractURLMetaWithHints(inputURL, StorefrontHints{})
+}
+
+// ExtractURLMetaWithHints extracts metadata from Apple Music URLs, inferring
+// the storefront from hints when the URL does not contain one
+func ExtractURLMetaWithHints(inputURL string, hints StorefrontHints) *URLMeta {
 	matches := reAlbumOrSongOrPlaylist.FindStringSubmatch(inputURL)
 	if len(matches) == 0 {
 		return nil
@@ -49,9 +93,298 @@ func ExtractURLMeta(inputURL string) *URLMeta {
 	}
 	
 	// Return the parsed metadata, pluralizing the type
-	return &URLMeta{
+	meta := &URLMeta{
 		Storefront: storefront,
 		URLType:    urlType + "s", // Pluralize to 'albums', 'songs', or 'playlists'
 		ID:         id,
 	}
+
+	if storefront == "" {
+		meta.Storefront, meta.StorefrontSource = InferStorefront(hints)
+		meta.StorefrontInferred = true
+	}
+
+	return meta
+}
+
+// IsLibraryURL reports whether inputURL links to an item of a user's library,
+// such as https://music.apple.com/library/playlist/p.xxx
+func IsLibraryURL(inputURL string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(inputURL))
+	if err != nil || (parsed.Host != "music.apple.com" && parsed.Host != geoHost) {
+		return false
+	}
+
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) > 1 && len(segments[0]) == 2 {
+		// Storefront-qualified, e.g. /us/library/...
+		segments = segments[1:]
+	}
+	return segments[0] == "library"
+}
+
+// IsGeoURL reports whether inputURL is a geo.music.apple.com link, which has
+// to be resolved to a music.apple.com one before it can be parsed
+func IsGeoURL(inputURL string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(inputURL))
+	return err == nil && parsed.Scheme == "https" && parsed.Host == geoHost
+}
+
+// ResolveGeoURL asks for inputURL with one HEAD request and returns the
+// storefront-qualified Apple Music link it redirects to
+func ResolveGeoURL(ctx context.Context, client *http.Client, inputURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, strings.TrimSpace(inputURL), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve geo link: %w", err)
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("geo link did not redirect: %s", resp.Status)
+	}
+	resolved := location.String()
+	if ExtractURLMeta(resolved) == nil {
+		return "", fmt.Errorf("geo link redirected to %s, which is not an Apple Music link", resolved)
+	}
+	return resolved, nil
+}
+
+// GeoFallbackURL turns a geo link into the music.apple.com link with the same
+// path, for when it cannot be resolved. The storefront is then inferred
+// unless the path has one
+func GeoFallbackURL(inputURL string) string {
+	return strings.Replace(strings.TrimSpace(inputURL), "https://"+geoHost+"/", "https://music.apple.com/", 1)
+}
+
+const (
+	// minTrackIDLength and maxTrackIDLength bound the digits of a catalog
+	// track ID (Adam ID)
+	minTrackIDLength = 8
+	maxTrackIDLength = 12
+)
+
+// aacFlag is the /song argument asking for the AAC stream when a song has no
+// ALAC
+const aacFlag = "aac"
+
+// SplitAACFlag removes the "aac" flag from the arguments of /song, reporting
+// whether it was given
+func SplitAACFlag(args string) (string, bool) {
+	fields := strings.Fields(args)
+	kept := fields[:0]
+	found := false
+	for _, field := range fields {
+		if strings.EqualFold(field, aacFlag) {
+			found = true
+			continue
+		}
+		kept = append(kept, field)
+	}
+	if !found {
+		return args, false
+	}
+	return strings.Join(kept, " "), true
+}
+
+// RequestsAAC reports whether a command message asks for the AAC stream
+func RequestsAAC(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		fields = fields[1:]
+	}
+	_, found := SplitAACFlag(strings.Join(fields, " "))
+	return found
+}
+
+// languageFlag prefixes the /song argument asking for the tags in another
+// language, e.g. "lang=en-US"
+const languageFlag = "lang="
+
+// SplitLanguageFlag removes "lang=<code>" from the arguments of /song,
+// returning the last code given, not yet checked
+func SplitLanguageFlag(args string) (string, string) {
+	fields := strings.Fields(args)
+	kept := fields[:0]
+	language := ""
+	found := false
+	for _, field := range fields {
+		if len(field) >= len(languageFlag) && strings.EqualFold(field[:len(languageFlag)], languageFlag) {
+			language = field[len(languageFlag):]
+			found = true
+			continue
+		}
+		kept = append(kept, field)
+	}
+	if !found {
+		return args, ""
+	}
+	return strings.Join(kept, " "), language
+}
+
+// RequestedLanguage returns the tag language a command message asks for, or
+// "" when it asks for none or an invalid one
+func RequestedLanguage(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		fields = fields[1:]
+	}
+	_, language := SplitLanguageFlag(strings.Join(fields, " "))
+	if language == "" {
+		return ""
+	}
+	language, err := NormalizeLanguage(language)
+	if err != nil {
+		return ""
+	}
+	return language
+}
+
+// ParseSongInput parses the arguments of /song: either an Apple Music URL or a
+// bare track ID, optionally followed by a storefront ("1559523359 us"). Track
+// IDs are turned into a song URL so that everything after parsing treats them
+// like a link. It returns nil when the input is neither
+func ParseSongInput(args string, hints StorefrontHints) (string, *URLMeta) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	if !IsTrackIDInput(fields[0]) {
+		if len(fields) > 1 {
+			return "", nil
+		}
+		return fields[0], ExtractURLMetaWithHints(fields[0], hints)
+	}
+
+	id := fields[0]
+	if len(id) < minTrackIDLength || len(id) > maxTrackIDLength || len(fields) > 2 {
+		return "", nil
+	}
+
+	meta := &URLMeta{URLType: "songs", ID: id}
+	if len(fields) == 2 {
+		storefront := strings.ToLower(fields[1])
+		if len(storefront) != 2 || !isLowerLetter(storefront[0]) || !isLowerLetter(storefront[1]) {
+			return "", nil
+		}
+		meta.Storefront = storefront
+	} else {
+		meta.Storefront, meta.StorefrontSource = InferStorefront(hints)
+		meta.StorefrontInferred = true
+	}
+
+	return SongURL(meta.Storefront, id), meta
+}
+
+// IsTrackIDInput reports whether the first /song argument is meant as a track
+// ID rather than a link
+func IsTrackIDInput(field string) bool {
+	if field == "" {
+		return false
+	}
+	for i := 0; i < len(field); i++ {
+		if field[i] < '0' || field[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// SongURL returns the song link for a track ID in a storefront
+func SongURL(storefront, id string) string {
+	return fmt.Sprintf("https://music.apple.com/%s/song/_/%s", storefront, id)
+}
+
+// WithStorefront returns inputURL with the storefront segment inserted when it
+// is missing, so the downloader receives a fully qualified link
+func WithStorefront(inputURL, storefront string) string {
+	const prefix = "https://music.apple.com/"
+	if !strings.HasPrefix(inputURL, prefix) || storefront == "" {
+		return inputURL
+	}
+
+	rest := inputURL[len(prefix):]
+	for _, urlType := range []string{"album/", "song/", "playlist/"} {
+		if strings.HasPrefix(rest, urlType) {
+			return prefix + storefront + "/" + rest
+		}
+	}
+
+	return inputURL
+}
+
+// ReplaceStorefront returns inputURL with its storefront segment set to
+// storefront, inserting one when it is missing
+func ReplaceStorefront(inputURL, storefront string) string {
+	const prefix = "https://music.apple.com/"
+	if !strings.HasPrefix(inputURL, prefix) || storefront == "" {
+		return inputURL
+	}
+
+	rest := inputURL[len(prefix):]
+	if len(rest) > 3 && rest[2] == '/' && isLowerLetter(rest[0]) && isLowerLetter(rest[1]) {
+		return prefix + storefront + rest[2:]
+	}
+
+	return WithStorefront(inputURL, storefront)
+}
+
+// isLowerLetter reports whether c is an ASCII lowercase letter
+func isLowerLetter(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+// ExtractURLCandidates returns the URLs contained in a message, preferring the
+// ones Telegram marked with URL/TextURL entities and falling back to
+// whitespace-separated tokens that look like links
+func ExtractURLCandidates(text string, entities []tg.MessageEntityClass) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+
+	add := func(candidate string) {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" || seen[candidate] {
+			return
+		}
+		seen[candidate] = true
+		candidates = append(candidates, candidate)
+	}
+
+	for _, entity := range entities {
+		switch e := entity.(type) {
+		case *tg.MessageEntityTextURL:
+			add(e.URL)
+		case *tg.MessageEntityURL:
+			add(substringUTF16(text, e.Offset, e.Length))
+		}
+	}
+
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "http://") || strings.HasPrefix(field, "https://") {
+			add(field)
+		}
+	}
+
+	return candidates
+}
+
+// substringUTF16 returns the part of text addressed by a Telegram entity
+// offset and length, both expressed in UTF-16 code units
+func substringUTF16(text string, offset, length int) string {
+	units := utf16.Encode([]rune(text))
+	if offset < 0 || length <= 0 || offset >= len(units) {
+		return ""
+	}
+
+	end := offset + length
+	if end > len(units) {
+		end = len(units)
+	}
+
+	return string(utf16.Decode(units[offset:end]))
 }
\ No newline at end of file
@@ -1,7 +1,12 @@
 package bot
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/gotd/td/tg"
 )
 
 func TestExtractURLMeta(t *testing.T) {
@@ -39,13 +44,43 @@ func TestExtractURLMeta(t *testing.T) {
 		},
 		{
 			name:     "Playlist URL",
-			inputURL: "https://music.apple.com/library/playlist/p.vMO5kRQiX1xGMr",
+			inputURL: "https://music.apple.com/us/playlist/todays-hits/pl.f4d106fed2bd41149aaacabb233eb5eb",
 			expected: &URLMeta{
-				Storefront: "library", // Note: this might not be a valid storefront but matches the regex
+				Storefront: "us",
 				URLType:    "playlists",
-				ID:         "p.vMO5kRQiX1xGMr",
+				ID:         "pl.f4d106fed2bd41149aaacabb233eb5eb",
 			},
 		},
+		{
+			name:     "URL with a locale",
+			inputURL: "https://music.apple.com/jp/album/some-album/1559523357?i=1559523359&l=en-US",
+			expected: &URLMeta{
+				Storefront: "jp",
+				URLType:    "songs",
+				ID:         "1559523359",
+			},
+		},
+		{
+			name:     "URL without storefront",
+			inputURL: "https://music.apple.com/album/3-originals/1559523357",
+			expected: &URLMeta{
+				Storefront: DefaultStorefront,
+				URLType:    "albums",
+				ID:         "1559523357",
+			},
+		},
+		{
+			// Library items are refused with ErrLibraryURL
+			name:     "Library playlist URL",
+			inputURL: "https://music.apple.com/library/playlist/p.vMO5kRQiX1xGMr",
+			expected: nil,
+		},
+		{
+			// Geo links are resolved before they are parsed
+			name:     "Geo URL",
+			inputURL: "https://geo.music.apple.com/us/album/_/1559523357?i=1559523359",
+			expected: nil,
+		},
 		{
 			name:     "Invalid URL",
 			inputURL: "https://spotify.com/track/123",
@@ -92,4 +127,418 @@ func TestExtractURLMeta(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestIsLibraryURL(t *testing.T) {
+	testCases := []struct {
+		inputURL string
+		expected bool
+	}{
+		{"https://music.apple.com/library/playlist/p.vMO5kRQiX1xGMr", true},
+		{"https://music.apple.com/us/library/playlist/p.vMO5kRQiX1xGMr", true},
+		{"https://music.apple.com/library/albums/l.abc123", true},
+		{"https://geo.music.apple.com/library/playlist/p.vMO5kRQiX1xGMr", true},
+		{"  https://music.apple.com/library/playlist/p.vMO5kRQiX1xGMr  ", true},
+		{"https://music.apple.com/us/playlist/todays-hits/pl.f4d106fed2bd41149aaacabb233eb5eb", false},
+		{"https://music.apple.com/us/album/library/1559523357", false},
+		{"https://example.com/library/playlist/p.vMO5kRQiX1xGMr", false},
+		{"not a url", false},
+	}
+
+	for _, tc := range testCases {
+		if got := IsLibraryURL(tc.inputURL); got != tc.expected {
+			t.Errorf("IsLibraryURL(%q) = %v, want %v", tc.inputURL, got, tc.expected)
+		}
+	}
+}
+
+func TestIsGeoURL(t *testing.T) {
+	testCases := []struct {
+		inputURL string
+		expected bool
+		fallback string
+	}{
+		{"https://geo.music.apple.com/us/album/_/1559523357?i=1559523359", true, "https://music.apple.com/us/album/_/1559523357?i=1559523359"},
+		{"https://geo.music.apple.com/album/_/1559523357", true, "https://music.apple.com/album/_/1559523357"},
+		{"http://geo.music.apple.com/album/_/1559523357", false, "http://geo.music.apple.com/album/_/1559523357"},
+		{"https://music.apple.com/us/album/_/1559523357", false, "https://music.apple.com/us/album/_/1559523357"},
+		{"https://geo.itunes.apple.com/us/album/_/1559523357", false, "https://geo.itunes.apple.com/us/album/_/1559523357"},
+	}
+
+	for _, tc := range testCases {
+		if got := IsGeoURL(tc.inputURL); got != tc.expected {
+			t.Errorf("IsGeoURL(%q) = %v, want %v", tc.inputURL, got, tc.expected)
+		}
+		if got := GeoFallbackURL(tc.inputURL); got != tc.fallback {
+			t.Errorf("GeoFallbackURL(%q) = %q, want %q", tc.inputURL, got, tc.fallback)
+		}
+	}
+
+	// The fallback of a geo link without storefront parses with an inferred one
+	meta := ExtractURLMeta(GeoFallbackURL("https://geo.music.apple.com/album/_/1559523357?i=1559523359"))
+	if meta == nil || !meta.StorefrontInferred || meta.ID != "1559523359" {
+		t.Errorf("Expected the fallback to parse with an inferred storefront, got %+v", meta)
+	}
+}
+
+func TestResolveGeoURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		location string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "redirect to storefront",
+			status:   http.StatusMovedPermanently,
+			location: "https://music.apple.com/gb/album/_/1559523357?i=1559523359",
+			expected: "https://music.apple.com/gb/album/_/1559523357?i=1559523359",
+		},
+		{
+			name:     "temporary redirect",
+			status:   http.StatusFound,
+			location: "https://music.apple.com/in/song/_/1559523359",
+			expected: "https://music.apple.com/in/song/_/1559523359",
+		},
+		{
+			name:    "no redirect",
+			status:  http.StatusOK,
+			wantErr: true,
+		},
+		{
+			name:     "redirect elsewhere",
+			status:   http.StatusFound,
+			location: "https://www.apple.com/apple-music/",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			methods := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods <- r.Method
+				if tc.location != "" {
+					w.Header().Set("Location", tc.location)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			resolved, err := ResolveGeoURL(context.Background(), geoClient, server.URL+"/us/album/_/1559523357")
+			if method := <-methods; method != http.MethodHead {
+				t.Errorf("Expected a HEAD request, got %s", method)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveGeoURL() error = %v", err)
+			}
+			if resolved != tc.expected {
+				t.Errorf("ResolveGeoURL() = %q, want %q", resolved, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExtractURLCandidates(t *testing.T) {
+	songURL := "https://music.apple.com/in/song/never-gonna-give-you-up/1559523359"
+
+	testCases := []struct {
+		name     string
+		text     string
+		entities []tg.MessageEntityClass
+		expected []string
+	}{
+		{
+			name:     "plain text URL",
+			text:     "/song " + songURL,
+			expected: []string{songURL},
+		},
+		{
+			name: "URL entity after emoji",
+			// The emoji takes two UTF-16 code units, so the entity offset is 9
+			text: "/song 🎵 " + songURL,
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityURL{Offset: 9, Length: len(songURL)},
+			},
+			expected: []string{songURL},
+		},
+		{
+			name: "hidden text URL",
+			text: "/song click here",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityTextURL{Offset: 6, Length: 10, URL: songURL},
+			},
+			expected: []string{songURL},
+		},
+		{
+			name:     "no links",
+			text:     "/song please",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := ExtractURLCandidates(tc.text, tc.entities)
+
+			if len(result) != len(tc.expected) {
+				t.Fatalf("Expected %v, got %v", tc.expected, result)
+			}
+
+			for i := range result {
+				if result[i] != tc.expected[i] {
+					t.Errorf("Candidate %d: expected %q, got %q", i, tc.expected[i], result[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractURLMetaWithHints_MissingStorefront(t *testing.T) {
+	inputURL := "https://music.apple.com/album/3-originals/1559523357?i=1559523359"
+
+	testCases := []struct {
+		name           string
+		hints          StorefrontHints
+		expected       string
+		expectedSource StorefrontSource
+	}{
+		{
+			name:           "from chat setting",
+			hints:          StorefrontHints{ChatStorefront: "gb", LanguageCode: "ja", Default: "in"},
+			expected:       "gb",
+			expectedSource: StorefrontFromChat,
+		},
+		{
+			name:           "from language",
+			hints:          StorefrontHints{LanguageCode: "de", Default: "in"},
+			expected:       "de",
+			expectedSource: StorefrontFromLanguage,
+		},
+		{
+			name:           "from global default",
+			hints:          StorefrontHints{Default: "in"},
+			expected:       "in",
+			expectedSource: StorefrontFromDefault,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := ExtractURLMetaWithHints(inputURL, tc.hints)
+			if result == nil {
+				t.Fatal("Expected URL without storefront to parse")
+			}
+
+			if !result.StorefrontInferred {
+				t.Error("Expected storefront to be marked as inferred")
+			}
+			if result.Storefront != tc.expected || result.StorefrontSource != tc.expectedSource {
+				t.Errorf("Storefront = %q from %q, want %q from %q",
+					result.Storefront, result.StorefrontSource, tc.expected, tc.expectedSource)
+			}
+			if result.URLType != "songs" || result.ID != "1559523359" {
+				t.Errorf("Expected song 1559523359, got %s %s", result.URLType, result.ID)
+			}
+		})
+	}
+}
+
+func TestExtractURLMeta_ExplicitStorefrontNotInferred(t *testing.T) {
+	result := ExtractURLMetaWithHints("https://music.apple.com/in/song/test/123", StorefrontHints{LanguageCode: "de"})
+	if result == nil {
+		t.Fatal("Expected URL to parse")
+	}
+	if result.StorefrontInferred || result.Storefront != "in" {
+		t.Errorf("Expected explicit storefront in, got %q (inferred: %v)", result.Storefront, result.StorefrontInferred)
+	}
+}
+
+func TestWithStorefront(t *testing.T) {
+	testCases := []struct {
+		inputURL string
+		expected string
+	}{
+		{
+			inputURL: "https://music.apple.com/album/3-originals/1559523357",
+			expected: "https://music.apple.com/de/album/3-originals/1559523357",
+		},
+		{
+			inputURL: "https://music.apple.com/song/test/123",
+			expected: "https://music.apple.com/de/song/test/123",
+		},
+		{
+			inputURL: "https://music.apple.com/in/song/test/123",
+			expected: "https://music.apple.com/in/song/test/123",
+		},
+		{
+			inputURL: "https://spotify.com/track/123",
+			expected: "https://spotify.com/track/123",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := WithStorefront(tc.inputURL, "de"); got != tc.expected {
+			t.Errorf("WithStorefront(%q) = %q, want %q", tc.inputURL, got, tc.expected)
+		}
+	}
+}
+
+func TestReplaceStorefront(t *testing.T) {
+	testCases := []struct {
+		inputURL string
+		expected string
+	}{
+		{
+			inputURL: "https://music.apple.com/in/song/test/123",
+			expected: "https://music.apple.com/de/song/test/123",
+		},
+		{
+			inputURL: "https://music.apple.com/us/album/3-originals/1559523357?i=1559523359",
+			expected: "https://music.apple.com/de/album/3-originals/1559523357?i=1559523359",
+		},
+		{
+			inputURL: "https://music.apple.com/song/test/123",
+			expected: "https://music.apple.com/de/song/test/123",
+		},
+		{
+			inputURL: "https://spotify.com/track/123",
+			expected: "https://spotify.com/track/123",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := ReplaceStorefront(tc.inputURL, "de"); got != tc.expected {
+			t.Errorf("ReplaceStorefront(%q) = %q, want %q", tc.inputURL, got, tc.expected)
+		}
+	}
+}
+
+func TestParseSongInput(t *testing.T) {
+	hints := StorefrontHints{Default: "in"}
+
+	testCases := []struct {
+		name         string
+		args         string
+		expectedURL  string
+		expectedMeta *URLMeta
+	}{
+		{
+			name:         "track ID with storefront",
+			args:         "1559523359 us",
+			expectedURL:  "https://music.apple.com/us/song/_/1559523359",
+			expectedMeta: &URLMeta{Storefront: "us", URLType: "songs", ID: "1559523359"},
+		},
+		{
+			name:         "track ID with upper-case storefront",
+			args:         " 1559523359  GB ",
+			expectedURL:  "https://music.apple.com/gb/song/_/1559523359",
+			expectedMeta: &URLMeta{Storefront: "gb", URLType: "songs", ID: "1559523359"},
+		},
+		{
+			name:        "track ID alone uses the default storefront",
+			args:        "1559523359",
+			expectedURL: "https://music.apple.com/in/song/_/1559523359",
+			expectedMeta: &URLMeta{Storefront: "in", URLType: "songs", ID: "1559523359",
+				StorefrontInferred: true, StorefrontSource: StorefrontFromDefault},
+		},
+		{
+			name:         "URL",
+			args:         "https://music.apple.com/us/song/test/1559523359",
+			expectedURL:  "https://music.apple.com/us/song/test/1559523359",
+			expectedMeta: &URLMeta{Storefront: "us", URLType: "songs", ID: "1559523359"},
+		},
+		{name: "ID too short", args: "12345"},
+		{name: "ID too long", args: "1234567890123"},
+		{name: "not numeric", args: "15595x3359 us"},
+		{name: "invalid storefront", args: "1559523359 usa"},
+		{name: "extra arguments", args: "1559523359 us gb"},
+		{name: "empty", args: "  "},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotURL, meta := ParseSongInput(tc.args, hints)
+			if tc.expectedMeta == nil {
+				if meta != nil {
+					t.Errorf("Expected %q to be rejected, got %+v", tc.args, meta)
+				}
+				return
+			}
+			if meta == nil || *meta != *tc.expectedMeta {
+				t.Fatalf("Expected %+v, got %+v", tc.expectedMeta, meta)
+			}
+			if gotURL != tc.expectedURL {
+				t.Errorf("Expected URL %q, got %q", tc.expectedURL, gotURL)
+			}
+			// The generated link must parse the same way downstream
+			if parsed := ExtractURLMeta(gotURL); parsed == nil || parsed.ID != meta.ID || parsed.Storefront != meta.Storefront {
+				t.Errorf("Expected %q to round-trip, got %+v", gotURL, parsed)
+			}
+		})
+	}
+}
+
+func TestSplitAACFlag(t *testing.T) {
+	testCases := []struct {
+		args     string
+		wantArgs string
+		wantAAC  bool
+	}{
+		{"https://music.apple.com/us/song/a/1559523359 aac", "https://music.apple.com/us/song/a/1559523359", true},
+		{"AAC 1559523359 us", "1559523359 us", true},
+		{"1559523359 us", "1559523359 us", false},
+		{"https://music.apple.com/us/song/aac/1559523359", "https://music.apple.com/us/song/aac/1559523359", false},
+		{"aac", "", true},
+	}
+
+	for _, tc := range testCases {
+		gotArgs, gotAAC := SplitAACFlag(tc.args)
+		if gotArgs != tc.wantArgs || gotAAC != tc.wantAAC {
+			t.Errorf("SplitAACFlag(%q) = %q, %v, want %q, %v", tc.args, gotArgs, gotAAC, tc.wantArgs, tc.wantAAC)
+		}
+	}
+
+	if !RequestsAAC("/song 1559523359 aac") || RequestsAAC("/song 1559523359") {
+		t.Error("Expected RequestsAAC to follow the flag in the message text")
+	}
+	if got := commandArgsTrackID("/song 1559523359 us aac"); got != "1559523359" {
+		t.Errorf("Expected the track ID with the aac flag, got %q", got)
+	}
+}
+
+func TestSplitLanguageFlag(t *testing.T) {
+	testCases := []struct {
+		args         string
+		wantArgs     string
+		wantLanguage string
+	}{
+		{"https://music.apple.com/jp/song/a/1474379093 lang=en-US", "https://music.apple.com/jp/song/a/1474379093", "en-US"},
+		{"LANG=ja 1474379093 jp aac", "1474379093 jp aac", "ja"},
+		{"1474379093 jp", "1474379093 jp", ""},
+	}
+
+	for _, tc := range testCases {
+		gotArgs, gotLanguage := SplitLanguageFlag(tc.args)
+		if gotArgs != tc.wantArgs || gotLanguage != tc.wantLanguage {
+			t.Errorf("SplitLanguageFlag(%q) = %q, %q, want %q, %q", tc.args, gotArgs, gotLanguage, tc.wantArgs, tc.wantLanguage)
+		}
+	}
+
+	if got := RequestedLanguage("/song 1474379093 lang=en_us"); got != "en-US" {
+		t.Errorf("Expected the normalized language, got %q", got)
+	}
+	if got := RequestedLanguage("/song 1474379093 lang=not!valid"); got != "" {
+		t.Errorf("Expected an invalid language to be ignored, got %q", got)
+	}
+	if got := commandArgsTrackID("/song 1474379093 jp lang=en-US aac"); got != "1474379093" {
+		t.Errorf("Expected the track ID with flags, got %q", got)
+	}
+}
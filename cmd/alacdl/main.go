@@ -0,0 +1,53 @@
+// Command alacdl downloads one Apple Music song without the bot, printing the
+// result as a JSON document (see downloader.ResultDocument). The device and
+// decryption services are found through M3U8_URL and DEC_URL like the bot's
+//
+//	alacdl -url https://music.apple.com/us/song/1 -out downloads -quality 24/96
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+)
+
+func main() {
+	url := flag.String("url", "", "Apple Music song or album track link (required)")
+	outDir := flag.String("out", downloader.DownloadsDir, "directory the song is written to")
+	quality := flag.String("quality", "", `highest ALAC quality, e.g. "24/96" or "16/44.1" (default: highest available)`)
+	flag.Parse()
+
+	// Log to stderr so stdout holds only the document
+	downloader.SetLogger(logging.New(os.Stderr, logging.LevelWarn))
+
+	if *url == "" {
+		fmt.Fprintln(os.Stderr, "alacdl: -url is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	limit, err := downloader.ParseQualityCap(*quality)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alacdl: invalid -quality: %v\n", err)
+		os.Exit(2)
+	}
+
+	manager := downloader.NewManager(0)
+	manager.SetDownloadDir(*outDir)
+	manager.SetQualityCap(limit)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := manager.NewDownloader().Download(ctx, *url, downloader.ProgressCallbacks{})
+	fmt.Println(string(downloader.MarshalResult(result, err)))
+	if err != nil || result == nil {
+		stop()
+		os.Exit(1)
+	}
+}
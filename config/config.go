@@ -3,7 +3,10 @@ package config
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,9 +16,109 @@ type BotConfig struct {
 	Token    string // Telegram bot token
 	APIID    int    // Telegram API ID
 	APIHash  string // Telegram API Hash
-	LogLevel string // Logging level (INFO, WARN, ERROR, FATAL)
+	LogLevel string // Lowest level of log entries written (DEBUG, INFO, WARN, ERROR, FATAL)
+
+	DeliveryReaction string // Emoji reacted on the command message once a song is delivered
+
+	DumpChatID int64 // Channel every song is uploaded to and forwarded from (0 = none)
+
+	AdminIDs     []int64 // Telegram user IDs allowed to run admin commands
+	DataDir      string  // Directory for persistent bot state
+	QueueSize    int     // Default song queue capacity
+	QueueWorkers int     // Default number of song queue workers
+
+	UserQueueLimit int // Pending requests one user may have at a time (0 = unlimited)
+	AutoRetries    int // Times a request failing transiently is queued again (0 = never)
+
+	SongRateLimit     int // /song requests one user may make per minute (0 = unlimited)
+	ChatSongRateLimit int // /song requests one chat may make per minute (0 = unlimited)
+
+	QueueFile        string        // File waiting requests are saved to across restarts (empty = not saved)
+	QueuedRequestTTL time.Duration // Saved requests older than this are dropped on startup (0 = never)
+
+	FailedRequestTTL time.Duration // How long failed requests can be retried with /retry
+	RequestTimeout   time.Duration // Longest a request may be processed before it fails (0 = no limit)
+
+	DownloadDir     string        // Directory downloaded songs are written to
+	StaleTempAge    time.Duration // Unfinished downloads older than this are removed on startup
+	MinFreeDiskMB   int           // Oldest finished downloads are removed to keep this much disk free (0 = never)
+	DownloadRetries int           // Times a broken media download is resumed before failing
+	DownloadChunks  int           // Ranges of a song downloaded at once (0 or 1 = one stream)
+	DecryptTimeout  time.Duration // Wait for the decryption service to answer a sample (0 = forever)
+	DecryptLimit    int           // Songs decrypted at once across all workers (0 = unlimited)
+	StallTimeout    time.Duration // Download or decryption time without progress before it is retried (0 = never)
+	DeviceTimeout   time.Duration // Wait for the device service to connect, take or answer a request (0 = forever)
+
+	PreflightEnabled bool // Check the download services and the song before queueing it
+	AACFallback      bool // Download AAC when a song has no ALAC, without the "aac" flag
+	MetadataLanguage string // Catalog language of the tags of chats without their own (empty = storefront's)
+
+	MaxMemoryMB int // Memory budget shared by concurrent downloads (0 = unlimited)
+	SplitMaxMB  int // Songs larger than this are uploaded in parts (0 = never split)
+
+	ArtworkMaxDimension int // Longer side of the embedded artwork in pixels (0 = original)
+	ArtworkMaxKB        int // Embedded artwork larger than this is re-encoded to fit (0 = no limit)
+
+	UploadMaxMB   int // Files larger than this are not uploaded (0 = Telegram's limit)
+	UploadPartKB  int // Part size of uploads over 10 MB (0 = the largest Telegram accepts)
+	UploadRetries int // Times a part Telegram failed to save is sent again
+
+	DownloadRateLimit float64 // MB/s shared by all media downloads (0 = unlimited)
+	UploadRateLimit   float64 // MB/s shared by all uploads to Telegram (0 = unlimited)
+
+	DefaultStorefront   string   // Storefront assumed for links without one when no better hint exists
+	FallbackStorefronts []string // Storefronts tried when a song is unavailable in the requested one
+
+	ExpectedMetadata []string // Tags reported missing in strict metadata mode (empty = all)
+
+	LogDedupEnabled   bool          // Collapse repeated identical log entries
+	LogDedupWindow    time.Duration // How long repeated entries are collapsed into one summary
+	LogDedupThreshold int           // Identical entries written per window before suppressing
+
+	HTTPAddr      string // Listen address of the HTTP server (empty = disabled)
+	PublicBaseURL string // Public URL of the HTTP server, used for links sent to users
+	MetricsAddr   string // Listen address of the download metrics server (empty = disabled)
 }
 
+// Defaults for optional settings
+const (
+	DefaultDeliveryReaction  = "✅"
+	DefaultDataDir           = "data"
+	DefaultDownloadDir       = "downloads"
+	DefaultStaleTempAge      = 6 * time.Hour
+	DefaultDownloadRetries   = 3
+	DefaultDownloadChunks    = 4
+	DefaultDecryptTimeout    = 30 * time.Second
+	DefaultDecryptLimit      = 1
+	DefaultStallTimeout      = 60 * time.Second
+	DefaultDeviceTimeout     = 10 * time.Second
+	DefaultUploadRetries     = 3
+	DefaultQueueSize         = 7
+	DefaultQueueWorkers      = 1
+	DefaultUserQueueLimit    = 2
+	DefaultAutoRetries       = 2
+	DefaultSongRateLimit     = 5
+	DefaultChatSongRateLimit = 20
+	DefaultFailedRequestTTL  = 24 * time.Hour
+	DefaultQueuedRequestTTL  = time.Hour
+	DefaultRequestTimeout    = 15 * time.Minute
+	DefaultStorefront        = "us"
+	DefaultLogDedupWindow    = 60 * time.Second
+	DefaultLogDedupThreshold = 1
+	
+	// MaxSplitMB is the largest file a bot can upload to Telegram
+	MaxSplitMB = 2000
+
+	// MaxUploadPartKB is the largest upload part Telegram accepts
+	MaxUploadPartKB = 512
+)
+
+// Defaults of the artwork embedded in songs
+const (
+	DefaultArtworkMaxDimension = 1400
+	DefaultArtworkMaxKB        = 1024
+)
+
 // LoadConfig loads and validates the bot configuration from environment variables
 // Returns a BotConfig struct or an error if validation fails
 func LoadConfig() (*BotConfig, error) {
@@ -50,16 +153,273 @@ func LoadConfig() (*BotConfig, error) {
 		logLevel = "INFO" // Default log level
 	}
 	
+	// Get delivery reaction emoji with default
+	deliveryReaction := os.Getenv("DELIVERY_REACTION")
+	if deliveryReaction == "" {
+		deliveryReaction = DefaultDeliveryReaction
+	}
+	
+	// Get admin IDs
+	adminIDs, err := validator.GetAdminIDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get admin IDs: %w", err)
+	}
+	
+	// Get data directory with default
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+	
+	// Get download directory with default
+	downloadDir := os.Getenv("DOWNLOAD_DIR")
+	if downloadDir == "" {
+		downloadDir = DefaultDownloadDir
+	}
+	staleTempAge, err := validator.GetDurationOrDefault("STALE_TEMP_AGE", DefaultStaleTempAge)
+	if err != nil {
+		return nil, err
+	}
+	minFreeDiskMB, err := validator.GetIntOrDefault("MIN_FREE_DISK_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+	downloadRetries, err := validator.GetIntOrDefault("DOWNLOAD_RETRIES", DefaultDownloadRetries)
+	if err != nil {
+		return nil, err
+	}
+	downloadChunks, err := validator.GetIntOrDefault("DOWNLOAD_CHUNKS", DefaultDownloadChunks)
+	if err != nil {
+		return nil, err
+	}
+	decryptTimeout, err := validator.GetDurationOrDefault("DECRYPT_TIMEOUT", DefaultDecryptTimeout)
+	if err != nil {
+		return nil, err
+	}
+	decryptLimit, err := validator.GetIntOrDefault("DECRYPT_CONCURRENCY", DefaultDecryptLimit)
+	if err != nil {
+		return nil, err
+	}
+	stallTimeout, err := validator.GetDurationOrDefault("STALL_TIMEOUT", DefaultStallTimeout)
+	if err != nil {
+		return nil, err
+	}
+	deviceTimeout, err := validator.GetDurationOrDefault("DEVICE_TIMEOUT", DefaultDeviceTimeout)
+	if err != nil {
+		return nil, err
+	}
+	dumpChatID, err := validator.GetInt64OrDefault("DUMP_CHAT_ID", 0)
+	if err != nil {
+		return nil, err
+	}
+	preflightEnabled, err := validator.GetBoolOrDefault("PREFLIGHT", true)
+	if err != nil {
+		return nil, err
+	}
+	aacFallback, err := validator.GetBoolOrDefault("AAC_FALLBACK", false)
+	if err != nil {
+		return nil, err
+	}
+	metadataLanguage := strings.TrimSpace(os.Getenv("METADATA_LANG"))
+	
+	// Get queue defaults
+	queueSize, err := validator.GetIntOrDefault("QUEUE_SIZE", DefaultQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	queueWorkers, err := validator.GetIntOrDefault("QUEUE_WORKERS", DefaultQueueWorkers)
+	if err != nil {
+		return nil, err
+	}
+	userQueueLimit, err := validator.GetIntOrDefault("USER_QUEUE_LIMIT", DefaultUserQueueLimit)
+	if err != nil {
+		return nil, err
+	}
+	autoRetries, err := validator.GetIntOrDefault("AUTO_RETRIES", DefaultAutoRetries)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get song request rate limits
+	songRateLimit, err := validator.GetIntOrDefault("SONG_RATE_LIMIT", DefaultSongRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	chatSongRateLimit, err := validator.GetIntOrDefault("CHAT_SONG_RATE_LIMIT", DefaultChatSongRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get queue persistence settings
+	queueFile := os.Getenv("QUEUE_FILE")
+	queuedRequestTTL, err := validator.GetDurationOrDefault("QUEUED_REQUEST_TTL", DefaultQueuedRequestTTL)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get failed request retention
+	failedRequestTTL, err := validator.GetDurationOrDefault("FAILED_REQUEST_TTL", DefaultFailedRequestTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the processing time limit of a request (0 = no limit)
+	requestTimeout, err := validator.GetDurationOrDefault("REQUEST_TIMEOUT", DefaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get download memory budget (0 = unlimited)
+	maxMemoryMB, err := validator.GetIntOrDefault("MAX_MEMORY_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get the size above which songs are split into parts (0 = never)
+	splitMaxMB, err := validator.GetIntOrDefault("SPLIT_MAX_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the size caps of the artwork embedded in songs
+	artworkMaxDimension, err := validator.GetIntOrDefault("ARTWORK_MAX_DIMENSION", DefaultArtworkMaxDimension)
+	if err != nil {
+		return nil, err
+	}
+	artworkMaxKB, err := validator.GetIntOrDefault("ARTWORK_MAX_KB", DefaultArtworkMaxKB)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get upload size limit, part size and part retries
+	uploadMaxMB, err := validator.GetIntOrDefault("UPLOAD_MAX_MB", MaxSplitMB)
+	if err != nil {
+		return nil, err
+	}
+	uploadPartKB, err := validator.GetIntOrDefault("UPLOAD_PART_KB", MaxUploadPartKB)
+	if err != nil {
+		return nil, err
+	}
+	uploadRetries, err := validator.GetIntOrDefault("UPLOAD_RETRIES", DefaultUploadRetries)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get bandwidth limits (0 = unlimited)
+	downloadRateLimit, err := validator.GetFloatOrDefault("DOWNLOAD_RATE_LIMIT", 0)
+	if err != nil {
+		return nil, err
+	}
+	uploadRateLimit, err := validator.GetFloatOrDefault("UPLOAD_RATE_LIMIT", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get default storefront
+	defaultStorefront := strings.ToLower(os.Getenv("DEFAULT_STOREFRONT"))
+	if defaultStorefront == "" {
+		defaultStorefront = DefaultStorefront
+	}
+	
+	// Get fallback storefronts
+	var fallbackStorefronts []string
+	for _, storefront := range strings.Split(os.Getenv("FALLBACK_STOREFRONTS"), ",") {
+		if storefront = strings.ToLower(strings.TrimSpace(storefront)); storefront != "" {
+			fallbackStorefronts = append(fallbackStorefronts, storefront)
+		}
+	}
+	
+	// Get the tags checked for completeness
+	var expectedMetadata []string
+	for _, field := range strings.Split(os.Getenv("EXPECTED_METADATA"), ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			expectedMetadata = append(expectedMetadata, field)
+		}
+	}
+	
+	// Get log deduplication settings
+	logDedupEnabled, err := validator.GetBoolOrDefault("LOG_DEDUP", true)
+	if err != nil {
+		return nil, err
+	}
+	logDedupWindow, err := validator.GetDurationOrDefault("LOG_DEDUP_WINDOW", DefaultLogDedupWindow)
+	if err != nil {
+		return nil, err
+	}
+	logDedupThreshold, err := validator.GetIntOrDefault("LOG_DEDUP_THRESHOLD", DefaultLogDedupThreshold)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Get HTTP server settings
+	httpAddr := os.Getenv("HTTP_ADDR")
+	publicBaseURL := strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	
 	config := &BotConfig{
-		Token:    token,
-		APIID:    apiID,
-		APIHash:  apiHash,
-		LogLevel: logLevel,
+		Token:               token,
+		APIID:               apiID,
+		APIHash:             apiHash,
+		LogLevel:            logLevel,
+		DeliveryReaction:    deliveryReaction,
+		AdminIDs:            adminIDs,
+		DataDir:             dataDir,
+		DumpChatID:          dumpChatID,
+		DownloadDir:         downloadDir,
+		StaleTempAge:        staleTempAge,
+		MinFreeDiskMB:       minFreeDiskMB,
+		DownloadRetries:     downloadRetries,
+		DownloadChunks:      downloadChunks,
+		DecryptTimeout:      decryptTimeout,
+		DecryptLimit:        decryptLimit,
+		StallTimeout:        stallTimeout,
+		DeviceTimeout:       deviceTimeout,
+		PreflightEnabled:    preflightEnabled,
+		AACFallback:         aacFallback,
+		MetadataLanguage:    metadataLanguage,
+		QueueSize:           queueSize,
+		QueueWorkers:        queueWorkers,
+		UserQueueLimit:      userQueueLimit,
+		AutoRetries:         autoRetries,
+		SongRateLimit:       songRateLimit,
+		ChatSongRateLimit:   chatSongRateLimit,
+		QueueFile:           queueFile,
+		QueuedRequestTTL:    queuedRequestTTL,
+		FailedRequestTTL:    failedRequestTTL,
+		RequestTimeout:      requestTimeout,
+		MaxMemoryMB:         maxMemoryMB,
+		SplitMaxMB:          splitMaxMB,
+		ArtworkMaxDimension: artworkMaxDimension,
+		ArtworkMaxKB:        artworkMaxKB,
+		UploadMaxMB:         uploadMaxMB,
+		UploadPartKB:        uploadPartKB,
+		UploadRetries:       uploadRetries,
+		DownloadRateLimit:   downloadRateLimit,
+		UploadRateLimit:     uploadRateLimit,
+		DefaultStorefront:   defaultStorefront,
+		FallbackStorefronts: fallbackStorefronts,
+		ExpectedMetadata:    expectedMetadata,
+		LogDedupEnabled:     logDedupEnabled,
+		LogDedupWindow:      logDedupWindow,
+		LogDedupThreshold:   logDedupThreshold,
+		HTTPAddr:            httpAddr,
+		PublicBaseURL:       publicBaseURL,
+		MetricsAddr:         metricsAddr,
 	}
 	
 	return config, nil
 }
 
+// IsAdmin reports whether the given user ID is listed in ADMIN_IDS
+func (c *BotConfig) IsAdmin(userID int64) bool {
+	for _, id := range c.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
 // Validate performs additional validation on the loaded configuration
 func (c *BotConfig) Validate() error {
 	if c.Token == "" {
@@ -87,5 +447,131 @@ func (c *BotConfig) Validate() error {
 		return fmt.Errorf("invalid log level: %s. Valid levels are: DEBUG, INFO, WARN, ERROR, FATAL", c.LogLevel)
 	}
 	
+	if c.QueueSize < 0 {
+		return fmt.Errorf("queue size cannot be negative, got: %d", c.QueueSize)
+	}
+	
+	if c.DefaultStorefront != "" && len(c.DefaultStorefront) != 2 {
+		return fmt.Errorf("default storefront must be a two-letter country code, got: %s", c.DefaultStorefront)
+	}
+	
+	for _, storefront := range c.FallbackStorefronts {
+		if len(storefront) != 2 {
+			return fmt.Errorf("fallback storefronts must be two-letter country codes, got: %s", storefront)
+		}
+	}
+	
+	if c.SplitMaxMB < 0 || c.SplitMaxMB > MaxSplitMB {
+		return fmt.Errorf("split size must be between 0 and %d MB, got: %d MB", MaxSplitMB, c.SplitMaxMB)
+	}
+	
+	if c.ArtworkMaxDimension < 0 {
+		return fmt.Errorf("artwork max dimension cannot be negative, got: %d", c.ArtworkMaxDimension)
+	}
+
+	if c.ArtworkMaxKB < 0 {
+		return fmt.Errorf("artwork size limit cannot be negative, got: %d KB", c.ArtworkMaxKB)
+	}
+
+	if c.UploadMaxMB < 0 || c.UploadMaxMB > MaxSplitMB {
+		return fmt.Errorf("upload limit must be between 0 and %d MB, got: %d MB", MaxSplitMB, c.UploadMaxMB)
+	}
+	
+	// Telegram needs parts of a power of two KB that divides 512 KB
+	if c.UploadPartKB < 0 || c.UploadPartKB > MaxUploadPartKB || c.UploadPartKB&(c.UploadPartKB-1) != 0 {
+		return fmt.Errorf("upload part size must be a power of two up to %d KB, got: %d KB", MaxUploadPartKB, c.UploadPartKB)
+	}
+	
+	if c.UploadRetries < 0 {
+		return fmt.Errorf("upload retries cannot be negative, got: %d", c.UploadRetries)
+	}
+	
+	if c.MaxMemoryMB < 0 {
+		return fmt.Errorf("max memory cannot be negative, got: %d MB", c.MaxMemoryMB)
+	}
+	
+	if c.DownloadRateLimit < 0 || c.UploadRateLimit < 0 {
+		return fmt.Errorf("rate limits cannot be negative, got: %g MB/s download, %g MB/s upload", c.DownloadRateLimit, c.UploadRateLimit)
+	}
+	
+	if c.DownloadRetries < 0 {
+		return fmt.Errorf("download retries cannot be negative, got: %d", c.DownloadRetries)
+	}
+	
+	if c.DownloadChunks < 0 {
+		return fmt.Errorf("download chunks cannot be negative, got: %d", c.DownloadChunks)
+	}
+	
+	if c.DumpChatID != 0 && c.DumpChatID > -1000000000000 {
+		return fmt.Errorf("dump chat ID must be a channel ID such as -1001234567890, got: %d", c.DumpChatID)
+	}
+	
+	if c.DecryptTimeout < 0 {
+		return fmt.Errorf("decrypt timeout cannot be negative, got: %s", c.DecryptTimeout)
+	}
+
+	if c.DecryptLimit < 0 {
+		return fmt.Errorf("decrypt concurrency cannot be negative, got: %d", c.DecryptLimit)
+	}
+
+	if c.StallTimeout < 0 {
+		return fmt.Errorf("stall timeout cannot be negative, got: %s", c.StallTimeout)
+	}
+
+	if c.DeviceTimeout < 0 {
+		return fmt.Errorf("device timeout cannot be negative, got: %s", c.DeviceTimeout)
+	}
+	
+	if c.StaleTempAge < 0 {
+		return fmt.Errorf("stale temp age cannot be negative, got: %s", c.StaleTempAge)
+	}
+
+	if c.MinFreeDiskMB < 0 {
+		return fmt.Errorf("min free disk cannot be negative, got: %d MB", c.MinFreeDiskMB)
+	}
+	
+	if c.QueueWorkers < 0 {
+		return fmt.Errorf("queue workers cannot be negative, got: %d", c.QueueWorkers)
+	}
+	
+	if c.QueuedRequestTTL < 0 {
+		return fmt.Errorf("queued request TTL cannot be negative, got: %s", c.QueuedRequestTTL)
+	}
+
+	if c.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout cannot be negative, got: %s", c.RequestTimeout)
+	}
+	
+	if c.UserQueueLimit < 0 {
+		return fmt.Errorf("user queue limit cannot be negative, got: %d", c.UserQueueLimit)
+	}
+
+	if c.AutoRetries < 0 {
+		return fmt.Errorf("auto retries cannot be negative, got: %d", c.AutoRetries)
+	}
+	
+	if c.SongRateLimit < 0 {
+		return fmt.Errorf("song rate limit cannot be negative, got: %d", c.SongRateLimit)
+	}
+	
+	if c.ChatSongRateLimit < 0 {
+		return fmt.Errorf("chat song rate limit cannot be negative, got: %d", c.ChatSongRateLimit)
+	}
+	
+	if c.LogDedupEnabled && c.LogDedupThreshold < 1 {
+		return fmt.Errorf("log dedup threshold must be at least 1, got: %d", c.LogDedupThreshold)
+	}
+	
+	if c.PublicBaseURL != "" {
+		u, err := url.Parse(c.PublicBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("public base URL must be an absolute http(s) URL, got: %s", c.PublicBaseURL)
+		}
+	}
+	
+	if c.MetricsAddr != "" && c.MetricsAddr == c.HTTPAddr {
+		return fmt.Errorf("metrics address must differ from the HTTP server address, got: %s", c.MetricsAddr)
+	}
+	
 	return nil
 }
\ No newline at end of file
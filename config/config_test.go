@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -40,6 +42,46 @@ func TestLoadConfig(t *testing.T) {
 			expectError: true,
 			errorMsg:    "environment validation failed",
 		},
+		{
+			name: "custom delivery reaction",
+			envVars: map[string]string{
+				"BOT_TOKEN":         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				"API_ID":            "12345",
+				"API_HASH":          "abcdef123456",
+				"DELIVERY_REACTION": "🔥",
+			},
+			expectError: false,
+		},
+		{
+			name: "custom default storefront",
+			envVars: map[string]string{
+				"BOT_TOKEN":          "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				"API_ID":             "12345",
+				"API_HASH":           "abcdef123456",
+				"DEFAULT_STOREFRONT": "GB",
+			},
+			expectError: false,
+		},
+		{
+			name: "fallback storefronts",
+			envVars: map[string]string{
+				"BOT_TOKEN":            "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				"API_ID":               "12345",
+				"API_HASH":             "abcdef123456",
+				"FALLBACK_STOREFRONTS": "GB, jp,,us",
+			},
+			expectError: false,
+		},
+		{
+			name: "split size",
+			envVars: map[string]string{
+				"BOT_TOKEN":    "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				"API_ID":       "12345",
+				"API_HASH":     "abcdef123456",
+				"SPLIT_MAX_MB": "50",
+			},
+			expectError: false,
+		},
 		{
 			name: "invalid API_ID",
 			envVars: map[string]string{
@@ -94,6 +136,38 @@ func TestLoadConfig(t *testing.T) {
 				if config.LogLevel != expectedLogLevel {
 					t.Errorf("expected log level %q, got %q", expectedLogLevel, config.LogLevel)
 				}
+				
+				expectedReaction := tt.envVars["DELIVERY_REACTION"]
+				if expectedReaction == "" {
+					expectedReaction = DefaultDeliveryReaction
+				}
+				if config.DeliveryReaction != expectedReaction {
+					t.Errorf("expected delivery reaction %q, got %q", expectedReaction, config.DeliveryReaction)
+				}
+
+				expectedStorefront := strings.ToLower(tt.envVars["DEFAULT_STOREFRONT"])
+				if expectedStorefront == "" {
+					expectedStorefront = DefaultStorefront
+				}
+				if config.DefaultStorefront != expectedStorefront {
+					t.Errorf("expected default storefront %q, got %q", expectedStorefront, config.DefaultStorefront)
+				}
+
+				if tt.envVars["FALLBACK_STOREFRONTS"] != "" {
+					if got := strings.Join(config.FallbackStorefronts, ","); got != "gb,jp,us" {
+						t.Errorf("expected fallback storefronts gb,jp,us, got %q", got)
+					}
+				} else if len(config.FallbackStorefronts) != 0 {
+					t.Errorf("expected no fallback storefronts, got %v", config.FallbackStorefronts)
+				}
+
+				expectedSplit := 0
+				if tt.envVars["SPLIT_MAX_MB"] != "" {
+					expectedSplit = 50
+				}
+				if config.SplitMaxMB != expectedSplit {
+					t.Errorf("expected split size %d MB, got %d MB", expectedSplit, config.SplitMaxMB)
+				}
 			}
 		})
 	}
@@ -171,6 +245,316 @@ func TestBotConfig_Validate(t *testing.T) {
 			expectError: true,
 			errorMsg:    "invalid log level",
 		},
+		{
+			name: "invalid default storefront",
+			config: &BotConfig{
+				Token:             "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:             12345,
+				APIHash:           "abcdef123456",
+				LogLevel:          "INFO",
+				DefaultStorefront: "usa",
+			},
+			expectError: true,
+			errorMsg:    "default storefront must be a two-letter country code",
+		},
+		{
+			name: "invalid fallback storefront",
+			config: &BotConfig{
+				Token:               "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:               12345,
+				APIHash:             "abcdef123456",
+				LogLevel:            "INFO",
+				FallbackStorefronts: []string{"gb", "japan"},
+			},
+			expectError: true,
+			errorMsg:    "fallback storefronts must be two-letter country codes",
+		},
+		{
+			name: "negative rate limit",
+			config: &BotConfig{
+				Token:           "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:           12345,
+				APIHash:         "abcdef123456",
+				LogLevel:        "INFO",
+				UploadRateLimit: -1,
+			},
+			expectError: true,
+			errorMsg:    "rate limits cannot be negative",
+		},
+		{
+			name: "negative song rate limit",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				SongRateLimit: -1,
+			},
+			expectError: true,
+			errorMsg:    "song rate limit cannot be negative",
+		},
+		{
+			name: "invalid public base URL",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				PublicBaseURL: "bot.example.com",
+			},
+			expectError: true,
+			errorMsg:    "public base URL must be an absolute http(s) URL",
+		},
+		{
+			name: "metrics address same as HTTP address",
+			config: &BotConfig{
+				Token:       "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:       12345,
+				APIHash:     "abcdef123456",
+				LogLevel:    "INFO",
+				HTTPAddr:    ":8080",
+				MetricsAddr: ":8080",
+			},
+			expectError: true,
+			errorMsg:    "metrics address must differ from the HTTP server address",
+		},
+		{
+			name: "valid public base URL",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				PublicBaseURL: "https://bot.example.com",
+			},
+			expectError: false,
+		},
+		{
+			name: "negative split size",
+			config: &BotConfig{
+				Token:      "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:      12345,
+				APIHash:    "abcdef123456",
+				LogLevel:   "INFO",
+				SplitMaxMB: -1,
+			},
+			expectError: true,
+			errorMsg:    "split size must be between 0 and 2000 MB",
+		},
+		{
+			name: "split size over upload limit",
+			config: &BotConfig{
+				Token:      "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:      12345,
+				APIHash:    "abcdef123456",
+				LogLevel:   "INFO",
+				SplitMaxMB: 2001,
+			},
+			expectError: true,
+			errorMsg:    "split size must be between 0 and 2000 MB",
+		},
+		{
+			name: "valid split size",
+			config: &BotConfig{
+				Token:      "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:      12345,
+				APIHash:    "abcdef123456",
+				LogLevel:   "INFO",
+				SplitMaxMB: 50,
+			},
+			expectError: false,
+		},
+		{
+			name: "negative download chunks",
+			config: &BotConfig{
+				Token:          "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:          12345,
+				APIHash:        "abcdef123456",
+				LogLevel:       "INFO",
+				DownloadChunks: -1,
+			},
+			expectError: true,
+			errorMsg:    "download chunks cannot be negative",
+		},
+		{
+			name: "upload limit above telegram limit",
+			config: &BotConfig{
+				Token:       "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:       12345,
+				APIHash:     "abcdef123456",
+				LogLevel:    "INFO",
+				UploadMaxMB: 4000,
+			},
+			expectError: true,
+			errorMsg:    "upload limit must be between 0 and 2000 MB",
+		},
+		{
+			name: "upload part size not a power of two",
+			config: &BotConfig{
+				Token:        "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:        12345,
+				APIHash:      "abcdef123456",
+				LogLevel:     "INFO",
+				UploadPartKB: 300,
+			},
+			expectError: true,
+			errorMsg:    "upload part size must be a power of two",
+		},
+		{
+			name: "negative artwork dimension",
+			config: &BotConfig{
+				Token:               "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:               12345,
+				APIHash:             "abcdef123456",
+				LogLevel:            "INFO",
+				ArtworkMaxDimension: -1,
+			},
+			expectError: true,
+			errorMsg:    "artwork max dimension cannot be negative",
+		},
+		{
+			name: "negative artwork size limit",
+			config: &BotConfig{
+				Token:        "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:        12345,
+				APIHash:      "abcdef123456",
+				LogLevel:     "INFO",
+				ArtworkMaxKB: -1,
+			},
+			expectError: true,
+			errorMsg:    "artwork size limit cannot be negative",
+		},
+		{
+			name: "upload part size above telegram maximum",
+			config: &BotConfig{
+				Token:        "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:        12345,
+				APIHash:      "abcdef123456",
+				LogLevel:     "INFO",
+				UploadPartKB: 1024,
+			},
+			expectError: true,
+			errorMsg:    "upload part size must be a power of two",
+		},
+		{
+			name: "negative upload retries",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				UploadRetries: -1,
+			},
+			expectError: true,
+			errorMsg:    "upload retries cannot be negative",
+		},
+		{
+			name: "negative decrypt timeout",
+			config: &BotConfig{
+				Token:          "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:          12345,
+				APIHash:        "abcdef123456",
+				LogLevel:       "INFO",
+				DecryptTimeout: -time.Second,
+			},
+			expectError: true,
+			errorMsg:    "decrypt timeout cannot be negative",
+		},
+		{
+			name: "negative min free disk",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				MinFreeDiskMB: -1,
+			},
+			expectError: true,
+			errorMsg:    "min free disk cannot be negative",
+		},
+		{
+			name: "negative auto retries",
+			config: &BotConfig{
+				Token:       "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:       12345,
+				APIHash:     "abcdef123456",
+				LogLevel:    "INFO",
+				AutoRetries: -1,
+			},
+			expectError: true,
+			errorMsg:    "auto retries cannot be negative",
+		},
+		{
+			name: "negative decrypt concurrency",
+			config: &BotConfig{
+				Token:        "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:        12345,
+				APIHash:      "abcdef123456",
+				LogLevel:     "INFO",
+				DecryptLimit: -1,
+			},
+			expectError: true,
+			errorMsg:    "decrypt concurrency cannot be negative",
+		},
+		{
+			name: "negative stall timeout",
+			config: &BotConfig{
+				Token:        "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:        12345,
+				APIHash:      "abcdef123456",
+				LogLevel:     "INFO",
+				StallTimeout: -time.Second,
+			},
+			expectError: true,
+			errorMsg:    "stall timeout cannot be negative",
+		},
+		{
+			name: "negative device timeout",
+			config: &BotConfig{
+				Token:         "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:         12345,
+				APIHash:       "abcdef123456",
+				LogLevel:      "INFO",
+				DeviceTimeout: -time.Second,
+			},
+			expectError: true,
+			errorMsg:    "device timeout cannot be negative",
+		},
+		{
+			name: "negative request timeout",
+			config: &BotConfig{
+				Token:          "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:          12345,
+				APIHash:        "abcdef123456",
+				LogLevel:       "INFO",
+				RequestTimeout: -time.Minute,
+			},
+			expectError: true,
+			errorMsg:    "request timeout cannot be negative",
+		},
+		{
+			name: "dump chat that is no channel",
+			config: &BotConfig{
+				Token:      "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:      12345,
+				APIHash:    "abcdef123456",
+				LogLevel:   "INFO",
+				DumpChatID: 123456,
+			},
+			expectError: true,
+			errorMsg:    "dump chat ID must be a channel ID",
+		},
+		{
+			name: "dump channel",
+			config: &BotConfig{
+				Token:      "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11",
+				APIID:      12345,
+				APIHash:    "abcdef123456",
+				LogLevel:   "INFO",
+				DumpChatID: -1001234567890,
+			},
+			expectError: false,
+		},
 		{
 			name: "valid DEBUG log level",
 			config: &BotConfig{
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // EnvValidator handles validation of required environment variables
@@ -63,4 +65,109 @@ func (e *EnvValidator) GetAPICredentials() (apiID int, apiHash string, err error
 	}
 	
 	return apiID, apiHash, nil
-}
\ No newline at end of file
+}
+
+// GetAdminIDs returns the comma-separated user IDs from ADMIN_IDS
+// Returns an empty list if the variable is not set
+func (e *EnvValidator) GetAdminIDs() ([]int64, error) {
+	value := os.Getenv("ADMIN_IDS")
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	
+	var ids []int64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ADMIN_IDS must be a comma-separated list of user IDs, got: %s", part)
+		}
+		ids = append(ids, id)
+	}
+	
+	return ids, nil
+}
+
+// GetIntOrDefault returns the integer value of an environment variable,
+// or defaultValue if it is not set
+func (e *EnvValidator) GetIntOrDefault(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a valid integer, got: %s", name, value)
+	}
+	
+	return parsed, nil
+}
+
+// GetInt64OrDefault returns the 64-bit integer value of an environment
+// variable, such as a chat ID, or defaultValue if it is not set
+func (e *EnvValidator) GetInt64OrDefault(name string, defaultValue int64) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a valid integer, got: %s", name, value)
+	}
+	
+	return parsed, nil
+}
+
+// GetFloatOrDefault returns the float value of an environment variable, or
+// defaultValue if it is not set
+func (e *EnvValidator) GetFloatOrDefault(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a valid number, got: %s", name, value)
+	}
+
+	return parsed, nil
+}
+
+// GetDurationOrDefault returns the duration value (e.g. "30m", "24h") of an
+// environment variable, or defaultValue if it is not set
+func (e *EnvValidator) GetDurationOrDefault(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30m or 24h, got: %s", name, value)
+	}
+	
+	return parsed, nil
+}
+
+// GetBoolOrDefault returns the boolean value (true/false, 1/0) of an
+// environment variable, or defaultValue if it is not set
+func (e *EnvValidator) GetBoolOrDefault(name string, defaultValue bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got: %s", name, value)
+	}
+	
+	return parsed, nil
+}
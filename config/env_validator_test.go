@@ -215,4 +215,121 @@ func TestEnvValidator_GetAPICredentials(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestEnvValidator_GetAdminIDs(t *testing.T) {
+	validator := NewEnvValidator()
+
+	tests := []struct {
+		name        string
+		envValue    string
+		expected    []int64
+		expectError bool
+	}{
+		{
+			name:     "not set",
+			envValue: "",
+			expected: nil,
+		},
+		{
+			name:     "single ID",
+			envValue: "12345",
+			expected: []int64{12345},
+		},
+		{
+			name:     "multiple IDs with spaces",
+			envValue: "12345, 67890 ,",
+			expected: []int64{12345, 67890},
+		},
+		{
+			name:        "invalid ID",
+			envValue:    "12345,abc",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			if tt.envValue != "" {
+				os.Setenv("ADMIN_IDS", tt.envValue)
+			}
+
+			ids, err := validator.GetAdminIDs()
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("expected no error but got: %v", err)
+				return
+			}
+			if len(ids) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvValidator_GetIntOrDefault(t *testing.T) {
+	validator := NewEnvValidator()
+
+	os.Clearenv()
+	if value, err := validator.GetIntOrDefault("QUEUE_SIZE", 7); err != nil || value != 7 {
+		t.Errorf("expected default 7, got %d (err: %v)", value, err)
+	}
+
+	os.Setenv("QUEUE_SIZE", "12")
+	if value, err := validator.GetIntOrDefault("QUEUE_SIZE", 7); err != nil || value != 12 {
+		t.Errorf("expected 12, got %d (err: %v)", value, err)
+	}
+
+	os.Setenv("QUEUE_SIZE", "lots")
+	if _, err := validator.GetIntOrDefault("QUEUE_SIZE", 7); err == nil {
+		t.Errorf("expected error for non-integer value")
+	}
+}
+
+func TestEnvValidator_GetFloatOrDefault(t *testing.T) {
+	validator := NewEnvValidator()
+
+	os.Clearenv()
+	if value, err := validator.GetFloatOrDefault("DOWNLOAD_RATE_LIMIT", 0); err != nil || value != 0 {
+		t.Errorf("expected default 0, got %g (err: %v)", value, err)
+	}
+
+	os.Setenv("DOWNLOAD_RATE_LIMIT", "2.5")
+	if value, err := validator.GetFloatOrDefault("DOWNLOAD_RATE_LIMIT", 0); err != nil || value != 2.5 {
+		t.Errorf("expected 2.5, got %g (err: %v)", value, err)
+	}
+
+	os.Setenv("DOWNLOAD_RATE_LIMIT", "fast")
+	if _, err := validator.GetFloatOrDefault("DOWNLOAD_RATE_LIMIT", 0); err == nil {
+		t.Errorf("expected error for non-numeric value")
+	}
+}
+
+func TestEnvValidator_GetBoolOrDefault(t *testing.T) {
+	validator := NewEnvValidator()
+
+	os.Clearenv()
+	if value, err := validator.GetBoolOrDefault("LOG_DEDUP", true); err != nil || !value {
+		t.Errorf("expected default true, got %v (err: %v)", value, err)
+	}
+
+	os.Setenv("LOG_DEDUP", "false")
+	if value, err := validator.GetBoolOrDefault("LOG_DEDUP", true); err != nil || value {
+		t.Errorf("expected false, got %v (err: %v)", value, err)
+	}
+
+	os.Setenv("LOG_DEDUP", "sometimes")
+	if _, err := validator.GetBoolOrDefault("LOG_DEDUP", true); err == nil {
+		t.Errorf("expected error for non-boolean value")
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Source tells where the effective value of a setting came from
+type Source string
+
+const (
+	SourceEnv     Source = "env"     // Set in the environment or .env file
+	SourceDefault Source = "default" // Not set, the built-in default applies
+	SourceRuntime Source = "runtime" // Changed while running, e.g. by /setqueue
+)
+
+// SettingKind tells how a setting value is shown in a snapshot
+type SettingKind int
+
+const (
+	KindPlain  SettingKind = iota
+	KindSecret             // Shown masked
+	KindURL                // Credentials in the URL are masked
+)
+
+// ConfigEntry is one setting in a configuration snapshot, with secrets masked
+type ConfigEntry struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// setting is a registered setting with its unmasked value
+type setting struct {
+	name   string
+	value  string
+	kind   SettingKind
+	source Source
+}
+
+// Registry tracks the effective value of every setting the bot runs with,
+// including settings read by other packages, and where each one came from
+type Registry struct {
+	mu       sync.RWMutex
+	settings []*setting
+}
+
+// NewRegistry creates a registry holding the settings of cfg
+func NewRegistry(cfg *BotConfig) *Registry {
+	r := &Registry{}
+
+	adminIDs := make([]string, len(cfg.AdminIDs))
+	for i, id := range cfg.AdminIDs {
+		adminIDs[i] = strconv.FormatInt(id, 10)
+	}
+
+	r.Register("BOT_TOKEN", cfg.Token, KindSecret)
+	r.Register("API_ID", strconv.Itoa(cfg.APIID), KindPlain)
+	r.Register("API_HASH", cfg.APIHash, KindSecret)
+	r.Register("LOG_LEVEL", cfg.LogLevel, KindPlain)
+	r.Register("DELIVERY_REACTION", cfg.DeliveryReaction, KindPlain)
+	r.Register("ADMIN_IDS", strings.Join(adminIDs, ","), KindPlain)
+	r.Register("DATA_DIR", cfg.DataDir, KindPlain)
+	r.Register("DUMP_CHAT_ID", strconv.FormatInt(cfg.DumpChatID, 10), KindPlain)
+	r.Register("DOWNLOAD_DIR", cfg.DownloadDir, KindPlain)
+	r.Register("STALE_TEMP_AGE", cfg.StaleTempAge.String(), KindPlain)
+	r.Register("MIN_FREE_DISK_MB", strconv.Itoa(cfg.MinFreeDiskMB), KindPlain)
+	r.Register("DOWNLOAD_RETRIES", strconv.Itoa(cfg.DownloadRetries), KindPlain)
+	r.Register("DOWNLOAD_CHUNKS", strconv.Itoa(cfg.DownloadChunks), KindPlain)
+	r.Register("DECRYPT_TIMEOUT", cfg.DecryptTimeout.String(), KindPlain)
+	r.Register("DECRYPT_CONCURRENCY", strconv.Itoa(cfg.DecryptLimit), KindPlain)
+	r.Register("STALL_TIMEOUT", cfg.StallTimeout.String(), KindPlain)
+	r.Register("DEVICE_TIMEOUT", cfg.DeviceTimeout.String(), KindPlain)
+	r.Register("PREFLIGHT", strconv.FormatBool(cfg.PreflightEnabled), KindPlain)
+	r.Register("AAC_FALLBACK", strconv.FormatBool(cfg.AACFallback), KindPlain)
+	r.Register("METADATA_LANG", cfg.MetadataLanguage, KindPlain)
+	r.Register("QUEUE_SIZE", strconv.Itoa(cfg.QueueSize), KindPlain)
+	r.Register("QUEUE_WORKERS", strconv.Itoa(cfg.QueueWorkers), KindPlain)
+	r.Register("USER_QUEUE_LIMIT", strconv.Itoa(cfg.UserQueueLimit), KindPlain)
+	r.Register("AUTO_RETRIES", strconv.Itoa(cfg.AutoRetries), KindPlain)
+	r.Register("SONG_RATE_LIMIT", strconv.Itoa(cfg.SongRateLimit), KindPlain)
+	r.Register("CHAT_SONG_RATE_LIMIT", strconv.Itoa(cfg.ChatSongRateLimit), KindPlain)
+	r.Register("QUEUE_FILE", cfg.QueueFile, KindPlain)
+	r.Register("QUEUED_REQUEST_TTL", cfg.QueuedRequestTTL.String(), KindPlain)
+	r.Register("FAILED_REQUEST_TTL", cfg.FailedRequestTTL.String(), KindPlain)
+	r.Register("REQUEST_TIMEOUT", cfg.RequestTimeout.String(), KindPlain)
+	r.Register("MAX_MEMORY_MB", strconv.Itoa(cfg.MaxMemoryMB), KindPlain)
+	r.Register("SPLIT_MAX_MB", strconv.Itoa(cfg.SplitMaxMB), KindPlain)
+	r.Register("ARTWORK_MAX_DIMENSION", strconv.Itoa(cfg.ArtworkMaxDimension), KindPlain)
+	r.Register("ARTWORK_MAX_KB", strconv.Itoa(cfg.ArtworkMaxKB), KindPlain)
+	r.Register("UPLOAD_MAX_MB", strconv.Itoa(cfg.UploadMaxMB), KindPlain)
+	r.Register("UPLOAD_PART_KB", strconv.Itoa(cfg.UploadPartKB), KindPlain)
+	r.Register("UPLOAD_RETRIES", strconv.Itoa(cfg.UploadRetries), KindPlain)
+	r.Register("DOWNLOAD_RATE_LIMIT", strconv.FormatFloat(cfg.DownloadRateLimit, 'g', -1, 64), KindPlain)
+	r.Register("UPLOAD_RATE_LIMIT", strconv.FormatFloat(cfg.UploadRateLimit, 'g', -1, 64), KindPlain)
+	r.Register("DEFAULT_STOREFRONT", cfg.DefaultStorefront, KindPlain)
+	r.Register("FALLBACK_STOREFRONTS", strings.Join(cfg.FallbackStorefronts, ","), KindPlain)
+	r.Register("EXPECTED_METADATA", strings.Join(cfg.ExpectedMetadata, ","), KindPlain)
+	r.Register("LOG_DEDUP", strconv.FormatBool(cfg.LogDedupEnabled), KindPlain)
+	r.Register("LOG_DEDUP_WINDOW", cfg.LogDedupWindow.String(), KindPlain)
+	r.Register("LOG_DEDUP_THRESHOLD", strconv.Itoa(cfg.LogDedupThreshold), KindPlain)
+	r.Register("HTTP_ADDR", cfg.HTTPAddr, KindPlain)
+	r.Register("PUBLIC_BASE_URL", cfg.PublicBaseURL, KindURL)
+	r.Register("METRICS_ADDR", cfg.MetricsAddr, KindPlain)
+
+	return r
+}
+
+// Register adds the effective value of the setting read from the environment
+// variable name. Its source is env when the variable is set, default otherwise
+func (r *Registry) Register(name, value string, kind SettingKind) {
+	source := SourceDefault
+	if env, ok := os.LookupEnv(name); ok && env != "" {
+		source = SourceEnv
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.find(name); s != nil {
+		s.value, s.kind, s.source = value, kind, source
+		return
+	}
+	r.settings = append(r.settings, &setting{name: name, value: value, kind: kind, source: source})
+}
+
+// SetOverride records that a setting was changed while running
+func (r *Registry) SetOverride(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.find(name); s != nil {
+		s.value, s.source = value, SourceRuntime
+		return
+	}
+	r.settings = append(r.settings, &setting{name: name, value: value, source: SourceRuntime})
+}
+
+// ConfigSnapshot returns every setting in registration order with secrets masked
+func (r *Registry) ConfigSnapshot() []ConfigEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]ConfigEntry, len(r.settings))
+	for i, s := range r.settings {
+		entries[i] = ConfigEntry{Name: s.name, Value: s.masked(), Source: s.source}
+	}
+	return entries
+}
+
+func (r *Registry) find(name string) *setting {
+	for _, s := range r.settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// masked returns the value of s safe to show to admins
+func (s *setting) masked() string {
+	switch {
+	case s.value == "":
+		return ""
+	case s.kind == KindSecret:
+		return MaskString(s.value)
+	case s.kind == KindURL:
+		return maskURLCredentials(s.value)
+	default:
+		return s.value
+	}
+}
+
+// MaskString masks sensitive information, keeping only the ends of long values
+func MaskString(s string) string {
+	if len(s) <= 8 {
+		return "***"
+	}
+	return s[:4] + "***" + s[len(s)-4:]
+}
+
+// maskURLCredentials masks the credentials of a URL with user info the way
+// URL.Redacted does, also covering a token given as the user name. Values
+// that do not parse are masked entirely since they may hold anything
+func maskURLCredentials(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return MaskString(raw)
+	}
+	if u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); !ok {
+		u.User = url.User("xxxxx")
+	}
+	return u.Redacted()
+}
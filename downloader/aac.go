@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/abema/go-mp4"
+)
+
+// readAACEntry reads the sample entry and esds box of an AAC stream from its
+// enca box into info
+func readAACEntry(r io.ReadSeeker, enca *mp4.BoxInfoWithPayload, info *SongInfo) error {
+	entry, ok := enca.Payload.(*mp4.AudioSampleEntry)
+	if !ok {
+		return fmt.Errorf("unexpected sample entry payload %T", enca.Payload)
+	}
+
+	esds, err := mp4.ExtractBoxWithPayload(r, &enca.Info, []mp4.BoxType{mp4.BoxTypeEsds()})
+	if err != nil {
+		return err
+	}
+	if len(esds) != 1 {
+		return errors.New("expected an alac or esds box in the sample entry")
+	}
+
+	info.aacEntry = entry
+	info.esds = &esds[0].Info
+	if payload, ok := esds[0].Payload.(*mp4.Esds); ok {
+		for _, descriptor := range payload.Descriptors {
+			if descriptor.DecoderConfigDescriptor != nil {
+				info.aacBitrate = descriptor.DecoderConfigDescriptor.AvgBitrate
+			}
+		}
+	}
+	return nil
+}
+
+// writeAACSampleEntry writes the mp4a sample entry of an AAC song: the audio
+// fields of the encrypted entry and its esds box, without the protection
+// scheme
+func writeAACSampleEntry(w *mp4.Writer, info *SongInfo) error {
+	box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMp4a()})
+	if err != nil {
+		return err
+	}
+
+	entry := *info.aacEntry
+	entry.SetType(mp4.BoxTypeMp4a())
+	if _, err := mp4.Marshal(w, &entry, box.Context); err != nil {
+		return err
+	}
+
+	if err := w.CopyBox(info.r, info.esds); err != nil {
+		return err
+	}
+
+	_, err = w.EndBox()
+	return err
+}
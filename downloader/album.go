@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TrackListProgress is the overall progress of an album or playlist
+// download, reported before each track and once all tracks are done
+type TrackListProgress struct {
+	Track      int    `json:"track"` // 1-based index of the current track, 0 when done
+	TrackCount int    `json:"track_count"`
+	Title      string `json:"title"` // Title of the current track
+	Completed  int    `json:"completed"`
+	Failed     int    `json:"failed"`
+}
+
+// FailedTrack is an album or playlist track that was skipped or failed to
+// download
+type FailedTrack struct {
+	SongID string `json:"song_id"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+	Err    error  `json:"-"`
+}
+
+// AlbumDownloadResult contains the result of an album download: the tracks
+// that were downloaded, in album order, and those that were not
+type AlbumDownloadResult struct {
+	AlbumID  string            `json:"album_id"`
+	Name     string            `json:"name"`
+	Artist   string            `json:"artist"`
+	Tracks   []*DownloadResult `json:"tracks"`
+	Failed   []FailedTrack     `json:"failed,omitempty"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// DownloadAlbum downloads the songs of an album one after the other. Every
+// track goes through Download with callbacks, so OnComplete fires as each
+// file is ready; OnTrackListProgress reports the overall progress. Tracks
+// that fail, e.g. because they have no ALAC, are recorded in Failed and
+// skipped. Cancellation stops before the next track and returns what was
+// downloaded so far with the error
+func (sd *SongDownloaderImpl) DownloadAlbum(ctx context.Context, url string, callbacks ProgressCallbacks) (*AlbumDownloadResult, error) {
+	startTime := time.Now()
+
+	urlMeta, err := sd.ExtractUrlMeta(url)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorInvalidURL, "failed to extract URL metadata", err)
+	}
+	if urlMeta.URLType != "albums" {
+		return nil, NewDownloadError(ErrorInvalidURL, fmt.Sprintf("expected an album URL, got %s", urlMeta.URLType))
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	album, err := sd.GetAlbumMeta(ctx, urlMeta, token)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get album metadata", err)
+	}
+
+	result := &AlbumDownloadResult{
+		AlbumID: album.ID,
+		Name:    album.Attributes.Name,
+		Artist:  album.Attributes.ArtistName,
+	}
+	result.Tracks, result.Failed, err = sd.downloadTracks(ctx, urlMeta.Storefront, album.Relationships.Tracks.Data, callbacks)
+	result.Duration = time.Since(startTime)
+	return result, err
+}
+
+// downloadTracks downloads tracks one after the other through the song
+// pipeline, skipping those that fail. It stops with the error when ctx is
+// cancelled
+func (sd *SongDownloaderImpl) downloadTracks(ctx context.Context, storefront string, tracks []AutoSong, callbacks ProgressCallbacks) ([]*DownloadResult, []FailedTrack, error) {
+	var downloaded []*DownloadResult
+	var failed []FailedTrack
+
+	for i, track := range tracks {
+		if err := ctx.Err(); err != nil {
+			return downloaded, failed, NewDownloadErrorWithCause(ErrorCancelled, "download cancelled", err)
+		}
+
+		sd.reportTrackListProgress(callbacks, TrackListProgress{
+			Track:      i + 1,
+			TrackCount: len(tracks),
+			Title:      track.Attributes.Name,
+			Completed:  len(downloaded),
+			Failed:     len(failed),
+		})
+
+		// Music videos are listed among the tracks but have no audio stream
+		if track.Type != "" && track.Type != "songs" {
+			failed = append(failed, FailedTrack{SongID: track.ID, Title: track.Attributes.Name, Reason: "not a song"})
+			continue
+		}
+
+		// Metadata comes from the song endpoint, so the tags match single
+		// song downloads
+		trackResult, err := sd.Download(ctx, trackURL(storefront, track.ID), callbacks)
+		if err != nil {
+			if IsDownloadError(err, ErrorCancelled) || errors.Is(err, context.Canceled) {
+				return downloaded, failed, err
+			}
+			failed = append(failed, FailedTrack{SongID: track.ID, Title: track.Attributes.Name, Reason: failedTrackReason(err), Err: err})
+			continue
+		}
+		downloaded = append(downloaded, trackResult)
+	}
+
+	sd.reportTrackListProgress(callbacks, TrackListProgress{
+		TrackCount: len(tracks),
+		Completed:  len(downloaded),
+		Failed:     len(failed),
+	})
+	return downloaded, failed, nil
+}
+
+// reportTrackListProgress calls the track list progress callback when one is
+// set
+func (sd *SongDownloaderImpl) reportTrackListProgress(callbacks ProgressCallbacks, progress TrackListProgress) {
+	if callbacks.OnTrackListProgress != nil {
+		callbacks.OnTrackListProgress(progress)
+	}
+}
+
+// trackURL returns the song link of a track
+func trackURL(storefront, songID string) string {
+	return fmt.Sprintf("https://music.apple.com/%s/song/_/%s", storefront, songID)
+}
+
+// failedTrackReason describes why a track was not downloaded
+func failedTrackReason(err error) string {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		switch downloadErr.Type {
+		case ErrorALACNotAvailable:
+			return "ALAC not available"
+		case ErrorNotReleased:
+			return "not released yet"
+		}
+	}
+	if errors.Is(err, ErrNotInStorefront) {
+		return "not available in this storefront"
+	}
+	return err.Error()
+}
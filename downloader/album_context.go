@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"go-alac-bot/logging"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// albumContextTTL is how long a fetched album context is reused by later
+// tracks of the same album
+const albumContextTTL = 10 * time.Minute
+
+// AlbumContext is what every track of an album shares: the album metadata,
+// its artwork, disc and track totals and which tracks have lyrics. It is
+// built once per album and never modified afterwards, so track pipelines
+// running in parallel can read it without locking
+type AlbumContext struct {
+	album      AutoAlbum
+	artwork    []byte
+	discTotal  int
+	trackTotal int
+	lyrics     map[string]bool
+}
+
+// newAlbumContext builds the context of album with its fetched artwork
+func newAlbumContext(album *AutoAlbum, artwork []byte) *AlbumContext {
+	ac := &AlbumContext{
+		album:      *album,
+		artwork:    artwork,
+		trackTotal: album.Attributes.TrackCount,
+		lyrics:     make(map[string]bool),
+	}
+	for _, track := range album.Relationships.Tracks.Data {
+		if track.Attributes.DiscNumber > ac.discTotal {
+			ac.discTotal = track.Attributes.DiscNumber
+		}
+		ac.lyrics[track.ID] = track.Attributes.HasLyrics
+	}
+	if ac.trackTotal == 0 {
+		ac.trackTotal = len(album.Relationships.Tracks.Data)
+	}
+	return ac
+}
+
+// Album returns the album metadata. The slices it holds are shared and must
+// not be modified
+func (ac *AlbumContext) Album() AutoAlbum {
+	return ac.album
+}
+
+// Artwork returns a copy of the album artwork
+func (ac *AlbumContext) Artwork() []byte {
+	return bytes.Clone(ac.artwork)
+}
+
+// DiscTotal returns the number of discs in the album
+func (ac *AlbumContext) DiscTotal() int {
+	return ac.discTotal
+}
+
+// TrackTotal returns the number of tracks in the album
+func (ac *AlbumContext) TrackTotal() int {
+	return ac.trackTotal
+}
+
+// HasLyrics reports whether the album track songID has lyrics
+func (ac *AlbumContext) HasLyrics(songID string) bool {
+	return ac.lyrics[songID]
+}
+
+// albumContextEntry is a cached album context and when it was built
+type albumContextEntry struct {
+	context *AlbumContext
+	created time.Time
+}
+
+// albumContexts hands out album contexts so that tracks of the same album
+// downloaded in parallel trigger a single fetch
+type albumContexts struct {
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]albumContextEntry
+}
+
+// newAlbumContexts creates an empty album context cache
+func newAlbumContexts() *albumContexts {
+	return &albumContexts{entries: make(map[string]albumContextEntry)}
+}
+
+// get returns the context cached under key, calling fetch to build it when
+// there is none. Concurrent callers for the same key share one fetch
+func (c *albumContexts) get(key string, fetch func() (*AlbumContext, error)) (*AlbumContext, error) {
+	if ac := c.lookup(key); ac != nil {
+		return ac, nil
+	}
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		// A fetch for key may have finished between lookup and Do
+		if ac := c.lookup(key); ac != nil {
+			return ac, nil
+		}
+
+		ac, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.entries[key] = albumContextEntry{context: ac, created: time.Now()}
+		c.mu.Unlock()
+		return ac, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*AlbumContext), nil
+}
+
+// lookup returns the unexpired context cached under key, dropping expired ones
+func (c *albumContexts) lookup(key string) *AlbumContext {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.Sub(entry.created) > albumContextTTL {
+			delete(c.entries, k)
+		}
+	}
+	if entry, ok := c.entries[key]; ok {
+		return entry.context
+	}
+	return nil
+}
+
+// albumContext returns the shared context of the album meta belongs to, or nil
+// when the song has no album or the album could not be fetched. Tracks then
+// fall back to fetching their own artwork
+func (sd *SongDownloaderImpl) albumContext(ctx context.Context, storefront string, meta *AutoSong, token string) *AlbumContext {
+	albums := meta.Relationships.Albums.Data
+	if len(albums) == 0 || albums[0].ID == "" {
+		return nil
+	}
+	albumID := albums[0].ID
+
+	ac, err := sd.albumContexts.get(storefront+"/"+albumID, func() (*AlbumContext, error) {
+		// The album is shared by the downloads of its tracks, so one of them
+		// being cancelled must not fail the others
+		shared := context.WithoutCancel(ctx)
+		album, err := sd.GetAlbumMeta(shared, &URLMeta{Storefront: storefront, URLType: "albums", ID: albumID}, token)
+		if err != nil {
+			return nil, err
+		}
+		artwork, err := sd.fetchArtwork(shared, artworkURL(album.Attributes.Artwork, sd.artworkMaxDimension))
+		if err != nil {
+			return nil, err
+		}
+		return newAlbumContext(album, artwork), nil
+	})
+	if err != nil {
+		logger.Warn("Failed to get album", logging.String("Album", albumID), logging.Err(err))
+		return nil
+	}
+	return ac
+}
@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// albumServer serves the token page and catalog for four tracks of one album,
+// counting requests for the album and its artwork
+type albumServer struct {
+	*httptest.Server
+
+	albumHits   int32
+	artworkHits int32
+}
+
+func newAlbumServer(t *testing.T) *albumServer {
+	as := &albumServer{}
+	as.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/us/songs/"):
+			id := strings.TrimPrefix(r.URL.Path, "/v1/catalog/us/songs/")
+			fmt.Fprintf(w, `{"data":[{"id":"%s","type":"songs","attributes":{
+				"name":"Track %s","artistName":"Artist","albumName":"Album",
+				"artwork":{"width":600,"height":600,"url":"%s/song-art/{w}x{h}bb.jpg"}},
+				"relationships":{"albums":{"data":[{"id":"300","type":"albums","attributes":{"trackCount":4}}]}}}]}`, id, id, as.URL)
+		case r.URL.Path == "/v1/catalog/us/albums/300":
+			atomic.AddInt32(&as.albumHits, 1)
+			// Slow enough that every worker asks for the album while it is in flight
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprintf(w, `{"data":[{"id":"300","type":"albums","attributes":{
+				"name":"Album","artistName":"Artist","trackCount":4,
+				"artwork":{"width":1200,"height":1200,"url":"%s/art/{w}x{h}bb.jpg"}},
+				"relationships":{"tracks":{"data":[
+					{"id":"1","attributes":{"discNumber":1,"hasLyrics":true}},
+					{"id":"2","attributes":{"discNumber":1}},
+					{"id":"3","attributes":{"discNumber":2,"hasLyrics":true}},
+					{"id":"4","attributes":{"discNumber":2}}]}}}]}`, as.URL)
+		case strings.HasPrefix(r.URL.Path, "/art/"):
+			atomic.AddInt32(&as.artworkHits, 1)
+			w.Write(testArtwork)
+		case strings.HasPrefix(r.URL.Path, "/song-art/"):
+			t.Errorf("Track fetched its own artwork instead of the album's")
+			w.Write([]byte("wrong artwork"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(as.Close)
+	return as
+}
+
+func TestAlbumContext_ParallelTracksFetchOnce(t *testing.T) {
+	server := newAlbumServer(t)
+	manager := NewManager(0)
+
+	const tracks = 4
+	paths := make([]string, tracks)
+	for i := range paths {
+		paths[i] = writeBotFixture(t, retagTestMeta(fmt.Sprintf("Track %d", i+1)))
+	}
+
+	contexts := make([]*AlbumContext, tracks)
+	errs := make([]error, tracks)
+	var wg sync.WaitGroup
+	for i := 0; i < tracks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// The same steps Download runs for artwork arriving after the
+			// audio is written
+			sd := manager.NewDownloader().(*SongDownloaderImpl)
+			sd.tokenPageURL = server.URL
+			sd.apple.catalogURL = server.URL
+
+			token, err := sd.GetToken(context.Background())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			meta, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: fmt.Sprint(i + 1)}, token)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			contexts[i] = sd.albumContext(context.Background(), "us", meta, token)
+			artwork, err := sd.prepareArtwork(context.Background(), meta, contexts[i])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = sd.addArtwork(paths[i], meta, contexts[i], artwork)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		t.Fatalf("Track pipeline failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&server.albumHits); n != 1 {
+		t.Errorf("Album endpoint hit %d times, want 1", n)
+	}
+	if n := atomic.LoadInt32(&server.artworkHits); n != 1 {
+		t.Errorf("Artwork endpoint hit %d times, want 1", n)
+	}
+
+	for i, ac := range contexts {
+		if ac != contexts[0] {
+			t.Errorf("Track %d got a different album context", i+1)
+		}
+		raw, _ := os.ReadFile(paths[i])
+		if !bytes.Contains(raw, testArtwork) {
+			t.Errorf("Track %d is missing the album artwork", i+1)
+		}
+		if err := validateOutputFile(paths[i]); err != nil {
+			t.Errorf("Track %d failed validation: %v", i+1, err)
+		}
+	}
+
+	ac := contexts[0]
+	if ac.Album().Attributes.Name != "Album" || ac.TrackTotal() != 4 || ac.DiscTotal() != 2 {
+		t.Errorf("Album context = %q, %d tracks, %d discs", ac.Album().Attributes.Name, ac.TrackTotal(), ac.DiscTotal())
+	}
+	if !ac.HasLyrics("3") || ac.HasLyrics("4") {
+		t.Error("Expected lyrics availability to follow the album tracks")
+	}
+
+	// Callers get a copy, so the shared artwork cannot be changed through it
+	ac.Artwork()[0] = 0
+	if !bytes.Equal(ac.Artwork(), testArtwork) {
+		t.Error("Expected the shared artwork to be unchanged")
+	}
+}
+
+func TestAlbumContexts_FailedFetchIsRetried(t *testing.T) {
+	contexts := newAlbumContexts()
+	fetches := 0
+	fetch := func() (*AlbumContext, error) {
+		fetches++
+		if fetches == 1 {
+			return nil, errors.New("catalog unavailable")
+		}
+		return newAlbumContext(&AutoAlbum{ID: "300"}, testArtwork), nil
+	}
+
+	if _, err := contexts.get("us/300", fetch); err == nil {
+		t.Fatal("Expected the first fetch to fail")
+	}
+	if ac, err := contexts.get("us/300", fetch); err != nil || ac.Album().ID != "300" {
+		t.Fatalf("Expected the second fetch to succeed, got %v", err)
+	}
+	if _, err := contexts.get("us/300", fetch); err != nil || fetches != 2 {
+		t.Errorf("Expected the cached context to be reused, fetched %d times", fetches)
+	}
+}
@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAlbumTracksServer serves a token page and album 2 with two songs without ALAC
+// and a music video
+func newAlbumTracksServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case r.URL.Path == "/v1/catalog/us/albums/2":
+			fmt.Fprint(w, `{"data":[{"id":"2","type":"albums","attributes":{"name":"Album","artistName":"Artist"},"relationships":{"tracks":{"data":[
+				{"id":"11","type":"songs","attributes":{"name":"One"}},
+				{"id":"12","type":"music-videos","attributes":{"name":"Video"}},
+				{"id":"13","type":"songs","attributes":{"name":"Three"}}]}}}]}`)
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/us/songs/"):
+			id := strings.TrimPrefix(r.URL.Path, "/v1/catalog/us/songs/")
+			fmt.Fprintf(w, `{"data":[{"id":"%s","type":"songs","attributes":{"name":"Song %s","extendedAssetUrls":{}}}]}`, id, id)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadAlbum_SkipsTracksWithoutALAC(t *testing.T) {
+	server := newAlbumTracksServer(t)
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+
+	var progress []TrackListProgress
+	callbacks := ProgressCallbacks{OnTrackListProgress: func(p TrackListProgress) { progress = append(progress, p) }}
+
+	result, err := sd.DownloadAlbum(context.Background(), "https://music.apple.com/us/album/album/2", callbacks)
+	if err != nil {
+		t.Fatalf("DownloadAlbum() error = %v", err)
+	}
+	if result.Name != "Album" || result.Artist != "Artist" || len(result.Tracks) != 0 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	wantFailed := []FailedTrack{
+		{SongID: "11", Title: "One", Reason: "ALAC not available"},
+		{SongID: "12", Title: "Video", Reason: "not a song"},
+		{SongID: "13", Title: "Three", Reason: "ALAC not available"},
+	}
+	if len(result.Failed) != len(wantFailed) {
+		t.Fatalf("Expected %d failed tracks, got %+v", len(wantFailed), result.Failed)
+	}
+	for i, want := range wantFailed {
+		got := result.Failed[i]
+		if got.SongID != want.SongID || got.Title != want.Title || got.Reason != want.Reason {
+			t.Errorf("Failed[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// One report before each track and one when done
+	if len(progress) != 4 {
+		t.Fatalf("Expected 4 progress reports, got %+v", progress)
+	}
+	if p := progress[2]; p.Track != 3 || p.TrackCount != 3 || p.Title != "Three" || p.Failed != 2 {
+		t.Errorf("Unexpected progress before the third track: %+v", p)
+	}
+	if p := progress[3]; p.Track != 0 || p.Failed != 3 {
+		t.Errorf("Unexpected final progress: %+v", p)
+	}
+}
+
+func TestDownloadAlbum_StopsWhenCancelled(t *testing.T) {
+	server := newAlbumTracksServer(t)
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	callbacks := ProgressCallbacks{OnTrackListProgress: func(p TrackListProgress) {
+		if p.Track == 2 {
+			cancel()
+		}
+	}}
+
+	result, err := sd.DownloadAlbum(ctx, "https://music.apple.com/us/album/album/2", callbacks)
+	if !IsDownloadError(err, ErrorCancelled) {
+		t.Fatalf("Expected a cancellation error, got %v", err)
+	}
+	// The second track was already under way, the third never started
+	if result == nil || len(result.Failed) != 2 {
+		t.Fatalf("Expected the first two tracks in the partial result, got %+v", result)
+	}
+}
+
+func TestDownloadAlbum_RejectsSongURLs(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	_, err := sd.DownloadAlbum(context.Background(), "https://music.apple.com/us/song/song/1", ProgressCallbacks{})
+	if !IsDownloadError(err, ErrorInvalidURL) {
+		t.Errorf("Expected an invalid URL error, got %v", err)
+	}
+}
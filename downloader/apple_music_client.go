@@ -0,0 +1,241 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// appleMusicUserAgent and appleMusicOrigin make requests look like the
+	// web player's
+	appleMusicUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+	appleMusicOrigin    = "https://music.apple.com"
+
+	// Bounds of the default HTTP client. Bodies are not bounded, since media
+	// downloads take minutes; requests end with their context instead
+	appleMusicDialTimeout     = 10 * time.Second
+	appleMusicTLSTimeout      = 10 * time.Second
+	appleMusicResponseTimeout = 30 * time.Second
+)
+
+// AppleMusicClient sends the HTTP requests of downloads: catalog lookups,
+// playlists, artwork and media. It holds the HTTP client, which can be
+// replaced with one going through a proxy or a test server, the headers the
+// catalog API expects and the bearer tokens cached for it
+type AppleMusicClient struct {
+	client     *http.Client
+	catalogURL string
+	tokens     *TokenCache
+
+	// Timings of the calls (nil = not recorded) and catalog responses whose
+	// critical fields went missing
+	latencies *LatencyTracker
+	schema    *SchemaMonitor
+}
+
+// NewAppleMusicClient creates a client sending its requests with client, or
+// with NewAppleMusicHTTPClient when client is nil
+func NewAppleMusicClient(client *http.Client) *AppleMusicClient {
+	if client == nil {
+		client = NewAppleMusicHTTPClient()
+	}
+	return &AppleMusicClient{
+		client:     client,
+		catalogURL: defaultCatalogURL,
+		tokens:     NewTokenCache(),
+		latencies:  NewLatencyTracker(),
+		schema:     NewSchemaMonitor(),
+	}
+}
+
+// NewAppleMusicHTTPClient creates the default HTTP client of an
+// AppleMusicClient, which gives up on servers that do not connect or answer
+// in time
+func NewAppleMusicHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: appleMusicDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = appleMusicTLSTimeout
+	transport.ResponseHeaderTimeout = appleMusicResponseTimeout
+	return &http.Client{Transport: transport}
+}
+
+// SetHTTPClient makes the client send its requests with client. It must be
+// called before any request is sent
+func (c *AppleMusicClient) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// httpClient returns the HTTP client bounded by timeout (0 = the client's own),
+// recording its calls as calls to dep
+func (c *AppleMusicClient) httpClient(dep Dependency, timeout time.Duration) *http.Client {
+	client := *c.client
+	if timeout > 0 {
+		client.Timeout = timeout
+	}
+	if c.latencies != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &latencyTransport{base: base, dep: dep, tracker: c.latencies}
+	}
+	return &client
+}
+
+// newCatalogRequest creates a request for the catalog endpoint at path,
+// authorized with token
+func (c *AppleMusicClient) newCatalogRequest(ctx context.Context, path string, query url.Values, token string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.catalogURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("User-Agent", appleMusicUserAgent)
+	req.Header.Set("Origin", appleMusicOrigin)
+	req.URL.RawQuery = query.Encode()
+	return req, nil
+}
+
+// GetCatalog fetches the catalog endpoint at path and decodes the JSON
+// response into v
+func (c *AppleMusicClient) GetCatalog(ctx context.Context, path string, query url.Values, token string, v any) error {
+	req, err := c.newCatalogRequest(ctx, path, query, token)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient(DepCatalogAPI, 0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// GetSongMeta fetches the metadata of a song, or of the song of an album
+// link, in language (empty = the storefront's). Songs the storefront does
+// not have fail with ErrNotInStorefront
+func (c *AppleMusicClient) GetSongMeta(ctx context.Context, urlMeta *URLMeta, token, language string) (*AutoSong, error) {
+	query := url.Values{}
+	query.Set("include", "albums,explicit")
+	query.Set("extend", "extendedAssetUrls")
+	query.Set("l", language)
+
+	req, err := c.newCatalogRequest(ctx, fmt.Sprintf("/v1/catalog/%s/%s/%s", urlMeta.Storefront, urlMeta.URLType, urlMeta.ID), query, token)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient(DepCatalogAPI, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Region restricted songs are not found
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotInStorefront
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrTokenRejected
+	}
+	if resp.StatusCode == http.StatusBadRequest && language != "" {
+		return nil, ErrLanguageUnavailable
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	// Keep the raw bytes to catch fields Apple moved or renamed
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var songResponse SongResponse
+	if err := json.Unmarshal(raw, &songResponse); err != nil {
+		return nil, err
+	}
+	c.schema.CheckSongs(req.URL.Path, raw, songResponse.Data)
+
+	for _, d := range songResponse.Data {
+		if d.ID == urlMeta.ID {
+			return &d, nil
+		}
+	}
+
+	return nil, ErrNotInStorefront
+}
+
+// GetAlbumMeta fetches the metadata of an album and its tracks in language
+// (empty = the storefront's)
+func (c *AppleMusicClient) GetAlbumMeta(ctx context.Context, urlMeta *URLMeta, token, language string) (*AutoAlbum, error) {
+	query := url.Values{}
+	query.Set("include", "tracks")
+	query.Set("l", language)
+
+	var albumResponse AlbumResponse
+	if err := c.GetCatalog(ctx, fmt.Sprintf("/v1/catalog/%s/albums/%s", urlMeta.Storefront, urlMeta.ID), query, token, &albumResponse); err != nil {
+		return nil, err
+	}
+
+	for _, d := range albumResponse.Data {
+		if d.ID == urlMeta.ID {
+			return &d, nil
+		}
+	}
+
+	return nil, errors.New("album not found in response")
+}
+
+// GetMasterPlaylist fetches the master playlist at playlistURL, returning it
+// as text since the keys are read from it as well
+func (c *AppleMusicClient) GetMasterPlaylist(ctx context.Context, playlistURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, playlistURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient(DepMasterPlaylist, 0).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// GetArtwork downloads the artwork image at imageURL
+func (c *AppleMusicClient) GetArtwork(ctx context.Context, imageURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient(DepMediaCDN, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("artwork request failed: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the requests sent through it
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.requests = append(rt.requests, req)
+	rt.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (rt *recordingTransport) sent() []*http.Request {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]*http.Request(nil), rt.requests...)
+}
+
+func TestAppleMusicClient_InjectedHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/us/songs/1":
+			fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","artistName":"Artist"}}]}`)
+		case "/v1/catalog/us/albums/2":
+			fmt.Fprint(w, `{"data":[{"id":"2","type":"albums","attributes":{"name":"Album"}}]}`)
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n")
+		case "/art.jpg":
+			w.Write(testArtwork)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	transport := &recordingTransport{}
+	client := NewAppleMusicClient(&http.Client{Transport: transport})
+	client.catalogURL = server.URL
+	ctx := context.Background()
+
+	sd := NewSongDownloaderWithClient(client).(*SongDownloaderImpl)
+	song, err := sd.GetSongMeta(ctx, &URLMeta{Storefront: "us", URLType: "songs", ID: "1"}, testToken)
+	if err != nil || song.Attributes.Name != "Song" {
+		t.Fatalf("GetSongMeta() = %+v, %v", song, err)
+	}
+	album, err := client.GetAlbumMeta(ctx, &URLMeta{Storefront: "us", URLType: "albums", ID: "2"}, testToken, "")
+	if err != nil || album.Attributes.Name != "Album" {
+		t.Fatalf("GetAlbumMeta() = %+v, %v", album, err)
+	}
+	if playlist, err := client.GetMasterPlaylist(ctx, server.URL+"/master.m3u8"); err != nil || playlist != "#EXTM3U\n" {
+		t.Fatalf("GetMasterPlaylist() = %q, %v", playlist, err)
+	}
+	if artwork, err := client.GetArtwork(ctx, server.URL+"/art.jpg"); err != nil || string(artwork) != string(testArtwork) {
+		t.Fatalf("GetArtwork() = %q, %v", artwork, err)
+	}
+	if _, err := client.GetArtwork(ctx, server.URL+"/missing.jpg"); err == nil {
+		t.Error("Expected missing artwork to fail")
+	}
+
+	requests := transport.sent()
+	if len(requests) != 5 {
+		t.Fatalf("Expected every request to go through the injected client, got %d", len(requests))
+	}
+	for _, req := range requests[:2] {
+		if got := req.Header.Get("Authorization"); got != "Bearer "+testToken {
+			t.Errorf("%s: Authorization = %q", req.URL.Path, got)
+		}
+		if req.Header.Get("User-Agent") != appleMusicUserAgent || req.Header.Get("Origin") != appleMusicOrigin {
+			t.Errorf("%s: missing the web player headers: %v", req.URL.Path, req.Header)
+		}
+	}
+	if stats := client.latencies.Stats(); len(stats) == 0 {
+		t.Error("Expected the calls to be timed")
+	}
+}
+
+func TestAppleMusicClient_HonorsContext(t *testing.T) {
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := NewAppleMusicClient(nil)
+	client.catalogURL = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetSongMeta(ctx, &URLMeta{Storefront: "us", URLType: "songs", ID: "1"}, testToken, "")
+		done <- err
+	}()
+
+	<-started
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the request to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Request did not end with its context")
+	}
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// DefaultArtworkMaxDimension caps the longer side of the artwork embedded
+	// in songs, instead of the often 3000 pixel original
+	DefaultArtworkMaxDimension = 1400
+
+	// DefaultArtworkMaxBytes caps the size of the artwork embedded in songs;
+	// larger artwork is re-encoded, and scaled down when needed, to fit
+	DefaultArtworkMaxBytes = 1 << 20
+
+	// artworkTimeout bounds fetching one artwork image
+	artworkTimeout = 30 * time.Second
+
+	// artworkQuality is the JPEG quality of re-encoded artwork
+	artworkQuality = 90
+
+	// minArtworkDimension is the smallest longer side artwork is scaled down
+	// to while making it fit the size cap
+	minArtworkDimension = 300
+)
+
+// iccProfileID starts the APP2 segments of a JPEG holding its ICC profile
+var iccProfileID = []byte("ICC_PROFILE\x00")
+
+// embeddedArtwork is the artwork embedded in a song, with its dimensions
+type embeddedArtwork struct {
+	data          []byte
+	width, height int
+}
+
+// fetchArtwork downloads an artwork image, giving up after artworkTimeout or
+// once ctx ends
+func (sd *SongDownloaderImpl) fetchArtwork(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, artworkTimeout)
+	defer cancel()
+
+	return sd.apple.GetArtwork(ctx, url)
+}
+
+// fitArtwork returns cover with its dimensions, re-encoded as JPEG when it is
+// larger than maxBytes (0 = no limit). Each encoding that is still too large
+// is scaled down by a quarter, stopping at minArtworkDimension. The ICC
+// profile of a JPEG cover is kept, so Display P3 artwork is not shown with
+// washed-out sRGB colours
+func fitArtwork(cover []byte, maxBytes int64) (embeddedArtwork, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(cover))
+	if err != nil {
+		return embeddedArtwork{}, fmt.Errorf("failed to decode artwork: %w", err)
+	}
+	if maxBytes <= 0 || int64(len(cover)) <= maxBytes {
+		return embeddedArtwork{data: cover, width: config.Width, height: config.Height}, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(cover))
+	if err != nil {
+		return embeddedArtwork{}, fmt.Errorf("failed to decode artwork: %w", err)
+	}
+	profile := iccSegments(cover)
+
+	width, height := config.Width, config.Height
+	for {
+		var img image.Image = src
+		if width != config.Width || height != config.Height {
+			img = scaleDown(src, width, height)
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: artworkQuality}); err != nil {
+			return embeddedArtwork{}, fmt.Errorf("failed to encode artwork: %w", err)
+		}
+		data := withICCSegments(buf.Bytes(), profile)
+
+		if int64(len(data)) <= maxBytes || max(width, height)*3/4 < minArtworkDimension {
+			return embeddedArtwork{data: data, width: width, height: height}, nil
+		}
+		width, height = max(1, width*3/4), max(1, height*3/4)
+	}
+}
+
+// iccSegments returns the APP2 segments holding the ICC profile of a JPEG,
+// markers included, nil when it has none or is not a JPEG
+func iccSegments(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	var segments []byte
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of the image data or its end, after every header
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		if marker == 0xE2 && bytes.HasPrefix(data[pos+4:end], iccProfileID) {
+			segments = append(segments, data[pos:end]...)
+		}
+		pos = end
+	}
+	return segments
+}
+
+// withICCSegments inserts segments right after the start of image marker of
+// a JPEG
+func withICCSegments(jpegData, segments []byte) []byte {
+	if len(segments) == 0 || len(jpegData) < 2 {
+		return jpegData
+	}
+	out := make([]byte, 0, len(jpegData)+len(segments))
+	out = append(out, jpegData[:2]...)
+	out = append(out, segments...)
+	return append(out, jpegData[2:]...)
+}
+
+// prepareArtwork returns the artwork to embed in the file of meta, taking it
+// from album when the album's artwork was already fetched. The artwork is
+// fetched at most artworkMaxDimension a side and re-encoded to fit in
+// artworkMaxBytes
+func (sd *SongDownloaderImpl) prepareArtwork(ctx context.Context, meta *AutoSong, album *AlbumContext) (embeddedArtwork, error) {
+	var original []byte
+	if album != nil {
+		original = album.Artwork()
+	} else {
+		var err error
+		original, err = sd.fetchArtwork(ctx, artworkURL(meta.Attributes.Artwork, sd.artworkMaxDimension))
+		if err != nil {
+			return embeddedArtwork{}, err
+		}
+	}
+
+	cover, err := fitArtwork(original, sd.artworkMaxBytes)
+	if err != nil {
+		// Embed the artwork as fetched rather than none
+		logger.Warn("Failed to fit artwork to the size limit", logging.Err(err))
+		cover = embeddedArtwork{data: original}
+	}
+	return cover, nil
+}
+
+// pendingArtwork is the album context and artwork of a download, fetched while
+// its audio is downloaded and decrypted
+type pendingArtwork struct {
+	done  chan struct{}
+	album *AlbumContext
+	cover embeddedArtwork
+	err   error
+}
+
+// startArtwork starts fetching the album context and artwork of meta
+func (sd *SongDownloaderImpl) startArtwork(ctx context.Context, storefront string, meta *AutoSong, token string) *pendingArtwork {
+	pending := &pendingArtwork{done: make(chan struct{})}
+	go func() {
+		defer close(pending.done)
+		pending.album = sd.albumContext(ctx, storefront, meta, token)
+		pending.cover, pending.err = sd.prepareArtwork(ctx, meta, pending.album)
+	}()
+	return pending
+}
+
+// ready reports whether the fetch has finished, without waiting for it
+func (p *pendingArtwork) ready() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the fetch to finish
+func (p *pendingArtwork) wait() {
+	<-p.done
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// noisyJPEG returns a JPEG of random pixels, which compresses poorly
+func noisyJPEG(t *testing.T, width, height int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode artwork: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// iccSegment returns an APP2 segment holding profile
+func iccSegment(profile []byte) []byte {
+	payload := append(append([]byte{}, iccProfileID...), 1, 1)
+	payload = append(payload, profile...)
+
+	segment := []byte{0xFF, 0xE2, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestFitArtwork_WithinLimit(t *testing.T) {
+	cover := testImage(t, 40, 30)
+
+	artwork, err := fitArtwork(cover, int64(len(cover)))
+	if err != nil {
+		t.Fatalf("fitArtwork() error = %v", err)
+	}
+	if !bytes.Equal(artwork.data, cover) {
+		t.Error("Expected artwork within the limit to be kept as is")
+	}
+	if artwork.width != 40 || artwork.height != 30 {
+		t.Errorf("Expected 40x30, got %dx%d", artwork.width, artwork.height)
+	}
+}
+
+func TestFitArtwork_OverLimit(t *testing.T) {
+	cover := noisyJPEG(t, 800, 800)
+	const maxBytes = 100 << 10
+
+	artwork, err := fitArtwork(cover, maxBytes)
+	if err != nil {
+		t.Fatalf("fitArtwork() error = %v", err)
+	}
+	if len(artwork.data) > maxBytes {
+		t.Errorf("Expected at most %d bytes, got %d", maxBytes, len(artwork.data))
+	}
+	if artwork.width >= 800 || artwork.width != artwork.height {
+		t.Errorf("Expected smaller square artwork, got %dx%d", artwork.width, artwork.height)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(artwork.data))
+	if err != nil {
+		t.Fatalf("Failed to decode fitted artwork: %v", err)
+	}
+	if format != "jpeg" || config.Width != artwork.width || config.Height != artwork.height {
+		t.Errorf("Expected a %dx%d JPEG, got a %dx%d %s", artwork.width, artwork.height, config.Width, config.Height, format)
+	}
+}
+
+func TestFitArtwork_KeepsICCProfile(t *testing.T) {
+	segment := iccSegment([]byte("Display P3"))
+	cover := noisyJPEG(t, 400, 400)
+	cover = withICCSegments(cover, segment)
+
+	if got := iccSegments(cover); !bytes.Equal(got, segment) {
+		t.Fatalf("iccSegments() = %q, want %q", got, segment)
+	}
+
+	artwork, err := fitArtwork(cover, int64(len(cover))/2)
+	if err != nil {
+		t.Fatalf("fitArtwork() error = %v", err)
+	}
+	if bytes.Equal(artwork.data, cover) {
+		t.Fatal("Expected artwork over the limit to be re-encoded")
+	}
+	if got := iccSegments(artwork.data); !bytes.Equal(got, segment) {
+		t.Errorf("Expected the ICC profile to be kept, got %q", got)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(artwork.data)); err != nil {
+		t.Errorf("Failed to decode fitted artwork: %v", err)
+	}
+}
+
+func TestFitArtwork_NotAnImage(t *testing.T) {
+	if _, err := fitArtwork([]byte("not an image"), 0); err == nil {
+		t.Error("Expected an error for data that is not an image")
+	}
+}
+
+func TestFetchArtwork_Cancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testImage(t, 10, 10))
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sd.fetchArtwork(ctx, server.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the fetch to be cancelled, got %v", err)
+	}
+}
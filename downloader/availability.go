@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+)
+
+// ALACStorefronts returns the storefronts among candidates, in their order,
+// where the song linked by songURL has an ALAC stream. Storefronts whose
+// catalog lookup fails are left out
+func (sd *SongDownloaderImpl) ALACStorefronts(ctx context.Context, songURL string, candidates []string) ([]string, error) {
+	urlMeta, err := sd.ExtractUrlMeta(songURL)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorInvalidURL, "failed to extract URL metadata", err)
+	}
+	if urlMeta.URLType != "songs" {
+		return nil, NewDownloadError(ErrorInvalidURL, fmt.Sprintf("cannot check %s for ALAC", urlMeta.URLType))
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var available []string
+	for _, storefront := range candidates {
+		meta, err := sd.GetSongMeta(ctx, &URLMeta{Storefront: storefront, URLType: urlMeta.URLType, ID: urlMeta.ID}, token)
+		if err != nil {
+			continue
+		}
+		if meta.Attributes.ExtendedAssetUrls["enhancedHls"] != "" {
+			available = append(available, storefront)
+		}
+	}
+	return available, nil
+}
@@ -0,0 +1,44 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestALACStorefronts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case r.URL.Path == "/v1/catalog/us/songs/1", r.URL.Path == "/v1/catalog/jp/songs/1":
+			fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","extendedAssetUrls":{"enhancedHls":"https://example.com/a.m3u8"}}}]}`)
+		case r.URL.Path == "/v1/catalog/gb/songs/1":
+			fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","extendedAssetUrls":{}}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+
+	got, err := sd.ALACStorefronts(context.Background(), "https://music.apple.com/in/song/song/1", []string{"us", "gb", "de", "jp"})
+	if err != nil {
+		t.Fatalf("ALACStorefronts() error = %v", err)
+	}
+	if want := []string{"us", "jp"}; !slices.Equal(got, want) {
+		t.Errorf("ALACStorefronts() = %v, want %v", got, want)
+	}
+
+	if _, err := sd.ALACStorefronts(context.Background(), "https://music.apple.com/in/album/album/2", []string{"us"}); err == nil {
+		t.Error("Expected albums to be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// minBandwidthBurst is the smallest burst a limiter allows, so slow limits
+// still pass reads of a reasonable size
+const minBandwidthBurst = 32 << 10
+
+// BandwidthLimiter is a token bucket of bytes shared by every transfer it
+// paces, so concurrent jobs split the rate between them. A nil limiter is
+// unlimited
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter creates a limiter of bytesPerSecond. Zero or less
+// returns nil, which does not limit
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := max(bytesPerSecond/10, minBandwidthBurst)
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the limit in bytes per second (zero when unlimited)
+func (l *BandwidthLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done. The bytes
+// are reserved up front, so transfers waiting together are served in the
+// order they asked
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader returns r paced by the limiter. Reads are capped at the burst size
+// so a single large read cannot run far ahead of the rate
+func (l *BandwidthLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, reader: r, limiter: l}
+}
+
+// limitedReader waits for the bytes of every read it passes on
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *BandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.limiter.burst {
+		p = p[:r.limiter.burst]
+	}
+	n, err := r.reader.Read(p)
+	if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transfer copies size bytes through l and returns how long it took
+func transfer(t *testing.T, l *BandwidthLimiter, size int) time.Duration {
+	t.Helper()
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(make([]byte, size))))
+	if err != nil || n != int64(size) {
+		t.Errorf("Copied %d bytes with error %v, want %d bytes", n, err, size)
+	}
+	return time.Since(start)
+}
+
+func TestBandwidthLimiter_PacesTransfer(t *testing.T) {
+	const rate = 20 << 20 // 10 MB is half a second of transfer
+	l := NewBandwidthLimiter(rate)
+
+	elapsed := transfer(t, l, 10<<20)
+	// The initial burst is free, so the rest needs (10 - 2) MB / 20 MB/s
+	if want := 350 * time.Millisecond; elapsed < want {
+		t.Errorf("10 MB at 20 MB/s took %v, want at least %v", elapsed, want)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("10 MB at 20 MB/s took %v, far longer than the rate allows", elapsed)
+	}
+}
+
+func TestBandwidthLimiter_SharedByConcurrentTransfers(t *testing.T) {
+	const rate = 20 << 20
+	l := NewBandwidthLimiter(rate)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	durations := make([]time.Duration, 2)
+	for i := range durations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			durations[i] = transfer(t, l, 5<<20)
+		}()
+	}
+	wg.Wait()
+
+	// Together the two transfers move 10 MB through one 20 MB/s budget, so
+	// neither finishes in the 150ms it would need alone
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Two 5 MB transfers took %v together, want at least 350ms", elapsed)
+	}
+	for i, d := range durations {
+		if d < 250*time.Millisecond {
+			t.Errorf("Transfer %d took %v, want it slowed by the other one", i, d)
+		}
+	}
+}
+
+func TestBandwidthLimiter_Unlimited(t *testing.T) {
+	l := NewBandwidthLimiter(0)
+	if l != nil {
+		t.Fatalf("NewBandwidthLimiter(0) = %+v, want nil", l)
+	}
+	if l.Rate() != 0 {
+		t.Errorf("Rate() = %d, want 0", l.Rate())
+	}
+	if elapsed := transfer(t, l, 10<<20); elapsed > time.Second {
+		t.Errorf("Unlimited transfer took %v", elapsed)
+	}
+}
+
+func TestBandwidthLimiter_WaitCanceled(t *testing.T) {
+	l := NewBandwidthLimiter(minBandwidthBurst)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The first burst is free, the next one has to wait and sees the cancel
+	if err := l.WaitN(ctx, minBandwidthBurst); err != nil {
+		t.Fatalf("WaitN() within the burst = %v, want nil", err)
+	}
+	if err := l.WaitN(ctx, minBandwidthBurst); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitN() = %v, want context.Canceled", err)
+	}
+}
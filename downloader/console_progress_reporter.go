@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleBarWidth is the number of characters of the console progress bar
+const consoleBarWidth = 30
+
+// ConsoleProgressReporter implements ProgressReporter with a progress bar
+// redrawn on a single line of w, for running the downloader from a terminal.
+// The library itself never writes to stdout
+type ConsoleProgressReporter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	songName  string
+	startTime time.Time
+	isActive  bool
+	lineLen   int // Length of the bar line being redrawn, 0 when there is none
+}
+
+// NewConsoleProgressReporter creates a ConsoleProgressReporter writing to w
+func NewConsoleProgressReporter(w io.Writer) *ConsoleProgressReporter {
+	return &ConsoleProgressReporter{w: w}
+}
+
+// StartTracking prints the song name; chatID is ignored
+func (cpr *ConsoleProgressReporter) StartTracking(ctx context.Context, chatID int64, songName string) error {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if cpr.isActive {
+		return NewDownloadError(ErrorUnknown, "progress tracking is already active")
+	}
+
+	cpr.songName = songName
+	cpr.startTime = time.Now()
+	cpr.isActive = true
+	cpr.lineLen = 0
+
+	_, err := fmt.Fprintf(cpr.w, "%s\n", songName)
+	return err
+}
+
+// UpdateProgress redraws the progress bar of the current phase
+func (cpr *ConsoleProgressReporter) UpdateProgress(phase Phase, progress Progress) error {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if !cpr.isActive {
+		return nil
+	}
+	return cpr.drawLocked(formatConsoleProgress(phase, progress))
+}
+
+// ReportPhaseChange keeps the bar of the finished phase and starts a new line
+func (cpr *ConsoleProgressReporter) ReportPhaseChange(oldPhase, newPhase Phase) error {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if !cpr.isActive {
+		return nil
+	}
+	if err := cpr.endLineLocked(); err != nil {
+		return err
+	}
+	return cpr.drawLocked(newPhase.String() + "...")
+}
+
+// ReportError prints the error below the bar
+func (cpr *ConsoleProgressReporter) ReportError(err error) error {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if !cpr.isActive {
+		return nil
+	}
+
+	errorMsg := "An error occurred"
+	if downloadErr, ok := AsDownloadError(err); ok {
+		errorMsg = downloadErr.Message
+	} else if err != nil {
+		errorMsg = err.Error()
+	}
+
+	if err := cpr.endLineLocked(); err != nil {
+		return err
+	}
+	_, writeErr := fmt.Fprintf(cpr.w, "Error: %s (after %s)\n", errorMsg, FormatDuration(Elapsed(cpr.startTime, time.Now())))
+	return writeErr
+}
+
+// ReportComplete prints the total time and the file written
+func (cpr *ConsoleProgressReporter) ReportComplete(duration time.Duration, filePath string) error {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if !cpr.isActive {
+		return nil
+	}
+	if err := cpr.endLineLocked(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(cpr.w, "Complete in %s: %s\n", duration.Round(time.Second), filePath)
+	return err
+}
+
+// UpdateSongName prints the song name on a line of its own once it is known.
+// Empty and unchanged names are ignored
+func (cpr *ConsoleProgressReporter) UpdateSongName(name string) {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	if !cpr.isActive || name == "" || name == cpr.songName {
+		return
+	}
+	cpr.songName = name
+	if cpr.endLineLocked() == nil {
+		fmt.Fprintf(cpr.w, "%s\n", name)
+	}
+}
+
+// Stop ends the bar line and stops tracking
+func (cpr *ConsoleProgressReporter) Stop() {
+	cpr.mu.Lock()
+	defer cpr.mu.Unlock()
+
+	cpr.endLineLocked()
+	cpr.isActive = false
+	cpr.songName = ""
+}
+
+// drawLocked replaces the bar line with line (must be called with lock held)
+func (cpr *ConsoleProgressReporter) drawLocked(line string) error {
+	// Pad with spaces to clear what is left of a longer previous line
+	padding := ""
+	if n := cpr.lineLen - len(line); n > 0 {
+		padding = strings.Repeat(" ", n)
+	}
+	cpr.lineLen = len(line)
+
+	_, err := fmt.Fprintf(cpr.w, "\r%s%s", line, padding)
+	return err
+}
+
+// endLineLocked moves past the bar line, if one is drawn (must be called
+// with lock held)
+func (cpr *ConsoleProgressReporter) endLineLocked() error {
+	if cpr.lineLen == 0 {
+		return nil
+	}
+	cpr.lineLen = 0
+
+	_, err := io.WriteString(cpr.w, "\n")
+	return err
+}
+
+// formatConsoleProgress formats one line of progress, with a bar when the
+// total size is known
+func formatConsoleProgress(phase Phase, progress Progress) string {
+	var builder strings.Builder
+	builder.WriteString(phase.String())
+
+	if progress.TotalBytes > 0 {
+		filled := int(progress.Percentage / 100 * consoleBarWidth)
+		filled = max(0, min(filled, consoleBarWidth))
+		fmt.Fprintf(&builder, " [%s%s] %5.1f%% %s / %s",
+			strings.Repeat("=", filled),
+			strings.Repeat(" ", consoleBarWidth-filled),
+			progress.Percentage,
+			FormatBytes(progress.BytesProcessed),
+			FormatBytes(progress.TotalBytes))
+	} else {
+		fmt.Fprintf(&builder, " %s", FormatBytes(progress.BytesProcessed))
+	}
+
+	if progress.Speed > 0 {
+		fmt.Fprintf(&builder, " %s/s", FormatBytes(progress.Speed))
+		if progress.ETA > 0 {
+			fmt.Fprintf(&builder, " ETA %s", FormatDuration(progress.ETA))
+		}
+	}
+
+	return builder.String()
+}
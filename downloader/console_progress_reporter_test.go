@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleProgressReporter(t *testing.T) {
+	var out bytes.Buffer
+	reporter := NewConsoleProgressReporter(&out)
+
+	if err := reporter.StartTracking(context.Background(), 0, "Test Song"); err != nil {
+		t.Fatalf("StartTracking() error = %v", err)
+	}
+	reporter.ReportPhaseChange(-1, PhaseDownloading)
+	reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 512, TotalBytes: 1024, Percentage: 50, Speed: 256, ETA: 2 * time.Second})
+	reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 1024, TotalBytes: 1024, Percentage: 100})
+	reporter.ReportComplete(3*time.Second, "/tmp/song.m4a")
+	reporter.Stop()
+
+	lines := strings.Split(out.String(), "\n")
+	if lines[0] != "Test Song" {
+		t.Errorf("Expected the song name first, got %q", lines[0])
+	}
+
+	// The bar is redrawn in place, each draw clearing the longer one before
+	draws := strings.Split(lines[1], "\r")
+	if len(draws) != 4 {
+		t.Fatalf("Expected 3 draws of the bar line, got %q", lines[1])
+	}
+	if want := "downloading [===============               ]  50.0% 512 B / 1.0 KB 256 B/s ETA "; !strings.HasPrefix(draws[2], want) {
+		t.Errorf("Unexpected bar %q, want prefix %q", draws[2], want)
+	}
+	if len(draws[3]) != len(draws[2]) {
+		t.Errorf("Expected the shorter line to be padded to %d characters, got %d", len(draws[2]), len(draws[3]))
+	}
+	if lines[2] != "Complete in 3s: /tmp/song.m4a" {
+		t.Errorf("Unexpected completion line %q", lines[2])
+	}
+}
+
+func TestConsoleProgressReporter_UpdateSongName(t *testing.T) {
+	var out bytes.Buffer
+	reporter := NewConsoleProgressReporter(&out)
+
+	reporter.StartTracking(context.Background(), 0, "Unknown Song")
+	reporter.UpdateProgress(PhaseValidating, Progress{})
+	reporter.UpdateSongName("Song - Artist")
+	reporter.UpdateSongName("Song - Artist")
+	reporter.UpdateSongName("")
+
+	if got := strings.Split(out.String(), "\n"); len(got) != 4 || got[2] != "Song - Artist" || got[3] != "" {
+		t.Errorf("Expected the name once below the bar line, got %q", out.String())
+	}
+}
+
+func TestConsoleProgressReporter_ErrorAndUnknownSize(t *testing.T) {
+	var out bytes.Buffer
+	reporter := NewConsoleProgressReporter(&out)
+
+	reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 100})
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing before StartTracking, got %q", out.String())
+	}
+
+	reporter.StartTracking(context.Background(), 0, "Test Song")
+	reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 2048, Speed: 1024})
+	reporter.ReportError(errors.New("connection reset"))
+
+	output := out.String()
+	if !strings.Contains(output, "\rdownloading 2.0 KB 1.0 KB/s\n") {
+		t.Errorf("Expected bytes and speed without a bar, got %q", output)
+	}
+	if !strings.Contains(output, "Error: connection reset") {
+		t.Errorf("Expected the error, got %q", output)
+	}
+}
@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// CoverMaxDimension caps the longer side of the artwork fetched for covers
+	CoverMaxDimension = 3000
+
+	// PreviewMaxDimension caps the longer side of the artwork of song previews
+	PreviewMaxDimension = 300
+)
+
+// CoverResult is the metadata and artwork of a song or album, fetched without
+// downloading any audio
+type CoverResult struct {
+	Song    *AutoSong  // Set for song URLs
+	Album   *AutoAlbum // Set for album URLs
+	Artwork []byte
+}
+
+// GetArtworkAndMetadata fetches the metadata and artwork of a song or album.
+// Only the catalog API and the artwork host are contacted; the device and
+// decryption services are never used
+func (sd *SongDownloaderImpl) GetArtworkAndMetadata(ctx context.Context, urlMeta *URLMeta) (*CoverResult, error) {
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	result := &CoverResult{}
+	var artwork Artwork
+
+	switch urlMeta.URLType {
+	case "songs":
+		song, err := sd.GetSongMeta(ctx, urlMeta, token)
+		if err != nil {
+			return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", err)
+		}
+		result.Song = song
+		artwork = song.Attributes.Artwork
+	case "albums":
+		album, err := sd.GetAlbumMeta(ctx, urlMeta, token)
+		if err != nil {
+			return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get album metadata", err)
+		}
+		result.Album = album
+		artwork = album.Attributes.Artwork
+	default:
+		return nil, NewDownloadError(ErrorInvalidURL, "only song and album links are supported")
+	}
+
+	if artwork.URL == "" {
+		return nil, NewDownloadError(ErrorNetworkFailure, "no artwork available")
+	}
+
+	result.Artwork, err = sd.fetchArtwork(ctx, artworkURL(artwork, CoverMaxDimension))
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to download artwork", err)
+	}
+
+	return result, nil
+}
+
+// GetArtworkAndMetadata fetches the metadata and artwork of a song or album
+// without going through the download pipeline
+func (m *Manager) GetArtworkAndMetadata(ctx context.Context, urlMeta *URLMeta) (*CoverResult, error) {
+	return m.NewDownloader().(*SongDownloaderImpl).GetArtworkAndMetadata(ctx, urlMeta)
+}
+
+// SongDetails is the catalog data of a song shown by /info, with what can be
+// downloaded of it
+type SongDetails struct {
+	Song       *AutoSong
+	Storefront string
+	ALAC       bool   // The catalog lists an ALAC (enhancedHls) stream
+	Lyrics     bool   // Apple Music has lyrics for the song
+	Artwork    []byte // nil when the song has none or it could not be fetched
+}
+
+// GetSongDetails fetches the metadata and artwork of a song. ALAC availability
+// comes from the catalog alone: the device and decryption services are never
+// used, and artwork that cannot be fetched is left out
+func (sd *SongDownloaderImpl) GetSongDetails(ctx context.Context, urlMeta *URLMeta) (*SongDetails, error) {
+	if urlMeta.URLType != "songs" {
+		return nil, NewDownloadError(ErrorInvalidURL, "only song links are supported")
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	song, err := sd.GetSongMeta(ctx, urlMeta, token)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", err)
+	}
+
+	attrs := song.Attributes
+	details := &SongDetails{
+		Song:       song,
+		Storefront: urlMeta.Storefront,
+		ALAC:       attrs.ExtendedAssetUrls["enhancedHls"] != "",
+		Lyrics:     attrs.HasLyrics || attrs.HasTimeSyncedLyrics,
+	}
+	if attrs.Artwork.URL != "" {
+		details.Artwork, err = sd.fetchArtwork(ctx, artworkURL(attrs.Artwork, CoverMaxDimension))
+		if err != nil {
+			logger.Warn("Failed to fetch artwork for song details", logging.String("ID", song.ID), logging.Err(err))
+		}
+	}
+	return details, nil
+}
+
+// GetSongDetails fetches the metadata and artwork of a song without going
+// through the download pipeline
+func (m *Manager) GetSongDetails(ctx context.Context, urlMeta *URLMeta) (*SongDetails, error) {
+	return m.NewDownloader().(*SongDownloaderImpl).GetSongDetails(ctx, urlMeta)
+}
+
+// SongPreview is what a song is shown with before it is downloaded
+type SongPreview struct {
+	Title      string
+	Artist     string
+	Album      string
+	ArtworkURL string // Empty when the song has no artwork
+}
+
+// GetSongPreview fetches the title, artist and artwork URL of a song. Only the
+// catalog API is contacted
+func (sd *SongDownloaderImpl) GetSongPreview(ctx context.Context, urlMeta *URLMeta) (*SongPreview, error) {
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	song, err := sd.GetSongMeta(ctx, urlMeta, token)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", err)
+	}
+
+	attrs := song.Attributes
+	preview := &SongPreview{Title: attrs.Name, Artist: attrs.ArtistName, Album: attrs.AlbumName}
+	if attrs.Artwork.URL != "" {
+		preview.ArtworkURL = artworkURL(attrs.Artwork, PreviewMaxDimension)
+	}
+	return preview, nil
+}
+
+// GetSongPreview fetches the title, artist and artwork URL of a song without
+// going through the download pipeline
+func (m *Manager) GetSongPreview(ctx context.Context, urlMeta *URLMeta) (*SongPreview, error) {
+	return m.NewDownloader().(*SongDownloaderImpl).GetSongPreview(ctx, urlMeta)
+}
+
+// artworkURL fills in the size of an artwork URL template, scaled down so
+// neither side exceeds maxDimension (zero or less keeps the original size),
+// and asks for a JPEG whatever format the template names
+func artworkURL(artwork Artwork, maxDimension int) string {
+	width, height := artwork.Width, artwork.Height
+	if maxDimension > 0 && (width > maxDimension || height > maxDimension) {
+		if width >= height {
+			height = height * maxDimension / width
+			width = maxDimension
+		} else {
+			width = width * maxDimension / height
+			height = maxDimension
+		}
+	}
+	url := strings.NewReplacer("{w}x{h}", fmt.Sprintf("%dx%d", width, height), "{c}", "bb", "{f}", "jpg").Replace(artwork.URL)
+	for _, ext := range []string{".png", ".webp"} {
+		if strings.HasSuffix(url, ext) {
+			url = strings.TrimSuffix(url, ext) + ".jpg"
+		}
+	}
+	return url
+}
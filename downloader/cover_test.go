@@ -0,0 +1,285 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+var testArtwork = []byte("\xff\xd8\xff\xe0fake-jpeg")
+
+// coverServer serves the token page, catalog API and artwork for cover tests
+type coverServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	artworkPath string
+}
+
+func newCoverServer(t *testing.T) *coverServer {
+	cs := &coverServer{}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case r.URL.Path == "/v1/catalog/us/songs/100":
+			fmt.Fprintf(w, `{"data":[{"id":"100","type":"songs","attributes":{
+				"name":"Song","artistName":"Artist","albumName":"Album","isrc":"USABC1234567",
+				"artwork":{"width":4000,"height":4000,"url":"%s/art/{w}x{h}bb.jpg"}},
+				"relationships":{"albums":{"data":[{"id":"200","type":"albums","attributes":{"upc":"012345678905"}}]}}}]}`, cs.URL)
+		case r.URL.Path == "/v1/catalog/us/songs/101":
+			fmt.Fprintf(w, `{"data":[{"id":"101","type":"songs","attributes":{
+				"name":"Other","artistName":"Artist","hasLyrics":true,"durationInMillis":185000,
+				"extendedAssetUrls":{"enhancedHls":"https://example.com/master.m3u8"},
+				"artwork":{"width":1200,"height":1200,"url":"%s/missing/{w}x{h}bb.jpg"}}}]}`, cs.URL)
+		case r.URL.Path == "/v1/catalog/us/albums/200":
+			if r.URL.Query().Get("include") != "tracks" {
+				t.Errorf("album request include = %q, want tracks", r.URL.Query().Get("include"))
+			}
+			fmt.Fprintf(w, `{"data":[{"id":"200","type":"albums","attributes":{
+				"name":"Album","artistName":"Artist","upc":"012345678905","trackCount":2,
+				"artwork":{"width":1200,"height":1200,"url":"%s/art/{w}x{h}bb.jpg"}},
+				"relationships":{"tracks":{"data":[
+					{"id":"100","attributes":{"name":"Song","trackNumber":1,"discNumber":1}},
+					{"id":"101","attributes":{"name":"Other","trackNumber":2,"discNumber":1}}]}}}]}`, cs.URL)
+		case strings.HasPrefix(r.URL.Path, "/art/"):
+			cs.mu.Lock()
+			cs.artworkPath = r.URL.Path
+			cs.mu.Unlock()
+			w.Write(testArtwork)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(cs.Close)
+	return cs
+}
+
+// newFakeSidecar listens like the device or decryption service and counts
+// connections made to it
+func newFakeSidecar(t *testing.T) (string, *int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var connects int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connects, 1)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), &connects
+}
+
+// newCoverDownloader creates a downloader using server for the catalog and
+// token, with fake sidecars that must never be contacted
+func newCoverDownloader(t *testing.T, server *coverServer) *SongDownloaderImpl {
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+
+	var deviceConnects, decryptionConnects *int32
+	sd.deviceUrl, deviceConnects = newFakeSidecar(t)
+	sd.decryptionUrl, decryptionConnects = newFakeSidecar(t)
+	t.Cleanup(func() {
+		if n := atomic.LoadInt32(deviceConnects); n != 0 {
+			t.Errorf("Device service got %d connections, want 0", n)
+		}
+		if n := atomic.LoadInt32(decryptionConnects); n != 0 {
+			t.Errorf("Decryption service got %d connections, want 0", n)
+		}
+	})
+	return sd
+}
+
+func TestGetArtworkAndMetadata_Song(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	result, err := sd.GetArtworkAndMetadata(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "100"})
+	if err != nil {
+		t.Fatalf("GetArtworkAndMetadata failed: %v", err)
+	}
+
+	if result.Song == nil || result.Album != nil {
+		t.Fatalf("Expected song result, got %+v", result)
+	}
+	if result.Song.Attributes.ISRC != "USABC1234567" {
+		t.Errorf("ISRC = %q", result.Song.Attributes.ISRC)
+	}
+	if !bytes.Equal(result.Artwork, testArtwork) {
+		t.Errorf("Artwork = %q, want %q", result.Artwork, testArtwork)
+	}
+
+	// 4000x4000 artwork is capped at the maximum dimension
+	want := fmt.Sprintf("/art/%dx%dbb.jpg", CoverMaxDimension, CoverMaxDimension)
+	if server.artworkPath != want {
+		t.Errorf("Artwork path = %q, want %q", server.artworkPath, want)
+	}
+}
+
+func TestGetArtworkAndMetadata_Album(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	result, err := sd.GetArtworkAndMetadata(context.Background(), &URLMeta{Storefront: "us", URLType: "albums", ID: "200"})
+	if err != nil {
+		t.Fatalf("GetArtworkAndMetadata failed: %v", err)
+	}
+
+	if result.Album == nil || result.Song != nil {
+		t.Fatalf("Expected album result, got %+v", result)
+	}
+	if result.Album.Attributes.UPC != "012345678905" {
+		t.Errorf("UPC = %q", result.Album.Attributes.UPC)
+	}
+	if tracks := result.Album.Relationships.Tracks.Data; len(tracks) != 2 || tracks[1].Attributes.Name != "Other" {
+		t.Errorf("Tracks = %+v", tracks)
+	}
+
+	// Artwork within the limit is fetched at its own size
+	if server.artworkPath != "/art/1200x1200bb.jpg" {
+		t.Errorf("Artwork path = %q", server.artworkPath)
+	}
+}
+
+func TestGetArtworkAndMetadata_Playlist(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	_, err := sd.GetArtworkAndMetadata(context.Background(), &URLMeta{Storefront: "us", URLType: "playlists", ID: "pl.1"})
+	if !IsDownloadError(err, ErrorInvalidURL) {
+		t.Errorf("Expected invalid URL error, got %v", err)
+	}
+}
+
+func TestGetSongDetails(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	details, err := sd.GetSongDetails(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "100"})
+	if err != nil {
+		t.Fatalf("GetSongDetails failed: %v", err)
+	}
+	if details.Song.Attributes.ISRC != "USABC1234567" || details.Storefront != "us" {
+		t.Errorf("Unexpected details %+v", details)
+	}
+	if details.ALAC || details.Lyrics {
+		t.Errorf("Expected no ALAC or lyrics without them in the catalog, got %+v", details)
+	}
+	if !bytes.Equal(details.Artwork, testArtwork) {
+		t.Errorf("Artwork = %q, want %q", details.Artwork, testArtwork)
+	}
+}
+
+func TestGetSongDetails_ALACAndMissingArtwork(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	// ALAC comes from the catalog, without asking the device service, and
+	// artwork that cannot be fetched is left out
+	details, err := sd.GetSongDetails(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "101"})
+	if err != nil {
+		t.Fatalf("GetSongDetails failed: %v", err)
+	}
+	if !details.ALAC || !details.Lyrics {
+		t.Errorf("Expected ALAC and lyrics, got %+v", details)
+	}
+	if details.Artwork != nil {
+		t.Errorf("Expected no artwork, got %q", details.Artwork)
+	}
+}
+
+func TestGetSongDetails_Album(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	_, err := sd.GetSongDetails(context.Background(), &URLMeta{Storefront: "us", URLType: "albums", ID: "200"})
+	if !IsDownloadError(err, ErrorInvalidURL) {
+		t.Errorf("Expected invalid URL error, got %v", err)
+	}
+}
+
+func TestGetSongPreview(t *testing.T) {
+	server := newCoverServer(t)
+	sd := newCoverDownloader(t, server)
+
+	preview, err := sd.GetSongPreview(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "100"})
+	if err != nil {
+		t.Fatalf("GetSongPreview failed: %v", err)
+	}
+	if preview.Title != "Song" || preview.Artist != "Artist" || preview.Album != "Album" {
+		t.Errorf("Unexpected preview %+v", preview)
+	}
+	want := fmt.Sprintf("%s/art/%dx%dbb.jpg", server.URL, PreviewMaxDimension, PreviewMaxDimension)
+	if preview.ArtworkURL != want {
+		t.Errorf("ArtworkURL = %q, want %q", preview.ArtworkURL, want)
+	}
+	if server.artworkPath != "" {
+		t.Errorf("Expected the artwork not to be downloaded, got a request for %q", server.artworkPath)
+	}
+}
+
+func TestArtworkURL(t *testing.T) {
+	const template = "https://example.com/{w}x{h}bb.jpg"
+
+	tests := []struct {
+		name         string
+		width        int
+		height       int
+		maxDimension int
+		want         string
+	}{
+		{"no limit", 4000, 4000, 0, "https://example.com/4000x4000bb.jpg"},
+		{"within limit", 1200, 1200, 3000, "https://example.com/1200x1200bb.jpg"},
+		{"square", 6000, 6000, 3000, "https://example.com/3000x3000bb.jpg"},
+		{"landscape", 6000, 3000, 3000, "https://example.com/3000x1500bb.jpg"},
+		{"portrait", 2000, 4000, 3000, "https://example.com/1500x3000bb.jpg"},
+		{"embedded default", 3000, 3000, DefaultArtworkMaxDimension, "https://example.com/1400x1400bb.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := artworkURL(Artwork{Width: tt.width, Height: tt.height, URL: template}, tt.maxDimension)
+			if got != tt.want {
+				t.Errorf("artworkURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArtworkURL_Format(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"crop and format", "https://example.com/{w}x{h}{c}.{f}", "https://example.com/600x600bb.jpg"},
+		{"png", "https://example.com/{w}x{h}bb.png", "https://example.com/600x600bb.jpg"},
+		{"webp", "https://example.com/{w}x{h}bb.webp", "https://example.com/600x600bb.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := artworkURL(Artwork{Width: 600, Height: 600, URL: tt.template}, 0)
+			if got != tt.want {
+				t.Errorf("artworkURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
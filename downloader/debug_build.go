@@ -0,0 +1,6 @@
+//go:build debug
+
+package downloader
+
+// debugBuild enables extra checks in builds made with -tags debug
+const debugBuild = true
@@ -0,0 +1,271 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDecryptTimeout bounds the decryptor's answer to one sample
+	DefaultDecryptTimeout = 30 * time.Second
+
+	// maxIdleDecryptConns is how many connections per decryptor are kept open
+	// between songs
+	maxIdleDecryptConns = 4
+)
+
+// errDecryptInterrupted is returned for samples sent after Interrupt
+var errDecryptInterrupted = errors.New("decryption interrupted")
+
+// DecryptClient keeps connections to the decryption service open across
+// songs. A song ends with the 0,0,0,0 terminator of its last key, which leaves
+// the service waiting for the next key, so the next song can be decrypted on
+// the same connection
+type DecryptClient struct {
+	timeout time.Duration // Deadline of each sample (0 = none)
+	dialer  net.Dialer
+
+	mu   sync.Mutex
+	idle map[string][]*decryptConn // By address
+}
+
+// NewDecryptClient creates a DecryptClient that fails a sample the decryptor
+// does not answer within timeout. Zero or less waits forever
+func NewDecryptClient(timeout time.Duration) *DecryptClient {
+	return &DecryptClient{
+		timeout: timeout,
+		dialer:  net.Dialer{Timeout: PreflightTimeout},
+		idle:    make(map[string][]*decryptConn),
+	}
+}
+
+// decryptConn is a connection to the decryptor with buffered reads and
+// writes
+type decryptConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *decryptConn) Close() error {
+	return c.conn.Close()
+}
+
+// Session starts decrypting a song with the decryptor at addr, on an idle
+// connection when there is one. Calls to the decryptor are recorded in
+// tracker when it is not nil
+func (c *DecryptClient) Session(addr string, tracker *LatencyTracker) *DecryptSession {
+	s := &DecryptSession{client: c, addr: addr, tracker: tracker, start: time.Now()}
+
+	c.mu.Lock()
+	if idle := c.idle[addr]; len(idle) > 0 {
+		s.conn = idle[len(idle)-1]
+		c.idle[addr] = idle[:len(idle)-1]
+		s.reused = true
+	}
+	c.mu.Unlock()
+
+	return s
+}
+
+// dial opens a new connection to the decryptor at addr
+func (c *DecryptClient) dial(addr string) (*decryptConn, error) {
+	conn, err := c.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// put keeps conn open for a later song, closing it when enough are idle
+func (c *DecryptClient) put(addr string, conn *decryptConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[addr]) >= maxIdleDecryptConns {
+		conn.Close()
+		return
+	}
+	c.idle[addr] = append(c.idle[addr], conn)
+}
+
+// DecryptSession decrypts the samples of one song over a connection of a
+// DecryptClient. It is not safe for concurrent use
+type DecryptSession struct {
+	client  *DecryptClient
+	addr    string
+	tracker *LatencyTracker
+
+	conn   *decryptConn
+	reused bool // conn served an earlier song, the decryptor may have closed it since
+
+	id, keyURI string // Key of the samples that follow
+	keySent    bool   // A key was selected on conn
+	decrypted  int    // Samples decrypted
+
+	start     time.Time
+	firstByte time.Duration
+
+	// mu guards conn and its deadline against Interrupt
+	mu          sync.Mutex
+	interrupted bool
+}
+
+// SetKey selects the key of the samples that follow. It is sent with the next
+// sample
+func (s *DecryptSession) SetKey(id, keyURI string) error {
+	if s.conn == nil {
+		conn, err := s.client.dial(s.addr)
+		if err != nil {
+			return err
+		}
+		s.setConn(conn)
+	}
+
+	if s.keySent {
+		if _, err := s.conn.w.Write([]byte{0, 0, 0, 0}); err != nil {
+			return err
+		}
+	}
+	s.id, s.keyURI = id, keyURI
+	return s.writeKey()
+}
+
+// writeKey buffers the selection of the current key
+func (s *DecryptSession) writeKey() error {
+	w := s.conn.w
+	w.WriteByte(byte(len(s.id)))
+	w.WriteString(s.id)
+	w.WriteByte(byte(len(s.keyURI)))
+	_, err := w.WriteString(s.keyURI)
+	s.keySent = true
+	return err
+}
+
+// Decrypt sends sample and reads its decrypted bytes into out, which must be
+// as long as sample. When the first sample on a reused connection fails
+// because the decryptor closed it, the song starts over on a new connection
+func (s *DecryptSession) Decrypt(sample, out []byte) error {
+	err := s.exchange(sample, out)
+	if err != nil && s.reused && s.decrypted == 0 && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, errDecryptInterrupted) {
+		s.conn.Close()
+		s.setConn(nil)
+		s.reused = false
+
+		conn, dialErr := s.client.dial(s.addr)
+		if dialErr != nil {
+			return dialErr
+		}
+		s.setConn(conn)
+		if err := s.writeKey(); err != nil {
+			return err
+		}
+		err = s.exchange(sample, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.decrypted == 0 {
+		s.firstByte = time.Since(s.start)
+	}
+	s.decrypted++
+	return nil
+}
+
+// exchange writes the length and data of sample in one write and reads the
+// answer into out
+func (s *DecryptSession) exchange(sample, out []byte) error {
+	s.mu.Lock()
+	if s.interrupted {
+		s.mu.Unlock()
+		return errDecryptInterrupted
+	}
+	if s.client.timeout > 0 {
+		s.conn.conn.SetDeadline(time.Now().Add(s.client.timeout))
+	}
+	s.mu.Unlock()
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(sample)))
+	s.conn.w.Write(size[:])
+	s.conn.w.Write(sample)
+	if err := s.conn.w.Flush(); err != nil {
+		return s.timeoutError(err)
+	}
+
+	if _, err := io.ReadFull(s.conn.r, out); err != nil {
+		return s.timeoutError(err)
+	}
+	return nil
+}
+
+// timeoutError names the timeout when err is a missed deadline
+func (s *DecryptSession) timeoutError(err error) error {
+	s.mu.Lock()
+	interrupted := s.interrupted
+	s.mu.Unlock()
+	if interrupted {
+		return fmt.Errorf("%w: %w", errDecryptInterrupted, err)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("decryptor did not answer within %s: %w", s.client.timeout, err)
+	}
+	return err
+}
+
+// Interrupt makes the pending sample, if any, and every later one fail at
+// once. Unlike the other methods it may be called from another goroutine
+func (s *DecryptSession) Interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interrupted = true
+	if s.conn != nil {
+		s.conn.conn.SetDeadline(time.Now())
+	}
+}
+
+// setConn replaces the connection of the session
+func (s *DecryptSession) setConn(conn *decryptConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+}
+
+// Close ends the song. After a successful song, ok, the connection is reset
+// for the next one and kept open; otherwise its state is unknown and it is
+// closed
+func (s *DecryptSession) Close(ok bool) {
+	if s.tracker != nil {
+		firstByte := s.firstByte
+		if firstByte == 0 {
+			firstByte = time.Since(s.start)
+		}
+		s.tracker.Record(DepDecrypt, firstByte, time.Since(s.start))
+	}
+
+	if s.conn == nil {
+		return
+	}
+	conn := s.conn
+	s.setConn(nil)
+
+	if ok && s.keySent {
+		conn.conn.SetDeadline(time.Time{})
+		conn.w.Write([]byte{0, 0, 0, 0})
+		ok = conn.w.Flush() == nil
+	}
+	if !ok {
+		conn.Close()
+		return
+	}
+	s.client.put(s.addr, conn)
+}
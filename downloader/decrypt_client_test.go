@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeDecryptor starts a decryption sidecar that runs serve on every
+// connection and counts the connections accepted
+func newFakeDecryptor(t *testing.T, serve func(conn net.Conn)) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &accepted
+}
+
+// decryptTestSongWith decrypts info with sd and checks the decrypted bytes
+func decryptTestSongWith(t *testing.T, sd *SongDownloaderImpl, info *SongInfo) {
+	t.Helper()
+
+	var out bytes.Buffer
+	err := sd.decryptSong(context.Background(), info, []string{"skd://key"}, &AutoSong{ID: "1"}, &out, ProgressCallbacks{})
+	if err != nil {
+		t.Fatalf("decryptSong() error = %v", err)
+	}
+	if want := int(info.totalDataSize); out.Len() != want {
+		t.Errorf("Expected %d decrypted bytes, got %d", want, out.Len())
+	}
+}
+
+func TestDecryptClient_ReusesConnectionAcrossSongs(t *testing.T) {
+	addr, accepted := newFakeDecryptor(t, serveXorDecrypt)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	for i := 0; i < 3; i++ {
+		decryptTestSongWith(t, sd, decryptTestSong(10, 100))
+	}
+
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("Expected 3 songs on 1 connection, got %d connections", got)
+	}
+}
+
+func TestDecryptClient_SwitchesKeysOnOneConnection(t *testing.T) {
+	addr, accepted := newFakeDecryptor(t, serveXorDecrypt)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	info := decryptTestSong(6, 100)
+	for i := range info.samples {
+		info.samples[i].descIndex = uint32(i / 3)
+	}
+	var out bytes.Buffer
+	err := sd.decryptSong(context.Background(), info, []string{prefetchKey, "skd://key"}, &AutoSong{ID: "1"}, &out, ProgressCallbacks{})
+	if err != nil {
+		t.Fatalf("decryptSong() error = %v", err)
+	}
+	if out.Len() != int(info.totalDataSize) {
+		t.Errorf("Expected %d decrypted bytes, got %d", info.totalDataSize, out.Len())
+	}
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("Expected both keys on 1 connection, got %d connections", got)
+	}
+}
+
+func TestDecryptClient_ReconnectsWhenIdleConnectionClosed(t *testing.T) {
+	// Closes the connection after the first song, as decryptors that serve
+	// one song per connection do
+	addr, accepted := newFakeDecryptor(t, serveXorSong)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	decryptTestSongWith(t, sd, decryptTestSong(5, 100))
+	time.Sleep(20 * time.Millisecond)
+	decryptTestSongWith(t, sd, decryptTestSong(5, 100))
+
+	if got := accepted.Load(); got != 2 {
+		t.Errorf("Expected a new connection for the second song, got %d connections", got)
+	}
+}
+
+func TestDecryptClient_TimesOutHungDecryptor(t *testing.T) {
+	// Reads everything and never answers
+	addr, _ := newFakeDecryptor(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+	sd.decrypter = NewDecryptClient(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sd.decryptSong(context.Background(), decryptTestSong(3, 100), []string{"skd://key"}, &AutoSong{ID: "1"}, io.Discard, ProgressCallbacks{})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Expected a deadline error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("decryptSong() blocked on a hung decryptor")
+	}
+}
+
+func TestDecryptClient_ClosesConnectionOfFailedSong(t *testing.T) {
+	addr, accepted := newFakeDecryptor(t, serveXorDecrypt)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+	sd.faults, _ = ParseFaultPlan("decrypt:reset@2samples")
+
+	err := sd.decryptSong(context.Background(), decryptTestSong(3, 100), []string{"skd://key"}, &AutoSong{ID: "1"}, io.Discard, ProgressCallbacks{})
+	if err == nil {
+		t.Fatal("Expected the injected fault")
+	}
+
+	sd.faults = nil
+	decryptTestSongWith(t, sd, decryptTestSong(3, 100))
+
+	if got := accepted.Load(); got != 2 {
+		t.Errorf("Expected the failed song's connection to be dropped, got %d connections", got)
+	}
+}
+
+// serveXorSong answers one song like serveXorDecrypt, then returns
+func serveXorSong(conn net.Conn) {
+	var sizeBuf [4]byte
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 2; i++ {
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(length[0])); err != nil {
+			return
+		}
+	}
+	for {
+		if _, err := io.ReadFull(conn, sizeBuf[:]); err != nil {
+			return
+		}
+		size := binary.LittleEndian.Uint32(sizeBuf[:])
+		if size == 0 {
+			return
+		}
+		data := buf[:size]
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		for i := range data {
+			data[i] ^= 0xff
+		}
+		conn.Write(data)
+	}
+}
@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultDecryptLimit is how many songs are decrypted at once. The decryption
+// service is the bottleneck of a download, so other downloads only overlap
+// their download and upload with it
+const DefaultDecryptLimit = 1
+
+// DecryptSlots limits how many downloads decrypt at once. Downloads wait for
+// a free slot in the order they asked for one. A nil DecryptSlots is
+// unlimited
+type DecryptSlots struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+}
+
+// NewDecryptSlots creates slots for limit songs decrypted at once. Zero or
+// less disables the limit
+func NewDecryptSlots(limit int) *DecryptSlots {
+	if limit <= 0 {
+		return nil
+	}
+	return &DecryptSlots{slots: make(chan struct{}, limit)}
+}
+
+// Limit returns how many songs may be decrypted at once (0 = unlimited)
+func (s *DecryptSlots) Limit() int {
+	if s == nil {
+		return 0
+	}
+	return cap(s.slots)
+}
+
+// InUse returns how many songs are being decrypted
+func (s *DecryptSlots) InUse() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.slots)
+}
+
+// Waiting returns how many downloads wait for a slot
+func (s *DecryptSlots) Waiting() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiting
+}
+
+// Acquire takes a slot, calling onWait first when none is free, and returns
+// the function giving it back. It fails when ctx ends before a slot is free
+func (s *DecryptSlots) Acquire(ctx context.Context, onWait func()) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return s.releaseFunc(), nil
+	default:
+	}
+
+	if onWait != nil {
+		onWait()
+	}
+	s.mu.Lock()
+	s.waiting++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.waiting--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case s.slots <- struct{}{}:
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaseFunc returns a function giving a slot back once, however often it is
+// called
+func (s *DecryptSlots) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-s.slots })
+	}
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecryptSlots_NeverExceeded(t *testing.T) {
+	for _, limit := range []int{1, 2} {
+		slots := NewDecryptSlots(limit)
+
+		var active, peak, waited int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := slots.Acquire(context.Background(), func() { atomic.AddInt32(&waited, 1) })
+				if err != nil {
+					t.Errorf("Acquire() error = %v", err)
+					return
+				}
+				defer release()
+
+				n := atomic.AddInt32(&active, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&active, -1)
+			}()
+		}
+		wg.Wait()
+
+		if p := atomic.LoadInt32(&peak); p > int32(limit) {
+			t.Errorf("Limit %d: %d songs decrypted at once", limit, p)
+		}
+		if atomic.LoadInt32(&waited) == 0 {
+			t.Errorf("Limit %d: expected some requests to wait for a slot", limit)
+		}
+		if slots.InUse() != 0 || slots.Waiting() != 0 {
+			t.Errorf("Limit %d: expected every slot back, got %d in use and %d waiting", limit, slots.InUse(), slots.Waiting())
+		}
+	}
+}
+
+func TestDecryptSlots_Cancelled(t *testing.T) {
+	slots := NewDecryptSlots(1)
+	release, err := slots.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := slots.Acquire(ctx, nil)
+		done <- err
+	}()
+	waitUntil(t, "the second request to wait", func() bool { return slots.Waiting() == 1 })
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to be cancelled, got %v", err)
+	}
+
+	// Giving a slot back twice frees it only once
+	release()
+	release()
+	if slots.InUse() != 0 || slots.Waiting() != 0 {
+		t.Errorf("Expected no slot in use, got %d in use and %d waiting", slots.InUse(), slots.Waiting())
+	}
+}
+
+func TestDecryptSlots_Unlimited(t *testing.T) {
+	slots := NewDecryptSlots(0)
+	if slots != nil || slots.Limit() != 0 {
+		t.Fatalf("Expected no limit, got %d", slots.Limit())
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := slots.Acquire(context.Background(), func() { t.Error("Expected no wait without a limit") }); err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+	}
+}
+
+func TestAcquireDecryptSlot_ReportsWaiting(t *testing.T) {
+	slots := NewDecryptSlots(1)
+	holder := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	holder.decryptSlots = slots
+	release, err := holder.acquireDecryptSlot(context.Background(), ProgressCallbacks{})
+	if err != nil {
+		t.Fatalf("acquireDecryptSlot() error = %v", err)
+	}
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptSlots = slots
+	phases := make(chan Phase, 1)
+	callbacks := ProgressCallbacks{OnPhaseChange: func(oldPhase, newPhase Phase) { phases <- newPhase }}
+
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := sd.acquireDecryptSlot(context.Background(), callbacks)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+
+	if phase := <-phases; phase != PhaseWaitingForDecrypt {
+		t.Errorf("Expected the waiting phase, got %s", phase)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second download to wait while the first decrypts")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("acquireDecryptSlot() error = %v", err)
+	}
+}
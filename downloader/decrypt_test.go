@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newXorDecryptServer starts a decryption sidecar that "decrypts" every sample
+// by flipping its bits, reusing one buffer so that it allocates nothing per
+// sample itself
+func newXorDecryptServer(tb testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Listen() error = %v", err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveXorDecrypt(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// serveXorDecrypt answers key selections and samples on conn until an empty
+// id or an error. An empty sample ends the samples of a key
+func serveXorDecrypt(conn net.Conn) {
+	serveXorDecryptKeys(conn, nil)
+}
+
+// serveXorDecryptKeys works like serveXorDecrypt, closing the connection when
+// a key URI is selected that rejected returns true for
+func serveXorDecryptKeys(conn net.Conn, rejected func(keyURI string) bool) {
+	buf := make([]byte, 64<<10)
+	for {
+		// Key selection: id and key URI, each prefixed by its length
+		var fields [2][]byte
+		for i := range fields {
+			var length [1]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil || (i == 0 && length[0] == 0) {
+				return
+			}
+			fields[i] = make([]byte, length[0])
+			if _, err := io.ReadFull(conn, fields[i]); err != nil {
+				return
+			}
+		}
+		if rejected != nil && rejected(string(fields[1])) {
+			return
+		}
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			data := buf[:size]
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			for i := range data {
+				data[i] ^= 0xff
+			}
+			conn.Write(data)
+		}
+	}
+}
+
+// decryptTestSong returns a song of count samples of size bytes each
+func decryptTestSong(count, size int) *SongInfo {
+	info := &SongInfo{}
+	for i := 0; i < count; i++ {
+		info.samples = append(info.samples, SampleInfo{data: bytes.Repeat([]byte{byte(i)}, size)})
+		info.totalDataSize += int64(size)
+	}
+	return info
+}
+
+func TestDecryptSong_StreamsSamplesInOrder(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = newXorDecryptServer(t)
+
+	info := decryptTestSong(20, 100)
+	var out bytes.Buffer
+	err := sd.decryptSong(context.Background(), info, []string{"skd://key"}, &AutoSong{ID: "1"}, &out, ProgressCallbacks{})
+	if err != nil {
+		t.Fatalf("decryptSong() error = %v", err)
+	}
+
+	var want []byte
+	for _, sample := range info.samples {
+		for _, b := range sample.data {
+			want = append(want, b^0xff)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("Expected %d decrypted bytes in sample order, got %d", len(want), out.Len())
+	}
+}
+
+// keyTestSong returns a song of two samples per description index given
+func keyTestSong(descIndexes ...uint32) *SongInfo {
+	info := decryptTestSong(2*len(descIndexes), 10)
+	for i := range info.samples {
+		info.samples[i].descIndex = descIndexes[i/2]
+	}
+	return info
+}
+
+func TestDecryptSong_FallsBackToPrefetchKey(t *testing.T) {
+	var mu sync.Mutex
+	var selected []string
+	addr, _ := newFakeDecryptor(t, func(conn net.Conn) {
+		serveXorDecryptKeys(conn, func(keyURI string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			selected = append(selected, keyURI)
+			return false
+		})
+	})
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	info := keyTestSong(0, 1, 2)
+	var out bytes.Buffer
+	err := sd.decryptSong(context.Background(), info, []string{prefetchKey, "skd://key"}, &AutoSong{ID: "1"}, &out, ProgressCallbacks{})
+	if err != nil {
+		t.Fatalf("decryptSong() error = %v", err)
+	}
+	if out.Len() != int(info.totalDataSize) {
+		t.Errorf("Expected %d decrypted bytes, got %d", info.totalDataSize, out.Len())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{prefetchKey, "skd://key", prefetchKey}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, selected)
+	}
+}
+
+func TestDecryptSong_ReportsFailedFallbackRange(t *testing.T) {
+	addr, _ := newFakeDecryptor(t, func(conn net.Conn) {
+		serveXorDecryptKeys(conn, func(keyURI string) bool { return keyURI == prefetchKey })
+	})
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	err := sd.decryptSong(context.Background(), keyTestSong(1, 2), []string{prefetchKey, "skd://key"}, &AutoSong{ID: "1"}, io.Discard, ProgressCallbacks{})
+
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) {
+		t.Fatalf("Expected a DownloadError, got %v", err)
+	}
+	if downloadErr.Type != ErrorDecryptionFailure {
+		t.Errorf("Expected a decryption failure, got %s", downloadErr.Type)
+	}
+	if got := downloadErr.Context["samples"]; got != "2-3" {
+		t.Errorf("Expected the failed range 2-3, got %v", got)
+	}
+}
+
+func TestDecryptSong_FailureWithOwnKeyIsNotAFallback(t *testing.T) {
+	addr, _ := newFakeDecryptor(t, func(conn net.Conn) {
+		serveXorDecryptKeys(conn, func(keyURI string) bool { return keyURI == "skd://key" })
+	})
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+
+	err := sd.decryptSong(context.Background(), keyTestSong(1, 2), []string{prefetchKey, "skd://key"}, &AutoSong{ID: "1"}, io.Discard, ProgressCallbacks{})
+	var downloadErr *DownloadError
+	if err == nil || errors.As(err, &downloadErr) {
+		t.Errorf("Expected the connection error itself, got %v", err)
+	}
+}
+
+func TestWarnMissingKeys(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	info := keyTestSong(0, 1, 2)
+	info.descriptions = 3
+
+	var warnings []string
+	callbacks := ProgressCallbacks{OnWarning: func(message string) { warnings = append(warnings, message) }}
+	sd.warnMissingKeys(info, []string{prefetchKey, "skd://key"}, callbacks)
+
+	expected := []string{
+		"manifest has 2 keys for 3 sample descriptions",
+		"no key for sample description 2, decrypting samples 4-5 with the prefetch key",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %q, got %q", expected, warnings)
+	}
+
+	warnings = nil
+	sd.warnMissingKeys(info, []string{prefetchKey, "skd://key", "skd://other"}, callbacks)
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings with a key per description, got %q", warnings)
+	}
+}
+
+// BenchmarkDecryptSong decrypts a 16 MB track. Decrypted samples go straight
+// to the writer, so the allocations per run stay far below the track size
+// instead of growing with it
+func BenchmarkDecryptSong(b *testing.B) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = newXorDecryptServer(b)
+	info := decryptTestSong(4096, 4096)
+
+	b.SetBytes(info.totalDataSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := sd.decryptSong(context.Background(), info, []string{"skd://key"}, &AutoSong{ID: "1"}, io.Discard, ProgressCallbacks{})
+		if err != nil {
+			b.Fatalf("decryptSong() error = %v", err)
+		}
+	}
+}
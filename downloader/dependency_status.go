@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DependencyCheck is the outcome of probing one dependency of the downloader
+type DependencyCheck struct {
+	Name    string
+	Target  string // Address probed, empty when there is none
+	Err     error  // nil when the dependency is available
+	Latency time.Duration
+}
+
+// OK reports whether the dependency is available
+func (c DependencyCheck) OK() bool {
+	return c.Err == nil
+}
+
+// DependencyStatus is the state of everything downloads depend on
+type DependencyStatus struct {
+	Device    DependencyCheck // M3U8_URL, which serves the stream playlists
+	Decryptor DependencyCheck // DEC_URL, which decrypts the samples
+	Token     DependencyCheck // Acquisition of an Apple Music token
+
+	DownloadDir string
+	DiskFree    uint64 // Bytes available in DownloadDir
+	DiskErr     error  // Why DiskFree is unknown
+}
+
+// Checks returns the dependency checks in the order they are shown
+func (s DependencyStatus) Checks() []DependencyCheck {
+	return []DependencyCheck{s.Device, s.Decryptor, s.Token}
+}
+
+// CheckDependencies probes the device and decryption services, the token
+// acquisition and the free space of the download directory concurrently,
+// each until ctx is done. The returned error joins the failed checks; the
+// status is complete either way
+func (sd *SongDownloaderImpl) CheckDependencies(ctx context.Context) (DependencyStatus, error) {
+	status := DependencyStatus{DownloadDir: sd.downloadDir}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		status.Device = probeTCP(ctx, "Device (M3U8_URL)", sd.deviceUrl)
+	}()
+	go func() {
+		defer wg.Done()
+		status.Decryptor = probeTCP(ctx, "Decryptor (DEC_URL)", sd.decryptionUrl)
+	}()
+	go func() {
+		defer wg.Done()
+		status.Token = sd.probeToken(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		status.DiskFree, status.DiskErr = diskFree(sd.downloadDir)
+	}()
+	wg.Wait()
+
+	var errs []error
+	for _, check := range status.Checks() {
+		if !check.OK() {
+			errs = append(errs, fmt.Errorf("%s: %w", check.Name, check.Err))
+		}
+	}
+	if status.DiskErr != nil {
+		errs = append(errs, fmt.Errorf("disk space: %w", status.DiskErr))
+	}
+	return status, errors.Join(errs...)
+}
+
+// probeTCP checks that a connection to addr can be opened
+func probeTCP(ctx context.Context, name, addr string) DependencyCheck {
+	check := DependencyCheck{Name: name, Target: addr}
+	start := time.Now()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	check.Latency = time.Since(start)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	conn.Close()
+	return check
+}
+
+// probeToken checks that an Apple Music token can be acquired. Cached tokens
+// count, as downloads would use them too
+func (sd *SongDownloaderImpl) probeToken(ctx context.Context) DependencyCheck {
+	check := DependencyCheck{Name: "Apple Music token"}
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sd.GetToken(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		check.Err = err
+	case <-ctx.Done():
+		check.Err = ctx.Err()
+	}
+	check.Latency = time.Since(start)
+	return check
+}
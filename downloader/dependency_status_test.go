@@ -0,0 +1,49 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	device, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+
+	// A closed listener leaves an address nothing listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceUrl = device.Addr().String()
+	sd.decryptionUrl = closed.Addr().String()
+	sd.staticToken = "static-token"
+	sd.downloadDir = t.TempDir()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := sd.CheckDependencies(ctx)
+	if err == nil {
+		t.Error("Expected an error for the unreachable decryptor")
+	}
+
+	if !status.Device.OK() || status.Device.Target != sd.deviceUrl {
+		t.Errorf("Expected the device check to pass, got %+v", status.Device)
+	}
+	if status.Decryptor.OK() {
+		t.Error("Expected the decryptor check to fail")
+	}
+	if !status.Token.OK() {
+		t.Errorf("Expected the static token to pass, got %v", status.Token.Err)
+	}
+	if status.DiskErr != nil || status.DiskFree == 0 {
+		t.Errorf("Expected free disk space, got %d, %v", status.DiskFree, status.DiskErr)
+	}
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import "fmt"
+
+// descIndexConvention is how a file numbers the sample description index in
+// its tfhd boxes. ISO/IEC 14496-12 makes the first stsd entry 1, but some
+// encoders write 0 for it
+type descIndexConvention int
+
+const (
+	descIndexOneBased descIndexConvention = iota
+	descIndexZeroBased
+)
+
+// String returns the string representation of the convention
+func (c descIndexConvention) String() string {
+	if c == descIndexZeroBased {
+		return "0-based"
+	}
+	return "1-based"
+}
+
+// detectDescIndexConvention decides the convention of a file from the indexes
+// of all its fragments and the number of stsd entries, and explains why.
+// An index of 0 only exists 0-based, an index equal to the entry count only
+// exists 1-based; anything else fits both and is read as the standard 1-based
+func detectDescIndexConvention(indexes []uint32, entryCount uint32) (descIndexConvention, string) {
+	if len(indexes) == 0 {
+		return descIndexOneBased, "no fragments"
+	}
+
+	lowest, highest := indexes[0], indexes[0]
+	for _, index := range indexes[1:] {
+		if index < lowest {
+			lowest = index
+		}
+		if index > highest {
+			highest = index
+		}
+	}
+
+	switch {
+	case lowest == 0:
+		return descIndexZeroBased, fmt.Sprintf("index 0 present, range %d-%d, %d stsd entries", lowest, highest, entryCount)
+	case entryCount > 0 && highest >= entryCount:
+		return descIndexOneBased, fmt.Sprintf("index %d needs 1-based numbering with %d stsd entries", highest, entryCount)
+	default:
+		return descIndexOneBased, fmt.Sprintf("range %d-%d fits both with %d stsd entries, assuming the standard", lowest, highest, entryCount)
+	}
+}
+
+// normalizeDescIndex converts an index to the 0-based position used for keys
+func normalizeDescIndex(index uint32, convention descIndexConvention) uint32 {
+	if convention == descIndexOneBased && index > 0 {
+		return index - 1
+	}
+	return index
+}
+
+// keyFallback is a run of samples whose description has no key in the
+// manifest, decrypted with the prefetch key instead
+type keyFallback struct {
+	descIndex   uint32
+	first, last int // Positions of the first and last sample of the run
+}
+
+// samples formats the positions of the run, e.g. "120-239"
+func (f keyFallback) samples() string {
+	return fmt.Sprintf("%d-%d", f.first, f.last)
+}
+
+// sampleKey returns the key URI of a sample description, the prefetch key
+// when the manifest has none for it
+func sampleKey(keys []string, descIndex uint32) string {
+	if int(descIndex) < len(keys) {
+		return keys[descIndex]
+	}
+	return prefetchKey
+}
+
+// keyFallbacks returns the runs of samples whose description has no key
+func keyFallbacks(samples []SampleInfo, keys []string) []keyFallback {
+	var fallbacks []keyFallback
+	for i, sample := range samples {
+		if int(sample.descIndex) < len(keys) {
+			continue
+		}
+		if n := len(fallbacks); n > 0 && fallbacks[n-1].descIndex == sample.descIndex && fallbacks[n-1].last == i-1 {
+			fallbacks[n-1].last = i
+			continue
+		}
+		fallbacks = append(fallbacks, keyFallback{descIndex: sample.descIndex, first: i, last: i})
+	}
+	return fallbacks
+}
+
+// warnMissingKeys warns when the manifest has fewer keys than the song has
+// sample descriptions, and about every run of samples decrypted with the
+// prefetch key because of it
+func (sd *SongDownloaderImpl) warnMissingKeys(info *SongInfo, keys []string, callbacks ProgressCallbacks) {
+	if info.descriptions > 0 && len(keys) < int(info.descriptions) {
+		sd.warn(fmt.Sprintf("manifest has %d keys for %d sample descriptions", len(keys), info.descriptions), callbacks)
+	}
+	for _, fallback := range keyFallbacks(info.samples, keys) {
+		sd.warn(fmt.Sprintf("no key for sample description %d, decrypting samples %s with the prefetch key",
+			fallback.descIndex, fallback.samples()), callbacks)
+	}
+}
+
+// fallbackDecryptError returns the failure to decrypt sample i. When the
+// sample was decrypted with the prefetch key in place of its own, the error
+// names the run of samples that used it
+func fallbackDecryptError(samples []SampleInfo, keys []string, i int, err error) error {
+	if int(samples[i].descIndex) < len(keys) {
+		return err
+	}
+	for _, fallback := range keyFallbacks(samples, keys) {
+		if i >= fallback.first && i <= fallback.last {
+			return NewDownloadErrorWithCause(ErrorDecryptionFailure,
+				fmt.Sprintf("decrypting samples %s with the prefetch key failed", fallback.samples()), err).
+				WithContext("samples", fallback.samples()).
+				WithContext("description", fallback.descIndex)
+		}
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/abema/go-mp4"
+)
+
+// fixtureSampleSizes are the sample sizes of every fragment in a fixture
+var fixtureSampleSizes = []uint32{3, 5}
+
+// buildFragmentedFixture writes a minimal fragmented ALAC track with
+// stsdEntries sample descriptions and one fragment per tfhd index
+func buildFragmentedFixture(t *testing.T, stsdEntries int, tfhdIndexes []uint32) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "fixture.mp4")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer file.Close()
+
+	w := mp4.NewWriter(file)
+	box := func(payload mp4.IImmutableBox, children func()) {
+		info, err := w.StartBox(&mp4.BoxInfo{Type: payload.GetType()})
+		if err != nil {
+			t.Fatalf("Failed to start %s box: %v", payload.GetType(), err)
+		}
+		if _, err := mp4.Marshal(w, payload, info.Context); err != nil {
+			t.Fatalf("Failed to marshal %s box: %v", payload.GetType(), err)
+		}
+		if children != nil {
+			children()
+		}
+		if _, err := w.EndBox(); err != nil {
+			t.Fatalf("Failed to end %s box: %v", payload.GetType(), err)
+		}
+	}
+
+	box(&mp4.Moov{}, func() {
+		box(&mp4.Mvhd{Timescale: 44100, Rate: 0x10000, Volume: 0x100, NextTrackID: 2}, nil)
+		box(&mp4.Trak{}, func() {
+			box(&mp4.Tkhd{TrackID: 1, Volume: 0x100}, nil)
+			box(&mp4.Mdia{}, func() {
+				box(&mp4.Mdhd{Timescale: 44100}, nil)
+				box(&mp4.Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}}, nil)
+				box(&mp4.Minf{}, func() {
+					box(&mp4.Smhd{}, nil)
+					box(&mp4.Dinf{}, nil)
+					box(&mp4.Stbl{}, func() {
+						box(&mp4.Stsd{EntryCount: uint32(stsdEntries)}, func() {
+							for i := 0; i < stsdEntries; i++ {
+								entry := &mp4.AudioSampleEntry{
+									SampleEntry:  mp4.SampleEntry{AnyTypeBox: mp4.AnyTypeBox{Type: mp4.BoxTypeEnca()}, DataReferenceIndex: 1},
+									ChannelCount: 2,
+									SampleSize:   16,
+									SampleRate:   44100 << 16,
+								}
+								box(entry, func() {
+									box(&Alac{FrameLength: 4096, BitDepth: 16, NumChannels: 2, SampleRate: 44100}, nil)
+								})
+							}
+						})
+					})
+				})
+			})
+		})
+		box(&mp4.Mvex{}, func() {
+			box(&mp4.Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 4096}, nil)
+		})
+	})
+
+	for _, index := range tfhdIndexes {
+		var data []byte
+		trun := &mp4.Trun{SampleCount: uint32(len(fixtureSampleSizes))}
+		trun.SetFlags(0x300)
+		for _, size := range fixtureSampleSizes {
+			trun.Entries = append(trun.Entries, mp4.TrunEntry{SampleDuration: 4096, SampleSize: size})
+			data = append(data, make([]byte, size)...)
+		}
+
+		tfhd := &mp4.Tfhd{TrackID: 1, SampleDescriptionIndex: index}
+		tfhd.SetFlags(mp4.TfhdSampleDescriptionIndexPresent)
+
+		box(&mp4.Moof{}, func() {
+			box(&mp4.Traf{}, func() {
+				box(tfhd, nil)
+				box(trun, nil)
+			})
+		})
+		box(&mp4.Mdat{Data: data}, nil)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return raw
+}
+
+func TestParseSongInfo_DescIndexConventions(t *testing.T) {
+	keys := []string{prefetchKey, "skd://itunes.apple.com/P000000000/s1/e2"}
+
+	testCases := []struct {
+		name        string
+		stsdEntries int
+		tfhdIndexes []uint32
+		expected    []uint32 // Normalized descIndex of every sample
+	}{
+		{
+			name:        "1-based encoder",
+			stsdEntries: 2,
+			tfhdIndexes: []uint32{1, 2, 2},
+			expected:    []uint32{0, 0, 1, 1, 1, 1},
+		},
+		{
+			name:        "0-based encoder",
+			stsdEntries: 2,
+			tfhdIndexes: []uint32{0, 1, 1},
+			expected:    []uint32{0, 0, 1, 1, 1, 1},
+		},
+		{
+			name:        "0-based encoder starting past the prefetch description",
+			stsdEntries: 2,
+			tfhdIndexes: []uint32{1, 1, 0},
+			expected:    []uint32{1, 1, 1, 1, 0, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := parseSongInfo(buildFragmentedFixture(t, tc.stsdEntries, tc.tfhdIndexes))
+			if err != nil {
+				t.Fatalf("Failed to parse fixture: %v", err)
+			}
+			if info == nil {
+				t.Fatal("Expected song info, got nil")
+			}
+
+			var got []uint32
+			for _, sample := range info.samples {
+				got = append(got, sample.descIndex)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected descIndex sequence %v, got %v", tc.expected, got)
+			}
+
+			if fallbacks := keyFallbacks(info.samples, keys); len(fallbacks) != 0 {
+				t.Errorf("Expected every sample to have a key, got fallbacks %v", fallbacks)
+			}
+		})
+	}
+}
+
+func TestDetectDescIndexConvention(t *testing.T) {
+	testCases := []struct {
+		name       string
+		indexes    []uint32
+		entryCount uint32
+		expected   descIndexConvention
+	}{
+		{"zero present", []uint32{0, 1}, 2, descIndexZeroBased},
+		{"index equals entry count", []uint32{1, 2}, 2, descIndexOneBased},
+		{"single entry 1-based", []uint32{1, 1}, 1, descIndexOneBased},
+		{"single entry 0-based", []uint32{0, 0}, 1, descIndexZeroBased},
+		{"ambiguous defaults to standard", []uint32{1, 1}, 2, descIndexOneBased},
+		{"no fragments", nil, 2, descIndexOneBased},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			convention, reason := detectDescIndexConvention(tc.indexes, tc.entryCount)
+			if convention != tc.expected {
+				t.Errorf("Expected %s, got %s (%s)", tc.expected, convention, reason)
+			}
+			if reason == "" {
+				t.Error("Expected the decision to be explained")
+			}
+		})
+	}
+}
+
+func TestKeyFallbacks(t *testing.T) {
+	samples := []SampleInfo{{descIndex: 0}, {descIndex: 2}, {descIndex: 2}, {descIndex: 1}, {descIndex: 2}}
+
+	if fallbacks := keyFallbacks(samples, []string{"a", "b", "c"}); len(fallbacks) != 0 {
+		t.Errorf("Expected no fallbacks, got %v", fallbacks)
+	}
+
+	expected := []keyFallback{{descIndex: 2, first: 1, last: 2}, {descIndex: 2, first: 4, last: 4}}
+	if got := keyFallbacks(samples, []string{"a", "b"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected fallbacks %v, got %v", expected, got)
+	}
+	if got := sampleKey([]string{"a", "b"}, 2); got != prefetchKey {
+		t.Errorf("Expected the prefetch key for a description without key, got %q", got)
+	}
+}
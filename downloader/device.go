@@ -0,0 +1,77 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// DefaultDeviceTimeout bounds each step of a request to the device service:
+// connecting, sending the song ID and waiting for the answer
+const DefaultDeviceTimeout = 10 * time.Second
+
+// maxDeviceIDLength is the longest song ID the device protocol can send, its
+// length being sent as a single byte
+const maxDeviceIDLength = 255
+
+// deviceRedialDelay is the wait before dialing the device service again after
+// it refused the connection, as it does while the sidecar restarts
+var deviceRedialDelay = 500 * time.Millisecond
+
+// ErrDeviceIDLength is the cause of the DeviceError of a song ID the device
+// protocol cannot send
+var ErrDeviceIDLength = errors.New("song ID length out of range")
+
+// DeviceError is an error of a request to the device service (M3U8_URL)
+type DeviceError struct {
+	Op  string // "dial", "write" or "read"
+	Err error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("device service %s: %v", e.Op, e.Err)
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the device service did not answer in time, like
+// net.Error
+func (e *DeviceError) Timeout() bool {
+	var netErr net.Error
+	return errors.Is(e.Err, os.ErrDeadlineExceeded) || (errors.As(e.Err, &netErr) && netErr.Timeout())
+}
+
+// IsDeviceTimeout reports whether err is, or wraps, a DeviceError of a device
+// service that timed out
+func IsDeviceTimeout(err error) bool {
+	var deviceErr *DeviceError
+	return errors.As(err, &deviceErr) && deviceErr.Timeout()
+}
+
+// dialDevice connects to the device service, dialing once more after
+// deviceRedialDelay when it refused the connection
+func (sd *SongDownloaderImpl) dialDevice() (net.Conn, error) {
+	conn, err := sd.dial(DepDevice, sd.deviceUrl, sd.deviceTimeout)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		logger.Warn("Device service refused the connection, dialing again", logging.String("Address", sd.deviceUrl))
+		time.Sleep(deviceRedialDelay)
+		conn, err = sd.dial(DepDevice, sd.deviceUrl, sd.deviceTimeout)
+	}
+	return conn, err
+}
+
+// deviceDeadline returns the deadline of the next step of a device request,
+// none without a device timeout
+func (sd *SongDownloaderImpl) deviceDeadline() time.Time {
+	if sd.deviceTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(sd.deviceTimeout)
+}
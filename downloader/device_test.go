@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeDevice starts a device service handling each connection with serve
+func newFakeDevice(t *testing.T, serve func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// readDeviceID reads the length-prefixed song ID of a device request
+func readDeviceID(conn net.Conn) (string, error) {
+	reader := bufio.NewReader(conn)
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, length)
+	if _, err := io.ReadFull(reader, id); err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+func TestGetEnhanceHls_Responds(t *testing.T) {
+	ids := make(chan string, 1)
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceUrl = newFakeDevice(t, func(conn net.Conn) {
+		id, err := readDeviceID(conn)
+		if err != nil {
+			return
+		}
+		ids <- id
+		conn.Write([]byte("https://example.com/master.m3u8\n"))
+	})
+
+	url, err := sd.GetEnhanceHls("1440818839")
+	if err != nil {
+		t.Fatalf("GetEnhanceHls() error = %v", err)
+	}
+	if url != "https://example.com/master.m3u8" {
+		t.Errorf("Expected the device's URL, got %q", url)
+	}
+	if id := <-ids; id != "1440818839" {
+		t.Errorf("Expected the device to get the song ID, got %q", id)
+	}
+}
+
+func TestGetEnhanceHls_Hangs(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceTimeout = 50 * time.Millisecond
+	sd.deviceUrl = newFakeDevice(t, func(conn net.Conn) {
+		readDeviceID(conn)
+		<-release
+	})
+
+	start := time.Now()
+	_, err := sd.GetEnhanceHls("1440818839")
+	if !IsDeviceTimeout(err) {
+		t.Fatalf("Expected a device timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request to give up after the timeout, took %v", elapsed)
+	}
+
+	// The download reports the timeout as such
+	downloadErr := NewDownloadErrorWithCause(ErrorTimeout, "device service timed out", err)
+	if msg := downloadErr.UserMessage(); !strings.Contains(msg, "device service timed out") {
+		t.Errorf("Expected the user message to name the device service, got %q", msg)
+	}
+}
+
+func TestGetEnhanceHls_ClosesEarly(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceUrl = newFakeDevice(t, func(conn net.Conn) {
+		readDeviceID(conn)
+	})
+
+	_, err := sd.GetEnhanceHls("1440818839")
+	var deviceErr *DeviceError
+	if !errors.As(err, &deviceErr) || deviceErr.Op != "read" {
+		t.Fatalf("Expected a device read error, got %v", err)
+	}
+	if IsDeviceTimeout(err) {
+		t.Errorf("Expected a closed connection not to be a timeout, got %v", err)
+	}
+}
+
+func TestGetEnhanceHls_IDLength(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	addr, connects := newFakeSidecar(t)
+	sd.deviceUrl = addr
+
+	for _, id := range []string{"", "pl." + strings.Repeat("a", maxDeviceIDLength)} {
+		if _, err := sd.GetEnhanceHls(id); !errors.Is(err, ErrDeviceIDLength) {
+			t.Errorf("GetEnhanceHls(%d bytes) error = %v, want ErrDeviceIDLength", len(id), err)
+		}
+	}
+	if n := atomic.LoadInt32(connects); n != 0 {
+		t.Errorf("Expected no connection for invalid IDs, got %d", n)
+	}
+}
+
+func TestGetEnhanceHls_RedialsWhenRefused(t *testing.T) {
+	// Find a free port, then start listening on it only after the first dial
+	// has been refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	defer func(delay time.Duration) { deviceRedialDelay = delay }(deviceRedialDelay)
+	deviceRedialDelay = 200 * time.Millisecond
+
+	listening := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			listening <- nil
+			return
+		}
+		listening <- listener
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readDeviceID(conn)
+		conn.Write([]byte("https://example.com/master.m3u8\n"))
+	}()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceUrl = addr
+	url, err := sd.GetEnhanceHls("1440818839")
+
+	restarted := <-listening
+	if restarted == nil {
+		t.Skip("Port was taken before the device could restart on it")
+	}
+	defer restarted.Close()
+	if err != nil {
+		t.Fatalf("GetEnhanceHls() error = %v", err)
+	}
+	if url != "https://example.com/master.m3u8" {
+		t.Errorf("Expected the device's URL, got %q", url)
+	}
+}
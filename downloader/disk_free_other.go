@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package downloader
+
+import "errors"
+
+// diskFree is not supported on this platform
+func diskFree(dir string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// diskOverheadPercent is added to the size of a song for the decrypted
+	// copy growing next to it and the metadata written into the file
+	diskOverheadPercent = 10
+
+	// janitorMinAge keeps the janitor away from songs finished or served from
+	// the cache this recently, which are still being uploaded
+	janitorMinAge = 30 * time.Minute
+)
+
+// ErrDiskFull is the cause of downloads failing for lack of disk space
+var ErrDiskFull = errors.New("not enough disk space")
+
+// servedDownloads records when finished downloads were last served from the
+// cache. Their modification time is left alone, as upload checkpoints are
+// tied to it
+var servedDownloads = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// markServed records that the finished download at filePath was just served
+// from the cache, keeping the janitor away from it while it is uploaded
+func markServed(filePath string) {
+	servedDownloads.Lock()
+	defer servedDownloads.Unlock()
+
+	now := time.Now()
+	for path, at := range servedDownloads.at {
+		if now.Sub(at) > janitorMinAge {
+			delete(servedDownloads.at, path)
+		}
+	}
+	servedDownloads.at[filepath.Clean(filePath)] = now
+}
+
+// servedAfter reports whether the download at filePath was served from the
+// cache after cutoff
+func servedAfter(filePath string, cutoff time.Time) bool {
+	servedDownloads.Lock()
+	defer servedDownloads.Unlock()
+
+	at, ok := servedDownloads.at[filepath.Clean(filePath)]
+	return ok && at.After(cutoff)
+}
+
+// DiskUsage reports the bytes free in the file system holding dir
+type DiskUsage func(dir string) (uint64, error)
+
+// EstimateDiskSpace returns the disk space a song of contentLength bytes needs
+// while it is written, or 0 when its length is unknown
+func EstimateDiskSpace(contentLength int64) int64 {
+	if contentLength <= 0 {
+		return 0
+	}
+	return contentLength + contentLength*diskOverheadPercent/100
+}
+
+// FreeDiskSpace removes finished downloads from dir, oldest first, until
+// minFree bytes are free according to usage. Songs finished or served from the
+// cache in the last janitorMinAge and unfinished downloads are never removed.
+// It returns how many files were removed
+func FreeDiskSpace(dir string, minFree uint64, usage DiskUsage) (int, error) {
+	free, err := usage(dir)
+	if err != nil || free >= minFree {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-janitorMinAge)
+	var finished []os.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".m4a") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) || servedAfter(filepath.Join(dir, entry.Name()), cutoff) {
+			continue
+		}
+		finished = append(finished, info)
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].ModTime().Before(finished[j].ModTime()) })
+
+	removed := 0
+	for _, info := range finished {
+		if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+		logger.Info("Removed old download to free disk space", logging.String("File", info.Name()), logging.Int64("Size", info.Size()))
+
+		if free, err = usage(dir); err != nil || free >= minFree {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// checkDiskSpace fails the download unless the downloads directory has room
+// for a song of contentLength bytes, first removing old downloads when a
+// minimum is set and the song would leave less than it free. Songs of unknown
+// length and file systems whose free space cannot be read are let through
+func (sd *SongDownloaderImpl) checkDiskSpace(contentLength int64) error {
+	need := uint64(EstimateDiskSpace(contentLength))
+	if sd.diskUsage == nil || need == 0 {
+		return nil
+	}
+
+	if sd.minFreeDisk > 0 {
+		if _, err := FreeDiskSpace(sd.downloadDir, need+sd.minFreeDisk, sd.diskUsage); err != nil {
+			logger.Warn("Failed to free disk space", logging.String("Dir", sd.downloadDir), logging.Err(err))
+		}
+	}
+
+	free, err := sd.diskUsage(sd.downloadDir)
+	if err != nil {
+		logger.Debug("Skipping disk space check", logging.Err(err))
+		return nil
+	}
+	if free < need {
+		return NewDownloadErrorWithCause(ErrorFileSystemError, fmt.Sprintf("the song needs %s but only %s is free",
+			FormatBytes(int64(need)), FormatBytes(int64(free))), ErrDiskFull)
+	}
+	return nil
+}
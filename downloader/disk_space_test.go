@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDiskUsage reports base bytes free plus perFile for each of files
+// removed from dir
+func fakeDiskUsage(base, perFile uint64, files ...string) DiskUsage {
+	return func(dir string) (uint64, error) {
+		free := base
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+				free += perFile
+			}
+		}
+		return free, nil
+	}
+}
+
+func TestFreeDiskSpace_RemovesOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := map[string]time.Time{
+		"1 - Oldest - Artist.m4a": now.Add(-3 * time.Hour),
+		"2 - Older - Artist.m4a":  now.Add(-2 * time.Hour),
+		"3 - Old - Artist.m4a":    now.Add(-time.Hour),
+		"4 - Uploading.m4a":       now,
+		"notes.txt":               now.Add(-4 * time.Hour),
+	}
+	for name, modTime := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("song"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := newTempDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := fakeDiskUsage(100, 100, "1 - Oldest - Artist.m4a", "2 - Older - Artist.m4a", "3 - Old - Artist.m4a", "4 - Uploading.m4a")
+	removed, err := FreeDiskSpace(dir, 250, usage)
+	if err != nil {
+		t.Fatalf("FreeDiskSpace() error = %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 files removed, got %d", removed)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		gone := os.IsNotExist(err)
+		if want := name == "1 - Oldest - Artist.m4a" || name == "2 - Older - Artist.m4a"; gone != want {
+			t.Errorf("%s: removed = %v, want %v", name, gone, want)
+		}
+	}
+
+	// Recent songs and other files are kept even when space stays short
+	removed, err = FreeDiskSpace(dir, 1000, usage)
+	if err != nil || removed != 1 {
+		t.Errorf("FreeDiskSpace() = %d, %v, want only the remaining old song removed", removed, err)
+	}
+	for _, name := range []string{"4 - Uploading.m4a", "notes.txt", TempDirName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", name, err)
+		}
+	}
+
+	// Nothing is removed while enough space is free
+	if removed, err := FreeDiskSpace(dir, 100, usage); err != nil || removed != 0 {
+		t.Errorf("FreeDiskSpace() = %d, %v, want nothing removed", removed, err)
+	}
+}
+
+func TestExtractSong_ChecksDiskSpace(t *testing.T) {
+	body := bytes.Repeat([]byte{0xAB}, 1000)
+
+	testCases := []struct {
+		name    string
+		usage   DiskUsage
+		wantErr bool
+	}{
+		{"enough space", fakeDiskUsage(1100, 0), false},
+		{"overhead does not fit", fakeDiskUsage(1099, 0), true},
+		{"free space unknown", func(string) (uint64, error) { return 0, errors.New("unsupported") }, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, ranges := newRangeServer(t, body)
+			sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+			sd.downloadDir = t.TempDir()
+			sd.diskUsage = tc.usage
+
+			_, err := sd.extractSong(context.Background(), server.URL, ProgressCallbacks{})
+			if !tc.wantErr {
+				if errors.Is(err, ErrDiskFull) {
+					t.Fatalf("Expected the download to go ahead, got %v", err)
+				}
+				return
+			}
+
+			var downloadErr *DownloadError
+			if !errors.As(err, &downloadErr) || downloadErr.Type != ErrorFileSystemError || !errors.Is(err, ErrDiskFull) {
+				t.Fatalf("Expected a disk full error, got %v", err)
+			}
+			if got := downloadErr.UserMessage(); got != "The bot is out of disk space. Please try again later" {
+				t.Errorf("UserMessage() = %q", got)
+			}
+			if got := len(ranges()); got != 1 {
+				t.Errorf("Expected no request after the check, got %d", got)
+			}
+		})
+	}
+}
+
+func TestCheckDiskSpace_RemovesOldDownloads(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	path := filepath.Join(dir, "1 - Song - Artist.m4a")
+	if err := os.WriteFile(path, []byte("song"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.downloadDir = dir
+	sd.diskUsage = fakeDiskUsage(2000, 1000, "1 - Song - Artist.m4a")
+
+	// Without a minimum, old downloads are kept
+	if err := sd.checkDiskSpace(1000); err != nil {
+		t.Fatalf("checkDiskSpace() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the old download to be kept, got %v", err)
+	}
+
+	// The song would leave less than the minimum free
+	sd.minFreeDisk = 1000
+	if err := sd.checkDiskSpace(1000); err != nil {
+		t.Fatalf("checkDiskSpace() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the old download to be removed, got %v", err)
+	}
+}
+
+func TestFreeDiskSpace_KeepsServedDownloads(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"1 - Served - Artist.m4a", "2 - Old - Artist.m4a"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("song"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An old download served from the cache is being uploaded
+	servedPath := filepath.Join(dir, "1 - Served - Artist.m4a")
+	markServed(servedPath)
+
+	usage := fakeDiskUsage(0, 100, "1 - Served - Artist.m4a", "2 - Old - Artist.m4a")
+	removed, err := FreeDiskSpace(dir, 200, usage)
+	if err != nil {
+		t.Fatalf("FreeDiskSpace() error = %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 file removed, got %d", removed)
+	}
+	info, err := os.Stat(servedPath)
+	if err != nil {
+		t.Fatalf("Expected the served download to be kept, got %v", err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("Expected the served download's modification time to be left alone, got %v", info.ModTime())
+	}
+}
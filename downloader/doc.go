@@ -9,4 +9,6 @@
 //
 // This package is designed to be used with the Telegram bot system to provide
 // real-time progress updates during song download and upload operations.
+// Progress only goes through ProgressCallbacks; command line tools can show it
+// with a ConsoleProgressReporter.
 package downloader
\ No newline at end of file
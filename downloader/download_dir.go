@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go-alac-bot/logging"
+
+	"github.com/abema/go-mp4"
+)
+
+// TempDirName is the subdirectory of the downloads directory holding the
+// files of unfinished downloads. Each download writes into its own directory
+// under it and moves the finished file out with a rename, so a crashed
+// download never leaves a partial file where finished songs are looked up
+const TempDirName = ".incomplete"
+
+// newTempDir creates a directory for the files of one download in dir
+func newTempDir(dir string) (string, error) {
+	parent := filepath.Join(dir, TempDirName)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, "song-*")
+}
+
+// CleanStaleTemp removes the temp files of downloads in dir last modified more
+// than maxAge ago, left behind by downloads that crashed or were killed. It
+// returns how many were removed
+func CleanStaleTemp(dir string, maxAge time.Duration) (int, error) {
+	parent := filepath.Join(dir, TempDirName)
+	entries, err := os.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(parent, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// songFileName returns the name of the downloaded file of a song
+func songFileName(meta *AutoSong) string {
+	return BuildFileName(meta.ID, meta.Attributes.Name, meta.Attributes.ArtistName)
+}
+
+// maxFileNameBytes bounds the name of a downloaded file before its
+// extension, leaving room under the 255 bytes most file systems allow for the
+// " (AAC)" and " (Part n of m)" suffixes and ".m4a"
+const maxFileNameBytes = 200
+
+// forbiddenNameChars are replaced with "_" in file names, being path
+// separators or reserved on Windows
+const forbiddenNameChars = `\/<>:"|?*`
+
+// BuildFileName returns the name of the downloaded file of the song with
+// catalog ID id: "<id> - <title> - <artist>.m4a", or "<id>.m4a" when nothing
+// is left of the title and artist. Without an ID, the name starts with the
+// title, or is "song.m4a". The ID keeps songs sharing a title and
+// artist apart. Forbidden, control and bidi control characters are dropped,
+// runs of whitespace collapsed and trailing dots and spaces trimmed, and the
+// name is cut to maxFileNameBytes on a character boundary
+func BuildFileName(id, title, artist string) string {
+	id = sanitizeFileName(id, maxFileNameBytes)
+
+	var parts []string
+	for _, part := range []string{title, artist} {
+		if part = sanitizeFileName(part, maxFileNameBytes); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	name := strings.Join(parts, " - ")
+
+	switch {
+	case name == "" && id == "":
+		name = "song"
+	case name == "":
+		name = id
+	case id != "":
+		name = sanitizeFileName(id+" - "+name, maxFileNameBytes)
+	}
+	return name + ".m4a"
+}
+
+// sanitizeFileName makes s safe to use in a file name of at most maxBytes
+// bytes, as described for BuildFileName
+func sanitizeFileName(s string, maxBytes int) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(forbiddenNameChars, r):
+			return '_'
+		case unicode.IsControl(r), unicode.Is(unicode.Bidi_Control, r):
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+
+	if len(s) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return strings.TrimRight(s, ". ")
+}
+
+// aacFilePath returns the path the AAC version of the song downloaded to
+// filePath is saved to
+func aacFilePath(filePath string) string {
+	return strings.TrimSuffix(filePath, ".m4a") + " (AAC).m4a"
+}
+
+// existingDownload returns the info of a finished download at filePath. A file
+// that is not a complete, parsable M4A is removed so the song is downloaded
+// again
+func existingDownload(filePath string) (os.FileInfo, bool) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil || fileInfo.IsDir() {
+		return nil, false
+	}
+
+	err = checkComplete(filePath, fileInfo.Size())
+	if err == nil {
+		err = validateOutputFile(filePath)
+	}
+	if err != nil {
+		logger.Warn("Removing corrupt download", logging.String("File", filePath), logging.Err(err))
+		if err := os.Remove(filePath); err != nil {
+			logger.Warn("Failed to remove corrupt download", logging.String("File", filePath), logging.Err(err))
+		}
+		return nil, false
+	}
+	return fileInfo, true
+}
+
+// checkComplete reports an error unless the top-level boxes of the file at
+// filePath, among them mdat, fill it exactly. A file cut off in the middle
+// has a box running past its end
+func checkComplete(filePath string, size int64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasMdat := false
+	for end := uint64(0); end < uint64(size); {
+		info, err := mp4.ReadBoxInfo(f)
+		if err != nil {
+			return fmt.Errorf("failed to read box at %d: %w", end, err)
+		}
+		if info.Size == 0 || info.Offset+info.Size > uint64(size) {
+			return fmt.Errorf("%s box at %d runs past the end of the file", info.Type, info.Offset)
+		}
+		if info.Type == mp4.BoxTypeMdat() {
+			hasMdat = true
+		}
+		end = info.Offset + info.Size
+		if _, err := info.SeekToEnd(f); err != nil {
+			return err
+		}
+	}
+
+	if !hasMdat {
+		return errors.New("no mdat box")
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestCleanStaleTemp(t *testing.T) {
+	dir := t.TempDir()
+
+	if removed, err := CleanStaleTemp(dir, time.Hour); err != nil || removed != 0 {
+		t.Fatalf("Expected nothing to clean without a temp dir, got %d, %v", removed, err)
+	}
+
+	stale, err := newTempDir(dir)
+	if err != nil {
+		t.Fatalf("newTempDir() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stale, "Song - Artist.m4a"), []byte("partial"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("Failed to age temp dir: %v", err)
+	}
+	fresh, err := newTempDir(dir)
+	if err != nil {
+		t.Fatalf("newTempDir() error = %v", err)
+	}
+	if stale == fresh {
+		t.Fatal("Expected every download to get its own temp dir")
+	}
+
+	removed, err := CleanStaleTemp(dir, time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("CleanStaleTemp() = %d, %v, want 1 removed", removed, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("Expected the stale temp dir to be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("Expected the fresh temp dir to be kept: %v", err)
+	}
+}
+
+func TestRetagDir_SkipsUnfinishedDownloads(t *testing.T) {
+	dir := t.TempDir()
+	temp, err := newTempDir(dir)
+	if err != nil {
+		t.Fatalf("newTempDir() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(temp, "partial.m4a"), []byte("partial"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+
+	summary, err := RetagDir(dir, func(string) (*AutoSong, error) {
+		t.Error("Expected no metadata to be fetched")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("RetagDir() error = %v", err)
+	}
+	if len(summary.Skipped) != 0 || len(summary.Failed) != 0 {
+		t.Errorf("Expected the unfinished download to be ignored, got %+v", summary)
+	}
+}
+
+func TestSongFileName_KeyedByID(t *testing.T) {
+	first := retagTestMeta("Intro")
+	second := retagTestMeta("Intro")
+	second.ID = "1440857782"
+	if a, b := songFileName(first), songFileName(second); a == b {
+		t.Errorf("Expected songs sharing a title and artist to get different files, both got %q", a)
+	}
+
+	renamed := retagTestMeta("Intro (Remastered)")
+	if name := songFileName(renamed); name != "1440857781 - Intro (Remastered) - Artist.m4a" {
+		t.Errorf("songFileName() = %q", name)
+	}
+
+	slashed := retagTestMeta("AC/DC: Live?")
+	if name := songFileName(slashed); name != "1440857781 - AC_DC_ Live_ - Artist.m4a" {
+		t.Errorf("Expected forbidden characters to be replaced, got %q", name)
+	}
+}
+
+func TestBuildFileName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		id     string
+		title  string
+		artist string
+		want   string
+	}{
+		{"plain", "1", "Song", "Artist", "1 - Song - Artist.m4a"},
+		{"japanese", "2", "夜に駆ける", "YOASOBI", "2 - 夜に駆ける - YOASOBI.m4a"},
+		{"arabic", "3", "\u202Bأغنية\u202C", "فنان", "3 - أغنية - فنان.m4a"},
+		{"emoji", "4", "🔥 Fire 🔥", "Artist", "4 - 🔥 Fire 🔥 - Artist.m4a"},
+		{"control characters", "5", "Song\x00\x1b[31m\r\n", "Artist\x7f", "5 - Song[31m - Artist.m4a"},
+		{"whitespace", "6", "  Song \t\t Title  ", "Artist\u00a0 ", "6 - Song Title - Artist.m4a"},
+		{"trailing dots", "7", "Song...", "Artist. . .", "7 - Song - Artist.m4a"},
+		{"forbidden characters", "8", `<a>/b\c:"d"|e?*`, "Artist", "8 - _a__b_c__d__e__ - Artist.m4a"},
+		{"invalid utf-8", "9", "So\xffng", "Art\xc3ist", "9 - Song - Artist.m4a"},
+		{"empty title", "10", "", "Artist", "10 - Artist.m4a"},
+		{"nothing left", "11", " ... ", "\u200f\x00", "11.m4a"},
+		{"dots only", "12", "..", ".", "12.m4a"},
+		{"no id", "", "Song", "Artist", "Song - Artist.m4a"},
+		{"nothing at all", "", "", "", "song.m4a"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := BuildFileName(tc.id, tc.title, tc.artist); got != tc.want {
+				t.Errorf("BuildFileName(%q, %q, %q) = %q, want %q", tc.id, tc.title, tc.artist, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildFileName_Truncates(t *testing.T) {
+	titles := []string{
+		strings.Repeat("a", 300),
+		strings.Repeat("é", 150),
+		strings.Repeat("夜", 100),
+		strings.Repeat("🔥", 75),
+		strings.Repeat("a", 195) + "..........",
+	}
+
+	for _, title := range titles {
+		name := BuildFileName("1440857781", title, "Artist")
+		stem := strings.TrimSuffix(name, ".m4a")
+		if len(stem) > maxFileNameBytes {
+			t.Errorf("Expected at most %d bytes, got %d in %q", maxFileNameBytes, len(stem), name)
+		}
+		if !utf8.ValidString(name) {
+			t.Errorf("Expected valid UTF-8, got %q", name)
+		}
+		if !strings.HasPrefix(name, "1440857781 - ") {
+			t.Errorf("Expected the ID to be kept, got %q", name)
+		}
+		if strings.HasSuffix(stem, ".") || strings.HasSuffix(stem, " ") {
+			t.Errorf("Expected no trailing dots or spaces, got %q", name)
+		}
+	}
+}
+
+func TestExistingDownload(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing.m4a")
+	if _, ok := existingDownload(missing); ok {
+		t.Error("Expected a miss for a file that does not exist")
+	}
+
+	data, err := os.ReadFile(writeBotFixture(t, retagTestMeta("Name")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := filepath.Join(dir, "complete.m4a")
+	if err := os.WriteFile(complete, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := existingDownload(complete); !ok || info.Size() != int64(len(data)) {
+		t.Errorf("Expected a hit for a complete file, got %v", ok)
+	}
+
+	// A leftover cut off in the middle is removed to be downloaded again
+	corrupt := filepath.Join(dir, "corrupt.m4a")
+	if err := os.WriteFile(corrupt, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := existingDownload(corrupt); ok {
+		t.Error("Expected a miss for a truncated file")
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("Expected the truncated file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// downloadHolds counts the jobs holding each finished download. Jobs for the
+// same song share its file in the downloads directory, so it is deleted only
+// once the last of them is done with it
+var downloadHolds = struct {
+	sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+// holdDownload takes a hold on the finished download at filePath. Taken before
+// the file is looked up, it keeps another job's release from deleting it
+// between the lookup and the upload
+func holdDownload(filePath string) {
+	downloadHolds.Lock()
+	defer downloadHolds.Unlock()
+
+	downloadHolds.count[filepath.Clean(filePath)]++
+}
+
+// ReleaseDownload drops the hold a DownloadResult has on its file. With remove
+// set, as after a successful upload, the file is deleted unless another job
+// still holds it. Split parts are not shared and are always deleted, along
+// with their directory once it is empty. It reports whether the file was
+// deleted
+func ReleaseDownload(filePath string, remove bool) (bool, error) {
+	downloadHolds.Lock()
+	defer downloadHolds.Unlock()
+
+	key := filepath.Clean(filePath)
+	if count := downloadHolds.count[key]; count > 1 {
+		downloadHolds.count[key] = count - 1
+		return false, nil
+	}
+	delete(downloadHolds.count, key)
+	if !remove {
+		return false, nil
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if dir := filepath.Dir(filePath); filepath.Base(filepath.Dir(dir)) == TempDirName {
+		os.Remove(dir)
+	}
+	return true, nil
+}
+
+// holdFile makes the job hold the download at filePath instead of the one it
+// held before, if any
+func (sd *SongDownloaderImpl) holdFile(filePath string) {
+	if sd.heldFile != "" {
+		ReleaseDownload(sd.heldFile, false)
+	}
+	holdDownload(filePath)
+	sd.heldFile = filePath
+}
+
+// handOverHold hands the job's hold on to the result of a finished download,
+// which ReleaseDownload drops once the file is uploaded
+func (sd *SongDownloaderImpl) handOverHold() {
+	sd.heldFile = ""
+}
+
+// releaseHold drops the hold of a download that failed
+func (sd *SongDownloaderImpl) releaseHold() {
+	if sd.heldFile != "" {
+		ReleaseDownload(sd.heldFile, false)
+		sd.heldFile = ""
+	}
+}
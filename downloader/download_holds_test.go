@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownload_ConcurrentJobsShareCachedFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/catalog/us/songs/1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","artistName":"Artist","durationInMillis":180000,
+			"extendedAssetUrls":{"enhancedHls":"https://example.com/master.m3u8"}}}]}`)
+	}))
+	defer server.Close()
+	device := newFakeDevice(t, func(conn net.Conn) {
+		if _, err := readDeviceID(conn); err == nil {
+			conn.Write([]byte("https://example.com/master.m3u8\n"))
+		}
+	})
+
+	// The song was downloaded before
+	dir := t.TempDir()
+	data, err := os.ReadFile(writeBotFixture(t, retagTestMeta("Song")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(dir, songFileName(&AutoSong{ID: "1", Attributes: SongAttributes{Name: "Song", ArtistName: "Artist"}}))
+	if err := os.WriteFile(cached, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two chats ask for it at the same time
+	results := make([]*DownloadResult, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+		sd.staticToken = testToken
+		sd.apple.catalogURL = server.URL
+		sd.deviceUrl = device
+		sd.downloadDir = dir
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := sd.Download(context.Background(), "https://music.apple.com/us/song/song/1", ProgressCallbacks{})
+			if err != nil {
+				t.Errorf("Download() error = %v", err)
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if results[0].FilePath != cached || results[1].FilePath != cached {
+		t.Fatalf("Expected both jobs to be served %s, got %s and %s", cached, results[0].FilePath, results[1].FilePath)
+	}
+
+	// The first upload finishes while the second is still going
+	if deleted, err := ReleaseDownload(results[0].FilePath, true); err != nil || deleted {
+		t.Fatalf("Expected the file to be kept for the other job, got %v, %v", deleted, err)
+	}
+	if info, err := os.Stat(results[1].FilePath); err != nil || info.Size() != results[1].FileSize {
+		t.Fatalf("Expected the second job to still find its file, got %v", err)
+	}
+
+	if deleted, err := ReleaseDownload(results[1].FilePath, true); err != nil || !deleted {
+		t.Fatalf("Expected the last job to delete the file, got %v, %v", deleted, err)
+	}
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be gone, got %v", err)
+	}
+}
+
+func TestReleaseDownload_FailedUploadKeepsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	if err := os.WriteFile(path, []byte("song"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	holdDownload(path)
+	if deleted, err := ReleaseDownload(path, false); err != nil || deleted {
+		t.Fatalf("ReleaseDownload() = %v, %v", deleted, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file to be kept for a retry, got %v", err)
+	}
+
+	downloadHolds.Lock()
+	defer downloadHolds.Unlock()
+	if _, ok := downloadHolds.count[path]; ok {
+		t.Error("Expected the hold to be dropped")
+	}
+}
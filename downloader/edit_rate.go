@@ -0,0 +1,309 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// BaseEditInterval is the least time between two progress edits of one
+	// message when the bot is not under pressure
+	BaseEditInterval = 2 * time.Second
+
+	// MaxEditInterval is how far the interval is stretched under pressure
+	MaxEditInterval = 8 * time.Second
+
+	// DefaultSafeEditRate is the number of progress edits per second, across
+	// all messages, the bot keeps below
+	DefaultSafeEditRate = 3.0
+
+	// minEditRate is the lowest the edit rate limit is cut to by FLOOD_WAITs
+	minEditRate = 0.5
+
+	// editRateStep is how much the limit grows back per quiet window
+	editRateStep = 0.5
+
+	// editRateWindow is how much history the demand is measured over, and how
+	// often the interval may change
+	editRateWindow = 10 * time.Second
+
+	// floodWaitCooldown is how long after a FLOOD_WAIT neither the interval
+	// shrinks nor the limit grows back
+	floodWaitCooldown = 2 * time.Minute
+
+	// FloodWaitStatsWindow is how far back FLOOD_WAITs are counted in stats
+	FloodWaitStatsWindow = 10 * time.Minute
+)
+
+// EditRateStats is the state of an EditRateController shown by /stats
+type EditRateStats struct {
+	Interval   time.Duration // Current least time between edits of one message
+	Rate       float64       // Edits per second over the last window
+	Limit      float64       // Current limit on edits per second
+	FloodWaits int           // FLOOD_WAITs within FloodWaitStatsWindow
+}
+
+// EditRateController paces the progress edits of every reporter. It holds
+// edits back to keep the global rate below a limit, which each FLOOD_WAIT halves
+// and quiet windows grow back to the safe rate. It stretches the per-message
+// interval (2s → 4s → 8s) when reporters want more edits than the limit or
+// Telegram answers with FLOOD_WAIT, and shrinks it back once the load drops
+type EditRateController struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	safeRate float64
+	limit    float64
+	interval time.Duration
+
+	lastChange time.Time
+	edits      []time.Time // Edits within editRateWindow
+	demand     []time.Time // Edits wanted within editRateWindow, allowed or not
+	floodWaits []time.Time // FLOOD_WAITs within FloodWaitStatsWindow
+	floodUntil time.Time   // No edits before this
+}
+
+// NewEditRateController creates a controller keeping edits below
+// DefaultSafeEditRate
+func NewEditRateController() *EditRateController {
+	return &EditRateController{
+		now:      time.Now,
+		safeRate: DefaultSafeEditRate,
+		limit:    DefaultSafeEditRate,
+		interval: BaseEditInterval,
+	}
+}
+
+// Stats returns the current interval, edit rate and recent FLOOD_WAITs
+func (c *EditRateController) Stats() EditRateStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.adjustLocked(c.now())
+	return EditRateStats{
+		Interval:   c.interval,
+		Rate:       float64(len(c.edits)) / editRateWindow.Seconds(),
+		Limit:      c.limit,
+		FloodWaits: len(c.floodWaits),
+	}
+}
+
+// NewPacer creates the pacer of one reporter
+func (c *EditRateController) NewPacer() *EditPacer {
+	return &EditPacer{controller: c}
+}
+
+// allow reports whether an edit whose message was last edited at last may go
+// out now, recording it if so
+func (c *EditRateController) allow(last time.Time) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.adjustLocked(now)
+
+	if now.Before(c.floodUntil) || (!last.IsZero() && now.Sub(last) < c.interval) {
+		return now, false
+	}
+	c.demand = append(c.demand, now)
+	if c.overLimitLocked(now) {
+		return now, false
+	}
+
+	c.recordEditLocked(now)
+	return now, true
+}
+
+// reserve records an edit if neither a FLOOD_WAIT nor the limit holds it
+// back, or returns how long to wait before asking again
+func (c *EditRateController) reserve() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.adjustLocked(now)
+
+	if now.Before(c.floodUntil) {
+		return c.floodUntil.Sub(now), false
+	}
+	if c.overLimitLocked(now) {
+		return time.Duration(float64(time.Second) / c.limit), false
+	}
+
+	c.demand = append(c.demand, now)
+	c.recordEditLocked(now)
+	return 0, true
+}
+
+// overLimitLocked reports whether another edit at now would exceed the limit.
+// Both the average over the window and bursts within a second stay below it
+// (must be called with lock held)
+func (c *EditRateController) overLimitLocked(now time.Time) bool {
+	burst := max(1, int(c.limit))
+	return float64(len(c.edits)) >= c.limit*editRateWindow.Seconds() || c.editsSinceLocked(now.Add(-time.Second)) >= burst
+}
+
+// force records an edit that goes out regardless of the pacing, like a
+// completion or error message
+func (c *EditRateController) force() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.adjustLocked(now)
+	c.recordEditLocked(now)
+	return now
+}
+
+// result records the outcome of an edit, pausing every edit for as long as
+// a FLOOD_WAIT asks
+func (c *EditRateController) result(err error) {
+	wait, ok := IsFloodWait(err)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.floodWaits = append(c.floodWaits, now)
+	if until := now.Add(wait); until.After(c.floodUntil) {
+		c.floodUntil = until
+	}
+	c.limit = max(c.limit/2, minEditRate)
+	c.adjustLocked(now)
+}
+
+// recordEditLocked records an edit sent at now (must be called with lock held)
+func (c *EditRateController) recordEditLocked(now time.Time) {
+	c.edits = append(c.edits, now)
+}
+
+// editsSinceLocked counts the edits after since (must be called with lock held)
+func (c *EditRateController) editsSinceLocked(since time.Time) int {
+	count := 0
+	for i := len(c.edits) - 1; i >= 0 && c.edits[i].After(since); i-- {
+		count++
+	}
+	return count
+}
+
+// adjustLocked drops history older than its window and, at most once per
+// window, changes the interval: stretched when the demand exceeds the limit
+// or a FLOOD_WAIT came in, shrunk when half the interval would still be well
+// below it. Quiet windows grow the limit back (must be called with lock held)
+func (c *EditRateController) adjustLocked(now time.Time) {
+	c.edits = pruneBefore(c.edits, now.Add(-editRateWindow))
+	c.demand = pruneBefore(c.demand, now.Add(-editRateWindow))
+	c.floodWaits = pruneBefore(c.floodWaits, now.Add(-FloodWaitStatsWindow))
+
+	if c.lastChange.IsZero() {
+		c.lastChange = now
+	}
+	if now.Sub(c.lastChange) < editRateWindow {
+		return
+	}
+	c.lastChange = now
+
+	sinceFloodWait := FloodWaitStatsWindow
+	if len(c.floodWaits) > 0 {
+		sinceFloodWait = now.Sub(c.floodWaits[len(c.floodWaits)-1])
+	}
+	quiet := sinceFloodWait >= floodWaitCooldown
+	if quiet {
+		c.limit = min(c.limit+editRateStep, c.safeRate)
+	}
+
+	demandRate := float64(len(c.demand)) / editRateWindow.Seconds()
+	switch {
+	case demandRate > c.limit || sinceFloodWait < editRateWindow:
+		if c.interval < MaxEditInterval {
+			c.interval *= 2
+			c.demand = nil
+		}
+	case demandRate*2 < c.limit*0.8 && quiet:
+		if c.interval > BaseEditInterval {
+			c.interval /= 2
+			c.demand = nil
+		}
+	}
+}
+
+// pruneBefore drops the times before cutoff from the sorted times
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// EditPacer asks an EditRateController for permission before each edit of
+// one message. A nil pacer allows everything
+type EditPacer struct {
+	mu         sync.Mutex
+	controller *EditRateController
+	last       time.Time
+}
+
+// Allow reports whether a periodic edit may go out now. Edits that are not
+// allowed should be skipped; a later one will show the newer state
+func (p *EditPacer) Allow() bool {
+	if p == nil {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now, ok := p.controller.allow(p.last)
+	if ok {
+		p.last = now
+	}
+	return ok
+}
+
+// Wait blocks until an edit that must not be skipped may go out, recording it.
+// It waits out FLOOD_WAITs and the limit but not the per-message interval, so
+// one pacer can be shared by edits of many messages
+func (p *EditPacer) Wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	for {
+		delay, ok := p.controller.reserve()
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Force records an edit that must go out regardless of the pacing
+func (p *EditPacer) Force() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = p.controller.force()
+}
+
+// Result records the outcome of an edit
+func (p *EditPacer) Result(err error) {
+	if p == nil || err == nil {
+		return
+	}
+	p.controller.result(err)
+}
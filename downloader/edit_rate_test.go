@@ -0,0 +1,289 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// fakeClock is a manually advanced clock shared by a controller and floodAPI
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// floodAPI answers edits with FLOOD_WAIT once limit edits were accepted
+// within the last second
+type floodAPI struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	limit      int
+	wait       int
+	edits      []time.Time
+	floodWaits []time.Time
+}
+
+func (a *floodAPI) MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error) {
+	return &tg.UpdateShortSentMessage{ID: 1}, nil
+}
+
+func (a *floodAPI) MessagesEditMessage(ctx context.Context, request *tg.MessagesEditMessageRequest) (tg.UpdatesClass, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if a.countLocked(a.edits, now.Add(-time.Second), now) >= a.limit {
+		a.floodWaits = append(a.floodWaits, now)
+		return nil, &tgerr.Error{Code: 420, Message: fmt.Sprintf("FLOOD_WAIT_%d", a.wait), Type: tgerr.ErrFloodWait, Argument: a.wait}
+	}
+	a.edits = append(a.edits, now)
+	return &tg.Updates{}, nil
+}
+
+func (a *floodAPI) MessagesSendReaction(ctx context.Context, request *tg.MessagesSendReactionRequest) (tg.UpdatesClass, error) {
+	return &tg.Updates{}, nil
+}
+
+// countLocked counts the times in (from, to] (must be called with lock held)
+func (a *floodAPI) countLocked(times []time.Time, from, to time.Time) int {
+	count := 0
+	for _, t := range times {
+		if t.After(from) && !t.After(to) {
+			count++
+		}
+	}
+	return count
+}
+
+// counts returns the edits and FLOOD_WAITs within the last d
+func (a *floodAPI) counts(d time.Duration) (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	return a.countLocked(a.edits, now.Add(-d), now), a.countLocked(a.floodWaits, now.Add(-d), now)
+}
+
+// maxPerSecond returns the most edits accepted within any second of the last d
+func (a *floodAPI) maxPerSecond(d time.Duration) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	most := 0
+	for _, t := range a.edits {
+		if t.After(a.now().Add(-d)) {
+			most = max(most, a.countLocked(a.edits, t.Add(-time.Second), t))
+		}
+	}
+	return most
+}
+
+// editSimulation runs reporters paced by one controller against a floodAPI,
+// each ticking every BaseEditInterval like its ProgressTracker, staggered
+type editSimulation struct {
+	clock      *fakeClock
+	api        *floodAPI
+	controller *EditRateController
+	reporters  []*TelegramProgressReporter
+}
+
+func newEditSimulation(t *testing.T, reporters int, safeRate float64, apiLimit int) *editSimulation {
+	t.Helper()
+
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sim := &editSimulation{
+		clock:      clock,
+		api:        &floodAPI{now: clock.now, limit: apiLimit, wait: 3},
+		controller: NewEditRateController(),
+	}
+	sim.controller.now = clock.now
+	sim.controller.safeRate, sim.controller.limit = safeRate, safeRate
+
+	for i := 0; i < reporters; i++ {
+		reporter := NewTelegramProgressReporter(sim.api)
+		reporter.SetEditRateController(sim.controller)
+		if err := reporter.StartTracking(context.Background(), int64(i+1), "Song"); err != nil {
+			t.Fatalf("StartTracking failed: %v", err)
+		}
+		sim.reporters = append(sim.reporters, reporter)
+	}
+	return sim
+}
+
+// run advances the clock by d in 100ms steps, ticking active reporters spread
+// evenly over the others, as if the rest had finished
+func (s *editSimulation) run(d time.Duration, active int) {
+	const step = 100 * time.Millisecond
+	ticksPerInterval := int(BaseEditInterval / step)
+
+	for i := 0; i < int(d/step); i++ {
+		s.clock.advance(step)
+		for r, reporter := range s.reporters {
+			if r%(len(s.reporters)/active) != 0 {
+				continue
+			}
+			if (i+r*ticksPerInterval/len(s.reporters))%ticksPerInterval == 0 {
+				reporter.UpdateProgress(PhaseDownloading, Progress{TotalBytes: 100, BytesProcessed: int64(i % 100), Percentage: float64(i % 100)})
+			}
+		}
+	}
+}
+
+func TestEditRateController_ConvergesUnderLoadAndRecovers(t *testing.T) {
+	sim := newEditSimulation(t, 40, DefaultSafeEditRate, 5)
+
+	// 40 reporters at 2s want 20 edits per second
+	sim.run(90*time.Second, 40)
+
+	stats := sim.controller.Stats()
+	if stats.Interval != MaxEditInterval {
+		t.Errorf("Expected the interval to stretch to %v, got %v", MaxEditInterval, stats.Interval)
+	}
+	edits, floodWaits := sim.api.counts(30 * time.Second)
+	if rate := float64(edits) / 30; rate > DefaultSafeEditRate {
+		t.Errorf("Expected at most %.1f edits per second, got %.2f", DefaultSafeEditRate, rate)
+	}
+	if floodWaits != 0 || stats.FloodWaits != 0 {
+		t.Errorf("Expected no FLOOD_WAIT, got %d", floodWaits)
+	}
+	if most := sim.api.maxPerSecond(30 * time.Second); most > int(DefaultSafeEditRate)+1 {
+		t.Errorf("Expected no bursts above the safe rate, got %d edits in one second", most)
+	}
+
+	// The load drops to 4 reporters
+	sim.run(90*time.Second, 4)
+
+	if interval := sim.controller.Stats().Interval; interval != BaseEditInterval {
+		t.Errorf("Expected the interval to shrink back to %v, got %v", BaseEditInterval, interval)
+	}
+	if edits, _ := sim.api.counts(20 * time.Second); edits < 36 {
+		t.Errorf("Expected the remaining reporters to edit every 2s again, got %d edits in 20s", edits)
+	}
+}
+
+func TestEditRateController_BacksOffOnFloodWait(t *testing.T) {
+	// The safe rate is above what this API tolerates, so only FLOOD_WAITs
+	// can teach the controller
+	sim := newEditSimulation(t, 10, 10, 2)
+
+	sim.run(60*time.Second, 10)
+
+	stats := sim.controller.Stats()
+	if stats.FloodWaits == 0 {
+		t.Fatal("Expected the API to answer with FLOOD_WAIT at first")
+	}
+	if stats.Interval <= BaseEditInterval || stats.Limit >= 10 {
+		t.Errorf("Expected the interval to stretch and the limit to drop, got %v and %.2f/s", stats.Interval, stats.Limit)
+	}
+
+	// Converged: edits keep going out without new FLOOD_WAITs
+	before := stats.FloodWaits
+	sim.run(60*time.Second, 10)
+	edits, floodWaits := sim.api.counts(60 * time.Second)
+	if floodWaits != 0 || sim.controller.Stats().FloodWaits != before {
+		t.Errorf("Expected no FLOOD_WAIT once converged, got %d", floodWaits)
+	}
+	if edits == 0 {
+		t.Error("Expected progress edits to continue")
+	}
+
+	// Long after the last FLOOD_WAIT the interval shrinks and the limit grows back
+	sim.run(5*time.Minute, 1)
+	stats = sim.controller.Stats()
+	if stats.Interval != BaseEditInterval || stats.Limit <= minEditRate {
+		t.Errorf("Expected the controller to recover, got %v and %.2f/s", stats.Interval, stats.Limit)
+	}
+}
+
+func TestEditPacer_ForcedEditsAndNil(t *testing.T) {
+	var pacer *EditPacer
+	if !pacer.Allow() {
+		t.Error("Expected a nil pacer to allow every edit")
+	}
+	pacer.Force()
+	pacer.Result(fmt.Errorf("boom"))
+
+	clock := &fakeClock{t: time.Now()}
+	controller := NewEditRateController()
+	controller.now = clock.now
+	pacer = controller.NewPacer()
+
+	// A completion goes out right after a periodic edit, and counts towards the rate
+	if !pacer.Allow() {
+		t.Fatal("Expected the first edit to be allowed")
+	}
+	pacer.Force()
+	if pacer.Allow() {
+		t.Error("Expected the next periodic edit to wait for the interval")
+	}
+	if rate := controller.Stats().Rate; rate != 0.2 {
+		t.Errorf("Expected 2 edits in the window, got a rate of %.2f/s", rate)
+	}
+
+	// A FLOOD_WAIT holds every edit back for as long as it asks
+	pacer.Result(&tgerr.Error{Code: 420, Type: tgerr.ErrFloodWait, Argument: 30})
+	clock.advance(20 * time.Second)
+	if controller.NewPacer().Allow() {
+		t.Error("Expected edits to wait out the FLOOD_WAIT")
+	}
+	clock.advance(11 * time.Second)
+	if !controller.NewPacer().Allow() {
+		t.Error("Expected edits to resume after the FLOOD_WAIT")
+	}
+}
+
+func TestEditPacer_Wait(t *testing.T) {
+	var pacer *EditPacer
+	if err := pacer.Wait(context.Background()); err != nil {
+		t.Errorf("Expected a nil pacer not to wait, got %v", err)
+	}
+
+	clock := &fakeClock{t: time.Now()}
+	controller := NewEditRateController()
+	controller.now = clock.now
+	pacer = controller.NewPacer()
+
+	// Edits of different messages don't wait for the interval, only the limit
+	for i := 0; i < int(DefaultSafeEditRate); i++ {
+		if err := pacer.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pacer.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected a burst above the limit to wait, got %v", err)
+	}
+	clock.advance(time.Second)
+	if err := pacer.Wait(context.Background()); err != nil {
+		t.Errorf("Expected the next second to allow an edit, got %v", err)
+	}
+
+	// A FLOOD_WAIT holds waiting edits back for as long as it asks
+	clock.advance(time.Second)
+	pacer.Result(&tgerr.Error{Code: 420, Type: tgerr.ErrFloodWait, Argument: 30})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pacer.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected edits to wait out the FLOOD_WAIT, got %v", err)
+	}
+	clock.advance(31 * time.Second)
+	if err := pacer.Wait(context.Background()); err != nil {
+		t.Errorf("Expected edits to resume after the FLOOD_WAIT, got %v", err)
+	}
+}
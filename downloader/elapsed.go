@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"math"
+	"time"
+)
+
+// MaxRenderedDuration is the longest elapsed time or ETA shown as is; longer
+// ones are rendered as ">24h"
+const MaxRenderedDuration = 24 * time.Hour
+
+// Elapsed returns the time from start to now, never negative. Both should
+// come from time.Now in this process: their monotonic readings then make the
+// result immune to wall clock steps. Times without one (parsed, serialized,
+// or stripped by Round(0), UTC or In) fall back to the wall clock, which a
+// step can move backwards, hence the clamp
+func Elapsed(start, now time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	if d := now.Sub(start); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// EstimateETA returns how long the remaining bytes take at bytesPerSecond,
+// 0 when unknown and capped just above MaxRenderedDuration so slow transfers
+// cannot overflow the duration
+func EstimateETA(remaining, bytesPerSecond int64) time.Duration {
+	if remaining <= 0 || bytesPerSecond <= 0 {
+		return 0
+	}
+
+	seconds := math.Ceil(float64(remaining) / float64(bytesPerSecond))
+	if seconds > MaxRenderedDuration.Seconds() {
+		return MaxRenderedDuration + time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// FormatDuration renders an elapsed time or ETA to the second. Negative
+// values render as 0s and values beyond MaxRenderedDuration as ">24h"
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "0s"
+	case d > MaxRenderedDuration:
+		return ">24h"
+	default:
+		return d.Round(time.Second).String()
+	}
+}
@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// wallOnly strips the monotonic reading, as parsing or serializing a Time does
+func wallOnly(t time.Time) time.Time {
+	return t.Round(0)
+}
+
+func TestElapsed(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		start time.Time
+		now   time.Time
+		want  time.Duration
+	}{
+		{name: "monotonic", start: now.Add(-90 * time.Second), now: now, want: 90 * time.Second},
+		{name: "zero start", start: time.Time{}, now: now, want: 0},
+		{name: "wall clock stepped back", start: wallOnly(now).Add(time.Hour), now: wallOnly(now), want: 0},
+		{name: "wall clock stepped forward", start: wallOnly(now).Add(-30 * time.Second), now: wallOnly(now), want: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Elapsed(tt.start, tt.now); got != tt.want {
+				t.Errorf("Elapsed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateETA(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining int64
+		speed     int64
+		want      time.Duration
+	}{
+		{name: "unknown speed", remaining: 100, speed: 0, want: 0},
+		{name: "done", remaining: 0, speed: 100, want: 0},
+		{name: "overshoot", remaining: -5, speed: 100, want: 0},
+		{name: "rounded up", remaining: 250, speed: 100, want: 3 * time.Second},
+		{name: "capped", remaining: 1 << 60, speed: 1, want: MaxRenderedDuration + time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateETA(tt.remaining, tt.speed); got != tt.want {
+				t.Errorf("EstimateETA(%d, %d) = %v, want %v", tt.remaining, tt.speed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: -5 * time.Minute, want: "0s"},
+		{d: 0, want: "0s"},
+		{d: 1499 * time.Millisecond, want: "1s"},
+		{d: 90 * time.Minute, want: "1h30m0s"},
+		{d: MaxRenderedDuration, want: "24h0m0s"},
+		{d: MaxRenderedDuration + time.Second, want: ">24h"},
+		{d: time.Duration(1<<63 - 1), want: ">24h"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestTelegramProgressReporter_FormatProgressMessage_ClockSkew(t *testing.T) {
+	reporter := NewTelegramProgressReporter(NewMockTelegramAPI())
+	progress := Progress{TotalBytes: 100, BytesProcessed: 1, Speed: 1, ETA: EstimateETA(1<<40, 1), Percentage: 1}
+
+	tests := []struct {
+		name        string
+		start       time.Time
+		wantElapsed string
+	}{
+		{name: "start after a step back", start: wallOnly(time.Now()).Add(2 * time.Hour), wantElapsed: "⏱️ Elapsed: 0s"},
+		{name: "start decades ago", start: time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), wantElapsed: "⏱️ Elapsed: >24h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := reporter.formatProgressMessage("Song", PhaseDownloading, progress, tt.start)
+			if !strings.Contains(message, tt.wantElapsed) {
+				t.Errorf("Expected %q in message:\n%s", tt.wantElapsed, message)
+			}
+			if !strings.Contains(message, "ETA: >24h") {
+				t.Errorf("Expected the ETA to be rendered as >24h:\n%s", message)
+			}
+			if strings.Contains(message, "-") {
+				t.Errorf("Expected no negative values:\n%s", message)
+			}
+		})
+	}
+}
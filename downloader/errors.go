@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -16,6 +17,10 @@ const (
 	ErrorTimeout
 	ErrorCancelled
 	ErrorUnknown
+	ErrorTokenUnavailable
+	ErrorNotReleased
+	ErrorBackendUnavailable
+	ErrorFileTooLarge
 )
 
 // String returns the string representation of the error type
@@ -37,6 +42,14 @@ func (et ErrorType) String() string {
 		return "cancelled"
 	case ErrorUnknown:
 		return "unknown"
+	case ErrorTokenUnavailable:
+		return "token_unavailable"
+	case ErrorNotReleased:
+		return "not_released"
+	case ErrorBackendUnavailable:
+		return "backend_unavailable"
+	case ErrorFileTooLarge:
+		return "file_too_large"
 	default:
 		return "unknown"
 	}
@@ -91,14 +104,74 @@ func (de *DownloadError) WithContext(key string, value interface{}) *DownloadErr
 	return de
 }
 
+// IsRetryable reports whether trying the same download again later may
+// succeed, as with network failures and timeouts
+func (de *DownloadError) IsRetryable() bool {
+	switch de.Type {
+	case ErrorNetworkFailure, ErrorTimeout, ErrorTokenUnavailable, ErrorBackendUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// UserMessage returns the message shown to users for the error. Types without
+// a specific message show Message
+func (de *DownloadError) UserMessage() string {
+	// The device service timing out is not the download taking too long
+	if IsDeviceTimeout(de.Cause) {
+		return "The device service timed out. Please try again in a moment"
+	}
+	if errors.Is(de.Cause, ErrDiskFull) {
+		return "The bot is out of disk space. Please try again later"
+	}
+
+	switch de.Type {
+	case ErrorALACNotAvailable:
+		return "This song isn't available in lossless ALAC"
+	case ErrorInvalidURL:
+		return "That doesn't look like a valid Apple Music link"
+	case ErrorDecryptionFailure:
+		return "The song couldn't be decrypted. Please try again later"
+	case ErrorNetworkFailure:
+		return "Apple Music couldn't be reached. Please try again in a moment"
+	case ErrorTimeout:
+		return "The download took too long. Please try again"
+	case ErrorCancelled:
+		return "The download was cancelled"
+	case ErrorFileSystemError:
+		return "The song couldn't be saved. Please try again later"
+	case ErrorTokenUnavailable:
+		return "Apple Music access is unavailable right now. Please try again later"
+	case ErrorNotReleased:
+		return "This song hasn't been released yet"
+	case ErrorBackendUnavailable:
+		return "The decryption backend is unavailable. Please try again later"
+	case ErrorFileTooLarge:
+		return "This song is too large to upload to Telegram. Try requesting the AAC version instead"
+	default:
+		return de.Message
+	}
+}
+
 // IsType checks if the error is of a specific type
 func (de *DownloadError) IsType(errorType ErrorType) bool {
 	return de.Type == errorType
 }
 
-// IsDownloadError checks if an error is a DownloadError and optionally of a specific type
+// AsDownloadError returns the first DownloadError in the chain of err
+func AsDownloadError(err error) (*DownloadError, bool) {
+	var de *DownloadError
+	if errors.As(err, &de) {
+		return de, true
+	}
+	return nil, false
+}
+
+// IsDownloadError checks if an error, or one it wraps, is a DownloadError and
+// optionally of a specific type
 func IsDownloadError(err error, errorType ...ErrorType) bool {
-	if de, ok := err.(*DownloadError); ok {
+	if de, ok := AsDownloadError(err); ok {
 		if len(errorType) == 0 {
 			return true
 		}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDownloadError_RetryableAndUserMessage(t *testing.T) {
+	tests := []struct {
+		errorType   ErrorType
+		retryable   bool
+		userMessage string
+	}{
+		{ErrorALACNotAvailable, false, "This song isn't available in lossless ALAC"},
+		{ErrorInvalidURL, false, "That doesn't look like a valid Apple Music link"},
+		{ErrorDecryptionFailure, false, "The song couldn't be decrypted. Please try again later"},
+		{ErrorNetworkFailure, true, "Apple Music couldn't be reached. Please try again in a moment"},
+		{ErrorTimeout, true, "The download took too long. Please try again"},
+		{ErrorCancelled, false, "The download was cancelled"},
+		{ErrorFileSystemError, false, "The song couldn't be saved. Please try again later"},
+		{ErrorTokenUnavailable, true, "Apple Music access is unavailable right now. Please try again later"},
+		{ErrorNotReleased, false, "This song hasn't been released yet"},
+		{ErrorBackendUnavailable, true, "The decryption backend is unavailable. Please try again later"},
+		{ErrorFileTooLarge, false, "This song is too large to upload to Telegram. Try requesting the AAC version instead"},
+		{ErrorUnknown, false, "internal detail"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.errorType.String(), func(t *testing.T) {
+			err := NewDownloadError(test.errorType, "internal detail")
+			if got := err.IsRetryable(); got != test.retryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, test.retryable)
+			}
+			if got := err.UserMessage(); got != test.userMessage {
+				t.Errorf("UserMessage() = %q, want %q", got, test.userMessage)
+			}
+		})
+	}
+}
+
+func TestDownloadError_Chain(t *testing.T) {
+	cause := fmt.Errorf("dial decryptor: %w", context.DeadlineExceeded)
+	err := fmt.Errorf("download failed: %w", NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to decrypt", cause))
+
+	de, ok := AsDownloadError(err)
+	if !ok || de.Type != ErrorNetworkFailure {
+		t.Fatalf("Expected the wrapped DownloadError, got %v", de)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected errors.Is to reach the cause through the DownloadError")
+	}
+	if !IsDownloadError(err, ErrorNetworkFailure) {
+		t.Error("Expected IsDownloadError to see through wrapping")
+	}
+
+	if _, ok := AsDownloadError(errors.New("plain")); ok {
+		t.Error("Expected plain errors not to be DownloadErrors")
+	}
+}
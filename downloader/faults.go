@@ -0,0 +1,255 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go-alac-bot/logging"
+)
+
+// FaultPoint is a place in the download pipeline where a fault can be injected
+type FaultPoint string
+
+const (
+	FaultToken    FaultPoint = "token"    // Each token page or bundle fetch
+	FaultCatalog  FaultPoint = "catalog"  // Each catalog API call
+	FaultManifest FaultPoint = "manifest" // HLS master playlist fetch
+	FaultMedia    FaultPoint = "media"    // Encrypted song download, at a byte offset
+	FaultDecrypt  FaultPoint = "decrypt"  // Decryption session, after a number of samples
+	FaultWrite    FaultPoint = "write"    // Creating the output file
+)
+
+// FaultAction is how an injected fault fails
+type FaultAction string
+
+const (
+	FaultReset   FaultAction = "reset"   // Connection reset by peer
+	FaultTimeout FaultAction = "timeout" // I/O timeout
+	FaultFail    FaultAction = "error"   // Generic failure
+)
+
+// Fault is one entry of a fault plan
+type Fault struct {
+	Point  FaultPoint
+	Action FaultAction
+
+	// Where the fault fires: media faults at Percent of the body or after
+	// Bytes, decrypt faults once Samples samples were decrypted
+	Percent float64
+	Bytes   int64
+	Samples int
+
+	// Times is how often the fault fires before the point works again
+	// (0 = every time)
+	Times int
+	fired int
+}
+
+// FaultError is the error returned by an injected fault. It unwraps to
+// syscall.ECONNRESET or os.ErrDeadlineExceeded so it is classified like the
+// real failure it stands for
+type FaultError struct {
+	Point  FaultPoint
+	Action FaultAction
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("injected fault at %s: %v", e.Point, e.Unwrap())
+}
+
+func (e *FaultError) Unwrap() error {
+	switch e.Action {
+	case FaultReset:
+		return syscall.ECONNRESET
+	case FaultTimeout:
+		return os.ErrDeadlineExceeded
+	default:
+		return errors.New("failure")
+	}
+}
+
+// Timeout reports whether the fault stands for a timeout, like net.Error
+func (e *FaultError) Timeout() bool {
+	return e.Action == FaultTimeout
+}
+
+// FaultPlan holds the faults injected into downloads, for resilience testing.
+// A nil plan injects nothing, so hooks cost a nil check when faults are off
+type FaultPlan struct {
+	mu     sync.Mutex
+	faults map[FaultPoint]*Fault
+}
+
+// faultPlanFromEnv returns the plan in FAULTS, nil when it is unset or invalid
+func faultPlanFromEnv() *FaultPlan {
+	spec := getEnv("FAULTS", "")
+	if spec == "" {
+		return nil
+	}
+	plan, err := ParseFaultPlan(spec)
+	if err != nil {
+		logger.Warn("Ignoring FAULTS", logging.Err(err))
+		return nil
+	}
+	logger.Warn("Fault injection enabled, downloads will fail on purpose", logging.String("FAULTS", spec))
+	return plan
+}
+
+// ParseFaultPlan parses a spec of ';' separated faults, each
+// "point:action[@where][*times]", e.g. "media:reset@50%;decrypt:timeout@100samples;token:error*2".
+// Media faults fire at "N%" or "Nbytes" of the body, decrypt faults at
+// "Nsamples"; other points fail when called. An empty spec returns nil
+func ParseFaultPlan(spec string) (*FaultPlan, error) {
+	plan := &FaultPlan{faults: make(map[FaultPoint]*Fault)}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fault, err := parseFault(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %w", entry, err)
+		}
+		if _, ok := plan.faults[fault.Point]; ok {
+			return nil, fmt.Errorf("invalid fault %q: %s has more than one fault", entry, fault.Point)
+		}
+		plan.faults[fault.Point] = fault
+	}
+
+	if len(plan.faults) == 0 {
+		return nil, nil
+	}
+	return plan, nil
+}
+
+// parseFault parses a single "point:action[@where][*times]" entry
+func parseFault(entry string) (*Fault, error) {
+	point, rest, ok := strings.Cut(entry, ":")
+	if !ok {
+		return nil, errors.New("expected point:action")
+	}
+
+	fault := &Fault{Point: FaultPoint(point)}
+	switch fault.Point {
+	case FaultToken, FaultCatalog, FaultManifest, FaultMedia, FaultDecrypt, FaultWrite:
+	default:
+		return nil, fmt.Errorf("unknown point %q", point)
+	}
+
+	rest, times, hasTimes := strings.Cut(rest, "*")
+	if hasTimes {
+		n, err := strconv.Atoi(times)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("times must be a positive integer, got %q", times)
+		}
+		fault.Times = n
+	}
+
+	action, where, hasWhere := strings.Cut(rest, "@")
+	fault.Action = FaultAction(action)
+	switch fault.Action {
+	case FaultReset, FaultTimeout, FaultFail:
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+
+	switch {
+	case fault.Point == FaultMedia && hasWhere && strings.HasSuffix(where, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(where, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("percent must be between 0 and 100, got %q", where)
+		}
+		fault.Percent = percent
+	case fault.Point == FaultMedia && hasWhere && strings.HasSuffix(where, "bytes"):
+		bytes, err := strconv.ParseInt(strings.TrimSuffix(where, "bytes"), 10, 64)
+		if err != nil || bytes < 0 {
+			return nil, fmt.Errorf("bytes must be a non-negative integer, got %q", where)
+		}
+		fault.Bytes = bytes
+	case fault.Point == FaultDecrypt && hasWhere && strings.HasSuffix(where, "samples"):
+		samples, err := strconv.Atoi(strings.TrimSuffix(where, "samples"))
+		if err != nil || samples < 0 {
+			return nil, fmt.Errorf("samples must be a non-negative integer, got %q", where)
+		}
+		fault.Samples = samples
+	case hasWhere:
+		return nil, fmt.Errorf("%s faults cannot fire at %q", fault.Point, where)
+	}
+
+	return fault, nil
+}
+
+// fire returns the fault at point if it should fire now, counting it
+func (p *FaultPlan) fire(point FaultPoint) *Fault {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fault, ok := p.faults[point]
+	if !ok || (fault.Times > 0 && fault.fired >= fault.Times) {
+		return nil
+	}
+	fault.fired++
+	return fault
+}
+
+// check returns the injected error for a call at point, nil when none fires
+func (p *FaultPlan) check(point FaultPoint) error {
+	if fault := p.fire(point); fault != nil {
+		return &FaultError{Point: point, Action: fault.Action}
+	}
+	return nil
+}
+
+// wrapMedia returns body failing at the media fault's offset of a file of
+// total bytes, or body itself when no media fault fires. Body starts at
+// offset of the file when resuming, and fails at once past the fault's offset
+func (p *FaultPlan) wrapMedia(body io.ReadCloser, total, offset int64) io.ReadCloser {
+	fault := p.fire(FaultMedia)
+	if fault == nil {
+		return body
+	}
+
+	at := fault.Bytes
+	if fault.Percent > 0 {
+		at = int64(float64(total) * fault.Percent / 100)
+	}
+	return &faultyBody{ReadCloser: body, remaining: at - offset, err: &FaultError{Point: FaultMedia, Action: fault.Action}}
+}
+
+// decryptFault returns how many samples decrypt before the decrypt fault
+// fires and its error, -1 when none fires
+func (p *FaultPlan) decryptFault() (int, error) {
+	if fault := p.fire(FaultDecrypt); fault != nil {
+		return fault.Samples, &FaultError{Point: FaultDecrypt, Action: fault.Action}
+	}
+	return -1, nil
+}
+
+// faultyBody reads up to remaining bytes, then fails with err
+type faultyBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *faultyBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
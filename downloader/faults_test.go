@@ -0,0 +1,287 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestParseFaultPlan(t *testing.T) {
+	plan, err := ParseFaultPlan("media:reset@50%; decrypt:timeout@100samples;token:error*2;write:error")
+	if err != nil {
+		t.Fatalf("ParseFaultPlan() error = %v", err)
+	}
+
+	tests := []struct {
+		point FaultPoint
+		want  Fault
+	}{
+		{FaultMedia, Fault{Point: FaultMedia, Action: FaultReset, Percent: 50}},
+		{FaultDecrypt, Fault{Point: FaultDecrypt, Action: FaultTimeout, Samples: 100}},
+		{FaultToken, Fault{Point: FaultToken, Action: FaultFail, Times: 2}},
+		{FaultWrite, Fault{Point: FaultWrite, Action: FaultFail}},
+	}
+	for _, tt := range tests {
+		if got := plan.faults[tt.point]; got == nil || *got != tt.want {
+			t.Errorf("fault at %s = %+v, want %+v", tt.point, got, tt.want)
+		}
+	}
+	if len(plan.faults) != len(tests) {
+		t.Errorf("Expected %d faults, got %d", len(tests), len(plan.faults))
+	}
+
+	if plan, err := ParseFaultPlan("media:reset@4096bytes"); err != nil || plan.faults[FaultMedia].Bytes != 4096 {
+		t.Errorf("Expected a fault after 4096 bytes, got %+v, %v", plan, err)
+	}
+	if plan, err := ParseFaultPlan(" ; "); err != nil || plan != nil {
+		t.Errorf("Expected no plan for an empty spec, got %+v, %v", plan, err)
+	}
+}
+
+func TestParseFaultPlan_Invalid(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"media", "expected point:action"},
+		{"disk:error", "unknown point"},
+		{"media:explode", "unknown action"},
+		{"token:error*0", "times must be a positive integer"},
+		{"media:reset@150%", "percent must be between 0 and 100"},
+		{"decrypt:reset@xsamples", "samples must be a non-negative integer"},
+		{"token:error@50%", "token faults cannot fire at"},
+		{"media:reset@10samples", "media faults cannot fire at"},
+		{"catalog:error;catalog:timeout", "catalog has more than one fault"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseFaultPlan(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseFaultPlan(%q) error = %v, want %q", tt.spec, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultError_Classification(t *testing.T) {
+	reset := &FaultError{Point: FaultMedia, Action: FaultReset}
+	if !errors.Is(reset, syscall.ECONNRESET) || reset.Timeout() {
+		t.Errorf("Expected a connection reset, got %v", reset)
+	}
+
+	timeout := &FaultError{Point: FaultManifest, Action: FaultTimeout}
+	if !errors.Is(timeout, os.ErrDeadlineExceeded) || !timeout.Timeout() {
+		t.Errorf("Expected a timeout, got %v", timeout)
+	}
+	var netErr interface{ Timeout() bool }
+	if !errors.As(error(timeout), &netErr) || !strings.Contains(timeout.Error(), "timeout") {
+		t.Errorf("Expected the timeout to look like a network timeout, got %q", timeout.Error())
+	}
+}
+
+func TestFaultPlan_NilInjectsNothing(t *testing.T) {
+	var plan *FaultPlan
+	if err := plan.check(FaultToken); err != nil {
+		t.Errorf("check() = %v, want nil", err)
+	}
+	body := io.NopCloser(strings.NewReader("data"))
+	if plan.wrapMedia(body, 4, 0) != body {
+		t.Error("Expected the body to be left alone")
+	}
+	if n, err := plan.decryptFault(); n != -1 || err != nil {
+		t.Errorf("decryptFault() = %d, %v, want -1, nil", n, err)
+	}
+}
+
+func TestFaults_TokenRetryRecovers(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		return realPage
+	})
+
+	sd := newTokenDownloader(server.URL, "manual-token")
+	sd.faults, _ = ParseFaultPlan("token:reset*1")
+
+	token, err := sd.GetToken(context.Background())
+	if err != nil || token != testToken {
+		t.Fatalf("Expected the second attempt to scrape the token, got %q, %v", token, err)
+	}
+	if status := sd.tokenHealth.Status(); status.Degraded {
+		t.Errorf("Expected healthy token status after recovering, got %+v", status)
+	}
+}
+
+func TestFaults_TokenOutageFallsBack(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		return realPage
+	})
+
+	sd := newTokenDownloader(server.URL, "manual-token")
+	sd.faults, _ = ParseFaultPlan("token:timeout")
+
+	token, err := sd.GetToken(context.Background())
+	if err != nil || token != "manual-token" {
+		t.Fatalf("Expected the fallback token, got %q, %v", token, err)
+	}
+	if requests := server.pageRequests(); requests != 0 {
+		t.Errorf("Expected no request to reach the page, got %d", requests)
+	}
+	if status := sd.tokenHealth.Status(); !status.Degraded || !strings.Contains(status.Reason, "injected fault at token") {
+		t.Errorf("Expected degraded status naming the fault, got %+v", status)
+	}
+}
+
+func TestFaults_CatalogFailureIsRetried(t *testing.T) {
+	server := newAlbumServer(t)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.apple.catalogURL = server.URL
+	sd.faults, _ = ParseFaultPlan("catalog:error*1")
+
+	meta, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "1"}, testToken)
+	if !errors.As(err, new(*FaultError)) {
+		t.Fatalf("Expected the injected catalog failure, got %v", err)
+	}
+
+	meta, err = sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "us", URLType: "songs", ID: "1"}, testToken)
+	if err != nil {
+		t.Fatalf("GetSongMeta() error = %v", err)
+	}
+	if ac := sd.albumContext(context.Background(), "us", meta, testToken); ac == nil || ac.Album().ID != "300" {
+		t.Errorf("Expected the album context once the catalog recovered, got %+v", ac)
+	}
+}
+
+func TestFaults_ManifestTimeout(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.faults, _ = ParseFaultPlan("manifest:timeout")
+
+	_, _, _, err := sd.ExtractMedia(context.Background(), "http://127.0.0.1:0/master.m3u8")
+	if fe := new(*FaultError); !errors.As(err, fe) || !(*fe).Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected an injected timeout before any request, got %v", err)
+	}
+}
+
+func TestFaults_TimesCountAcrossJobs(t *testing.T) {
+	t.Setenv("FAULTS", "manifest:error*2")
+	manager := NewManager(0)
+	first := manager.NewDownloader().(*SongDownloaderImpl)
+	second := manager.NewDownloader().(*SongDownloaderImpl)
+
+	fired := 0
+	for _, sd := range []*SongDownloaderImpl{first, second, first, second} {
+		_, _, _, err := sd.ExtractMedia(context.Background(), "http://127.0.0.1:0/master.m3u8")
+		if errors.As(err, new(*FaultError)) {
+			fired++
+		}
+	}
+	if fired != 2 {
+		t.Errorf("Expected the fault to fire 2 times across both jobs, got %d", fired)
+	}
+}
+
+func TestFaults_MediaResetMidDownload(t *testing.T) {
+	body := bytes.Repeat([]byte{0xAB}, 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.faults, _ = ParseFaultPlan("media:reset@50%")
+
+	var read int64
+	_, err := sd.extractSong(context.Background(), server.URL, ProgressCallbacks{
+		OnProgress: func(phase Phase, progress Progress) {
+			read = progress.BytesProcessed
+		},
+	})
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Expected a connection reset, got %v", err)
+	}
+	if read != 500 {
+		t.Errorf("Expected the reset after 500 bytes, read %d", read)
+	}
+}
+
+// newEchoDecryptServer starts a decryption sidecar that echoes every sample
+// back and records the samples it received
+func newEchoDecryptServer(t *testing.T) (string, func() int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	samples := 0
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Key selection: id and key URI, each prefixed by its length
+		for i := 0; i < 2; i++ {
+			var length [1]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, conn, int64(length[0])); err != nil {
+				return
+			}
+		}
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+				return
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			mu.Lock()
+			samples++
+			mu.Unlock()
+			conn.Write(data)
+		}
+	}()
+
+	return listener.Addr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return samples
+	}
+}
+
+func TestFaults_DecryptFailsAfterSamples(t *testing.T) {
+	addr, received := newEchoDecryptServer(t)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+	sd.faults, _ = ParseFaultPlan("decrypt:reset@2samples")
+
+	info := &SongInfo{totalDataSize: 12}
+	for i := 0; i < 3; i++ {
+		info.samples = append(info.samples, SampleInfo{data: []byte{1, 2, 3, 4}})
+	}
+	manifest := &AutoSong{ID: "1440818839"}
+
+	err := sd.decryptSong(context.Background(), info, []string{"skd://key"}, manifest, io.Discard, ProgressCallbacks{})
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Expected a connection reset, got %v", err)
+	}
+	if got := received(); got != 2 {
+		t.Errorf("Expected 2 samples decrypted before the fault, got %d", got)
+	}
+}
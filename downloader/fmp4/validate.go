@@ -0,0 +1,319 @@
+// Package fmp4 checks the MP4 files produced from decrypted fragmented
+// sources, so nothing specific to the encrypted input reaches the output
+package fmp4
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/abema/go-mp4"
+)
+
+var (
+	boxTypeAlac = mp4.StrToBoxType("alac")
+	boxTypeEnca = mp4.StrToBoxType("enca")
+	boxTypeSenc = mp4.StrToBoxType("senc")
+
+	// seigGroupingType marks sample groups carrying per-sample encryption info
+	seigGroupingType = [4]byte{'s', 'e', 'i', 'g'}
+)
+
+// encryptionBoxes only appear in encrypted media
+var encryptionBoxes = map[mp4.BoxType]bool{
+	boxTypeSenc:       true,
+	mp4.BoxTypeSaio(): true,
+	mp4.BoxTypeSaiz(): true,
+	mp4.BoxTypePssh(): true,
+	mp4.BoxTypeTenc(): true,
+	mp4.BoxTypeSinf(): true,
+	mp4.BoxTypeSchm(): true,
+	mp4.BoxTypeSchi(): true,
+	mp4.BoxTypeFrma(): true,
+	boxTypeEnca:       true,
+	mp4.BoxTypeEncv(): true,
+}
+
+// containers are the boxes whose children are checked. udta only holds tags
+// and is not walked
+var containers = map[mp4.BoxType]bool{
+	mp4.BoxTypeMoov(): true,
+	mp4.BoxTypeTrak(): true,
+	mp4.BoxTypeMdia(): true,
+	mp4.BoxTypeMinf(): true,
+	mp4.BoxTypeStbl(): true,
+	mp4.BoxTypeStsd(): true,
+	mp4.BoxTypeDinf(): true,
+	mp4.BoxTypeEdts(): true,
+	mp4.BoxTypeMvex(): true,
+	mp4.BoxTypeMoof(): true,
+	mp4.BoxTypeTraf(): true,
+	boxTypeEnca:       true,
+	mp4.BoxTypeSinf(): true,
+	mp4.BoxTypeSchi(): true,
+}
+
+// ValidationError lists the problems found in a file, each prefixed with the
+// path of the offending box
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid output M4A (%d problems):\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// sampleTable collects the stbl children of one track
+type sampleTable struct {
+	path         string
+	entries      int
+	stts         *mp4.Stts
+	stsc         *mp4.Stsc
+	stsz         *mp4.Stsz
+	chunkBoxes   int
+	chunkOffsets []uint64
+}
+
+// dataRange is the payload of an mdat box
+type dataRange struct {
+	start, end uint64
+}
+
+// ValidateOutputM4A walks the box tree of a finished M4A and checks that no
+// encryption-related box is left, that the sample entry is alac, or mp4a for
+// AAC, and that the sample tables of every track agree with each other and
+// with mdat. All problems are reported in a *ValidationError
+func ValidateOutputM4A(r io.ReadSeeker) error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	var tables []*sampleTable
+	var current *sampleTable
+	var mdats []dataRange
+
+	_, err := mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+		boxType := h.BoxInfo.Type
+		path := formatPath(h.Path)
+
+		if encryptionBoxes[boxType] {
+			addf("%s: encryption box %s must not be present", path, boxType)
+		}
+
+		var parent mp4.BoxType
+		if len(h.Path) > 1 {
+			parent = h.Path[len(h.Path)-2]
+		}
+
+		if parent == mp4.BoxTypeStsd() && current != nil {
+			current.entries++
+			if boxType != boxTypeAlac && boxType != mp4.BoxTypeMp4a() {
+				addf("%s: sample entry is %s, want alac or mp4a", path, boxType)
+			}
+		}
+
+		switch boxType {
+		case mp4.BoxTypeMdat():
+			mdats = append(mdats, dataRange{
+				start: h.BoxInfo.Offset + h.BoxInfo.HeaderSize,
+				end:   h.BoxInfo.Offset + h.BoxInfo.Size,
+			})
+
+		case mp4.BoxTypeStbl():
+			current = &sampleTable{path: path}
+			tables = append(tables, current)
+			defer func() { current = nil }()
+
+		case mp4.BoxTypeSbgp(), mp4.BoxTypeSgpd():
+			payload, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			var groupingType [4]byte
+			switch group := payload.(type) {
+			case *mp4.Sbgp:
+				groupingType = [4]byte{byte(group.GroupingType >> 24), byte(group.GroupingType >> 16), byte(group.GroupingType >> 8), byte(group.GroupingType)}
+			case *mp4.Sgpd:
+				groupingType = group.GroupingType
+			}
+			if groupingType == seigGroupingType {
+				addf("%s: seig sample group must not be present", path)
+			}
+		}
+
+		if parent == mp4.BoxTypeStbl() && current != nil {
+			if err := current.read(h); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+
+		if containers[boxType] {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read box structure: %w", err)
+	}
+
+	if len(tables) == 0 {
+		addf("moov/trak/mdia/minf/stbl: no sample table found")
+	}
+	for _, table := range tables {
+		problems = append(problems, table.check(mdats)...)
+	}
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// read records the sample table box behind h
+func (t *sampleTable) read(h *mp4.ReadHandle) error {
+	switch h.BoxInfo.Type {
+	case mp4.BoxTypeStts(), mp4.BoxTypeStsc(), mp4.BoxTypeStsz(), mp4.BoxTypeStco(), mp4.BoxTypeCo64():
+	default:
+		return nil
+	}
+
+	payload, _, err := h.ReadPayload()
+	if err != nil {
+		return err
+	}
+
+	switch box := payload.(type) {
+	case *mp4.Stts:
+		t.stts = box
+	case *mp4.Stsc:
+		t.stsc = box
+	case *mp4.Stsz:
+		t.stsz = box
+	case *mp4.Stco:
+		t.chunkBoxes++
+		for _, offset := range box.ChunkOffset {
+			t.chunkOffsets = append(t.chunkOffsets, uint64(offset))
+		}
+	case *mp4.Co64:
+		t.chunkBoxes++
+		t.chunkOffsets = append(t.chunkOffsets, box.ChunkOffset...)
+	}
+	return nil
+}
+
+// check returns the inconsistencies between the boxes of the sample table
+func (t *sampleTable) check(mdats []dataRange) []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(t.path+"/"+format, args...))
+	}
+
+	if t.entries == 0 {
+		addf("stsd: no sample entries")
+	}
+	if t.stts == nil {
+		addf("stts: missing")
+	}
+	if t.stsc == nil {
+		addf("stsc: missing")
+	}
+	if t.stsz == nil {
+		addf("stsz: missing")
+	}
+	if t.chunkBoxes != 1 {
+		addf("stco: want exactly one stco or co64, found %d", t.chunkBoxes)
+	}
+	if len(problems) != 0 {
+		return problems
+	}
+
+	samples := uint64(t.stsz.SampleCount)
+
+	var sttsTotal uint64
+	for _, entry := range t.stts.Entries {
+		sttsTotal += uint64(entry.SampleCount)
+	}
+	if sttsTotal != samples {
+		addf("stts: covers %d samples, stsz has %d", sttsTotal, samples)
+	}
+
+	// Samples per chunk as described by stsc, which must cover every chunk
+	chunks := uint64(len(t.chunkOffsets))
+	perChunk := make([]uint64, 0, chunks)
+	for i, entry := range t.stsc.Entries {
+		first := uint64(entry.FirstChunk)
+		if first < 1 || (i == 0 && first != 1) || (i > 0 && first <= uint64(t.stsc.Entries[i-1].FirstChunk)) {
+			addf("stsc: entry %d has first chunk %d out of order", i+1, first)
+			return problems
+		}
+		if first > chunks {
+			addf("stsc: entry %d starts at chunk %d, but stco has %d chunks", i+1, first, chunks)
+			return problems
+		}
+		if index := entry.SampleDescriptionIndex; index < 1 || int(index) > t.entries {
+			addf("stsc: entry %d has sample description index %d, stsd has %d entries", i+1, index, t.entries)
+		}
+
+		last := chunks
+		if i+1 < len(t.stsc.Entries) {
+			last = uint64(t.stsc.Entries[i+1].FirstChunk) - 1
+		}
+		for chunk := first; chunk <= last; chunk++ {
+			perChunk = append(perChunk, uint64(entry.SamplesPerChunk))
+		}
+	}
+	if uint64(len(perChunk)) != chunks {
+		addf("stsc: describes %d chunks, stco has %d", len(perChunk), chunks)
+		return problems
+	}
+
+	var stscTotal uint64
+	for _, n := range perChunk {
+		stscTotal += n
+	}
+	if stscTotal != samples {
+		addf("stsc: %d chunks hold %d samples, stsz has %d", chunks, stscTotal, samples)
+		return problems
+	}
+
+	// Every chunk must lie inside an mdat payload
+	sample := uint64(0)
+	for i, offset := range t.chunkOffsets {
+		var size uint64
+		for n := uint64(0); n < perChunk[i]; n++ {
+			if t.stsz.SampleSize != 0 {
+				size += uint64(t.stsz.SampleSize)
+			} else {
+				size += uint64(t.stsz.EntrySize[sample])
+			}
+			sample++
+		}
+
+		if !insideAny(mdats, offset, offset+size) {
+			addf("stco: chunk %d at offset %d (%d bytes) is outside mdat", i+1, offset, size)
+			return problems
+		}
+	}
+
+	return problems
+}
+
+// insideAny reports whether [start, end) lies inside one of the ranges
+func insideAny(ranges []dataRange, start, end uint64) bool {
+	for _, r := range ranges {
+		if start >= r.start && end <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPath renders a box path as moov/trak/...
+func formatPath(path mp4.BoxPath) string {
+	parts := make([]string, len(path))
+	for i, boxType := range path {
+		parts[i] = boxType.String()
+	}
+	return strings.Join(parts, "/")
+}
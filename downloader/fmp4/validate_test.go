@@ -0,0 +1,326 @@
+package fmp4
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abema/go-mp4"
+)
+
+// fixture describes a minimal single-track M4A laid out as ftyp, mdat, moov
+type fixture struct {
+	sampleEntry mp4.BoxType
+	sizes       []uint32
+	stts        []mp4.SttsEntry
+	stsc        []mp4.StscEntry
+	co64        bool
+	offsetShift int64
+
+	moovExtra []mp4.BoxType // Empty full boxes added to moov
+	stblExtra []mp4.BoxType // Empty full boxes added to stbl
+	sbgp      string        // Grouping type of an sbgp added to stbl
+}
+
+// goodFixture has 7 samples in two chunks of 5 and 2 samples
+func goodFixture() fixture {
+	return fixture{
+		sampleEntry: boxTypeAlac,
+		sizes:       []uint32{3, 5, 2, 4, 6, 1, 2},
+		stts:        []mp4.SttsEntry{{SampleCount: 7, SampleDelta: 4096}},
+		stsc: []mp4.StscEntry{
+			{FirstChunk: 1, SamplesPerChunk: 5, SampleDescriptionIndex: 1},
+			{FirstChunk: 2, SamplesPerChunk: 2, SampleDescriptionIndex: 1},
+		},
+	}
+}
+
+func (f fixture) build(t *testing.T) *bytes.Reader {
+	t.Helper()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "fixture.m4a"))
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer file.Close()
+
+	w := mp4.NewWriter(file)
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Failed to build fixture: %v", err)
+		}
+	}
+	start := func(boxType mp4.BoxType) *mp4.BoxInfo {
+		t.Helper()
+		box, err := w.StartBox(&mp4.BoxInfo{Type: boxType})
+		check(err)
+		return box
+	}
+	end := func() {
+		t.Helper()
+		_, err := w.EndBox()
+		check(err)
+	}
+	emptyFullBox := func(boxType mp4.BoxType) {
+		t.Helper()
+		start(boxType)
+		_, err := w.Write(make([]byte, 8)) // Version, flags and a zero count
+		check(err)
+		end()
+	}
+
+	box := start(mp4.BoxTypeFtyp())
+	_, err = mp4.Marshal(w, &mp4.Ftyp{MajorBrand: [4]byte{'M', '4', 'A', ' '}}, box.Context)
+	check(err)
+	end()
+
+	// Chunks are laid out back to back in mdat
+	box = start(mp4.BoxTypeMdat())
+	var data []byte
+	for i, size := range f.sizes {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, int(size))...)
+	}
+	_, err = w.Write(data)
+	check(err)
+	mdat, err := w.EndBox()
+	check(err)
+
+	var offsets []uint64
+	offset := int64(mdat.Offset+mdat.HeaderSize) + f.offsetShift
+	sample := 0
+	for chunk := 0; sample < len(f.sizes); chunk++ {
+		perChunk := int(f.stsc[len(f.stsc)-1].SamplesPerChunk)
+		for i, entry := range f.stsc {
+			if uint32(chunk+1) >= entry.FirstChunk && (i+1 == len(f.stsc) || uint32(chunk+1) < f.stsc[i+1].FirstChunk) {
+				perChunk = int(entry.SamplesPerChunk)
+			}
+		}
+		if perChunk == 0 {
+			break
+		}
+		offsets = append(offsets, uint64(offset))
+		for n := 0; n < perChunk && sample < len(f.sizes); n++ {
+			offset += int64(f.sizes[sample])
+			sample++
+		}
+	}
+
+	start(mp4.BoxTypeMoov())
+	for _, boxType := range f.moovExtra {
+		emptyFullBox(boxType)
+	}
+	start(mp4.BoxTypeTrak())
+	start(mp4.BoxTypeMdia())
+	start(mp4.BoxTypeMinf())
+	start(mp4.BoxTypeStbl())
+
+	box = start(mp4.BoxTypeStsd())
+	_, err = mp4.Marshal(w, &mp4.Stsd{EntryCount: 1}, box.Context)
+	check(err)
+	start(f.sampleEntry)
+	// Audio sample entry: data reference 1, 2 channels, 16 bits, 44100 Hz
+	_, err = w.Write([]byte{
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 2, 0, 16, 0, 0, 0, 0,
+		0xac, 0x44, 0, 0,
+	})
+	check(err)
+	if f.sampleEntry == boxTypeEnca {
+		start(mp4.BoxTypeSinf())
+		start(mp4.BoxTypeFrma())
+		_, err = w.Write([]byte("alac"))
+		check(err)
+		end()
+		end()
+	}
+	end()
+	end()
+
+	box = start(mp4.BoxTypeStts())
+	_, err = mp4.Marshal(w, &mp4.Stts{EntryCount: uint32(len(f.stts)), Entries: f.stts}, box.Context)
+	check(err)
+	end()
+
+	box = start(mp4.BoxTypeStsc())
+	_, err = mp4.Marshal(w, &mp4.Stsc{EntryCount: uint32(len(f.stsc)), Entries: f.stsc}, box.Context)
+	check(err)
+	end()
+
+	box = start(mp4.BoxTypeStsz())
+	_, err = mp4.Marshal(w, &mp4.Stsz{SampleCount: uint32(len(f.sizes)), EntrySize: f.sizes}, box.Context)
+	check(err)
+	end()
+
+	if f.co64 {
+		box = start(mp4.BoxTypeCo64())
+		_, err = mp4.Marshal(w, &mp4.Co64{EntryCount: uint32(len(offsets)), ChunkOffset: offsets}, box.Context)
+	} else {
+		stco := &mp4.Stco{EntryCount: uint32(len(offsets))}
+		for _, o := range offsets {
+			stco.ChunkOffset = append(stco.ChunkOffset, uint32(o))
+		}
+		box = start(mp4.BoxTypeStco())
+		_, err = mp4.Marshal(w, stco, box.Context)
+	}
+	check(err)
+	end()
+
+	for _, boxType := range f.stblExtra {
+		emptyFullBox(boxType)
+	}
+	if f.sbgp != "" {
+		box = start(mp4.BoxTypeSbgp())
+		groupingType := mp4.StrToBoxType(f.sbgp)
+		_, err = mp4.Marshal(w, &mp4.Sbgp{
+			GroupingType: uint32(groupingType[0])<<24 | uint32(groupingType[1])<<16 | uint32(groupingType[2])<<8 | uint32(groupingType[3]),
+		}, box.Context)
+		check(err)
+		end()
+	}
+
+	end() // stbl
+	end() // minf
+	end() // mdia
+	end() // trak
+	end() // moov
+
+	raw, err := os.ReadFile(file.Name())
+	check(err)
+	return bytes.NewReader(raw)
+}
+
+func TestValidateOutputM4A_Good(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(f *fixture)
+	}{
+		{"stco", func(f *fixture) {}},
+		{"co64", func(f *fixture) { f.co64 = true }},
+		{"non-encryption sample group", func(f *fixture) { f.sbgp = "roll" }},
+		{"aac sample entry", func(f *fixture) { f.sampleEntry = mp4.BoxTypeMp4a() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := goodFixture()
+			tt.modify(&f)
+
+			if err := ValidateOutputM4A(f.build(t)); err != nil {
+				t.Errorf("ValidateOutputM4A() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestValidateOutputM4A_Corrupted(t *testing.T) {
+	const stbl = "moov/trak/mdia/minf/stbl/"
+
+	tests := []struct {
+		name   string
+		modify func(f *fixture)
+		want   []string
+	}{
+		{
+			name:   "encrypted sample entry",
+			modify: func(f *fixture) { f.sampleEntry = boxTypeEnca },
+			want: []string{
+				stbl + "stsd/enca: encryption box enca",
+				stbl + "stsd/enca: sample entry is enca, want alac or mp4a",
+				stbl + "stsd/enca/sinf: encryption box sinf",
+				stbl + "stsd/enca/sinf/frma: encryption box frma",
+			},
+		},
+		{
+			name:   "other sample entry",
+			modify: func(f *fixture) { f.sampleEntry = mp4.StrToBoxType("Opus") },
+			want:   []string{stbl + "stsd/Opus: sample entry is Opus, want alac or mp4a"},
+		},
+		{
+			name: "sample auxiliary information",
+			modify: func(f *fixture) {
+				f.stblExtra = []mp4.BoxType{boxTypeSenc, mp4.BoxTypeSaio(), mp4.BoxTypeSaiz()}
+			},
+			want: []string{
+				stbl + "senc: encryption box senc",
+				stbl + "saio: encryption box saio",
+				stbl + "saiz: encryption box saiz",
+			},
+		},
+		{
+			name:   "protection header",
+			modify: func(f *fixture) { f.moovExtra = []mp4.BoxType{mp4.BoxTypePssh()} },
+			want:   []string{"moov/pssh: encryption box pssh"},
+		},
+		{
+			name:   "encryption sample group",
+			modify: func(f *fixture) { f.sbgp = "seig" },
+			want:   []string{stbl + "sbgp: seig sample group"},
+		},
+		{
+			name:   "stts total",
+			modify: func(f *fixture) { f.stts = []mp4.SttsEntry{{SampleCount: 6, SampleDelta: 4096}} },
+			want:   []string{stbl + "stts: covers 6 samples, stsz has 7"},
+		},
+		{
+			name:   "stsz sample count",
+			modify: func(f *fixture) { f.sizes = append(f.sizes, 8) },
+			want:   []string{stbl + "stts: covers 7 samples, stsz has 8"},
+		},
+		{
+			name: "stsc sample count",
+			modify: func(f *fixture) {
+				f.stsc = []mp4.StscEntry{{FirstChunk: 1, SamplesPerChunk: 5, SampleDescriptionIndex: 1}}
+			},
+			want: []string{stbl + "stsc: 2 chunks hold 10 samples, stsz has 7"},
+		},
+		{
+			name: "stsc chunk order",
+			modify: func(f *fixture) {
+				f.stsc[1].FirstChunk = 1
+			},
+			want: []string{stbl + "stsc: entry 2 has first chunk 1 out of order"},
+		},
+		{
+			name: "sample description index",
+			modify: func(f *fixture) {
+				f.stsc[0].SampleDescriptionIndex = 0
+			},
+			want: []string{stbl + "stsc: entry 1 has sample description index 0, stsd has 1 entries"},
+		},
+		{
+			name:   "chunk outside mdat",
+			modify: func(f *fixture) { f.offsetShift = 10 },
+			want:   []string{stbl + "stco: chunk 1 at offset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := goodFixture()
+			tt.modify(&f)
+
+			err := ValidateOutputM4A(f.build(t))
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidateOutputM4A() = %v, want *ValidationError", err)
+			}
+
+			for _, want := range tt.want {
+				found := false
+				for _, problem := range validationErr.Problems {
+					if strings.HasPrefix(problem, want) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("Expected a problem starting with %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
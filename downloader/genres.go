@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"strings"
+)
+
+// genericGenres are the umbrella genres Apple lists before the specific ones,
+// in the languages storefronts localize them to
+var genericGenres = map[string]bool{
+	"music":   true,
+	"musique": true,
+	"musik":   true,
+	"música":  true,
+	"musica":  true,
+	"muziek":  true,
+	"muzyka":  true,
+	"музыка":  true,
+	"ミュージック":  true,
+	"音乐":      true,
+	"音樂":      true,
+	"음악":      true,
+}
+
+// id3v1Genres are the genres of the ID3v1 standard, in index order
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+// appleID3v1Aliases maps Apple genre names to the ID3v1 genre they correspond to
+var appleID3v1Aliases = map[string]string{
+	"hip-hop/rap":        "Hip-Hop",
+	"r&b/soul":           "R&B",
+	"christian & gospel": "Gospel",
+	"electronica":        "Electronic",
+	"rock & roll":        "Rock & Roll",
+}
+
+// GenreTags are the genre tags written to a file
+type GenreTags struct {
+	Primary string   // ©gen
+	All     []string // GENRES freeform atom, semicolon-joined
+	ID3v1   uint16   // gnre, the ID3v1 index of Primary plus one (0 = none)
+}
+
+// normalizeGenres drops empty and duplicate genre names and the generic
+// "Music" umbrella when more specific genres exist, keeping Apple's order
+func normalizeGenres(names []string) GenreTags {
+	var specific, generic []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if genericGenres[key] {
+			generic = append(generic, name)
+		} else {
+			specific = append(specific, name)
+		}
+	}
+
+	if len(specific) == 0 {
+		specific = generic
+	}
+	if len(specific) == 0 {
+		return GenreTags{}
+	}
+
+	return GenreTags{
+		Primary: specific[0],
+		All:     specific,
+		ID3v1:   id3v1GenreCode(specific[0]),
+	}
+}
+
+// id3v1GenreCode returns the gnre value of genre, 0 when it is not an ID3v1 genre
+func id3v1GenreCode(genre string) uint16 {
+	if alias, ok := appleID3v1Aliases[strings.ToLower(genre)]; ok {
+		genre = alias
+	}
+	for i, name := range id3v1Genres {
+		if strings.EqualFold(name, genre) {
+			return uint16(i + 1)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeGenres(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  GenreTags
+	}{
+		{
+			name:  "empty",
+			input: nil,
+			want:  GenreTags{},
+		},
+		{
+			name:  "only the umbrella genre",
+			input: []string{"Music"},
+			want:  GenreTags{Primary: "Music", All: []string{"Music"}},
+		},
+		{
+			name:  "umbrella genre dropped",
+			input: []string{"Music", "Electronic", "Dance"},
+			want:  GenreTags{Primary: "Electronic", All: []string{"Electronic", "Dance"}, ID3v1: 53},
+		},
+		{
+			name:  "non-English names",
+			input: []string{"Musique", "Électronique", "Dance"},
+			want:  GenreTags{Primary: "Électronique", All: []string{"Électronique", "Dance"}},
+		},
+		{
+			name:  "Japanese umbrella genre",
+			input: []string{"J-Pop", "ミュージック"},
+			want:  GenreTags{Primary: "J-Pop", All: []string{"J-Pop"}},
+		},
+		{
+			name:  "Apple names mapped to ID3v1",
+			input: []string{"Hip-Hop/Rap", "Music"},
+			want:  GenreTags{Primary: "Hip-Hop/Rap", All: []string{"Hip-Hop/Rap"}, ID3v1: 8},
+		},
+		{
+			name:  "duplicates and blanks",
+			input: []string{" Pop ", "", "pop", "Rock"},
+			want:  GenreTags{Primary: "Pop", All: []string{"Pop", "Rock"}, ID3v1: 14},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeGenres(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeGenres(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteM4a_Genres(t *testing.T) {
+	meta := retagTestMeta("Name")
+	meta.Attributes.GenreNames = []string{"Music", "Electronic", "Dance"}
+
+	data, err := os.ReadFile(writeBotFixture(t, meta))
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+
+	for _, want := range [][]byte{
+		[]byte("\251gen"),
+		[]byte("GENRES"),
+		[]byte("Electronic;Dance"),
+		{'g', 'n', 'r', 'e', 0, 0, 0, 0x12, 'd', 'a', 't', 'a', 0, 0, 0, 0, 0, 0, 0, 0, 0, 53},
+	} {
+		if !bytes.Contains(data, want) {
+			t.Errorf("Expected the file to contain %q", want)
+		}
+	}
+	if bytes.Contains(data, []byte("Music")) {
+		t.Error("Expected the umbrella genre not to be written")
+	}
+}
@@ -3,6 +3,8 @@ package downloader
 import (
 	"context"
 	"time"
+
+	"github.com/gotd/td/tg"
 )
 
 // Phase represents the current phase of the download process
@@ -16,6 +18,8 @@ const (
 	PhaseUploading
 	PhaseComplete
 	PhaseError
+	PhaseWaitingForMemory
+	PhaseWaitingForDecrypt
 )
 
 // String returns the string representation of the phase
@@ -35,6 +39,10 @@ func (p Phase) String() string {
 		return "complete"
 	case PhaseError:
 		return "error"
+	case PhaseWaitingForMemory:
+		return "waiting for memory"
+	case PhaseWaitingForDecrypt:
+		return "waiting for decryption"
 	default:
 		return "unknown"
 	}
@@ -55,6 +63,18 @@ type ProgressCallbacks struct {
 	OnPhaseChange func(oldPhase, newPhase Phase)
 	OnError       func(err error)
 	OnComplete    func(result *DownloadResult)
+
+	// OnWarning reports a problem the download works around, such as
+	// samples decrypted with a fallback key
+	OnWarning func(message string)
+
+	// OnMetadata reports the song's metadata as soon as it is fetched,
+	// before anything is downloaded (optional)
+	OnMetadata func(meta *SongMetadata)
+
+	// OnTrackListProgress reports the overall progress of album and
+	// playlist downloads
+	OnTrackListProgress func(progress TrackListProgress)
 }
 
 // DownloadResult contains the result of a successful download
@@ -64,6 +84,56 @@ type DownloadResult struct {
 	Duration time.Duration `json:"duration"`
 	FileSize int64         `json:"file_size"`
 	Format   string        `json:"format"`
+
+	// Parts is set when the file was larger than the split limit and was
+	// split into several files, uploaded in order instead of FilePath
+	Parts []SplitPart `json:"parts,omitempty"`
+
+	// PartIndex and PartCount identify a single part (1-based) when a part is
+	// handled on its own
+	PartIndex int `json:"part_index,omitempty"`
+	PartCount int `json:"part_count,omitempty"`
+
+	// MissingFields lists the expected tags absent from the catalog data,
+	// e.g. "composer" or "lyrics". The file is complete otherwise
+	MissingFields []string `json:"missing_fields,omitempty"`
+
+	// TagWarnings lists the tags skipped while writing the file, e.g. the
+	// album tags of a song without album relationship, as "tag: reason"
+	TagWarnings []string `json:"tag_warnings,omitempty"`
+
+	// Lyrics are the LRC lyrics embedded in the file, empty when the song
+	// has none or they could not be fetched
+	Lyrics string `json:"lyrics,omitempty"`
+
+	// Media is the quality of the downloaded stream, nil when the file was
+	// already downloaded
+	Media *MediaInfo `json:"media,omitempty"`
+
+	// Thumbnail is the artwork as a JPEG of at most ThumbnailMaxDimension
+	// pixels a side, nil when none could be made
+	Thumbnail []byte `json:"-"`
+
+	// ArtworkWidth and ArtworkHeight are the dimensions of the artwork
+	// embedded in the file, zero when it has none or was already downloaded
+	ArtworkWidth  int `json:"artwork_width,omitempty"`
+	ArtworkHeight int `json:"artwork_height,omitempty"`
+
+	// PhaseDurations is the time the download spent in each phase. The bot
+	// adds PhaseUploading once the file has been delivered
+	PhaseDurations map[Phase]time.Duration `json:"phase_durations,omitempty"`
+}
+
+// DownloadOptions are per-request settings of a download. They only affect
+// what is fetched and written, never the messages sent about it
+type DownloadOptions struct {
+	// MetadataLanguage is the catalog language of the tags, e.g. "ja" or
+	// "en-US". Empty uses the storefront's default language
+	MetadataLanguage string `json:"metadata_language,omitempty"`
+
+	// AACFallback downloads the best AAC stream of songs without ALAC
+	// instead of failing
+	AACFallback bool `json:"aac_fallback,omitempty"`
 }
 
 // SongMetadata contains metadata about the downloaded song
@@ -75,6 +145,19 @@ type SongMetadata struct {
 	DurationMillis int           `json:"duration_millis"`
 	ArtworkURL     string        `json:"artwork_url"`
 	AppleMusicID   string        `json:"apple_music_id"`
+
+	// Audio format read from the ALAC box, zero when unknown
+	BitDepth     int `json:"bit_depth,omitempty"`
+	SampleRateHz int `json:"sample_rate_hz,omitempty"`
+	Channels     int `json:"channels,omitempty"`
+	Bitrate      int `json:"bitrate,omitempty"` // Average, in bits per second
+
+	// Codec is CodecAAC for songs downloaded as AAC, empty for ALAC
+	Codec string `json:"codec,omitempty"`
+
+	// Language is the catalog language of the tags, empty for the
+	// storefront's default language
+	Language string `json:"language,omitempty"`
 }
 
 // SongDownloader interface defines the contract for downloading songs
@@ -93,12 +176,78 @@ type SongDownloader interface {
 type DownloadStatus struct {
 	Phase     Phase     `json:"phase"`
 	Progress  Progress  `json:"progress"`
-	StartTime time.Time `json:"start_time"`
+	StartTime time.Time `json:"start_time"` // In-process reading; measure with Elapsed
 	SongName  string    `json:"song_name"`
 	IsActive  bool      `json:"is_active"`
 	Error     error     `json:"error,omitempty"`
 }
 
+// CompletionNoter is implemented by progress reporters that can add a note to
+// their completion message
+type CompletionNoter interface {
+	SetCompletionNote(note string)
+}
+
+// CompletionDetailer is implemented by progress reporters that can show the
+// size and audio quality of the delivered file in their completion message
+type CompletionDetailer interface {
+	SetCompletionDetails(fileSize int64, quality string)
+}
+
+// PhaseTimer is implemented by progress reporters that can show the time
+// spent in each phase in their completion message
+type PhaseTimer interface {
+	SetPhaseDurations(durations map[Phase]time.Duration)
+}
+
+// CancellationReporter is implemented by progress reporters that can show
+// that the user cancelled the download, rather than an error
+type CancellationReporter interface {
+	ReportCancelled() error
+}
+
+// InterruptionReporter is implemented by progress reporters that can show
+// that the download stopped for a reason other than the user or an error,
+// such as a restart of the bot
+type InterruptionReporter interface {
+	ReportInterrupted(reason string) error
+}
+
+// ChoicePrompter is implemented by progress reporters that can turn their
+// message into a question with inline buttons
+type ChoicePrompter interface {
+	ReportChoices(message string, markup tg.ReplyMarkupClass) (int, error)
+}
+
+// OptionsSetter is implemented by downloaders that take per-request options
+type OptionsSetter interface {
+	SetOptions(options DownloadOptions)
+}
+
+// AlbumDownloader is implemented by downloaders that can download every song
+// of an album
+type AlbumDownloader interface {
+	DownloadAlbum(ctx context.Context, url string, callbacks ProgressCallbacks) (*AlbumDownloadResult, error)
+}
+
+// PlaylistDownloader is implemented by downloaders that can download every
+// song of a playlist
+type PlaylistDownloader interface {
+	DownloadPlaylist(ctx context.Context, url string, callbacks ProgressCallbacks) (*PlaylistDownloadResult, error)
+}
+
+// ALACChecker is implemented by downloaders that can check in which
+// storefronts a song is available in ALAC without downloading it
+type ALACChecker interface {
+	ALACStorefronts(ctx context.Context, songURL string, candidates []string) ([]string, error)
+}
+
+// ReleaseChecker is implemented by downloaders that can look up whether a
+// song that was not released yet has come out
+type ReleaseChecker interface {
+	ReleaseStatus(ctx context.Context, songURL string) (*ReleaseStatus, error)
+}
+
 // ProgressReporter interface defines the contract for reporting progress
 type ProgressReporter interface {
 	// StartTracking begins progress tracking for a specific chat and song
@@ -116,6 +265,10 @@ type ProgressReporter interface {
 	// ReportComplete reports successful completion with summary information
 	ReportComplete(duration time.Duration, filePath string) error
 
+	// UpdateSongName names the song once its metadata is known, e.g. when
+	// tracking started before it was
+	UpdateSongName(name string)
+
 	// Stop stops progress tracking and cleans up resources
 	Stop()
 }
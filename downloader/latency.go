@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent calls per dependency percentiles cover
+const latencyWindow = 100
+
+// Dependency is an external service the bot waits on
+type Dependency string
+
+const (
+	DepCatalogAPI     Dependency = "catalog_api"     // Apple Music catalog metadata
+	DepTokenPage      Dependency = "token_page"      // Web player page and bundle holding the token
+	DepMasterPlaylist Dependency = "master_playlist" // HLS master and media playlists
+	DepMediaCDN       Dependency = "media_cdn"       // Encrypted song and artwork downloads
+	DepDevice         Dependency = "device_tcp"      // Device sidecar resolving HLS URLs
+	DepDecrypt        Dependency = "decrypt_tcp"     // Decryption sidecar
+	DepTelegramSend   Dependency = "telegram_send"   // Sending messages and media
+	DepTelegramEdit   Dependency = "telegram_edit"   // Editing progress messages
+	DepTelegramUpload Dependency = "telegram_upload" // Uploading file parts
+)
+
+// LatencyStats are the percentiles of the recent calls to one dependency
+type LatencyStats struct {
+	Dependency   Dependency
+	Samples      int // Calls covered, at most latencyWindow
+	FirstByteP50 time.Duration
+	FirstByteP95 time.Duration
+	TotalP50     time.Duration
+	TotalP95     time.Duration
+}
+
+// latencySample is the timing of one call
+type latencySample struct {
+	firstByte time.Duration
+	total     time.Duration
+}
+
+// LatencyTracker keeps the first byte and total durations of the most recent
+// calls to each external dependency
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples map[Dependency][]latencySample
+	next    map[Dependency]int
+}
+
+// NewLatencyTracker creates a LatencyTracker with no calls recorded
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{
+		samples: make(map[Dependency][]latencySample),
+		next:    make(map[Dependency]int),
+	}
+}
+
+// Record adds a call to dep that answered after firstByte and finished after
+// total, replacing the oldest call once the window is full
+func (t *LatencyTracker) Record(dep Dependency, firstByte, total time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sample := latencySample{firstByte: firstByte, total: total}
+	if samples := t.samples[dep]; len(samples) < latencyWindow {
+		t.samples[dep] = append(samples, sample)
+		return
+	}
+	t.samples[dep][t.next[dep]] = sample
+	t.next[dep] = (t.next[dep] + 1) % latencyWindow
+}
+
+// Stats returns the percentiles of every dependency called, by name
+func (t *LatencyTracker) Stats() []LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make([]LatencyStats, 0, len(t.samples))
+	for dep, samples := range t.samples {
+		firstBytes := make([]time.Duration, len(samples))
+		totals := make([]time.Duration, len(samples))
+		for i, sample := range samples {
+			firstBytes[i] = sample.firstByte
+			totals[i] = sample.total
+		}
+		stats = append(stats, LatencyStats{
+			Dependency:   dep,
+			Samples:      len(samples),
+			FirstByteP50: percentile(firstBytes, 50),
+			FirstByteP95: percentile(firstBytes, 95),
+			TotalP50:     percentile(totals, 50),
+			TotalP95:     percentile(totals, 95),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Dependency < stats[j].Dependency
+	})
+	return stats
+}
+
+// ServeHTTP writes the percentiles in the Prometheus text format
+func (t *LatencyTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	stats := t.Stats()
+	fmt.Fprintln(w, "# HELP alac_dependency_latency_seconds Latency of recent calls to external dependencies")
+	fmt.Fprintln(w, "# TYPE alac_dependency_latency_seconds gauge")
+	for _, s := range stats {
+		for _, v := range []struct {
+			stage    string
+			quantile string
+			value    time.Duration
+		}{
+			{"first_byte", "0.5", s.FirstByteP50},
+			{"first_byte", "0.95", s.FirstByteP95},
+			{"total", "0.5", s.TotalP50},
+			{"total", "0.95", s.TotalP95},
+		} {
+			fmt.Fprintf(w, "alac_dependency_latency_seconds{dependency=%q,stage=%q,quantile=%q} %g\n",
+				s.Dependency, v.stage, v.quantile, v.value.Seconds())
+		}
+	}
+	fmt.Fprintln(w, "# HELP alac_dependency_latency_samples Recent calls the latency percentiles cover")
+	fmt.Fprintln(w, "# TYPE alac_dependency_latency_samples gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "alac_dependency_latency_samples{dependency=%q} %d\n", s.Dependency, s.Samples)
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of values, 0 when empty
+func percentile(values []time.Duration, p float64) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// latencyTransport records the timing of every request it sends as a call to dep
+type latencyTransport struct {
+	base    http.RoundTripper
+	dep     Dependency
+	tracker *LatencyTracker
+}
+
+// RoundTrip sends req, timing the first response byte with httptrace and the
+// total until the body is read to the end or closed
+func (lt *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	var firstByte time.Duration
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte = time.Since(start) },
+	}
+
+	resp, err := lt.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func() {
+		lt.tracker.Record(lt.dep, firstByte, time.Since(start))
+	}}
+	return resp, nil
+}
+
+// timedBody calls done once, when the body hits EOF or is closed
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+// timedConn records a TCP session as a call to dep: the first byte is the
+// first read after dialing, the total ends when the connection is closed
+type timedConn struct {
+	net.Conn
+	dep       Dependency
+	tracker   *LatencyTracker
+	start     time.Time
+	firstByte time.Duration
+	once      sync.Once
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.firstByte == 0 {
+		c.firstByte = time.Since(c.start)
+	}
+	return n, err
+}
+
+func (c *timedConn) Close() error {
+	c.once.Do(func() {
+		firstByte := c.firstByte
+		if firstByte == 0 {
+			firstByte = time.Since(c.start)
+		}
+		c.tracker.Record(c.dep, firstByte, time.Since(c.start))
+	})
+	return c.Conn.Close()
+}
+
+// httpClient returns the Apple Music client's HTTP client recording its
+// calls as calls to dep
+func (sd *SongDownloaderImpl) httpClient(dep Dependency, timeout time.Duration) *http.Client {
+	return sd.apple.httpClient(dep, timeout)
+}
+
+// dial connects to the sidecar at addr within timeout (0 = none), recording
+// the session as a call to dep
+func (sd *SongDownloaderImpl) dial(dep Dependency, addr string, timeout time.Duration) (net.Conn, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil || sd.latencies == nil {
+		return conn, err
+	}
+	return &timedConn{Conn: conn, dep: dep, tracker: sd.latencies, start: start}, nil
+}
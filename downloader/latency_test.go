@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		durations := make([]time.Duration, len(values))
+		for i, v := range values {
+			durations[i] = time.Duration(v) * time.Millisecond
+		}
+		return durations
+	}
+
+	tests := []struct {
+		name   string
+		values []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single", ms(7), 95, 7 * time.Millisecond},
+		{"median of odd count", ms(30, 10, 20), 50, 20 * time.Millisecond},
+		{"median of even count", ms(40, 10, 30, 20), 50, 20 * time.Millisecond},
+		{"p95 of 20", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 100), 95, 19 * time.Millisecond},
+		{"p95 of 21", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 100), 95, 20 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.values, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %v, want %v", tt.values, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyTracker_KeepsRecentWindow(t *testing.T) {
+	tracker := NewLatencyTracker()
+
+	// A slow early period followed by a full window of fast calls
+	for i := 0; i < latencyWindow; i++ {
+		tracker.Record(DepCatalogAPI, time.Second, 2*time.Second)
+	}
+	for i := 0; i < latencyWindow; i++ {
+		tracker.Record(DepCatalogAPI, 10*time.Millisecond, 20*time.Millisecond)
+	}
+	tracker.Record(DepDecrypt, time.Millisecond, time.Second)
+
+	stats := tracker.Stats()
+	if len(stats) != 2 || stats[0].Dependency != DepCatalogAPI || stats[1].Dependency != DepDecrypt {
+		t.Fatalf("Expected catalog_api and decrypt_tcp stats, got %+v", stats)
+	}
+	catalog := stats[0]
+	if catalog.Samples != latencyWindow {
+		t.Errorf("Expected %d samples, got %d", latencyWindow, catalog.Samples)
+	}
+	if catalog.FirstByteP95 != 10*time.Millisecond || catalog.TotalP95 != 20*time.Millisecond {
+		t.Errorf("Expected only the recent fast calls, got %+v", catalog)
+	}
+}
+
+func TestLatencyTracker_ServeHTTP(t *testing.T) {
+	tracker := NewLatencyTracker()
+	tracker.Record(DepMediaCDN, 250*time.Millisecond, 3*time.Second)
+
+	rec := httptest.NewRecorder()
+	tracker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`alac_dependency_latency_seconds{dependency="media_cdn",stage="first_byte",quantile="0.5"} 0.25`,
+		`alac_dependency_latency_seconds{dependency="media_cdn",stage="total",quantile="0.95"} 3`,
+		`alac_dependency_latency_samples{dependency="media_cdn"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestLatency_RecordsHTTPCalls(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string { return realPage })
+	sd := newTokenDownloader(server.URL, "")
+
+	if _, err := sd.GetToken(context.Background()); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	stats := sd.latencies.Stats()
+	if len(stats) != 1 || stats[0].Dependency != DepTokenPage {
+		t.Fatalf("Expected token_page stats, got %+v", stats)
+	}
+	// The page and its JS bundle
+	if stats[0].Samples != 2 {
+		t.Errorf("Expected 2 calls, got %d", stats[0].Samples)
+	}
+	if stats[0].FirstByteP50 <= 0 || stats[0].TotalP50 < stats[0].FirstByteP50 {
+		t.Errorf("Expected first byte within total, got %+v", stats[0])
+	}
+}
+
+func TestLatency_RecordsTCPSessions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	// A device sidecar answering every request with an HLS URL
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				length, err := reader.ReadByte()
+				if err != nil {
+					return
+				}
+				if _, err := reader.Discard(int(length)); err != nil {
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+				conn.Write([]byte("https://example.com/master.m3u8\n"))
+			}()
+		}
+	}()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.deviceUrl = listener.Addr().String()
+	for i := 0; i < 3; i++ {
+		if _, err := sd.GetEnhanceHls("1440818839"); err != nil {
+			t.Fatalf("GetEnhanceHls() error = %v", err)
+		}
+	}
+
+	stats := sd.latencies.Stats()
+	if len(stats) != 1 || stats[0].Dependency != DepDevice || stats[0].Samples != 3 {
+		t.Fatalf("Expected 3 device_tcp calls, got %+v", stats)
+	}
+	if stats[0].FirstByteP50 < 5*time.Millisecond {
+		t.Errorf("Expected first byte after the sidecar's delay, got %v", stats[0].FirstByteP50)
+	}
+}
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrLanguageUnavailable is returned when the catalog rejects the metadata
+// language of a request
+var ErrLanguageUnavailable = errors.New("metadata language not available")
+
+// getLocalizedSongMeta gets song metadata in the metadata language of the
+// options. When the catalog rejects the language, the rest of the download,
+// lyrics included, uses the storefront's default language instead
+func (sd *SongDownloaderImpl) getLocalizedSongMeta(ctx context.Context, urlMeta *URLMeta, token string, callbacks ProgressCallbacks) (*AutoSong, string, error) {
+	meta, token, err := sd.getSongMetaRefreshingToken(ctx, urlMeta, token)
+	if !errors.Is(err, ErrLanguageUnavailable) {
+		return meta, token, err
+	}
+
+	sd.warn(fmt.Sprintf("Metadata is not available in %s in the %s storefront, using its default language",
+		sd.options.MetadataLanguage, urlMeta.Storefront), callbacks)
+	sd.options.MetadataLanguage = ""
+	return sd.getSongMetaRefreshingToken(ctx, urlMeta, token)
+}
+
+// albumName returns the album name for the tags. With a catalog language set,
+// the album relationship carries the name in that language while the song's
+// albumName can stay in the storefront's, so the relationship's is preferred
+func albumName(meta *AutoSong) string {
+	if album := songAlbumAttributes(meta); album != nil && album.Name != "" {
+		return album.Name
+	}
+	return meta.Attributes.AlbumName
+}
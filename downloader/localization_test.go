@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// localizedSongs are catalog responses for song 1 on the jp storefront. The
+// song's albumName stays in the storefront's language whatever l is, while
+// the album relationship follows l
+var localizedSongs = map[string]string{
+	"": `{"data":[{"id":"1","type":"songs","attributes":{"name":"夜に駆ける","albumName":"夜に駆ける - Single","artistName":"YOASOBI"},
+		"relationships":{"albums":{"data":[{"id":"2","type":"albums","attributes":{"name":"夜に駆ける - Single","artistName":"YOASOBI"}}]}}}]}`,
+	"en-US": `{"data":[{"id":"1","type":"songs","attributes":{"name":"Racing Into The Night","albumName":"夜に駆ける - Single","artistName":"YOASOBI"},
+		"relationships":{"albums":{"data":[{"id":"2","type":"albums","attributes":{"name":"Racing Into The Night - Single","artistName":"YOASOBI"}}]}}}]}`,
+}
+
+// newLocalizedCatalog serves localizedSongs, their lyrics and album 2,
+// recording the l parameter of every request ("<unset>" when it is missing).
+// Songs in languages missing from localizedSongs are a bad request
+func newLocalizedCatalog(t *testing.T) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var languages []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language := "<unset>"
+		if r.URL.Query().Has("l") {
+			language = r.URL.Query().Get("l")
+		}
+		mu.Lock()
+		languages = append(languages, language)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/catalog/jp/songs/1":
+			song, ok := localizedSongs[language]
+			if !ok {
+				http.Error(w, `{"errors":[{"code":"40005","title":"Invalid Parameter Value"}]}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, song)
+		case "/v1/catalog/jp/songs/1/lyrics":
+			fmt.Fprint(w, `{"data":[{"attributes":{"ttml":"<tt><body><div><p>Line</p></div></body></tt>"}}]}`)
+		case "/v1/catalog/jp/albums/2":
+			fmt.Fprint(w, `{"data":[{"id":"2","type":"albums","attributes":{"name":"Album"}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), languages...)
+	}
+}
+
+func TestCatalogRequests_MetadataLanguage(t *testing.T) {
+	tests := []struct {
+		name    string
+		options DownloadOptions
+		want    string
+	}{
+		{name: "storefront default", options: DownloadOptions{}, want: ""},
+		{name: "metadata language", options: DownloadOptions{MetadataLanguage: "en-US"}, want: "en-US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requested := newLocalizedCatalog(t)
+			sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+			sd.apple.catalogURL = server.URL
+			sd.SetOptions(tt.options)
+
+			if _, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken); err != nil {
+				t.Fatalf("GetSongMeta() error = %v", err)
+			}
+			if _, err := sd.GetAlbumMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "albums", ID: "2"}, testToken); err != nil {
+				t.Fatalf("GetAlbumMeta() error = %v", err)
+			}
+
+			got := requested()
+			if len(got) != 2 || got[0] != tt.want || got[1] != tt.want {
+				t.Errorf("Catalog requests had l = %q, want %q for both", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogRequests_QueryString(t *testing.T) {
+	server, requested := newLocalizedCatalog(t)
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.apple.catalogURL = server.URL
+	sd.SetOptions(DownloadOptions{MetadataLanguage: "en-US"})
+
+	meta, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken)
+	if err != nil {
+		t.Fatalf("GetSongMeta() error = %v", err)
+	}
+	if meta.Attributes.Name != "Racing Into The Night" {
+		t.Errorf("Expected the English song name, got %q", meta.Attributes.Name)
+	}
+	if _, err := sd.fetchLyrics(context.Background(), "jp", "1", testToken); err != nil {
+		t.Fatalf("fetchLyrics() error = %v", err)
+	}
+	if got := requested(); len(got) != 2 || got[0] != "en-US" || got[1] != "en-US" {
+		t.Errorf("Expected the song and lyrics requests in en-US, got %q", got)
+	}
+
+	// The song request keeps its other parameters next to l
+	var query string
+	rawServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprint(w, localizedSongs["en-US"])
+	}))
+	defer rawServer.Close()
+	sd.apple.catalogURL = rawServer.URL
+	sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken)
+	if want := "extend=extendedAssetUrls&include=albums%2Cexplicit&l=en-US"; query != want {
+		t.Errorf("Song query = %q, want %q", query, want)
+	}
+}
+
+func TestGetLocalizedSongMeta_FallsBackToDefaultLanguage(t *testing.T) {
+	server, requested := newLocalizedCatalog(t)
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.apple.catalogURL = server.URL
+	sd.SetOptions(DownloadOptions{MetadataLanguage: "xx-YY"})
+
+	var warnings []string
+	callbacks := ProgressCallbacks{OnWarning: func(message string) { warnings = append(warnings, message) }}
+	meta, _, err := sd.getLocalizedSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken, callbacks)
+	if err != nil {
+		t.Fatalf("getLocalizedSongMeta() error = %v", err)
+	}
+	if meta.Attributes.Name != "夜に駆ける" {
+		t.Errorf("Expected the storefront-language song, got %q", meta.Attributes.Name)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "xx-YY") {
+		t.Errorf("Expected one warning naming the language, got %q", warnings)
+	}
+
+	// Lyrics follow the fallback
+	if _, err := sd.fetchLyrics(context.Background(), "jp", "1", testToken); err != nil {
+		t.Fatalf("fetchLyrics() error = %v", err)
+	}
+	if got := requested(); len(got) != 3 || got[0] != "xx-YY" || got[1] != "" || got[2] != "" {
+		t.Errorf("Expected xx-YY then the default language, got %q", got)
+	}
+
+	// Without a language a bad request is an ordinary failure
+	sd.SetOptions(DownloadOptions{})
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer badServer.Close()
+	sd.apple.catalogURL = badServer.URL
+	if _, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken); err == nil || errors.Is(err, ErrLanguageUnavailable) {
+		t.Errorf("Expected a plain error, got %v", err)
+	}
+}
+
+func TestAlbumName_PrefersLocalizedRelationship(t *testing.T) {
+	tests := []struct {
+		language string
+		want     string
+	}{
+		{language: "", want: "夜に駆ける - Single"},
+		{language: "en-US", want: "Racing Into The Night - Single"},
+	}
+
+	for _, tt := range tests {
+		t.Run("l="+tt.language, func(t *testing.T) {
+			server, _ := newLocalizedCatalog(t)
+			sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+			sd.apple.catalogURL = server.URL
+			sd.SetOptions(DownloadOptions{MetadataLanguage: tt.language})
+
+			meta, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: "jp", URLType: "songs", ID: "1"}, testToken)
+			if err != nil {
+				t.Fatalf("GetSongMeta() error = %v", err)
+			}
+			if got := albumName(meta); got != tt.want {
+				t.Errorf("albumName() = %q, want %q", got, tt.want)
+			}
+
+			// The ©alb atom carries the same name
+			raw, err := os.ReadFile(writeBotFixture(t, meta))
+			if err != nil {
+				t.Fatalf("Failed to read the written file: %v", err)
+			}
+			if !bytes.Contains(raw, []byte(tt.want)) {
+				t.Errorf("Expected the album tag to be %q", tt.want)
+			}
+			if tt.language != "" && bytes.Contains(raw, []byte(meta.Attributes.AlbumName)) {
+				t.Errorf("Expected the storefront-language album name %q not to be written", meta.Attributes.AlbumName)
+			}
+		})
+	}
+
+	// Without an album relationship the song's albumName is used
+	meta := retagTestMeta("Name")
+	meta.Relationships.Albums.Data = nil
+	if got := albumName(meta); got != "Album" {
+		t.Errorf("albumName() without a relationship = %q, want %q", got, "Album")
+	}
+}
@@ -0,0 +1,17 @@
+package downloader
+
+import (
+	"log"
+
+	"go-alac-bot/logging"
+)
+
+// logger receives the downloader's log entries. It writes INFO and above to
+// the standard logger's output until the bot sets its own
+var logger = logging.New(log.Writer(), logging.LevelInfo)
+
+// SetLogger sets the logger of the downloader, e.g. the bot's logger so that
+// LOG_LEVEL applies to downloads too
+func SetLogger(l logging.Logger) {
+	logger = l
+}
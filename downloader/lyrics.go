@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lyricsResponse is the catalog response of the lyrics of a song
+type lyricsResponse struct {
+	Data []struct {
+		Attributes struct {
+			TTML string `json:"ttml"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// fetchLyrics retrieves the lyrics of a song from Apple Music API as LRC.
+// Lyrics without timing come back as plain lines
+func (sd *SongDownloaderImpl) fetchLyrics(ctx context.Context, storefront, songID, token string) (string, error) {
+	query := url.Values{}
+	query.Set("l", sd.options.MetadataLanguage)
+
+	var response lyricsResponse
+	path := fmt.Sprintf("/v1/catalog/%s/songs/%s/lyrics", storefront, songID)
+	if err := sd.apple.GetCatalog(ctx, path, query, token, &response); err != nil {
+		return "", err
+	}
+	if len(response.Data) == 0 || response.Data[0].Attributes.TTML == "" {
+		return "", errors.New("no lyrics in response")
+	}
+
+	return ttmlToLRC(response.Data[0].Attributes.TTML)
+}
+
+// ttmlToLRC converts TTML lyrics to LRC, one line per <p> element. Word
+// timings inside a line are dropped
+func ttmlToLRC(ttml string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(ttml))
+
+	var lines []string
+	var text strings.Builder
+	var begin string
+	inLine := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid TTML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "p" {
+				inLine = true
+				begin = ""
+				text.Reset()
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "begin" {
+						begin = attr.Value
+					}
+				}
+			}
+		case xml.CharData:
+			if inLine {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local != "p" || !inLine {
+				continue
+			}
+			inLine = false
+
+			line := strings.Join(strings.Fields(text.String()), " ")
+			if begin == "" {
+				lines = append(lines, line)
+				continue
+			}
+			offset, err := parseTTMLTime(begin)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, lrcTimestamp(offset)+line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", errors.New("no lines in TTML")
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// parseTTMLTime parses a TTML clock time such as "1:02.345", "01:01:02.345",
+// "62.345" or "62.345s"
+func parseTTMLTime(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSuffix(value, "s"), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid TTML time %q", value)
+	}
+
+	var total float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid TTML time %q", value)
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total * float64(time.Second)).Round(time.Millisecond), nil
+}
+
+// lrcTimestamp formats an offset as an LRC time tag, e.g. "[01:02.34]"
+func lrcTimestamp(offset time.Duration) string {
+	centiseconds := offset.Milliseconds() / 10
+	return fmt.Sprintf("[%02d:%02d.%02d]", centiseconds/6000, centiseconds/100%60, centiseconds%100)
+}
+
+// lyricsItem builds a raw ©lyr ilst item holding the lyrics as UTF-8 text
+func lyricsItem(lyrics string) []byte {
+	dataSize := 16 + len(lyrics)
+	item := make([]byte, 8+dataSize)
+	binary.BigEndian.PutUint32(item[0:], uint32(len(item)))
+	copy(item[4:], "\251lyr")
+	binary.BigEndian.PutUint32(item[8:], uint32(dataSize))
+	copy(item[12:], "data")
+	binary.BigEndian.PutUint32(item[16:], 1) // UTF-8
+	// item[20:24] is the locale, left as 0
+	copy(item[24:], lyrics)
+	return item
+}
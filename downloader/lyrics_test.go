@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4"
+)
+
+const testTTML = `<tt xmlns="http://www.w3.org/ns/ttml" xmlns:itunes="http://music.apple.com/lyric-ttml-internal">
+<body><div begin="0.5" end="70.1">
+<p begin="0.5" end="3.2"><span begin="0.5">First</span> <span begin="1.0">line</span></p>
+<p begin="1:02.345" end="1:05">Second
+  line</p>
+<p begin="01:01:02.345s">Third line</p>
+</div></body></tt>`
+
+func TestTTMLToLRC(t *testing.T) {
+	lrc, err := ttmlToLRC(testTTML)
+	if err != nil {
+		t.Fatalf("ttmlToLRC() error = %v", err)
+	}
+	want := "[00:00.50]First line\n[01:02.34]Second line\n[61:02.34]Third line\n"
+	if lrc != want {
+		t.Errorf("ttmlToLRC() = %q, want %q", lrc, want)
+	}
+
+	unsynced, err := ttmlToLRC(`<tt><body><div><p>Plain one</p><p>Plain two</p></div></body></tt>`)
+	if err != nil || unsynced != "Plain one\nPlain two\n" {
+		t.Errorf("Expected plain lines for unsynced lyrics, got %q, %v", unsynced, err)
+	}
+
+	for _, invalid := range []string{`<tt><body></body></tt>`, `<tt><p begin="x">Line</p></tt>`, `<tt><p>`} {
+		if _, err := ttmlToLRC(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestParseTTMLTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"62.345", 62345 * time.Millisecond},
+		{"62.345s", 62345 * time.Millisecond},
+		{"1:02.345", 62345 * time.Millisecond},
+		{"01:01:02.345", time.Hour + 62345*time.Millisecond},
+	}
+	for _, tt := range tests {
+		got, err := parseTTMLTime(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTTMLTime(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := parseTTMLTime("1:2:3:4"); err == nil {
+		t.Error("Expected an error for too many fields")
+	}
+}
+
+func TestFetchLyrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/us/songs/1/lyrics":
+			fmt.Fprintf(w, `{"data":[{"id":"1","type":"lyrics","attributes":{"ttml":%q}}]}`, testTTML)
+		case "/v1/catalog/us/songs/2/lyrics":
+			fmt.Fprint(w, `{"data":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.apple.catalogURL = server.URL
+
+	lrc, err := sd.fetchLyrics(context.Background(), "us", "1", testToken)
+	if err != nil || lrc == "" {
+		t.Fatalf("fetchLyrics() = %q, %v", lrc, err)
+	}
+	if _, err := sd.fetchLyrics(context.Background(), "us", "2", testToken); err == nil {
+		t.Error("Expected an error for a response without lyrics")
+	}
+	if _, err := sd.fetchLyrics(context.Background(), "us", "3", testToken); err == nil {
+		t.Error("Expected an error for a song without a lyrics endpoint")
+	}
+}
+
+func TestWriteM4a_Lyrics(t *testing.T) {
+	info, err := parseSongInfo(buildFragmentedFixture(t, 1, []uint32{1, 1, 1}))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	var data []byte
+	for _, sample := range info.samples {
+		data = append(data, sample.data...)
+	}
+	info.lyrics = "[00:00.50]First line\n"
+
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	_, err = sd.WriteM4a(mp4.NewWriter(file), info, retagTestMeta("Song"), nil, data)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Failed to write M4A: %v", err)
+	}
+
+	// Retagging keeps the lyrics, they are not a generated tag
+	if err := RetagFile(path, retagTestMeta("Renamed")); err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if !bytes.Contains(raw, lyricsItem(info.lyrics)) {
+		t.Error("Expected the ©lyr item to be kept in the file")
+	}
+}
@@ -1,21 +1,38 @@
 package downloader
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 
 	"github.com/abema/go-mp4"
 )
 
-// WriteM4a writes the decrypted song data to an M4A file
-func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *AutoSong, data []byte) error {
-	albums := meta.Relationships.Albums.Data
-	artists := meta.Relationships.Artists.Data
+// largeDataSize is the most audio written with 32-bit chunk offsets. It
+// leaves room below 4 GiB for the moov box written before the audio, which
+// the offsets are checked against once known
+const largeDataSize = math.MaxUint32 - 64<<20
+
+// WriteM4a writes the decrypted song data to an M4A file with cover, a JPEG
+// or PNG, as its artwork when non-nil, and returns the warnings of the tags
+// that could not be written
+func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *AutoSong, cover []byte, data []byte) ([]string, error) {
+	return sd.writeM4a(w, info, meta, nil, cover, bytes.NewReader(data))
+}
+
+// writeM4a writes an M4A file whose mdat is copied from data, the decrypted
+// samples in order, so the audio never has to be in memory at once. Album,
+// when known, supplies the track and disc totals. A non-nil cover is written
+// as the covr item, so the file is tagged in a single pass
+func (sd *SongDownloaderImpl) writeM4a(w *mp4.Writer, info *SongInfo, meta *AutoSong, album *AlbumContext, cover []byte, data io.Reader) (warnings []string, err error) {
 	{ // ftyp
 		box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeFtyp()})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		_, err = mp4.Marshal(w, &mp4.Ftyp{
 			MajorBrand:   [4]byte{'M', '4', 'A', ' '},
@@ -28,39 +45,52 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 			},
 		}, box.Context)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		_, err = w.EndBox()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	const chunkSize uint32 = 5
 	duration := info.Duration()
+	if uint64(len(info.samples)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%d samples do not fit the sample tables", len(info.samples))
+	}
 	numSamples := uint32(len(info.samples))
 	var stco *mp4.BoxInfo
 
+	var dataSize uint64
+	for i, sample := range info.samples {
+		if uint64(sample.size()) > math.MaxUint32 {
+			return nil, fmt.Errorf("sample %d of %d bytes does not fit the stsz box", i+1, sample.size())
+		}
+		dataSize += uint64(sample.size())
+	}
+	// Audio reaching past 4 GiB needs 64-bit chunk offsets and mdat size
+	large := dataSize > largeDataSize
+
 	{ // moov
 		_, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMoov()})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		box, err := mp4.ExtractBox(info.r, nil, mp4.BoxPath{mp4.BoxTypeMoov()})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		moovOri := box[0]
 
 		{ // mvhd
 			_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMvhd()})
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			oriBox, err := mp4.ExtractBoxWithPayload(info.r, moovOri, mp4.BoxPath{mp4.BoxTypeMvhd()})
 			if err != nil {
-				return err
+				return nil, err
 			}
 			mvhd := oriBox[0].Payload.(*mp4.Mvhd)
 			if mvhd.Version == 0 {
@@ -71,36 +101,36 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 
 			_, err = mp4.Marshal(w, mvhd, oriBox[0].Info.Context)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			_, err = w.EndBox()
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 
 		{ // trak
 			_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTrak()})
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			box, err := mp4.ExtractBox(info.r, moovOri, mp4.BoxPath{mp4.BoxTypeTrak()})
 			if err != nil {
-				return err
+				return nil, err
 			}
 			trakOri := box[0]
 
 			{ // tkhd
 				_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeTkhd()})
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				oriBox, err := mp4.ExtractBoxWithPayload(info.r, trakOri, mp4.BoxPath{mp4.BoxTypeTkhd()})
 				if err != nil {
-					return err
+					return nil, err
 				}
 				tkhd := oriBox[0].Payload.(*mp4.Tkhd)
 				if tkhd.Version == 0 {
@@ -112,36 +142,36 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 
 				_, err = mp4.Marshal(w, tkhd, oriBox[0].Info.Context)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				_, err = w.EndBox()
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
 
 			{ // mdia
 				_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdia()})
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				box, err := mp4.ExtractBox(info.r, trakOri, mp4.BoxPath{mp4.BoxTypeMdia()})
 				if err != nil {
-					return err
+					return nil, err
 				}
 				mdiaOri := box[0]
 
 				{ // mdhd
 					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdhd()})
 					if err != nil {
-						return err
+						return nil, err
 					}
 
 					oriBox, err := mp4.ExtractBoxWithPayload(info.r, mdiaOri, mp4.BoxPath{mp4.BoxTypeMdhd()})
 					if err != nil {
-						return err
+						return nil, err
 					}
 					mdhd := oriBox[0].Payload.(*mp4.Mdhd)
 					if mdhd.Version == 0 {
@@ -152,36 +182,36 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 
 					_, err = mp4.Marshal(w, mdhd, oriBox[0].Info.Context)
 					if err != nil {
-						return err
+						return nil, err
 					}
 
 					_, err = w.EndBox()
 					if err != nil {
-						return err
+						return nil, err
 					}
 				}
 
 				{ // hdlr
 					oriBox, err := mp4.ExtractBox(info.r, mdiaOri, mp4.BoxPath{mp4.BoxTypeHdlr()})
 					if err != nil {
-						return err
+						return nil, err
 					}
 
 					err = w.CopyBox(info.r, oriBox[0])
 					if err != nil {
-						return err
+						return nil, err
 					}
 				}
 
 				{ // minf
 					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMinf()})
 					if err != nil {
-						return err
+						return nil, err
 					}
 
 					box, err := mp4.ExtractBox(info.r, mdiaOri, mp4.BoxPath{mp4.BoxTypeMinf()})
 					if err != nil {
-						return err
+						return nil, err
 					}
 					minfOri := box[0]
 
@@ -191,13 +221,13 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 							{mp4.BoxTypeDinf()},
 						})
 						if err != nil {
-							return err
+							return nil, err
 						}
 
 						for _, b := range boxes {
 							err = w.CopyBox(info.r, b)
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 					}
@@ -205,88 +235,93 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 					{ // stbl
 						_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStbl()})
 						if err != nil {
-							return err
+							return nil, err
 						}
 
 						{ // stsd
 							box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsd()})
 							if err != nil {
-								return err
+								return nil, err
 							}
 							_, err = mp4.Marshal(w, &mp4.Stsd{EntryCount: 1}, box.Context)
 							if err != nil {
-								return err
+								return nil, err
 							}
 
-							{ // alac
+							if info.alacParam == nil { // mp4a
+								err = writeAACSampleEntry(w, info)
+								if err != nil {
+									return nil, err
+								}
+							} else { // alac
 								_, err = w.StartBox(&mp4.BoxInfo{Type: BoxTypeAlac()})
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = w.Write([]byte{
 									0, 0, 0, 0, 0, 0, 0, 1,
 									0, 0, 0, 0, 0, 0, 0, 0})
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								err = binary.Write(w, binary.BigEndian, uint16(info.alacParam.NumChannels))
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								err = binary.Write(w, binary.BigEndian, uint16(info.alacParam.BitDepth))
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = w.Write([]byte{0, 0})
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								err = binary.Write(w, binary.BigEndian, info.alacParam.SampleRate)
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = w.Write([]byte{0, 0})
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								box, err := w.StartBox(&mp4.BoxInfo{Type: BoxTypeAlac()})
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = mp4.Marshal(w, info.alacParam, box.Context)
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = w.EndBox()
 								if err != nil {
-									return err
+									return nil, err
 								}
 
 								_, err = w.EndBox()
 								if err != nil {
-									return err
+									return nil, err
 								}
 							}
 
 							_, err = w.EndBox()
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 
 						{ // stts
 							box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStts()})
 							if err != nil {
-								return err
+								return nil, err
 							}
 
 							var stts mp4.Stts
@@ -307,28 +342,33 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 
 							_, err = mp4.Marshal(w, &stts, box.Context)
 							if err != nil {
-								return err
+								return nil, err
 							}
 
 							_, err = w.EndBox()
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 
 						{ // stsc
 							box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsc()})
 							if err != nil {
-								return err
+								return nil, err
 							}
 
-							if numSamples%chunkSize == 0 {
+							if numSamples%chunkSize == 0 || numSamples < chunkSize {
+								// A single chunk when there are fewer samples than fill one
+								samplesPerChunk := chunkSize
+								if numSamples < chunkSize {
+									samplesPerChunk = numSamples
+								}
 								_, err = mp4.Marshal(w, &mp4.Stsc{
 									EntryCount: 1,
 									Entries: []mp4.StscEntry{
 										{
 											FirstChunk:             1,
-											SamplesPerChunk:        chunkSize,
+											SamplesPerChunk:        samplesPerChunk,
 											SampleDescriptionIndex: 1,
 										},
 									},
@@ -352,478 +392,596 @@ func (sd *SongDownloaderImpl) WriteM4a(w *mp4.Writer, info *SongInfo, meta *Auto
 
 							_, err = w.EndBox()
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 
 						{ // stsz
 							box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStsz()})
 							if err != nil {
-								return err
+								return nil, err
 							}
 
 							stsz := mp4.Stsz{SampleCount: numSamples}
 							for _, sample := range info.samples {
-								stsz.EntrySize = append(stsz.EntrySize, uint32(len(sample.data)))
+								stsz.EntrySize = append(stsz.EntrySize, uint32(sample.size()))
 							}
 
 							_, err = mp4.Marshal(w, &stsz, box.Context)
 							if err != nil {
-								return err
+								return nil, err
 							}
 
 							_, err = w.EndBox()
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 
-						{ // stco
-							box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeStco()})
+						{ // stco, or co64 for large files
+							boxType := mp4.BoxTypeStco()
+							if large {
+								boxType = mp4.BoxTypeCo64()
+							}
+							box, err := w.StartBox(&mp4.BoxInfo{Type: boxType})
 							if err != nil {
-								return err
+								return nil, err
 							}
 
+							// A placeholder of the same size, filled in once
+							// the mdat offset is known
 							l := (numSamples + chunkSize - 1) / chunkSize
-							_, err = mp4.Marshal(w, &mp4.Stco{
-								EntryCount:  l,
-								ChunkOffset: make([]uint32, l),
-							}, box.Context)
+							_, err = mp4.Marshal(w, chunkOffsetBox(large, make([]uint64, l)), box.Context)
+							if err != nil {
+								return nil, err
+							}
 
 							stco, err = w.EndBox()
 							if err != nil {
-								return err
+								return nil, err
 							}
 						}
 
 						_, err = w.EndBox()
 						if err != nil {
-							return err
+							return nil, err
 						}
 					}
 
 					_, err = w.EndBox()
 					if err != nil {
-						return err
+						return nil, err
 					}
 				}
 
 				_, err = w.EndBox()
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
 
 			_, err = w.EndBox()
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 
-		{ // udta
-			ctx := mp4.Context{UnderUdta: true}
-			_, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeUdta(), Context: ctx})
-			if err != nil {
-				return err
-			}
-
-			{ // meta
-				ctx.UnderIlstMeta = true
+		var items []byte
+		if info.lyrics != "" {
+			items = lyricsItem(info.lyrics)
+		}
+		if cover != nil {
+			items = append(items, coverItem(cover)...)
+		}
+		warnings, err = writeUdta(w, meta, album, items)
+		if err != nil {
+			return nil, err
+		}
 
-				_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMeta(), Context: ctx})
-				if err != nil {
-					return err
-				}
+		_, err = w.EndBox()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-				_, err = mp4.Marshal(w, &mp4.Meta{}, ctx)
-				if err != nil {
-					return err
-				}
+	{
+		mdatInfo := &mp4.BoxInfo{Type: mp4.BoxTypeMdat()}
+		if large {
+			// The size is written once the audio is, so its header must
+			// have room for a 64-bit size from the start
+			mdatInfo.HeaderSize = mp4.LargeHeaderSize
+		}
+		box, err := w.StartBox(mdatInfo)
+		if err != nil {
+			return nil, err
+		}
 
-				{ // hdlr
-					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeHdlr(), Context: ctx})
-					if err != nil {
-						return err
-					}
+		// Same bytes as marshaling an Mdat holding the data
+		written, err := io.Copy(w, data)
+		if err != nil {
+			return nil, err
+		}
+		if written != info.totalDataSize && info.totalDataSize != 0 {
+			return nil, fmt.Errorf("wrote %d bytes of audio, expected %d", written, info.totalDataSize)
+		}
 
-					_, err = mp4.Marshal(w, &mp4.Hdlr{
-						HandlerType: [4]byte{'m', 'd', 'i', 'r'},
-						Reserved:    [3]uint32{0x6170706c, 0, 0},
-					}, ctx)
-					if err != nil {
-						return err
-					}
+		mdat, err := w.EndBox()
+		if err != nil {
+			return nil, err
+		}
 
-					_, err = w.EndBox()
-					if err != nil {
-						return err
-					}
+		var offsets []uint64
+		offset := mdat.Offset + mdat.HeaderSize
+		for i := uint32(0); i < numSamples; i++ {
+			if i%chunkSize == 0 {
+				if !large && offset > math.MaxUint32 {
+					return nil, fmt.Errorf("chunk offset %d does not fit the stco box", offset)
 				}
+				offsets = append(offsets, offset)
+			}
+			offset += uint64(info.samples[i].size())
+		}
 
-				{ // ilst
-					ctx.UnderIlst = true
+		_, err = stco.SeekToPayload(w)
+		if err != nil {
+			return nil, err
+		}
+		_, err = mp4.Marshal(w, chunkOffsetBox(large, offsets), box.Context)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeIlst(), Context: ctx})
-					if err != nil {
-						return err
-					}
+	return warnings, nil
 
-					marshalData := func(val interface{}) error {
-						_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeData()})
-						if err != nil {
-							return err
-						}
+}
 
-						var boxData mp4.Data
-						switch v := val.(type) {
-						case string:
-							boxData.DataType = mp4.DataTypeStringUTF8
-							boxData.Data = []byte(v)
-						case uint8:
-							boxData.DataType = mp4.DataTypeSignedIntBigEndian
-							boxData.Data = []byte{v}
-						case uint32:
-							boxData.DataType = mp4.DataTypeSignedIntBigEndian
-							boxData.Data = make([]byte, 4)
-							binary.BigEndian.PutUint32(boxData.Data, v)
-						case []byte:
-							boxData.DataType = mp4.DataTypeBinary
-							boxData.Data = v
-						default:
-							panic("unsupported value")
-						}
+// writeUdta writes the udta box holding the iTunes metadata tags. Preserved
+// holds raw ilst items copied verbatim after the generated tags. Tags whose
+// value is missing or invalid, such as the album tags of a song without album
+// relationship, are skipped and reported as warnings; only failing to write
+// the box itself is an error
+func writeUdta(w *mp4.Writer, meta *AutoSong, album *AlbumContext, preserved []byte) ([]string, error) {
+	albums := meta.Relationships.Albums.Data
+	artists := meta.Relationships.Artists.Data
+	albumAttrs := songAlbumAttributes(meta)
 
-						_, err = mp4.Marshal(w, &boxData, ctx)
-						if err != nil {
-							return err
-						}
+	var warnings []string
+	warn := func(tag, format string, args ...interface{}) {
+		warnings = append(warnings, tag+": "+fmt.Sprintf(format, args...))
+	}
 
-						_, err = w.EndBox()
-						return err
-					}
+	ctx := mp4.Context{UnderUdta: true}
+	_, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeUdta(), Context: ctx})
+	if err != nil {
+		return nil, err
+	}
 
-					addMeta := func(tag mp4.BoxType, val interface{}) error {
-						_, err = w.StartBox(&mp4.BoxInfo{Type: tag})
-						if err != nil {
-							return err
-						}
+	{ // meta
+		ctx.UnderIlstMeta = true
 
-						err = marshalData(val)
-						if err != nil {
-							return err
-						}
+		_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMeta(), Context: ctx})
+		if err != nil {
+			return nil, err
+		}
 
-						_, err = w.EndBox()
-						return err
-					}
+		_, err = mp4.Marshal(w, &mp4.Meta{}, ctx)
+		if err != nil {
+			return nil, err
+		}
 
-					addExtendedMeta := func(name string, val interface{}) error {
-						ctx.UnderIlstFreeMeta = true
+		{ // hdlr
+			_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeHdlr(), Context: ctx})
+			if err != nil {
+				return nil, err
+			}
 
-						_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'-', '-', '-', '-'}, Context: ctx})
-						if err != nil {
-							return err
-						}
+			_, err = mp4.Marshal(w, &mp4.Hdlr{
+				HandlerType: [4]byte{'m', 'd', 'i', 'r'},
+				Reserved:    [3]uint32{0x6170706c, 0, 0},
+			}, ctx)
+			if err != nil {
+				return nil, err
+			}
 
-						{
-							_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'m', 'e', 'a', 'n'}, Context: ctx})
-							if err != nil {
-								return err
-							}
+			_, err = w.EndBox()
+			if err != nil {
+				return nil, err
+			}
+		}
 
-							_, err = w.Write([]byte{0, 0, 0, 0})
-							if err != nil {
-								return err
-							}
+		{ // ilst
+			ctx.UnderIlst = true
 
-							_, err = io.WriteString(w, "com.apple.iTunes")
-							if err != nil {
-								return err
-							}
+			_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeIlst(), Context: ctx})
+			if err != nil {
+				return nil, err
+			}
 
-							_, err = w.EndBox()
-							if err != nil {
-								return err
-							}
-						}
+			marshalData := func(val interface{}) error {
+				_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeData()})
+				if err != nil {
+					return err
+				}
 
-						{
-							_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'n', 'a', 'm', 'e'}, Context: ctx})
-							if err != nil {
-								return err
-							}
+				var boxData mp4.Data
+				switch v := val.(type) {
+				case string:
+					boxData.DataType = mp4.DataTypeStringUTF8
+					boxData.Data = []byte(v)
+				case uint8:
+					boxData.DataType = mp4.DataTypeSignedIntBigEndian
+					boxData.Data = []byte{v}
+				case uint32:
+					boxData.DataType = mp4.DataTypeSignedIntBigEndian
+					boxData.Data = make([]byte, 4)
+					binary.BigEndian.PutUint32(boxData.Data, v)
+				case []byte:
+					boxData.DataType = mp4.DataTypeBinary
+					boxData.Data = v
+				default:
+					panic("unsupported value")
+				}
 
-							_, err = w.Write([]byte{0, 0, 0, 0})
-							if err != nil {
-								return err
-							}
+				_, err = mp4.Marshal(w, &boxData, ctx)
+				if err != nil {
+					return err
+				}
 
-							_, err = io.WriteString(w, name)
-							if err != nil {
-								return err
-							}
+				_, err = w.EndBox()
+				return err
+			}
 
-							_, err = w.EndBox()
-							if err != nil {
-								return err
-							}
-						}
+			addMeta := func(tag mp4.BoxType, val interface{}) error {
+				_, err = w.StartBox(&mp4.BoxInfo{Type: tag})
+				if err != nil {
+					return err
+				}
 
-						err = marshalData(val)
-						if err != nil {
-							return err
-						}
+				err = marshalData(val)
+				if err != nil {
+					return err
+				}
 
-						ctx.UnderIlstFreeMeta = false
+				_, err = w.EndBox()
+				return err
+			}
 
-						_, err = w.EndBox()
-						return err
-					}
+			addExtendedMeta := func(name string, val interface{}) error {
+				ctx.UnderIlstFreeMeta = true
 
-					err = addMeta(mp4.BoxType{'\251', 'n', 'a', 'm'}, meta.Attributes.Name)
-					if err != nil {
-						return err
-					}
+				_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'-', '-', '-', '-'}, Context: ctx})
+				if err != nil {
+					return err
+				}
 
-					err = addMeta(mp4.BoxType{'s', 'o', 'n', 'm'}, meta.Attributes.Name)
-					if err != nil {
-						return err
-					}
-					AlbumName := meta.Attributes.AlbumName
-					//if strings.Contains(meta.ID, "pl.") {
-					//	if !config.UseSongInfoForPlaylist {
-					//		AlbumName = meta.Data[0].Attributes.Name
-					//	}
-					//}
-					err = addMeta(mp4.BoxType{'\251', 'a', 'l', 'b'}, AlbumName)
+				{
+					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'m', 'e', 'a', 'n'}, Context: ctx})
 					if err != nil {
 						return err
 					}
 
-					err = addMeta(mp4.BoxType{'s', 'o', 'a', 'l'}, AlbumName)
+					_, err = w.Write([]byte{0, 0, 0, 0})
 					if err != nil {
 						return err
 					}
 
-					err = addMeta(mp4.BoxType{'\251', 'A', 'R', 'T'}, meta.Attributes.ArtistName)
+					_, err = io.WriteString(w, "com.apple.iTunes")
 					if err != nil {
 						return err
 					}
 
-					err = addMeta(mp4.BoxType{'s', 'o', 'a', 'r'}, meta.Attributes.ArtistName)
+					_, err = w.EndBox()
 					if err != nil {
 						return err
 					}
+				}
 
-					err = addMeta(mp4.BoxType{'\251', 'p', 'r', 'f'}, meta.Attributes.ArtistName)
+				{
+					_, err = w.StartBox(&mp4.BoxInfo{Type: mp4.BoxType{'n', 'a', 'm', 'e'}, Context: ctx})
 					if err != nil {
 						return err
 					}
 
-					err = addExtendedMeta("PERFORMER", meta.Attributes.ArtistName)
+					_, err = w.Write([]byte{0, 0, 0, 0})
 					if err != nil {
 						return err
 					}
 
-					err = addExtendedMeta("ITUNESALBUMID", albums[0].ID)
+					_, err = io.WriteString(w, name)
 					if err != nil {
 						return err
 					}
 
-					err = addMeta(mp4.BoxType{'\251', 'w', 'r', 't'}, meta.Attributes.ComposerName)
+					_, err = w.EndBox()
 					if err != nil {
 						return err
 					}
+				}
 
-					err = addMeta(mp4.BoxType{'s', 'o', 'c', 'o'}, meta.Attributes.ComposerName)
-					if err != nil {
-						return err
-					}
+				err = marshalData(val)
+				if err != nil {
+					return err
+				}
 
-					err = addMeta(mp4.BoxType{'\251', 'd', 'a', 'y'}, meta.Attributes.ReleaseDate)
-					if err != nil {
-						return err
-					}
+				ctx.UnderIlstFreeMeta = false
 
-					err = addExtendedMeta("RELEASETIME", meta.Attributes.ReleaseDate)
-					if err != nil {
-						return err
-					}
+				_, err = w.EndBox()
+				return err
+			}
 
-					cnID, err := strconv.ParseUint(meta.ID, 10, 32)
-					if err != nil {
-						return err
-					}
+			err = addMeta(mp4.BoxType{'\251', 'n', 'a', 'm'}, meta.Attributes.Name)
+			if err != nil {
+				return nil, err
+			}
 
-					err = addMeta(mp4.BoxType{'c', 'n', 'I', 'D'}, uint32(cnID))
-					if err != nil {
-						return err
-					}
+			err = addMeta(mp4.BoxType{'s', 'o', 'n', 'm'}, meta.Attributes.Name)
+			if err != nil {
+				return nil, err
+			}
+			AlbumName := albumName(meta)
+			//if strings.Contains(meta.ID, "pl.") {
+			//	if !config.UseSongInfoForPlaylist {
+			//		AlbumName = meta.Data[0].Attributes.Name
+			//	}
+			//}
+			err = addMeta(mp4.BoxType{'\251', 'a', 'l', 'b'}, AlbumName)
+			if err != nil {
+				return nil, err
+			}
 
-					err = addExtendedMeta("ISRC", meta.Attributes.ISRC)
-					if err != nil {
-						return err
-					}
+			err = addMeta(mp4.BoxType{'s', 'o', 'a', 'l'}, AlbumName)
+			if err != nil {
+				return nil, err
+			}
 
-					if len(meta.Attributes.GenreNames) > 0 {
-						err = addMeta(mp4.BoxType{'\251', 'g', 'e', 'n'}, meta.Attributes.GenreNames[0])
-						if err != nil {
-							return err
-						}
-					}
+			err = addMeta(mp4.BoxType{'\251', 'A', 'R', 'T'}, meta.Attributes.ArtistName)
+			if err != nil {
+				return nil, err
+			}
 
-					if len(albums) > 0 {
+			err = addMeta(mp4.BoxType{'s', 'o', 'a', 'r'}, meta.Attributes.ArtistName)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addMeta(mp4.BoxType{'a', 'A', 'R', 'T'}, meta.Attributes.ArtistName)
-						if err != nil {
-							return err
-						}
+			err = addMeta(mp4.BoxType{'\251', 'p', 'r', 'f'}, meta.Attributes.ArtistName)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addMeta(mp4.BoxType{'s', 'o', 'a', 'a'}, meta.Attributes.ArtistName)
-						if err != nil {
-							return err
-						}
+			err = addExtendedMeta("PERFORMER", meta.Attributes.ArtistName)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addMeta(mp4.BoxType{'c', 'p', 'r', 't'}, albums[0].Attributes.Copyright)
-						if err != nil {
-							return err
-						}
+			if len(albums) > 0 && albums[0].ID != "" {
+				err = addExtendedMeta("ITUNESALBUMID", albums[0].ID)
+				if err != nil {
+					return nil, err
+				}
+			}
 
-						var isCpil uint8
-						if albums[0].Attributes.IsCompilation {
-							isCpil = 1
-						}
-						err = addMeta(mp4.BoxType{'c', 'p', 'i', 'l'}, isCpil)
-						if err != nil {
-							return err
-						}
+			err = addMeta(mp4.BoxType{'\251', 'w', 'r', 't'}, meta.Attributes.ComposerName)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addMeta(mp4.BoxType{'\251', 'p', 'u', 'b'}, albums[0].Attributes.RecordLabel)
-						if err != nil {
-							return err
-						}
+			err = addMeta(mp4.BoxType{'s', 'o', 'c', 'o'}, meta.Attributes.ComposerName)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addExtendedMeta("LABEL", albums[0].Attributes.RecordLabel)
-						if err != nil {
-							return err
-						}
+			err = addMeta(mp4.BoxType{'\251', 'd', 'a', 'y'}, meta.Attributes.ReleaseDate)
+			if err != nil {
+				return nil, err
+			}
 
-						err = addExtendedMeta("UPC", albums[0].Attributes.UPC)
-						if err != nil {
-							return err
-						}
+			err = addExtendedMeta("RELEASETIME", meta.Attributes.ReleaseDate)
+			if err != nil {
+				return nil, err
+			}
 
-						//if !strings.Contains(meta.Data[0].ID, "pl.") {
-						//	plID, err := strconv.ParseUint(meta.Data[0].ID, 10, 32)
-						//	if err != nil {
-						//		return err
-						//	}
-						//
-						//	err = addMeta(mp4.BoxType{'p', 'l', 'I', 'D'}, uint32(plID))
-						//	if err != nil {
-						//		return err
-						//	}
-						//}
-					}
+			if cnID, parseErr := strconv.ParseUint(meta.ID, 10, 32); parseErr != nil {
+				warn("cnID", "invalid song ID %q", meta.ID)
+			} else {
+				err = addMeta(mp4.BoxType{'c', 'n', 'I', 'D'}, uint32(cnID))
+				if err != nil {
+					return nil, err
+				}
+			}
 
-					if len(artists) > 0 {
-						if len(artists[0].ID) > 0 {
-							atID, err := strconv.ParseUint(artists[0].ID, 10, 32)
-							if err != nil {
-								return err
-							}
+			err = addExtendedMeta("ISRC", meta.Attributes.ISRC)
+			if err != nil {
+				return nil, err
+			}
 
-							err = addMeta(mp4.BoxType{'a', 't', 'I', 'D'}, uint32(atID))
-							if err != nil {
-								return err
-							}
-						}
-					}
-					trkn := make([]byte, 8)
-					disk := make([]byte, 8)
-					binary.BigEndian.PutUint32(trkn, uint32(meta.Attributes.TrackNumber))
-					binary.BigEndian.PutUint16(trkn[4:], uint16(albums[0].Attributes.TrackCount))
-					binary.BigEndian.PutUint32(disk, uint32(meta.Attributes.DiscNumber))
-					//binary.BigEndian.PutUint16(disk[4:], uint16(meta.Data[0].Relationships.Tracks.Data[trackTotal-1].Attributes.DiscNumber))
-					//if strings.Contains(meta.Data[0].ID, "pl.") {
-					//	if !config.UseSongInfoForPlaylist {
-					//		binary.BigEndian.PutUint32(trkn, uint32(trackNum))
-					//		binary.BigEndian.PutUint16(trkn[4:], uint16(trackTotal))
-					//		binary.BigEndian.PutUint32(disk, uint32(1))
-					//		binary.BigEndian.PutUint16(disk[4:], uint16(1))
-					//	}
-					//}
-					err = addMeta(mp4.BoxType{'t', 'r', 'k', 'n'}, trkn)
-					if err != nil {
-						return err
-					}
-					err = addMeta(mp4.BoxType{'d', 'i', 's', 'k'}, disk)
-					if err != nil {
-						return err
-					}
+			genres := normalizeGenres(meta.Attributes.GenreNames)
+			if genres.Primary != "" {
+				err = addMeta(mp4.BoxType{'\251', 'g', 'e', 'n'}, genres.Primary)
+				if err != nil {
+					return nil, err
+				}
 
-					ctx.UnderIlst = false
+				err = addExtendedMeta("GENRES", strings.Join(genres.All, ";"))
+				if err != nil {
+					return nil, err
+				}
+			}
 
-					_, err = w.EndBox()
-					if err != nil {
-						return err
+			if genres.ID3v1 != 0 {
+				gnre := make([]byte, 2)
+				binary.BigEndian.PutUint16(gnre, genres.ID3v1)
+				err = addMeta(mp4.BoxType{'g', 'n', 'r', 'e'}, gnre)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if len(albums) == 0 {
+				warn("album", "song has no album relationship, album tags skipped")
+			} else {
+				err = addMeta(mp4.BoxType{'a', 'A', 'R', 'T'}, meta.Attributes.ArtistName)
+				if err != nil {
+					return nil, err
+				}
+
+				err = addMeta(mp4.BoxType{'s', 'o', 'a', 'a'}, meta.Attributes.ArtistName)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if len(albums) > 0 && albumAttrs == nil {
+				warn("album", "album %s has no attributes, album tags skipped", albums[0].ID)
+			} else if albumAttrs != nil {
+				err = addMeta(mp4.BoxType{'c', 'p', 'r', 't'}, albumAttrs.Copyright)
+				if err != nil {
+					return nil, err
+				}
+
+				var isCpil uint8
+				if albumAttrs.IsCompilation {
+					isCpil = 1
+				}
+				err = addMeta(mp4.BoxType{'c', 'p', 'i', 'l'}, isCpil)
+				if err != nil {
+					return nil, err
+				}
+
+				err = addMeta(mp4.BoxType{'\251', 'p', 'u', 'b'}, albumAttrs.RecordLabel)
+				if err != nil {
+					return nil, err
+				}
+
+				err = addExtendedMeta("LABEL", albumAttrs.RecordLabel)
+				if err != nil {
+					return nil, err
+				}
+
+				err = addExtendedMeta("UPC", albumAttrs.UPC)
+				if err != nil {
+					return nil, err
+				}
+
+				//if !strings.Contains(meta.Data[0].ID, "pl.") {
+				//	plID, err := strconv.ParseUint(meta.Data[0].ID, 10, 32)
+				//	if err != nil {
+				//		return nil, err
+				//	}
+				//
+				//	err = addMeta(mp4.BoxType{'p', 'l', 'I', 'D'}, uint32(plID))
+				//	if err != nil {
+				//		return nil, err
+				//	}
+				//}
+			}
+
+			if len(artists) > 0 {
+				if len(artists[0].ID) > 0 {
+					if atID, parseErr := strconv.ParseUint(artists[0].ID, 10, 32); parseErr != nil {
+						warn("atID", "invalid artist ID %q", artists[0].ID)
+					} else {
+						err = addMeta(mp4.BoxType{'a', 't', 'I', 'D'}, uint32(atID))
+						if err != nil {
+							return nil, err
+						}
 					}
 				}
+			}
+			discNumber, discTotal := discPosition(meta, album)
+			trkn := make([]byte, 8)
+			disk := make([]byte, 8)
+			binary.BigEndian.PutUint32(trkn, uint32(meta.Attributes.TrackNumber))
+			binary.BigEndian.PutUint16(trkn[4:], uint16(trackTotal(meta, album)))
+			binary.BigEndian.PutUint32(disk, uint32(discNumber))
+			binary.BigEndian.PutUint16(disk[4:], uint16(discTotal))
+			//binary.BigEndian.PutUint16(disk[4:], uint16(meta.Data[0].Relationships.Tracks.Data[trackTotal-1].Attributes.DiscNumber))
+			//if strings.Contains(meta.Data[0].ID, "pl.") {
+			//	if !config.UseSongInfoForPlaylist {
+			//		binary.BigEndian.PutUint32(trkn, uint32(trackNum))
+			//		binary.BigEndian.PutUint16(trkn[4:], uint16(trackTotal))
+			//		binary.BigEndian.PutUint32(disk, uint32(1))
+			//		binary.BigEndian.PutUint16(disk[4:], uint16(1))
+			//	}
+			//}
+			err = addMeta(mp4.BoxType{'t', 'r', 'k', 'n'}, trkn)
+			if err != nil {
+				return nil, err
+			}
+			err = addMeta(mp4.BoxType{'d', 'i', 's', 'k'}, disk)
+			if err != nil {
+				return nil, err
+			}
 
-				ctx.UnderIlstMeta = false
-				_, err = w.EndBox()
+			// Keep items carried over from an existing file (e.g. artwork)
+			if len(preserved) > 0 {
+				_, err = w.Write(preserved)
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
 
-			ctx.UnderUdta = false
+			ctx.UnderIlst = false
+
 			_, err = w.EndBox()
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 
+		ctx.UnderIlstMeta = false
 		_, err = w.EndBox()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	{
-		box, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMdat()})
-		if err != nil {
-			return err
-		}
-
-		_, err = mp4.Marshal(w, &mp4.Mdat{Data: data}, box.Context)
-		if err != nil {
-			return err
-		}
-
-		mdat, err := w.EndBox()
-
-		var realStco mp4.Stco
-
-		offset := mdat.Offset + mdat.HeaderSize
-		for i := uint32(0); i < numSamples; i++ {
-			if i%chunkSize == 0 {
-				realStco.EntryCount++
-				realStco.ChunkOffset = append(realStco.ChunkOffset, uint32(offset))
-			}
-			offset += uint64(len(info.samples[i].data))
-		}
+	ctx.UnderUdta = false
+	_, err = w.EndBox()
+	if err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
 
-		_, err = stco.SeekToPayload(w)
-		if err != nil {
-			return err
-		}
-		_, err = mp4.Marshal(w, &realStco, box.Context)
-		if err != nil {
-			return err
-		}
+// trackTotal returns the track total of the trkn tag: the album's when its
+// context was fetched, else the track count of the song's album relationship,
+// 0 (unknown) without either
+func trackTotal(meta *AutoSong, album *AlbumContext) int {
+	if album != nil && album.TrackTotal() > 0 {
+		return album.TrackTotal()
+	}
+	if attrs := songAlbumAttributes(meta); attrs != nil {
+		return attrs.TrackCount
 	}
+	return 0
+}
 
-	return nil
+// discPosition returns the disc number and total of the disk tag. The total
+// is the album's when its context was fetched, and 1/1 for a song without
+// album relationship, such as a pre-release single. Otherwise the total is
+// left unknown (0) rather than guessed
+func discPosition(meta *AutoSong, album *AlbumContext) (number, total int) {
+	number = max(1, meta.Attributes.DiscNumber)
+	if album != nil && album.DiscTotal() >= number {
+		return number, album.DiscTotal()
+	}
+	if number == 1 && len(meta.Relationships.Albums.Data) == 0 {
+		return 1, 1
+	}
+	return number, 0
+}
 
+// chunkOffsetBox returns the stco box holding offsets, or the co64 box when
+// large
+func chunkOffsetBox(large bool, offsets []uint64) mp4.IBox {
+	if large {
+		return &mp4.Co64{EntryCount: uint32(len(offsets)), ChunkOffset: offsets}
+	}
+	stco := &mp4.Stco{EntryCount: uint32(len(offsets)), ChunkOffset: make([]uint32, len(offsets))}
+	for i, offset := range offsets {
+		stco.ChunkOffset[i] = uint32(offset)
+	}
+	return stco
 }
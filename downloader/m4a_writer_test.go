@@ -0,0 +1,420 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abema/go-mp4"
+)
+
+func TestValidateOutputFile(t *testing.T) {
+	// The written file drops everything specific to the encrypted source
+	if err := validateOutputFile(writeBotFixture(t, retagTestMeta("Name"))); err != nil {
+		t.Errorf("Expected written file to pass validation, got %v", err)
+	}
+
+	source := filepath.Join(t.TempDir(), "source.mp4")
+	if err := os.WriteFile(source, buildFragmentedFixture(t, 1, []uint32{1}), 0o644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	err := validateOutputFile(source)
+	if err == nil || !strings.Contains(err.Error(), "stsd/enca: sample entry is enca, want alac") {
+		t.Errorf("Expected the encrypted source to fail validation, got %v", err)
+	}
+}
+
+// writeTaggedFixture writes an M4A tagged from meta and album, with cover as
+// its artwork, and returns its path with the tag warnings
+func writeTaggedFixture(t *testing.T, meta *AutoSong, album *AlbumContext, cover []byte) (string, []string) {
+	t.Helper()
+
+	info, err := parseSongInfo(buildFragmentedFixture(t, 1, []uint32{1, 1, 1}))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	var data []byte
+	for _, sample := range info.samples {
+		data = append(data, sample.data...)
+	}
+
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	warnings, err := sd.writeM4a(mp4.NewWriter(file), info, meta, album, cover, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to write M4A: %v", err)
+	}
+	return path, warnings
+}
+
+// readIlstValues returns the value of the data box of every ilst item of the
+// file at path, except the freeform ---- items
+func readIlstValues(t *testing.T, path string) map[mp4.BoxType][]byte {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	ilst, err := mp4.ExtractBox(file, nil, ilstPath)
+	if err != nil || len(ilst) != 1 {
+		t.Fatalf("Failed to find ilst: %v", err)
+	}
+	items, err := mp4.ExtractBox(file, ilst[0], mp4.BoxPath{mp4.BoxTypeAny()})
+	if err != nil {
+		t.Fatalf("Failed to read ilst items: %v", err)
+	}
+
+	values := make(map[mp4.BoxType][]byte)
+	for _, item := range items {
+		if item.Type == (mp4.BoxType{'-', '-', '-', '-'}) {
+			continue
+		}
+		// A data box: size, type, data type, locale, then the value
+		raw := make([]byte, item.Size-item.HeaderSize)
+		if _, err := item.SeekToPayload(file); err != nil {
+			t.Fatalf("Failed to seek to %s: %v", item.Type, err)
+		}
+		if _, err := io.ReadFull(file, raw); err != nil || len(raw) < 16 {
+			t.Fatalf("Failed to read %s: %v", item.Type, err)
+		}
+		values[item.Type] = raw[16:]
+	}
+	return values
+}
+
+func TestWriteM4a_Relationships(t *testing.T) {
+	var (
+		aART = mp4.BoxType{'a', 'A', 'R', 'T'}
+		cprt = mp4.BoxType{'c', 'p', 'r', 't'}
+		atID = mp4.BoxType{'a', 't', 'I', 'D'}
+		cnID = mp4.BoxType{'c', 'n', 'I', 'D'}
+		trkn = mp4.BoxType{'t', 'r', 'k', 'n'}
+		disk = mp4.BoxType{'d', 'i', 's', 'k'}
+	)
+
+	// position encodes a trkn or disk value
+	position := func(number, total int) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b, uint32(number))
+		binary.BigEndian.PutUint16(b[4:], uint16(total))
+		return b
+	}
+
+	twoDiscs := newAlbumContext(&AutoAlbum{
+		ID:         "1440857000",
+		Attributes: AlbumAttributes{TrackCount: 12},
+		Relationships: AlbumRelationships{Tracks: TrackRelationship{Data: []AutoSong{
+			{ID: "1", Attributes: SongAttributes{DiscNumber: 1}},
+			{ID: "2", Attributes: SongAttributes{DiscNumber: 2}},
+		}}},
+	}, nil)
+
+	tests := []struct {
+		name     string
+		meta     func(meta *AutoSong)
+		album    *AlbumContext
+		present  []mp4.BoxType
+		absent   []mp4.BoxType
+		trkn     []byte
+		disk     []byte
+		warnings []string
+	}{
+		{
+			name:    "album and artist",
+			meta:    func(meta *AutoSong) {},
+			present: []mp4.BoxType{aART, cprt, atID, cnID},
+			trkn:    position(1, 10),
+			disk:    position(1, 0),
+		},
+		{
+			name: "no album",
+			meta: func(meta *AutoSong) {
+				meta.Relationships.Albums.Data = nil
+			},
+			present:  []mp4.BoxType{atID, cnID},
+			absent:   []mp4.BoxType{aART, cprt},
+			trkn:     position(1, 0),
+			disk:     position(1, 1),
+			warnings: []string{"album: song has no album relationship, album tags skipped"},
+		},
+		{
+			name: "album without attributes",
+			meta: func(meta *AutoSong) {
+				meta.Relationships.Albums.Data[0].Attributes = nil
+			},
+			present:  []mp4.BoxType{aART, atID},
+			absent:   []mp4.BoxType{cprt},
+			trkn:     position(1, 0),
+			disk:     position(1, 0),
+			warnings: []string{"album: album 1440857000 has no attributes, album tags skipped"},
+		},
+		{
+			name: "no artist",
+			meta: func(meta *AutoSong) {
+				meta.Relationships.Artists.Data = nil
+			},
+			present: []mp4.BoxType{aART, cprt},
+			absent:  []mp4.BoxType{atID},
+			trkn:    position(1, 10),
+			disk:    position(1, 0),
+		},
+		{
+			name: "no album or artist",
+			meta: func(meta *AutoSong) {
+				meta.Relationships = Relationships{}
+			},
+			present:  []mp4.BoxType{cnID},
+			absent:   []mp4.BoxType{aART, cprt, atID},
+			trkn:     position(1, 0),
+			disk:     position(1, 1),
+			warnings: []string{"album: song has no album relationship, album tags skipped"},
+		},
+		{
+			name: "invalid IDs",
+			meta: func(meta *AutoSong) {
+				meta.ID = "pl.u-123"
+				meta.Relationships.Artists.Data[0].ID = "artist"
+			},
+			present: []mp4.BoxType{aART, cprt},
+			absent:  []mp4.BoxType{cnID, atID},
+			trkn:    position(1, 10),
+			disk:    position(1, 0),
+			warnings: []string{
+				`cnID: invalid song ID "pl.u-123"`,
+				`atID: invalid artist ID "artist"`,
+			},
+		},
+		{
+			name: "album context",
+			meta: func(meta *AutoSong) {
+				meta.Attributes.DiscNumber = 2
+			},
+			album:   twoDiscs,
+			present: []mp4.BoxType{aART, cprt},
+			trkn:    position(1, 12),
+			disk:    position(2, 2),
+		},
+		{
+			name: "later disc without album context",
+			meta: func(meta *AutoSong) {
+				meta.Attributes.DiscNumber = 2
+			},
+			trkn: position(1, 10),
+			disk: position(2, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := retagTestMeta("Song")
+			tt.meta(meta)
+
+			path, warnings := writeTaggedFixture(t, meta, tt.album, nil)
+			if !reflect.DeepEqual(warnings, tt.warnings) {
+				t.Errorf("Warnings = %q, want %q", warnings, tt.warnings)
+			}
+			if err := validateOutputFile(path); err != nil {
+				t.Errorf("Written M4A failed validation: %v", err)
+			}
+
+			values := readIlstValues(t, path)
+			for _, tag := range tt.present {
+				if _, ok := values[tag]; !ok {
+					t.Errorf("Expected a %s tag", tag)
+				}
+			}
+			for _, tag := range tt.absent {
+				if _, ok := values[tag]; ok {
+					t.Errorf("Expected no %s tag", tag)
+				}
+			}
+			if got := values[trkn]; !bytes.Equal(got, tt.trkn) {
+				t.Errorf("trkn = %v, want %v", got, tt.trkn)
+			}
+			if got := values[disk]; !bytes.Equal(got, tt.disk) {
+				t.Errorf("disk = %v, want %v", got, tt.disk)
+			}
+		})
+	}
+}
+
+func TestWriteM4a_Artwork(t *testing.T) {
+	tests := []struct {
+		name     string
+		cover    []byte
+		dataType uint32
+	}{
+		{"jpeg", noisyJPEG(t, 32, 32), dataTypeJPEG},
+		{"png", testImage(t, 32, 32), dataTypePNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _ := writeTaggedFixture(t, retagTestMeta("Song"), nil, tt.cover)
+			if err := validateOutputFile(path); err != nil {
+				t.Fatalf("Written M4A failed validation: %v", err)
+			}
+
+			// Retagging keeps the single cover
+			if err := RetagFile(path, retagTestMeta("Renamed")); err != nil {
+				t.Fatalf("Failed to retag: %v", err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", path, err)
+			}
+			defer file.Close()
+
+			covrPath := append(append(mp4.BoxPath{}, ilstPath...), covrType)
+			items, err := mp4.ExtractBox(file, nil, covrPath)
+			if err != nil {
+				t.Fatalf("Failed to read covr: %v", err)
+			}
+			if len(items) != 1 {
+				t.Fatalf("Expected exactly one covr item under moov/udta/meta/ilst, got %d", len(items))
+			}
+
+			// A data box: size, type, data type, locale, then the image
+			raw := make([]byte, items[0].Size-items[0].HeaderSize)
+			items[0].SeekToPayload(file)
+			if _, err := io.ReadFull(file, raw); err != nil || len(raw) < 16 {
+				t.Fatalf("Failed to read covr: %v", err)
+			}
+			if string(raw[4:8]) != "data" {
+				t.Errorf("Expected a data box in covr, got %q", raw[4:8])
+			}
+			if got := binary.BigEndian.Uint32(raw[8:12]); got != tt.dataType {
+				t.Errorf("Data type = %d, want %d", got, tt.dataType)
+			}
+			if !bytes.Equal(raw[16:], tt.cover) {
+				t.Error("Expected the covr item to hold the cover")
+			}
+		})
+	}
+}
+
+func TestWriteM4a_NoArtwork(t *testing.T) {
+	path, _ := writeTaggedFixture(t, retagTestMeta("Song"), nil, nil)
+
+	if _, ok := readIlstValues(t, path)[covrType]; ok {
+		t.Error("Expected no covr item without artwork")
+	}
+}
+
+// discardWriter is an io.WriteSeeker keeping only the bytes written below
+// keep, so files of several GiB can be written without storing the audio
+type discardWriter struct {
+	head []byte
+	keep int64
+	pos  int64
+	size int64
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	if w.pos < w.keep {
+		end := min(w.pos+int64(len(p)), w.keep)
+		if int64(len(w.head)) < end {
+			w.head = append(w.head, make([]byte, end-int64(len(w.head)))...)
+		}
+		copy(w.head[w.pos:end], p)
+	}
+	w.pos += int64(len(p))
+	w.size = max(w.size, w.pos)
+	return len(p), nil
+}
+
+func (w *discardWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		w.pos = offset
+	case io.SeekCurrent:
+		w.pos += offset
+	case io.SeekEnd:
+		w.pos = w.size + offset
+	}
+	return w.pos, nil
+}
+
+// zeroReader reads zeros forever
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteM4a_LargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Writes more than 4 GiB of audio")
+	}
+
+	info, err := parseSongInfo(buildFragmentedFixture(t, 1, []uint32{1}))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+
+	// Samples sharing one buffer add up to more than 4 GiB
+	const sampleSize = 16 << 20
+	shared := make([]byte, sampleSize)
+	info.samples = make([]SampleInfo, 265)
+	for i := range info.samples {
+		info.samples[i] = SampleInfo{data: shared, duration: 1}
+	}
+	dataSize := int64(len(info.samples)) * sampleSize
+	info.totalDataSize = dataSize
+
+	w := &discardWriter{keep: 1 << 20}
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	if _, err := sd.writeM4a(mp4.NewWriter(w), info, retagTestMeta("Song"), nil, nil, io.LimitReader(zeroReader{}, dataSize)); err != nil {
+		t.Fatalf("Failed to write M4A: %v", err)
+	}
+
+	head := bytes.NewReader(w.head)
+	if stcos, _ := mp4.ExtractBox(head, nil, stcoPath); len(stcos) != 0 {
+		t.Error("Expected no stco box past 4 GiB")
+	}
+	co64s, err := mp4.ExtractBoxWithPayload(head, nil, co64Path)
+	if err != nil || len(co64s) != 1 {
+		t.Fatalf("Expected one co64 box, got %d (%v)", len(co64s), err)
+	}
+	mdats, err := mp4.ExtractBox(head, nil, mp4.BoxPath{mp4.BoxTypeMdat()})
+	if err != nil || len(mdats) != 1 {
+		t.Fatalf("Expected one mdat box, got %d (%v)", len(mdats), err)
+	}
+	mdat := mdats[0]
+	if mdat.HeaderSize != mp4.LargeHeaderSize || mdat.Size != mp4.LargeHeaderSize+uint64(dataSize) {
+		t.Errorf("Expected a 64-bit mdat of %d bytes, got %d bytes with a %d byte header", mp4.LargeHeaderSize+uint64(dataSize), mdat.Size, mdat.HeaderSize)
+	}
+	if w.size != int64(mdat.Offset+mdat.Size) {
+		t.Errorf("Expected the file to end with the mdat at %d, got %d bytes", mdat.Offset+mdat.Size, w.size)
+	}
+
+	offsets := co64s[0].Payload.(*mp4.Co64).ChunkOffset
+	if len(offsets) != (len(info.samples)+4)/5 {
+		t.Fatalf("Expected %d chunks, got %d", (len(info.samples)+4)/5, len(offsets))
+	}
+	for i, offset := range offsets {
+		if want := mdat.Offset + mdat.HeaderSize + uint64(i)*5*sampleSize; offset != want {
+			t.Errorf("Chunk %d at %d, want %d", i+1, offset, want)
+		}
+	}
+	if last := offsets[len(offsets)-1]; last <= math.MaxUint32 {
+		t.Errorf("Expected the last chunk past 4 GiB, got offset %d", last)
+	}
+}
@@ -0,0 +1,328 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Manager hands out a downloader per job so several downloads can run at
+// once, and makes them share one memory budget, token, token health and the
+// metadata of albums being downloaded
+type Manager struct {
+	budget        *MemoryBudget
+	tokenHealth   *TokenHealth
+	albumContexts *albumContexts
+
+	// apple sends the HTTP requests of every download, sharing their tokens,
+	// latencies and schema drift
+	apple *AppleMusicClient
+
+	storefrontHealth    *StorefrontHealth
+	fallbackStorefronts []string
+
+	splitMaxBytes int64
+
+	downloadDir string
+	minFreeDisk uint64
+
+	// Highest ALAC quality selected (nil = ALAC_MAX_QUALITY)
+	qualityCap *MediaInfo
+
+	mediaRetries int
+	mediaChunks  int
+	stallTimeout time.Duration
+
+	deviceTimeout time.Duration
+
+	artworkMaxDimension int
+	artworkMaxBytes     int64
+
+	decrypter    *DecryptClient
+	decryptSlots *DecryptSlots
+
+	// Faults in FAULTS, parsed once so their counts span all jobs
+	faults *FaultPlan
+
+	expectedMetadata []MetadataField
+
+	editRate *EditRateController
+
+	downloadLimiter *BandwidthLimiter
+	uploadLimiter   *BandwidthLimiter
+
+	metrics Metrics
+}
+
+// NewManager creates a Manager whose downloads share maxMemoryBytes of
+// estimated memory. Zero or less disables the limit
+func NewManager(maxMemoryBytes int64) *Manager {
+	return &Manager{
+		budget:              NewMemoryBudget(maxMemoryBytes),
+		tokenHealth:         NewTokenHealth(),
+		albumContexts:       newAlbumContexts(),
+		apple:               NewAppleMusicClient(nil),
+		storefrontHealth:    NewStorefrontHealth(),
+		expectedMetadata:    MetadataFields,
+		editRate:            NewEditRateController(),
+		downloadDir:         DownloadsDir,
+		mediaRetries:        DefaultMediaRetries,
+		mediaChunks:         DefaultMediaChunks,
+		stallTimeout:        DefaultStallTimeout,
+		deviceTimeout:       DefaultDeviceTimeout,
+		artworkMaxDimension: DefaultArtworkMaxDimension,
+		artworkMaxBytes:     DefaultArtworkMaxBytes,
+		decrypter:           NewDecryptClient(DefaultDecryptTimeout),
+		decryptSlots:        NewDecryptSlots(DefaultDecryptLimit),
+		faults:              faultPlanFromEnv(),
+		metrics:             NoopMetrics{},
+	}
+}
+
+// NewDownloader creates a downloader for a single job
+func (m *Manager) NewDownloader() SongDownloader {
+	sd := NewSongDownloaderWithClient(m.apple).(*SongDownloaderImpl)
+	sd.memoryBudget = m.budget
+	sd.tokenHealth = m.tokenHealth
+	sd.albumContexts = m.albumContexts
+	sd.splitMaxBytes = m.splitMaxBytes
+	sd.expectedMetadata = m.expectedMetadata
+	sd.bandwidth = m.downloadLimiter
+	sd.downloadDir = m.downloadDir
+	sd.minFreeDisk = m.minFreeDisk
+	if m.qualityCap != nil {
+		sd.qualityCap = *m.qualityCap
+	}
+	sd.mediaRetries = m.mediaRetries
+	sd.mediaChunks = m.mediaChunks
+	sd.stallTimeout = m.stallTimeout
+	sd.deviceTimeout = m.deviceTimeout
+	sd.artworkMaxDimension = m.artworkMaxDimension
+	sd.artworkMaxBytes = m.artworkMaxBytes
+	sd.decrypter = m.decrypter
+	sd.decryptSlots = m.decryptSlots
+	sd.faults = m.faults
+	sd.metrics = m.metrics
+	return sd
+}
+
+// MemoryBudget returns the budget shared by the manager's downloads
+func (m *Manager) MemoryBudget() *MemoryBudget {
+	return m.budget
+}
+
+// TokenStatus returns the result of the most recent token acquisition by any
+// of the manager's downloads
+func (m *Manager) TokenStatus() TokenStatus {
+	return m.tokenHealth.Status()
+}
+
+// SetSplitLimit makes downloads larger than maxBytes split into parts of at
+// most maxBytes. Zero or less disables splitting
+func (m *Manager) SetSplitLimit(maxBytes int64) {
+	m.splitMaxBytes = maxBytes
+}
+
+// SetDownloadDir sets the directory downloads are written to
+func (m *Manager) SetDownloadDir(dir string) {
+	m.downloadDir = dir
+}
+
+// DownloadDir returns the directory downloads are written to
+func (m *Manager) DownloadDir() string {
+	return m.downloadDir
+}
+
+// CheckDependencies probes what the manager's downloads depend on, see
+// SongDownloaderImpl.CheckDependencies
+func (m *Manager) CheckDependencies(ctx context.Context) (DependencyStatus, error) {
+	return m.NewDownloader().(*SongDownloaderImpl).CheckDependencies(ctx)
+}
+
+// Validate runs the pre-flight checks of a download, see
+// SongDownloaderImpl.Validate
+func (m *Manager) Validate(ctx context.Context, url string) error {
+	return m.NewDownloader().(*SongDownloaderImpl).Validate(ctx, url)
+}
+
+// SetQualityCap makes downloads select the highest ALAC quality within limit
+// instead of the one set with ALAC_MAX_QUALITY
+func (m *Manager) SetQualityCap(limit MediaInfo) {
+	m.qualityCap = &limit
+}
+
+// SetMinFreeDisk makes downloads remove the oldest finished downloads when
+// they would leave less than bytes free in the download directory. Zero never
+// removes any
+func (m *Manager) SetMinFreeDisk(bytes uint64) {
+	m.minFreeDisk = bytes
+}
+
+// CleanStaleTemp removes the files of downloads unfinished for longer than
+// maxAge from the download directory, returning how many were removed
+func (m *Manager) CleanStaleTemp(maxAge time.Duration) (int, error) {
+	return CleanStaleTemp(m.downloadDir, maxAge)
+}
+
+// SetMediaRetries sets how many times a media download that breaks off is
+// resumed where it stopped before the download fails
+func (m *Manager) SetMediaRetries(retries int) {
+	m.mediaRetries = retries
+}
+
+// SetMediaChunks sets how many ranges of a song are downloaded at once. One
+// or less downloads every song as a single stream
+func (m *Manager) SetMediaChunks(chunks int) {
+	m.mediaChunks = chunks
+}
+
+// SetStallTimeout sets how long the download or decryption of a song may go
+// without progress before it starts over, failing when it stalls again. Zero
+// or less disables the check
+func (m *Manager) SetStallTimeout(timeout time.Duration) {
+	m.stallTimeout = timeout
+}
+
+// SetDeviceTimeout sets how long connecting to the device service, sending it
+// a song ID and waiting for its answer may each take before the download
+// fails. Zero or less waits forever
+func (m *Manager) SetDeviceTimeout(timeout time.Duration) {
+	m.deviceTimeout = timeout
+}
+
+// SetArtworkLimits caps the longer side of the artwork embedded in songs at
+// maxDimension pixels and its size at maxBytes, re-encoding larger artwork.
+// Zero or less keeps the original size
+func (m *Manager) SetArtworkLimits(maxDimension int, maxBytes int64) {
+	m.artworkMaxDimension = maxDimension
+	m.artworkMaxBytes = maxBytes
+}
+
+// SetDecryptTimeout sets how long the decryption service may take to answer
+// one sample before the download fails. Zero or less waits forever
+func (m *Manager) SetDecryptTimeout(timeout time.Duration) {
+	m.decrypter = NewDecryptClient(timeout)
+}
+
+// SetDecryptLimit sets how many of the manager's downloads may decrypt at
+// once; the others wait with their download done. Zero or less disables the
+// limit
+func (m *Manager) SetDecryptLimit(limit int) {
+	m.decryptSlots = NewDecryptSlots(limit)
+}
+
+// DecryptSlots returns the slots limiting the songs decrypted at once (nil
+// when unlimited)
+func (m *Manager) DecryptSlots() *DecryptSlots {
+	return m.decryptSlots
+}
+
+// SetBandwidthLimits caps the combined rate of all media downloads and of all
+// uploads, in bytes per second. Zero or less leaves a direction unlimited
+func (m *Manager) SetBandwidthLimits(downloadBytesPerSecond, uploadBytesPerSecond int64) {
+	m.downloadLimiter = NewBandwidthLimiter(downloadBytesPerSecond)
+	m.uploadLimiter = NewBandwidthLimiter(uploadBytesPerSecond)
+}
+
+// UploadLimiter returns the limiter shared by uploads (nil when unlimited)
+func (m *Manager) UploadLimiter() *BandwidthLimiter {
+	return m.uploadLimiter
+}
+
+// SchemaMonitor returns the monitor of schema drift in the catalog responses
+// of every download
+func (m *Manager) SchemaMonitor() *SchemaMonitor {
+	return m.apple.schema
+}
+
+// EditRate returns the controller pacing the progress edits of every download
+func (m *Manager) EditRate() *EditRateController {
+	return m.editRate
+}
+
+// SetExpectedMetadata sets the fields, by key, whose absence downloads report
+// in DownloadResult.MissingFields. No keys expects every field
+func (m *Manager) SetExpectedMetadata(keys []string) error {
+	fields, err := ParseMetadataFields(keys)
+	if err != nil {
+		return err
+	}
+	m.expectedMetadata = fields
+	return nil
+}
+
+// SetLatencyTracker makes the manager's downloads record their external calls
+// in tracker, so they are reported together with calls made elsewhere
+func (m *Manager) SetLatencyTracker(tracker *LatencyTracker) {
+	m.apple.latencies = tracker
+}
+
+// Latencies returns the tracker the manager's downloads record their calls in
+func (m *Manager) Latencies() *LatencyTracker {
+	return m.apple.latencies
+}
+
+// SetHTTPClient makes the manager's downloads send their HTTP requests with
+// client, e.g. one going through a proxy
+func (m *Manager) SetHTTPClient(client *http.Client) {
+	m.apple.SetHTTPClient(client)
+}
+
+// SetMetrics makes the manager's downloads report their outcomes and phase
+// durations to metrics
+func (m *Manager) SetMetrics(metrics Metrics) {
+	m.metrics = metrics
+}
+
+// Metrics returns where the manager's downloads report their metrics
+func (m *Manager) Metrics() Metrics {
+	return m.metrics
+}
+
+// SetFallbackStorefronts sets the storefronts tried when a download fails in
+// the requested one for storefront reasons
+func (m *Manager) SetFallbackStorefronts(storefronts []string) {
+	m.fallbackStorefronts = storefronts
+}
+
+// StorefrontOrder returns the storefronts to try for a link from requested:
+// requested first, then the fallbacks from most to least healthy
+func (m *Manager) StorefrontOrder(requested string) []string {
+	var fallbacks []string
+	for _, storefront := range m.fallbackStorefronts {
+		if storefront != requested {
+			fallbacks = append(fallbacks, storefront)
+		}
+	}
+	return append([]string{requested}, m.storefrontHealth.Order(fallbacks)...)
+}
+
+// RecordStorefrontOutcome updates the health of storefront after a download
+// from it. Errors unrelated to the storefront are not counted
+func (m *Manager) RecordStorefrontOutcome(storefront string, err error) {
+	switch {
+	case err == nil:
+		m.storefrontHealth.Record(storefront, true)
+	case IsStorefrontFailure(err):
+		m.storefrontHealth.Record(storefront, false)
+	}
+}
+
+// StorefrontScores returns the health of every storefront downloaded from
+func (m *Manager) StorefrontScores() []StorefrontScore {
+	return m.storefrontHealth.Scores()
+}
+
+// RetagDir re-fetches the metadata of every bot-written file under dir from
+// storefront and rewrites the tags that changed
+func (m *Manager) RetagDir(ctx context.Context, dir, storefront string) (*RetagSummary, error) {
+	sd := m.NewDownloader().(*SongDownloaderImpl)
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	return RetagDir(dir, func(songID string) (*AutoSong, error) {
+		return sd.GetSongMeta(ctx, &URLMeta{Storefront: storefront, URLType: "songs", ID: songID}, token)
+	})
+}
@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gotd/td/tg"
+)
+
+// UTF16Len returns the length of s as counted by Telegram, in UTF-16 code
+// units. Entity offsets and lengths and message limits all use this unit
+func UTF16Len(s string) int {
+	length := 0
+	for _, r := range s {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
+
+// TruncateUTF16 shortens s to at most limit UTF-16 code units, ending it with
+// an ellipsis when cut
+func TruncateUTF16(s string, limit int) string {
+	if UTF16Len(s) <= limit {
+		return s
+	}
+
+	runes := []rune(s)
+	length := 1 // The ellipsis
+	for i, r := range runes {
+		length += utf16.RuneLen(r)
+		if length > limit {
+			return string(runes[:i]) + "…"
+		}
+	}
+	return s
+}
+
+// markdownSpan is a formatted byte range of the plain text
+type markdownSpan struct {
+	kind       string // "bold", "code", "pre" or "link"
+	start, end int
+	value      string // Language of a code block, URL of a link
+}
+
+// markdownParser collects the plain text and formatted spans of a message
+type markdownParser struct {
+	plain strings.Builder
+	spans []markdownSpan
+}
+
+// ParseMarkdown strips the markdown from text and returns the plain text with
+// the entities it stood for: **bold** or *bold*, `code`, ```code blocks```
+// with an optional language on their first line and [links](url). Bold and
+// link text may hold other spans. Markers without a closing one are kept as
+// text, empty spans are dropped
+func ParseMarkdown(text string) (string, []tg.MessageEntityClass) {
+	var p markdownParser
+	p.parse(text)
+
+	// Telegram counts offsets and lengths in UTF-16 code units, not bytes
+	plain := p.plain.String()
+	var entities []tg.MessageEntityClass
+	for _, s := range p.spans {
+		offset, length := UTF16Len(plain[:s.start]), UTF16Len(plain[s.start:s.end])
+		switch s.kind {
+		case "bold":
+			entities = append(entities, &tg.MessageEntityBold{Offset: offset, Length: length})
+		case "code":
+			entities = append(entities, &tg.MessageEntityCode{Offset: offset, Length: length})
+		case "pre":
+			entities = append(entities, &tg.MessageEntityPre{Offset: offset, Length: length, Language: s.value})
+		case "link":
+			entities = append(entities, &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: s.value})
+		}
+	}
+	return plain, entities
+}
+
+// SendFormatted calls send with the plain text and entities of the markdown
+// message and, when Telegram rejects the entities, once more with message as
+// plain text without entities
+func SendFormatted(message string, send func(text string, entities []tg.MessageEntityClass) error) error {
+	text, entities := ParseMarkdown(message)
+	err := send(text, entities)
+	if len(entities) > 0 && IsEntityInvalid(err) {
+		return send(message, nil)
+	}
+	return err
+}
+
+// parse writes the plain text of text and records its spans
+func (p *markdownParser) parse(text string) {
+	for i := 0; i < len(text); {
+		if n := p.parseSpan(text[i:]); n > 0 {
+			i += n
+			continue
+		}
+
+		// Copy whole runes so multi-byte characters stay intact
+		_, size := utf8.DecodeRuneInString(text[i:])
+		p.plain.WriteString(text[i : i+size])
+		i += size
+	}
+}
+
+// parseSpan parses the span text starts with and returns how many bytes it
+// took, 0 when text does not start with a span. Unclosed multi-character
+// markers are written as text whole, so they do not open shorter ones
+func (p *markdownParser) parseSpan(text string) int {
+	switch {
+	case strings.HasPrefix(text, "```"):
+		end := strings.Index(text[3:], "```")
+		if end == -1 {
+			p.plain.WriteString("```")
+			return 3
+		}
+		block, language := text[3:3+end], ""
+		if newline := strings.IndexByte(block, '\n'); newline != -1 && !strings.ContainsAny(block[:newline], " \t") {
+			language, block = block[:newline], block[newline+1:]
+		}
+		p.literal("pre", strings.TrimSuffix(block, "\n"), language)
+		return end + 6
+
+	case text[0] == '`':
+		end := strings.IndexByte(text[1:], '`')
+		if end == -1 {
+			return 0
+		}
+		p.literal("code", text[1:1+end], "")
+		return end + 2
+
+	case strings.HasPrefix(text, "**"):
+		end := strings.Index(text[2:], "**")
+		if end == -1 {
+			p.plain.WriteString("**")
+			return 2
+		}
+		p.nested("bold", text[2:2+end], "")
+		return end + 4
+
+	case text[0] == '*':
+		end := strings.IndexByte(text[1:], '*')
+		if end == -1 {
+			return 0
+		}
+		p.nested("bold", text[1:1+end], "")
+		return end + 2
+
+	case text[0] == '[':
+		label, rest, ok := strings.Cut(text[1:], "](")
+		if !ok || strings.ContainsAny(label, "[]\n") {
+			return 0
+		}
+		url, _, ok := strings.Cut(rest, ")")
+		if !ok || url == "" || strings.ContainsAny(url, " \n") {
+			return 0
+		}
+		p.nested("link", label, url)
+		return len(label) + len(url) + 4
+	}
+	return 0
+}
+
+// literal writes text as is, formatted as kind
+func (p *markdownParser) literal(kind, text, value string) {
+	if text == "" {
+		return
+	}
+	start := p.plain.Len()
+	p.plain.WriteString(text)
+	p.spans = append(p.spans, markdownSpan{kind, start, p.plain.Len(), value})
+}
+
+// nested writes the plain text of text, formatted as kind. The span is
+// recorded before the ones inside it, keeping them ordered by offset
+func (p *markdownParser) nested(kind, text, value string) {
+	index, start := len(p.spans), p.plain.Len()
+	p.spans = append(p.spans, markdownSpan{kind: kind, start: start, value: value})
+	p.parse(text)
+	if p.plain.Len() == start {
+		p.spans = p.spans[:index]
+		return
+	}
+	p.spans[index].end = p.plain.Len()
+}
@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// formattedSpans returns what each entity covers of text, counted in UTF-16
+// code units as Telegram does, prefixed with its kind
+func formattedSpans(t *testing.T, text string, entities []tg.MessageEntityClass) []string {
+	t.Helper()
+
+	units := utf16.Encode([]rune(text))
+	var spans []string
+	for _, entity := range entities {
+		offset, length := entity.GetOffset(), entity.GetLength()
+		if offset < 0 || length <= 0 || offset+length > len(units) {
+			t.Fatalf("Entity %T [%d, %d) is outside the %d UTF-16 units of %q", entity, offset, offset+length, len(units), text)
+		}
+
+		kind := "?"
+		switch entity.(type) {
+		case *tg.MessageEntityBold:
+			kind = "bold"
+		case *tg.MessageEntityCode:
+			kind = "code"
+		case *tg.MessageEntityPre:
+			kind = "pre"
+		case *tg.MessageEntityTextURL:
+			kind = "link"
+		}
+		spans = append(spans, kind+":"+string(utf16.Decode(units[offset:offset+length])))
+	}
+	return spans
+}
+
+func TestParseMarkdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantPlain string
+		wantSpans []string
+	}{
+		{
+			name:      "double asterisk bold after emoji",
+			text:      "🎵 **Shape of You 🔥**\n\n✅ **Complete!**",
+			wantPlain: "🎵 Shape of You 🔥\n\n✅ Complete!",
+			wantSpans: []string{"bold:Shape of You 🔥", "bold:Complete!"},
+		},
+		{
+			name:      "code inside bold",
+			text:      "🏷️ **Retag of `Música/夜に駆ける` finished** in 3s",
+			wantPlain: "🏷️ Retag of Música/夜に駆ける finished in 3s",
+			wantSpans: []string{"bold:Retag of Música/夜に駆ける finished", "code:Música/夜に駆ける"},
+		},
+		{
+			name:      "code block with a language",
+			text:      "⚙️ **Config**\n\n```json\n{\"title\": \"Café 🎶\"}\n```",
+			wantPlain: "⚙️ Config\n\n{\"title\": \"Café 🎶\"}",
+			wantSpans: []string{"bold:Config", "pre:{\"title\": \"Café 🎶\"}"},
+		},
+		{
+			name:      "code block without a language",
+			text:      "```\n*not bold*\n```",
+			wantPlain: "*not bold*",
+			wantSpans: []string{"pre:*not bold*"},
+		},
+		{
+			name:      "link",
+			text:      "🎧 Listen on [Apple Music 🍎](https://music.apple.com/us/song/x/1)",
+			wantPlain: "🎧 Listen on Apple Music 🍎",
+			wantSpans: []string{"link:Apple Music 🍎"},
+		},
+		{
+			name:      "brackets that are no link",
+			text:      "Song [Live] (2019) [x](not a url)",
+			wantPlain: "Song [Live] (2019) [x](not a url)",
+		},
+		{
+			name:      "unclosed markers",
+			text:      "**Song ``` 5 * 3",
+			wantPlain: "**Song ``` 5 * 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, entities := ParseMarkdown(tt.text)
+			if plain != tt.wantPlain {
+				t.Errorf("plain = %q, want %q", plain, tt.wantPlain)
+			}
+			if got := formattedSpans(t, plain, entities); strings.Join(got, "|") != strings.Join(tt.wantSpans, "|") {
+				t.Errorf("spans = %q, want %q", got, tt.wantSpans)
+			}
+		})
+	}
+}
+
+func TestParseMarkdown_EntityValues(t *testing.T) {
+	_, entities := ParseMarkdown("```go\nfmt.Println()\n``` [site](https://example.com/a?b=c)")
+	if len(entities) != 2 {
+		t.Fatalf("Expected 2 entities, got %d", len(entities))
+	}
+	if pre, ok := entities[0].(*tg.MessageEntityPre); !ok || pre.Language != "go" {
+		t.Errorf("Expected a go code block, got %v", entities[0])
+	}
+	if link, ok := entities[1].(*tg.MessageEntityTextURL); !ok || link.URL != "https://example.com/a?b=c" {
+		t.Errorf("Expected the link URL, got %v", entities[1])
+	}
+}
+
+func TestSendFormatted_FallsBackToPlainText(t *testing.T) {
+	var sent []string
+	err := SendFormatted("🎵 **Song**", func(text string, entities []tg.MessageEntityClass) error {
+		sent = append(sent, text)
+		if entities != nil {
+			return tgerr.New(400, "ENTITY_BOUNDS_INVALID")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SendFormatted() error = %v", err)
+	}
+	if len(sent) != 2 || sent[0] != "🎵 Song" || sent[1] != "🎵 **Song**" {
+		t.Errorf("Expected the formatted text, then the plain markdown, got %q", sent)
+	}
+
+	sent = nil
+	err = SendFormatted("🎵 **Song**", func(text string, entities []tg.MessageEntityClass) error {
+		sent = append(sent, text)
+		return tgerr.New(400, "PEER_ID_INVALID")
+	})
+	if err == nil || len(sent) != 1 {
+		t.Errorf("Expected other errors to be returned without retrying, got %d sends and %v", len(sent), err)
+	}
+}
+
+func TestTelegramProgressReporter_FormatsMessages(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	songName := "Despacito 💃🔥 - Luis Fonsi"
+
+	if err := reporter.StartTracking(context.Background(), 12345, songName); err != nil {
+		t.Fatalf("StartTracking() error = %v", err)
+	}
+	if err := reporter.UpdateProgress(PhaseDownloading, Progress{TotalBytes: 100, BytesProcessed: 50, Percentage: 50}); err != nil {
+		t.Fatalf("UpdateProgress() error = %v", err)
+	}
+	if err := reporter.ReportError(NewDownloadError(ErrorNetworkFailure, "Connection failed")); err != nil {
+		t.Fatalf("ReportError() error = %v", err)
+	}
+
+	send := api.GetSendMessageCalls()[0].Request
+	if spans := formattedSpans(t, send.Message, send.Entities); strings.Join(spans, "|") != "bold:"+songName {
+		t.Errorf("Expected the song title in bold, got %q", spans)
+	}
+
+	edits := api.GetEditMessageCalls()
+	if len(edits) != 2 {
+		t.Fatalf("Expected a progress and an error edit, got %d edits", len(edits))
+	}
+	progress, failure := edits[0].Request, edits[1].Request
+	if spans := formattedSpans(t, progress.Message, progress.Entities); strings.Join(spans, "|") != "bold:"+songName+"|bold:Downloading song..." {
+		t.Errorf("Expected the title and phase in bold, got %q", spans)
+	}
+	if spans := formattedSpans(t, failure.Message, failure.Entities); strings.Join(spans, "|") != "bold:"+songName+"|bold:Error" {
+		t.Errorf("Expected the title and error in bold, got %q", spans)
+	}
+	for _, message := range []string{send.Message, progress.Message, failure.Message} {
+		if strings.Contains(message, "**") {
+			t.Errorf("Expected no literal asterisks, got %q", message)
+		}
+	}
+}
+
+func TestTruncateUTF16(t *testing.T) {
+	tests := []struct {
+		input string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 5, "trun…"},
+		{"🎵🎵🎵", 5, "🎵🎵…"}, // Each emoji is two UTF-16 units
+	}
+
+	for _, tt := range tests {
+		if got := TruncateUTF16(tt.input, tt.limit); got != tt.want {
+			t.Errorf("TruncateUTF16(%q, %d) = %q, want %q", tt.input, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	// DefaultMediaChunks is how many ranges of a song are downloaded at once
+	DefaultMediaChunks = 4
+
+	// minMediaChunkSize is the smallest range worth a connection of its own
+	minMediaChunkSize = 512 << 10
+)
+
+// errRangeUnsupported is returned when the media server answers a range
+// request with the whole file
+var errRangeUnsupported = errors.New("media server does not support range requests")
+
+// mediaChunkCount returns how many ranges the media file of track is
+// downloaded in at once, 1 when it is read as one stream
+func (sd *SongDownloaderImpl) mediaChunkCount(track *http.Response) int {
+	if sd.mediaChunks < 2 || track.ContentLength <= 0 || track.Header.Get("Accept-Ranges") != "bytes" {
+		return 1
+	}
+	return int(max(1, min(int64(sd.mediaChunks), track.ContentLength/minMediaChunkSize)))
+}
+
+// downloadChunks downloads the total bytes of the media file at url in
+// chunks ranges at once, into one buffer. The first range is read from first,
+// a response for the whole file. Progress is reported for the bytes of all
+// ranges together. The first failing range stops the others; a server that
+// ignores the ranges fails with errRangeUnsupported
+func (sd *SongDownloaderImpl) downloadChunks(ctx context.Context, url string, first io.ReadCloser, total int64, chunks int, callbacks ProgressCallbacks) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	buf := make([]byte, total)
+
+	var mu sync.Mutex
+	var read int64
+	meter := newRateMeter()
+	onRead := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		read += int64(n)
+		sd.reportProgress(PhaseDownloading, meter.Progress(read, total), callbacks)
+	}
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	size := total / int64(chunks)
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		start := int64(i) * size
+		end := start + size
+		if i == chunks-1 {
+			end = total
+		}
+
+		body := &resumableBody{
+			ctx:     ctx,
+			sd:      sd,
+			url:     url,
+			total:   end - start,
+			start:   start,
+			ranged:  true,
+			retries: sd.mediaRetries,
+			delay:   sd.mediaRetryDelay,
+		}
+		if i == 0 {
+			body.body = sd.faults.wrapMedia(first, body.total, 0)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sd.downloadRange(ctx, body, buf[start:end], onRead); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return buf, nil
+}
+
+// downloadRange reads body into dst, opening it first unless it continues a
+// response already
+func (sd *SongDownloaderImpl) downloadRange(ctx context.Context, body *resumableBody, dst []byte, onRead func(n int)) error {
+	if body.body == nil {
+		opened, err := body.open()
+		if err != nil {
+			return err
+		}
+		body.body = opened
+	}
+	defer body.Close()
+
+	_, err := io.ReadFull(&countingReader{reader: sd.bandwidth.Reader(ctx, body), onRead: onRead}, dst)
+	return err
+}
+
+// countingReader calls onRead with the size of every read
+type countingReader struct {
+	reader io.Reader
+	onRead func(n int)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.onRead(n)
+	}
+	return n, err
+}
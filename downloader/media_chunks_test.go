@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// chunkTestBody returns a body large enough to be split into 4 ranges
+func chunkTestBody() []byte {
+	body := make([]byte, 4*minMediaChunkSize+123)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	return body
+}
+
+func TestDownloadChunks(t *testing.T) {
+	body := chunkTestBody()
+	server, ranges := newRangeServer(t, body)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	track, err := sd.getMedia(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("getMedia() error = %v", err)
+	}
+	defer track.Body.Close()
+
+	chunks := sd.mediaChunkCount(track)
+	if chunks != DefaultMediaChunks {
+		t.Fatalf("Expected %d chunks, got %d", DefaultMediaChunks, chunks)
+	}
+
+	var last int64
+	got, err := sd.downloadChunks(context.Background(), server.URL, track.Body, track.ContentLength, chunks, ProgressCallbacks{
+		OnProgress: func(phase Phase, progress Progress) {
+			if progress.BytesProcessed < last {
+				t.Errorf("Progress went backwards from %d to %d", last, progress.BytesProcessed)
+			}
+			last = progress.BytesProcessed
+		},
+	})
+	if err != nil {
+		t.Fatalf("downloadChunks() error = %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Error("Expected the stitched ranges to equal the file")
+	}
+	if last != int64(len(body)) {
+		t.Errorf("Expected progress across all ranges to end at %d, got %d", len(body), last)
+	}
+
+	// The first range continues the plain request
+	size := int64(len(body)) / 4
+	want := []string{"",
+		"bytes=" + strconv.FormatInt(size, 10) + "-" + strconv.FormatInt(2*size-1, 10),
+		"bytes=" + strconv.FormatInt(2*size, 10) + "-" + strconv.FormatInt(3*size-1, 10),
+		"bytes=" + strconv.FormatInt(3*size, 10) + "-" + strconv.FormatInt(int64(len(body))-1, 10),
+	}
+	requested := ranges()
+	sort.Strings(requested)
+	sort.Strings(want)
+	if len(requested) != len(want) {
+		t.Fatalf("Range headers = %q, want %q", requested, want)
+	}
+	for i := range want {
+		if requested[i] != want[i] {
+			t.Errorf("Range headers = %q, want %q", requested, want)
+			break
+		}
+	}
+}
+
+func TestMediaChunkCount(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	track := func(length int64, acceptRanges string) *http.Response {
+		header := http.Header{}
+		if acceptRanges != "" {
+			header.Set("Accept-Ranges", acceptRanges)
+		}
+		return &http.Response{ContentLength: length, Header: header}
+	}
+
+	tests := []struct {
+		name     string
+		response *http.Response
+		want     int
+	}{
+		{"ranges supported", track(8*minMediaChunkSize, "bytes"), DefaultMediaChunks},
+		{"ranges not supported", track(8*minMediaChunkSize, ""), 1},
+		{"ranges refused", track(8*minMediaChunkSize, "none"), 1},
+		{"unknown length", track(-1, "bytes"), 1},
+		{"small file", track(minMediaChunkSize, "bytes"), 1},
+		{"medium file", track(2*minMediaChunkSize+1, "bytes"), 2},
+	}
+	for _, test := range tests {
+		if got := sd.mediaChunkCount(test.response); got != test.want {
+			t.Errorf("%s: mediaChunkCount() = %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	sd.mediaChunks = 1
+	if got := sd.mediaChunkCount(track(8*minMediaChunkSize, "bytes")); got != 1 {
+		t.Errorf("Expected one stream when chunking is off, got %d", got)
+	}
+}
+
+func TestDownloadChunks_RangeIgnored(t *testing.T) {
+	body := chunkTestBody()
+	// Claims range support but always sends the whole file
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	track, err := sd.getMedia(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("getMedia() error = %v", err)
+	}
+	defer track.Body.Close()
+
+	_, err = sd.downloadChunks(context.Background(), server.URL, track.Body, track.ContentLength, sd.mediaChunkCount(track), ProgressCallbacks{})
+	if !errors.Is(err, errRangeUnsupported) {
+		t.Errorf("Expected errRangeUnsupported, got %v", err)
+	}
+}
+
+func TestExtractSong_SingleStreamWithoutRanges(t *testing.T) {
+	body := chunkTestBody()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Range") != "" {
+			t.Errorf("Unexpected range request %q", r.Header.Get("Range"))
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	var last int64
+	// The body is no MP4, so parsing fails once it has been read
+	sd.extractSong(context.Background(), server.URL, ProgressCallbacks{
+		OnProgress: func(phase Phase, progress Progress) {
+			last = progress.BytesProcessed
+		},
+	})
+	if requests != 1 || last != int64(len(body)) {
+		t.Errorf("Expected the whole file in one request, got %d requests and %d bytes", requests, last)
+	}
+}
+
+func TestDownloadChunks_CancelStopsAllRanges(t *testing.T) {
+	body := chunkTestBody()
+	// Ranged requests stall until the client goes away
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			http.ServeContent(w, r, "song.mp4", time.Time{}, bytes.NewReader(body))
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 0-0/1")
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.mediaRetries = 0
+	track, err := sd.getMedia(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("getMedia() error = %v", err)
+	}
+	defer track.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sd.downloadChunks(ctx, server.URL, track.Body, track.ContentLength, sd.mediaChunkCount(track), ProgressCallbacks{})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error after cancellation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected all ranges to stop once the context was cancelled")
+	}
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"go-alac-bot/logging"
+
+	"github.com/grafov/m3u8"
+)
+
+// resolveMediaURL returns the URL of the fMP4 file behind a variant: the
+// EXT-X-MAP (or first segment) URI of its media playlist. When the media
+// playlist cannot be read it falls back to the "_m.mp4" file next to it
+func (sd *SongDownloaderImpl) resolveMediaURL(ctx context.Context, streamURL *url.URL) string {
+	// Variants pointing at the file itself need no media playlist
+	if ext := strings.ToLower(path.Ext(streamURL.Path)); ext == ".mp4" || ext == ".m4a" {
+		return streamURL.String()
+	}
+
+	mediaURL, err := sd.mediaPlaylistURL(ctx, streamURL)
+	if err == nil {
+		return mediaURL.String()
+	}
+	logger.Warn("Could not read media playlist, guessing the media URL", logging.Err(err))
+
+	fallback := *streamURL
+	fallback.Path = strings.TrimSuffix(fallback.Path, ".m3u8") + "_m.mp4"
+	fallback.RawPath = ""
+	return fallback.String()
+}
+
+// mediaPlaylistURL fetches the media playlist at streamURL and resolves the
+// media file it lists against it
+func (sd *SongDownloaderImpl) mediaPlaylistURL(ctx context.Context, streamURL *url.URL) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sd.httpClient(DepMasterPlaylist, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse media playlist: %w", err)
+	}
+	if listType != m3u8.MEDIA {
+		return nil, errors.New("m3u8 not of media type")
+	}
+
+	uri := mediaFileURI(playlist.(*m3u8.MediaPlaylist))
+	if uri == "" {
+		return nil, errors.New("media playlist lists no media file")
+	}
+	mediaURL, err := streamURL.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URI %q: %w", uri, err)
+	}
+	inheritQuery(mediaURL, streamURL)
+	return mediaURL, nil
+}
+
+// inheritQuery gives u the query string of the playlist it was resolved
+// against when it has none of its own and is served by the same host
+func inheritQuery(u, base *url.URL) {
+	if u.RawQuery == "" && u.Host == base.Host {
+		u.RawQuery = base.RawQuery
+	}
+}
+
+// mediaFileURI returns the file holding the init section and samples of a
+// media playlist: the EXT-X-MAP URI, or the first segment's without one
+func mediaFileURI(playlist *m3u8.MediaPlaylist) string {
+	if playlist.Map != nil && playlist.Map.URI != "" {
+		return playlist.Map.URI
+	}
+	for _, segment := range playlist.Segments {
+		if segment != nil && segment.URI != "" {
+			return segment.URI
+		}
+	}
+	return ""
+}
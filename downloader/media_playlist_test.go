@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// testMasterPlaylist is shaped like an Apple Music enhanced HLS master
+// playlist: ALAC and AAC variants with relative URIs and session keys
+const testMasterPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://itunes.apple.com/P000000000/s1/e1",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://itunes.apple.com/AA1440818839/c23",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-stereo-256",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-alac-stereo-44100-24",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2"
+#EXT-X-STREAM-INF:BANDWIDTH=286000,AVERAGE-BANDWIDTH=256000,CODECS="mp4a.40.2",AUDIO="audio-stereo-256"
+P1440818839_A1440818839_audio_en_gr256_mp4a-40-2.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2204000,AVERAGE-BANDWIDTH=1706000,CODECS="alac",AUDIO="audio-alac-stereo-44100-24"
+%s
+`
+
+// testMediaPlaylist is shaped like the media playlist of one variant: a
+// single fMP4 file addressed by byte ranges after its init section
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-TARGETDURATION:19
+#EXT-X-VERSION:7
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MAP:URI="%[1]s",BYTERANGE="1162@0"
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://itunes.apple.com/AA1440818839/c23",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+#EXTINF:18.00000,
+#EXT-X-BYTERANGE:1873021@1162
+%[1]s
+#EXTINF:18.00000,
+#EXT-X-BYTERANGE:1901356@1874183
+%[1]s
+#EXT-X-ENDLIST
+`
+
+// playlistServer serves the master playlist with the ALAC variant at
+// variantURI and that variant's media playlist listing mediaURI. The media
+// playlist is missing when mediaURI is empty
+func playlistServer(t *testing.T, variantURI, mediaURI string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/itunes-assets/master.m3u8":
+			fmt.Fprintf(w, testMasterPlaylist, variantURI)
+		case "/itunes-assets/alac.m3u8":
+			if mediaURI == "" || r.URL.Query().Get("token") != "abc" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, testMediaPlaylist, mediaURI)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestExtractMedia_ResolvesMediaPlaylist(t *testing.T) {
+	testCases := []struct {
+		name       string
+		variantURI string
+		mediaURI   string
+		want       string // Relative to the server
+	}{
+		{
+			name:       "map URI",
+			variantURI: "alac.m3u8",
+			mediaURI:   "P1440818839_alac_m.mp4",
+			want:       "/itunes-assets/P1440818839_alac_m.mp4?token=abc",
+		},
+		{
+			name:       "map URI with its own query",
+			variantURI: "alac.m3u8",
+			mediaURI:   "/media/P1440818839.mp4?sig=xyz",
+			want:       "/media/P1440818839.mp4?sig=xyz",
+		},
+		{
+			name:       "variant pointing at the file",
+			variantURI: "P1440818839_alac.mp4",
+			want:       "/itunes-assets/P1440818839_alac.mp4?token=abc",
+		},
+		{
+			name:       "no media playlist falls back to the suffix",
+			variantURI: "missing.m3u8",
+			want:       "/itunes-assets/missing_m.mp4?token=abc",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := playlistServer(t, tc.variantURI, tc.mediaURI)
+			sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+
+			mediaURL, keys, media, err := sd.ExtractMedia(context.Background(), server.URL+"/itunes-assets/master.m3u8?token=abc")
+			if err != nil {
+				t.Fatalf("ExtractMedia() error = %v", err)
+			}
+			if mediaURL != server.URL+tc.want {
+				t.Errorf("Expected %s, got %s", server.URL+tc.want, mediaURL)
+			}
+			if media.SampleRate != 44100 || media.BitDepth != 24 {
+				t.Errorf("Expected the ALAC variant, got %s", media)
+			}
+			if len(keys) != 2 || keys[0] != prefetchKey || keys[1] != "skd://itunes.apple.com/AA1440818839/c23" {
+				t.Errorf("Expected the prefetch and song keys, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestInheritQuery(t *testing.T) {
+	base, _ := url.Parse("https://aod.itunes.apple.com/a/master.m3u8?token=abc")
+
+	sameHost, _ := base.Parse("b/file.mp4")
+	inheritQuery(sameHost, base)
+	if sameHost.RawQuery != "token=abc" {
+		t.Errorf("Expected the token on the same host, got %q", sameHost.RawQuery)
+	}
+
+	otherHost, _ := base.Parse("https://cdn.example.com/file.mp4")
+	inheritQuery(otherHost, base)
+	if otherHost.RawQuery != "" {
+		t.Errorf("Expected no token sent to another host, got %q", otherHost.RawQuery)
+	}
+}
@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// DefaultMediaRetries is how many times a broken media download is
+	// resumed before the download fails
+	DefaultMediaRetries = 3
+
+	// defaultMediaRetryDelay is the wait before the first resumption
+	defaultMediaRetryDelay = 500 * time.Millisecond
+)
+
+// resumableBody reads a media file over as many requests as it takes. When
+// the transfer breaks off, the rest is requested with a Range header after a
+// backoff, up to retries times
+type resumableBody struct {
+	ctx    context.Context
+	sd     *SongDownloaderImpl
+	url    string
+	total  int64 // -1 when unknown
+	start  int64 // Offset of the body in the file
+	ranged bool  // Only total bytes from start are read, as one range of the file
+
+	body    io.ReadCloser
+	read    int64
+	retries int
+	delay   time.Duration
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.read += int64(n)
+
+		complete := err == io.EOF && (b.total < 0 || b.read >= b.total)
+		if err == nil || complete {
+			return n, err
+		}
+		if n > 0 {
+			// Hand out the data first, the error comes back on the next read
+			return n, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err := b.resume(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// resume replaces the broken body with one continuing at the bytes read so
+// far, returning the last error once the retries are used up
+func (b *resumableBody) resume(cause error) error {
+	for b.retries > 0 {
+		if b.ctx.Err() != nil {
+			return cause
+		}
+		b.retries--
+		logger.Warn("Media download broke off, resuming", logging.Int64("Read", b.read), logging.Err(cause), logging.Duration("Delay", b.delay))
+
+		timer := time.NewTimer(b.delay)
+		select {
+		case <-b.ctx.Done():
+			timer.Stop()
+			return cause
+		case <-timer.C:
+		}
+		b.delay *= 2
+
+		body, err := b.open()
+		if err == nil {
+			b.body.Close()
+			b.body = body
+			return nil
+		}
+		if errors.Is(err, errRangeUnsupported) {
+			return err
+		}
+		cause = err
+	}
+	return fmt.Errorf("media download failed after %d bytes: %w", b.read, cause)
+}
+
+// open requests the file from the bytes read so far, up to the end of the
+// range for ranged bodies
+func (b *resumableBody) open() (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(b.ctx, "GET", b.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", b.start+b.read, b.start+b.total-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.read))
+	}
+
+	resp, err := b.sd.httpClient(DepMediaCDN, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if b.ranged {
+			resp.Body.Close()
+			return nil, errRangeUnsupported
+		}
+		// The range was ignored, skip what was already read
+		if _, err := io.CopyN(io.Discard, resp.Body, b.read); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	default:
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+
+	return b.sd.faults.wrapMedia(resp.Body, b.total, b.read), nil
+}
+
+// Close closes the current body
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
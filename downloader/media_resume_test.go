@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newRangeServer serves body with Range support and records the Range header
+// of every request
+func newRangeServer(t *testing.T, body []byte) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "song.mp4", time.Time{}, bytes.NewReader(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func TestResumableBody_ResumesWithRange(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 100)
+	server, ranges := newRangeServer(t, body)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.mediaRetryDelay = time.Millisecond
+	sd.faults, _ = ParseFaultPlan("media:reset@30%*2")
+
+	var got bytes.Buffer
+	var last int64
+	progressReader := &ProgressReader{
+		onProgress: func(read, total int64) {
+			if read < last {
+				t.Errorf("Progress went backwards from %d to %d", last, read)
+			}
+			last = read
+		},
+	}
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	rb := &resumableBody{
+		ctx:     context.Background(),
+		sd:      sd,
+		url:     server.URL,
+		total:   resp.ContentLength,
+		body:    sd.faults.wrapMedia(resp.Body, resp.ContentLength, 0),
+		retries: 3,
+		delay:   sd.mediaRetryDelay,
+	}
+	defer rb.Close()
+	progressReader.reader = rb
+	progressReader.total = resp.ContentLength
+
+	if _, err := got.ReadFrom(progressReader); err != nil {
+		t.Fatalf("Expected the download to resume, got %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), body) {
+		t.Errorf("Expected the whole body once, got %d bytes", got.Len())
+	}
+	if last != int64(len(body)) {
+		t.Errorf("Expected progress to end at %d, got %d", len(body), last)
+	}
+
+	// The second fault fires right at the resumed offset, the third request
+	// gets the rest
+	want := []string{"", "bytes=300-", "bytes=300-"}
+	if got := ranges(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Range headers = %q, want %q", got, want)
+	}
+}
+
+func TestExtractSong_FailsAfterRetries(t *testing.T) {
+	server, ranges := newRangeServer(t, bytes.Repeat([]byte{0xAB}, 1000))
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.mediaRetryDelay = time.Millisecond
+	sd.mediaRetries = 2
+	sd.faults, _ = ParseFaultPlan("media:reset@50%")
+
+	_, err := sd.extractSong(context.Background(), server.URL, ProgressCallbacks{})
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Expected the last connection reset, got %v", err)
+	}
+	if got := len(ranges()); got != 3 {
+		t.Errorf("Expected the first request and 2 retries, got %d requests", got)
+	}
+}
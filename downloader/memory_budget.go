@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// memoryOverheadBytes is the fixed per-download overhead (HTTP buffers,
+	// sample tables, artwork, MP4 writer state) added to every estimate
+	memoryOverheadBytes = 16 << 20
+
+	// unknownContentLength is assumed when the server does not send a
+	// Content-Length, large enough for a long hi-res track
+	unknownContentLength = 256 << 20
+)
+
+// EstimateFootprint returns a conservative estimate of the peak memory a
+// download needs. The whole encrypted track is read into memory and parsing
+// copies the media data once more, while decrypted samples are streamed to
+// disk, so the estimate is twice the track size plus a fixed overhead
+func EstimateFootprint(contentLength int64) int64 {
+	if contentLength <= 0 {
+		contentLength = unknownContentLength
+	}
+	return 2*contentLength + memoryOverheadBytes
+}
+
+// MemoryBudget admits downloads in arrival order while their combined
+// estimated footprint stays under a global limit
+type MemoryBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	waiters []*memoryWaiter
+}
+
+// memoryWaiter is a reservation waiting for memory to be released
+type memoryWaiter struct {
+	bytes int64
+	ready chan struct{}
+}
+
+// MemoryReservation is memory held by a running download
+type MemoryReservation struct {
+	budget *MemoryBudget
+	bytes  int64
+	once   sync.Once
+}
+
+// NewMemoryBudget creates a budget of limitBytes. A limit of zero or less
+// admits everything
+func NewMemoryBudget(limitBytes int64) *MemoryBudget {
+	return &MemoryBudget{limit: limitBytes}
+}
+
+// Limit returns the budget limit in bytes (zero or less when unlimited)
+func (b *MemoryBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the bytes currently reserved
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Waiting returns the number of reservations waiting for memory
+func (b *MemoryBudget) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiters)
+}
+
+// Reserve blocks until bytes can be reserved without exceeding the limit, or
+// ctx is done. Reservations are admitted in the order they were requested.
+// onWait is called once if the reservation has to wait. A request larger than
+// the whole budget is admitted alone once nothing else is running
+func (b *MemoryBudget) Reserve(ctx context.Context, bytes int64, onWait func()) (*MemoryReservation, error) {
+	reservation := &MemoryReservation{budget: b, bytes: bytes}
+
+	b.mu.Lock()
+	if len(b.waiters) == 0 && b.fitsLocked(bytes) {
+		b.used += bytes
+		b.mu.Unlock()
+		return reservation, nil
+	}
+
+	waiter := &memoryWaiter{bytes: bytes, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, waiter)
+	b.mu.Unlock()
+
+	if onWait != nil {
+		onWait()
+	}
+
+	select {
+	case <-waiter.ready:
+		return reservation, nil
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		// Admitted while giving up; hand the memory back
+		b.used -= bytes
+	default:
+		for i, w := range b.waiters {
+			if w == waiter {
+				b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	b.admitLocked()
+
+	return nil, ctx.Err()
+}
+
+// Release returns the reserved memory to the budget. It is safe to call more
+// than once, so it can be deferred next to other cleanup
+func (r *MemoryReservation) Release() {
+	if r == nil {
+		return
+	}
+
+	r.once.Do(func() {
+		r.budget.mu.Lock()
+		defer r.budget.mu.Unlock()
+
+		r.budget.used -= r.bytes
+		r.budget.admitLocked()
+	})
+}
+
+// fitsLocked reports whether bytes can be reserved now (must be called with lock held)
+func (b *MemoryBudget) fitsLocked(bytes int64) bool {
+	return b.limit <= 0 || b.used == 0 || b.used+bytes <= b.limit
+}
+
+// admitLocked admits waiting reservations from the head of the line while
+// they fit (must be called with lock held)
+func (b *MemoryBudget) admitLocked() {
+	for len(b.waiters) > 0 && b.fitsLocked(b.waiters[0].bytes) {
+		waiter := b.waiters[0]
+		b.waiters = b.waiters[1:]
+		b.used += waiter.bytes
+		close(waiter.ready)
+	}
+}
@@ -0,0 +1,227 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitUntil polls cond until it holds or the timeout expires
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEstimateFootprint(t *testing.T) {
+	if got := EstimateFootprint(100 << 20); got != 200<<20+memoryOverheadBytes {
+		t.Errorf("Expected 2x track size plus overhead, got %d", got)
+	}
+
+	if got := EstimateFootprint(-1); got != 2*unknownContentLength+memoryOverheadBytes {
+		t.Errorf("Expected unknown length to use the fallback size, got %d", got)
+	}
+}
+
+func TestMemoryBudget_AdmissionOrderAndPanicRelease(t *testing.T) {
+	budget := NewMemoryBudget(100)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var admitted []string
+	var waitedJobs []string
+
+	// runJob reserves memory, records the admission, then runs body with the
+	// reservation released through defer even if body panics
+	runJob := func(name string, bytes int64, body func()) {
+		defer func() { recover() }()
+
+		reservation, err := budget.Reserve(ctx, bytes, func() {
+			mu.Lock()
+			waitedJobs = append(waitedJobs, name)
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Errorf("Job %s: unexpected error: %v", name, err)
+			return
+		}
+		defer reservation.Release()
+
+		mu.Lock()
+		admitted = append(admitted, name)
+		mu.Unlock()
+
+		body()
+	}
+
+	// admittedCount returns how many jobs were admitted so far
+	admittedCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(admitted)
+	}
+
+	releaseA := make(chan struct{})
+	releaseB := make(chan struct{})
+	releaseC := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runJob("A", 60, func() {
+			<-releaseA
+			panic("simulated failure")
+		})
+	}()
+	waitUntil(t, "job A to be admitted", func() bool { return budget.Used() == 60 })
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runJob("B", 70, func() { <-releaseB })
+	}()
+	waitUntil(t, "job B to wait", func() bool { return budget.Waiting() == 1 })
+
+	// C would fit next to A, but must not overtake B
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runJob("C", 40, func() { <-releaseC })
+	}()
+	waitUntil(t, "job C to wait", func() bool { return budget.Waiting() == 2 })
+
+	mu.Lock()
+	if len(admitted) != 1 || admitted[0] != "A" {
+		t.Errorf("Expected only A to be admitted, got %v", admitted)
+	}
+	if len(waitedJobs) != 2 || waitedJobs[0] != "B" || waitedJobs[1] != "C" {
+		t.Errorf("Expected B and C to report waiting, got %v", waitedJobs)
+	}
+	mu.Unlock()
+
+	// A panics; its reservation is still released and B is admitted. C does
+	// not fit next to B, so it is only admitted once B is done
+	close(releaseA)
+	waitUntil(t, "B to be admitted", func() bool { return admittedCount() == 2 })
+
+	mu.Lock()
+	if admitted[1] != "B" {
+		t.Errorf("Expected B to be admitted after A, got %v", admitted)
+	}
+	mu.Unlock()
+	if waiting := budget.Waiting(); waiting != 1 {
+		t.Errorf("Expected C to still wait, got %d waiting", waiting)
+	}
+
+	close(releaseB)
+	waitUntil(t, "C to be admitted", func() bool { return admittedCount() == 3 })
+
+	mu.Lock()
+	if admitted[2] != "C" {
+		t.Errorf("Expected C to be admitted last, got %v", admitted)
+	}
+	mu.Unlock()
+
+	close(releaseC)
+	wg.Wait()
+
+	if used := budget.Used(); used != 0 {
+		t.Errorf("Expected all memory to be released, got %d bytes still reserved", used)
+	}
+}
+
+func TestMemoryBudget_OversizedReservation(t *testing.T) {
+	budget := NewMemoryBudget(100)
+
+	// A reservation larger than the whole budget still runs when nothing else does
+	reservation, err := budget.Reserve(context.Background(), 500, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reservation.Release()
+	reservation.Release()
+
+	if used := budget.Used(); used != 0 {
+		t.Errorf("Expected double release to be a no-op, got %d bytes reserved", used)
+	}
+}
+
+func TestMemoryBudget_CancelWhileWaiting(t *testing.T) {
+	budget := NewMemoryBudget(100)
+
+	holder, err := budget.Reserve(context.Background(), 80, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := budget.Reserve(ctx, 50, nil); err == nil {
+		t.Fatal("Expected reservation to fail when the context is cancelled")
+	}
+	if waiting := budget.Waiting(); waiting != 0 {
+		t.Errorf("Expected cancelled reservation to leave the line, got %d waiting", waiting)
+	}
+
+	holder.Release()
+	if used := budget.Used(); used != 0 {
+		t.Errorf("Expected all memory to be released, got %d", used)
+	}
+}
+
+func TestSongDownloader_ReserveMemoryReportsWaiting(t *testing.T) {
+	manager := NewManager(EstimateFootprint(10 << 20))
+	sd := manager.NewDownloader().(*SongDownloaderImpl)
+
+	// Another download holds the whole budget
+	holder, err := manager.MemoryBudget().Reserve(context.Background(), manager.MemoryBudget().Limit(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+	var phases []Phase
+	callbacks := ProgressCallbacks{
+		OnPhaseChange: func(oldPhase, newPhase Phase) {
+			mu.Lock()
+			phases = append(phases, newPhase)
+			mu.Unlock()
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sd.reserveMemory(context.Background(), 10<<20, callbacks)
+	}()
+
+	waitUntil(t, "download to wait for memory", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(phases) == 1
+	})
+
+	holder.Release()
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	if len(phases) != 2 || phases[0] != PhaseWaitingForMemory || phases[1] != PhaseDownloading {
+		t.Errorf("Expected waiting then downloading phases, got %v", phases)
+	}
+	mu.Unlock()
+
+	sd.releaseMemory()
+	if used := manager.MemoryBudget().Used(); used != 0 {
+		t.Errorf("Expected reservation to be released, got %d bytes", used)
+	}
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetadataField is a tag archivists expect in every file, checked against the
+// catalog data the file was tagged from
+type MetadataField struct {
+	Key     string // Name used in EXPECTED_METADATA
+	Label   string // Name shown to users
+	Present func(meta *AutoSong) bool
+}
+
+// MetadataFields are the fields checked for completeness, in display order.
+// Add an entry here to check another field
+var MetadataFields = []MetadataField{
+	{
+		Key:   "composer",
+		Label: "composer",
+		Present: func(meta *AutoSong) bool {
+			return strings.TrimSpace(meta.Attributes.ComposerName) != ""
+		},
+	},
+	{
+		Key:   "isrc",
+		Label: "ISRC",
+		Present: func(meta *AutoSong) bool {
+			return strings.TrimSpace(meta.Attributes.ISRC) != ""
+		},
+	},
+	{
+		Key:   "upc",
+		Label: "UPC",
+		Present: func(meta *AutoSong) bool {
+			album := songAlbumAttributes(meta)
+			return album != nil && strings.TrimSpace(album.UPC) != ""
+		},
+	},
+	{
+		Key:   "label",
+		Label: "record label",
+		Present: func(meta *AutoSong) bool {
+			album := songAlbumAttributes(meta)
+			return album != nil && strings.TrimSpace(album.RecordLabel) != ""
+		},
+	},
+	{
+		Key:   "lyrics",
+		Label: "lyrics",
+		Present: func(meta *AutoSong) bool {
+			return meta.Attributes.HasLyrics
+		},
+	},
+}
+
+// songAlbumAttributes returns the attributes of the album a song was fetched
+// with, nil when the catalog data has none
+func songAlbumAttributes(meta *AutoSong) *AlbumAttributes {
+	albums := meta.Relationships.Albums.Data
+	if len(albums) == 0 {
+		return nil
+	}
+	return albums[0].Attributes
+}
+
+// ParseMetadataFields returns the fields named by keys, in display order. No
+// keys selects every field
+func ParseMetadataFields(keys []string) ([]MetadataField, error) {
+	if len(keys) == 0 {
+		return MetadataFields, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if !isMetadataFieldKey(key) {
+			return nil, fmt.Errorf("unknown metadata field %q", key)
+		}
+		wanted[key] = true
+	}
+
+	var fields []MetadataField
+	for _, field := range MetadataFields {
+		if wanted[field.Key] {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// isMetadataFieldKey reports whether key names one of MetadataFields
+func isMetadataFieldKey(key string) bool {
+	for _, field := range MetadataFields {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingMetadataFields returns the labels of the fields absent from meta
+func MissingMetadataFields(meta *AutoSong, fields []MetadataField) []string {
+	var missing []string
+	for _, field := range fields {
+		if !field.Present(meta) {
+			missing = append(missing, field.Label)
+		}
+	}
+	return missing
+}
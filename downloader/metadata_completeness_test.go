@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+// completeTestMeta returns catalog data with every expected field present
+func completeTestMeta() *AutoSong {
+	meta := retagTestMeta("Name")
+	meta.Attributes.ComposerName = "Composer"
+	meta.Attributes.ISRC = "USRC17607839"
+	meta.Attributes.HasLyrics = true
+	album := meta.Relationships.Albums.Data[0].Attributes
+	album.UPC = "00602577241413"
+	album.RecordLabel = "Label"
+	return meta
+}
+
+func TestMissingMetadataFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(meta *AutoSong)
+		want   []string
+	}{
+		{
+			name:   "complete",
+			modify: func(meta *AutoSong) {},
+			want:   nil,
+		},
+		{
+			name: "composer and lyrics",
+			modify: func(meta *AutoSong) {
+				meta.Attributes.ComposerName = ""
+				meta.Attributes.HasLyrics = false
+			},
+			want: []string{"composer", "lyrics"},
+		},
+		{
+			name: "blank ISRC",
+			modify: func(meta *AutoSong) {
+				meta.Attributes.ISRC = "  "
+			},
+			want: []string{"ISRC"},
+		},
+		{
+			name: "album without UPC or label",
+			modify: func(meta *AutoSong) {
+				album := meta.Relationships.Albums.Data[0].Attributes
+				album.UPC = ""
+				album.RecordLabel = ""
+			},
+			want: []string{"UPC", "record label"},
+		},
+		{
+			name: "no album data",
+			modify: func(meta *AutoSong) {
+				meta.Relationships.Albums.Data = nil
+			},
+			want: []string{"UPC", "record label"},
+		},
+		{
+			name: "album attributes not included",
+			modify: func(meta *AutoSong) {
+				meta.Relationships.Albums.Data[0].Attributes = nil
+			},
+			want: []string{"UPC", "record label"},
+		},
+		{
+			name: "everything",
+			modify: func(meta *AutoSong) {
+				*meta = AutoSong{}
+			},
+			want: []string{"composer", "ISRC", "UPC", "record label", "lyrics"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := completeTestMeta()
+			tt.modify(meta)
+			if got := MissingMetadataFields(meta, MetadataFields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingMetadataFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadataFields(t *testing.T) {
+	fields, err := ParseMetadataFields([]string{"Lyrics", " composer", ""})
+	if err != nil {
+		t.Fatalf("ParseMetadataFields() error = %v", err)
+	}
+
+	// Display order is kept regardless of the order given
+	if got := MissingMetadataFields(&AutoSong{}, fields); !reflect.DeepEqual(got, []string{"composer", "lyrics"}) {
+		t.Errorf("Expected only composer and lyrics to be checked, got %q", got)
+	}
+
+	if fields, err := ParseMetadataFields(nil); err != nil || len(fields) != len(MetadataFields) {
+		t.Errorf("Expected every field by default, got %d fields and %v", len(fields), err)
+	}
+
+	if _, err := ParseMetadataFields([]string{"composer", "mood"}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestManager_SetExpectedMetadata(t *testing.T) {
+	manager := NewManager(0)
+	if err := manager.SetExpectedMetadata([]string{"isrc"}); err != nil {
+		t.Fatalf("SetExpectedMetadata() error = %v", err)
+	}
+
+	sd := manager.NewDownloader().(*SongDownloaderImpl)
+	if got := MissingMetadataFields(&AutoSong{}, sd.expectedMetadata); !reflect.DeepEqual(got, []string{"ISRC"}) {
+		t.Errorf("Expected downloads to check only the ISRC, got %q", got)
+	}
+
+	if err := manager.SetExpectedMetadata([]string{"bpm"}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if sd := manager.NewDownloader().(*SongDownloaderImpl); len(sd.expectedMetadata) != 1 {
+		t.Errorf("Expected a failed update to keep the previous fields, got %d", len(sd.expectedMetadata))
+	}
+}
@@ -0,0 +1,209 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Download outcomes counted by Metrics.DownloadFinished
+const (
+	DownloadSucceeded = "success"
+	DownloadFailed    = "failed"
+	DownloadCancelled = "cancelled"
+)
+
+// phaseDurationBuckets are the upper bounds in seconds of the phase duration
+// histogram buckets
+var phaseDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Metrics receives the counters and timings of downloads, the song queue and
+// progress messages. The bot serves them from METRICS_ADDR; without it the
+// NoopMetrics drop them. A MetricsRegistry serves, in the Prometheus text
+// format:
+//
+//   - alac_downloads_total{status,error_type}: finished downloads by status
+//     (success, failed or cancelled) and DownloadError type, empty on success
+//   - alac_download_phase_duration_seconds{phase}: histogram of the time
+//     downloads spent in each phase
+//   - alac_queue_depth: requests waiting in the song queue
+//   - alac_upload_bytes_total: bytes of songs uploaded to Telegram
+//   - alac_telegram_edit_failures_total: progress message edits that failed,
+//     FLOOD_WAITs included
+type Metrics interface {
+	// DownloadFinished counts a download that ended with err (nil = success)
+	DownloadFinished(err error)
+	// PhaseFinished records the time a download spent in phase
+	PhaseFinished(phase Phase, d time.Duration)
+	// SetQueueDepth records the number of requests waiting in the queue
+	SetQueueDepth(depth int)
+	// AddUploadBytes counts n bytes uploaded to Telegram
+	AddUploadBytes(n int64)
+	// EditFailed counts a failed progress message edit
+	EditFailed()
+}
+
+// NoopMetrics is a Metrics recording nothing, used when metrics are off
+type NoopMetrics struct{}
+
+func (NoopMetrics) DownloadFinished(error)             {}
+func (NoopMetrics) PhaseFinished(Phase, time.Duration) {}
+func (NoopMetrics) SetQueueDepth(int)                  {}
+func (NoopMetrics) AddUploadBytes(int64)               {}
+func (NoopMetrics) EditFailed()                        {}
+
+// downloadOutcome labels alac_downloads_total
+type downloadOutcome struct {
+	status    string
+	errorType string
+}
+
+// histogram counts observations per bucket of phaseDurationBuckets, the last
+// count being the +Inf bucket
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// MetricsRegistry is a Metrics keeping the values in memory and serving them
+// in the Prometheus text format
+type MetricsRegistry struct {
+	mu           sync.Mutex
+	downloads    map[downloadOutcome]int64
+	phases       map[Phase]*histogram
+	queueDepth   int64
+	uploadBytes  int64
+	editFailures int64
+}
+
+// NewMetricsRegistry creates a MetricsRegistry with every value at zero
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		downloads: make(map[downloadOutcome]int64),
+		phases:    make(map[Phase]*histogram),
+	}
+}
+
+// DownloadFinished implements Metrics
+func (r *MetricsRegistry) DownloadFinished(err error) {
+	outcome := downloadOutcome{status: DownloadSucceeded}
+	if err != nil {
+		outcome = downloadOutcome{status: DownloadFailed, errorType: ErrorUnknown.String()}
+		if downloadErr, ok := AsDownloadError(err); ok {
+			outcome.errorType = downloadErr.Type.String()
+		}
+		if errors.Is(err, context.Canceled) || IsDownloadError(err, ErrorCancelled) {
+			outcome = downloadOutcome{status: DownloadCancelled, errorType: ErrorCancelled.String()}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downloads[outcome]++
+}
+
+// PhaseFinished implements Metrics
+func (r *MetricsRegistry) PhaseFinished(phase Phase, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h := r.phases[phase]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(phaseDurationBuckets)+1)}
+		r.phases[phase] = h
+	}
+	seconds := d.Seconds()
+	bucket := sort.SearchFloat64s(phaseDurationBuckets, seconds)
+	h.counts[bucket]++
+	h.sum += seconds
+	h.count++
+}
+
+// SetQueueDepth implements Metrics
+func (r *MetricsRegistry) SetQueueDepth(depth int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queueDepth = int64(depth)
+}
+
+// AddUploadBytes implements Metrics
+func (r *MetricsRegistry) AddUploadBytes(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploadBytes += n
+}
+
+// EditFailed implements Metrics
+func (r *MetricsRegistry) EditFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.editFailures++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, r.text())
+}
+
+// text formats the metrics, in the same order every time
+func (r *MetricsRegistry) text() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out strings.Builder
+	fmt.Fprintln(&out, "# HELP alac_downloads_total Finished downloads by status and error type")
+	fmt.Fprintln(&out, "# TYPE alac_downloads_total counter")
+	outcomes := make([]downloadOutcome, 0, len(r.downloads))
+	for outcome := range r.downloads {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].status != outcomes[j].status {
+			return outcomes[i].status < outcomes[j].status
+		}
+		return outcomes[i].errorType < outcomes[j].errorType
+	})
+	for _, outcome := range outcomes {
+		fmt.Fprintf(&out, "alac_downloads_total{status=%q,error_type=%q} %d\n",
+			outcome.status, outcome.errorType, r.downloads[outcome])
+	}
+
+	fmt.Fprintln(&out, "# HELP alac_download_phase_duration_seconds Time downloads spent in each phase")
+	fmt.Fprintln(&out, "# TYPE alac_download_phase_duration_seconds histogram")
+	phases := make([]Phase, 0, len(r.phases))
+	for phase := range r.phases {
+		phases = append(phases, phase)
+	}
+	sort.Slice(phases, func(i, j int) bool { return phases[i] < phases[j] })
+	for _, phase := range phases {
+		h := r.phases[phase]
+		cumulative := int64(0)
+		for i, bound := range phaseDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&out, "alac_download_phase_duration_seconds_bucket{phase=%q,le=\"%g\"} %d\n", phase, bound, cumulative)
+		}
+		fmt.Fprintf(&out, "alac_download_phase_duration_seconds_bucket{phase=%q,le=\"+Inf\"} %d\n", phase, h.count)
+		fmt.Fprintf(&out, "alac_download_phase_duration_seconds_sum{phase=%q} %g\n", phase, h.sum)
+		fmt.Fprintf(&out, "alac_download_phase_duration_seconds_count{phase=%q} %d\n", phase, h.count)
+	}
+
+	fmt.Fprintln(&out, "# HELP alac_queue_depth Requests waiting in the song queue")
+	fmt.Fprintln(&out, "# TYPE alac_queue_depth gauge")
+	fmt.Fprintf(&out, "alac_queue_depth %d\n", r.queueDepth)
+
+	fmt.Fprintln(&out, "# HELP alac_upload_bytes_total Bytes of songs uploaded to Telegram")
+	fmt.Fprintln(&out, "# TYPE alac_upload_bytes_total counter")
+	fmt.Fprintf(&out, "alac_upload_bytes_total %d\n", r.uploadBytes)
+
+	fmt.Fprintln(&out, "# HELP alac_telegram_edit_failures_total Progress message edits that failed")
+	fmt.Fprintln(&out, "# TYPE alac_telegram_edit_failures_total counter")
+	fmt.Fprintf(&out, "alac_telegram_edit_failures_total %d\n", r.editFailures)
+	return out.String()
+}
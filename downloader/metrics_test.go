@@ -0,0 +1,192 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// recordingMetrics records the phases and edit failures reported to it
+type recordingMetrics struct {
+	NoopMetrics
+	mu           sync.Mutex
+	phases       []Phase
+	editFailures int
+}
+
+func (m *recordingMetrics) PhaseFinished(phase Phase, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phases = append(m.phases, phase)
+}
+
+func (m *recordingMetrics) EditFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.editFailures++
+}
+
+func TestMetricsRegistry_DownloadOutcomes(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.DownloadFinished(nil)
+	registry.DownloadFinished(nil)
+	registry.DownloadFinished(NewDownloadError(ErrorNetworkFailure, "connection reset"))
+	registry.DownloadFinished(fmt.Errorf("wrapped: %w", context.Canceled))
+	registry.DownloadFinished(NewDownloadError(ErrorCancelled, "stopped"))
+	registry.DownloadFinished(fmt.Errorf("plain failure"))
+
+	text := registry.text()
+	for _, want := range []string{
+		`alac_downloads_total{status="success",error_type=""} 2`,
+		fmt.Sprintf(`alac_downloads_total{status="failed",error_type=%q} 1`, ErrorNetworkFailure.String()),
+		fmt.Sprintf(`alac_downloads_total{status="failed",error_type=%q} 1`, ErrorUnknown.String()),
+		fmt.Sprintf(`alac_downloads_total{status="cancelled",error_type=%q} 2`, ErrorCancelled.String()),
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestMetricsRegistry_PhaseHistogram(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.PhaseFinished(PhaseDownloading, 300*time.Millisecond)
+	registry.PhaseFinished(PhaseDownloading, 3*time.Second)
+	registry.PhaseFinished(PhaseDownloading, 10*time.Minute)
+
+	text := registry.text()
+	for _, want := range []string{
+		`alac_download_phase_duration_seconds_bucket{phase="downloading",le="0.1"} 0`,
+		`alac_download_phase_duration_seconds_bucket{phase="downloading",le="0.5"} 1`,
+		`alac_download_phase_duration_seconds_bucket{phase="downloading",le="5"} 2`,
+		`alac_download_phase_duration_seconds_bucket{phase="downloading",le="300"} 2`,
+		`alac_download_phase_duration_seconds_bucket{phase="downloading",le="+Inf"} 3`,
+		`alac_download_phase_duration_seconds_sum{phase="downloading"} 603.3`,
+		`alac_download_phase_duration_seconds_count{phase="downloading"} 3`,
+	} {
+		if !strings.Contains(text, want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestMetricsRegistry_ServeHTTP(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.SetQueueDepth(4)
+	registry.SetQueueDepth(3)
+	registry.AddUploadBytes(1024)
+	registry.AddUploadBytes(2048)
+	registry.EditFailed()
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the text format, got %q", rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE alac_queue_depth gauge",
+		"alac_queue_depth 3",
+		"alac_upload_bytes_total 3072",
+		"alac_telegram_edit_failures_total 1",
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestSongDownloaderImpl_RecordsPhases(t *testing.T) {
+	metrics := &recordingMetrics{}
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.metrics = metrics
+
+	// Phases are only timed within Download
+	sd.updatePhase(PhaseDownloading, ProgressCallbacks{})
+	if len(metrics.phases) != 0 {
+		t.Fatalf("Expected no phases outside a download, got %v", metrics.phases)
+	}
+
+	sd.phaseStart = time.Now()
+	sd.updatePhase(PhaseDecrypting, ProgressCallbacks{})
+	sd.updatePhase(PhaseDecrypting, ProgressCallbacks{})
+	sd.reportError(NewDownloadError(ErrorDecryptionFailure, "bad key"), ProgressCallbacks{})
+	sd.updatePhase(PhaseComplete, ProgressCallbacks{})
+
+	want := []Phase{PhaseDownloading, PhaseDecrypting}
+	if fmt.Sprint(metrics.phases) != fmt.Sprint(want) {
+		t.Errorf("Expected phases %v, got %v", want, metrics.phases)
+	}
+}
+
+func TestSongDownloaderImpl_PhaseDurations(t *testing.T) {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.metrics = &recordingMetrics{}
+
+	// Each phase is timed from the previous change, and a phase entered twice
+	// adds up
+	sd.phaseStart = time.Now().Add(-3 * time.Second)
+	sd.updatePhase(PhaseDownloading, ProgressCallbacks{})
+	sd.phaseStart = time.Now().Add(-12 * time.Second)
+	sd.updatePhase(PhaseDecrypting, ProgressCallbacks{})
+	sd.phaseStart = time.Now().Add(-5 * time.Second)
+	sd.updatePhase(PhaseDownloading, ProgressCallbacks{})
+	sd.phaseStart = time.Now().Add(-4 * time.Second)
+	sd.updatePhase(PhaseDecrypting, ProgressCallbacks{})
+	sd.phaseStart = time.Now().Add(-2 * time.Second)
+	sd.updatePhase(PhaseComplete, ProgressCallbacks{})
+	sd.phaseStart = time.Now().Add(-time.Minute)
+	sd.updatePhase(PhaseValidating, ProgressCallbacks{})
+
+	durations := sd.recordedPhases()
+	want := map[Phase]time.Duration{
+		PhaseValidating:  3 * time.Second,
+		PhaseDownloading: 16 * time.Second,
+		PhaseDecrypting:  7 * time.Second,
+	}
+	if len(durations) != len(want) {
+		t.Fatalf("Expected durations of %d phases, got %v", len(want), durations)
+	}
+	for phase, d := range want {
+		if got := durations[phase]; got < d || got > d+time.Second {
+			t.Errorf("Expected about %s in %s, got %s", d, phase, got)
+		}
+	}
+
+	// The copy is the caller's
+	durations[PhaseUploading] = time.Second
+	if _, ok := sd.recordedPhases()[PhaseUploading]; ok {
+		t.Error("Expected recordedPhases to return a copy")
+	}
+}
+
+func TestTelegramProgressReporter_CountsEditFailures(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	metrics := &recordingMetrics{}
+	reporter.SetMetrics(metrics)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+
+	// Unchanged messages are not failures
+	api.SetShouldFailEdit(true, &tgerr.Error{Code: 400, Message: "MESSAGE_NOT_MODIFIED", Type: "MESSAGE_NOT_MODIFIED"})
+	reporter.UpdateProgress(PhaseDownloading, Progress{Percentage: 10})
+	if metrics.editFailures != 0 {
+		t.Errorf("Expected MESSAGE_NOT_MODIFIED not to count, got %d failures", metrics.editFailures)
+	}
+
+	api.SetShouldFailEdit(true, nil)
+	reporter.UpdateProgress(PhaseDownloading, Progress{Percentage: 20})
+	if metrics.editFailures != 1 {
+		t.Errorf("Expected 1 edit failure, got %d", metrics.editFailures)
+	}
+}
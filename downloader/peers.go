@@ -0,0 +1,31 @@
+package downloader
+
+import "github.com/gotd/td/tg"
+
+// channelChatIDOffset is subtracted from channel IDs to get their chat IDs,
+// e.g. channel 1234567890 is chat -1001234567890
+const channelChatIDOffset = 1000000000000
+
+// PeerResolver builds the input peer messages to a chat ID are sent to
+type PeerResolver interface {
+	InputPeer(chatID int64) tg.InputPeerClass
+}
+
+// ChannelChatID returns the chat ID of a supergroup or channel
+func ChannelChatID(channelID int64) int64 {
+	return -channelChatIDOffset - channelID
+}
+
+// ChatInputPeer returns the input peer of a chat ID: a user for positive IDs,
+// a supergroup or channel for -100<channel ID> and a basic group for other
+// negative IDs. accessHash is 0 when it is unknown
+func ChatInputPeer(chatID, accessHash int64) tg.InputPeerClass {
+	switch {
+	case chatID > 0:
+		return &tg.InputPeerUser{UserID: chatID, AccessHash: accessHash}
+	case chatID <= -channelChatIDOffset:
+		return &tg.InputPeerChannel{ChannelID: -chatID - channelChatIDOffset, AccessHash: accessHash}
+	default:
+		return &tg.InputPeerChat{ChatID: -chatID}
+	}
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestChatInputPeer(t *testing.T) {
+	testCases := []struct {
+		name   string
+		chatID int64
+		want   tg.InputPeerClass
+	}{
+		{"user", 12345, &tg.InputPeerUser{UserID: 12345, AccessHash: 7}},
+		{"basic group", -98765, &tg.InputPeerChat{ChatID: 98765}},
+		{"supergroup", -1001234567890, &tg.InputPeerChannel{ChannelID: 1234567890, AccessHash: 7}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ChatInputPeer(tc.chatID, 7)
+			if got.String() != tc.want.String() {
+				t.Errorf("ChatInputPeer(%d) = %v, want %v", tc.chatID, got, tc.want)
+			}
+		})
+	}
+
+	if got := ChannelChatID(1234567890); got != -1001234567890 {
+		t.Errorf("ChannelChatID() = %d, want -1001234567890", got)
+	}
+}
+
+// fixedPeers resolves every chat ID to the same peer
+type fixedPeers struct {
+	peer tg.InputPeerClass
+}
+
+func (f fixedPeers) InputPeer(chatID int64) tg.InputPeerClass {
+	return f.peer
+}
+
+func TestTelegramProgressReporter_SupergroupPeer(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	channel := &tg.InputPeerChannel{ChannelID: 1234567890, AccessHash: 42}
+	reporter.SetPeerResolver(fixedPeers{peer: channel})
+
+	if err := reporter.StartTracking(context.Background(), -1001234567890, "Song - Artist"); err != nil {
+		t.Fatalf("StartTracking() error = %v", err)
+	}
+	if err := reporter.ReportCancelled(); err != nil {
+		t.Fatalf("ReportCancelled() error = %v", err)
+	}
+
+	sends := api.GetSendMessageCalls()
+	edits := api.GetEditMessageCalls()
+	if len(sends) != 1 || sends[0].Request.Peer != channel {
+		t.Errorf("Expected the progress message sent to the channel peer, got %v", sends)
+	}
+	if len(edits) == 0 || edits[len(edits)-1].Request.Peer != channel {
+		t.Errorf("Expected the edits sent to the channel peer, got %v", edits)
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// PlaylistDownloadResult contains the result of a playlist download: the
+// tracks that were downloaded, in playlist order, and those that were not
+type PlaylistDownloadResult struct {
+	PlaylistID string            `json:"playlist_id"`
+	Name       string            `json:"name"`
+	Curator    string            `json:"curator"`
+	Tracks     []*DownloadResult `json:"tracks"`
+	Failed     []FailedTrack     `json:"failed,omitempty"`
+	Duration   time.Duration     `json:"duration"`
+}
+
+// DownloadPlaylist downloads the songs of a playlist one after the other,
+// like DownloadAlbum. OnComplete fires as each file is ready, so callers can
+// upload while the rest of the playlist downloads
+func (sd *SongDownloaderImpl) DownloadPlaylist(ctx context.Context, url string, callbacks ProgressCallbacks) (*PlaylistDownloadResult, error) {
+	startTime := time.Now()
+
+	urlMeta, err := sd.ExtractUrlMeta(url)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorInvalidURL, "failed to extract URL metadata", err)
+	}
+	if urlMeta.URLType != "playlists" {
+		return nil, NewDownloadError(ErrorInvalidURL, fmt.Sprintf("expected a playlist URL, got %s", urlMeta.URLType))
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+
+	playlist, err := sd.GetPlaylistMeta(ctx, urlMeta, token)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get playlist metadata", err)
+	}
+
+	result := &PlaylistDownloadResult{
+		PlaylistID: playlist.ID,
+		Name:       playlist.Attributes.Name,
+		Curator:    playlist.Attributes.CuratorName,
+	}
+	result.Tracks, result.Failed, err = sd.downloadTracks(ctx, urlMeta.Storefront, playlist.Relationships.Tracks.Data, callbacks)
+	result.Duration = time.Since(startTime)
+	return result, err
+}
+
+// GetPlaylistMeta retrieves playlist metadata and all of its tracks from
+// Apple Music API, following the next links of the track pages
+func (sd *SongDownloaderImpl) GetPlaylistMeta(ctx context.Context, urlMeta *URLMeta, token string) (*AutoPlaylist, error) {
+	if err := sd.faults.check(FaultCatalog); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("include", "tracks")
+	query.Set("l", sd.options.MetadataLanguage)
+
+	var playlistResponse PlaylistResponse
+	path := fmt.Sprintf("/v1/catalog/%s/playlists/%s", urlMeta.Storefront, urlMeta.ID)
+	if err := sd.apple.GetCatalog(ctx, path, query, token, &playlistResponse); err != nil {
+		return nil, err
+	}
+
+	var playlist *AutoPlaylist
+	for i := range playlistResponse.Data {
+		if playlistResponse.Data[i].ID == urlMeta.ID {
+			playlist = &playlistResponse.Data[i]
+			break
+		}
+	}
+	if playlist == nil {
+		return nil, errors.New("playlist not found in response")
+	}
+
+	// The response holds the first 100 tracks, the rest come in pages
+	tracks := &playlist.Relationships.Tracks
+	seen := map[string]bool{}
+	for next := tracks.Next; next != "" && !seen[next]; {
+		seen[next] = true
+
+		nextURL, err := url.Parse(next)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next page %q: %w", next, err)
+		}
+		pageQuery := nextURL.Query()
+		pageQuery.Set("l", sd.options.MetadataLanguage)
+
+		var page TrackRelationship
+		if err := sd.apple.GetCatalog(ctx, nextURL.Path, pageQuery, token, &page); err != nil {
+			return nil, err
+		}
+		tracks.Data = append(tracks.Data, page.Data...)
+		next = page.Next
+	}
+	tracks.Next = ""
+
+	return playlist, nil
+}
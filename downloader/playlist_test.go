@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newPlaylistServer serves playlist pl.test with 250 songs without ALAC, in
+// pages of 100
+func newPlaylistServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	page := func(offset int) string {
+		var songs []string
+		for i := offset; i < min(offset+100, 250); i++ {
+			songs = append(songs, fmt.Sprintf(`{"id":"%d","type":"songs","attributes":{"name":"Song %d"}}`, 1000+i, i))
+		}
+		next := ""
+		if offset+100 < 250 {
+			next = fmt.Sprintf("/v1/catalog/us/playlists/pl.test/tracks?offset=%d", offset+100)
+		}
+		return fmt.Sprintf(`{"next":%q,"data":[%s]}`, next, strings.Join(songs, ","))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case r.URL.Path == "/v1/catalog/us/playlists/pl.test":
+			fmt.Fprintf(w, `{"data":[{"id":"pl.test","type":"playlists","attributes":{"name":"Mix","curatorName":"Curator"},"relationships":{"tracks":%s}}]}`, page(0))
+		case r.URL.Path == "/v1/catalog/us/playlists/pl.test/tracks":
+			var offset int
+			fmt.Sscan(r.URL.Query().Get("offset"), &offset)
+			fmt.Fprint(w, page(offset))
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/us/songs/"):
+			id := strings.TrimPrefix(r.URL.Path, "/v1/catalog/us/songs/")
+			fmt.Fprintf(w, `{"data":[{"id":"%s","type":"songs","attributes":{"name":"Song %s","extendedAssetUrls":{}}}]}`, id, id)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetPlaylistMeta_FollowsPages(t *testing.T) {
+	server := newPlaylistServer(t)
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.apple.catalogURL = server.URL
+
+	playlist, err := sd.GetPlaylistMeta(context.Background(), &URLMeta{Storefront: "us", URLType: "playlists", ID: "pl.test"}, testToken)
+	if err != nil {
+		t.Fatalf("GetPlaylistMeta() error = %v", err)
+	}
+
+	tracks := playlist.Relationships.Tracks
+	if len(tracks.Data) != 250 || tracks.Next != "" {
+		t.Fatalf("Expected all 250 tracks without a next page, got %d and %q", len(tracks.Data), tracks.Next)
+	}
+	if first, last := tracks.Data[0].ID, tracks.Data[249].ID; first != "1000" || last != "1249" {
+		t.Errorf("Expected tracks 1000 to 1249 in order, got %s to %s", first, last)
+	}
+	if playlist.Attributes.CuratorName != "Curator" {
+		t.Errorf("CuratorName = %q, want Curator", playlist.Attributes.CuratorName)
+	}
+}
+
+func TestDownloadPlaylist(t *testing.T) {
+	server := newPlaylistServer(t)
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+
+	var last TrackListProgress
+	callbacks := ProgressCallbacks{OnTrackListProgress: func(p TrackListProgress) { last = p }}
+
+	result, err := sd.DownloadPlaylist(context.Background(), "https://music.apple.com/us/playlist/mix/pl.test", callbacks)
+	if err != nil {
+		t.Fatalf("DownloadPlaylist() error = %v", err)
+	}
+	if result.Name != "Mix" || len(result.Failed) != 250 || result.Failed[0].Reason != "ALAC not available" {
+		t.Errorf("Expected every track skipped for lack of ALAC, got %d failed", len(result.Failed))
+	}
+	if last.Track != 0 || last.TrackCount != 250 || last.Failed != 250 {
+		t.Errorf("Unexpected final progress: %+v", last)
+	}
+
+	if _, err := sd.DownloadPlaylist(context.Background(), "https://music.apple.com/us/album/album/2", callbacks); !IsDownloadError(err, ErrorInvalidURL) {
+		t.Errorf("Expected album URLs to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PreflightTimeout bounds each connection attempt of Validate
+const PreflightTimeout = 2 * time.Second
+
+// Validate checks before a song is queued that its download can start: the
+// device and decryption services accept connections and the song's metadata
+// can be fetched. It fails fast with a DownloadError, of type
+// ErrorBackendUnavailable when a service is down, instead of after the
+// encrypted song has been downloaded. Songs missing from the requested
+// storefront pass, as the fallback storefronts may have them
+func (sd *SongDownloaderImpl) Validate(ctx context.Context, url string) error {
+	urlMeta, err := sd.ExtractUrlMeta(url)
+	if err != nil {
+		return NewDownloadErrorWithCause(ErrorInvalidURL, "failed to extract URL metadata", err)
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, PreflightTimeout)
+	defer cancel()
+
+	checks := make(chan DependencyCheck, 2)
+	go func() { checks <- probeTCP(dialCtx, "Device (M3U8_URL)", sd.deviceUrl) }()
+	go func() { checks <- probeTCP(dialCtx, "Decryptor (DEC_URL)", sd.decryptionUrl) }()
+	for i := 0; i < 2; i++ {
+		if check := <-checks; !check.OK() {
+			return NewDownloadErrorWithCause(ErrorBackendUnavailable, check.Name+" is unavailable", check.Err).
+				WithContext("address", check.Target)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return NewDownloadErrorWithCause(ErrorCancelled, "validation cancelled", err)
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return NewDownloadErrorWithCause(ErrorTokenUnavailable, "failed to get authentication token", err)
+	}
+	if _, _, err := sd.getSongMetaRefreshingToken(ctx, urlMeta, token); err != nil && !errors.Is(err, ErrNotInStorefront) {
+		return NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newPreflightDownloader returns a downloader whose services listen on local
+// ports and whose catalog is served by catalog
+func newPreflightDownloader(t *testing.T, catalog http.HandlerFunc) *SongDownloaderImpl {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	for _, addr := range []*string{&sd.deviceUrl, &sd.decryptionUrl} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		*addr = listener.Addr().String()
+	}
+
+	server := httptest.NewServer(catalog)
+	t.Cleanup(server.Close)
+	sd.apple.catalogURL = server.URL
+	sd.staticToken = "static-token"
+	return sd
+}
+
+func TestValidate(t *testing.T) {
+	const songURL = "https://music.apple.com/us/song/test/1"
+
+	sd := newPreflightDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song"}}]}`)
+	})
+	if err := sd.Validate(context.Background(), songURL); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	if err := sd.Validate(context.Background(), "https://example.com/song"); !IsDownloadError(err, ErrorInvalidURL) {
+		t.Errorf("Expected ErrorInvalidURL for a foreign link, got %v", err)
+	}
+
+	// Nothing listens on the decryptor address once its listener is closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	sd.decryptionUrl = closed.Addr().String()
+	if err := sd.Validate(context.Background(), songURL); !IsDownloadError(err, ErrorBackendUnavailable) {
+		t.Errorf("Expected ErrorBackendUnavailable for a closed decryptor, got %v", err)
+	}
+}
+
+func TestValidate_MetadataFailure(t *testing.T) {
+	const songURL = "https://music.apple.com/us/song/test/1"
+
+	sd := newPreflightDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if err := sd.Validate(context.Background(), songURL); !IsDownloadError(err, ErrorNetworkFailure) {
+		t.Errorf("Expected ErrorNetworkFailure when the catalog fails, got %v", err)
+	}
+
+	// Fallback storefronts may have songs missing from the requested one
+	sd = newPreflightDownloader(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[]}`)
+	})
+	if err := sd.Validate(context.Background(), songURL); err != nil {
+		t.Errorf("Expected a song missing from the storefront to pass, got %v", err)
+	}
+}
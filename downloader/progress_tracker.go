@@ -2,14 +2,30 @@ package downloader
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 )
 
-// ProgressTracker manages periodic progress updates with a 2-second interval
+const (
+	// DefaultMinProgressDelta is how many percentage points progress must
+	// move before a periodic update is reported
+	DefaultMinProgressDelta = 1.0
+
+	// DefaultMaxUpdateInterval is the longest a running phase goes without a
+	// periodic update, so slow downloads still show they are alive
+	DefaultMaxUpdateInterval = 15 * time.Second
+)
+
+// ProgressTracker manages periodic progress updates, checked every 2 seconds
+// by default. A check reports only when the phase changed, progress moved by
+// the minimum delta or the maximum interval passed since the last report.
+// Reporters paced by an EditRateController may skip some of them
 type ProgressTracker struct {
 	// Configuration
-	updateInterval time.Duration
+	updateInterval time.Duration // Time between checks (the minimum update interval)
+	maxInterval    time.Duration // Longest time between reports of a phase
+	minDelta       float64       // Percentage points progress must move to be reported
 	reporter       ProgressReporter
 	
 	// State management
@@ -33,19 +49,63 @@ type progressUpdate struct {
 	progress Progress
 }
 
+// lastReport is the periodic update a ProgressTracker last reported
+type lastReport struct {
+	phase      Phase
+	percentage float64
+	at         time.Time
+}
+
+// ProgressTrackerOption configures a ProgressTracker
+type ProgressTrackerOption func(*ProgressTracker)
+
+// WithMinUpdateInterval sets how often progress is checked, and so the least
+// time between two periodic updates
+func WithMinUpdateInterval(interval time.Duration) ProgressTrackerOption {
+	return func(pt *ProgressTracker) {
+		pt.updateInterval = interval
+	}
+}
+
+// WithMaxUpdateInterval sets the longest time between two periodic updates,
+// even when progress has not moved
+func WithMaxUpdateInterval(interval time.Duration) ProgressTrackerOption {
+	return func(pt *ProgressTracker) {
+		pt.maxInterval = interval
+	}
+}
+
+// WithMinProgressDelta sets how many percentage points progress must move
+// before a periodic update is reported
+func WithMinProgressDelta(delta float64) ProgressTrackerOption {
+	return func(pt *ProgressTracker) {
+		pt.minDelta = delta
+	}
+}
+
 // NewProgressTracker creates a new ProgressTracker with the specified reporter
 func NewProgressTracker(reporter ProgressReporter) *ProgressTracker {
-	return &ProgressTracker{
-		updateInterval: 2 * time.Second,
-		reporter:       reporter,
-		currentPhase:   -1, // Initialize to invalid phase to detect first phase change
-	}
+	return NewProgressTrackerWithOptions(reporter)
 }
 
 // NewProgressTrackerWithInterval creates a ProgressTracker with a custom update interval
 func NewProgressTrackerWithInterval(reporter ProgressReporter, interval time.Duration) *ProgressTracker {
-	pt := NewProgressTracker(reporter)
-	pt.updateInterval = interval
+	return NewProgressTrackerWithOptions(reporter, WithMinUpdateInterval(interval))
+}
+
+// NewProgressTrackerWithOptions creates a ProgressTracker with the default
+// intervals and delta, changed by opts
+func NewProgressTrackerWithOptions(reporter ProgressReporter, opts ...ProgressTrackerOption) *ProgressTracker {
+	pt := &ProgressTracker{
+		updateInterval: BaseEditInterval,
+		maxInterval:    DefaultMaxUpdateInterval,
+		minDelta:       DefaultMinProgressDelta,
+		reporter:       reporter,
+		currentPhase:   -1, // Initialize to invalid phase to detect first phase change
+	}
+	for _, opt := range opts {
+		opt(pt)
+	}
 	return pt
 }
 
@@ -146,7 +206,7 @@ func (pt *ProgressTracker) IsRunning() bool {
 func (pt *ProgressTracker) updateLoop() {
 	defer close(pt.doneChan)
 	
-	var lastReportedPhase Phase = -1 // Initialize to invalid phase
+	last := lastReport{phase: -1} // Initialize to invalid phase
 	
 	for {
 		select {
@@ -172,21 +232,33 @@ func (pt *ProgressTracker) updateLoop() {
 			}
 			
 		case <-pt.ticker.C:
-			// Periodic update every 2 seconds
+			// Periodic check, every 2 seconds by default
 			pt.mu.RLock()
 			currentPhase := pt.currentPhase
 			currentProgress := pt.currentProgress
 			pt.mu.RUnlock()
 			
-			// Only report if we have valid progress and phase has been set (not -1)
-			if pt.reporter != nil && currentPhase >= 0 && 
-			   (currentPhase != lastReportedPhase || 
-			    (currentProgress.TotalBytes > 0 && currentProgress.BytesProcessed >= 0)) {
+			// Only report once the phase has been set (not -1) and something changed
+			now := time.Now()
+			if pt.reporter != nil && currentPhase >= 0 && pt.shouldReport(last, currentPhase, currentProgress, now) {
 				if err := pt.reporter.UpdateProgress(currentPhase, currentProgress); err != nil {
 					// Log error but continue (could add logging here)
 				}
-				lastReportedPhase = currentPhase
+				last = lastReport{phase: currentPhase, percentage: currentProgress.Percentage, at: now}
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// shouldReport reports whether a periodic update of progress in phase is
+// worth sending at now, given the last one reported
+func (pt *ProgressTracker) shouldReport(last lastReport, phase Phase, progress Progress, now time.Time) bool {
+	switch {
+	case phase != last.phase:
+		return true
+	case math.Abs(progress.Percentage-last.percentage) >= pt.minDelta:
+		return true
+	default:
+		return now.Sub(last.at) >= pt.maxInterval
+	}
+}
@@ -101,6 +101,8 @@ func (m *MockProgressReporter) ReportComplete(duration time.Duration, filePath s
 	return nil
 }
 
+func (m *MockProgressReporter) UpdateSongName(name string) {}
+
 func (m *MockProgressReporter) Stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -279,7 +281,9 @@ func TestProgressTracker_PhaseChangeReporting(t *testing.T) {
 
 func TestProgressTracker_PeriodicUpdates(t *testing.T) {
 	reporter := NewMockProgressReporter()
-	tracker := NewProgressTrackerWithInterval(reporter, 100*time.Millisecond)
+	tracker := NewProgressTrackerWithOptions(reporter,
+		WithMinUpdateInterval(100*time.Millisecond),
+		WithMaxUpdateInterval(100*time.Millisecond))
 	ctx := context.Background()
 	
 	err := tracker.Start(ctx)
@@ -432,4 +436,75 @@ func TestProgressTracker_ConcurrentUpdates(t *testing.T) {
 	if progress.TotalBytes != 1000 {
 		t.Errorf("Expected total bytes to be 1000, got %d", progress.TotalBytes)
 	}
-}
\ No newline at end of file
+}
+
+func TestProgressTracker_Options(t *testing.T) {
+	tracker := NewProgressTrackerWithOptions(NewMockProgressReporter(),
+		WithMinUpdateInterval(time.Second),
+		WithMaxUpdateInterval(time.Minute),
+		WithMinProgressDelta(5))
+	if tracker.updateInterval != time.Second || tracker.maxInterval != time.Minute || tracker.minDelta != 5 {
+		t.Errorf("Options not applied: interval %v, max %v, delta %v", tracker.updateInterval, tracker.maxInterval, tracker.minDelta)
+	}
+
+	tracker = NewProgressTrackerWithInterval(NewMockProgressReporter(), time.Second)
+	if tracker.maxInterval != DefaultMaxUpdateInterval || tracker.minDelta != DefaultMinProgressDelta {
+		t.Errorf("Expected the defaults, got max %v, delta %v", tracker.maxInterval, tracker.minDelta)
+	}
+}
+
+func TestProgressTracker_ShouldReport(t *testing.T) {
+	tracker := NewProgressTracker(NewMockProgressReporter())
+	now := time.Now()
+	last := lastReport{phase: PhaseDownloading, percentage: 40, at: now}
+
+	testCases := []struct {
+		name     string
+		phase    Phase
+		percent  float64
+		elapsed  time.Duration
+		expected bool
+	}{
+		{"unchanged", PhaseDownloading, 40, 2 * time.Second, false},
+		{"below delta", PhaseDownloading, 40.9, 2 * time.Second, false},
+		{"delta reached", PhaseDownloading, 41, 2 * time.Second, true},
+		{"phase changed", PhaseDecrypting, 40, 2 * time.Second, true},
+		{"heartbeat", PhaseDownloading, 40, DefaultMaxUpdateInterval, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tracker.shouldReport(last, tc.phase, Progress{Percentage: tc.percent}, now.Add(tc.elapsed))
+			if got != tc.expected {
+				t.Errorf("shouldReport() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestProgressTracker_SuppressesUnchangedProgress(t *testing.T) {
+	reporter := NewMockProgressReporter()
+	tracker := NewProgressTrackerWithOptions(reporter, WithMinUpdateInterval(20*time.Millisecond))
+	if err := tracker.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start tracker: %v", err)
+	}
+	defer tracker.Stop()
+
+	tracker.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 500, TotalBytes: 1000, Percentage: 50})
+	time.Sleep(150 * time.Millisecond)
+	if calls := len(reporter.GetUpdateProgressCalls()); calls != 1 {
+		t.Fatalf("Expected unchanged progress to be reported once, got %d", calls)
+	}
+
+	tracker.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 505, TotalBytes: 1000, Percentage: 50.5})
+	time.Sleep(100 * time.Millisecond)
+	if calls := len(reporter.GetUpdateProgressCalls()); calls != 1 {
+		t.Fatalf("Expected a move below the delta not to be reported, got %d calls", calls)
+	}
+
+	tracker.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 520, TotalBytes: 1000, Percentage: 52})
+	time.Sleep(100 * time.Millisecond)
+	if calls := len(reporter.GetUpdateProgressCalls()); calls != 2 {
+		t.Errorf("Expected a move of the delta to be reported, got %d calls", calls)
+	}
+}
@@ -0,0 +1,224 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-alac-bot/logging"
+
+	"github.com/grafov/m3u8"
+)
+
+// MaxALACSampleRate is the highest sample rate the decryption service handles
+const MaxALACSampleRate = 192000
+
+const (
+	// CodecAAC marks streams and songs downloaded as AAC instead of ALAC
+	CodecAAC = "aac"
+
+	// FormatAAC is the DownloadResult format of songs downloaded as AAC
+	FormatAAC = "m4a-aac"
+)
+
+// errNoALACVariant is returned when a master playlist has no ALAC variant
+var errNoALACVariant = errors.New("no codec found")
+
+// MediaInfo is the quality of an ALAC stream, or of an AAC stream when Codec
+// is CodecAAC
+type MediaInfo struct {
+	BitDepth   int    `json:"bit_depth"`
+	SampleRate int    `json:"sample_rate"` // Hz
+	Codec      string `json:"codec,omitempty"`
+	Bitrate    int    `json:"bitrate,omitempty"` // Bits per second, AAC only
+}
+
+// String formats the quality as e.g. "24-bit/96 kHz", or "AAC 256 kbps"
+func (mi MediaInfo) String() string {
+	if mi.Codec == CodecAAC {
+		return fmt.Sprintf("AAC %d kbps", mi.Bitrate/1000)
+	}
+	return fmt.Sprintf("%d-bit/%s kHz", mi.BitDepth, strconv.FormatFloat(float64(mi.SampleRate)/1000, 'f', -1, 64))
+}
+
+// better reports whether mi is of higher quality than other, sample rate first
+func (mi MediaInfo) better(other MediaInfo) bool {
+	if mi.SampleRate != other.SampleRate {
+		return mi.SampleRate > other.SampleRate
+	}
+	return mi.BitDepth > other.BitDepth
+}
+
+// within reports whether mi does not exceed limit. Zero fields of limit do
+// not limit
+func (mi MediaInfo) within(limit MediaInfo) bool {
+	return (limit.BitDepth == 0 || mi.BitDepth <= limit.BitDepth) &&
+		(limit.SampleRate == 0 || mi.SampleRate <= limit.SampleRate)
+}
+
+// ParseQualityCap parses a quality cap such as "24/96" or "16/44.1", bit depth
+// then sample rate in kHz. An empty string is no cap
+func ParseQualityCap(value string) (MediaInfo, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return MediaInfo{}, nil
+	}
+
+	depth, rate, ok := strings.Cut(value, "/")
+	if !ok {
+		return MediaInfo{}, fmt.Errorf("invalid quality cap %q, expected bit depth/kHz such as 24/96", value)
+	}
+	bitDepth, err := strconv.Atoi(strings.TrimSpace(depth))
+	if err != nil || bitDepth <= 0 {
+		return MediaInfo{}, fmt.Errorf("invalid bit depth in quality cap %q", value)
+	}
+	kHz, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || kHz <= 0 {
+		return MediaInfo{}, fmt.Errorf("invalid sample rate in quality cap %q", value)
+	}
+
+	return MediaInfo{BitDepth: bitDepth, SampleRate: int(kHz*1000 + 0.5)}, nil
+}
+
+// qualityCapFromEnv reads the quality cap from ALAC_MAX_QUALITY. An invalid
+// value is ignored with a warning, selecting the highest quality
+func qualityCapFromEnv() MediaInfo {
+	limit, err := ParseQualityCap(getEnv("ALAC_MAX_QUALITY", ""))
+	if err != nil {
+		logger.Warn("Ignoring ALAC_MAX_QUALITY", logging.Err(err))
+	}
+	return limit
+}
+
+// parseALACAudio reads the quality from the audio group of an ALAC variant,
+// e.g. "audio-alac-stereo-96000-24"
+func parseALACAudio(audio string) (MediaInfo, error) {
+	fields := strings.Split(audio, "-")
+	if len(fields) < 2 {
+		return MediaInfo{}, fmt.Errorf("unexpected ALAC audio group %q", audio)
+	}
+
+	sampleRate, err := strconv.Atoi(fields[len(fields)-2])
+	if err != nil || sampleRate <= 0 {
+		return MediaInfo{}, fmt.Errorf("no sample rate in ALAC audio group %q", audio)
+	}
+	bitDepth, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || bitDepth <= 0 {
+		return MediaInfo{}, fmt.Errorf("no bit depth in ALAC audio group %q", audio)
+	}
+
+	return MediaInfo{BitDepth: bitDepth, SampleRate: sampleRate}, nil
+}
+
+// selectALACVariant picks the highest quality ALAC variant within limit and
+// MaxALACSampleRate. Variants whose quality cannot be read are skipped, ties
+// go to the higher average bandwidth
+func selectALACVariant(variants []*m3u8.Variant, limit MediaInfo) (*m3u8.Variant, MediaInfo, error) {
+	var selected *m3u8.Variant
+	var selectedInfo MediaInfo
+	found := 0
+
+	for _, variant := range variants {
+		if variant == nil || variant.Codecs != "alac" {
+			continue
+		}
+		info, err := parseALACAudio(variant.Audio)
+		if err != nil {
+			logger.Debug("Skipping ALAC variant", logging.RedactedURL("URI", variant.URI), logging.Err(err))
+			continue
+		}
+		found++
+		if info.SampleRate > MaxALACSampleRate || !info.within(limit) {
+			continue
+		}
+		if selected == nil || info.better(selectedInfo) ||
+			(info == selectedInfo && variant.AverageBandwidth > selected.AverageBandwidth) {
+			selected, selectedInfo = variant, info
+		}
+	}
+
+	if selected == nil {
+		if found > 0 {
+			return nil, MediaInfo{}, errors.New("no ALAC variant within the quality cap")
+		}
+		return nil, MediaInfo{}, errNoALACVariant
+	}
+	return selected, selectedInfo, nil
+}
+
+// selectAACVariant picks the AAC variant of the highest bandwidth, for master
+// playlists without ALAC
+func selectAACVariant(variants []*m3u8.Variant) (*m3u8.Variant, MediaInfo, error) {
+	var selected *m3u8.Variant
+	for _, variant := range variants {
+		if variant == nil || !strings.HasPrefix(variant.Codecs, "mp4a.40.") {
+			continue
+		}
+		if selected == nil || variantBandwidth(variant) > variantBandwidth(selected) {
+			selected = variant
+		}
+	}
+
+	if selected == nil {
+		return nil, MediaInfo{}, errors.New("no ALAC or AAC variant found")
+	}
+	return selected, MediaInfo{Codec: CodecAAC, Bitrate: int(variantBandwidth(selected))}, nil
+}
+
+// variantBandwidth returns the average bandwidth of a variant, or its peak
+// bandwidth when the playlist gives no average
+func variantBandwidth(variant *m3u8.Variant) uint32 {
+	if variant.AverageBandwidth > 0 {
+		return variant.AverageBandwidth
+	}
+	return variant.Bandwidth
+}
+
+// selectVariant picks the ALAC variant to download or, with the AAC fallback
+// on, the best AAC variant of master playlists without ALAC
+func (sd *SongDownloaderImpl) selectVariant(variants []*m3u8.Variant) (*m3u8.Variant, MediaInfo, error) {
+	variant, media, err := selectALACVariant(variants, sd.qualityCap)
+	if errors.Is(err, errNoALACVariant) && sd.options.AACFallback {
+		return selectAACVariant(variants)
+	}
+	return variant, media, err
+}
+
+// QualityLabel describes the audio format, e.g. "24-bit / 96 kHz ALAC" or
+// "256 kbps AAC". It is empty when the format is unknown
+func (m *SongMetadata) QualityLabel() string {
+	if m != nil && m.Codec == CodecAAC {
+		if m.Bitrate == 0 {
+			return "AAC"
+		}
+		return fmt.Sprintf("%d kbps AAC", m.Bitrate/1000)
+	}
+	if m == nil || m.BitDepth == 0 || m.SampleRateHz == 0 {
+		return ""
+	}
+	kHz := strconv.FormatFloat(float64(m.SampleRateHz)/1000, 'f', -1, 64)
+	return fmt.Sprintf("%d-bit / %s kHz ALAC", m.BitDepth, kHz)
+}
+
+// setAudioFormat copies the audio format of alac into the metadata
+func (m *SongMetadata) setAudioFormat(alac *Alac) {
+	if alac == nil {
+		return
+	}
+	m.BitDepth = int(alac.BitDepth)
+	m.SampleRateHz = int(alac.SampleRate)
+	m.Channels = int(alac.NumChannels)
+	m.Bitrate = int(alac.AvgBitRate)
+}
+
+// setAACFormat copies the audio format of an AAC song into the metadata
+func (m *SongMetadata) setAACFormat(info *SongInfo) {
+	if info.aacEntry == nil {
+		return
+	}
+	m.Codec = CodecAAC
+	m.SampleRateHz = int(info.aacEntry.SampleRate >> 16)
+	m.Channels = int(info.aacEntry.ChannelCount)
+	m.Bitrate = int(info.aacBitrate)
+}
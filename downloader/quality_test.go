@@ -0,0 +1,223 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/abema/go-mp4"
+	"github.com/grafov/m3u8"
+)
+
+// alacVariant returns an ALAC variant with the given audio group
+func alacVariant(uri, audio string, bandwidth uint32) *m3u8.Variant {
+	return &m3u8.Variant{URI: uri, VariantParams: m3u8.VariantParams{Codecs: "alac", Audio: audio, AverageBandwidth: bandwidth}}
+}
+
+func TestParseALACAudio(t *testing.T) {
+	tests := []struct {
+		audio   string
+		want    MediaInfo
+		wantErr bool
+	}{
+		{"audio-alac-stereo-96000-24", MediaInfo{BitDepth: 24, SampleRate: 96000}, false},
+		{"audio-alac-stereo-44100-16", MediaInfo{BitDepth: 16, SampleRate: 44100}, false},
+		{"", MediaInfo{}, true},
+		{"alac", MediaInfo{}, true},
+		{"audio-alac-stereo-96000", MediaInfo{}, true},
+		{"audio-alac-stereo-hires-24", MediaInfo{}, true},
+		{"audio-alac-stereo-96000-", MediaInfo{}, true},
+		{"audio-alac-stereo--24", MediaInfo{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseALACAudio(test.audio)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseALACAudio(%q) = %+v, %v, want %+v (error %v)", test.audio, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseQualityCap(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    MediaInfo
+		wantErr bool
+	}{
+		{"", MediaInfo{}, false},
+		{"24/96", MediaInfo{BitDepth: 24, SampleRate: 96000}, false},
+		{" 16 / 44.1 ", MediaInfo{BitDepth: 16, SampleRate: 44100}, false},
+		{"24", MediaInfo{}, true},
+		{"24/", MediaInfo{}, true},
+		{"x/96", MediaInfo{}, true},
+		{"24/-96", MediaInfo{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseQualityCap(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseQualityCap(%q) = %+v, %v, want %+v (error %v)", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestSelectALACVariant(t *testing.T) {
+	// Ordered as Apple sometimes lists them, a lower quality first
+	variants := []*m3u8.Variant{
+		{URI: "aac.m3u8", VariantParams: m3u8.VariantParams{Codecs: "mp4a.40.2", Audio: "audio-stereo-256", AverageBandwidth: 9000000}},
+		alacVariant("48k.m3u8", "audio-alac-stereo-48000-24", 2000000),
+		alacVariant("96k.m3u8", "audio-alac-stereo-96000-24", 1500000),
+		alacVariant("broken.m3u8", "audio-alac-stereo-hires", 5000000),
+		alacVariant("192k.m3u8", "audio-alac-stereo-192000-24", 1000000),
+		alacVariant("44k.m3u8", "audio-alac-stereo-44100-16", 800000),
+	}
+
+	tests := []struct {
+		name    string
+		limit   MediaInfo
+		wantURI string
+		want    MediaInfo
+	}{
+		{"highest", MediaInfo{}, "192k.m3u8", MediaInfo{BitDepth: 24, SampleRate: 192000}},
+		{"capped at 24/96", MediaInfo{BitDepth: 24, SampleRate: 96000}, "96k.m3u8", MediaInfo{BitDepth: 24, SampleRate: 96000}},
+		{"capped at 16-bit", MediaInfo{BitDepth: 16, SampleRate: 192000}, "44k.m3u8", MediaInfo{BitDepth: 16, SampleRate: 44100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			variant, info, err := selectALACVariant(variants, test.limit)
+			if err != nil {
+				t.Fatalf("selectALACVariant() error = %v", err)
+			}
+			if variant.URI != test.wantURI || info != test.want {
+				t.Errorf("selectALACVariant() = %s, %+v, want %s, %+v", variant.URI, info, test.wantURI, test.want)
+			}
+		})
+	}
+
+	if _, _, err := selectALACVariant(variants, MediaInfo{BitDepth: 8}); err == nil {
+		t.Error("Expected an error when no variant is within the cap")
+	}
+	if _, _, err := selectALACVariant(variants[:1], MediaInfo{}); err == nil {
+		t.Error("Expected an error without ALAC variants")
+	}
+}
+
+// aacVariant returns an AAC variant with the given codecs and bandwidth
+func aacVariant(uri, codecs string, bandwidth uint32) *m3u8.Variant {
+	return &m3u8.Variant{URI: uri, VariantParams: m3u8.VariantParams{Codecs: codecs, Audio: "audio-stereo", AverageBandwidth: bandwidth}}
+}
+
+func TestSelectAACVariant(t *testing.T) {
+	variants := []*m3u8.Variant{
+		aacVariant("he.m3u8", "mp4a.40.5", 64000),
+		aacVariant("256.m3u8", "mp4a.40.2", 256000),
+		{URI: "atmos.m3u8", VariantParams: m3u8.VariantParams{Codecs: "ec-3", AverageBandwidth: 768000}},
+		{URI: "peak.m3u8", VariantParams: m3u8.VariantParams{Codecs: "mp4a.40.2", Bandwidth: 128000}},
+	}
+
+	variant, info, err := selectAACVariant(variants)
+	if err != nil {
+		t.Fatalf("selectAACVariant() error = %v", err)
+	}
+	if variant.URI != "256.m3u8" || info != (MediaInfo{Codec: CodecAAC, Bitrate: 256000}) {
+		t.Errorf("selectAACVariant() = %s, %+v, want the 256 kbps variant", variant.URI, info)
+	}
+
+	if _, _, err := selectAACVariant(variants[2:3]); err == nil {
+		t.Error("Expected an error without AAC variants")
+	}
+}
+
+func TestSelectVariant_AACFallback(t *testing.T) {
+	// A manifest without any alac codec
+	variants := []*m3u8.Variant{
+		aacVariant("he.m3u8", "mp4a.40.5", 64000),
+		aacVariant("256.m3u8", "mp4a.40.2", 256000),
+	}
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+
+	if _, _, err := sd.selectVariant(variants); !errors.Is(err, errNoALACVariant) {
+		t.Errorf("Expected no variant without the AAC fallback, got %v", err)
+	}
+
+	sd.SetOptions(DownloadOptions{AACFallback: true})
+	variant, info, err := sd.selectVariant(variants)
+	if err != nil {
+		t.Fatalf("selectVariant() error = %v", err)
+	}
+	if variant.URI != "256.m3u8" || info.Codec != CodecAAC {
+		t.Errorf("Expected the 256 kbps AAC variant, got %s, %+v", variant.URI, info)
+	}
+
+	// ALAC is preferred whenever the manifest has it
+	withALAC := append(variants, alacVariant("44k.m3u8", "audio-alac-stereo-44100-16", 800000))
+	if variant, info, err := sd.selectVariant(withALAC); err != nil || variant.URI != "44k.m3u8" || info.Codec != "" {
+		t.Errorf("Expected the ALAC variant, got %v, %+v, %v", variant, info, err)
+	}
+
+	// A quality cap that excludes every ALAC variant does not fall back
+	sd.qualityCap = MediaInfo{BitDepth: 8}
+	if _, _, err := sd.selectVariant(withALAC); err == nil || errors.Is(err, errNoALACVariant) {
+		t.Errorf("Expected the quality cap error, got %v", err)
+	}
+}
+
+func TestMediaInfo_String(t *testing.T) {
+	if got := (MediaInfo{BitDepth: 24, SampleRate: 96000}).String(); got != "24-bit/96 kHz" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (MediaInfo{BitDepth: 16, SampleRate: 44100}).String(); got != "16-bit/44.1 kHz" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (MediaInfo{Codec: CodecAAC, Bitrate: 256000}).String(); got != "AAC 256 kbps" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestSongMetadata_AudioFormat(t *testing.T) {
+	meta := &SongMetadata{}
+	if label := meta.QualityLabel(); label != "" {
+		t.Errorf("Expected no label before the format is known, got %q", label)
+	}
+
+	meta.setAudioFormat(&Alac{BitDepth: 24, NumChannels: 2, AvgBitRate: 2822400, SampleRate: 96000})
+	if meta.BitDepth != 24 || meta.SampleRateHz != 96000 || meta.Channels != 2 || meta.Bitrate != 2822400 {
+		t.Errorf("Unexpected audio format %+v", meta)
+	}
+	if label := meta.QualityLabel(); label != "24-bit / 96 kHz ALAC" {
+		t.Errorf("QualityLabel() = %q", label)
+	}
+
+	meta = &SongMetadata{}
+	meta.setAudioFormat(&Alac{BitDepth: 16, NumChannels: 2, SampleRate: 44100})
+	if label := meta.QualityLabel(); label != "16-bit / 44.1 kHz ALAC" {
+		t.Errorf("QualityLabel() = %q", label)
+	}
+	meta.setAudioFormat(nil)
+	if meta.BitDepth != 16 {
+		t.Error("Expected a nil ALAC box to keep the format")
+	}
+}
+
+func TestSongMetadata_AACFormat(t *testing.T) {
+	meta := &SongMetadata{}
+	meta.setAACFormat(&SongInfo{
+		aacEntry:   &mp4.AudioSampleEntry{ChannelCount: 2, SampleRate: 44100 << 16},
+		aacBitrate: 256000,
+	})
+	if meta.Codec != CodecAAC || meta.SampleRateHz != 44100 || meta.Channels != 2 || meta.Bitrate != 256000 {
+		t.Errorf("Unexpected audio format %+v", meta)
+	}
+	if label := meta.QualityLabel(); label != "256 kbps AAC" {
+		t.Errorf("QualityLabel() = %q", label)
+	}
+
+	meta = &SongMetadata{}
+	meta.setAACFormat(&SongInfo{})
+	if meta.Codec != "" {
+		t.Error("Expected a song without an AAC entry to keep its format")
+	}
+	if label := (&SongMetadata{Codec: CodecAAC}).QualityLabel(); label != "AAC" {
+		t.Errorf("QualityLabel() = %q", label)
+	}
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import "time"
+
+const (
+	// rateWindow is how far back transfer speeds are measured
+	rateWindow = 5 * time.Second
+
+	// rateSampleInterval is the least time between two kept samples, so fast
+	// loops with many small steps keep a short history
+	rateSampleInterval = 100 * time.Millisecond
+)
+
+// rateMeter measures the speed of a transfer over the last rateWindow and
+// estimates the time left
+type rateMeter struct {
+	now     func() time.Time
+	samples []rateSample
+}
+
+// rateSample is how many bytes were processed at a point in time
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// newRateMeter creates a rate meter on the wall clock
+func newRateMeter() *rateMeter {
+	return &rateMeter{now: time.Now}
+}
+
+// Progress records that processed of total bytes are done and returns the
+// progress with its speed and ETA. When total is unknown (<= 0) only the
+// speed is set
+func (m *rateMeter) Progress(processed, total int64) Progress {
+	now := m.now()
+	if n := len(m.samples); n == 0 || now.Sub(m.samples[n-1].at) >= rateSampleInterval {
+		m.samples = append(m.samples, rateSample{at: now, bytes: processed})
+	}
+
+	// Keep the newest sample outside the window as the baseline
+	cutoff := now.Add(-rateWindow)
+	i := 0
+	for i+1 < len(m.samples) && !m.samples[i+1].at.After(cutoff) {
+		i++
+	}
+	m.samples = m.samples[i:]
+
+	progress := Progress{BytesProcessed: processed, TotalBytes: total}
+	if total > 0 {
+		progress.Percentage = float64(processed) / float64(total) * 100
+	}
+
+	first := m.samples[0]
+	if elapsed := now.Sub(first.at); elapsed > 0 && processed > first.bytes {
+		progress.Speed = int64(float64(processed-first.bytes) / elapsed.Seconds())
+	}
+	if progress.Speed > 0 && total > processed {
+		progress.ETA = time.Duration(float64(total-processed) / float64(progress.Speed) * float64(time.Second))
+	}
+
+	return progress
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestRateMeter creates a rate meter whose clock only moves by advance
+func newTestRateMeter() (*rateMeter, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	meter := &rateMeter{now: func() time.Time { return now }}
+	return meter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateMeter_SpeedAndETA(t *testing.T) {
+	meter, advance := newTestRateMeter()
+
+	if progress := meter.Progress(0, 1000); progress.Speed != 0 || progress.ETA != 0 {
+		t.Errorf("Expected no speed before time passed, got %+v", progress)
+	}
+
+	advance(time.Second)
+	progress := meter.Progress(100, 1000)
+	if progress.Speed != 100 {
+		t.Errorf("Speed = %d, want 100", progress.Speed)
+	}
+	if progress.ETA != 9*time.Second {
+		t.Errorf("ETA = %v, want 9s", progress.ETA)
+	}
+	if progress.Percentage != 10 {
+		t.Errorf("Percentage = %v, want 10", progress.Percentage)
+	}
+}
+
+func TestRateMeter_RollingWindow(t *testing.T) {
+	meter, advance := newTestRateMeter()
+
+	// 10 seconds at 100 B/s, then 5 seconds at 300 B/s
+	var processed int64
+	for i := 0; i <= 10; i++ {
+		meter.Progress(processed, 10000)
+		processed += 100
+		advance(time.Second)
+	}
+	processed -= 100
+	var progress Progress
+	for i := 0; i < 5; i++ {
+		processed += 300
+		progress = meter.Progress(processed, 10000)
+		advance(time.Second)
+	}
+
+	// Only the last 5 seconds count
+	if progress.Speed != 300 {
+		t.Errorf("Speed = %d, want 300 over the window", progress.Speed)
+	}
+}
+
+func TestRateMeter_UnknownTotal(t *testing.T) {
+	meter, advance := newTestRateMeter()
+
+	meter.Progress(0, -1)
+	advance(2 * time.Second)
+	progress := meter.Progress(1000, -1)
+	if progress.Speed != 500 {
+		t.Errorf("Speed = %d, want 500", progress.Speed)
+	}
+	if progress.ETA != 0 || progress.Percentage != 0 {
+		t.Errorf("Expected no ETA or percentage without a total, got %+v", progress)
+	}
+}
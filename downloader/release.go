@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// releaseDateLayout is the layout of catalog release dates
+const releaseDateLayout = "2006-01-02"
+
+// ReleaseStatus is what the catalog says about the release of a song
+type ReleaseStatus struct {
+	SongID      string
+	Title       string
+	Artist      string
+	ReleaseDate time.Time // Zero when the catalog has none
+	Released    bool      // Whether the song is out
+	ALAC        bool      // Whether the song can be downloaded in ALAC
+}
+
+// ReleaseDate returns the release date of the song, falling back to the one
+// of its album. It returns the zero time when neither is known
+func ReleaseDate(meta *AutoSong) time.Time {
+	dates := []string{meta.Attributes.ReleaseDate}
+	if album := songAlbumAttributes(meta); album != nil {
+		dates = append(dates, album.ReleaseDate)
+	}
+
+	for _, date := range dates {
+		if parsed, err := time.Parse(releaseDateLayout, date); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// IsPrerelease reports whether the song is listed in the catalog but not out
+// yet at now: it has no stream and its album is a pre-release or its release
+// date lies ahead
+func IsPrerelease(meta *AutoSong, now time.Time) bool {
+	if meta.Attributes.ExtendedAssetUrls["enhancedHls"] != "" {
+		return false
+	}
+	if album := songAlbumAttributes(meta); album != nil && album.IsPrerelease {
+		return true
+	}
+	return ReleaseDate(meta).After(now)
+}
+
+// newNotReleasedError creates the error for a song that is not out yet,
+// carrying what the bot needs to offer a release reminder
+func newNotReleasedError(meta *AutoSong) *DownloadError {
+	err := NewDownloadError(ErrorNotReleased, "song is not released yet").
+		WithContext("song_id", meta.ID).
+		WithContext("title", meta.Attributes.Name).
+		WithContext("artist", meta.Attributes.ArtistName)
+	if date := ReleaseDate(meta); !date.IsZero() {
+		err.WithContext("release_date", date)
+	}
+	return err
+}
+
+// NotReleasedStatus returns the release details carried by an ErrorNotReleased
+// error, or false for any other error
+func NotReleasedStatus(err error) (*ReleaseStatus, bool) {
+	var de *DownloadError
+	if !errors.As(err, &de) || de.Type != ErrorNotReleased {
+		return nil, false
+	}
+
+	status := &ReleaseStatus{}
+	status.SongID, _ = de.Context["song_id"].(string)
+	status.Title, _ = de.Context["title"].(string)
+	status.Artist, _ = de.Context["artist"].(string)
+	status.ReleaseDate, _ = de.Context["release_date"].(time.Time)
+	return status, true
+}
+
+// ReleaseStatus looks the song linked by songURL up in the catalog to tell
+// whether it is out and can be downloaded in ALAC
+func (sd *SongDownloaderImpl) ReleaseStatus(ctx context.Context, songURL string) (*ReleaseStatus, error) {
+	urlMeta, err := sd.ExtractUrlMeta(songURL)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorInvalidURL, "failed to extract URL metadata", err)
+	}
+	if urlMeta.URLType != "songs" {
+		return nil, NewDownloadError(ErrorInvalidURL, fmt.Sprintf("cannot check the release of %s", urlMeta.URLType))
+	}
+
+	token, err := sd.GetToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := sd.GetSongMeta(ctx, urlMeta, token)
+	if err != nil {
+		return nil, NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", err)
+	}
+
+	released := !IsPrerelease(meta, time.Now())
+	return &ReleaseStatus{
+		SongID:      meta.ID,
+		Title:       meta.Attributes.Name,
+		Artist:      meta.Attributes.ArtistName,
+		ReleaseDate: ReleaseDate(meta),
+		Released:    released,
+		ALAC:        released && meta.Attributes.ExtendedAssetUrls["enhancedHls"] != "",
+	}, nil
+}
@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsPrerelease(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		modify func(meta *AutoSong)
+		want   bool
+	}{
+		{
+			name:   "released long ago without ALAC",
+			modify: func(meta *AutoSong) {},
+			want:   false,
+		},
+		{
+			name: "release date ahead",
+			modify: func(meta *AutoSong) {
+				meta.Attributes.ReleaseDate = "2026-10-09"
+			},
+			want: true,
+		},
+		{
+			name: "pre-release album",
+			modify: func(meta *AutoSong) {
+				meta.Relationships.Albums.Data[0].Attributes.IsPrerelease = true
+			},
+			want: true,
+		},
+		{
+			name: "album release date ahead",
+			modify: func(meta *AutoSong) {
+				meta.Attributes.ReleaseDate = ""
+				meta.Relationships.Albums.Data[0].Attributes.ReleaseDate = "2026-10-09"
+			},
+			want: true,
+		},
+		{
+			name: "stream already available",
+			modify: func(meta *AutoSong) {
+				meta.Attributes.ReleaseDate = "2026-10-09"
+				meta.Attributes.ExtendedAssetUrls = map[string]string{"enhancedHls": "https://example.com/a.m3u8"}
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := retagTestMeta("Name")
+			tt.modify(meta)
+			if got := IsPrerelease(meta, now); got != tt.want {
+				t.Errorf("IsPrerelease() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotReleasedStatus(t *testing.T) {
+	meta := retagTestMeta("Upcoming")
+	meta.Attributes.ReleaseDate = "2026-10-09"
+
+	status, ok := NotReleasedStatus(fmt.Errorf("download failed: %w", newNotReleasedError(meta)))
+	if !ok {
+		t.Fatal("Expected the release details to be found in a wrapped error")
+	}
+	want := ReleaseStatus{SongID: "1440857781", Title: "Upcoming", Artist: "Artist", ReleaseDate: time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)}
+	if *status != want {
+		t.Errorf("NotReleasedStatus() = %+v, want %+v", *status, want)
+	}
+
+	if _, ok := NotReleasedStatus(NewDownloadError(ErrorALACNotAvailable, "no ALAC")); ok {
+		t.Error("Expected no release details for other errors")
+	}
+	if IsStorefrontFailure(newNotReleasedError(meta)) {
+		t.Error("Expected a song that is not out not to be tried in other storefronts")
+	}
+}
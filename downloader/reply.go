@@ -0,0 +1,21 @@
+package downloader
+
+import "github.com/gotd/td/tg"
+
+// ReplyTo returns the reply header of a message, nil for message ID 0
+func ReplyTo(messageID int) tg.InputReplyToClass {
+	if messageID == 0 {
+		return nil
+	}
+	return &tg.InputReplyToMessage{ReplyToMsgID: messageID}
+}
+
+// SendReplying calls send with the reply header of messageID and, when that
+// message was deleted in the meantime, once more without a reply header
+func SendReplying(messageID int, send func(replyTo tg.InputReplyToClass) error) error {
+	err := send(ReplyTo(messageID))
+	if messageID != 0 && IsReplyTargetGone(err) {
+		return send(nil)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// deletedReplyAPI rejects every message replying to another one, as Telegram
+// does once the replied message was deleted
+type deletedReplyAPI struct {
+	*MockTelegramAPI
+}
+
+func (a deletedReplyAPI) MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error) {
+	if request.ReplyTo != nil {
+		a.mu.Lock()
+		a.sendMessageCalls = append(a.sendMessageCalls, SendMessageCall{Request: request})
+		a.mu.Unlock()
+		return nil, tgerr.New(400, "REPLY_MESSAGE_ID_INVALID")
+	}
+	return a.MockTelegramAPI.MessagesSendMessage(ctx, request)
+}
+
+func TestTelegramProgressReporter_ReplyTo(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	reporter.SetReplyTo(42)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Song - Artist"); err != nil {
+		t.Fatalf("StartTracking() error = %v", err)
+	}
+
+	sends := api.GetSendMessageCalls()
+	if len(sends) != 1 {
+		t.Fatalf("Expected one progress message, got %d", len(sends))
+	}
+	replyTo, ok := sends[0].Request.ReplyTo.(*tg.InputReplyToMessage)
+	if !ok || replyTo.ReplyToMsgID != 42 {
+		t.Errorf("Expected the progress message to reply to message 42, got %v", sends[0].Request.ReplyTo)
+	}
+}
+
+func TestTelegramProgressReporter_ReplyToDeletedMessage(t *testing.T) {
+	api := deletedReplyAPI{NewMockTelegramAPI()}
+	reporter := NewTelegramProgressReporter(api)
+	reporter.SetReplyTo(42)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Song - Artist"); err != nil {
+		t.Fatalf("StartTracking() error = %v", err)
+	}
+
+	sends := api.GetSendMessageCalls()
+	if len(sends) != 2 {
+		t.Fatalf("Expected the progress message to be sent again, got %d sends", len(sends))
+	}
+	if sends[1].Request.ReplyTo != nil {
+		t.Errorf("Expected the second send not to reply, got %v", sends[1].Request.ReplyTo)
+	}
+}
+
+func TestSendReplying(t *testing.T) {
+	var replies []tg.InputReplyToClass
+	err := SendReplying(0, func(replyTo tg.InputReplyToClass) error {
+		replies = append(replies, replyTo)
+		return tgerr.New(400, "REPLY_MESSAGE_ID_INVALID")
+	})
+	if err == nil || len(replies) != 1 || replies[0] != nil {
+		t.Errorf("Expected a single send without reply for message 0, got %v and %v", replies, err)
+	}
+
+	replies = nil
+	err = SendReplying(42, func(replyTo tg.InputReplyToClass) error {
+		replies = append(replies, replyTo)
+		return tgerr.New(403, "CHAT_WRITE_FORBIDDEN")
+	})
+	if !tgerr.Is(err, "CHAT_WRITE_FORBIDDEN") || len(replies) != 1 {
+		t.Errorf("Expected other errors to be returned without retrying, got %d sends and %v", len(replies), err)
+	}
+}
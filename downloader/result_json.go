@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ResultDocument is the JSON document describing how a download ended, for
+// callers outside Go. Either FilePath and Metadata or Error is set
+type ResultDocument struct {
+	OK       bool          `json:"ok"`
+	FilePath string        `json:"file_path,omitempty"`
+	Metadata *SongMetadata `json:"metadata,omitempty"`
+	Error    *ResultError  `json:"error,omitempty"`
+}
+
+// ResultError is the error of a failed download in a ResultDocument
+type ResultError struct {
+	Type    string `json:"type"`    // ErrorType.String(), "unknown" for other errors
+	Message string `json:"message"` // Message of the error and its cause
+}
+
+// NewResultDocument describes the result and error returned by Download
+func NewResultDocument(result *DownloadResult, err error) ResultDocument {
+	if err == nil && result == nil {
+		err = errors.New("download returned no result")
+	}
+	if err != nil {
+		var downloadErr *DownloadError
+		if errors.As(err, &downloadErr) {
+			message := downloadErr.Message
+			if downloadErr.Cause != nil {
+				message += ": " + downloadErr.Cause.Error()
+			}
+			return ResultDocument{Error: &ResultError{Type: downloadErr.Type.String(), Message: message}}
+		}
+		return ResultDocument{Error: &ResultError{Type: ErrorUnknown.String(), Message: err.Error()}}
+	}
+	return ResultDocument{OK: true, FilePath: result.FilePath, Metadata: result.SongMeta}
+}
+
+// MarshalResult encodes the result and error returned by Download as a
+// ResultDocument
+func MarshalResult(result *DownloadResult, err error) []byte {
+	// The document holds only strings, numbers and bools, which always encode
+	data, _ := json.Marshal(NewResultDocument(result, err))
+	return data
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMarshalResult_Success(t *testing.T) {
+	result := &DownloadResult{
+		FilePath: "downloads/1 - Song - Artist.m4a",
+		SongMeta: &SongMetadata{Title: "Song", Artist: "Artist", Album: "Album", AppleMusicID: "1", BitDepth: 24, SampleRateHz: 96000},
+		FileSize: 1234,
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(MarshalResult(result, nil), &doc); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if doc["ok"] != true || doc["file_path"] != result.FilePath || doc["error"] != nil {
+		t.Errorf("Unexpected document: %v", doc)
+	}
+	metadata, _ := doc["metadata"].(map[string]any)
+	if metadata["title"] != "Song" || metadata["artist"] != "Artist" || metadata["bit_depth"] != 24.0 {
+		t.Errorf("Unexpected metadata: %v", metadata)
+	}
+}
+
+func TestMarshalResult_Errors(t *testing.T) {
+	errorTypes := []ErrorType{
+		ErrorInvalidURL,
+		ErrorNetworkFailure,
+		ErrorDecryptionFailure,
+		ErrorFileSystemError,
+		ErrorALACNotAvailable,
+		ErrorTimeout,
+		ErrorCancelled,
+		ErrorUnknown,
+		ErrorTokenUnavailable,
+		ErrorNotReleased,
+		ErrorBackendUnavailable,
+		ErrorFileTooLarge,
+	}
+
+	for _, errorType := range errorTypes {
+		t.Run(errorType.String(), func(t *testing.T) {
+			cause := errors.New("connection refused")
+			err := fmt.Errorf("request failed: %w", NewDownloadErrorWithCause(errorType, "failed", cause))
+
+			var doc ResultDocument
+			if err := json.Unmarshal(MarshalResult(nil, err), &doc); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if doc.OK || doc.FilePath != "" || doc.Metadata != nil || doc.Error == nil {
+				t.Fatalf("Expected only an error, got %+v", doc)
+			}
+			if doc.Error.Type != errorType.String() || doc.Error.Message != "failed: connection refused" {
+				t.Errorf("Unexpected error: %+v", doc.Error)
+			}
+		})
+	}
+}
+
+func TestMarshalResult_OtherErrors(t *testing.T) {
+	testCases := []struct {
+		err     error
+		message string
+	}{
+		{errors.New("boom"), "boom"},
+		{nil, "download returned no result"},
+	}
+
+	for _, tc := range testCases {
+		doc := NewResultDocument(nil, tc.err)
+		if doc.OK || doc.Error == nil || doc.Error.Type != "unknown" || doc.Error.Message != tc.message {
+			t.Errorf("NewResultDocument(nil, %v) = %+v", tc.err, doc)
+		}
+	}
+}
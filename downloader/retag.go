@@ -0,0 +1,447 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-alac-bot/logging"
+
+	"github.com/abema/go-mp4"
+)
+
+// ErrNotRetaggable is returned for files without the tags the bot writes, such
+// as files produced by other tools
+var ErrNotRetaggable = errors.New("file has no bot-written tags")
+
+var (
+	ilstPath = mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeUdta(), mp4.BoxTypeMeta(), mp4.BoxTypeIlst()}
+	stcoPath = mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStco()}
+	co64Path = mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeCo64()}
+	cnIDType = mp4.BoxType{'c', 'n', 'I', 'D'}
+	covrType = mp4.BoxType{'c', 'o', 'v', 'r'}
+)
+
+// Well-known data types of the iTunes data box
+const (
+	dataTypeJPEG = 13
+	dataTypePNG  = 14
+)
+
+// writtenTags are the ilst items generated by writeUdta. Any other item in an
+// existing file (artwork, lyrics) is carried over when retagging
+var writtenTags = map[mp4.BoxType]bool{
+	{'\251', 'n', 'a', 'm'}: true, {'s', 'o', 'n', 'm'}: true,
+	{'\251', 'a', 'l', 'b'}: true, {'s', 'o', 'a', 'l'}: true,
+	{'\251', 'A', 'R', 'T'}: true, {'s', 'o', 'a', 'r'}: true,
+	{'\251', 'p', 'r', 'f'}: true, {'-', '-', '-', '-'}: true,
+	{'\251', 'w', 'r', 't'}: true, {'s', 'o', 'c', 'o'}: true,
+	{'\251', 'd', 'a', 'y'}: true, {'c', 'n', 'I', 'D'}: true,
+	{'\251', 'g', 'e', 'n'}: true, {'a', 'A', 'R', 'T'}: true,
+	{'s', 'o', 'a', 'a'}: true, {'c', 'p', 'r', 't'}: true,
+	{'c', 'p', 'i', 'l'}: true, {'\251', 'p', 'u', 'b'}: true,
+	{'a', 't', 'I', 'D'}: true, {'t', 'r', 'k', 'n'}: true,
+	{'d', 'i', 's', 'k'}: true, {'g', 'n', 'r', 'e'}: true,
+}
+
+// RetagSummary is the outcome of retagging the files in a directory
+type RetagSummary struct {
+	Changed   []string // Files whose tags were rewritten
+	Unchanged int      // Files whose tags were already current
+	Skipped   []string // Files without bot-written tags
+	Failed    []string // Files that could not be read, fetched or written
+}
+
+// RetagFile rewrites the metadata tags of an M4A written by the bot, leaving
+// the audio data untouched. Files with neither a cnID tag nor a sidecar JSON
+// are rejected with ErrNotRetaggable
+func RetagFile(path string, meta *AutoSong) error {
+	if _, err := retagSongID(path); err != nil {
+		return err
+	}
+	_, err := rewriteTags(path, meta, nil, nil)
+	return err
+}
+
+// retagSongID returns the song ID of a file from its cnID tag or, for files
+// without one, from its sidecar JSON
+func retagSongID(path string) (string, error) {
+	songID, err := ReadSongID(path)
+	if errors.Is(err, ErrNotRetaggable) {
+		return readSidecarSongID(path)
+	}
+	return songID, err
+}
+
+// readSidecarSongID returns the song ID in the "id" field of the JSON file
+// next to path with the same name, such as song.json for song.m4a. Song
+// metadata saved as JSON qualifies
+func readSidecarSongID(path string) (string, error) {
+	raw, err := os.ReadFile(strings.TrimSuffix(path, filepath.Ext(path)) + ".json")
+	if os.IsNotExist(err) {
+		return "", ErrNotRetaggable
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var sidecar struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &sidecar); err != nil {
+		return "", fmt.Errorf("invalid sidecar JSON: %w", err)
+	}
+	if _, err := strconv.ParseUint(sidecar.ID, 10, 32); err != nil {
+		return "", ErrNotRetaggable
+	}
+	return sidecar.ID, nil
+}
+
+// ReadSongID returns the Apple Music song ID stored in a file's cnID tag
+func ReadSongID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	items, err := readIlstItems(f)
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range items {
+		if item.Type != cnIDType {
+			continue
+		}
+
+		// cnID holds a data box: size, type, data type, locale, then the value
+		raw := make([]byte, item.Size-item.HeaderSize)
+		if _, err := item.SeekToPayload(f); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(f, raw); err != nil {
+			return "", err
+		}
+		if len(raw) < 20 || string(raw[4:8]) != "data" {
+			return "", ErrNotRetaggable
+		}
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(raw[16:20])), 10), nil
+	}
+
+	return "", ErrNotRetaggable
+}
+
+// RetagDir retags every .m4a file under dir with the metadata fetch returns
+// for the song ID stored in the file or its sidecar JSON
+func RetagDir(dir string, fetch func(songID string) (*AutoSong, error)) (*RetagSummary, error) {
+	summary := &RetagSummary{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == TempDirName {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".m4a") {
+			return nil
+		}
+
+		songID, err := retagSongID(path)
+		if err != nil {
+			if errors.Is(err, ErrNotRetaggable) {
+				logger.Warn("Skipping file", logging.String("File", path), logging.Err(err))
+				summary.Skipped = append(summary.Skipped, path)
+			} else {
+				logger.Error("Failed to read file", logging.String("File", path), logging.Err(err))
+				summary.Failed = append(summary.Failed, path)
+			}
+			return nil
+		}
+
+		meta, err := fetch(songID)
+		if err != nil {
+			logger.Error("Failed to fetch metadata", logging.String("File", path), logging.String("Song", songID), logging.Err(err))
+			summary.Failed = append(summary.Failed, path)
+			return nil
+		}
+
+		changed, err := rewriteTags(path, meta, nil, nil)
+		switch {
+		case errors.Is(err, ErrNotRetaggable):
+			logger.Warn("Skipping file", logging.String("File", path), logging.Err(err))
+			summary.Skipped = append(summary.Skipped, path)
+		case err != nil:
+			logger.Error("Failed to retag file", logging.String("File", path), logging.Err(err))
+			summary.Failed = append(summary.Failed, path)
+		case changed:
+			summary.Changed = append(summary.Changed, path)
+		default:
+			summary.Unchanged++
+		}
+		return nil
+	})
+
+	return summary, err
+}
+
+// readIlstItems returns the ilst items of a file, or ErrNotRetaggable when the
+// file has no cnID tag
+func readIlstItems(r io.ReadSeeker) ([]*mp4.BoxInfo, error) {
+	items, err := readIlst(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.Type == cnIDType {
+			return items, nil
+		}
+	}
+	return nil, ErrNotRetaggable
+}
+
+// readIlst returns the ilst items of a file, or ErrNotRetaggable when the file
+// has no ilst box
+func readIlst(r io.ReadSeeker) ([]*mp4.BoxInfo, error) {
+	ilst, err := mp4.ExtractBox(r, nil, ilstPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(ilst) != 1 {
+		return nil, ErrNotRetaggable
+	}
+	return mp4.ExtractBox(r, ilst[0], mp4.BoxPath{mp4.BoxTypeAny()})
+}
+
+// rewriteTags rebuilds path with a new udta box and reports whether the tags
+// changed. A non-nil cover replaces the artwork and a non-nil album supplies
+// the track and disc totals. The file is rewritten through a temporary file:
+// every box except moov/udta is copied verbatim and chunk offsets are shifted
+// by the change in moov size, and the new file keeps the permissions of the
+// old one. Files without an ilst box are rejected with ErrNotRetaggable; which
+// song a file holds is for the caller to check
+func rewriteTags(path string, meta *AutoSong, album *AlbumContext, cover []byte) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	items, err := readIlst(f)
+	if err != nil {
+		return false, err
+	}
+
+	var preserved bytes.Buffer
+	for _, item := range items {
+		if writtenTags[item.Type] || (cover != nil && item.Type == covrType) {
+			continue
+		}
+		if _, err := item.SeekToStart(f); err != nil {
+			return false, err
+		}
+		if _, err := io.CopyN(&preserved, f, int64(item.Size)); err != nil {
+			return false, err
+		}
+	}
+
+	if cover != nil {
+		preserved.Write(coverItem(cover))
+	}
+
+	top, err := mp4.ExtractBox(f, nil, mp4.BoxPath{mp4.BoxTypeAny()})
+	if err != nil {
+		return false, err
+	}
+	moovs, err := mp4.ExtractBox(f, nil, mp4.BoxPath{mp4.BoxTypeMoov()})
+	if err != nil {
+		return false, err
+	}
+	moov := moovs[0]
+	children, err := mp4.ExtractBox(f, moov, mp4.BoxPath{mp4.BoxTypeAny()})
+	if err != nil {
+		return false, err
+	}
+	stcos, err := mp4.ExtractBoxesWithPayload(f, nil, []mp4.BoxPath{stcoPath, co64Path})
+	if err != nil {
+		return false, err
+	}
+	if len(stcos) == 0 {
+		return false, ErrNotRetaggable
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".retag-*.m4a")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	defer tmp.Close()
+
+	w := mp4.NewWriter(tmp)
+
+	// Where each moov child moved to, to find the stco boxes in the new file
+	moved := make(map[*mp4.BoxInfo]int64)
+	var oldUdta, newUdta *mp4.BoxInfo
+	var moovDelta int64
+
+	for _, box := range top {
+		if box.Type != mp4.BoxTypeMoov() {
+			if err := w.CopyBox(f, box); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		if _, err := w.StartBox(&mp4.BoxInfo{Type: mp4.BoxTypeMoov()}); err != nil {
+			return false, err
+		}
+		for _, child := range children {
+			start, err := w.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return false, err
+			}
+			moved[child] = start - int64(child.Offset)
+
+			if child.Type != mp4.BoxTypeUdta() {
+				if err := w.CopyBox(f, child); err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			// Skipped tags were reported when the file was first written
+			if _, err := writeUdta(w, meta, album, preserved.Bytes()); err != nil {
+				return false, err
+			}
+			end, err := w.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return false, err
+			}
+			oldUdta = child
+			newUdta = &mp4.BoxInfo{Offset: uint64(start), Size: uint64(end - start)}
+		}
+		newMoov, err := w.EndBox()
+		if err != nil {
+			return false, err
+		}
+		moovDelta = int64(newMoov.Size) - int64(moov.Size)
+	}
+
+	// Chunks stored after moov moved by the change in its size
+	for _, stco := range stcos {
+		var delta int64
+		for child, d := range moved {
+			if stco.Info.Offset >= child.Offset && stco.Info.Offset < child.Offset+child.Size {
+				delta = d
+			}
+		}
+
+		switch payload := stco.Payload.(type) {
+		case *mp4.Stco:
+			for i, offset := range payload.ChunkOffset {
+				if uint64(offset) < moov.Offset {
+					continue
+				}
+				shifted := int64(offset) + moovDelta
+				if shifted < 0 || shifted > math.MaxUint32 {
+					return false, fmt.Errorf("chunk offset %d out of range after retagging", shifted)
+				}
+				payload.ChunkOffset[i] = uint32(shifted)
+			}
+		case *mp4.Co64:
+			for i, offset := range payload.ChunkOffset {
+				if offset < moov.Offset {
+					continue
+				}
+				shifted := int64(offset) + moovDelta
+				if shifted < 0 {
+					return false, fmt.Errorf("chunk offset %d out of range after retagging", shifted)
+				}
+				payload.ChunkOffset[i] = uint64(shifted)
+			}
+		}
+
+		if _, err := tmp.Seek(int64(stco.Info.Offset+stco.Info.HeaderSize)+delta, io.SeekStart); err != nil {
+			return false, err
+		}
+		if _, err := mp4.Marshal(tmp, stco.Payload, stco.Info.Context); err != nil {
+			return false, err
+		}
+	}
+
+	changed, err := sectionsDiffer(f, oldUdta, tmp, newUdta)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	// CreateTemp makes the file private
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// coverItem builds a raw covr ilst item holding a JPEG or PNG image
+func coverItem(cover []byte) []byte {
+	dataType := uint32(dataTypeJPEG)
+	if bytes.HasPrefix(cover, []byte("\x89PNG")) {
+		dataType = dataTypePNG
+	}
+
+	dataSize := 16 + len(cover)
+	item := make([]byte, 8+dataSize)
+	binary.BigEndian.PutUint32(item[0:], uint32(len(item)))
+	copy(item[4:], "covr")
+	binary.BigEndian.PutUint32(item[8:], uint32(dataSize))
+	copy(item[12:], "data")
+	binary.BigEndian.PutUint32(item[16:], dataType)
+	// item[20:24] is the locale, left as 0
+	copy(item[24:], cover)
+	return item
+}
+
+// sectionsDiffer compares the bytes of a box in two files
+func sectionsDiffer(a io.ReadSeeker, boxA *mp4.BoxInfo, b io.ReadSeeker, boxB *mp4.BoxInfo) (bool, error) {
+	if boxA.Size != boxB.Size {
+		return true, nil
+	}
+
+	read := func(r io.ReadSeeker, box *mp4.BoxInfo) ([]byte, error) {
+		buf := make([]byte, box.Size)
+		if _, err := r.Seek(int64(box.Offset), io.SeekStart); err != nil {
+			return nil, err
+		}
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	bytesA, err := read(a, boxA)
+	if err != nil {
+		return false, err
+	}
+	bytesB, err := read(b, boxB)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(bytesA, bytesB), nil
+}
@@ -0,0 +1,294 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abema/go-mp4"
+)
+
+// retagTestMeta returns song metadata as the catalog API would
+func retagTestMeta(name string) *AutoSong {
+	return &AutoSong{
+		ID: "1440857781",
+		Attributes: SongAttributes{
+			Name:        name,
+			AlbumName:   "Album",
+			ArtistName:  "Artist",
+			ReleaseDate: "2020-01-01",
+			TrackNumber: 1,
+			DiscNumber:  1,
+			GenreNames:  []string{"Pop"},
+		},
+		Relationships: Relationships{
+			Albums: Relationship{Data: []RelationshipData{{
+				ID:         "1440857000",
+				Attributes: &AlbumAttributes{TrackCount: 10, Copyright: "℗ 2020"},
+			}}},
+			Artists: Relationship{Data: []RelationshipData{{ID: "12345"}}},
+		},
+	}
+}
+
+// writeBotFixture writes an M4A the way a download does and returns its path
+func writeBotFixture(t *testing.T, meta *AutoSong) string {
+	t.Helper()
+
+	info, err := parseSongInfo(buildFragmentedFixture(t, 1, []uint32{1, 1, 1}))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	var data []byte
+	for i := range info.samples {
+		info.samples[i].data = bytes.Repeat([]byte{byte(i + 1)}, len(info.samples[i].data))
+		data = append(data, info.samples[i].data...)
+	}
+
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	if _, err := sd.WriteM4a(mp4.NewWriter(file), info, meta, nil, data); err != nil {
+		t.Fatalf("Failed to write M4A: %v", err)
+	}
+	if err := validateOutputFile(path); err != nil {
+		t.Fatalf("Written M4A failed validation: %v", err)
+	}
+	return path
+}
+
+// readAudio returns the mdat payload and the bytes each stco chunk offset points at
+func readAudio(t *testing.T, path string) ([]byte, []byte) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	mdats, err := mp4.ExtractBox(file, nil, mp4.BoxPath{mp4.BoxTypeMdat()})
+	if err != nil || len(mdats) != 1 {
+		t.Fatalf("Failed to find mdat: %v", err)
+	}
+	mdat := make([]byte, mdats[0].Size-mdats[0].HeaderSize)
+	mdats[0].SeekToPayload(file)
+	io.ReadFull(file, mdat)
+
+	stcos, err := mp4.ExtractBoxWithPayload(file, nil, stcoPath)
+	if err != nil || len(stcos) != 1 {
+		t.Fatalf("Failed to find stco: %v", err)
+	}
+	var chunkStarts []byte
+	for _, offset := range stcos[0].Payload.(*mp4.Stco).ChunkOffset {
+		b := make([]byte, 1)
+		file.ReadAt(b, int64(offset))
+		chunkStarts = append(chunkStarts, b[0])
+	}
+	return mdat, chunkStarts
+}
+
+func TestRetagFile(t *testing.T) {
+	path := writeBotFixture(t, retagTestMeta("Old Name"))
+	audioBefore, chunksBefore := readAudio(t, path)
+
+	if err := RetagFile(path, retagTestMeta("A Much Longer Corrected Song Name")); err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+	if err := validateOutputFile(path); err != nil {
+		t.Errorf("Retagged file failed validation: %v", err)
+	}
+
+	audioAfter, chunksAfter := readAudio(t, path)
+	if !bytes.Equal(audioBefore, audioAfter) {
+		t.Error("Expected audio data to be bit-identical after retagging")
+	}
+	if !bytes.Equal(chunksBefore, chunksAfter) {
+		t.Errorf("Expected chunk offsets to follow the moved audio, got chunks starting %v, want %v", chunksAfter, chunksBefore)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("Old Name")) || !bytes.Contains(raw, []byte("A Much Longer Corrected Song Name")) {
+		t.Error("Expected the title tag to be rewritten")
+	}
+
+	songID, err := ReadSongID(path)
+	if err != nil || songID != "1440857781" {
+		t.Errorf("Expected song ID 1440857781 to be kept, got %q (%v)", songID, err)
+	}
+}
+
+func TestRetagFile_KeepsArtwork(t *testing.T) {
+	path := writeBotFixture(t, retagTestMeta("Old Name"))
+
+	// Artwork is added after writing, the same way downloads do when it
+	// arrives late
+	cover := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, bytes.Repeat([]byte("cover"), 20)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(cover)
+	}))
+	defer server.Close()
+
+	meta := retagTestMeta("Old Name")
+	meta.Attributes.Artwork = Artwork{URL: server.URL + "/{w}x{h}.jpg", Width: 600, Height: 600}
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	artwork, err := sd.prepareArtwork(context.Background(), meta, nil)
+	if err != nil {
+		t.Fatalf("Failed to fetch artwork: %v", err)
+	}
+	if err := sd.addArtwork(path, meta, nil, artwork); err != nil {
+		t.Fatalf("Failed to add artwork: %v", err)
+	}
+	if songID, err := ReadSongID(path); err != nil || songID != meta.ID {
+		t.Fatalf("Expected artwork to keep the song ID tag, got %q (%v)", songID, err)
+	}
+	audioBefore, chunksBefore := readAudio(t, path)
+
+	if err := RetagFile(path, retagTestMeta("New Name")); err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+
+	audioAfter, chunksAfter := readAudio(t, path)
+	if !bytes.Equal(audioBefore, audioAfter) || !bytes.Equal(chunksBefore, chunksAfter) {
+		t.Error("Expected audio data and chunk offsets to survive retagging")
+	}
+	raw, _ := os.ReadFile(path)
+	if !bytes.Contains(raw, cover) {
+		t.Error("Expected the artwork to be kept")
+	}
+	if !bytes.Contains(raw, []byte("New Name")) {
+		t.Error("Expected the title tag to be rewritten")
+	}
+}
+
+func TestRetagFile_KeepsPermissions(t *testing.T) {
+	path := writeBotFixture(t, retagTestMeta("Old Name"))
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+
+	if err := RetagFile(path, retagTestMeta("New Name")); err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat: %v", err)
+	}
+	if mode := stat.Mode().Perm(); mode != 0640 {
+		t.Errorf("Expected mode 0640 to be kept, got %o", mode)
+	}
+}
+
+func TestRetagFile_Sidecar(t *testing.T) {
+	// No cnID is written for an unparsable song ID
+	untagged := retagTestMeta("Old Name")
+	untagged.ID = ""
+	path := writeBotFixture(t, untagged)
+
+	if err := RetagFile(path, retagTestMeta("New Name")); !errors.Is(err, ErrNotRetaggable) {
+		t.Fatalf("Expected ErrNotRetaggable without a sidecar, got %v", err)
+	}
+
+	sidecar := filepath.Join(filepath.Dir(path), "song.json")
+	if err := os.WriteFile(sidecar, []byte(`{"id": "1440857781", "type": "songs"}`), 0644); err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+	if err := RetagFile(path, retagTestMeta("New Name")); err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+
+	songID, err := ReadSongID(path)
+	if err != nil || songID != "1440857781" {
+		t.Errorf("Expected the cnID tag to be written, got %q (%v)", songID, err)
+	}
+}
+
+func TestRetagFile_Unchanged(t *testing.T) {
+	meta := retagTestMeta("Same Name")
+	path := writeBotFixture(t, meta)
+	before, _ := os.ReadFile(path)
+
+	changed, err := rewriteTags(path, meta, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to retag: %v", err)
+	}
+	if changed {
+		t.Error("Expected retagging with the same metadata to report no change")
+	}
+
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) {
+		t.Error("Expected an unchanged file to be left alone")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".retag-*")); len(matches) != 0 {
+		t.Errorf("Expected temporary files to be removed, found %v", matches)
+	}
+}
+
+func TestRetagFile_NotBotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other.m4a")
+	if err := os.WriteFile(path, buildFragmentedFixture(t, 1, []uint32{1}), 0644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	before, _ := os.ReadFile(path)
+
+	if err := RetagFile(path, retagTestMeta("Name")); !errors.Is(err, ErrNotRetaggable) {
+		t.Errorf("Expected ErrNotRetaggable, got %v", err)
+	}
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) {
+		t.Error("Expected a skipped file to be left alone")
+	}
+}
+
+func TestRetagDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, raw []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), raw, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	stale, _ := os.ReadFile(writeBotFixture(t, retagTestMeta("Old Name")))
+	current, _ := os.ReadFile(writeBotFixture(t, retagTestMeta("New Name")))
+	write("stale.m4a", stale)
+	write("current.m4a", current)
+	write("other.m4a", buildFragmentedFixture(t, 1, []uint32{1}))
+	write("notes.txt", []byte("not audio"))
+
+	var fetched []string
+	summary, err := RetagDir(dir, func(songID string) (*AutoSong, error) {
+		fetched = append(fetched, songID)
+		return retagTestMeta("New Name"), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to retag dir: %v", err)
+	}
+
+	if len(summary.Changed) != 1 || filepath.Base(summary.Changed[0]) != "stale.m4a" {
+		t.Errorf("Expected only stale.m4a to change, got %v", summary.Changed)
+	}
+	if summary.Unchanged != 1 {
+		t.Errorf("Expected 1 unchanged file, got %d", summary.Unchanged)
+	}
+	if len(summary.Skipped) != 1 || filepath.Base(summary.Skipped[0]) != "other.m4a" {
+		t.Errorf("Expected other.m4a to be skipped, got %v", summary.Skipped)
+	}
+	if len(summary.Failed) != 0 {
+		t.Errorf("Expected no failures, got %v", summary.Failed)
+	}
+	if len(fetched) != 2 {
+		t.Errorf("Expected metadata to be fetched for the 2 bot files, got %v", fetched)
+	}
+}
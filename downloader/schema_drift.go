@@ -0,0 +1,160 @@
+package downloader
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// schemaAnomalyWindow is how many anomalous responses are kept for diagnosis
+const schemaAnomalyWindow = 10
+
+// criticalPath is a part of a catalog song that decodes to a zero value
+// without any error when Apple moves or renames it
+type criticalPath struct {
+	path   string
+	isZero func(song *AutoSong) bool
+}
+
+// criticalSongPaths are checked in every song response. A missing
+// extendedAssetUrls would make every song look like it has no ALAC
+var criticalSongPaths = []criticalPath{
+	{"attributes.extendedAssetUrls", func(song *AutoSong) bool { return len(song.Attributes.ExtendedAssetUrls) == 0 }},
+	{"attributes.playParams", func(song *AutoSong) bool { return song.Attributes.PlayParams == PlayParams{} }},
+	{"relationships.albums.data", func(song *AutoSong) bool { return len(song.Relationships.Albums.Data) == 0 }},
+}
+
+// SchemaAnomaly is a catalog response with critical paths missing
+type SchemaAnomaly struct {
+	Time         time.Time
+	Endpoint     string   // Request path, e.g. /v1/catalog/us/songs/1
+	Missing      []string // Critical paths absent from the payload
+	TopLevelKeys []string // Keys of the response object, for a hint of what changed
+	Raw          []byte   // The response as received
+}
+
+// SchemaMonitor watches catalog responses for schema drift: critical paths
+// missing from the raw JSON while the typed fields they decode into are zero
+type SchemaMonitor struct {
+	drifts atomic.Int64
+
+	mu        sync.Mutex
+	anomalies []SchemaAnomaly
+	next      int
+}
+
+// NewSchemaMonitor creates a SchemaMonitor with nothing recorded
+func NewSchemaMonitor() *SchemaMonitor {
+	return &SchemaMonitor{}
+}
+
+// CheckSongs checks a decoded song response against its raw bytes and
+// records it when critical paths are missing. It reports whether drift was
+// found
+func (m *SchemaMonitor) CheckSongs(endpoint string, raw []byte, songs []AutoSong) bool {
+	var payload struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return false
+	}
+
+	var missing []string
+	for i := range songs {
+		if i >= len(payload.Data) {
+			break
+		}
+		for _, critical := range criticalSongPaths {
+			if !hasPath(payload.Data[i], critical.path) && critical.isZero(&songs[i]) && !slices.Contains(missing, critical.path) {
+				missing = append(missing, critical.path)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return false
+	}
+
+	anomaly := SchemaAnomaly{
+		Time:         time.Now(),
+		Endpoint:     endpoint,
+		Missing:      missing,
+		TopLevelKeys: topLevelKeys(raw),
+		Raw:          append([]byte(nil), raw...),
+	}
+	m.record(anomaly)
+
+	logger.Warn("Catalog schema drift: Apple may have moved or renamed fields missing from the response; affected values decode as empty",
+		logging.String("Endpoint", endpoint), logging.String("Missing", strings.Join(missing, ", ")),
+		logging.String("TopLevelKeys", strings.Join(anomaly.TopLevelKeys, ", ")))
+	return true
+}
+
+// record counts an anomaly and keeps it, replacing the oldest once the
+// window is full
+func (m *SchemaMonitor) record(anomaly SchemaAnomaly) {
+	m.drifts.Add(1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.anomalies) < schemaAnomalyWindow {
+		m.anomalies = append(m.anomalies, anomaly)
+		return
+	}
+	m.anomalies[m.next] = anomaly
+	m.next = (m.next + 1) % schemaAnomalyWindow
+}
+
+// DriftCount returns how many responses had schema drift since the start
+func (m *SchemaMonitor) DriftCount() int64 {
+	return m.drifts.Load()
+}
+
+// Anomalies returns the kept anomalous responses, newest first
+func (m *SchemaMonitor) Anomalies() []SchemaAnomaly {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	anomalies := make([]SchemaAnomaly, 0, len(m.anomalies))
+	for i := range m.anomalies {
+		// The newest is just before next once the window has wrapped
+		index := (m.next - 1 - i + 2*len(m.anomalies)) % len(m.anomalies)
+		anomalies = append(anomalies, m.anomalies[index])
+	}
+	return anomalies
+}
+
+// hasPath reports whether a dotted path is present and not null in a JSON
+// object
+func hasPath(object map[string]any, path string) bool {
+	var value any = object
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		if value, ok = fields[key]; !ok || value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// topLevelKeys returns the sorted keys of a JSON object, nil if raw is not one
+func topLevelKeys(raw []byte) []string {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// driftFixtures are song responses as the catalog sends them today and with
+// critical fields moved or renamed
+var driftFixtures = map[string]string{
+	"normal": `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","playParams":{"id":"1","kind":"song"},"extendedAssetUrls":{"enhancedHls":"https://example.com/a.m3u8"}},
+		"relationships":{"albums":{"data":[{"id":"2","type":"albums"}]}}}]}`,
+	"no ALAC": `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","playParams":{"id":"1","kind":"song"},"extendedAssetUrls":{}},
+		"relationships":{"albums":{"data":[{"id":"2","type":"albums"}]}}}]}`,
+	"moved asset URLs": `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","playParams":{"id":"1","kind":"song"},"assets":{"extendedAssetUrls":{"enhancedHls":"https://example.com/a.m3u8"}}},
+		"relationships":{"albums":{"data":[{"id":"2","type":"albums"}]}}}]}`,
+	"renamed fields": `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","playParameters":{"id":"1","kind":"song"},"extendedAssetUrls":{"enhancedHls":"https://example.com/a.m3u8"}},
+		"relationships":{"album":{"data":[{"id":"2","type":"albums"}]}}}],"meta":{}}`,
+}
+
+func checkFixture(t *testing.T, monitor *SchemaMonitor, name string) bool {
+	t.Helper()
+	raw := []byte(driftFixtures[name])
+	var response SongResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		t.Fatalf("Fixture %q does not decode: %v", name, err)
+	}
+	return monitor.CheckSongs("/v1/catalog/us/songs/1", raw, response.Data)
+}
+
+func TestSchemaMonitor_CheckSongs(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		wantMissing []string
+	}{
+		{fixture: "normal"},
+		{fixture: "no ALAC"}, // Present but empty is a song without ALAC, not drift
+		{fixture: "moved asset URLs", wantMissing: []string{"attributes.extendedAssetUrls"}},
+		{fixture: "renamed fields", wantMissing: []string{"attributes.playParams", "relationships.albums.data"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			monitor := NewSchemaMonitor()
+			drift := checkFixture(t, monitor, tt.fixture)
+
+			if drift != (tt.wantMissing != nil) {
+				t.Fatalf("CheckSongs() = %v, want drift %v", drift, tt.wantMissing != nil)
+			}
+			anomalies := monitor.Anomalies()
+			if tt.wantMissing == nil {
+				if monitor.DriftCount() != 0 || len(anomalies) != 0 {
+					t.Errorf("Expected a quiet monitor, got %d drifts and %d anomalies", monitor.DriftCount(), len(anomalies))
+				}
+				return
+			}
+
+			if monitor.DriftCount() != 1 || len(anomalies) != 1 {
+				t.Fatalf("Expected 1 drift and anomaly, got %d and %d", monitor.DriftCount(), len(anomalies))
+			}
+			if !slices.Equal(anomalies[0].Missing, tt.wantMissing) {
+				t.Errorf("Missing = %v, want %v", anomalies[0].Missing, tt.wantMissing)
+			}
+			if string(anomalies[0].Raw) != driftFixtures[tt.fixture] {
+				t.Error("Expected the raw response to be kept")
+			}
+		})
+	}
+
+	monitor := NewSchemaMonitor()
+	checkFixture(t, monitor, "renamed fields")
+	if keys := monitor.Anomalies()[0].TopLevelKeys; !slices.Equal(keys, []string{"data", "meta"}) {
+		t.Errorf("TopLevelKeys = %v, want [data meta]", keys)
+	}
+}
+
+func TestSchemaMonitor_KeepsRecentAnomalies(t *testing.T) {
+	monitor := NewSchemaMonitor()
+	for i := 0; i < schemaAnomalyWindow+3; i++ {
+		monitor.record(SchemaAnomaly{Endpoint: fmt.Sprint(i)})
+	}
+
+	if got := monitor.DriftCount(); got != schemaAnomalyWindow+3 {
+		t.Errorf("DriftCount() = %d, want %d", got, schemaAnomalyWindow+3)
+	}
+	anomalies := monitor.Anomalies()
+	if len(anomalies) != schemaAnomalyWindow {
+		t.Fatalf("Expected %d anomalies kept, got %d", schemaAnomalyWindow, len(anomalies))
+	}
+	if first, last := anomalies[0].Endpoint, anomalies[len(anomalies)-1].Endpoint; first != "12" || last != "3" {
+		t.Errorf("Expected anomalies 12 down to 3, got %s down to %s", first, last)
+	}
+}
+
+func TestGetSongMeta_SchemaDrift(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/us/songs/1":
+			fmt.Fprint(w, driftFixtures["normal"])
+		case "/v1/catalog/gb/songs/1":
+			fmt.Fprint(w, driftFixtures["moved asset URLs"])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Downloads of one manager share the monitor
+	manager := NewManager(0)
+	for _, storefront := range []string{"us", "gb"} {
+		sd := manager.NewDownloader().(*SongDownloaderImpl)
+		sd.apple.catalogURL = server.URL
+		if _, err := sd.GetSongMeta(context.Background(), &URLMeta{Storefront: storefront, URLType: "songs", ID: "1"}, testToken); err != nil {
+			t.Fatalf("GetSongMeta(%s) error = %v", storefront, err)
+		}
+	}
+
+	monitor := manager.SchemaMonitor()
+	if monitor.DriftCount() != 1 {
+		t.Fatalf("DriftCount() = %d, want 1", monitor.DriftCount())
+	}
+	if endpoint := monitor.Anomalies()[0].Endpoint; endpoint != "/v1/catalog/gb/songs/1" {
+		t.Errorf("Endpoint = %q, want the drifted gb response", endpoint)
+	}
+}
@@ -0,0 +1,38 @@
+package downloader
+
+import "github.com/gotd/td/tg"
+
+// SentMessages returns the messages created by a send or forward. Messages
+// sent to supergroups and channels come as channel updates
+func SentMessages(updates tg.UpdatesClass) []*tg.Message {
+	u, ok := updates.(*tg.Updates)
+	if !ok {
+		return nil
+	}
+	var messages []*tg.Message
+	for _, update := range u.Updates {
+		var message tg.MessageClass
+		switch update := update.(type) {
+		case *tg.UpdateNewMessage:
+			message = update.Message
+		case *tg.UpdateNewChannelMessage:
+			message = update.Message
+		}
+		if msg, ok := message.(*tg.Message); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// SentMessageID returns the ID of the message created by a send, 0 when the
+// updates hold none
+func SentMessageID(updates tg.UpdatesClass) int {
+	if sent, ok := updates.(*tg.UpdateShortSentMessage); ok {
+		return sent.ID
+	}
+	if messages := SentMessages(updates); len(messages) > 0 {
+		return messages[0].ID
+	}
+	return 0
+}
@@ -4,33 +4,37 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/Sorrow446/go-mp4tag"
 	"github.com/abema/go-mp4"
 	"github.com/grafov/m3u8"
-	"github.com/schollz/progressbar/v3"
+
+	"go-alac-bot/downloader/fmp4"
+	"go-alac-bot/logging"
 )
 
 const (
 	defaultId   = "0"
 	prefetchKey = "skd://itunes.apple.com/P000000000/s1/e1"
+
+	// DownloadsDir is where downloaded songs are written unless another
+	// directory is set on the Manager
+	DownloadsDir = "downloads"
+
+	// defaultCatalogURL is the Apple Music API serving catalog metadata
+	defaultCatalogURL = "https://amp-api.music.apple.com"
 )
 
 func init() {
@@ -41,21 +45,127 @@ func init() {
 type SongDownloaderImpl struct {
 	deviceUrl      string
 	decryptionUrl  string
-	forbiddenNames *regexp.Regexp
+	validateOutput bool
+	embedLyrics    bool
+	downloadDir    string
 
 	// State management
 	mu         sync.RWMutex
 	status     DownloadStatus
 	cancelFunc context.CancelFunc
 	isActive   bool
+
+	// Free space of the downloads directory (nil = not checked) and the
+	// minimum kept free by removing old downloads (0 = none removed)
+	diskUsage   DiskUsage
+	minFreeDisk uint64
+
+	// Memory admission for the in-memory part of a download
+	memoryBudget      *MemoryBudget
+	memoryReservation *MemoryReservation
+
+	// Finished download the current job holds, handed on to its result
+	heldFile string
+
+	// HTTP requests to Apple Music, with the cached tokens
+	apple *AppleMusicClient
+
+	// Token acquisition
+	tokenPageURL  string
+	fallbackToken string
+	tokenHealth   *TokenHealth
+	staticToken   string
+
+	// Album metadata and artwork shared by tracks of the same album
+	albumContexts *albumContexts
+
+	// Files larger than this are split into parts (0 = never split)
+	splitMaxBytes int64
+
+	// Resumptions of a broken media download, the first after
+	// mediaRetryDelay and each next one after twice the previous delay
+	mediaRetries    int
+	mediaRetryDelay time.Duration
+
+	// Ranges of the media downloaded at once (1 = one stream)
+	mediaChunks int
+
+	// Timings of calls to external dependencies
+	latencies *LatencyTracker
+
+	// Rate shared with the other downloads of the manager (nil = unlimited)
+	bandwidth *BandwidthLimiter
+
+	// Connections to the decryption service, kept open across songs, and the
+	// slots limiting the songs decrypted at once (nil = unlimited)
+	decrypter    *DecryptClient
+	decryptSlots *DecryptSlots
+
+	// Faults injected for resilience testing, shared by the manager's
+	// downloads (nil = none)
+	faults *FaultPlan
+
+	// Fields reported in DownloadResult.MissingFields when absent
+	expectedMetadata []MetadataField
+
+	// Highest ALAC quality selected (zero fields = no limit)
+	qualityCap MediaInfo
+
+	// Per-request settings, such as the language of the tags
+	options DownloadOptions
+
+	// Download outcomes and phase durations, timed from phaseStart and
+	// added up per phase in phaseDurations for DownloadResult
+	metrics        Metrics
+	phaseStart     time.Time
+	phaseDurations map[Phase]time.Duration
+
+	// Longest the download or decryption may go without progress before it
+	// is retried once (0 = never), watched by watchdog while it runs
+	stallTimeout time.Duration
+	watchdog     *stallWatchdog
+
+	// Longest each step of a device service request may take (0 = forever)
+	deviceTimeout time.Duration
+
+	// Largest side and size of the embedded artwork (0 = original)
+	artworkMaxDimension int
+	artworkMaxBytes     int64
 }
 
 // NewSongDownloaderImpl creates a new instance of SongDownloaderImpl
 func NewSongDownloaderImpl() SongDownloader {
+	return NewSongDownloaderWithClient(NewAppleMusicClient(nil))
+}
+
+// NewSongDownloaderWithClient creates a SongDownloaderImpl sending its HTTP
+// requests with apple
+func NewSongDownloaderWithClient(apple *AppleMusicClient) SongDownloader {
 	return &SongDownloaderImpl{
-		deviceUrl:      getEnv("M3U8_URL", "127.0.0.1:20020"),
-		decryptionUrl:  getEnv("DEC_URL", "127.0.0.1:10020"),
-		forbiddenNames: regexp.MustCompile(`[\\/<>:"|?*]`),
+		deviceUrl:           getEnv("M3U8_URL", "127.0.0.1:20020"),
+		decryptionUrl:       getEnv("DEC_URL", "127.0.0.1:10020"),
+		downloadDir:         DownloadsDir,
+		diskUsage:           diskFree,
+		validateOutput:      debugBuild || getEnv("VALIDATE_OUTPUT", "") == "true",
+		embedLyrics:         getEnv("EMBED_LYRICS", "true") != "false",
+		tokenPageURL:        defaultTokenPageURL,
+		fallbackToken:       getEnv("APPLE_DEV_TOKEN", ""),
+		tokenHealth:         NewTokenHealth(),
+		apple:               apple,
+		staticToken:         getEnv("APPLE_STATIC_TOKEN", ""),
+		albumContexts:       newAlbumContexts(),
+		latencies:           apple.latencies,
+		decrypter:           NewDecryptClient(DefaultDecryptTimeout),
+		expectedMetadata:    MetadataFields,
+		mediaRetries:        DefaultMediaRetries,
+		stallTimeout:        DefaultStallTimeout,
+		deviceTimeout:       DefaultDeviceTimeout,
+		artworkMaxDimension: DefaultArtworkMaxDimension,
+		artworkMaxBytes:     DefaultArtworkMaxBytes,
+		mediaRetryDelay:     defaultMediaRetryDelay,
+		mediaChunks:         DefaultMediaChunks,
+		qualityCap:          qualityCapFromEnv(),
+		metrics:             NoopMetrics{},
 		status: DownloadStatus{
 			Phase:    PhaseValidating,
 			IsActive: false,
@@ -63,6 +173,29 @@ func NewSongDownloaderImpl() SongDownloader {
 	}
 }
 
+// EnvSetting is a setting the downloader reads from the environment itself
+type EnvSetting struct {
+	Name   string
+	Value  string // Effective value after defaults
+	Secret bool
+}
+
+// EnvSettings returns the effective values of the environment variables read
+// by new downloaders
+func EnvSettings() []EnvSetting {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	return []EnvSetting{
+		{Name: "M3U8_URL", Value: sd.deviceUrl},
+		{Name: "DEC_URL", Value: sd.decryptionUrl},
+		{Name: "APPLE_DEV_TOKEN", Value: sd.fallbackToken, Secret: true},
+		{Name: "APPLE_STATIC_TOKEN", Value: sd.staticToken, Secret: true},
+		{Name: "VALIDATE_OUTPUT", Value: strconv.FormatBool(sd.validateOutput)},
+		{Name: "EMBED_LYRICS", Value: strconv.FormatBool(sd.embedLyrics)},
+		{Name: "ALAC_MAX_QUALITY", Value: getEnv("ALAC_MAX_QUALITY", "")},
+		{Name: "FAULTS", Value: getEnv("FAULTS", "")},
+	}
+}
+
 // Helper function to get environment variables with fallback
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
@@ -73,6 +206,13 @@ func getEnv(key, fallback string) string {
 
 // Download implements the SongDownloader interface
 func (sd *SongDownloaderImpl) Download(ctx context.Context, url string, callbacks ProgressCallbacks) (*DownloadResult, error) {
+	result, err := sd.download(ctx, url, callbacks)
+	sd.metrics.DownloadFinished(err)
+	return result, err
+}
+
+// download runs a download for Download
+func (sd *SongDownloaderImpl) download(ctx context.Context, url string, callbacks ProgressCallbacks) (*DownloadResult, error) {
 	sd.mu.Lock()
 	if sd.isActive {
 		sd.mu.Unlock()
@@ -86,9 +226,14 @@ func (sd *SongDownloaderImpl) Download(ctx context.Context, url string, callback
 	sd.status.StartTime = time.Now()
 	sd.status.IsActive = true
 	sd.status.Phase = PhaseValidating
+	sd.phaseStart = sd.status.StartTime
+	sd.phaseDurations = make(map[Phase]time.Duration)
 	sd.mu.Unlock()
 
 	defer func() {
+		sd.releaseMemory()
+		sd.releaseHold()
+
 		sd.mu.Lock()
 		sd.isActive = false
 		sd.status.IsActive = false
@@ -118,145 +263,233 @@ func (sd *SongDownloaderImpl) Download(ctx context.Context, url string, callback
 	}
 
 	// Get authentication token
-	token, err := sd.GetToken()
+	token, err := sd.GetToken(downloadCtx)
 	if err != nil {
-		return nil, sd.handleError(ErrorNetworkFailure, "failed to get authentication token", err, callbacks)
+		return nil, sd.handleError(ErrorTokenUnavailable, "failed to get authentication token", err, callbacks)
 	}
 
 	// Get song metadata
-	meta, err := sd.GetSongMeta(urlMeta, token)
+	meta, token, err := sd.getLocalizedSongMeta(downloadCtx, urlMeta, token, callbacks)
 	if err != nil {
 		return nil, sd.handleError(ErrorNetworkFailure, "failed to get song metadata", err, callbacks)
 	}
+	if callbacks.OnMetadata != nil {
+		callbacks.OnMetadata(newSongMetadata(meta, sd.options.MetadataLanguage))
+	}
 
-	if meta.Attributes.ExtendedAssetUrls["enhancedHls"] == "" {
+	// Songs listed ahead of their release have no stream yet
+	if IsPrerelease(meta, time.Now()) {
+		return nil, sd.reportError(newNotReleasedError(meta), callbacks)
+	}
+
+	// With the AAC fallback the device is asked for a master playlist even
+	// when the catalog lists none
+	if meta.Attributes.ExtendedAssetUrls["enhancedHls"] == "" && !sd.options.AACFallback {
 		return nil, sd.handleError(ErrorALACNotAvailable, "ALAC format not available for this song", nil, callbacks)
 	}
 
 	// Get enhanced HLS URL
 	enhancedHls, err := sd.GetEnhanceHls(meta.ID)
-	if err != nil {
+	switch {
+	case IsDeviceTimeout(err):
+		return nil, sd.handleError(ErrorTimeout, "device service timed out", err, callbacks)
+	case errors.Is(err, ErrDeviceIDLength):
+		return nil, sd.handleError(ErrorInvalidURL, "song ID cannot be sent to the device service", err, callbacks)
+	case err != nil:
 		return nil, sd.handleError(ErrorNetworkFailure, "failed to get enhanced HLS URL", err, callbacks)
 	}
 
 	if strings.HasSuffix(enhancedHls, "m3u8") {
 		meta.Attributes.ExtendedAssetUrls["enhancedHls"] = enhancedHls
 	}
+	if meta.Attributes.ExtendedAssetUrls["enhancedHls"] == "" {
+		return nil, sd.handleError(ErrorALACNotAvailable, "no stream available for this song", nil, callbacks)
+	}
 
 	// Generate song filename
-	songName := fmt.Sprintf("%s - %s", meta.Attributes.Name, meta.Attributes.ArtistName)
-	songName = fmt.Sprintf("%s.m4a", sd.forbiddenNames.ReplaceAllString(songName, "_"))
+	songName := BuildFileName("", meta.Attributes.Name, meta.Attributes.ArtistName)
 
 	// Update status with song name
 	sd.mu.Lock()
 	sd.status.SongName = songName
 	sd.mu.Unlock()
 
-	// Check if file already exists
-	filePath := filepath.Join("downloads", songName)
-	if _, err := os.Stat(filePath); err == nil {
-		// File exists, create result and return
-		fileInfo, _ := os.Stat(filePath)
-		result := &DownloadResult{
-			FilePath: filePath,
-			SongMeta: &SongMetadata{
-				Title:          meta.Attributes.Name,
-				Artist:         meta.Attributes.ArtistName,
-				Album:          meta.Attributes.AlbumName,
-				AppleMusicID:   meta.ID,
-				ArtworkURL:     meta.Attributes.Artwork.URL,
-				Duration:       time.Duration(meta.Attributes.DurationInMillis) * time.Millisecond,
-				DurationMillis: meta.Attributes.DurationInMillis,
-			},
-			FileSize: fileInfo.Size(),
-			Format:   "m4a",
-			Duration: time.Since(sd.status.StartTime),
-		}
-
-		sd.updatePhase(PhaseComplete, callbacks)
-		if callbacks.OnComplete != nil {
-			callbacks.OnComplete(result)
-		}
-
-		return result, nil
+	// Check if the song was downloaded before. Unfinished downloads are kept
+	// elsewhere
+	filePath := filepath.Join(sd.downloadDir, songFileName(meta))
+	sd.holdFile(filePath)
+	if fileInfo, ok := existingDownload(filePath); ok {
+		return sd.completeFromCache(downloadCtx, filePath, fileInfo, meta, "m4a", callbacks), nil
 	}
 
 	// Extract media information
-	trackUrl, keys, err := sd.ExtractMedia(meta.Attributes.ExtendedAssetUrls["enhancedHls"])
+	trackUrl, keys, media, err := sd.ExtractMedia(downloadCtx, meta.Attributes.ExtendedAssetUrls["enhancedHls"])
 	if err != nil {
 		return nil, sd.handleError(ErrorNetworkFailure, "failed to extract media information", err, callbacks)
 	}
 
+	// Songs without ALAC come as AAC when the request allows it, saved under
+	// a name of their own so they never stand in for the ALAC file
+	format := "m4a"
+	if media.Codec == CodecAAC {
+		format = FormatAAC
+		sd.warn("ALAC is not available for this song, downloading AAC instead", callbacks)
+		filePath = aacFilePath(filePath)
+		sd.holdFile(filePath)
+		if fileInfo, ok := existingDownload(filePath); ok {
+			return sd.completeFromCache(downloadCtx, filePath, fileInfo, meta, format, callbacks), nil
+		}
+	}
+
 	// Check for cancellation before starting download
 	if err := downloadCtx.Err(); err != nil {
 		return nil, sd.handleError(ErrorCancelled, "download cancelled", err, callbacks)
 	}
 
-	// Phase 2: Download song data
+	// Fetch the artwork while the audio is downloaded, to embed it as the
+	// file is written
+	artwork := sd.startArtwork(downloadCtx, urlMeta.Storefront, meta, token)
+
+	// Phase 2: Download song data, starting over once when it stalls
 	sd.updatePhase(PhaseDownloading, callbacks)
 
-	info, err := sd.extractSong(downloadCtx, trackUrl, callbacks)
+	var info *SongInfo
+	err = sd.runWatched(downloadCtx, PhaseDownloading, callbacks, func(ctx context.Context) error {
+		// A new attempt reserves its memory again
+		sd.releaseMemory()
+		var err error
+		info, err = sd.extractSong(ctx, trackUrl, callbacks)
+		return err
+	})
+	var stallErr *DownloadError
+	if errors.As(err, &stallErr) {
+		return nil, sd.reportError(stallErr, callbacks)
+	}
 	if err != nil {
 		return nil, sd.handleError(ErrorNetworkFailure, "failed to download song data", err, callbacks)
 	}
 
-	// Validate samples and keys
-	samplesOk := true
-	for _, sample := range info.samples {
-		if int(sample.descIndex) >= len(keys) {
-			samplesOk = false
-			break
-		}
-	}
-
-	if !samplesOk {
-		return nil, sd.handleError(ErrorDecryptionFailure, "decryption size mismatch", nil, callbacks)
-	}
+	// Descriptions without a key of their own are decrypted with the
+	// prefetch key rather than failing the download
+	sd.warnMissingKeys(info, keys, callbacks)
 
 	// Check for cancellation before decryption
 	if err := downloadCtx.Err(); err != nil {
 		return nil, sd.handleError(ErrorCancelled, "download cancelled", err, callbacks)
 	}
 
-	// Phase 3: Decrypt song
+	// Write into a temp dir of this download, moved into place when finished
+	tempDir, err := newTempDir(sd.downloadDir)
+	if err != nil {
+		return nil, sd.handleError(ErrorFileSystemError, "failed to create downloads directory", err, callbacks)
+	}
+	defer os.RemoveAll(tempDir)
+	tempPath := filepath.Join(tempDir, songName)
+
+	// Phase 3: Decrypt song, streaming the samples to disk, once no other
+	// download holds the decryption service
+	releaseSlot, err := sd.acquireDecryptSlot(downloadCtx, callbacks)
+	if err != nil {
+		return nil, sd.handleError(ErrorCancelled, "download cancelled", err, callbacks)
+	}
+	defer releaseSlot()
 	sd.updatePhase(PhaseDecrypting, callbacks)
 
-	decrypted, err := sd.decryptSong(downloadCtx, info, keys, meta, callbacks)
+	decrypted, err := os.Create(filepath.Join(tempDir, "decrypted.bin"))
+	if err != nil {
+		return nil, sd.handleError(ErrorFileSystemError, "failed to create decryption file", err, callbacks)
+	}
+	defer decrypted.Close()
+
+	err = sd.runWatched(downloadCtx, PhaseDecrypting, callbacks, func(ctx context.Context) error {
+		// A new attempt starts over with an empty file
+		if _, err := decrypted.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := decrypted.Truncate(0); err != nil {
+			return err
+		}
+		return sd.decryptSong(ctx, info, keys, meta, decrypted, callbacks)
+	})
+	// The next download may decrypt while this one is written and uploaded
+	releaseSlot()
+	var decryptErr *DownloadError
+	if errors.As(err, &decryptErr) {
+		return nil, sd.reportError(decryptErr, callbacks)
+	}
 	if err != nil {
 		return nil, sd.handleError(ErrorDecryptionFailure, "failed to decrypt song", err, callbacks)
 	}
+	if _, err := decrypted.Seek(0, io.SeekStart); err != nil {
+		return nil, sd.handleError(ErrorFileSystemError, "failed to read decrypted song", err, callbacks)
+	}
 
 	// Check for cancellation before writing
 	if err := downloadCtx.Err(); err != nil {
 		return nil, sd.handleError(ErrorCancelled, "download cancelled", err, callbacks)
 	}
 
+	// Fetch lyrics to embed. Songs without lyrics are still delivered
+	if sd.embedLyrics && (meta.Attributes.HasTimeSyncedLyrics || meta.Attributes.HasLyrics) {
+		lyrics, err := sd.fetchLyrics(downloadCtx, urlMeta.Storefront, meta.ID, token)
+		if err != nil {
+			logger.Warn("Failed to fetch lyrics", logging.String("Song", meta.ID), logging.Err(err))
+		}
+		info.lyrics = lyrics
+	}
+
 	// Phase 4: Write file
 	sd.updatePhase(PhaseWriting, callbacks)
 
-	// Create downloads directory
-	err = os.MkdirAll("downloads", os.ModePerm)
-	if err != nil {
-		return nil, sd.handleError(ErrorFileSystemError, "failed to create downloads directory", err, callbacks)
-	}
-
 	// Create and write the file
-	file, err := os.Create(filePath)
+	if err := sd.faults.check(FaultWrite); err != nil {
+		return nil, sd.handleError(ErrorFileSystemError, "failed to create output file", err, callbacks)
+	}
+	file, err := os.Create(tempPath)
 	if err != nil {
 		return nil, sd.handleError(ErrorFileSystemError, "failed to create output file", err, callbacks)
 	}
-	defer file.Close()
 
-	err = sd.WriteM4a(mp4.NewWriter(file), info, meta, decrypted)
+	// The artwork is written with the tags when it arrived in time, and
+	// added by a second pass over the file otherwise
+	embedded := artwork.ready()
+	var album *AlbumContext
+	var cover []byte
+	if embedded {
+		album, cover = artwork.album, artwork.cover.data
+	}
+	tagWarnings, err := sd.writeM4a(mp4.NewWriter(file), info, meta, album, cover, decrypted)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return nil, sd.handleError(ErrorFileSystemError, "failed to write M4A file", err, callbacks)
 	}
+	for _, warning := range tagWarnings {
+		logger.Warn("Skipped tag", logging.String("Song", meta.ID), logging.String("Warning", warning))
+	}
 
-	// Add artwork
-	err = sd.addArtwork(filePath, meta)
-	if err != nil {
+	if !embedded {
+		artwork.wait()
+		if artwork.err == nil {
+			artwork.err = sd.addArtwork(tempPath, meta, artwork.album, artwork.cover)
+		}
+	}
+	if artwork.err != nil {
 		// Don't fail the entire download for artwork issues, just log
-		fmt.Printf("Warning: failed to add artwork: %v\n", err)
+		logger.Warn("Failed to add artwork", logging.Err(artwork.err))
+		artwork.cover = embeddedArtwork{}
+	}
+
+	// Check the finished file before it can be served from the downloads dir
+	if sd.validateOutput {
+		if err := validateOutputFile(tempPath); err != nil {
+			return nil, sd.handleError(ErrorFileSystemError, "output file failed validation", err, callbacks)
+		}
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return nil, sd.handleError(ErrorFileSystemError, "failed to move output file into place", err, callbacks)
 	}
 
 	// Get final file info
@@ -267,30 +500,92 @@ func (sd *SongDownloaderImpl) Download(ctx context.Context, url string, callback
 
 	// Create result
 	result := &DownloadResult{
-		FilePath: filePath,
-		SongMeta: &SongMetadata{
-			Title:          meta.Attributes.Name,
-			Artist:         meta.Attributes.ArtistName,
-			Album:          meta.Attributes.AlbumName,
-			AppleMusicID:   meta.ID,
-			ArtworkURL:     meta.Attributes.Artwork.URL,
-			Duration:       time.Duration(meta.Attributes.DurationInMillis) * time.Millisecond,
-			DurationMillis: meta.Attributes.DurationInMillis,
-		},
-		FileSize: fileInfo.Size(),
-		Format:   "m4a",
-		Duration: time.Since(sd.status.StartTime),
+		FilePath:      filePath,
+		SongMeta:      newSongMetadata(meta, sd.options.MetadataLanguage),
+		FileSize:      fileInfo.Size(),
+		Format:        format,
+		Duration:      Elapsed(sd.status.StartTime, time.Now()),
+		MissingFields: MissingMetadataFields(meta, sd.expectedMetadata),
+		TagWarnings:   tagWarnings,
+		Lyrics:        info.lyrics,
+		Media:         &media,
+		Thumbnail:     sd.thumbnail(downloadCtx, artwork.cover.data, meta.Attributes.Artwork),
+		ArtworkWidth:  artwork.cover.width,
+		ArtworkHeight: artwork.cover.height,
+	}
+	result.SongMeta.setAudioFormat(info.alacParam)
+	result.SongMeta.setAACFormat(info)
+
+	// Split files too large to upload in one piece
+	if sd.splitMaxBytes > 0 && result.FileSize > sd.splitMaxBytes {
+		parts, err := sd.splitM4A(filePath, meta, artwork.album, sd.splitMaxBytes)
+		if err != nil {
+			return nil, sd.handleError(ErrorFileSystemError, "failed to split output file", err, callbacks)
+		}
+		if sd.validateOutput {
+			for _, part := range parts {
+				if err := validateOutputFile(part.FilePath); err != nil {
+					os.RemoveAll(filepath.Dir(part.FilePath))
+					return nil, sd.handleError(ErrorFileSystemError, "output part failed validation", err, callbacks)
+				}
+			}
+		}
+		result.Parts = parts
 	}
 
 	// Phase 5: Complete
 	sd.updatePhase(PhaseComplete, callbacks)
+	result.PhaseDurations = sd.recordedPhases()
 	if callbacks.OnComplete != nil {
 		callbacks.OnComplete(result)
 	}
 
+	sd.handOverHold()
 	return result, nil
 }
 
+// newSongMetadata returns the metadata of a song reported to callers, with
+// tags in language. The audio format is added once the file is written
+func newSongMetadata(meta *AutoSong, language string) *SongMetadata {
+	return &SongMetadata{
+		Title:          meta.Attributes.Name,
+		Artist:         meta.Attributes.ArtistName,
+		Album:          albumName(meta),
+		AppleMusicID:   meta.ID,
+		ArtworkURL:     meta.Attributes.Artwork.URL,
+		Duration:       time.Duration(meta.Attributes.DurationInMillis) * time.Millisecond,
+		DurationMillis: meta.Attributes.DurationInMillis,
+		Language:       language,
+	}
+}
+
+// completeFromCache completes a download with the file of format downloaded
+// before at filePath, which the janitor then leaves alone while it is uploaded
+func (sd *SongDownloaderImpl) completeFromCache(ctx context.Context, filePath string, fileInfo os.FileInfo, meta *AutoSong, format string, callbacks ProgressCallbacks) *DownloadResult {
+	markServed(filePath)
+	result := &DownloadResult{
+		FilePath:      filePath,
+		SongMeta:      newSongMetadata(meta, sd.options.MetadataLanguage),
+		FileSize:      fileInfo.Size(),
+		Format:        format,
+		Duration:      Elapsed(sd.status.StartTime, time.Now()),
+		MissingFields: MissingMetadataFields(meta, sd.expectedMetadata),
+		Thumbnail:     sd.thumbnail(ctx, nil, meta.Attributes.Artwork),
+	}
+	if format == FormatAAC {
+		result.SongMeta.Codec = CodecAAC
+	}
+
+	sd.updatePhase(PhaseComplete, callbacks)
+	result.PhaseDurations = sd.recordedPhases()
+	if callbacks.OnComplete != nil {
+		callbacks.OnComplete(result)
+	}
+
+	sd.handOverHold()
+	return result
+}
+
 // Cancel implements the SongDownloader interface
 func (sd *SongDownloaderImpl) Cancel(ctx context.Context) error {
 	sd.mu.Lock()
@@ -307,6 +602,12 @@ func (sd *SongDownloaderImpl) Cancel(ctx context.Context) error {
 	return nil
 }
 
+// SetOptions implements the OptionsSetter interface. It must be called before
+// Download
+func (sd *SongDownloaderImpl) SetOptions(options DownloadOptions) {
+	sd.options = options
+}
+
 // GetStatus implements the SongDownloader interface
 func (sd *SongDownloaderImpl) GetStatus() DownloadStatus {
 	sd.mu.RLock()
@@ -320,27 +621,107 @@ func (sd *SongDownloaderImpl) updatePhase(newPhase Phase, callbacks ProgressCall
 	sd.mu.Lock()
 	oldPhase := sd.status.Phase
 	sd.status.Phase = newPhase
+	var elapsed time.Duration
+	timed := false
+	if oldPhase != newPhase {
+		sd.status.Progress = Progress{}
+		elapsed, timed = sd.endPhaseLocked()
+	}
 	sd.mu.Unlock()
 
+	if timed {
+		sd.recordPhase(oldPhase, elapsed)
+	}
 	if callbacks.OnPhaseChange != nil && oldPhase != newPhase {
 		callbacks.OnPhaseChange(oldPhase, newPhase)
 	}
 }
 
+// reportProgress records progress of the current phase for GetStatus and
+// notifies callbacks
+func (sd *SongDownloaderImpl) reportProgress(phase Phase, progress Progress, callbacks ProgressCallbacks) {
+	sd.mu.Lock()
+	sd.status.Progress = progress
+	sd.mu.Unlock()
+	sd.feedWatchdog()
+
+	if callbacks.OnProgress != nil {
+		callbacks.OnProgress(phase, progress)
+	}
+}
+
 // handleError creates a DownloadError and notifies callbacks
 func (sd *SongDownloaderImpl) handleError(errorType ErrorType, message string, cause error, callbacks ProgressCallbacks) error {
+	return sd.reportError(NewDownloadErrorWithCause(errorType, message, cause), callbacks)
+}
+
+// reportError records err as the end of the download and notifies callbacks
+func (sd *SongDownloaderImpl) reportError(err *DownloadError, callbacks ProgressCallbacks) error {
 	sd.mu.Lock()
+	failedPhase := sd.status.Phase
 	sd.status.Phase = PhaseError
-	sd.status.Error = cause
+	sd.status.Error = err.Cause
+	elapsed, timed := sd.endPhaseLocked()
 	sd.mu.Unlock()
 
-	err := NewDownloadErrorWithCause(errorType, message, cause)
+	if timed {
+		sd.recordPhase(failedPhase, elapsed)
+	}
 
 	if callbacks.OnError != nil {
 		callbacks.OnError(err)
 	}
 
 	return err
+}
+
+// endPhaseLocked starts timing the next phase, returning how long the one
+// ending took and false when it was not timed, outside Download (must be
+// called with sd.mu held)
+func (sd *SongDownloaderImpl) endPhaseLocked() (time.Duration, bool) {
+	now := time.Now()
+	started := sd.phaseStart
+	sd.phaseStart = now
+	return now.Sub(started), !started.IsZero()
+}
+
+// recordPhase adds the time spent in a phase to the phase durations and
+// reports it to the metrics. The final phases last until the next download
+// and are not recorded
+func (sd *SongDownloaderImpl) recordPhase(phase Phase, elapsed time.Duration) {
+	if phase == PhaseComplete || phase == PhaseError {
+		return
+	}
+
+	sd.mu.Lock()
+	if sd.phaseDurations == nil {
+		sd.phaseDurations = make(map[Phase]time.Duration)
+	}
+	sd.phaseDurations[phase] += elapsed
+	sd.mu.Unlock()
+
+	sd.metrics.PhaseFinished(phase, elapsed)
+}
+
+// recordedPhases returns a copy of the time spent in each phase of the
+// download so far
+func (sd *SongDownloaderImpl) recordedPhases() map[Phase]time.Duration {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	durations := make(map[Phase]time.Duration, len(sd.phaseDurations))
+	for phase, d := range sd.phaseDurations {
+		durations[phase] = d
+	}
+	return durations
+}
+
+// warn logs a problem the download works around and passes it to callbacks
+func (sd *SongDownloaderImpl) warn(message string, callbacks ProgressCallbacks) {
+	logger.Warn(message)
+	if callbacks.OnWarning != nil {
+		callbacks.OnWarning(message)
+	}
 } // ExtractUrlMeta extracts metadata from Apple Music URLs
 func (sd *SongDownloaderImpl) ExtractUrlMeta(inputURL string) (*URLMeta, error) {
 	// Define a regex pattern to match album, song, and playlist URLs, including full playlist ID with hyphens
@@ -378,137 +759,71 @@ func (sd *SongDownloaderImpl) ExtractUrlMeta(inputURL string) (*URLMeta, error)
 	return nil, fmt.Errorf("invalid Apple Music URL format")
 }
 
-// GetToken retrieves authentication token from Apple Music
-func (sd *SongDownloaderImpl) GetToken() (string, error) {
-	client := &http.Client{}
-
-	// Step 1: Fetch the main page to find the JS file
-	mainPageURL := "https://beta.music.apple.com"
-	req, err := http.NewRequest("GET", mainPageURL, nil)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	// Find the index-legacy JS URI using regex
-	regex := regexp.MustCompile(`/assets/index-legacy-[^/]+\.js`)
-	indexJsUri := regex.FindString(string(body))
-	if indexJsUri == "" {
-		return "", errors.New("index JS file not found")
-	}
-
-	// Step 2: Fetch the JS file to extract the token
-	jsFileURL := mainPageURL + indexJsUri
-	req, err = http.NewRequest("GET", jsFileURL, nil)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err = client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read the JS file content
-	body, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	// Extract the token using regex
-	regex = regexp.MustCompile(`eyJh[^"]+`)
-	token := regex.FindString(string(body))
-	if token == "" {
-		return "", errors.New("token not found in JS file")
-	}
-
-	return token, nil
-}
-
 // GetSongMeta retrieves song metadata from Apple Music API
-func (sd *SongDownloaderImpl) GetSongMeta(urlMeta *URLMeta, token string) (*AutoSong, error) {
-	URL := fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/%s/%s", urlMeta.Storefront, urlMeta.URLType, urlMeta.ID)
-
-	req, err := http.NewRequest("GET", URL, nil)
-	if err != nil {
+func (sd *SongDownloaderImpl) GetSongMeta(ctx context.Context, urlMeta *URLMeta, token string) (*AutoSong, error) {
+	if err := sd.faults.check(FaultCatalog); err != nil {
 		return nil, err
 	}
+	return sd.apple.GetSongMeta(ctx, urlMeta, token, sd.options.MetadataLanguage)
+}
 
-	// Set headers for the request
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
-
-	// Prepare query parameters
-	query := url.Values{}
-	query.Set("include", "albums,explicit")
-	query.Set("extend", "extendedAssetUrls")
-	query.Set("l", "")
-	req.URL.RawQuery = query.Encode()
-
-	// Make the HTTP request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+// getSongMetaRefreshingToken gets song metadata like GetSongMeta. When token
+// is rejected it is dropped from the cache and the request is retried once
+// with a new token, which is returned for the rest of the download
+func (sd *SongDownloaderImpl) getSongMetaRefreshingToken(ctx context.Context, urlMeta *URLMeta, token string) (*AutoSong, string, error) {
+	meta, err := sd.GetSongMeta(ctx, urlMeta, token)
+	if !errors.Is(err, ErrTokenRejected) {
+		return meta, token, err
 	}
-	defer resp.Body.Close()
 
-	// Check for a successful response
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(resp.Status)
+	logger.Warn("Apple Music token was rejected, retrying with a new one")
+	sd.apple.tokens.Invalidate(token)
+	token, err = sd.GetToken(ctx)
+	if err != nil {
+		return nil, "", err
 	}
+	meta, err = sd.GetSongMeta(ctx, urlMeta, token)
+	return meta, token, err
+}
 
-	// Decode the response body into the SongResponse struct
-	var songResponse SongResponse
-	if err := json.NewDecoder(resp.Body).Decode(&songResponse); err != nil {
+// GetAlbumMeta retrieves album metadata and its tracks from Apple Music API
+func (sd *SongDownloaderImpl) GetAlbumMeta(ctx context.Context, urlMeta *URLMeta, token string) (*AutoAlbum, error) {
+	if err := sd.faults.check(FaultCatalog); err != nil {
 		return nil, err
 	}
-
-	for _, d := range songResponse.Data {
-		if d.ID == urlMeta.ID {
-			return &d, nil
-		}
-	}
-
-	return nil, errors.New("song not found in response")
+	return sd.apple.GetAlbumMeta(ctx, urlMeta, token, sd.options.MetadataLanguage)
 } // GetEnhanceHls retrieves enhanced HLS URL from device service
 func (sd *SongDownloaderImpl) GetEnhanceHls(songId string) (string, error) {
-	conn, err := net.Dial("tcp", sd.deviceUrl)
+	// The length of the adamID is sent as a single byte
+	adamIDBuffer := []byte(songId)
+	if len(adamIDBuffer) == 0 || len(adamIDBuffer) > maxDeviceIDLength {
+		return "", &DeviceError{Op: "write", Err: fmt.Errorf("%w: %d bytes, want 1 to %d", ErrDeviceIDLength, len(adamIDBuffer), maxDeviceIDLength)}
+	}
+	lengthBuffer := []byte{byte(len(adamIDBuffer))}
+
+	conn, err := sd.dialDevice()
 	if err != nil {
-		return "", fmt.Errorf("error connecting to device: %w", err)
+		return "", &DeviceError{Op: "dial", Err: err}
 	}
 	defer conn.Close()
 
-	adamIDBuffer := []byte(songId)
-	lengthBuffer := []byte{byte(len(adamIDBuffer))}
-
 	// Write length and adamID to the connection
+	conn.SetWriteDeadline(sd.deviceDeadline())
 	_, err = conn.Write(lengthBuffer)
 	if err != nil {
-		return "", fmt.Errorf("error writing length to device: %w", err)
+		return "", &DeviceError{Op: "write", Err: fmt.Errorf("writing length: %w", err)}
 	}
 
 	_, err = conn.Write(adamIDBuffer)
 	if err != nil {
-		return "", fmt.Errorf("error writing adamID to device: %w", err)
+		return "", &DeviceError{Op: "write", Err: fmt.Errorf("writing adamID: %w", err)}
 	}
 
 	// Read the response (URL) from the device
+	conn.SetReadDeadline(sd.deviceDeadline())
 	response, err := bufio.NewReader(conn).ReadBytes('\n')
 	if err != nil {
-		return "", fmt.Errorf("error reading response from device: %w", err)
+		return "", &DeviceError{Op: "read", Err: err}
 	}
 
 	// Trim any newline characters from the response
@@ -520,63 +835,45 @@ func (sd *SongDownloaderImpl) GetEnhanceHls(songId string) (string, error) {
 	return string(response), nil
 }
 
-// ExtractMedia extracts media URL and keys from HLS manifest
-func (sd *SongDownloaderImpl) ExtractMedia(urlStr string) (string, []string, error) {
+// ExtractMedia extracts media URL and keys from HLS manifest, along with the
+// quality of the ALAC stream selected under the quality cap, or of the AAC
+// stream selected instead with the AAC fallback on
+func (sd *SongDownloaderImpl) ExtractMedia(ctx context.Context, urlStr string) (string, []string, MediaInfo, error) {
 	masterUrl, err := url.Parse(urlStr)
 	if err != nil {
-		return "", nil, err
+		return "", nil, MediaInfo{}, err
 	}
 
-	resp, err := http.Get(urlStr)
-	if err != nil {
-		return "", nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", nil, errors.New(resp.Status)
+	if err := sd.faults.check(FaultManifest); err != nil {
+		return "", nil, MediaInfo{}, err
 	}
-
-	body, err := io.ReadAll(resp.Body)
+	masterString, err := sd.apple.GetMasterPlaylist(ctx, urlStr)
 	if err != nil {
-		return "", nil, err
+		return "", nil, MediaInfo{}, err
 	}
-	masterString := string(body)
 	from, listType, err := m3u8.DecodeFrom(strings.NewReader(masterString), true)
 	if err != nil || listType != m3u8.MASTER {
-		return "", nil, errors.New("m3u8 not of master type")
+		return "", nil, MediaInfo{}, errors.New("m3u8 not of master type")
 	}
 	master := from.(*m3u8.MasterPlaylist)
-	var streamUrl *url.URL
-	sort.Slice(master.Variants, func(i, j int) bool {
-		return master.Variants[i].AverageBandwidth > master.Variants[j].AverageBandwidth
-	})
-
-	for _, variant := range master.Variants {
-		if variant.Codecs == "alac" {
-			split := strings.Split(variant.Audio, "-")
-			length := len(split)
-			lengthInt, err := strconv.Atoi(split[length-2])
-			if err != nil {
-				return "", nil, err
-			}
-			if lengthInt <= 192000 {
-				fmt.Printf("%s-bit / %s Hz\n", split[length-1], split[length-2])
-				streamUrlTemp, err := masterUrl.Parse(variant.URI)
-				if err != nil {
-					return "", nil, err
-				}
-				streamUrl = streamUrlTemp
-				break
-			}
-		}
+	variant, media, err := sd.selectVariant(master.Variants)
+	if err != nil {
+		return "", nil, MediaInfo{}, err
 	}
-
-	if streamUrl == nil {
-		return "", nil, errors.New("no codec found")
+	if media.Codec == CodecAAC {
+		logger.Info("No ALAC stream, selected AAC stream", logging.Any("Stream", media))
+	} else {
+		logger.Debug("Selected ALAC stream", logging.Any("Stream", media))
 	}
+	streamUrl, err := masterUrl.Parse(variant.URI)
+	if err != nil {
+		return "", nil, MediaInfo{}, err
+	}
+	// Auth tokens in the query string carry over to the variant and its file
+	inheritQuery(streamUrl, masterUrl)
+	mediaUrl := sd.resolveMediaURL(ctx, streamUrl)
 	var keys []string
 	keys = append(keys, prefetchKey)
-	streamUrl.Path = strings.TrimSuffix(streamUrl.Path, ".m3u8") + "_m.mp4"
 	regex := regexp.MustCompile(`"(skd?://[^"]*)"`)
 	matches := regex.FindAllStringSubmatch(masterString, -1)
 	for _, match := range matches {
@@ -584,7 +881,7 @@ func (sd *SongDownloaderImpl) ExtractMedia(urlStr string) (string, []string, err
 			keys = append(keys, match[1])
 		}
 	}
-	return streamUrl.String(), keys, nil
+	return mediaUrl, keys, media, nil
 } // ProgressReader wraps an io.Reader to provide progress callbacks
 type ProgressReader struct {
 	reader     io.Reader
@@ -602,45 +899,159 @@ func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 	return
 }
 
+// acquireDecryptSlot waits for a free decryption slot, reporting the waiting
+// phase while other downloads decrypt, and returns the function giving it back
+func (sd *SongDownloaderImpl) acquireDecryptSlot(ctx context.Context, callbacks ProgressCallbacks) (func(), error) {
+	release, err := sd.decryptSlots.Acquire(ctx, func() {
+		sd.updatePhase(PhaseWaitingForDecrypt, callbacks)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cancelled while waiting for decryption: %w", err)
+	}
+	return release, nil
+}
+
+// reserveMemory reserves the estimated footprint of a track from the memory
+// budget, reporting the waiting phase while other downloads hold the memory.
+// The reservation is released when Download returns
+func (sd *SongDownloaderImpl) reserveMemory(ctx context.Context, contentLength int64, callbacks ProgressCallbacks) error {
+	if sd.memoryBudget == nil {
+		return nil
+	}
+
+	waited := false
+	reservation, err := sd.memoryBudget.Reserve(ctx, EstimateFootprint(contentLength), func() {
+		// Waiting for memory is no stall
+		waited = true
+		sd.holdWatchdog()
+		sd.updatePhase(PhaseWaitingForMemory, callbacks)
+	})
+	if err != nil {
+		return fmt.Errorf("cancelled while waiting for memory: %w", err)
+	}
+
+	sd.mu.Lock()
+	sd.memoryReservation = reservation
+	sd.mu.Unlock()
+
+	if waited {
+		sd.feedWatchdog()
+		sd.updatePhase(PhaseDownloading, callbacks)
+	}
+
+	return nil
+}
+
+// releaseMemory returns the current memory reservation, if any
+func (sd *SongDownloaderImpl) releaseMemory() {
+	sd.mu.Lock()
+	reservation := sd.memoryReservation
+	sd.memoryReservation = nil
+	sd.mu.Unlock()
+
+	reservation.Release()
+}
+
 // extractSong downloads and extracts song data with progress reporting
 func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callbacks ProgressCallbacks) (*SongInfo, error) {
-	track, err := http.Get(url)
+	track, err := sd.getMedia(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	defer track.Body.Close()
-	if track.StatusCode != http.StatusOK {
-		return nil, errors.New(track.Status)
-	}
+	defer func() { track.Body.Close() }()
 
 	contentLength := track.ContentLength
 
-	// Create a progress reader to track download progress
-	progressReader := &ProgressReader{
-		reader: track.Body,
-		total:  contentLength,
-		onProgress: func(read, total int64) {
-			if callbacks.OnProgress != nil {
-				progress := Progress{
-					BytesProcessed: read,
-					TotalBytes:     total,
-					Percentage:     float64(read) / float64(total) * 100,
-				}
-				callbacks.OnProgress(PhaseDownloading, progress)
-			}
-		},
+	// Fail before writing anything when the song does not fit on disk
+	if err := sd.checkDiskSpace(contentLength); err != nil {
+		return nil, err
 	}
 
-	rawSong, err := io.ReadAll(progressReader)
-	if err != nil {
+	// Wait until the track fits in the memory budget before buffering it
+	if err := sd.reserveMemory(ctx, contentLength, callbacks); err != nil {
 		return nil, err
 	}
 
+	// Long tracks are downloaded in ranges over several connections, the
+	// first range continuing this response
+	var rawSong []byte
+	if chunks := sd.mediaChunkCount(track); chunks > 1 {
+		rawSong, err = sd.downloadChunks(ctx, url, track.Body, contentLength, chunks, callbacks)
+		if errors.Is(err, errRangeUnsupported) {
+			logger.Warn("Downloading media as one stream", logging.Err(err), logging.RedactedURL("URL", url))
+			track.Body.Close()
+			if track, err = sd.getMedia(ctx, url); err != nil {
+				return nil, err
+			}
+			rawSong = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if rawSong == nil {
+		if rawSong, err = sd.downloadStream(ctx, url, track, callbacks); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check for cancellation
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
+	return parseSongInfo(rawSong)
+}
+
+// getMedia requests the media file at url
+func (sd *SongDownloaderImpl) getMedia(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	track, err := sd.httpClient(DepMediaCDN, 0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if track.StatusCode != http.StatusOK {
+		track.Body.Close()
+		return nil, errors.New(track.Status)
+	}
+	return track, nil
+}
+
+// downloadStream reads the media file of track as one stream
+func (sd *SongDownloaderImpl) downloadStream(ctx context.Context, url string, track *http.Response, callbacks ProgressCallbacks) ([]byte, error) {
+	contentLength := track.ContentLength
+
+	// Resume from where the transfer broke off on network errors
+	body := &resumableBody{
+		ctx:     ctx,
+		sd:      sd,
+		url:     url,
+		total:   contentLength,
+		body:    sd.faults.wrapMedia(track.Body, contentLength, 0),
+		retries: sd.mediaRetries,
+		delay:   sd.mediaRetryDelay,
+	}
+	defer body.Close()
+
+	// Create a progress reader to track download progress. It counts the
+	// bytes of every attempt, so progress never goes backwards
+	meter := newRateMeter()
+	progressReader := &ProgressReader{
+		reader: sd.bandwidth.Reader(ctx, body),
+		total:  contentLength,
+		onProgress: func(read, total int64) {
+			sd.reportProgress(PhaseDownloading, meter.Progress(read, total), callbacks)
+		},
+	}
+
+	return io.ReadAll(progressReader)
+}
+
+// parseSongInfo parses a fragmented MP4 track into its ALAC or AAC parameters
+// and samples
+func parseSongInfo(rawSong []byte) (*SongInfo, error) {
 	f := bytes.NewReader(rawSong)
 
 	trex, err := mp4.ExtractBoxWithPayload(f, nil, []mp4.BoxType{
@@ -665,6 +1076,14 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 	}
 
 	var extracted *SongInfo
+	stsd, err := mp4.ExtractBoxWithPayload(f, stbl[0], []mp4.BoxType{
+		mp4.BoxTypeStsd(),
+	})
+	if err != nil || len(stsd) != 1 {
+		return nil, err
+	}
+	stsdEntryCount := stsd[0].Payload.(*mp4.Stsd).EntryCount
+
 	enca, err := mp4.ExtractBoxWithPayload(f, stbl[0], []mp4.BoxType{
 		mp4.BoxTypeStsd(),
 		mp4.BoxTypeEnca(),
@@ -675,12 +1094,23 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 
 	aalac, err := mp4.ExtractBoxWithPayload(f, &enca[0].Info,
 		[]mp4.BoxType{BoxTypeAlac()})
-	if err != nil || len(aalac) != 1 {
+	if err != nil {
 		return nil, err
 	}
 	extracted = &SongInfo{
-		r:         f,
-		alacParam: aalac[0].Payload.(*Alac),
+		r:            f,
+		descriptions: stsdEntryCount,
+	}
+	switch len(aalac) {
+	case 1:
+		extracted.alacParam = aalac[0].Payload.(*Alac)
+	case 0:
+		// AAC streams describe their audio in an esds box instead
+		if err := readAACEntry(f, enca[0], extracted); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("expected a single alac box")
 	}
 
 	moofs, err := mp4.ExtractBox(f, nil, []mp4.BoxType{
@@ -697,6 +1127,10 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 		return nil, err
 	}
 
+	// Raw sample description index of each fragment, normalized once the
+	// convention of the whole file is known
+	fragmentIndexes := make([]uint32, 0, len(moofs))
+
 	for i, moof := range moofs {
 		tfhd, err := mp4.ExtractBoxWithPayload(f, moof, []mp4.BoxType{
 			mp4.BoxTypeTraf(),
@@ -706,10 +1140,11 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 			return nil, err
 		}
 		tfhdPay := tfhd[0].Payload.(*mp4.Tfhd)
-		index := tfhdPay.SampleDescriptionIndex
-		if index != 0 {
-			index--
+		index := trexPay.DefaultSampleDescriptionIndex
+		if tfhdPay.CheckFlag(0x2) {
+			index = tfhdPay.SampleDescriptionIndex
 		}
+		fragmentIndexes = append(fragmentIndexes, index)
 
 		truns, err := mp4.ExtractBoxWithPayload(f, moof, []mp4.BoxType{
 			mp4.BoxTypeTraf(),
@@ -753,6 +1188,12 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 		}
 	}
 
+	convention, reason := detectDescIndexConvention(fragmentIndexes, stsdEntryCount)
+	logger.Debug("Detected sample description indexes", logging.Any("Convention", convention), logging.String("Reason", reason))
+	for i := range extracted.samples {
+		extracted.samples[i].descIndex = normalizeDescIndex(extracted.samples[i].descIndex, convention)
+	}
+
 	// Calculate total data size
 	var totalSize int64 = 0
 	for _, sample := range extracted.samples {
@@ -763,139 +1204,82 @@ func (sd *SongDownloaderImpl) extractSong(ctx context.Context, url string, callb
 	return extracted, nil
 }
 
-// decryptSong decrypts the song data with progress reporting
-func (sd *SongDownloaderImpl) decryptSong(ctx context.Context, info *SongInfo, keys []string, manifest *AutoSong, callbacks ProgressCallbacks) ([]byte, error) {
-	conn, err := net.Dial("tcp", sd.decryptionUrl)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+// decryptSong decrypts the song data with progress reporting, writing the
+// decrypted samples to out in order. Only one sample is held at a time
+func (sd *SongDownloaderImpl) decryptSong(ctx context.Context, info *SongInfo, keys []string, manifest *AutoSong, out io.Writer, callbacks ProgressCallbacks) (err error) {
+	session := sd.decrypter.Session(sd.decryptionUrl, sd.latencies)
+	defer func() { session.Close(err == nil) }()
+
+	// Cancelling, e.g. when decryption stalls, fails a pending sample at once
+	stop := context.AfterFunc(ctx, session.Interrupt)
+	defer stop()
 
-	var decrypted []byte
+	w := bufio.NewWriter(out)
+	var de []byte
 	var lastIndex uint32 = math.MaxUint8
 	var totalProcessed int64 = 0
+	meter := newRateMeter()
+	faultAfter, faultErr := sd.faults.decryptFault()
 
-	bar := progressbar.NewOptions64(info.totalDataSize,
-		progressbar.OptionClearOnFinish(),
-		progressbar.OptionSetElapsedTime(false),
-		progressbar.OptionSetPredictTime(false),
-		progressbar.OptionShowElapsedTimeOnFinish(),
-		progressbar.OptionShowCount(),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionShowBytes(true),
-		//progressbar.OptionSetDescription("Decrypting..."),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "",
-			SaucerHead:    "",
-			SaucerPadding: "",
-			BarStart:      "",
-			BarEnd:        "",
-		}),
-	)
-
-	for _, sp := range info.samples {
+	for i, sp := range info.samples {
 		// Check for cancellation
 		if err := ctx.Err(); err != nil {
-			return nil, err
+			return err
+		}
+		if i == faultAfter {
+			return faultErr
 		}
 
 		if lastIndex != sp.descIndex {
-			if len(decrypted) != 0 {
-				_, err := conn.Write([]byte{0, 0, 0, 0})
-				if err != nil {
-					return nil, err
-				}
-			}
-			keyUri := keys[sp.descIndex]
+			keyUri := sampleKey(keys, sp.descIndex)
 			id := manifest.ID
 			if keyUri == prefetchKey {
 				id = defaultId
 			}
-
-			_, err := conn.Write([]byte{byte(len(id))})
-			if err != nil {
-				return nil, err
-			}
-			_, err = io.WriteString(conn, id)
-			if err != nil {
-				return nil, err
-			}
-
-			_, err = conn.Write([]byte{byte(len(keyUri))})
-			if err != nil {
-				return nil, err
-			}
-			_, err = io.WriteString(conn, keyUri)
-			if err != nil {
-				return nil, err
+			if err := session.SetKey(id, keyUri); err != nil {
+				return err
 			}
 		}
 		lastIndex = sp.descIndex
 
-		err := binary.Write(conn, binary.LittleEndian, uint32(len(sp.data)))
-		if err != nil {
-			return nil, err
+		// Samples are small, so one buffer is reused for all of them
+		if cap(de) < len(sp.data) {
+			de = make([]byte, len(sp.data))
 		}
-
-		_, err = conn.Write(sp.data)
-		if err != nil {
-			return nil, err
+		de = de[:len(sp.data)]
+		if err := session.Decrypt(sp.data, de); err != nil {
+			return fallbackDecryptError(info.samples, keys, i, err)
 		}
 
-		de := make([]byte, len(sp.data))
-		_, err = io.ReadFull(conn, de)
-		if err != nil {
-			return nil, err
+		if _, err := w.Write(de); err != nil {
+			return err
 		}
-
-		decrypted = append(decrypted, de...)
-		bar.Add(len(sp.data))
 		totalProcessed += int64(len(sp.data))
 
 		// Report progress
-		if callbacks.OnProgress != nil {
-			progress := Progress{
-				BytesProcessed: totalProcessed,
-				TotalBytes:     info.totalDataSize,
-				Percentage:     float64(totalProcessed) / float64(info.totalDataSize) * 100,
-			}
-			callbacks.OnProgress(PhaseDecrypting, progress)
-		}
+		sd.reportProgress(PhaseDecrypting, meter.Progress(totalProcessed, info.totalDataSize), callbacks)
 	}
 
-	_, _ = conn.Write([]byte{0, 0, 0, 0, 0})
-
-	return decrypted, nil
+	return w.Flush()
 }
 
-// addArtwork adds artwork to the M4A file
-func (sd *SongDownloaderImpl) addArtwork(filePath string, meta *AutoSong) error {
-	artwork := meta.Attributes.Artwork
-	coverUrl := strings.Replace(artwork.URL, "{w}x{h}", fmt.Sprintf("%dx%d", artwork.Width, artwork.Height), -1)
-	resp, err := http.Get(coverUrl)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	cover, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	mp4t, err := mp4tag.Open(filePath)
+// validateOutputFile checks a finished M4A for leftovers of the encrypted
+// source and inconsistent sample tables
+func validateOutputFile(filePath string) error {
+	f, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
-	defer mp4t.Close()
+	defer f.Close()
 
-	tags := &mp4tag.MP4Tags{
-		Pictures: []*mp4tag.MP4Picture{{Data: cover}},
-	}
-
-	err = mp4t.Write(tags, []string{})
-	if err != nil {
-		return err
-	}
+	return fmp4.ValidateOutputM4A(f)
+}
 
-	return nil
+// addArtwork adds cover to an M4A file written without artwork. Downloads
+// embed the artwork as the file is written and only fall back to this second
+// pass when the artwork arrived after the file was written
+func (sd *SongDownloaderImpl) addArtwork(filePath string, meta *AutoSong, album *AlbumContext, cover embeddedArtwork) error {
+	// Rewrite the tags with the cover added, keeping the tags written by WriteM4a
+	_, err := rewriteTags(filePath, meta, album, cover.data)
+	return err
 }
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDownload_ReportsMetadataFirst(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/catalog/us/songs/1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song","artistName":"Artist","durationInMillis":180000,
+			"extendedAssetUrls":{"enhancedHls":"https://example.com/master.m3u8"}}}]}`)
+	}))
+	defer server.Close()
+
+	// The device service refuses connections, failing the download once the
+	// metadata is known
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.staticToken = testToken
+	sd.apple.catalogURL = server.URL
+	sd.deviceUrl = listener.Addr().String()
+	sd.downloadDir = t.TempDir()
+
+	var got []*SongMetadata
+	var phaseBeforeMetadata bool
+	callbacks := ProgressCallbacks{
+		OnMetadata: func(meta *SongMetadata) { got = append(got, meta) },
+		OnPhaseChange: func(oldPhase, newPhase Phase) {
+			if newPhase != PhaseValidating && len(got) == 0 {
+				phaseBeforeMetadata = true
+			}
+		},
+	}
+	if _, err := sd.Download(context.Background(), "https://music.apple.com/us/song/song/1", callbacks); err == nil {
+		t.Fatal("Expected the download to fail without a device service")
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("Expected the metadata to be reported once, got %d", len(got))
+	}
+	if meta := got[0]; meta.Title != "Song" || meta.Artist != "Artist" || meta.AppleMusicID != "1" || meta.DurationMillis != 180000 {
+		t.Errorf("Unexpected metadata: %+v", meta)
+	}
+	if phaseBeforeMetadata {
+		t.Error("Expected the metadata before the download started")
+	}
+
+	// Callbacks without OnMetadata still work
+	if _, err := sd.Download(context.Background(), "https://music.apple.com/us/song/song/1", ProgressCallbacks{}); err == nil {
+		t.Fatal("Expected the download to fail without a device service")
+	}
+}
@@ -0,0 +1,361 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abema/go-mp4"
+)
+
+// SplitPart is one part of a download split to stay under the upload size limit
+type SplitPart struct {
+	FilePath string        `json:"file_path"`
+	Duration time.Duration `json:"duration"`
+	FileSize int64         `json:"file_size"`
+}
+
+var stblPath = mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl()}
+
+// alacSampleEntrySize is the size of the audio sample entry fields WriteM4a
+// writes before the nested alac box
+const alacSampleEntrySize = 28
+
+// splitM4A splits the M4A at path into the fewest parts of at most maxBytes,
+// cut at the sample boundaries nearest to equal durations. Every part is
+// re-muxed by WriteM4a without touching the audio, keeps the artwork and has
+// "(Part i/N)" appended to its title. Album, when known, supplies the track
+// and disc totals. Audio is streamed from the original file part by part, so
+// splitting needs no memory beyond the download's reservation. The original
+// file is left in place. The parts are written to a directory of their own,
+// as concurrent jobs for the same song would otherwise overwrite each other's
+func (sd *SongDownloaderImpl) splitM4A(path string, meta *AutoSong, album *AlbumContext, maxBytes int64) (_ []SplitPart, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir, err := newTempDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := readOutputM4A(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	timescale, err := readTimescale(f)
+	if err != nil {
+		return nil, err
+	}
+	cover, err := readCover(f)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int((fileInfo.Size() + maxBytes - 1) / maxBytes)
+	for ; count <= len(info.samples); count++ {
+		parts, err := sd.writeParts(path, dir, f, info, meta, album, cover, timescale, splitPoints(info.samples, count))
+		if err != nil {
+			return nil, err
+		}
+
+		fits := true
+		for _, part := range parts {
+			fits = fits && part.FileSize <= maxBytes
+		}
+		if fits {
+			return parts, nil
+		}
+
+		// Uneven bitrate left a part too large, try again with one more
+		for _, part := range parts {
+			os.Remove(part.FilePath)
+		}
+	}
+
+	return nil, fmt.Errorf("cannot split %s into parts of at most %d bytes", path, maxBytes)
+}
+
+// writeParts writes one M4A per range of samples starting at each of starts
+// into dir, named after path and copying their audio from src
+func (sd *SongDownloaderImpl) writeParts(path, dir string, src io.ReaderAt, info *SongInfo, meta *AutoSong, album *AlbumContext, cover []byte, timescale uint32, starts []int) ([]SplitPart, error) {
+	var parts []SplitPart
+	fail := func(err error) ([]SplitPart, error) {
+		for _, part := range parts {
+			os.Remove(part.FilePath)
+		}
+		return nil, err
+	}
+
+	for i, start := range starts {
+		end := len(info.samples)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		partInfo := &SongInfo{r: info.r, alacParam: info.alacParam, samples: info.samples[start:end]}
+		audio, err := sampleSection(src, partInfo.samples)
+		if err != nil {
+			return fail(err)
+		}
+		partInfo.totalDataSize = audio.Size()
+
+		partMeta := *meta
+		partMeta.Attributes.Name = fmt.Sprintf("%s (Part %d/%d)", meta.Attributes.Name, i+1, len(starts))
+
+		partPath := filepath.Join(dir, fmt.Sprintf("%s (Part %d of %d).m4a", strings.TrimSuffix(filepath.Base(path), ".m4a"), i+1, len(starts)))
+		file, err := os.Create(partPath)
+		if err != nil {
+			return fail(err)
+		}
+		_, err = sd.writeM4a(mp4.NewWriter(file), partInfo, &partMeta, album, cover, audio)
+		file.Close()
+		if err != nil {
+			os.Remove(partPath)
+			return fail(fmt.Errorf("failed to write part %d: %w", i+1, err))
+		}
+
+		stat, err := os.Stat(partPath)
+		if err != nil {
+			return fail(err)
+		}
+		parts = append(parts, SplitPart{
+			FilePath: partPath,
+			Duration: time.Duration(float64(partInfo.Duration()) / float64(timescale) * float64(time.Second)),
+			FileSize: stat.Size(),
+		})
+	}
+
+	return parts, nil
+}
+
+// sampleSection returns a reader of the audio of samples, which must lie back
+// to back in r as WriteM4a writes them
+func sampleSection(r io.ReaderAt, samples []SampleInfo) (*io.SectionReader, error) {
+	start := samples[0].offset
+	end := start
+	for i, sample := range samples {
+		if sample.offset != end {
+			return nil, fmt.Errorf("sample %d does not follow the one before it", i+1)
+		}
+		end += sample.size()
+	}
+	return io.NewSectionReader(r, start, end-start), nil
+}
+
+// splitPoints returns the first sample of each of count parts, picking the
+// sample boundaries nearest to equal durations. Every part gets at least one
+// sample
+func splitPoints(samples []SampleInfo, count int) []int {
+	var total uint64
+	for _, sample := range samples {
+		total += uint64(sample.duration)
+	}
+
+	starts := []int{0}
+	var elapsed uint64
+	next := 0
+	for part := 1; part < count; part++ {
+		target := total * uint64(part) / uint64(count)
+
+		// Advance while the boundary after the next sample is at least as close
+		for next < len(samples) && elapsed+uint64(samples[next].duration) <= target {
+			elapsed += uint64(samples[next].duration)
+			next++
+		}
+		if next < len(samples) && target-elapsed > elapsed+uint64(samples[next].duration)-target {
+			elapsed += uint64(samples[next].duration)
+			next++
+		}
+
+		// Keep every part non-empty
+		minStart := starts[len(starts)-1] + 1
+		maxStart := len(samples) - (count - part)
+		for next < minStart {
+			elapsed += uint64(samples[next].duration)
+			next++
+		}
+		for next > maxStart {
+			next--
+			elapsed -= uint64(samples[next].duration)
+		}
+		starts = append(starts, next)
+	}
+	return starts
+}
+
+// readOutputM4A reads the samples and ALAC parameters of an M4A written by
+// WriteM4a, so it can be re-muxed. Sample data is not loaded: samples record
+// where in r it is
+func readOutputM4A(r io.ReadSeeker) (*SongInfo, error) {
+	stbls, err := mp4.ExtractBox(r, nil, stblPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(stbls) != 1 {
+		return nil, errors.New("expected a single track")
+	}
+	stbl := stbls[0]
+
+	boxes, err := mp4.ExtractBoxesWithPayload(r, stbl, []mp4.BoxPath{
+		{mp4.BoxTypeStts()},
+		{mp4.BoxTypeStsc()},
+		{mp4.BoxTypeStsz()},
+		{mp4.BoxTypeStco()},
+		{mp4.BoxTypeCo64()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stts *mp4.Stts
+	var stsc *mp4.Stsc
+	var stsz *mp4.Stsz
+	var offsets []uint64
+	for _, box := range boxes {
+		switch payload := box.Payload.(type) {
+		case *mp4.Stts:
+			stts = payload
+		case *mp4.Stsc:
+			stsc = payload
+		case *mp4.Stsz:
+			stsz = payload
+		case *mp4.Stco:
+			for _, offset := range payload.ChunkOffset {
+				offsets = append(offsets, uint64(offset))
+			}
+		case *mp4.Co64:
+			offsets = payload.ChunkOffset
+		}
+	}
+	if stts == nil || stsc == nil || stsz == nil || offsets == nil || len(stsc.Entries) == 0 {
+		return nil, errors.New("incomplete sample table")
+	}
+
+	alac, err := readAlacParam(r, stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	var durations []uint32
+	for _, entry := range stts.Entries {
+		for i := uint32(0); i < entry.SampleCount; i++ {
+			durations = append(durations, entry.SampleDelta)
+		}
+	}
+	if len(durations) != len(stsz.EntrySize) {
+		return nil, fmt.Errorf("stts has %d samples, stsz has %d", len(durations), len(stsz.EntrySize))
+	}
+
+	info := &SongInfo{r: r, alacParam: alac}
+	sample := 0
+	for chunk, offset := range offsets {
+		perChunk := 0
+		for _, entry := range stsc.Entries {
+			if uint32(chunk+1) >= entry.FirstChunk {
+				perChunk = int(entry.SamplesPerChunk)
+			}
+		}
+
+		position := int64(offset)
+		for n := 0; n < perChunk && sample < len(durations); n++ {
+			length := stsz.EntrySize[sample]
+			info.samples = append(info.samples, SampleInfo{offset: position, length: length, duration: durations[sample]})
+			info.totalDataSize += int64(length)
+			position += int64(length)
+			sample++
+		}
+	}
+	if sample != len(durations) {
+		return nil, fmt.Errorf("chunks hold %d samples, stsz has %d", sample, len(durations))
+	}
+
+	return info, nil
+}
+
+// readAlacParam reads the ALAC parameters nested in the alac sample entry.
+// The entry and the box inside it share a type, so the inner box is located
+// by hand rather than through the box definitions
+func readAlacParam(r io.ReadSeeker, stbl *mp4.BoxInfo) (*Alac, error) {
+	entries, err := mp4.ExtractBox(r, stbl, mp4.BoxPath{mp4.BoxTypeStsd(), BoxTypeAlac()})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, errors.New("expected a single alac sample entry")
+	}
+
+	if _, err := r.Seek(int64(entries[0].Offset+entries[0].HeaderSize)+alacSampleEntrySize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	inner, err := mp4.ReadBoxInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	if inner.Type != BoxTypeAlac() {
+		return nil, fmt.Errorf("expected alac box in sample entry, got %s", inner.Type)
+	}
+
+	var alac Alac
+	if _, err := mp4.Unmarshal(r, inner.Size-inner.HeaderSize, &alac, inner.Context); err != nil {
+		return nil, err
+	}
+	return &alac, nil
+}
+
+// readTimescale returns the timescale of the track's sample durations
+func readTimescale(r io.ReadSeeker) (uint32, error) {
+	mdhds, err := mp4.ExtractBoxWithPayload(r, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMdhd()})
+	if err != nil {
+		return 0, err
+	}
+	if len(mdhds) != 1 || mdhds[0].Payload.(*mp4.Mdhd).Timescale == 0 {
+		return 0, errors.New("missing track timescale")
+	}
+	return mdhds[0].Payload.(*mp4.Mdhd).Timescale, nil
+}
+
+// readCover returns the artwork image of a file, or nil when it has none
+func readCover(r io.ReadSeeker) ([]byte, error) {
+	items, err := readIlstItems(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if item.Type != covrType {
+			continue
+		}
+
+		raw := make([]byte, item.Size-item.HeaderSize)
+		if _, err := item.SeekToPayload(r); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, err
+		}
+
+		// data box: size, type, data type and locale before the image
+		if len(raw) < 16 || !bytes.Equal(raw[4:8], []byte("data")) {
+			return nil, errors.New("malformed covr item")
+		}
+		return raw[16:], nil
+	}
+	return nil, nil
+}
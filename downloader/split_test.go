@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readSamples returns the samples of an output M4A
+func readSamples(t *testing.T, path string) *SongInfo {
+	t.Helper()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	info, err := readOutputM4A(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", path, err)
+	}
+	return info
+}
+
+func TestSplitM4A(t *testing.T) {
+	meta := retagTestMeta("Long Song")
+	path := writeBotFixture(t, meta)
+	cover := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, bytes.Repeat([]byte("cover"), 20)...)
+	if _, err := rewriteTags(path, meta, nil, cover); err != nil {
+		t.Fatalf("Failed to add artwork: %v", err)
+	}
+
+	stat, _ := os.Stat(path)
+	original := readSamples(t, path)
+	originalAudio, _ := readAudio(t, path)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	parts, err := sd.splitM4A(path, meta, nil, stat.Size()-1)
+	if err != nil {
+		t.Fatalf("splitM4A failed: %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(parts))
+	}
+
+	var audio []byte
+	var ticks uint64
+	var duration time.Duration
+	for i, part := range parts {
+		if err := validateOutputFile(part.FilePath); err != nil {
+			t.Errorf("Part %d failed validation: %v", i+1, err)
+		}
+		if part.FileSize > stat.Size()-1 {
+			t.Errorf("Part %d is %d bytes, over the limit", i+1, part.FileSize)
+		}
+
+		info := readSamples(t, part.FilePath)
+		ticks += info.Duration()
+		duration += part.Duration
+
+		// Chunk offsets must point at the part's own audio
+		raw, _ := os.ReadFile(part.FilePath)
+		partAudio, chunkStarts := readAudio(t, part.FilePath)
+		for chunk, start := range chunkStarts {
+			if want := raw[info.samples[chunk*5].offset]; start != want {
+				t.Errorf("Part %d chunk %d starts with %d, want %d", i+1, chunk+1, start, want)
+			}
+		}
+		audio = append(audio, partAudio...)
+
+		title := []byte("Long Song (Part " + string(rune('1'+i)) + "/2)")
+		if !bytes.Contains(raw, title) {
+			t.Errorf("Expected part %d to be titled %q", i+1, title)
+		}
+		f, _ := os.Open(part.FilePath)
+		partCover, err := readCover(f)
+		f.Close()
+		if err != nil || !bytes.Equal(partCover, cover) {
+			t.Errorf("Expected part %d to keep the artwork, got %v", i+1, err)
+		}
+	}
+
+	if ticks != original.Duration() {
+		t.Errorf("Parts last %d ticks, want %d", ticks, original.Duration())
+	}
+	if want := time.Duration(float64(original.Duration()) / 44100 * float64(time.Second)); duration-want > time.Millisecond || want-duration > time.Millisecond {
+		t.Errorf("Parts last %v, want %v", duration, want)
+	}
+	if !bytes.Equal(audio, originalAudio) {
+		t.Error("Expected the parts to hold the original audio unchanged")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the original file to be kept: %v", err)
+	}
+}
+
+func TestSplitPoints(t *testing.T) {
+	samples := func(durations ...uint32) []SampleInfo {
+		var s []SampleInfo
+		for _, d := range durations {
+			s = append(s, SampleInfo{duration: d})
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		samples []SampleInfo
+		count   int
+		want    []int
+	}{
+		{"equal halves", samples(1, 1, 1, 1, 1, 1), 2, []int{0, 3}},
+		{"nearest boundary", samples(4, 1, 8, 1), 2, []int{0, 2}},
+		{"thirds", samples(2, 2, 2, 2, 2, 2, 2, 2, 2), 3, []int{0, 3, 6}},
+		{"one sample each", samples(1, 1, 100), 3, []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPoints(tt.samples, tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitM4A_ConcurrentJobsGetTheirOwnParts(t *testing.T) {
+	meta := retagTestMeta("Long Song")
+	path := writeBotFixture(t, meta)
+	stat, _ := os.Stat(path)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	first, err := sd.splitM4A(path, meta, nil, stat.Size()-1)
+	if err != nil {
+		t.Fatalf("splitM4A failed: %v", err)
+	}
+	uploading, _ := os.ReadFile(first[0].FilePath)
+
+	// A second job for the same song splits it while the first uploads
+	second, err := sd.splitM4A(path, meta, nil, stat.Size()-1)
+	if err != nil {
+		t.Fatalf("splitM4A failed: %v", err)
+	}
+	for i := range first {
+		if first[i].FilePath == second[i].FilePath {
+			t.Errorf("Expected part %d of each job at its own path, both got %s", i+1, first[i].FilePath)
+		}
+		if filepath.Base(first[i].FilePath) != filepath.Base(second[i].FilePath) {
+			t.Errorf("Expected part %d to keep its name, got %s and %s", i+1, first[i].FilePath, second[i].FilePath)
+		}
+	}
+	if after, _ := os.ReadFile(first[0].FilePath); !bytes.Equal(after, uploading) {
+		t.Error("Expected the first job's part to be left alone")
+	}
+
+	// The last part uploaded takes the job's directory with it
+	for _, part := range first {
+		if _, err := ReleaseDownload(part.FilePath, true); err != nil {
+			t.Fatalf("ReleaseDownload() error = %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Dir(first[0].FilePath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the parts directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultStallTimeout is how long a download or decryption may go without
+// progress before it is considered stalled
+const DefaultStallTimeout = 60 * time.Second
+
+// errStalled is the cause of the cancelled context of a stalled phase
+var errStalled = errors.New("stalled")
+
+// stallWatchdog cancels the context of a phase that makes no progress for a
+// while. Progress is reported with Feed
+type stallWatchdog struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	window time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	held bool
+}
+
+// watchStalls returns a watchdog whose context, derived from ctx, is cancelled
+// with errStalled once window passes without Feed. A window of zero or less
+// never cancels. Stop must be called when the phase ends
+func watchStalls(ctx context.Context, window time.Duration) *stallWatchdog {
+	phaseCtx, cancel := context.WithCancelCause(ctx)
+	w := &stallWatchdog{ctx: phaseCtx, cancel: cancel, window: window, last: time.Now()}
+	if window > 0 {
+		go w.run()
+	}
+	return w
+}
+
+// run cancels the context once the phase has been idle for the window
+func (w *stallWatchdog) run() {
+	timer := time.NewTimer(w.window)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		w.mu.Lock()
+		idle := time.Since(w.last)
+		held := w.held
+		w.mu.Unlock()
+
+		if held {
+			timer.Reset(w.window)
+			continue
+		}
+		if idle < w.window {
+			timer.Reset(w.window - idle)
+			continue
+		}
+		w.cancel(errStalled)
+		return
+	}
+}
+
+// Feed records progress of the phase, ending a Hold
+func (w *stallWatchdog) Feed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.last = time.Now()
+	w.held = false
+}
+
+// Hold stops the phase from stalling until the next Feed, while it waits for
+// something other than the network, such as memory
+func (w *stallWatchdog) Hold() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held = true
+}
+
+// Stalled reports whether the watchdog cancelled the phase
+func (w *stallWatchdog) Stalled() bool {
+	return errors.Is(context.Cause(w.ctx), errStalled)
+}
+
+// Stop ends the watch and releases the context
+func (w *stallWatchdog) Stop() {
+	w.cancel(nil)
+}
+
+// stalledError is the error of a phase that stalled on every attempt
+func stalledError(phase Phase, window time.Duration) *DownloadError {
+	return NewDownloadErrorWithCause(ErrorNetworkFailure, fmt.Sprintf("%s stalled: no progress for %s", phase, window), errStalled)
+}
+
+// runWatched runs a phase under a stall watchdog fed by reportProgress. A phase
+// that stalls is run once more, and fails with stalledError when it stalls
+// again
+func (sd *SongDownloaderImpl) runWatched(ctx context.Context, phase Phase, callbacks ProgressCallbacks, run func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		watchdog := watchStalls(ctx, sd.stallTimeout)
+		sd.mu.Lock()
+		sd.watchdog = watchdog
+		sd.mu.Unlock()
+
+		err := run(watchdog.ctx)
+
+		sd.mu.Lock()
+		sd.watchdog = nil
+		sd.mu.Unlock()
+		stalled := watchdog.Stalled()
+		watchdog.Stop()
+
+		if err == nil || !stalled {
+			return err
+		}
+		if attempt > 1 {
+			return stalledError(phase, sd.stallTimeout)
+		}
+		sd.warn(fmt.Sprintf("No %s progress for %s, retrying", phase, sd.stallTimeout), callbacks)
+	}
+}
+
+// feedWatchdog records progress of the watched phase, if any
+func (sd *SongDownloaderImpl) feedWatchdog() {
+	sd.mu.RLock()
+	watchdog := sd.watchdog
+	sd.mu.RUnlock()
+
+	if watchdog != nil {
+		watchdog.Feed()
+	}
+}
+
+// holdWatchdog keeps the watched phase, if any, from stalling until it makes
+// progress again
+func (sd *SongDownloaderImpl) holdWatchdog() {
+	sd.mu.RLock()
+	watchdog := sd.watchdog
+	sd.mu.RUnlock()
+
+	if watchdog != nil {
+		watchdog.Hold()
+	}
+}
@@ -0,0 +1,187 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStallWatchdog(t *testing.T) {
+	const window = 30 * time.Millisecond
+
+	// Progress keeps the phase alive
+	w := watchStalls(context.Background(), window)
+	for i := 0; i < 6; i++ {
+		time.Sleep(window / 3)
+		w.Feed()
+	}
+	if w.Stalled() {
+		t.Error("Expected a phase making progress not to stall")
+	}
+
+	// So does waiting for something other than the network
+	w.Hold()
+	time.Sleep(3 * window)
+	if w.Stalled() {
+		t.Error("Expected a held phase not to stall")
+	}
+	w.Feed()
+
+	select {
+	case <-w.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the idle phase to be cancelled")
+	}
+	if !w.Stalled() {
+		t.Errorf("Expected the stall as cause, got %v", context.Cause(w.ctx))
+	}
+	w.Stop()
+
+	// Stopping or cancelling the download is no stall
+	ctx, cancel := context.WithCancel(context.Background())
+	w = watchStalls(ctx, window)
+	cancel()
+	time.Sleep(2 * window)
+	if w.Stalled() {
+		t.Error("Expected a cancelled phase not to count as stalled")
+	}
+	w.Stop()
+
+	w = watchStalls(context.Background(), 0)
+	time.Sleep(window)
+	if w.ctx.Err() != nil {
+		t.Error("Expected a zero window to disable the watchdog")
+	}
+	w.Stop()
+}
+
+// newStallingServer serves body, stopping halfway through on the first
+// stalls requests until the client gives up on them
+func newStallingServer(t *testing.T, body []byte, stalls int32) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if requests.Add(1) > stalls {
+			w.Write(body)
+			return
+		}
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// watchedStream downloads url as one stream under the stall watchdog
+func watchedStream(sd *SongDownloaderImpl, url string, callbacks ProgressCallbacks) ([]byte, error) {
+	var data []byte
+	err := sd.runWatched(context.Background(), PhaseDownloading, callbacks, func(ctx context.Context) error {
+		track, err := sd.getMedia(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer track.Body.Close()
+		data, err = sd.downloadStream(ctx, url, track, callbacks)
+		return err
+	})
+	return data, err
+}
+
+// warningRecorder collects the warnings of a download
+type warningRecorder struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (r *warningRecorder) callbacks() ProgressCallbacks {
+	return ProgressCallbacks{OnWarning: func(message string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.warnings = append(r.warnings, message)
+	}}
+}
+
+func TestRunWatched_RetriesStalledDownload(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	server, requests := newStallingServer(t, body, 1)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.stallTimeout = 50 * time.Millisecond
+	sd.mediaRetries = 0
+	warnings := &warningRecorder{}
+
+	data, err := watchedStream(sd, server.URL, warnings.callbacks())
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if !bytes.Equal(data, body) {
+		t.Errorf("Expected the whole body, got %d bytes", len(data))
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected the stalled request and 1 retry, got %d requests", got)
+	}
+	if len(warnings.warnings) != 1 {
+		t.Errorf("Expected 1 stall warning, got %q", warnings.warnings)
+	}
+}
+
+func TestRunWatched_FailsWhenStalledTwice(t *testing.T) {
+	server, requests := newStallingServer(t, bytes.Repeat([]byte{0xAB}, 1000), 2)
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.stallTimeout = 50 * time.Millisecond
+	sd.mediaRetries = 0
+
+	_, err := watchedStream(sd, server.URL, ProgressCallbacks{})
+	if !IsDownloadError(err, ErrorNetworkFailure) || !errors.Is(err, errStalled) {
+		t.Fatalf("Expected a stalled network failure, got %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected 2 requests, got %d", got)
+	}
+}
+
+func TestRunWatched_InterruptsStalledDecryption(t *testing.T) {
+	// Reads everything and never answers
+	addr, accepted := newFakeDecryptor(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.decryptionUrl = addr
+	sd.decrypter = NewDecryptClient(0)
+	sd.stallTimeout = 50 * time.Millisecond
+	warnings := &warningRecorder{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sd.runWatched(context.Background(), PhaseDecrypting, warnings.callbacks(), func(ctx context.Context) error {
+			return sd.decryptSong(ctx, decryptTestSong(3, 100), []string{"skd://key"}, &AutoSong{ID: "1"}, io.Discard, warnings.callbacks())
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !IsDownloadError(err, ErrorNetworkFailure) || !errors.Is(err, errStalled) {
+			t.Fatalf("Expected a stalled network failure, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Decryption blocked on a hung decryptor")
+	}
+	if got := accepted.Load(); got != 2 {
+		t.Errorf("Expected a new connection for the retry, got %d connections", got)
+	}
+	if len(warnings.warnings) != 1 {
+		t.Errorf("Expected 1 stall warning, got %q", warnings.warnings)
+	}
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+const (
+	// storefrontEMAAlpha is the weight of the newest outcome in a health score
+	storefrontEMAAlpha = 0.3
+
+	// storefrontReprobeInterval is how often, in orderings, the least healthy
+	// fallback is tried first so a recovered storefront can climb back
+	storefrontReprobeInterval = 10
+)
+
+// ErrNotInStorefront is returned when the catalog has no such song in the
+// requested storefront, usually because it is region restricted
+var ErrNotInStorefront = errors.New("song not available in storefront")
+
+// StorefrontScore is the health of one storefront
+type StorefrontScore struct {
+	Storefront string
+	Score      float64 // Moving average of outcomes, from 0 (failing) to 1 (healthy)
+	Outcomes   int     // Number of outcomes recorded
+}
+
+// StorefrontHealth keeps an exponential moving average of download outcomes
+// per storefront and orders fallback storefronts by it
+type StorefrontHealth struct {
+	mu        sync.Mutex
+	scores    map[string]*StorefrontScore
+	orderings int
+}
+
+// NewStorefrontHealth creates a StorefrontHealth where every storefront starts
+// out healthy
+func NewStorefrontHealth() *StorefrontHealth {
+	return &StorefrontHealth{scores: make(map[string]*StorefrontScore)}
+}
+
+// Record adds the outcome of a download from storefront to its score
+func (h *StorefrontHealth) Record(storefront string, success bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	score, ok := h.scores[storefront]
+	if !ok {
+		score = &StorefrontScore{Storefront: storefront, Score: 1}
+		h.scores[storefront] = score
+	}
+
+	outcome := 0.0
+	if success {
+		outcome = 1
+	}
+	score.Score = (1-storefrontEMAAlpha)*score.Score + storefrontEMAAlpha*outcome
+	score.Outcomes++
+}
+
+// Score returns the health of storefront, 1 when nothing was recorded
+func (h *StorefrontHealth) Score(storefront string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.scoreLocked(storefront)
+}
+
+func (h *StorefrontHealth) scoreLocked(storefront string) float64 {
+	if score, ok := h.scores[storefront]; ok {
+		return score.Score
+	}
+	return 1
+}
+
+// Order returns storefronts sorted from most to least healthy, keeping the
+// given order for equal scores. Every storefrontReprobeInterval calls the
+// least healthy one is moved to the front instead, so it is re-probed
+func (h *StorefrontHealth) Order(storefronts []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ordered := append([]string(nil), storefronts...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return h.scoreLocked(ordered[i]) > h.scoreLocked(ordered[j])
+	})
+
+	h.orderings++
+	if h.orderings%storefrontReprobeInterval == 0 && len(ordered) > 1 {
+		last := ordered[len(ordered)-1]
+		if h.scoreLocked(last) < h.scoreLocked(ordered[0]) {
+			copy(ordered[1:], ordered[:len(ordered)-1])
+			ordered[0] = last
+		}
+	}
+
+	return ordered
+}
+
+// Scores returns the recorded scores from most to least healthy
+func (h *StorefrontHealth) Scores() []StorefrontScore {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	scores := make([]StorefrontScore, 0, len(h.scores))
+	for _, score := range h.scores {
+		scores = append(scores, *score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Storefront < scores[j].Storefront
+	})
+	return scores
+}
+
+// IsStorefrontFailure reports whether err points at the storefront rather than
+// the bot: the song is region restricted, has no ALAC stream there, or its keys
+// could not be used. Another storefront may succeed where this one failed
+func IsStorefrontFailure(err error) bool {
+	return errors.Is(err, ErrNotInStorefront) || IsDownloadError(err, ErrorALACNotAvailable, ErrorDecryptionFailure)
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestStorefrontHealth_OrderFollowsOutcomes(t *testing.T) {
+	health := NewStorefrontHealth()
+
+	// Nothing recorded yet: every storefront is healthy and the given order is kept
+	if got := health.Order([]string{"gb", "jp", "de"}); !reflect.DeepEqual(got, []string{"gb", "jp", "de"}) {
+		t.Errorf("Order() = %v, want the given order", got)
+	}
+
+	outcomes := map[string][]bool{
+		"gb": {true, true, false, true},
+		"jp": {false, false, true, false},
+		"de": {true, true, true, true},
+	}
+	for _, storefront := range []string{"gb", "jp", "de"} {
+		for _, success := range outcomes[storefront] {
+			health.Record(storefront, success)
+		}
+	}
+
+	if got := health.Order([]string{"gb", "jp", "de"}); !reflect.DeepEqual(got, []string{"de", "gb", "jp"}) {
+		t.Errorf("Order() = %v, want [de gb jp]", got)
+	}
+
+	// Scores follow the moving average: 1 -> 0.7 -> 0.49 -> 0.643 -> 0.4501
+	if score := health.Score("jp"); math.Abs(score-0.4501) > 1e-9 {
+		t.Errorf("Score(jp) = %v, want 0.4501", score)
+	}
+	if score := health.Score("fr"); score != 1 {
+		t.Errorf("Score(fr) = %v, want 1 for an unknown storefront", score)
+	}
+
+	scores := health.Scores()
+	var names []string
+	for _, score := range scores {
+		names = append(names, score.Storefront)
+	}
+	if !reflect.DeepEqual(names, []string{"de", "gb", "jp"}) || scores[2].Outcomes != 4 {
+		t.Errorf("Scores() = %+v", scores)
+	}
+}
+
+func TestStorefrontHealth_DemotionAfterFailureBurst(t *testing.T) {
+	health := NewStorefrontHealth()
+	for i := 0; i < 20; i++ {
+		health.Record("gb", true)
+		health.Record("jp", true)
+	}
+
+	// gb was first, but a burst of key failures moves it behind jp
+	for i := 0; i < 3; i++ {
+		health.Record("gb", false)
+	}
+	if got := health.Order([]string{"gb", "jp"}); !reflect.DeepEqual(got, []string{"jp", "gb"}) {
+		t.Errorf("Order() after failure burst = %v, want [jp gb]", got)
+	}
+
+	// Successes bring it back
+	for i := 0; i < 10; i++ {
+		health.Record("gb", true)
+	}
+	health.Record("jp", false)
+	if got := health.Order([]string{"jp", "gb"}); !reflect.DeepEqual(got, []string{"gb", "jp"}) {
+		t.Errorf("Order() after recovery = %v, want [gb jp]", got)
+	}
+}
+
+func TestStorefrontHealth_PeriodicReprobe(t *testing.T) {
+	health := NewStorefrontHealth()
+	health.Record("gb", true)
+	health.Record("de", false)
+	health.Record("jp", false)
+	health.Record("jp", false)
+
+	for i := 1; i <= 2*storefrontReprobeInterval; i++ {
+		got := health.Order([]string{"jp", "de", "gb"})
+
+		want := []string{"gb", "de", "jp"}
+		if i%storefrontReprobeInterval == 0 {
+			want = []string{"jp", "gb", "de"} // The least healthy is re-probed first
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Order() call %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestStorefrontHealth_NoReprobeWhenAllEqual(t *testing.T) {
+	health := NewStorefrontHealth()
+	for i := 1; i <= storefrontReprobeInterval; i++ {
+		if got := health.Order([]string{"gb", "jp"}); !reflect.DeepEqual(got, []string{"gb", "jp"}) {
+			t.Errorf("Order() call %d = %v, want the given order", i, got)
+		}
+	}
+}
+
+func TestManager_StorefrontOrder(t *testing.T) {
+	manager := NewManager(0)
+	manager.SetFallbackStorefronts([]string{"gb", "us", "jp"})
+
+	// The requested storefront always comes first and is not repeated
+	if got := manager.StorefrontOrder("us"); !reflect.DeepEqual(got, []string{"us", "gb", "jp"}) {
+		t.Errorf("StorefrontOrder(us) = %v, want [us gb jp]", got)
+	}
+
+	manager.RecordStorefrontOutcome("gb", NewDownloadError(ErrorALACNotAvailable, "ALAC format not available"))
+	manager.RecordStorefrontOutcome("jp", nil)
+	manager.RecordStorefrontOutcome("jp", NewDownloadError(ErrorTokenUnavailable, "no token")) // Not the storefront's fault
+
+	if got := manager.StorefrontOrder("us"); !reflect.DeepEqual(got, []string{"us", "jp", "gb"}) {
+		t.Errorf("StorefrontOrder(us) = %v, want [us jp gb]", got)
+	}
+
+	var scores []string
+	for _, score := range manager.StorefrontScores() {
+		scores = append(scores, fmt.Sprintf("%s %.2f", score.Storefront, score.Score))
+	}
+	if !reflect.DeepEqual(scores, []string{"jp 1.00", "gb 0.70"}) {
+		t.Errorf("StorefrontScores() = %v", scores)
+	}
+}
+
+func TestIsStorefrontFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to get song metadata", ErrNotInStorefront), true},
+		{NewDownloadError(ErrorALACNotAvailable, "ALAC format not available"), true},
+		{NewDownloadError(ErrorDecryptionFailure, "failed to decrypt song"), true},
+		{NewDownloadError(ErrorNetworkFailure, "failed to download song data"), false},
+		{NewDownloadError(ErrorTokenUnavailable, "no token"), false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsStorefrontFailure(tt.err); got != tt.want {
+			t.Errorf("IsStorefrontFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package downloader
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -10,10 +11,16 @@ import (
 	"github.com/gotd/td/tg"
 )
 
+// unknownSongName is shown on the progress message until UpdateSongName
+// names the song
+const unknownSongName = "Looking up the song…"
+
 // TelegramAPI defines the interface for Telegram API operations needed by the progress reporter
+// and the handlers that report on a request
 type TelegramAPI interface {
 	MessagesSendMessage(ctx context.Context, request *tg.MessagesSendMessageRequest) (tg.UpdatesClass, error)
 	MessagesEditMessage(ctx context.Context, request *tg.MessagesEditMessageRequest) (tg.UpdatesClass, error)
+	MessagesSendReaction(ctx context.Context, request *tg.MessagesSendReactionRequest) (tg.UpdatesClass, error)
 }
 
 // TelegramProgressReporter implements ProgressReporter for Telegram message updates
@@ -25,16 +32,167 @@ type TelegramProgressReporter struct {
 	songName  string
 	isActive  bool
 	startTime time.Time
+	watchURL  string       // Optional link to a web progress page
+	attempt   string       // Shown above the progress of a retried request ("" = none)
+	note      string       // Optional note added to the completion message
+	fileSize  int64        // Size shown in the completion message (0 = not shown)
+	quality   string       // Audio quality shown in the completion message
+	resumeID  int          // Message StartTracking edits instead of sending a new one
+	replyTo   int          // Message the progress message replies to (0 = none)
+	pacer     *EditPacer   // Paces periodic edits with other reporters (nil = unpaced)
+	peers     PeerResolver // Builds the peer of the chat (nil = from the chat ID alone)
+	metrics   Metrics      // Counts failed edits
+
+	// Time spent in each phase, shown in the completion message
+	phases map[Phase]time.Duration
+
+	// Latest progress reported, shown again when the song is renamed
+	phase       Phase
+	progress    Progress
+	hasProgress bool
+
+	// Buttons of the progress message while the request runs and once it
+	// failed (nil = none)
+	activeMarkup tg.ReplyMarkupClass
+	failedMarkup tg.ReplyMarkupClass
+
+	// Edits are sent one at a time. A FLOOD_WAIT holds them back until it
+	// expires, keeping only the latest
+	editMu     sync.Mutex
+	lastEdit   string              // Content of the last edit, empty when unknown
+	lastMarkup tg.ReplyMarkupClass // Buttons of the last edit
+	floodUntil time.Time           // No edits before this
+	pending    *pendingEdit        // Latest edit held back by a FLOOD_WAIT
+	afterFunc  func(d time.Duration, f func())
+}
+
+// pendingEdit is an edit held back until a FLOOD_WAIT expires
+type pendingEdit struct {
+	chatID    int64
+	messageID int
+	message   string
+	markup    tg.ReplyMarkupClass
 }
 
 // NewTelegramProgressReporter creates a new TelegramProgressReporter
 func NewTelegramProgressReporter(api TelegramAPI) *TelegramProgressReporter {
 	return &TelegramProgressReporter{
-		api: api,
+		api:     api,
+		metrics: NoopMetrics{},
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// SetWatchURL adds a "watch live" link to the progress messages sent after this call
+func (tpr *TelegramProgressReporter) SetWatchURL(url string) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.watchURL = url
+}
+
+// SetEditRateController makes periodic edits ask controller for permission,
+// so they are paced together with those of other reporters. Must be called
+// before StartTracking
+func (tpr *TelegramProgressReporter) SetEditRateController(controller *EditRateController) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.pacer = controller.NewPacer()
+}
+
+// SetPeerResolver makes messages go to the peers resolver builds, with the
+// access hashes supergroups and channels need
+func (tpr *TelegramProgressReporter) SetPeerResolver(resolver PeerResolver) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.peers = resolver
+}
+
+// SetMetrics makes the reporter count its failed edits in metrics. Must be
+// called before StartTracking
+func (tpr *TelegramProgressReporter) SetMetrics(metrics Metrics) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.metrics = metrics
+}
+
+// SetReplyTo makes the progress message a reply to a message, such as the
+// command it reports on. It is sent without the reply when that message was
+// deleted
+func (tpr *TelegramProgressReporter) SetReplyTo(messageID int) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.replyTo = messageID
+}
+
+// SetControls adds buttons to the progress message: active ones while the
+// request runs and failed ones with its error. Completion and cancellation
+// remove them. Must be called before StartTracking
+func (tpr *TelegramProgressReporter) SetControls(active, failed tg.ReplyMarkupClass) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.activeMarkup = active
+	tpr.failedMarkup = failed
+}
+
+// SetCompletionNote adds a note below the completion message
+func (tpr *TelegramProgressReporter) SetCompletionNote(note string) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.note = note
+}
+
+// SetCompletionDetails adds the file size and audio quality to the completion
+// message. A zero size or empty quality is left out
+func (tpr *TelegramProgressReporter) SetCompletionDetails(fileSize int64, quality string) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.fileSize = fileSize
+	tpr.quality = quality
+}
+
+// SetPhaseDurations adds the time spent downloading, decrypting, writing and
+// uploading to the completion message
+func (tpr *TelegramProgressReporter) SetPhaseDurations(durations map[Phase]time.Duration) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.phases = make(map[Phase]time.Duration, len(durations))
+	for phase, d := range durations {
+		tpr.phases[phase] = d
+	}
+}
+
+// SetAttempt shows that the download is the attempt-th of attempts on the
+// progress messages, for requests retried after a transient failure. Nothing
+// is shown for the first attempt
+func (tpr *TelegramProgressReporter) SetAttempt(attempt, attempts int) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.attempt = ""
+	if attempt > 1 && attempt <= attempts {
+		tpr.attempt = fmt.Sprintf("⚠️ Attempt %d of %d…", attempt, attempts)
+	}
+}
+
+// withAttempt puts the attempt line above a progress message
+func withAttempt(message, attempt string) string {
+	if attempt == "" {
+		return message
 	}
+	return attempt + "\n" + message
+}
+
+// ResumeMessage makes the next StartTracking continue on an existing message,
+// e.g. the queue status message or one that asked the user how to go on
+func (tpr *TelegramProgressReporter) ResumeMessage(messageID int) {
+	tpr.mu.Lock()
+	defer tpr.mu.Unlock()
+	tpr.resumeID = messageID
 }
 
-// StartTracking begins progress tracking for a specific chat and song
+// StartTracking begins progress tracking for a specific chat and song. An
+// empty songName shows a placeholder until UpdateSongName names the song
 func (tpr *TelegramProgressReporter) StartTracking(ctx context.Context, chatID int64, songName string) error {
 	tpr.mu.Lock()
 	defer tpr.mu.Unlock()
@@ -43,20 +201,30 @@ func (tpr *TelegramProgressReporter) StartTracking(ctx context.Context, chatID i
 		return NewDownloadError(ErrorUnknown, "progress tracking is already active")
 	}
 
+	if songName == "" {
+		songName = unknownSongName
+	}
 	tpr.chatID = chatID
 	tpr.songName = songName
 	tpr.isActive = true
 	tpr.startTime = time.Now()
 	tpr.messageID = 0 // Will be set when first message is sent
-
-	// Send initial message - check if it's an upload (has 📤 emoji)
-	var initialMessage string
-	if strings.Contains(songName, "📤") {
-		initialMessage = fmt.Sprintf("🎵 **%s**\n\n⏳ Initializing upload...", songName)
-	} else {
-		initialMessage = fmt.Sprintf("🎵 **%s**\n\n⏳ Initializing download...", songName)
+	tpr.hasProgress = false
+	tpr.resetLastEdit()
+
+	initialMessage := withWatchLink(withAttempt(initialProgressMessage(songName), tpr.attempt), tpr.watchURL)
+	var messageID int
+	var err error
+	if tpr.resumeID != 0 {
+		messageID = tpr.resumeID
+		tpr.resumeID = 0
+		tpr.pacer.Force()
+		err = tpr.editMessageMarkup(ctx, chatID, messageID, initialMessage, tpr.activeMarkup)
+	}
+	if messageID == 0 || err != nil {
+		// A message that can no longer be edited is replaced by a new one
+		messageID, err = tpr.sendMessage(ctx, initialMessage)
 	}
-	messageID, err := tpr.sendMessage(ctx, initialMessage)
 	if err != nil {
 		tpr.isActive = false
 		return NewDownloadErrorWithCause(ErrorNetworkFailure, "failed to send initial progress message", err)
@@ -66,28 +234,73 @@ func (tpr *TelegramProgressReporter) StartTracking(ctx context.Context, chatID i
 	return nil
 }
 
+// initialProgressMessage is the progress message before any progress
+func initialProgressMessage(songName string) string {
+	return fmt.Sprintf("🎵 **%s**\n\n⏳ Initializing download...", songName)
+}
+
+// UpdateSongName names the song of the progress message once its metadata is
+// known, editing the message right away to show it. Empty names are ignored
+func (tpr *TelegramProgressReporter) UpdateSongName(name string) {
+	tpr.mu.Lock()
+	if !tpr.isActive || name == "" || name == tpr.songName {
+		tpr.mu.Unlock()
+		return
+	}
+	tpr.songName = name
+
+	chatID := tpr.chatID
+	messageID := tpr.messageID
+	message := initialProgressMessage(name)
+	if tpr.hasProgress {
+		message = tpr.formatProgressMessage(name, tpr.phase, tpr.progress, tpr.startTime)
+	}
+	message = withWatchLink(withAttempt(message, tpr.attempt), tpr.watchURL)
+	markup := tpr.activeMarkup
+	tpr.mu.Unlock()
+
+	if messageID == 0 || tpr.holdDuringFloodWait(chatID, messageID, message, markup) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tpr.pacer.Force()
+	tpr.editMessageMarkup(ctx, chatID, messageID, message, markup)
+}
+
 // UpdateProgress reports progress for the current phase
 func (tpr *TelegramProgressReporter) UpdateProgress(phase Phase, progress Progress) error {
-	tpr.mu.RLock()
+	tpr.mu.Lock()
 	if !tpr.isActive || tpr.messageID == 0 {
-		tpr.mu.RUnlock()
+		tpr.mu.Unlock()
 		return nil // Not active or no message to update
 	}
 
+	tpr.phase, tpr.progress, tpr.hasProgress = phase, progress, true
 	chatID := tpr.chatID
 	messageID := tpr.messageID
 	songName := tpr.songName
 	startTime := tpr.startTime
-	tpr.mu.RUnlock()
+	watchURL := tpr.watchURL
+	attempt := tpr.attempt
+	markup := tpr.activeMarkup
+	tpr.mu.Unlock()
 
 	// Format progress message
-	message := tpr.formatProgressMessage(songName, phase, progress, startTime)
+	message := withWatchLink(withAttempt(tpr.formatProgressMessage(songName, phase, progress, startTime), attempt), watchURL)
+
+	// Skip the update when edits are being paced; a later one shows newer progress
+	if tpr.holdDuringFloodWait(chatID, messageID, message, markup) || !tpr.pacer.Allow() {
+		return nil
+	}
 
 	// Update the message
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return tpr.editMessage(ctx, chatID, messageID, message)
+	return tpr.editMessageMarkup(ctx, chatID, messageID, message, markup)
 }
 
 // ReportPhaseChange reports a transition between phases
@@ -102,20 +315,29 @@ func (tpr *TelegramProgressReporter) ReportPhaseChange(oldPhase, newPhase Phase)
 	messageID := tpr.messageID
 	songName := tpr.songName
 	startTime := tpr.startTime
+	watchURL := tpr.watchURL
+	attempt := tpr.attempt
+	markup := tpr.activeMarkup
 	tpr.mu.RUnlock()
 
 	// Create phase transition message
-	message := fmt.Sprintf("🎵 **%s**\n\n%s %s\n\n⏱️ Elapsed: %s",
+	message := fmt.Sprintf("🎵 **%s**\n\n%s **%s**\n\n⏱️ Elapsed: %s",
 		songName,
 		tpr.getPhaseEmoji(newPhase),
 		tpr.getPhaseDescription(newPhase),
-		time.Since(startTime).Round(time.Second))
+		FormatDuration(Elapsed(startTime, time.Now())))
+	message = withWatchLink(withAttempt(message, attempt), watchURL)
+
+	// The next periodic update shows the new phase when this edit is held back
+	if tpr.holdDuringFloodWait(chatID, messageID, message, markup) || !tpr.pacer.Allow() {
+		return nil
+	}
 
 	// Update the message
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return tpr.editMessage(ctx, chatID, messageID, message)
+	return tpr.editMessageMarkup(ctx, chatID, messageID, message, markup)
 }
 
 // ReportError reports an error that occurred during processing
@@ -130,12 +352,13 @@ func (tpr *TelegramProgressReporter) ReportError(err error) error {
 	messageID := tpr.messageID
 	songName := tpr.songName
 	startTime := tpr.startTime
+	markup := tpr.failedMarkup
 	tpr.mu.RUnlock()
 
 	// Format error message
 	errorMsg := "An error occurred"
-	if downloadErr, ok := err.(*DownloadError); ok {
-		errorMsg = downloadErr.Message
+	if downloadErr, ok := AsDownloadError(err); ok {
+		errorMsg = downloadErr.UserMessage()
 	} else if err != nil {
 		errorMsg = err.Error()
 	}
@@ -143,15 +366,85 @@ func (tpr *TelegramProgressReporter) ReportError(err error) error {
 	message := fmt.Sprintf("🎵 **%s**\n\n❌ **Error**: %s\n\n⏱️ Elapsed: %s",
 		songName,
 		errorMsg,
-		time.Since(startTime).Round(time.Second))
+		FormatDuration(Elapsed(startTime, time.Now())))
 
 	// Update the message
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	tpr.pacer.Force()
+	return tpr.editMessageMarkup(ctx, chatID, messageID, message, markup)
+}
+
+// ReportCancelled implements CancellationReporter
+func (tpr *TelegramProgressReporter) ReportCancelled() error {
+	tpr.mu.RLock()
+	if !tpr.isActive {
+		tpr.mu.RUnlock()
+		return nil
+	}
+
+	chatID := tpr.chatID
+	messageID := tpr.messageID
+	songName := tpr.songName
+	startTime := tpr.startTime
+	tpr.mu.RUnlock()
+
+	message := fmt.Sprintf("🎵 **%s**\n\n❌ Cancelled by user\n\n⏱️ Elapsed: %s",
+		songName,
+		FormatDuration(Elapsed(startTime, time.Now())))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return tpr.editMessage(ctx, chatID, messageID, message)
+}
+
+// ReportInterrupted shows reason on the progress message, e.g. that the bot
+// is restarting and the download will start over
+func (tpr *TelegramProgressReporter) ReportInterrupted(reason string) error {
+	tpr.mu.RLock()
+	if !tpr.isActive {
+		tpr.mu.RUnlock()
+		return nil
+	}
+
+	chatID := tpr.chatID
+	messageID := tpr.messageID
+	songName := tpr.songName
+	tpr.mu.RUnlock()
+
+	message := fmt.Sprintf("🎵 **%s**\n\n%s", songName, reason)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	return tpr.editMessage(ctx, chatID, messageID, message)
 }
 
+// ReportChoices turns the progress message into message with the buttons of
+// markup and returns its ID. Progress updates sent afterwards remove the buttons
+func (tpr *TelegramProgressReporter) ReportChoices(message string, markup tg.ReplyMarkupClass) (int, error) {
+	tpr.mu.RLock()
+	if !tpr.isActive {
+		tpr.mu.RUnlock()
+		return 0, NewDownloadError(ErrorUnknown, "progress tracking is not active")
+	}
+
+	chatID := tpr.chatID
+	messageID := tpr.messageID
+	tpr.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tpr.pacer.Force()
+	if err := tpr.editMessageMarkup(ctx, chatID, messageID, message, markup); err != nil {
+		return 0, err
+	}
+	return messageID, nil
+}
+
 // ReportComplete reports successful completion with summary information
 func (tpr *TelegramProgressReporter) ReportComplete(duration time.Duration, filePath string) error {
 	tpr.mu.RLock()
@@ -163,18 +456,25 @@ func (tpr *TelegramProgressReporter) ReportComplete(duration time.Duration, file
 	chatID := tpr.chatID
 	messageID := tpr.messageID
 	songName := tpr.songName
+	note := tpr.note
+	details := completionDetails(tpr.fileSize, tpr.quality)
+	breakdown := tpr.phaseBreakdown(tpr.phases)
 	tpr.mu.RUnlock()
 
-	// Format completion message - check if it's an upload
-	var message string
-	if strings.Contains(songName, "📤") {
-		message = fmt.Sprintf("🎵 **%s**\n\n✅ **Upload Complete!**\n\n⏱️ Total time: %s",
-			songName,
-			duration.Round(time.Second))
-	} else {
-		message = fmt.Sprintf("🎵 **%s**\n\n✅ **Download Complete!**\n\n⏱️ Total time: %s\n📁 Ready for upload...",
-			songName,
-			duration.Round(time.Second))
+	message := fmt.Sprintf("🎵 **%s**\n\n✅ **Complete!**\n\n⏱️ Total time: %s",
+		songName,
+		duration.Round(time.Second))
+	if breakdown != "" {
+		message += "\n" + breakdown
+	}
+	if details != "" {
+		message += "\n" + details
+	}
+	if filePath != "" {
+		message += fmt.Sprintf("\n📁 Sent `%s`", filepath.Base(filePath))
+	}
+	if note != "" {
+		message += "\n\n" + note
 	}
 
 	// Update the message
@@ -193,77 +493,192 @@ func (tpr *TelegramProgressReporter) Stop() {
 	tpr.messageID = 0
 	tpr.chatID = 0
 	tpr.songName = ""
+	tpr.note = ""
+	tpr.fileSize = 0
+	tpr.quality = ""
+	tpr.phases = nil
 }
 
-// sendMessage sends a new message and returns the message ID
-func (tpr *TelegramProgressReporter) sendMessage(ctx context.Context, message string) (int, error) {
-	if tpr.api == nil {
-		return 0, NewDownloadError(ErrorUnknown, "telegram API is not initialized")
+// completionDetails formats the size and quality line of a completion message
+func completionDetails(fileSize int64, quality string) string {
+	var parts []string
+	if fileSize > 0 {
+		parts = append(parts, FormatBytes(fileSize))
+	}
+	if quality != "" {
+		parts = append(parts, quality)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "📦 " + strings.Join(parts, " · ")
+}
+
+// breakdownPhases are the phases of the completion message's timing line, in
+// the order they run
+var breakdownPhases = []Phase{PhaseDownloading, PhaseDecrypting, PhaseWriting, PhaseUploading}
+
+// phaseBreakdown formats the timing line of a completion message, leaving out
+// the phases that did not run
+func (tpr *TelegramProgressReporter) phaseBreakdown(durations map[Phase]time.Duration) string {
+	var parts []string
+	for _, phase := range breakdownPhases {
+		if d, ok := durations[phase]; ok {
+			parts = append(parts, tpr.getPhaseEmoji(phase)+" "+FormatDuration(d))
+		}
 	}
+	return strings.Join(parts, " • ")
+}
 
-	// Determine peer type based on chat ID
-	var peer tg.InputPeerClass
-	if tpr.chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: tpr.chatID}
-	} else {
-		peer = &tg.InputPeerChat{ChatID: -tpr.chatID}
+// inputPeer returns the peer of a chat, through the peer resolver when one
+// is set
+func (tpr *TelegramProgressReporter) inputPeer(chatID int64) tg.InputPeerClass {
+	if tpr.peers != nil {
+		return tpr.peers.InputPeer(chatID)
 	}
+	return ChatInputPeer(chatID, 0)
+}
 
-	request := &tg.MessagesSendMessageRequest{
-		Peer:     peer,
-		Message:  message,
-		RandomID: time.Now().UnixNano(),
+// sendMessage sends a new message with the active buttons and returns the
+// message ID (must be called with mu held)
+func (tpr *TelegramProgressReporter) sendMessage(ctx context.Context, message string) (int, error) {
+	if tpr.api == nil {
+		return 0, NewDownloadError(ErrorUnknown, "telegram API is not initialized")
 	}
 
-	updates, err := tpr.api.MessagesSendMessage(ctx, request)
+	peer := tpr.inputPeer(tpr.chatID)
+
+	var updates tg.UpdatesClass
+	tpr.pacer.Force()
+	err := SendReplying(tpr.replyTo, func(replyTo tg.InputReplyToClass) error {
+		return SendFormatted(message, func(text string, entities []tg.MessageEntityClass) error {
+			var err error
+			updates, err = tpr.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+				Peer:        peer,
+				Message:     text,
+				Entities:    entities,
+				ReplyTo:     replyTo,
+				ReplyMarkup: tpr.activeMarkup,
+				RandomID:    time.Now().UnixNano(),
+			})
+			return err
+		})
+	})
+	tpr.pacer.Result(err)
 	if err != nil {
 		return 0, err
 	}
 
-	// Extract message ID from updates
-	messageID := tpr.extractMessageID(updates)
-	return messageID, nil
+	return SentMessageID(updates), nil
 }
 
-// editMessage edits an existing message
+// editMessage edits an existing message, removing any buttons. The edit goes
+// out regardless of the pacing
 func (tpr *TelegramProgressReporter) editMessage(ctx context.Context, chatID int64, messageID int, message string) error {
+	tpr.pacer.Force()
+	return tpr.editMessageMarkup(ctx, chatID, messageID, message, nil)
+}
+
+// editMessageMarkup edits an existing message, setting its buttons to markup.
+// Edits that would not change the message are skipped, and those made during
+// a FLOOD_WAIT are held back so only the latest goes out once it expires
+func (tpr *TelegramProgressReporter) editMessageMarkup(ctx context.Context, chatID int64, messageID int, message string, markup tg.ReplyMarkupClass) error {
 	if tpr.api == nil {
 		return NewDownloadError(ErrorUnknown, "telegram API is not initialized")
 	}
 
-	// Determine peer type based on chat ID
-	var peer tg.InputPeerClass
-	if chatID > 0 {
-		peer = &tg.InputPeerUser{UserID: chatID}
-	} else {
-		peer = &tg.InputPeerChat{ChatID: -chatID}
+	tpr.editMu.Lock()
+	defer tpr.editMu.Unlock()
+
+	edit := &pendingEdit{chatID: chatID, messageID: messageID, message: message, markup: markup}
+	if time.Now().Before(tpr.floodUntil) {
+		tpr.pending = edit
+		return nil
 	}
+	return tpr.sendEditLocked(ctx, edit)
+}
 
-	request := &tg.MessagesEditMessageRequest{
-		Peer:    peer,
-		ID:      messageID,
-		Message: message,
+// sendEditLocked sends edit unless the message already shows it. A FLOOD_WAIT
+// holds the edit back and schedules it for when the wait expires (must be
+// called with editMu held)
+func (tpr *TelegramProgressReporter) sendEditLocked(ctx context.Context, edit *pendingEdit) error {
+	if edit.message == tpr.lastEdit && edit.markup == tpr.lastMarkup {
+		return nil
 	}
 
-	_, err := tpr.api.MessagesEditMessage(ctx, request)
-	return err
-}
+	peer := tpr.inputPeer(edit.chatID)
 
-// extractMessageID extracts the message ID from Telegram API updates
-func (tpr *TelegramProgressReporter) extractMessageID(updates tg.UpdatesClass) int {
-	switch u := updates.(type) {
-	case *tg.Updates:
-		for _, update := range u.Updates {
-			if msgUpdate, ok := update.(*tg.UpdateNewMessage); ok {
-				if msg, ok := msgUpdate.Message.(*tg.Message); ok {
-					return msg.ID
-				}
-			}
+	err := SendFormatted(edit.message, func(text string, entities []tg.MessageEntityClass) error {
+		request := &tg.MessagesEditMessageRequest{
+			Peer:     peer,
+			ID:       edit.messageID,
+			Message:  text,
+			Entities: entities,
+		}
+		if edit.markup != nil {
+			request.SetReplyMarkup(edit.markup)
 		}
-	case *tg.UpdateShortSentMessage:
-		return u.ID
+		_, err := tpr.api.MessagesEditMessage(ctx, request)
+		return err
+	})
+	tpr.pacer.Result(err)
+	if err != nil && !IsMessageNotModified(err) {
+		tpr.metrics.EditFailed()
 	}
-	return 0
+
+	if wait, ok := IsFloodWait(err); ok {
+		tpr.floodUntil = time.Now().Add(wait)
+		tpr.pending = edit
+		tpr.afterFunc(wait, tpr.flushPendingEdit)
+		return nil
+	}
+	if err != nil && !IsMessageNotModified(err) {
+		return err
+	}
+
+	tpr.lastEdit = edit.message
+	tpr.lastMarkup = edit.markup
+	return nil
+}
+
+// holdDuringFloodWait makes message with markup the edit sent once the
+// current FLOOD_WAIT expires, returning false when there is none
+func (tpr *TelegramProgressReporter) holdDuringFloodWait(chatID int64, messageID int, message string, markup tg.ReplyMarkupClass) bool {
+	tpr.editMu.Lock()
+	defer tpr.editMu.Unlock()
+
+	if !time.Now().Before(tpr.floodUntil) {
+		return false
+	}
+	tpr.pending = &pendingEdit{chatID: chatID, messageID: messageID, message: message, markup: markup}
+	return true
+}
+
+// flushPendingEdit sends the edit held back by a FLOOD_WAIT that has expired
+func (tpr *TelegramProgressReporter) flushPendingEdit() {
+	tpr.editMu.Lock()
+	defer tpr.editMu.Unlock()
+
+	tpr.floodUntil = time.Time{}
+	edit := tpr.pending
+	tpr.pending = nil
+	if edit == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tpr.pacer.Force()
+	tpr.sendEditLocked(ctx, edit)
+}
+
+// resetLastEdit forgets the content of the last edit, e.g. for a new message
+func (tpr *TelegramProgressReporter) resetLastEdit() {
+	tpr.editMu.Lock()
+	defer tpr.editMu.Unlock()
+	tpr.lastEdit = ""
+	tpr.lastMarkup = nil
 }
 
 // formatProgressMessage formats a progress update message
@@ -274,7 +689,7 @@ func (tpr *TelegramProgressReporter) formatProgressMessage(songName string, phas
 	builder.WriteString(fmt.Sprintf("🎵 **%s**\n\n", songName))
 
 	// Phase indicator
-	builder.WriteString(fmt.Sprintf("%s %s\n\n", tpr.getPhaseEmoji(phase), tpr.getPhaseDescription(phase)))
+	builder.WriteString(fmt.Sprintf("%s **%s**\n\n", tpr.getPhaseEmoji(phase), tpr.getPhaseDescription(phase)))
 
 	// Progress bar and percentage
 	if progress.TotalBytes > 0 {
@@ -283,25 +698,38 @@ func (tpr *TelegramProgressReporter) formatProgressMessage(songName string, phas
 
 		// File size info
 		builder.WriteString(fmt.Sprintf("📦 %s / %s\n",
-			tpr.formatBytes(progress.BytesProcessed),
-			tpr.formatBytes(progress.TotalBytes)))
+			FormatBytes(progress.BytesProcessed),
+			FormatBytes(progress.TotalBytes)))
 
 		// Speed and ETA
 		if progress.Speed > 0 {
-			builder.WriteString(fmt.Sprintf("⚡ %s/s", tpr.formatBytes(progress.Speed)))
+			builder.WriteString(fmt.Sprintf("⚡ %s/s", FormatBytes(progress.Speed)))
 			if progress.ETA > 0 {
-				builder.WriteString(fmt.Sprintf(" • ETA: %s", progress.ETA.Round(time.Second)))
+				builder.WriteString(fmt.Sprintf(" • ETA: %s", FormatDuration(progress.ETA)))
 			}
 			builder.WriteString("\n")
 		}
+	} else if progress.Speed > 0 {
+		// Without a total size only the transfer itself can be shown
+		builder.WriteString(fmt.Sprintf("📦 %s • ⚡ %s/s\n",
+			FormatBytes(progress.BytesProcessed),
+			FormatBytes(progress.Speed)))
 	}
 
 	// Elapsed time
-	builder.WriteString(fmt.Sprintf("\n⏱️ Elapsed: %s", time.Since(startTime).Round(time.Second)))
+	builder.WriteString(fmt.Sprintf("\n⏱️ Elapsed: %s", FormatDuration(Elapsed(startTime, time.Now()))))
 
 	return builder.String()
 }
 
+// withWatchLink appends the web progress page link to a message, if there is one
+func withWatchLink(message, watchURL string) string {
+	if watchURL == "" {
+		return message
+	}
+	return message + "\n\n👀 Watch live: " + watchURL
+}
+
 // createProgressBar creates a visual progress bar
 func (tpr *TelegramProgressReporter) createProgressBar(percentage float64, length int) string {
 	if percentage < 0 {
@@ -317,8 +745,9 @@ func (tpr *TelegramProgressReporter) createProgressBar(percentage float64, lengt
 	return strings.Repeat("█", filled) + strings.Repeat("░", empty)
 }
 
-// formatBytes formats byte count into human-readable format
-func (tpr *TelegramProgressReporter) formatBytes(bytes int64) string {
+// FormatBytes formats a byte count as B, KB, MB, GB or TB (powers of 1024)
+// with one decimal, as sizes are shown to users
+func FormatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
 		return fmt.Sprintf("%d B", bytes)
@@ -351,6 +780,8 @@ func (tpr *TelegramProgressReporter) getPhaseEmoji(phase Phase) string {
 		return "✅"
 	case PhaseError:
 		return "❌"
+	case PhaseWaitingForMemory, PhaseWaitingForDecrypt:
+		return "⏳"
 	default:
 		return "⏳"
 	}
@@ -373,6 +804,10 @@ func (tpr *TelegramProgressReporter) getPhaseDescription(phase Phase) string {
 		return "Upload complete!"
 	case PhaseError:
 		return "Error occurred"
+	case PhaseWaitingForMemory:
+		return "Waiting for memory (other downloads are running)..."
+	case PhaseWaitingForDecrypt:
+		return "Waiting for decryption (another song is being decrypted)..."
 	default:
 		return "Processing..."
 	}
@@ -399,5 +834,5 @@ func (tpr *TelegramProgressReporter) GetElapsedTime() time.Duration {
 	if !tpr.isActive {
 		return 0
 	}
-	return time.Since(tpr.startTime)
+	return Elapsed(tpr.startTime, time.Now())
 }
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
 // MockTelegramAPI is a mock implementation of TelegramAPI for testing
@@ -16,11 +17,14 @@ type MockTelegramAPI struct {
 	mu                    sync.RWMutex
 	sendMessageCalls      []SendMessageCall
 	editMessageCalls      []EditMessageCall
+	sendReactionCalls     []SendReactionCall
 	shouldFailSend        bool
 	shouldFailEdit        bool
+	shouldFailReaction    bool
 	nextMessageID         int
 	sendMessageError      error
 	editMessageError      error
+	sendReactionError     error
 }
 
 type SendMessageCall struct {
@@ -31,6 +35,10 @@ type EditMessageCall struct {
 	Request *tg.MessagesEditMessageRequest
 }
 
+type SendReactionCall struct {
+	Request *tg.MessagesSendReactionRequest
+}
+
 func NewMockTelegramAPI() *MockTelegramAPI {
 	return &MockTelegramAPI{
 		nextMessageID: 1,
@@ -84,6 +92,28 @@ func (m *MockTelegramAPI) MessagesEditMessage(ctx context.Context, request *tg.M
 	}, nil
 }
 
+func (m *MockTelegramAPI) MessagesSendReaction(ctx context.Context, request *tg.MessagesSendReactionRequest) (tg.UpdatesClass, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	m.sendReactionCalls = append(m.sendReactionCalls, SendReactionCall{Request: request})
+	
+	if m.shouldFailReaction {
+		if m.sendReactionError != nil {
+			return nil, m.sendReactionError
+		}
+		return nil, fmt.Errorf("mock send reaction error")
+	}
+	
+	return &tg.Updates{
+		Updates: []tg.UpdateClass{},
+		Users:   []tg.UserClass{},
+		Chats:   []tg.ChatClass{},
+		Date:    int(time.Now().Unix()),
+		Seq:     1,
+	}, nil
+}
+
 func (m *MockTelegramAPI) GetSendMessageCalls() []SendMessageCall {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -100,6 +130,14 @@ func (m *MockTelegramAPI) GetEditMessageCalls() []EditMessageCall {
 	return calls
 }
 
+func (m *MockTelegramAPI) GetSendReactionCalls() []SendReactionCall {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	calls := make([]SendReactionCall, len(m.sendReactionCalls))
+	copy(calls, m.sendReactionCalls)
+	return calls
+}
+
 func (m *MockTelegramAPI) SetShouldFailSend(fail bool, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -114,13 +152,22 @@ func (m *MockTelegramAPI) SetShouldFailEdit(fail bool, err error) {
 	m.editMessageError = err
 }
 
+func (m *MockTelegramAPI) SetShouldFailReaction(fail bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shouldFailReaction = fail
+	m.sendReactionError = err
+}
+
 func (m *MockTelegramAPI) Reset() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.sendMessageCalls = nil
 	m.editMessageCalls = nil
+	m.sendReactionCalls = nil
 	m.shouldFailSend = false
 	m.shouldFailEdit = false
+	m.shouldFailReaction = false
 	m.nextMessageID = 1
 	m.sendMessageError = nil
 	m.editMessageError = nil
@@ -202,6 +249,65 @@ func TestTelegramProgressReporter_StartTrackingWithSendError(t *testing.T) {
 	}
 }
 
+func TestTelegramProgressReporter_ResumeFallsBackToNewMessage(t *testing.T) {
+	api := NewMockTelegramAPI()
+	api.SetShouldFailEdit(true, tgerr.New(400, "MESSAGE_ID_INVALID"))
+	reporter := NewTelegramProgressReporter(api)
+	reporter.ResumeMessage(42)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	defer reporter.Stop()
+
+	if edits := api.GetEditMessageCalls(); len(edits) != 1 || edits[0].Request.ID != 42 {
+		t.Errorf("Expected the resumed message to be tried first, got %+v", edits)
+	}
+	if sends := api.GetSendMessageCalls(); len(sends) != 1 {
+		t.Errorf("Expected a new message once the edit failed, got %d sends", len(sends))
+	}
+	if reporter.messageID == 42 {
+		t.Error("Expected the progress to go on in the new message")
+	}
+}
+
+func TestTelegramProgressReporter_UpdateSongName(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+
+	if err := reporter.StartTracking(context.Background(), 12345, ""); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if sends := api.GetSendMessageCalls(); len(sends) != 1 || !strings.Contains(sends[0].Request.Message, unknownSongName) {
+		t.Fatalf("Expected the placeholder name before the song is known, got %+v", sends)
+	}
+
+	// The name is shown right away, with the latest progress
+	reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 512, TotalBytes: 1024, Percentage: 50})
+	reporter.UpdateSongName("Song - Artist")
+	reporter.UpdateSongName("Song - Artist")
+	reporter.UpdateSongName("")
+
+	edits := api.GetEditMessageCalls()
+	if len(edits) != 2 {
+		t.Fatalf("Expected the progress and one rename edit, got %d", len(edits))
+	}
+	if message := edits[1].Request.Message; !strings.HasPrefix(message, "🎵 Song - Artist\n") || !strings.Contains(message, "50.0%") {
+		t.Errorf("Expected the real title above the progress, got %q", message)
+	}
+
+	if err := reporter.ReportComplete(time.Minute, "downloads/1 - Song - Artist.m4a"); err != nil {
+		t.Fatalf("Failed to report completion: %v", err)
+	}
+	message := api.GetEditMessageCalls()[2].Request.Message
+	if !strings.HasPrefix(message, "🎵 Song - Artist\n") {
+		t.Errorf("Expected the song name in the completion message, got %q", message)
+	}
+	if !strings.Contains(message, "📁 Sent 1 - Song - Artist.m4a") {
+		t.Errorf("Expected the sent file in the completion message, got %q", message)
+	}
+}
+
 func TestTelegramProgressReporter_UpdateProgress(t *testing.T) {
 	api := NewMockTelegramAPI()
 	reporter := NewTelegramProgressReporter(api)
@@ -322,8 +428,76 @@ func TestTelegramProgressReporter_ReportError(t *testing.T) {
 		t.Error("Error message should contain error emoji")
 	}
 	
-	if !strings.Contains(message, "Connection failed") {
-		t.Error("Error message should contain error description")
+	if !strings.Contains(message, "Apple Music couldn't be reached") || strings.Contains(message, "Connection failed") {
+		t.Error("Error message should contain the user-facing error description")
+	}
+}
+
+func TestTelegramProgressReporter_ReportCancelled(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if err := reporter.ReportCancelled(); err != nil {
+		t.Fatalf("Failed to report cancellation: %v", err)
+	}
+
+	editCalls := api.GetEditMessageCalls()
+	if len(editCalls) != 1 {
+		t.Fatalf("Expected 1 edit message call, got %d", len(editCalls))
+	}
+	message := editCalls[0].Request.Message
+	if !strings.Contains(message, "❌ Cancelled by user") || strings.Contains(message, "Error") {
+		t.Errorf("Expected a cancellation instead of an error, got %q", message)
+	}
+}
+
+func TestTelegramProgressReporter_Controls(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	active := &tg.ReplyInlineMarkup{}
+	failed := &tg.ReplyInlineMarkup{}
+	reporter.SetControls(active, failed)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if err := reporter.ReportPhaseChange(PhaseValidating, PhaseDownloading); err != nil {
+		t.Fatalf("Failed to report phase change: %v", err)
+	}
+	if err := reporter.ReportError(NewDownloadError(ErrorNetworkFailure, "Connection failed")); err != nil {
+		t.Fatalf("Failed to report error: %v", err)
+	}
+
+	sendCalls := api.GetSendMessageCalls()
+	if len(sendCalls) != 1 || sendCalls[0].Request.ReplyMarkup != active {
+		t.Errorf("Expected the progress message to be sent with the active buttons")
+	}
+	editCalls := api.GetEditMessageCalls()
+	if len(editCalls) != 2 {
+		t.Fatalf("Expected 2 edit message calls, got %d", len(editCalls))
+	}
+	if editCalls[0].Request.ReplyMarkup != active {
+		t.Error("Expected progress edits to keep the active buttons")
+	}
+	if editCalls[1].Request.ReplyMarkup != failed {
+		t.Error("Expected the error to show the failed buttons")
+	}
+
+	// Cancelled downloads have nothing left to press
+	reporter = NewTelegramProgressReporter(api)
+	reporter.SetControls(active, failed)
+	api.Reset()
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if err := reporter.ReportCancelled(); err != nil {
+		t.Fatalf("Failed to report cancellation: %v", err)
+	}
+	if editCalls := api.GetEditMessageCalls(); len(editCalls) != 1 || editCalls[0].Request.ReplyMarkup != nil {
+		t.Error("Expected the cancellation to remove the buttons")
 	}
 }
 
@@ -367,6 +541,77 @@ func TestTelegramProgressReporter_ReportComplete(t *testing.T) {
 	}
 }
 
+func TestTelegramProgressReporter_CompletionDetails(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+
+	reporter.SetCompletionDetails(45*1024*1024, "24-bit / 96 kHz ALAC")
+	if err := reporter.ReportComplete(time.Minute, "song.m4a"); err != nil {
+		t.Fatalf("Failed to report completion: %v", err)
+	}
+
+	editCalls := api.GetEditMessageCalls()
+	if len(editCalls) != 1 {
+		t.Fatalf("Expected 1 edit message call, got %d", len(editCalls))
+	}
+	if message := editCalls[0].Request.Message; !strings.Contains(message, "📦 45.0 MB · 24-bit / 96 kHz ALAC") {
+		t.Errorf("Expected the size and quality in the completion message, got %q", message)
+	}
+}
+
+func TestTelegramProgressReporter_PhaseDurations(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+
+	reporter.SetPhaseDurations(map[Phase]time.Duration{
+		PhaseValidating:  time.Second,
+		PhaseDownloading: 12 * time.Second,
+		PhaseDecrypting:  8*time.Second + 400*time.Millisecond,
+		PhaseWriting:     time.Second,
+		PhaseUploading:   20 * time.Second,
+	})
+	if err := reporter.ReportComplete(time.Minute, "song.m4a"); err != nil {
+		t.Fatalf("Failed to report completion: %v", err)
+	}
+
+	editCalls := api.GetEditMessageCalls()
+	if len(editCalls) != 1 {
+		t.Fatalf("Expected 1 edit message call, got %d", len(editCalls))
+	}
+	want := "⏱️ Total time: 1m0s\n⬇️ 12s • 🔓 8s • 💾 1s • 📤 20s"
+	if message := editCalls[0].Request.Message; !strings.Contains(message, want) {
+		t.Errorf("Expected %q in the completion message, got %q", want, message)
+	}
+}
+
+func TestTelegramProgressReporter_PhaseBreakdown(t *testing.T) {
+	reporter := NewTelegramProgressReporter(NewMockTelegramAPI())
+
+	tests := []struct {
+		name      string
+		durations map[Phase]time.Duration
+		want      string
+	}{
+		{"none", nil, ""},
+		{"cached", map[Phase]time.Duration{PhaseValidating: time.Second}, ""},
+		{"upload only", map[Phase]time.Duration{PhaseUploading: 1500 * time.Millisecond}, "📤 2s"},
+		{"skips missing", map[Phase]time.Duration{PhaseUploading: time.Minute, PhaseDownloading: 90 * time.Second}, "⬇️ 1m30s • 📤 1m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reporter.phaseBreakdown(tt.durations); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
 func TestTelegramProgressReporter_Stop(t *testing.T) {
 	api := NewMockTelegramAPI()
 	reporter := NewTelegramProgressReporter(api)
@@ -394,10 +639,7 @@ func TestTelegramProgressReporter_Stop(t *testing.T) {
 	}
 }
 
-func TestTelegramProgressReporter_FormatBytes(t *testing.T) {
-	api := NewMockTelegramAPI()
-	reporter := NewTelegramProgressReporter(api)
-	
+func TestFormatBytes(t *testing.T) {
 	tests := []struct {
 		bytes    int64
 		expected string
@@ -412,9 +654,9 @@ func TestTelegramProgressReporter_FormatBytes(t *testing.T) {
 	}
 	
 	for _, test := range tests {
-		result := reporter.formatBytes(test.bytes)
+		result := FormatBytes(test.bytes)
 		if result != test.expected {
-			t.Errorf("formatBytes(%d) = %s, expected %s", test.bytes, result, test.expected)
+			t.Errorf("FormatBytes(%d) = %s, expected %s", test.bytes, result, test.expected)
 		}
 	}
 }
@@ -458,6 +700,7 @@ func TestTelegramProgressReporter_GetPhaseEmoji(t *testing.T) {
 		{PhaseWriting, "💾"},
 		{PhaseComplete, "✅"},
 		{PhaseError, "❌"},
+		{PhaseWaitingForMemory, "⏳"},
 		{Phase(-1), "⏳"}, // Unknown phase
 	}
 	
@@ -483,6 +726,7 @@ func TestTelegramProgressReporter_GetPhaseDescription(t *testing.T) {
 		{PhaseWriting, "Writing file..."},
 		{PhaseComplete, "Download complete!"},
 		{PhaseError, "Error occurred"},
+		{PhaseWaitingForMemory, "Waiting for memory (other downloads are running)..."},
 		{Phase(-1), "Processing..."}, // Unknown phase
 	}
 	
@@ -494,35 +738,25 @@ func TestTelegramProgressReporter_GetPhaseDescription(t *testing.T) {
 	}
 }
 
-func TestTelegramProgressReporter_ExtractMessageID(t *testing.T) {
-	api := NewMockTelegramAPI()
-	reporter := NewTelegramProgressReporter(api)
-	
-	// Test UpdateShortSentMessage
-	shortSent := &tg.UpdateShortSentMessage{ID: 123}
-	messageID := reporter.extractMessageID(shortSent)
-	if messageID != 123 {
-		t.Errorf("Expected message ID 123, got %d", messageID)
-	}
-	
-	// Test Updates with UpdateNewMessage
-	updates := &tg.Updates{
-		Updates: []tg.UpdateClass{
-			&tg.UpdateNewMessage{
-				Message: &tg.Message{ID: 456},
-			},
-		},
-	}
-	messageID = reporter.extractMessageID(updates)
-	if messageID != 456 {
-		t.Errorf("Expected message ID 456, got %d", messageID)
+func TestSentMessageID(t *testing.T) {
+	testCases := []struct {
+		name    string
+		updates tg.UpdatesClass
+		want    int
+	}{
+		{"short sent message", &tg.UpdateShortSentMessage{ID: 123}, 123},
+		{"new message", &tg.Updates{Updates: []tg.UpdateClass{&tg.UpdateNewMessage{Message: &tg.Message{ID: 456}}}}, 456},
+		{"channel message", &tg.Updates{Updates: []tg.UpdateClass{
+			&tg.UpdateMessageID{ID: 789},
+			&tg.UpdateNewChannelMessage{Message: &tg.Message{ID: 789}},
+		}}, 789},
+		{"empty updates", &tg.Updates{}, 0},
 	}
-	
-	// Test empty updates
-	emptyUpdates := &tg.Updates{Updates: []tg.UpdateClass{}}
-	messageID = reporter.extractMessageID(emptyUpdates)
-	if messageID != 0 {
-		t.Errorf("Expected message ID 0 for empty updates, got %d", messageID)
+
+	for _, tc := range testCases {
+		if got := SentMessageID(tc.updates); got != tc.want {
+			t.Errorf("%s: SentMessageID() = %d, want %d", tc.name, got, tc.want)
+		}
 	}
 }
 
@@ -572,4 +806,179 @@ func TestTelegramProgressReporter_ConcurrentOperations(t *testing.T) {
 	}
 	
 	reporter.Stop()
-}
\ No newline at end of file
+}
+func TestTelegramProgressReporter_WatchURL(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	watchURL := "https://bot.example.com/p/abc"
+	reporter.SetWatchURL(watchURL)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if err := reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 50, TotalBytes: 100, Percentage: 50}); err != nil {
+		t.Fatalf("Failed to update progress: %v", err)
+	}
+	if err := reporter.ReportPhaseChange(PhaseDownloading, PhaseDecrypting); err != nil {
+		t.Fatalf("Failed to report phase change: %v", err)
+	}
+
+	if !strings.Contains(api.GetSendMessageCalls()[0].Request.Message, "Watch live: "+watchURL) {
+		t.Error("Initial message should contain the watch link")
+	}
+	for i, call := range api.GetEditMessageCalls() {
+		if !strings.Contains(call.Request.Message, "Watch live: "+watchURL) {
+			t.Errorf("Edit %d should contain the watch link, got %q", i, call.Request.Message)
+		}
+	}
+	reporter.Stop()
+}
+
+func TestTelegramProgressReporter_Attempt(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	reporter.SetAttempt(2, 3)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	if err := reporter.UpdateProgress(PhaseDownloading, Progress{BytesProcessed: 50, TotalBytes: 100, Percentage: 50}); err != nil {
+		t.Fatalf("Failed to update progress: %v", err)
+	}
+	if err := reporter.ReportPhaseChange(PhaseDownloading, PhaseDecrypting); err != nil {
+		t.Fatalf("Failed to report phase change: %v", err)
+	}
+
+	if message := api.GetSendMessageCalls()[0].Request.Message; !strings.HasPrefix(message, "⚠️ Attempt 2 of 3…\n") {
+		t.Errorf("Initial message should start with the attempt, got %q", message)
+	}
+	for i, call := range api.GetEditMessageCalls() {
+		if !strings.HasPrefix(call.Request.Message, "⚠️ Attempt 2 of 3…\n") {
+			t.Errorf("Edit %d should start with the attempt, got %q", i, call.Request.Message)
+		}
+	}
+	reporter.Stop()
+
+	// The first attempt is not worth a mention
+	first := NewTelegramProgressReporter(NewMockTelegramAPI())
+	first.SetAttempt(1, 3)
+	if first.attempt != "" {
+		t.Errorf("Expected no attempt line for the first attempt, got %q", first.attempt)
+	}
+}
+
+func TestTelegramProgressReporter_ChoicesAndResume(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	markup := &tg.ReplyInlineMarkup{Rows: []tg.KeyboardButtonRow{{Buttons: []tg.KeyboardButtonClass{
+		&tg.KeyboardButtonCallback{Text: "Cancel", Data: []byte("ovr:x:cancel")},
+	}}}}
+	messageID, err := reporter.ReportChoices("Choose a store", markup)
+	if err != nil {
+		t.Fatalf("Failed to report choices: %v", err)
+	}
+	reporter.Stop()
+
+	edits := api.GetEditMessageCalls()
+	if len(edits) != 1 || edits[0].Request.ID != messageID || edits[0].Request.ReplyMarkup != markup {
+		t.Fatalf("Expected the progress message to get the buttons, got %+v", edits)
+	}
+
+	// A resumed download continues on the prompt message and drops its buttons
+	resumed := NewTelegramProgressReporter(api)
+	resumed.ResumeMessage(messageID)
+	if err := resumed.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+	defer resumed.Stop()
+
+	if sends := api.GetSendMessageCalls(); len(sends) != 1 {
+		t.Errorf("Expected no new message when resuming, got %d sends", len(sends))
+	}
+	edits = api.GetEditMessageCalls()
+	if len(edits) != 2 || edits[1].Request.ID != messageID || edits[1].Request.ReplyMarkup != nil {
+		t.Errorf("Expected the prompt message to be edited without buttons, got %+v", edits)
+	}
+}
+
+func TestTelegramProgressReporter_SkipsUnchangedEdits(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+
+	progress := Progress{BytesProcessed: 1024, TotalBytes: 2048, Percentage: 50.0}
+	reporter.mu.Lock()
+	reporter.startTime = time.Now().Add(-time.Minute) // Keeps the elapsed time fixed
+	reporter.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if err := reporter.UpdateProgress(PhaseDownloading, progress); err != nil {
+			t.Fatalf("UpdateProgress() error = %v", err)
+		}
+	}
+	if calls := len(api.GetEditMessageCalls()); calls != 1 {
+		t.Errorf("Expected identical updates to be sent once, got %d edits", calls)
+	}
+
+	// Telegram rejecting an unchanged edit is not an error
+	api.SetShouldFailEdit(true, &tgerr.Error{Code: 400, Message: "MESSAGE_NOT_MODIFIED", Type: "MESSAGE_NOT_MODIFIED"})
+	progress.Percentage = 75.0
+	if err := reporter.UpdateProgress(PhaseDownloading, progress); err != nil {
+		t.Errorf("Expected MESSAGE_NOT_MODIFIED to be ignored, got %v", err)
+	}
+}
+
+func TestTelegramProgressReporter_FloodWait(t *testing.T) {
+	api := NewMockTelegramAPI()
+	reporter := NewTelegramProgressReporter(api)
+
+	var waits []time.Duration
+	var flush func()
+	reporter.afterFunc = func(d time.Duration, f func()) {
+		waits = append(waits, d)
+		flush = f
+	}
+
+	if err := reporter.StartTracking(context.Background(), 12345, "Test Song"); err != nil {
+		t.Fatalf("Failed to start tracking: %v", err)
+	}
+
+	api.SetShouldFailEdit(true, &tgerr.Error{Code: 420, Message: "FLOOD_WAIT_30", Type: tgerr.ErrFloodWait, Argument: 30})
+	if err := reporter.UpdateProgress(PhaseDownloading, Progress{Percentage: 10}); err != nil {
+		t.Fatalf("Expected the edit to be held back, got %v", err)
+	}
+	if len(waits) != 1 || waits[0] != 30*time.Second {
+		t.Fatalf("Expected a retry after the 30s wait, got %v", waits)
+	}
+	api.Reset()
+
+	// Updates during the wait are coalesced; none reaches Telegram
+	reporter.UpdateProgress(PhaseDownloading, Progress{Percentage: 20})
+	reporter.ReportPhaseChange(PhaseDownloading, PhaseDecrypting)
+	if err := reporter.ReportComplete(time.Minute, "song.m4a"); err != nil {
+		t.Fatalf("Expected the completion to be held back, got %v", err)
+	}
+	if calls := len(api.GetEditMessageCalls()); calls != 0 {
+		t.Fatalf("Expected no edits during the wait, got %d", calls)
+	}
+
+	flush()
+	editCalls := api.GetEditMessageCalls()
+	if len(editCalls) != 1 {
+		t.Fatalf("Expected only the latest edit once the wait expired, got %d", len(editCalls))
+	}
+	if !strings.Contains(editCalls[0].Request.Message, "Complete!") {
+		t.Errorf("Expected the completion message, got %q", editCalls[0].Request.Message)
+	}
+
+	// Edits go straight out again
+	reporter.UpdateProgress(PhaseDownloading, Progress{Percentage: 30})
+	if calls := len(api.GetEditMessageCalls()); calls != 2 {
+		t.Errorf("Expected edits to resume after the wait, got %d", calls)
+	}
+}
@@ -0,0 +1,165 @@
+package downloader
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// Telegram RPC error types the bot reacts to. These are the Type values gotd
+// extracts from the RPC error message (numeric arguments stripped).
+const (
+	TgErrFloodWait               = tgerr.ErrFloodWait
+	TgErrFloodPremiumWait        = tgerr.ErrPremiumFloodWait
+	TgErrMessageNotModified      = "MESSAGE_NOT_MODIFIED"
+	TgErrMessageIDInvalid        = "MESSAGE_ID_INVALID"
+	TgErrReplyMessageIDInvalid   = "REPLY_MESSAGE_ID_INVALID"
+	TgErrReplyToInvalid          = "REPLY_TO_INVALID"
+	TgErrEntityBoundsInvalid     = "ENTITY_BOUNDS_INVALID"
+	TgErrEntitiesTooLong         = "ENTITIES_TOO_LONG"
+	TgErrEntityTextURLInvalid    = "ENTITY_TEXTURL_INVALID"
+	TgErrUsernameNotOccupied     = "USERNAME_NOT_OCCUPIED"
+	TgErrUsernameInvalid         = "USERNAME_INVALID"
+	TgErrPeerIDInvalid           = "PEER_ID_INVALID"
+	TgErrChannelInvalid          = "CHANNEL_INVALID"
+	TgErrChatIDInvalid           = "CHAT_ID_INVALID"
+	TgErrUserIDInvalid           = "USER_ID_INVALID"
+	TgErrChatSendMediaForbidden  = "CHAT_SEND_MEDIA_FORBIDDEN"
+	TgErrChatSendAudiosForbidden = "CHAT_SEND_AUDIOS_FORBIDDEN"
+	TgErrChatWriteForbidden      = "CHAT_WRITE_FORBIDDEN"
+	TgErrFileReferenceExpired    = "FILE_REFERENCE_EXPIRED"
+	TgErrFileReferenceInvalid    = "FILE_REFERENCE_INVALID"
+	TgErrFilePartMissing         = "FILE_PART_MISSING"
+	TgErrFilePartsInvalid        = "FILE_PARTS_INVALID"
+)
+
+// Telegram RPC error codes the bot reacts to
+const (
+	TgCodeBadRequest = 400
+	TgCodeForbidden  = 403
+	TgCodeFloodWait  = 420
+)
+
+// floodWaitPattern matches flood wait errors that reached us as plain strings,
+// either in raw form ("FLOOD_WAIT_30") or as formatted by gotd
+// ("rpc error code 420: FLOOD_WAIT (30)")
+var floodWaitPattern = regexp.MustCompile(`FLOOD(?:_PREMIUM)?_WAIT(?:_(\d+)| \((\d+)\))`)
+
+// IsFloodWait reports whether err is a FLOOD_WAIT error and returns the wait
+// duration Telegram asked for
+func IsFloodWait(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		return d, true
+	}
+
+	// Fall back to string matching for errors that lost their type
+	matches := floodWaitPattern.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return 0, false
+	}
+
+	for _, group := range matches[1:] {
+		if group == "" {
+			continue
+		}
+		if seconds, convErr := strconv.Atoi(group); convErr == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return 0, true
+}
+
+// IsMessageNotModified reports whether an edit was rejected because the new
+// content is identical to the current one
+func IsMessageNotModified(err error) bool {
+	return isRPCErrorType(err, TgErrMessageNotModified)
+}
+
+// IsMessageIDInvalid reports whether the referenced message no longer exists
+// (deleted, or never visible to the bot)
+func IsMessageIDInvalid(err error) bool {
+	return isRPCErrorType(err, TgErrMessageIDInvalid)
+}
+
+// IsReplyTargetGone reports whether a send failed because the message it
+// replied to was deleted
+func IsReplyTargetGone(err error) bool {
+	return isRPCErrorType(err, TgErrReplyMessageIDInvalid, TgErrReplyToInvalid, TgErrMessageIDInvalid)
+}
+
+// IsEntityInvalid reports whether Telegram rejected the entities of a message
+func IsEntityInvalid(err error) bool {
+	return isRPCErrorType(err, TgErrEntityBoundsInvalid, TgErrEntitiesTooLong, TgErrEntityTextURLInvalid)
+}
+
+// IsUsernameNotOccupied reports whether no user, bot or channel has the
+// username being resolved
+func IsUsernameNotOccupied(err error) bool {
+	return isRPCErrorType(err, TgErrUsernameNotOccupied)
+}
+
+// IsUsernameInvalid reports whether the username being resolved is malformed
+func IsUsernameInvalid(err error) bool {
+	return isRPCErrorType(err, TgErrUsernameInvalid)
+}
+
+// IsPeerInvalid reports whether the target peer could not be resolved
+func IsPeerInvalid(err error) bool {
+	return isRPCErrorType(err,
+		TgErrPeerIDInvalid,
+		TgErrChannelInvalid,
+		TgErrChatIDInvalid,
+		TgErrUserIDInvalid,
+	)
+}
+
+// IsMediaForbidden reports whether the chat does not allow the bot to post
+// media (or to post at all)
+func IsMediaForbidden(err error) bool {
+	return isRPCErrorType(err,
+		TgErrChatSendMediaForbidden,
+		TgErrChatSendAudiosForbidden,
+		TgErrChatWriteForbidden,
+	)
+}
+
+// IsFileReferenceExpired reports whether a stored file reference must be
+// refreshed before the file can be sent again
+func IsFileReferenceExpired(err error) bool {
+	return isRPCErrorType(err, TgErrFileReferenceExpired, TgErrFileReferenceInvalid)
+}
+
+// IsFilePartsInvalid reports whether a sent file was rejected because parts of
+// its upload are missing or do not add up, so it must be uploaded again
+func IsFilePartsInvalid(err error) bool {
+	return isRPCErrorType(err, TgErrFilePartMissing, TgErrFilePartsInvalid)
+}
+
+// isRPCErrorType checks err against the given RPC error types, using gotd's
+// typed error when available and the error text otherwise
+func isRPCErrorType(err error, types ...string) bool {
+	if err == nil {
+		return false
+	}
+
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.IsOneOf(types...)
+	}
+
+	errorMsg := err.Error()
+	for _, t := range types {
+		if strings.Contains(errorMsg, t) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+func TestIsFloodWait(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedWait time.Duration
+		expectedOK   bool
+	}{
+		{"nil error", nil, 0, false},
+		{"typed flood wait", tgerr.New(420, "FLOOD_WAIT_30"), 30 * time.Second, true},
+		{"typed premium flood wait", tgerr.New(420, "FLOOD_PREMIUM_WAIT_5"), 5 * time.Second, true},
+		{"wrapped typed flood wait", fmt.Errorf("edit failed: %w", tgerr.New(420, "FLOOD_WAIT_12")), 12 * time.Second, true},
+		{"raw string flood wait", errors.New("FLOOD_WAIT_7"), 7 * time.Second, true},
+		{"gotd formatted string", errors.New("rpc error code 420: FLOOD_WAIT (45)"), 45 * time.Second, true},
+		{"other rpc error", tgerr.New(400, "MESSAGE_NOT_MODIFIED"), 0, false},
+		{"plain error", errors.New("connection refused"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := IsFloodWait(tt.err)
+			if ok != tt.expectedOK {
+				t.Errorf("IsFloodWait() ok = %v, expected %v", ok, tt.expectedOK)
+			}
+			if wait != tt.expectedWait {
+				t.Errorf("IsFloodWait() wait = %v, expected %v", wait, tt.expectedWait)
+			}
+		})
+	}
+}
+
+func TestRPCErrorPredicates(t *testing.T) {
+	tests := []struct {
+		name      string
+		predicate func(error) bool
+		matching  []error
+		other     []error
+	}{
+		{
+			name:      "IsMessageNotModified",
+			predicate: IsMessageNotModified,
+			matching: []error{
+				tgerr.New(400, "MESSAGE_NOT_MODIFIED"),
+				fmt.Errorf("wrapped: %w", tgerr.New(400, "MESSAGE_NOT_MODIFIED")),
+				errors.New("rpc error code 400: MESSAGE_NOT_MODIFIED"),
+			},
+			other: []error{tgerr.New(400, "MESSAGE_ID_INVALID"), errors.New("timeout")},
+		},
+		{
+			name:      "IsMessageIDInvalid",
+			predicate: IsMessageIDInvalid,
+			matching: []error{
+				tgerr.New(400, "MESSAGE_ID_INVALID"),
+				errors.New("rpc error code 400: MESSAGE_ID_INVALID"),
+			},
+			other: []error{tgerr.New(400, "MESSAGE_NOT_MODIFIED"), errors.New("not found")},
+		},
+		{
+			name:      "IsPeerInvalid",
+			predicate: IsPeerInvalid,
+			matching: []error{
+				tgerr.New(400, "PEER_ID_INVALID"),
+				tgerr.New(400, "CHANNEL_INVALID"),
+				tgerr.New(400, "CHAT_ID_INVALID"),
+				tgerr.New(400, "USER_ID_INVALID"),
+				errors.New("rpc error code 400: PEER_ID_INVALID"),
+			},
+			other: []error{tgerr.New(400, "MESSAGE_ID_INVALID"), errors.New("invalid peer type")},
+		},
+		{
+			name:      "IsMediaForbidden",
+			predicate: IsMediaForbidden,
+			matching: []error{
+				tgerr.New(403, "CHAT_SEND_MEDIA_FORBIDDEN"),
+				tgerr.New(403, "CHAT_SEND_AUDIOS_FORBIDDEN"),
+				tgerr.New(403, "CHAT_WRITE_FORBIDDEN"),
+				errors.New("rpc error code 403: CHAT_SEND_MEDIA_FORBIDDEN"),
+			},
+			other: []error{tgerr.New(403, "USER_BOT_REQUIRED"), errors.New("forbidden")},
+		},
+		{
+			name:      "IsFileReferenceExpired",
+			predicate: IsFileReferenceExpired,
+			matching: []error{
+				tgerr.New(400, "FILE_REFERENCE_EXPIRED"),
+				tgerr.New(400, "FILE_REFERENCE_0_EXPIRED"),
+				tgerr.New(400, "FILE_REFERENCE_INVALID"),
+				errors.New("rpc error code 400: FILE_REFERENCE_EXPIRED"),
+			},
+			other: []error{tgerr.New(400, "FILE_PARTS_INVALID"), errors.New("file not found")},
+		},
+		{
+			name:      "IsFilePartsInvalid",
+			predicate: IsFilePartsInvalid,
+			matching: []error{
+				tgerr.New(400, "FILE_PART_0_MISSING"),
+				tgerr.New(400, "FILE_PART_12_MISSING"),
+				tgerr.New(400, "FILE_PARTS_INVALID"),
+				errors.New("rpc error code 400: FILE_PARTS_INVALID"),
+			},
+			other: []error{tgerr.New(400, "FILE_REFERENCE_EXPIRED"), errors.New("file part missing")},
+		},
+		{
+			name:      "IsReplyTargetGone",
+			predicate: IsReplyTargetGone,
+			matching: []error{
+				tgerr.New(400, "REPLY_MESSAGE_ID_INVALID"),
+				tgerr.New(400, "REPLY_TO_INVALID"),
+				errors.New("rpc error code 400: REPLY_MESSAGE_ID_INVALID"),
+			},
+			other: []error{tgerr.New(403, "CHAT_WRITE_FORBIDDEN"), errors.New("reply failed")},
+		},
+		{
+			name:      "IsEntityInvalid",
+			predicate: IsEntityInvalid,
+			matching: []error{
+				tgerr.New(400, "ENTITY_BOUNDS_INVALID"),
+				tgerr.New(400, "ENTITIES_TOO_LONG"),
+				tgerr.New(400, "ENTITY_TEXTURL_INVALID"),
+			},
+			other: []error{tgerr.New(400, "PEER_ID_INVALID"), errors.New("bad entities")},
+		},
+		{
+			name:      "IsUsernameNotOccupied",
+			predicate: IsUsernameNotOccupied,
+			matching: []error{
+				tgerr.New(400, "USERNAME_NOT_OCCUPIED"),
+				errors.New("rpc error code 400: USERNAME_NOT_OCCUPIED"),
+			},
+			other: []error{tgerr.New(400, "USERNAME_INVALID"), errors.New("username not found")},
+		},
+		{
+			name:      "IsUsernameInvalid",
+			predicate: IsUsernameInvalid,
+			matching:  []error{tgerr.New(400, "USERNAME_INVALID")},
+			other:     []error{tgerr.New(400, "USERNAME_NOT_OCCUPIED"), errors.New("invalid")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.predicate(nil) {
+				t.Error("predicate should be false for nil error")
+			}
+			for _, err := range tt.matching {
+				if !tt.predicate(err) {
+					t.Errorf("expected %v to match", err)
+				}
+			}
+			for _, err := range tt.other {
+				if tt.predicate(err) {
+					t.Errorf("expected %v not to match", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedErrorFormatStillParses(t *testing.T) {
+	// Guard against gotd changing how typed errors render: the string
+	// fallback must agree with the typed path for the same error
+	typed := tgerr.New(420, "FLOOD_WAIT_9")
+	plain := errors.New(typed.Error())
+
+	typedWait, typedOK := IsFloodWait(typed)
+	plainWait, plainOK := IsFloodWait(plain)
+	if typedOK != plainOK || typedWait != plainWait {
+		t.Errorf("typed (%v, %v) and string (%v, %v) flood wait parsing disagree",
+			typedWait, typedOK, plainWait, plainOK)
+	}
+
+	notModified := errors.New(tgerr.New(400, "MESSAGE_NOT_MODIFIED").Error())
+	if !IsMessageNotModified(notModified) {
+		t.Errorf("string form %q should be recognised", notModified)
+	}
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// ThumbnailMaxDimension caps the longer side of upload thumbnails, the
+	// largest Telegram shows
+	ThumbnailMaxDimension = 320
+
+	// thumbnailQuality is the JPEG quality of upload thumbnails
+	thumbnailQuality = 85
+)
+
+// PrepareThumbnail downloads the artwork at artworkURL and turns it into an
+// upload thumbnail
+func (sd *SongDownloaderImpl) PrepareThumbnail(ctx context.Context, artworkURL string) ([]byte, error) {
+	artwork, err := sd.fetchArtwork(ctx, artworkURL)
+	if err != nil {
+		return nil, err
+	}
+	return makeThumbnail(artwork)
+}
+
+// thumbnail returns the upload thumbnail of a song, made from cover when it
+// was fetched already and downloaded at thumbnail size otherwise. It is nil
+// when no thumbnail could be made, uploads then go without one
+func (sd *SongDownloaderImpl) thumbnail(ctx context.Context, cover []byte, artwork Artwork) []byte {
+	var thumb []byte
+	var err error
+	switch {
+	case len(cover) > 0:
+		thumb, err = makeThumbnail(cover)
+	case artwork.URL != "":
+		thumb, err = sd.PrepareThumbnail(ctx, artworkURL(artwork, ThumbnailMaxDimension))
+	default:
+		return nil
+	}
+	if err != nil {
+		logger.Warn("Failed to prepare thumbnail", logging.Err(err))
+		return nil
+	}
+	return thumb
+}
+
+// makeThumbnail scales a JPEG or PNG image down so neither side exceeds
+// ThumbnailMaxDimension and encodes it as JPEG
+func makeThumbnail(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode artwork: %w", err)
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("artwork has no pixels")
+	}
+	if width > ThumbnailMaxDimension || height > ThumbnailMaxDimension {
+		if width >= height {
+			height = max(1, height*ThumbnailMaxDimension/width)
+			width = ThumbnailMaxDimension
+		} else {
+			width = max(1, width*ThumbnailMaxDimension/height)
+			height = ThumbnailMaxDimension
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes src to width x height, no larger than src, averaging the
+// source pixels that fall into each pixel of the result
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testImage encodes a width x height image as PNG, red on the left half and
+// blue on the right
+func testImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode artwork: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{"wide artwork", 1000, 500, 320, 160},
+		{"tall artwork", 400, 800, 160, 320},
+		{"square artwork", 1200, 1200, 320, 320},
+		{"small artwork keeps its size", 100, 80, 100, 80},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			thumb, err := makeThumbnail(testImage(t, tc.width, tc.height))
+			if err != nil {
+				t.Fatalf("makeThumbnail() error = %v", err)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatalf("Expected a JPEG thumbnail: %v", err)
+			}
+			if got := img.Bounds(); got.Dx() != tc.wantWidth || got.Dy() != tc.wantHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tc.wantWidth, tc.wantHeight, got.Dx(), got.Dy())
+			}
+
+			// The colors of both halves survive scaling
+			r, _, b, _ := img.At(0, 0).RGBA()
+			if r>>8 < 200 || b>>8 > 50 {
+				t.Errorf("Expected the left edge to stay red, got r=%d b=%d", r>>8, b>>8)
+			}
+			r, _, b, _ = img.At(img.Bounds().Dx()-1, 0).RGBA()
+			if b>>8 < 200 || r>>8 > 50 {
+				t.Errorf("Expected the right edge to stay blue, got r=%d b=%d", r>>8, b>>8)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnail_RejectsInvalidImage(t *testing.T) {
+	if _, err := makeThumbnail([]byte("not an image")); err == nil {
+		t.Error("Expected an error for data that is no image")
+	}
+}
+
+func TestPrepareThumbnail(t *testing.T) {
+	artwork := testImage(t, 640, 640)
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write(artwork)
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	thumb := sd.thumbnail(context.Background(), nil, Artwork{URL: server.URL + "/{w}x{h}.jpg", Width: 3000, Height: 3000})
+	if thumb == nil {
+		t.Fatal("Expected a thumbnail from the artwork URL")
+	}
+	if !strings.HasPrefix(requested, "/320x320") {
+		t.Errorf("Expected the artwork to be requested at thumbnail size, got %s", requested)
+	}
+}
+
+func TestThumbnail_DegradesWithoutArtwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	if thumb := sd.thumbnail(context.Background(), nil, Artwork{URL: server.URL + "/{w}x{h}.jpg"}); thumb != nil {
+		t.Error("Expected no thumbnail when the artwork cannot be fetched")
+	}
+	if thumb := sd.thumbnail(context.Background(), []byte("corrupt"), Artwork{}); thumb != nil {
+		t.Error("Expected no thumbnail for corrupt artwork")
+	}
+	if thumb := sd.thumbnail(context.Background(), nil, Artwork{}); thumb != nil {
+		t.Error("Expected no thumbnail without an artwork URL")
+	}
+}
@@ -0,0 +1,268 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+const (
+	// defaultTokenPageURL is the web player page whose JS bundle embeds the token
+	defaultTokenPageURL = "https://beta.music.apple.com"
+
+	// tokenAttempts is how many times the web player is scraped before giving up
+	tokenAttempts = 3
+
+	// tokenRequestTimeout bounds each page and bundle request
+	tokenRequestTimeout = 20 * time.Second
+)
+
+// tokenAcceptLanguages varies the Accept-Language header between attempts.
+// Region-selection interstitials are often not served for a different language
+var tokenAcceptLanguages = []string{
+	"en-US,en;q=0.9",
+	"en-GB,en;q=0.8",
+	"ja-JP,ja;q=0.9,en;q=0.5",
+}
+
+var (
+	indexJSRegex = regexp.MustCompile(`/assets/index-legacy-[^/"']+\.js`)
+	tokenRegex   = regexp.MustCompile(`eyJh[^"]+`)
+
+	// Redirects in interstitial pages: <meta http-equiv="refresh" content="0; url=...">
+	// or a script assigning window.location / location.href
+	metaRefreshRegex    = regexp.MustCompile(`(?i)<meta[^>]+http-equiv=["']?refresh["']?[^>]+content=["'][^"']*url=([^"'>\s]+)`)
+	scriptRedirectRegex = regexp.MustCompile(`(?:window\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']`)
+)
+
+// errIndexJSNotFound is returned when the page has no reference to the JS bundle,
+// usually because an interstitial was served instead of the web player
+var errIndexJSNotFound = errors.New("index JS file not found")
+
+// ErrTokenRejected is returned when the catalog API rejects the token (HTTP 401)
+var ErrTokenRejected = errors.New("token rejected by the catalog API")
+
+// TokenStatus describes the most recent token acquisition
+type TokenStatus struct {
+	Degraded  bool      // The token was not scraped; the fallback was used or nothing was available
+	Reason    string    // Why acquisition is degraded
+	CheckedAt time.Time // When the token was last acquired (zero if never)
+}
+
+// TokenHealth records token acquisition results for health reporting
+type TokenHealth struct {
+	mu     sync.RWMutex
+	status TokenStatus
+}
+
+// NewTokenHealth creates an empty TokenHealth
+func NewTokenHealth() *TokenHealth {
+	return &TokenHealth{}
+}
+
+// Status returns the most recent token acquisition status
+func (h *TokenHealth) Status() TokenStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// record stores the result of a token acquisition
+func (h *TokenHealth) record(degraded bool, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = TokenStatus{Degraded: degraded, Reason: reason, CheckedAt: time.Now()}
+}
+
+// GetToken retrieves authentication token from Apple Music. Scraped tokens
+// are cached until refreshMargin before they expire. Otherwise the web player
+// is scraped up to tokenAttempts times with different Accept-Language headers,
+// following interstitial redirects. If that fails, APPLE_DEV_TOKEN is used.
+// APPLE_STATIC_TOKEN, meant for tests, skips all of this
+func (sd *SongDownloaderImpl) GetToken(ctx context.Context) (string, error) {
+	if sd.staticToken != "" {
+		return sd.staticToken, nil
+	}
+	return sd.apple.tokens.Get(time.Now(), func() (string, bool, error) {
+		return sd.acquireToken(ctx)
+	})
+}
+
+// acquireToken scrapes a new token, falling back to APPLE_DEV_TOKEN. Only
+// scraped tokens are cached, so scraping is tried again next time
+func (sd *SongDownloaderImpl) acquireToken(ctx context.Context) (string, bool, error) {
+	client := sd.httpClient(DepTokenPage, tokenRequestTimeout)
+
+	var lastErr error
+	for attempt := 0; attempt < tokenAttempts; attempt++ {
+		token, err := sd.scrapeToken(ctx, client, tokenAcceptLanguages[attempt%len(tokenAcceptLanguages)])
+		if err == nil {
+			sd.tokenHealth.record(false, "")
+			return token, true, nil
+		}
+		// A cancelled caller says nothing about the web player
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		lastErr = err
+	}
+
+	if sd.fallbackToken != "" {
+		logger.Warn("Could not scrape Apple Music token, using APPLE_DEV_TOKEN", logging.Int("Attempts", tokenAttempts),
+			logging.Err(lastErr), logging.String("Expiry", describeTokenExpiry(sd.fallbackToken, time.Now())))
+		sd.tokenHealth.record(true, fmt.Sprintf("using APPLE_DEV_TOKEN: %v", lastErr))
+		return sd.fallbackToken, false, nil
+	}
+
+	sd.tokenHealth.record(true, lastErr.Error())
+	return "", false, NewDownloadErrorWithCause(ErrorTokenUnavailable,
+		fmt.Sprintf("could not acquire token after %d attempts", tokenAttempts), lastErr)
+}
+
+// scrapeToken fetches the web player page and extracts the token from its JS bundle
+func (sd *SongDownloaderImpl) scrapeToken(ctx context.Context, client *http.Client, acceptLanguage string) (string, error) {
+	if err := sd.faults.check(FaultToken); err != nil {
+		return "", err
+	}
+
+	pageURL := sd.tokenPageURL
+	if pageURL == "" {
+		pageURL = defaultTokenPageURL
+	}
+
+	page, finalURL, err := fetchTokenResource(ctx, client, pageURL, acceptLanguage)
+	if err != nil {
+		return "", err
+	}
+
+	indexJsUri := indexJSRegex.FindString(page)
+	if indexJsUri == "" {
+		// Interstitials sometimes point at the real page themselves
+		target := interstitialRedirect(page, finalURL)
+		if target == "" {
+			return "", errIndexJSNotFound
+		}
+
+		page, finalURL, err = fetchTokenResource(ctx, client, target, acceptLanguage)
+		if err != nil {
+			return "", fmt.Errorf("failed to follow interstitial redirect: %w", err)
+		}
+
+		indexJsUri = indexJSRegex.FindString(page)
+		if indexJsUri == "" {
+			return "", errIndexJSNotFound
+		}
+	}
+
+	js, _, err := fetchTokenResource(ctx, client, resolveReference(finalURL, indexJsUri), acceptLanguage)
+	if err != nil {
+		return "", err
+	}
+
+	token := tokenRegex.FindString(js)
+	if token == "" {
+		return "", errors.New("token not found in JS file")
+	}
+
+	return token, nil
+}
+
+// fetchTokenResource GETs a page and returns its body and final URL after HTTP redirects
+func fetchTokenResource(ctx context.Context, client *http.Client, resourceURL, acceptLanguage string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", resourceURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept-Language", acceptLanguage)
+	req.Header.Set("User-Agent", appleMusicUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	// Error pages never carry the token, and may look like interstitials
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", "", fmt.Errorf("%s returned %s", resourceURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(body), resp.Request.URL.String(), nil
+}
+
+// interstitialRedirect returns the absolute redirect target of an interstitial
+// page, or "" if it has none
+func interstitialRedirect(page, pageURL string) string {
+	for _, regex := range []*regexp.Regexp{metaRefreshRegex, scriptRedirectRegex} {
+		if matches := regex.FindStringSubmatch(page); matches != nil {
+			target := strings.ReplaceAll(matches[1], "&amp;", "&")
+			return resolveReference(pageURL, target)
+		}
+	}
+	return ""
+}
+
+// resolveReference resolves ref against base, returning ref unchanged if either does not parse
+func resolveReference(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
+
+// tokenExpiry reads the exp claim of a JWT token
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// describeTokenExpiry reports when a JWT developer token expires, for the
+// fallback warning
+func describeTokenExpiry(token string, now time.Time) string {
+	expiry, ok := tokenExpiry(token)
+	if !ok {
+		return "The token expiry could not be read; replace it if requests start failing."
+	}
+
+	if !expiry.After(now) {
+		return fmt.Sprintf("The token EXPIRED at %s; replace it.", expiry.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("The token expires at %s (in %s); replace it before then.",
+		expiry.UTC().Format(time.RFC3339), expiry.Sub(now).Round(time.Hour))
+}
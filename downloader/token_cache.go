@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// refreshMargin is how long before its expiry a cached token is replaced
+	refreshMargin = 5 * time.Minute
+
+	// unknownTokenLifetime is how long a token without a readable expiry is
+	// cached
+	unknownTokenLifetime = time.Hour
+)
+
+// TokenCache holds the current Apple Music token for every download sharing
+// it. Concurrent callers needing a new token wait for a single acquisition
+type TokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewTokenCache creates an empty TokenCache
+func NewTokenCache() *TokenCache {
+	return &TokenCache{}
+}
+
+// Get returns the cached token unless it expires within refreshMargin of now,
+// otherwise a token from acquire, which is cached when acquire says so
+func (c *TokenCache) Get(now time.Time, acquire func() (token string, cache bool, err error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && now.Add(refreshMargin).Before(c.expires) {
+		return c.token, nil
+	}
+
+	token, cache, err := acquire()
+	if err != nil {
+		return "", err
+	}
+	if !cache {
+		c.token = ""
+		return token, nil
+	}
+
+	expires, ok := tokenExpiry(token)
+	if !ok {
+		expires = now.Add(unknownTokenLifetime)
+	}
+	c.token, c.expires = token, expires
+	return token, nil
+}
+
+// Invalidate drops token from the cache, e.g. after it was rejected. A token
+// cached since then is kept
+func (c *TokenCache) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+	}
+}
@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwtExpiringAt returns a JWT-shaped token whose exp claim is expiry
+func jwtExpiringAt(expiry time.Time) string {
+	payload := fmt.Sprintf(`{"exp":%d}`, expiry.Unix())
+	return "eyJhbGciOiJFUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+func TestTokenCache_RefreshesBeforeExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := NewTokenCache()
+
+	acquired := 0
+	acquire := func() (string, bool, error) {
+		acquired++
+		return jwtExpiringAt(now.Add(time.Hour)), true, nil
+	}
+
+	first, err := cache.Get(now, acquire)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if second, _ := cache.Get(now.Add(50*time.Minute), acquire); second != first || acquired != 1 {
+		t.Errorf("Expected the cached token well before expiry, acquired %d times", acquired)
+	}
+	cache.Get(now.Add(56*time.Minute), acquire)
+	if acquired != 2 {
+		t.Errorf("Expected a refresh within %s of expiry, acquired %d times", refreshMargin, acquired)
+	}
+}
+
+func TestTokenCache_UncacheableAndInvalidate(t *testing.T) {
+	now := time.Now()
+	cache := NewTokenCache()
+
+	if _, err := cache.Get(now, func() (string, bool, error) { return "fallback", false, nil }); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	acquired := 0
+	acquire := func() (string, bool, error) {
+		acquired++
+		return fmt.Sprintf("scraped-%d", acquired), true, nil
+	}
+	if token, _ := cache.Get(now, acquire); token != "scraped-1" {
+		t.Errorf("Expected fallback tokens not to be cached, got %q", token)
+	}
+
+	cache.Invalidate("some-older-token")
+	if token, _ := cache.Get(now, acquire); token != "scraped-1" {
+		t.Errorf("Expected invalidating another token to keep the cache, got %q", token)
+	}
+	cache.Invalidate("scraped-1")
+	if token, _ := cache.Get(now, acquire); token != "scraped-2" {
+		t.Errorf("Expected a new token after invalidation, got %q", token)
+	}
+
+	failing := func() (string, bool, error) { return "", false, errors.New("down") }
+	cache.Invalidate("scraped-2")
+	if _, err := cache.Get(now, failing); err == nil {
+		t.Error("Expected the acquisition error")
+	}
+}
+
+func TestTokenCache_ConcurrentCallersShareOneAcquisition(t *testing.T) {
+	cache := NewTokenCache()
+	var acquired atomic.Int32
+	acquire := func() (string, bool, error) {
+		acquired.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return testToken, true, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := cache.Get(time.Now(), acquire); token != testToken || err != nil {
+				t.Errorf("Get() = %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := acquired.Load(); n != 1 {
+		t.Errorf("Expected one acquisition, got %d", n)
+	}
+}
+
+func TestGetToken_CachedAcrossDownloaders(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		return realPage
+	})
+
+	manager := NewManager(0)
+	for i := 0; i < 3; i++ {
+		sd := manager.NewDownloader().(*SongDownloaderImpl)
+		sd.tokenPageURL = server.URL
+		if token, err := sd.GetToken(context.Background()); token != testToken || err != nil {
+			t.Fatalf("GetToken() = %q, %v", token, err)
+		}
+	}
+	if requests := server.pageRequests(); requests != 1 {
+		t.Errorf("Expected the page to be scraped once, got %d requests", requests)
+	}
+}
+
+func TestGetToken_StaticToken(t *testing.T) {
+	t.Setenv("APPLE_STATIC_TOKEN", "static-token")
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.tokenPageURL = "http://127.0.0.1:0"
+
+	if token, err := sd.GetToken(context.Background()); token != "static-token" || err != nil {
+		t.Errorf("GetToken() = %q, %v, want the static token", token, err)
+	}
+}
+
+func TestGetSongMeta_RetriesRejectedToken(t *testing.T) {
+	const staleToken = "stale-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			fmt.Fprint(w, realPage)
+		case strings.HasPrefix(r.URL.Path, "/assets/"):
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+		case r.Header.Get("Authorization") != "Bearer "+testToken:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, `{"data":[{"id":"1","type":"songs","attributes":{"name":"Song"}}]}`)
+		}
+	}))
+	defer server.Close()
+
+	sd := newTokenDownloader(server.URL, "")
+	sd.apple.catalogURL = server.URL
+	sd.apple.tokens.Get(time.Now(), func() (string, bool, error) { return staleToken, true, nil })
+
+	urlMeta := &URLMeta{Storefront: "us", URLType: "songs", ID: "1"}
+	if _, err := sd.GetSongMeta(context.Background(), urlMeta, staleToken); !errors.Is(err, ErrTokenRejected) {
+		t.Fatalf("Expected ErrTokenRejected for the stale token, got %v", err)
+	}
+
+	meta, token, err := sd.getSongMetaRefreshingToken(context.Background(), urlMeta, staleToken)
+	if err != nil || meta.Attributes.Name != "Song" {
+		t.Fatalf("Expected the retry with a new token to succeed, got %v", err)
+	}
+	if token != testToken {
+		t.Errorf("Expected the new token to be returned, got %q", token)
+	}
+}
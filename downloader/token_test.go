@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testToken        = "eyJhbGciOiJFUzI1NiJ9.test-token"
+	interstitialPage = `<html><body>Choose your country or region</body></html>`
+	realPage         = `<html><script src="/assets/index-legacy-abc123.js"></script></html>`
+)
+
+// tokenServer serves the web player page through pageFor, which gets the
+// request number (starting at 1), and a JS bundle containing testToken
+type tokenServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  int
+	languages []string
+}
+
+func newTokenServer(t *testing.T, pageFor func(n int, r *http.Request) string) *tokenServer {
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+			return
+		}
+
+		ts.mu.Lock()
+		ts.requests++
+		n := ts.requests
+		ts.languages = append(ts.languages, r.Header.Get("Accept-Language"))
+		ts.mu.Unlock()
+
+		fmt.Fprint(w, pageFor(n, r))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// pageRequests returns how many times the page was requested
+func (ts *tokenServer) pageRequests() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.requests
+}
+
+// acceptLanguages returns the Accept-Language header of every page request
+func (ts *tokenServer) acceptLanguages() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]string(nil), ts.languages...)
+}
+
+// newTokenDownloader creates a downloader scraping pageURL with the given fallback token
+func newTokenDownloader(pageURL, fallbackToken string) *SongDownloaderImpl {
+	sd := NewSongDownloaderImpl().(*SongDownloaderImpl)
+	sd.tokenPageURL = pageURL
+	sd.fallbackToken = fallbackToken
+	return sd
+}
+
+func TestGetToken_RetriesInterstitialWithDifferentLanguage(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		if n == 1 {
+			return interstitialPage
+		}
+		return realPage
+	})
+
+	sd := newTokenDownloader(server.URL, "")
+	token, err := sd.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != testToken {
+		t.Errorf("Expected token %q, got %q", testToken, token)
+	}
+
+	if languages := server.acceptLanguages(); len(languages) != 2 || languages[0] == languages[1] {
+		t.Errorf("Expected two attempts with different Accept-Language, got %v", languages)
+	}
+	if status := sd.tokenHealth.Status(); status.Degraded {
+		t.Errorf("Expected healthy token status, got %+v", status)
+	}
+}
+
+func TestGetToken_FollowsInterstitialRedirect(t *testing.T) {
+	testCases := []struct {
+		name         string
+		interstitial string
+	}{
+		{
+			name:         "meta refresh",
+			interstitial: `<html><head><meta http-equiv="refresh" content="0; url=/us/browse"></head></html>`,
+		},
+		{
+			name:         "script location",
+			interstitial: `<html><script>window.location.href = "/us/browse";</script></html>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTokenServer(t, func(n int, r *http.Request) string {
+				if r.URL.Path == "/us/browse" {
+					return realPage
+				}
+				return tc.interstitial
+			})
+
+			sd := newTokenDownloader(server.URL, "")
+			token, err := sd.GetToken(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if token != testToken {
+				t.Errorf("Expected token %q, got %q", testToken, token)
+			}
+			if requests := server.pageRequests(); requests != 2 {
+				t.Errorf("Expected the redirect to be followed in the first attempt, got %d page requests", requests)
+			}
+		})
+	}
+}
+
+func TestGetToken_EnvFallback(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		return interstitialPage
+	})
+
+	sd := newTokenDownloader(server.URL, "manual-token")
+	token, err := sd.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "manual-token" {
+		t.Errorf("Expected fallback token, got %q", token)
+	}
+	if requests := server.pageRequests(); requests != tokenAttempts {
+		t.Errorf("Expected %d attempts before falling back, got %d", tokenAttempts, requests)
+	}
+
+	status := sd.tokenHealth.Status()
+	if !status.Degraded || !strings.Contains(status.Reason, "APPLE_DEV_TOKEN") {
+		t.Errorf("Expected degraded status mentioning the fallback, got %+v", status)
+	}
+}
+
+func TestGetToken_Unavailable(t *testing.T) {
+	server := newTokenServer(t, func(n int, r *http.Request) string {
+		return interstitialPage
+	})
+
+	manager := NewManager(0)
+	sd := manager.NewDownloader().(*SongDownloaderImpl)
+	sd.tokenPageURL = server.URL
+	sd.fallbackToken = ""
+
+	_, err := sd.GetToken(context.Background())
+	if !IsDownloadError(err, ErrorTokenUnavailable) {
+		t.Fatalf("Expected ErrorTokenUnavailable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "index JS file not found") {
+		t.Errorf("Expected the last failure as cause, got %v", err)
+	}
+
+	// The manager reports degraded acquisition for health checks
+	if status := manager.TokenStatus(); !status.Degraded {
+		t.Errorf("Expected degraded token status, got %+v", status)
+	}
+}
+
+func TestGetToken_ErrorStatus(t *testing.T) {
+	// An error page that would otherwise yield a token
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if strings.HasPrefix(r.URL.Path, "/assets/") {
+			fmt.Fprintf(w, `const config = {token: "%s"};`, testToken)
+			return
+		}
+		fmt.Fprint(w, realPage)
+	}))
+	defer server.Close()
+
+	sd := newTokenDownloader(server.URL, "")
+	_, err := sd.GetToken(context.Background())
+	if !IsDownloadError(err, ErrorTokenUnavailable) {
+		t.Fatalf("Expected ErrorTokenUnavailable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the status in the cause, got %v", err)
+	}
+}
+
+func TestDescribeTokenExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJFUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+
+	testCases := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{"future expiry", jwt(fmt.Sprintf(`{"exp":%d}`, now.Add(48*time.Hour).Unix())), "expires at"},
+		{"past expiry", jwt(fmt.Sprintf(`{"exp":%d}`, now.Add(-time.Hour).Unix())), "EXPIRED"},
+		{"no exp claim", jwt(`{"iss":"team"}`), "could not be read"},
+		{"not a JWT", "manual-token", "could not be read"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := describeTokenExpiry(tc.token, now); !strings.Contains(got, tc.expected) {
+				t.Errorf("Expected %q in %q", tc.expected, got)
+			}
+		})
+	}
+}
@@ -111,12 +111,78 @@ type SongResponse struct {
 	Data []AutoSong `json:"data"`
 }
 
+// AutoAlbum represents an album from Apple Music API
+type AutoAlbum struct {
+	ID            string             `json:"id"`
+	Type          string             `json:"type"`
+	Attributes    AlbumAttributes    `json:"attributes"`
+	Relationships AlbumRelationships `json:"relationships"`
+}
+
+// AlbumRelationships contains the data related to an album
+type AlbumRelationships struct {
+	Tracks TrackRelationship `json:"tracks"`
+}
+
+// TrackRelationship holds a page of the tracks of an album or playlist
+type TrackRelationship struct {
+	Href string     `json:"href"`
+	Next string     `json:"next"`
+	Data []AutoSong `json:"data"`
+}
+
+// AlbumResponse represents the API response containing albums
+type AlbumResponse struct {
+	Data []AutoAlbum `json:"data"`
+}
+
+// PlaylistAttributes contains the attributes of a catalog playlist
+type PlaylistAttributes struct {
+	Name         string     `json:"name"`
+	CuratorName  string     `json:"curatorName"`
+	PlaylistType string     `json:"playlistType"`
+	LastModified string     `json:"lastModifiedDate"`
+	Artwork      Artwork    `json:"artwork"`
+	PlayParams   PlayParams `json:"playParams"`
+	URL          string     `json:"url"`
+}
+
+// AutoPlaylist represents a catalog playlist with its tracks
+type AutoPlaylist struct {
+	ID            string                `json:"id"`
+	Type          string                `json:"type"`
+	Attributes    PlaylistAttributes    `json:"attributes"`
+	Relationships PlaylistRelationships `json:"relationships"`
+}
+
+// PlaylistRelationships contains the data related to a playlist
+type PlaylistRelationships struct {
+	Tracks TrackRelationship `json:"tracks"`
+}
+
+// PlaylistResponse represents the API response containing playlists
+type PlaylistResponse struct {
+	Data []AutoPlaylist `json:"data"`
+}
+
 // SongInfo contains internal song processing information
 type SongInfo struct {
 	r             io.ReadSeeker
 	alacParam     *Alac
 	samples       []SampleInfo
 	totalDataSize int64
+
+	// Entries of the stsd box, one per sample description
+	descriptions uint32
+
+	// Sample entry, esds box and average bitrate of AAC songs, which have no
+	// alacParam
+	aacEntry   *mp4.AudioSampleEntry
+	esds       *mp4.BoxInfo
+	aacBitrate uint32
+
+	// LRC lyrics written into the ©lyr tag, empty for none
+	lyrics string
 }
 
 // Duration calculates the total duration of the song
@@ -157,6 +223,16 @@ func BoxTypeAlac() mp4.BoxType {
 // SampleInfo contains information about individual samples
 type SampleInfo struct {
 	data      []byte
+	offset    int64  // Where the sample starts in its file, when data is not loaded
+	length    uint32 // Size of the sample when data is not loaded
 	duration  uint32
 	descIndex uint32
+}
+
+// size returns the size of the sample, whether its data is loaded or not
+func (s SampleInfo) size() int64 {
+	if s.data != nil {
+		return int64(len(s.data))
+	}
+	return int64(s.length)
 }
\ No newline at end of file
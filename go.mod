@@ -3,15 +3,14 @@ module go-alac-bot
 go 1.24.6
 
 require (
-	github.com/Sorrow446/go-mp4tag v0.0.0-20240130220823-68ce31d53e37
 	github.com/abema/go-mp4 v1.4.1
 	github.com/celestix/gotgproto v1.0.0-beta21
 	github.com/glebarez/sqlite v1.11.0
 	github.com/gotd/td v0.122.0
 	github.com/grafov/m3u8 v0.12.1
 	github.com/joho/godotenv v1.5.1
-	github.com/schollz/progressbar/v3 v3.18.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/sync v0.12.0
 )
 
 require (
@@ -51,7 +50,6 @@ require (
 	golang.org/x/exp v0.0.0-20250106191152-7588d65b2ba8 // indirect
 	golang.org/x/mod v0.24.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/sync v0.12.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/term v0.30.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
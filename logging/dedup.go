@@ -0,0 +1,165 @@
+// Package logging provides the log output layer shared by the whole bot
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for log deduplication
+const (
+	DefaultWindow    = 60 * time.Second
+	DefaultThreshold = 1
+)
+
+// outputCallDepth makes file:line flags on the output logger point at the
+// code that called the wrapping logger rather than at this package
+const outputCallDepth = 4
+
+// Options configures a Deduplicator
+type Options struct {
+	Enabled   bool          // Suppress repeated identical entries
+	Window    time.Duration // How long a run of identical entries is collapsed
+	Threshold int           // How many identical entries are written before suppressing the rest
+}
+
+// DefaultOptions returns deduplication enabled with the default window and threshold
+func DefaultOptions() Options {
+	return Options{Enabled: true, Window: DefaultWindow, Threshold: DefaultThreshold}
+}
+
+// repeatRun is a run of identical entries within one window
+type repeatRun struct {
+	key        string
+	start      time.Time
+	lastSeen   time.Time
+	count      int
+	suppressed int
+	timer      *time.Timer
+}
+
+// Deduplicator is an io.Writer for a *log.Logger that collapses repeated
+// identical entries into a summary line. Entries are forwarded to out, which
+// carries the prefix and flags; the wrapping logger should use neither
+type Deduplicator struct {
+	mu      sync.Mutex
+	out     *log.Logger
+	options Options
+	run     *repeatRun
+	recent  *RecentErrors
+	now     func() time.Time
+}
+
+// NewDeduplicator creates a Deduplicator writing to out
+func NewDeduplicator(out *log.Logger, options Options) *Deduplicator {
+	return &Deduplicator{
+		out:     out,
+		options: normalizeOptions(options),
+		recent:  NewRecentErrors(MaxRecentErrors),
+		now:     time.Now,
+	}
+}
+
+// normalizeOptions replaces unusable values with the defaults
+func normalizeOptions(options Options) Options {
+	if options.Window <= 0 {
+		options.Window = DefaultWindow
+	}
+	if options.Threshold < 1 {
+		options.Threshold = DefaultThreshold
+	}
+	return options
+}
+
+// Configure replaces the options. Any suppressed entries are summarized first
+func (d *Deduplicator) Configure(options Options) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.flushLocked()
+	d.options = normalizeOptions(options)
+}
+
+// Flush writes the summary of the current run, if anything was suppressed
+func (d *Deduplicator) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.flushLocked()
+}
+
+// RecentErrors returns the last unique errors seen, most recent first
+func (d *Deduplicator) RecentErrors() []ErrorSummary {
+	return d.recent.List()
+}
+
+// Write implements io.Writer. Each call is one log entry
+func (d *Deduplicator) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	entry := parseEntry(message)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if entry.isError() {
+		d.recent.Record(entry, now)
+	}
+
+	if !d.options.Enabled {
+		return len(p), d.out.Output(outputCallDepth, message)
+	}
+
+	if run := d.run; run != nil && run.key == entry.key && now.Sub(run.start) < d.options.Window {
+		run.count++
+		run.lastSeen = now
+		if run.count > d.options.Threshold {
+			run.suppressed++
+			return len(p), nil
+		}
+		return len(p), d.out.Output(outputCallDepth, message)
+	}
+
+	// A different entry, or the window has closed
+	d.flushLocked()
+
+	run := &repeatRun{key: entry.key, start: now, lastSeen: now, count: 1}
+	run.timer = time.AfterFunc(d.options.Window, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.run == run {
+			d.flushLocked()
+		}
+	})
+	d.run = run
+
+	return len(p), d.out.Output(outputCallDepth, message)
+}
+
+// flushLocked ends the current run and summarizes it (must be called with lock held)
+func (d *Deduplicator) flushLocked() {
+	run := d.run
+	if run == nil {
+		return
+	}
+
+	d.run = nil
+	run.timer.Stop()
+
+	if run.suppressed > 0 {
+		d.out.Print(repeatSummary(run.suppressed, run.lastSeen.Sub(run.start)))
+	}
+}
+
+// repeatSummary creates the line written in place of suppressed entries
+func repeatSummary(times int, elapsed time.Duration) string {
+	if elapsed >= time.Second {
+		elapsed = elapsed.Round(time.Second)
+	} else {
+		elapsed = elapsed.Round(time.Millisecond)
+	}
+	return fmt.Sprintf("previous message repeated %d times in %s", times, elapsed)
+}
@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for the window timer goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+// newTestLogger creates a logger deduplicated with options, and its output
+func newTestLogger(options Options) (*log.Logger, *Deduplicator, *syncBuffer) {
+	output := &syncBuffer{}
+	dedup := NewDeduplicator(log.New(output, "", 0), options)
+	return log.New(dedup, "", 0), dedup, output
+}
+
+// networkError logs the same error the error handler does for a failing sidecar
+func networkError(logger *log.Logger, correlationID string) {
+	logger.Printf("ERROR: [NETWORK] Network error occurred: dial tcp 127.0.0.1:10020: connect: connection refused | Correlation: %s | Timestamp: %s",
+		correlationID, time.Now().Format(time.RFC3339Nano))
+}
+
+func TestDeduplicator_SuppressesRepeatsUntilDifferentMessage(t *testing.T) {
+	logger, _, output := newTestLogger(Options{Enabled: true, Window: time.Minute, Threshold: 1})
+
+	for i := 0; i < 48; i++ {
+		networkError(logger, fmt.Sprintf("id-%d", i))
+	}
+	logger.Printf("INFO: Processing /song command for user 1 in chat 1")
+
+	lines := output.Lines()
+	if len(lines) != 3 {
+		t.Fatalf("Expected first entry, summary and new entry, got %d lines: %v", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "Correlation: id-0") {
+		t.Errorf("Expected the first occurrence to be written, got %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "previous message repeated 47 times in ") {
+		t.Errorf("Expected repeat summary, got %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "Processing /song") {
+		t.Errorf("Expected the different message last, got %q", lines[2])
+	}
+}
+
+func TestDeduplicator_Threshold(t *testing.T) {
+	logger, dedup, output := newTestLogger(Options{Enabled: true, Window: time.Minute, Threshold: 3})
+
+	for i := 0; i < 10; i++ {
+		networkError(logger, fmt.Sprintf("id-%d", i))
+	}
+	dedup.Flush()
+
+	lines := output.Lines()
+	if len(lines) != 4 {
+		t.Fatalf("Expected 3 entries and a summary, got %d lines: %v", len(lines), lines)
+	}
+	if !strings.HasPrefix(lines[3], "previous message repeated 7 times") {
+		t.Errorf("Expected summary of 7 suppressed entries, got %q", lines[3])
+	}
+}
+
+func TestDeduplicator_SummaryWhenWindowCloses(t *testing.T) {
+	logger, _, output := newTestLogger(Options{Enabled: true, Window: 50 * time.Millisecond, Threshold: 1})
+
+	networkError(logger, "a")
+	networkError(logger, "b")
+	networkError(logger, "c")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(output.Lines()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the window summary, got %v", output.Lines())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	lines := output.Lines()
+	if !strings.HasPrefix(lines[1], "previous message repeated 2 times") {
+		t.Errorf("Expected summary of 2 suppressed entries, got %q", lines[1])
+	}
+
+	// The next occurrence starts a new window and is written again
+	networkError(logger, "d")
+	if lines := output.Lines(); len(lines) != 3 || !strings.Contains(lines[2], "Correlation: d") {
+		t.Errorf("Expected repeat after the window to be written, got %v", lines)
+	}
+}
+
+func TestDeduplicator_Disabled(t *testing.T) {
+	logger, _, output := newTestLogger(Options{Enabled: false})
+
+	for i := 0; i < 5; i++ {
+		networkError(logger, fmt.Sprintf("id-%d", i))
+	}
+
+	if lines := output.Lines(); len(lines) != 5 {
+		t.Errorf("Expected every entry to be written, got %d lines", len(lines))
+	}
+}
+
+func TestDeduplicator_RecentErrors(t *testing.T) {
+	logger, dedup, _ := newTestLogger(DefaultOptions())
+
+	for i := 0; i < 7; i++ {
+		networkError(logger, fmt.Sprintf("id-%d", i))
+	}
+	logger.Printf("WARN: [COMMAND] Command processing error occurred: invalid URL | Correlation: cmd-1 | User: 5")
+	logger.Printf("INFO: Bot started")
+
+	errors := dedup.RecentErrors()
+	if len(errors) != 2 {
+		t.Fatalf("Expected 2 unique errors, got %d: %+v", len(errors), errors)
+	}
+
+	if errors[0].Level != "WARN" || errors[0].Count != 1 || !strings.Contains(errors[0].Message, "User: 5") {
+		t.Errorf("Expected the command error first, got %+v", errors[0])
+	}
+
+	network := errors[1]
+	if network.Level != "ERROR" || network.Count != 7 {
+		t.Errorf("Expected network error counted 7 times, got %+v", network)
+	}
+	if strings.Contains(network.Message, "Correlation") || strings.Contains(network.Message, "Timestamp") {
+		t.Errorf("Expected volatile fields to be stripped, got %q", network.Message)
+	}
+
+	// Suppressed entries still keep their correlation IDs
+	expectedIDs := []string{"id-2", "id-3", "id-4", "id-5", "id-6"}
+	if strings.Join(network.CorrelationIDs, ",") != strings.Join(expectedIDs, ",") {
+		t.Errorf("Expected correlation IDs %v, got %v", expectedIDs, network.CorrelationIDs)
+	}
+}
+
+func TestRecentErrors_KeepsLastUnique(t *testing.T) {
+	recent := NewRecentErrors(MaxRecentErrors)
+	now := time.Now()
+
+	for i := 0; i < MaxRecentErrors+5; i++ {
+		recent.Record(parseEntry(fmt.Sprintf("ERROR: failure %d", i)), now)
+	}
+	// Seeing an old error again moves it to the front
+	recent.Record(parseEntry("ERROR: failure 10"), now)
+
+	errors := recent.List()
+	if len(errors) != MaxRecentErrors {
+		t.Fatalf("Expected %d errors, got %d", MaxRecentErrors, len(errors))
+	}
+	if errors[0].Message != "ERROR: failure 10" || errors[0].Count != 2 {
+		t.Errorf("Expected repeated error first with count 2, got %+v", errors[0])
+	}
+	if errors[1].Message != fmt.Sprintf("ERROR: failure %d", MaxRecentErrors+4) {
+		t.Errorf("Expected newest error second, got %q", errors[1].Message)
+	}
+	for _, summary := range errors {
+		if summary.Message == "ERROR: failure 0" {
+			t.Error("Expected the oldest error to be evicted")
+		}
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	entry := parseEntry("ERROR: boom | Correlation: abc | Timestamp: 2024-01-01T00:00:00Z | Chat: 42")
+
+	if entry.level != "ERROR" {
+		t.Errorf("Expected level ERROR, got %q", entry.level)
+	}
+	if entry.text != "ERROR: boom | Chat: 42" {
+		t.Errorf("Unexpected text %q", entry.text)
+	}
+	if entry.correlationID != "abc" {
+		t.Errorf("Expected correlation ID abc, got %q", entry.correlationID)
+	}
+
+	if plain := parseEntry("Bot started"); plain.level != "" || plain.isError() {
+		t.Errorf("Expected message without level, got %+v", plain)
+	}
+}
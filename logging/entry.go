@@ -0,0 +1,62 @@
+package logging
+
+import "strings"
+
+// levels are the level prefixes used by the bot's log messages ("ERROR: ...")
+var levels = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// volatileFields are structured " | Name: value" fields that differ between
+// otherwise identical entries and are left out when comparing them
+var volatileFields = []string{"Correlation", "Timestamp"}
+
+// logEntry is a log message split into the parts used for deduplication
+type logEntry struct {
+	level         string
+	text          string // Message without volatile fields
+	correlationID string
+	key           string
+}
+
+// parseEntry extracts the level, comparable text and correlation ID of a message
+func parseEntry(message string) logEntry {
+	entry := logEntry{}
+
+	for _, level := range levels {
+		if strings.HasPrefix(message, level+": ") {
+			entry.level = level
+			break
+		}
+	}
+
+	parts := strings.Split(message, " | ")
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		name, value, _ := strings.Cut(part, ": ")
+		if name == "Correlation" {
+			entry.correlationID = value
+		}
+		if isVolatile(name) {
+			continue
+		}
+		kept = append(kept, part)
+	}
+
+	entry.text = strings.Join(kept, " | ")
+	entry.key = entry.level + "\x00" + entry.text
+	return entry
+}
+
+// isVolatile reports whether a structured field is left out of comparisons
+func isVolatile(name string) bool {
+	for _, field := range volatileFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// isError reports whether the entry belongs in the recent errors list
+func (e logEntry) isError() bool {
+	return e.level == "WARN" || e.level == "ERROR" || e.level == "FATAL"
+}
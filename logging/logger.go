@@ -0,0 +1,284 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of log entries, lowest first. LOG_LEVEL=FATAL keeps only the
+// entries written right before the bot exits
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+	LevelFatal = slog.Level(12)
+)
+
+// Field is a named value attached to a log entry, written as " | Name: value"
+type Field = slog.Attr
+
+// Logger writes leveled log entries with fields. Entries below the level it
+// was created with are dropped
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// Fatal writes an entry for an error the bot exits on; the caller exits
+	Fatal(msg string, fields ...Field)
+
+	// With returns a logger adding fields to every entry
+	With(fields ...Field) Logger
+}
+
+// slogLogger is the Logger over a log/slog logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// New creates a logger writing one "LEVEL: message | Name: value" line per
+// entry to w, dropping entries below level. A *slog.LevelVar changes the
+// level at runtime
+func New(w io.Writer, level slog.Leveler) Logger {
+	return &slogLogger{logger: slog.New(&lineHandler{out: &lineWriter{w: w}, level: level})}
+}
+
+// Discard returns a logger dropping every entry
+func Discard() Logger {
+	return &slogLogger{logger: slog.New(slog.DiscardHandler)}
+}
+
+// Debug writes an entry only needed to follow what the bot does in detail
+func (l *slogLogger) Debug(msg string, fields ...Field) {
+	l.log(LevelDebug, msg, fields)
+}
+
+// Info writes an entry about normal operation
+func (l *slogLogger) Info(msg string, fields ...Field) {
+	l.log(LevelInfo, msg, fields)
+}
+
+// Warn writes an entry about a problem the bot worked around
+func (l *slogLogger) Warn(msg string, fields ...Field) {
+	l.log(LevelWarn, msg, fields)
+}
+
+// Error writes an entry about a failed operation
+func (l *slogLogger) Error(msg string, fields ...Field) {
+	l.log(LevelError, msg, fields)
+}
+
+// Fatal implements Logger
+func (l *slogLogger) Fatal(msg string, fields ...Field) {
+	l.log(LevelFatal, msg, fields)
+}
+
+// With implements Logger
+func (l *slogLogger) With(fields ...Field) Logger {
+	args := make([]any, len(fields))
+	for i, field := range fields {
+		args[i] = field
+	}
+	return &slogLogger{logger: l.logger.With(args...)}
+}
+
+// log writes an entry at level, skipping the formatting of dropped ones
+func (l *slogLogger) log(level slog.Level, msg string, fields []Field) {
+	ctx := context.Background()
+	if l.logger.Enabled(ctx, level) {
+		l.logger.LogAttrs(ctx, level, msg, fields...)
+	}
+}
+
+// ParseLevel returns the level named by LOG_LEVEL
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO", "":
+		return LevelInfo, nil
+	case "WARN":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	case "FATAL":
+		return LevelFatal, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// levelName is the prefix of entries at level, one of levels
+func levelName(level slog.Level) string {
+	switch {
+	case level >= LevelFatal:
+		return "FATAL"
+	case level >= LevelError:
+		return "ERROR"
+	case level >= LevelWarn:
+		return "WARN"
+	case level >= LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// lineWriter writes whole entries to w, one at a time
+type lineWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// lineHandler is the slog.Handler formatting entries as the lines the
+// Deduplicator parses: "LEVEL: message | Name: value | Name: value"
+type lineHandler struct {
+	out    *lineWriter
+	level  slog.Leveler
+	fields string // Formatted fields added by WithAttrs
+	group  string // Prefix of field names added by WithGroup
+}
+
+// Enabled implements slog.Handler
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *lineHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(levelName(record.Level))
+	line.WriteString(": ")
+	line.WriteString(record.Message)
+	line.WriteString(h.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		writeField(&line, h.group, attr)
+		return true
+	})
+	line.WriteByte('\n')
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	_, err := io.WriteString(h.out.w, line.String())
+	return err
+}
+
+// WithAttrs implements slog.Handler
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields strings.Builder
+	fields.WriteString(h.fields)
+	for _, attr := range attrs {
+		writeField(&fields, h.group, attr)
+	}
+	handler := *h
+	handler.fields = fields.String()
+	return &handler
+}
+
+// WithGroup implements slog.Handler
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	handler := *h
+	handler.group += name + "."
+	return &handler
+}
+
+// writeField appends " | Name: value" for a field, one per member of groups
+func writeField(line *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			writeField(line, prefix, member)
+		}
+		return
+	}
+	fmt.Fprintf(line, " | %s%s: %s", prefix, attr.Key, attr.Value.String())
+}
+
+// String returns a text field
+func String(name, value string) Field {
+	return slog.String(name, value)
+}
+
+// Int returns a number field
+func Int(name string, value int) Field {
+	return slog.Int(name, value)
+}
+
+// Int64 returns a number field, e.g. a user or chat ID
+func Int64(name string, value int64) Field {
+	return slog.Int64(name, value)
+}
+
+// Bool returns a yes/no field
+func Bool(name string, value bool) Field {
+	return slog.Bool(name, value)
+}
+
+// Duration returns a duration field
+func Duration(name string, value time.Duration) Field {
+	return slog.Duration(name, value)
+}
+
+// Any returns a field of any value, written with its default format
+func Any(name string, value any) Field {
+	return slog.Any(name, value)
+}
+
+// Err returns the "Error" field of an error
+func Err(err error) Field {
+	if err == nil {
+		return slog.String("Error", "<nil>")
+	}
+	return slog.String("Error", err.Error())
+}
+
+// Redacted returns a field of a secret such as a bot or bearer token, showing
+// only its first and last 4 characters
+func Redacted(name, secret string) Field {
+	return slog.String(name, maskSecret(secret))
+}
+
+// RedactedURL returns a URL field with its credentials and query values
+// masked, since signed media and API URLs carry tokens there
+func RedactedURL(name, rawURL string) Field {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted(name, rawURL)
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, url.QueryEscape(key)+"=***")
+		}
+		slices.Sort(keys)
+		u.RawQuery = strings.Join(keys, "&")
+	}
+	return slog.String(name, u.String())
+}
+
+// maskSecret hides all but the ends of a secret, and short secrets entirely
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "***"
+	}
+	return secret[:4] + "***" + secret[len(secret)-4:]
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxRecentErrors is how many unique errors are kept for /errors
+const MaxRecentErrors = 20
+
+// maxCorrelationIDs is how many correlation IDs are kept per unique error
+const maxCorrelationIDs = 5
+
+// ErrorSummary is a unique error and how often it was logged
+type ErrorSummary struct {
+	Level          string
+	Message        string
+	Count          int
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	CorrelationIDs []string // Oldest first
+
+	key string
+}
+
+// RecentErrors keeps the most recently seen unique errors, including the ones
+// suppressed from the log output
+type RecentErrors struct {
+	mu       sync.Mutex
+	capacity int
+	entries  []*ErrorSummary // Least recently seen first
+	byKey    map[string]*ErrorSummary
+}
+
+// NewRecentErrors creates a list holding up to capacity unique errors
+func NewRecentErrors(capacity int) *RecentErrors {
+	return &RecentErrors{
+		capacity: capacity,
+		byKey:    make(map[string]*ErrorSummary),
+	}
+}
+
+// Record counts an occurrence of an error entry
+func (r *RecentErrors) Record(entry logEntry, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary, exists := r.byKey[entry.key]
+	if exists {
+		r.remove(summary)
+	} else {
+		summary = &ErrorSummary{Level: entry.level, Message: entry.text, FirstSeen: at, key: entry.key}
+		r.byKey[entry.key] = summary
+	}
+
+	summary.Count++
+	summary.LastSeen = at
+	if entry.correlationID != "" {
+		summary.CorrelationIDs = append(summary.CorrelationIDs, entry.correlationID)
+		if len(summary.CorrelationIDs) > maxCorrelationIDs {
+			summary.CorrelationIDs = summary.CorrelationIDs[1:]
+		}
+	}
+	r.entries = append(r.entries, summary)
+
+	if len(r.entries) > r.capacity {
+		oldest := r.entries[0]
+		r.entries = r.entries[1:]
+		delete(r.byKey, oldest.key)
+	}
+}
+
+// List returns copies of the kept errors, most recently seen first
+func (r *RecentErrors) List() []ErrorSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]ErrorSummary, 0, len(r.entries))
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		summary := *r.entries[i]
+		summary.CorrelationIDs = append([]string(nil), summary.CorrelationIDs...)
+		result = append(result, summary)
+	}
+	return result
+}
+
+// remove drops a summary from the ordered list (must be called with lock held)
+func (r *RecentErrors) remove(summary *ErrorSummary) {
+	for i, entry := range r.entries {
+		if entry == summary {
+			r.entries = append(r.entries[:i], r.entries[i+1:]...)
+			return
+		}
+	}
+}
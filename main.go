@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,65 +13,116 @@ import (
 
 	"go-alac-bot/bot"
 	"go-alac-bot/config"
+	"go-alac-bot/downloader"
+	"go-alac-bot/logging"
+	"go-alac-bot/web"
 )
 
 func main() {
 	// Set up proper logging configuration
-	logger := setupLogging()
+	logger, logLevel, logDedup := setupLogging()
 
-	logger.Printf("Starting Telegram bot application...")
+	logger.Info("Starting Telegram bot application...")
 
 	// Load and validate configuration using environment validation
 	cfg, err := loadAndValidateConfig(logger)
 	if err != nil {
-		logger.Fatalf("Configuration error: %v", err)
+		logger.Fatal("Configuration error", logging.Err(err))
 		os.Exit(1)
 	}
 
-	logger.Printf("Configuration loaded and validated successfully")
+	// LOG_LEVEL may have come from the .env file
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logLevel.Set(level)
+
+	logger.Info("Configuration loaded and validated successfully")
+
+	// Apply the configured log deduplication settings
+	logDedup.Configure(logging.Options{
+		Enabled:   cfg.LogDedupEnabled,
+		Window:    cfg.LogDedupWindow,
+		Threshold: cfg.LogDedupThreshold,
+	})
 
 	// Create and configure the bot
 	telegramBot, err := setupBot(cfg, logger)
 	if err != nil {
-		logger.Fatalf("Failed to setup bot: %v", err)
+		logger.Fatal("Failed to setup bot", logging.Err(err))
 		os.Exit(1)
 	}
 
 	// Register command handlers
-	registerCommandHandlers(telegramBot, logger)
+	songHandler := registerCommandHandlers(telegramBot, logger, logDedup)
+
+	// Start the optional HTTP and metrics servers
+	metricsHandler := setupMetrics(cfg, telegramBot, songHandler)
+	httpServer := startHTTPServer(cfg, logger, telegramBot, songHandler, metricsHandler)
+	metricsServer := startMetricsServer(cfg, logger, metricsHandler)
 
 	// Start the bot
 	if err := telegramBot.Start(); err != nil {
-		logger.Fatalf("Failed to start bot: %v", err)
+		logger.Fatal("Failed to start bot", logging.Err(err))
 		os.Exit(1)
 	}
 
-	logger.Printf("Bot started successfully. Press Ctrl+C to stop.")
+	logger.Info("Bot started successfully. Press Ctrl+C to stop.")
+
+	// Process the requests saved before the last shutdown
+	songHandler.GetQueue().Resume()
+
+	// Announce songs that came out to those waiting for them
+	stopReleaseChecker := songHandler.StartReleaseChecker(bot.ReleaseCheckInterval)
+	defer stopReleaseChecker()
 
 	// Implement graceful shutdown
 	gracefulShutdown(telegramBot, logger)
+
+	if httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error stopping HTTP server", logging.Err(err))
+		}
+		cancel()
+	}
+	if metricsServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error stopping metrics server", logging.Err(err))
+		}
+		cancel()
+	}
+
+	// Write out anything still being collapsed
+	logDedup.Flush()
 }
 
-// setupLogging configures structured logging for the application
-func setupLogging() *log.Logger {
-	// Create logger with timestamp and proper formatting
-	logger := log.New(os.Stdout, "[TELEGRAM-BOT] ", log.LstdFlags|log.Lshortfile)
+// setupLogging configures structured logging for the application. The returned
+// logger writes through a deduplicator that collapses repeated entries and
+// drops entries below the returned level, which starts at LOG_LEVEL
+func setupLogging() (logging.Logger, *slog.LevelVar, *logging.Deduplicator) {
+	// Create output logger with timestamp and proper formatting
+	output := log.New(os.Stdout, "[TELEGRAM-BOT] ", log.LstdFlags)
 
 	// Set log output format based on environment
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "DEBUG" {
-		logger.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
-	} else {
-		// For non-debug levels, use cleaner format without file info
-		logger.SetFlags(log.LstdFlags)
+	level, _ := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if level == logging.LevelDebug {
+		output.SetFlags(log.LstdFlags | log.Lmicroseconds)
 	}
 
-	return logger
+	// Prefix and flags are added by the output logger, after deduplication
+	logDedup := logging.NewDeduplicator(output, logging.DefaultOptions())
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(level)
+	logger := logging.New(logDedup, logLevel)
+
+	// The downloader logs through the same logger
+	downloader.SetLogger(logger)
+	return logger, logLevel, logDedup
 }
 
 // loadAndValidateConfig loads configuration and performs environment validation
-func loadAndValidateConfig(logger *log.Logger) (*config.BotConfig, error) {
-	logger.Printf("Loading and validating configuration...")
+func loadAndValidateConfig(logger logging.Logger) (*config.BotConfig, error) {
+	logger.Info("Loading and validating configuration...")
 
 	// Load configuration using existing config package
 	cfg, err := config.LoadConfig()
@@ -83,18 +136,20 @@ func loadAndValidateConfig(logger *log.Logger) (*config.BotConfig, error) {
 	}
 
 	// Log configuration status (with masked sensitive data)
-	logger.Printf("Configuration details:")
-	logger.Printf("- API ID: %d", cfg.APIID)
-	logger.Printf("- API Hash: %s", maskString(cfg.APIHash))
-	logger.Printf("- Bot Token: %s", maskString(cfg.Token))
-	logger.Printf("- Log Level: %s", cfg.LogLevel)
+	logger.Info("Configuration details",
+		logging.Int("APIID", cfg.APIID),
+		logging.Redacted("APIHash", cfg.APIHash),
+		logging.Redacted("BotToken", cfg.Token),
+		logging.String("LogLevel", cfg.LogLevel),
+		logging.String("DataDir", cfg.DataDir),
+		logging.Int("Admins", len(cfg.AdminIDs)))
 
 	return cfg, nil
 }
 
 // setupBot creates and configures the TelegramBot instance
-func setupBot(cfg *config.BotConfig, logger *log.Logger) (*bot.TelegramBot, error) {
-	logger.Printf("Setting up Telegram bot client...")
+func setupBot(cfg *config.BotConfig, logger logging.Logger) (*bot.TelegramBot, error) {
+	logger.Info("Setting up Telegram bot client...")
 
 	// Create the bot instance with error handling
 	telegramBot, err := bot.NewTelegramBot(cfg, logger)
@@ -102,13 +157,24 @@ func setupBot(cfg *config.BotConfig, logger *log.Logger) (*bot.TelegramBot, erro
 		return nil, fmt.Errorf("failed to create bot instance: %w", err)
 	}
 
-	logger.Printf("Bot client created successfully")
+	// Settings the downloader reads itself are shown by /config too
+	registry := telegramBot.GetConfigRegistry()
+	for _, setting := range downloader.EnvSettings() {
+		kind := config.KindPlain
+		if setting.Secret {
+			kind = config.KindSecret
+		}
+		registry.Register(setting.Name, setting.Value, kind)
+	}
+
+	logger.Info("Bot client created successfully")
 	return telegramBot, nil
 }
 
-// registerCommandHandlers wires together all command handlers with the bot
-func registerCommandHandlers(telegramBot *bot.TelegramBot, logger *log.Logger) {
-	logger.Printf("Registering command handlers...")
+// registerCommandHandlers wires together all command handlers with the bot and
+// returns the /song handler for the HTTP server
+func registerCommandHandlers(telegramBot *bot.TelegramBot, logger logging.Logger, logDedup *logging.Deduplicator) *bot.SongHandler {
+	logger.Info("Registering command handlers...")
 
 	// Create and register /start command handler
 	startHandler := bot.NewStartHandler(telegramBot, logger)
@@ -128,19 +194,165 @@ func registerCommandHandlers(telegramBot *bot.TelegramBot, logger *log.Logger) {
 
 	// Create and register /song command handler
 	songHandler := bot.NewSongHandler(telegramBot, logger)
+	telegramBot.SetSongQueue(songHandler.GetQueue())
 	telegramBot.RegisterCommandHandler(songHandler)
+	telegramBot.RegisterCallbackHandler(songHandler)
+
+	// Register the Cancel and Retry buttons of progress messages
+	telegramBot.RegisterCallbackHandler(bot.NewProgressControlsHandler(telegramBot, logger, songHandler))
+
+	// Create and register the inline query handler for "@bot <link>"
+	inlineHandler := bot.NewInlineHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterInlineHandler(inlineHandler)
+
+	// Create and register /cover command handler
+	coverHandler := bot.NewCoverHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(coverHandler)
+
+	// Create and register /info command handler
+	infoHandler := bot.NewInfoHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(infoHandler)
 
 	// Create and register /queue command handler
 	queueHandler := bot.NewQueueHandler(telegramBot, logger, songHandler)
 	telegramBot.RegisterCommandHandler(queueHandler)
 
+	// Create and register /my command handler
+	myHandler := bot.NewMyHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(myHandler)
+
+	// Create and register /history command handler
+	historyHandler := bot.NewHistoryHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(historyHandler)
+
+	// Create and register /failed and /retry command handlers
+	failedHandler := bot.NewFailedHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(failedHandler)
+	retryHandler := bot.NewRetryHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(retryHandler)
+
+	// Create and register /cancel command handler
+	cancelHandler := bot.NewCancelHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(cancelHandler)
+
+	// Create and register /status command handler
+	statusHandler := bot.NewStatusHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(statusHandler)
+
+	// Create and register /reminders command handler, which also handles
+	// the buttons of songs that are not released yet
+	remindersHandler := bot.NewRemindersHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(remindersHandler)
+	telegramBot.RegisterCallbackHandler(remindersHandler)
+
+	// Create and register /strict command handler
+	strictHandler := bot.NewStrictHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(strictHandler)
+
+	// Create and register /language command handler
+	languageHandler := bot.NewLanguageHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(languageHandler)
+
+	// Create and register /storefront command handler
+	storefrontHandler := bot.NewStorefrontHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(storefrontHandler)
+
+	// Create and register /autodelete command handler
+	autoDeleteHandler := bot.NewAutoDeleteHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(autoDeleteHandler)
+
+	// Create and register /setqueue admin command handler
+	setQueueHandler := bot.NewSetQueueHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(setQueueHandler)
+
+	// Create and register /errors admin command handler
+	errorsHandler := bot.NewErrorsHandler(telegramBot, logger, logDedup)
+	telegramBot.RegisterCommandHandler(errorsHandler)
+
+	// Create and register /drift admin command handler
+	driftHandler := bot.NewDriftHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(driftHandler)
+
+	// Create and register /config admin command handler
+	configHandler := bot.NewConfigHandler(telegramBot, logger)
+	telegramBot.RegisterCommandHandler(configHandler)
+
+	// Create and register /stats admin command handler
+	statsHandler := bot.NewStatsHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(statsHandler)
+
+	// Create and register /clearqueue admin command handler
+	clearQueueHandler := bot.NewClearQueueHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(clearQueueHandler)
+
+	// Create and register /retag admin command handler
+	retagHandler := bot.NewRetagHandler(telegramBot, logger, songHandler)
+	telegramBot.RegisterCommandHandler(retagHandler)
+
 	// Log registered commands
 	registeredCommands := telegramBot.GetRouter().GetRegisteredCommands()
-	logger.Printf("Registered %d command handlers: %v", len(registeredCommands), registeredCommands)
+	logger.Info("Registered command handlers", logging.Int("Count", len(registeredCommands)), logging.Any("Commands", registeredCommands))
+
+	return songHandler
+}
+
+// setupMetrics returns the handler of /metrics: the latency metrics and, when
+// METRICS_ADDR is set, the counters of downloads, the queue and uploads
+func setupMetrics(cfg *config.BotConfig, telegramBot *bot.TelegramBot, songHandler *bot.SongHandler) http.Handler {
+	if cfg.MetricsAddr == "" {
+		return telegramBot.Latencies()
+	}
+
+	metrics := downloader.NewMetricsRegistry()
+	songHandler.SetMetrics(metrics)
+	return web.JoinMetrics(telegramBot.Latencies(), metrics)
+}
+
+// startHTTPServer starts the HTTP server when HTTP_ADDR is set, mounting the
+// metrics and, when PUBLIC_BASE_URL is also set, the download progress pages
+func startHTTPServer(cfg *config.BotConfig, logger logging.Logger, telegramBot *bot.TelegramBot, songHandler *bot.SongHandler, metricsHandler http.Handler) *web.Server {
+	if cfg.HTTPAddr == "" {
+		return nil
+	}
+
+	server := web.NewServer(cfg.HTTPAddr, logger)
+	server.Handle(web.MetricsPath, metricsHandler)
+	server.AddHealthCounter("schema_drift_total", songHandler.GetSchemaMonitor().DriftCount)
+	if cfg.PublicBaseURL != "" {
+		pages := bot.NewProgressPages(cfg.PublicBaseURL)
+		server.Handle(bot.ProgressPagePath, pages)
+		songHandler.SetProgressPages(pages)
+		logger.Info("Progress pages enabled", logging.String("URL", cfg.PublicBaseURL+bot.ProgressPagePath))
+	}
+
+	if err := server.Start(); err != nil {
+		logger.Warn("Failed to start HTTP server", logging.String("Address", cfg.HTTPAddr), logging.Err(err))
+		songHandler.SetProgressPages(nil)
+		return nil
+	}
+	return server
+}
+
+// startMetricsServer starts the metrics server when METRICS_ADDR is set
+func startMetricsServer(cfg *config.BotConfig, logger logging.Logger, metricsHandler http.Handler) *web.Server {
+	if cfg.MetricsAddr == "" {
+		return nil
+	}
+
+	server := web.NewServer(cfg.MetricsAddr, logger)
+	server.Handle(web.MetricsPath, metricsHandler)
+	if err := server.Start(); err != nil {
+		logger.Warn("Failed to start metrics server", logging.String("Address", cfg.MetricsAddr), logging.Err(err))
+		return nil
+	}
+	return server
 }
 
+// queueDrainTimeout is how long the shutdown waits for running downloads
+const queueDrainTimeout = 5 * time.Second
+
 // gracefulShutdown implements graceful startup and shutdown handling
-func gracefulShutdown(telegramBot *bot.TelegramBot, logger *log.Logger) {
+func gracefulShutdown(telegramBot *bot.TelegramBot, logger logging.Logger) {
 	// Create channel to listen for interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -151,7 +363,7 @@ func gracefulShutdown(telegramBot *bot.TelegramBot, logger *log.Logger) {
 
 	// Wait for shutdown signal
 	sig := <-sigChan
-	logger.Printf("Received signal: %v. Initiating graceful shutdown...", sig)
+	logger.Info("Received signal. Initiating graceful shutdown...", logging.String("Signal", sig.String()))
 
 	// Create shutdown context with timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
@@ -164,19 +376,23 @@ func gracefulShutdown(telegramBot *bot.TelegramBot, logger *log.Logger) {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				logger.Printf("Panic during shutdown: %v", r)
+				logger.Error("Panic during shutdown", logging.Any("Panic", r))
 				shutdownComplete <- fmt.Errorf("panic during shutdown: %v", r)
 			}
 		}()
 
-		logger.Printf("Stopping bot...")
-		if err := telegramBot.Stop(); err != nil {
-			logger.Printf("Error stopping bot: %v", err)
+		// Downloads still running after the drain timeout are re-queued, which
+		// leaves the rest of the shutdown timeout to stop the client
+		logger.Info("Stopping bot...")
+		drainCtx, drainCancel := context.WithTimeout(shutdownCtx, queueDrainTimeout)
+		defer drainCancel()
+		if err := telegramBot.Stop(drainCtx); err != nil {
+			logger.Error("Error stopping bot", logging.Err(err))
 			shutdownComplete <- err
 			return
 		}
 
-		logger.Printf("Bot stopped successfully")
+		logger.Info("Bot stopped successfully")
 		shutdownComplete <- nil
 	}()
 
@@ -184,21 +400,13 @@ func gracefulShutdown(telegramBot *bot.TelegramBot, logger *log.Logger) {
 	select {
 	case err := <-shutdownComplete:
 		if err != nil {
-			logger.Printf("Shutdown completed with error: %v", err)
+			logger.Fatal("Shutdown completed with error", logging.Err(err))
 			os.Exit(1)
 		}
-		logger.Printf("Graceful shutdown completed successfully")
+		logger.Info("Graceful shutdown completed successfully")
 
 	case <-shutdownCtx.Done():
-		logger.Printf("Shutdown timeout exceeded. Forcing exit...")
+		logger.Fatal("Shutdown timeout exceeded. Forcing exit...")
 		os.Exit(1)
 	}
 }
-
-// maskString masks sensitive information for logging
-func maskString(s string) string {
-	if len(s) <= 8 {
-		return "***"
-	}
-	return s[:4] + "***" + s[len(s)-4:]
-}
@@ -0,0 +1,114 @@
+// Package web serves the bot's optional HTTP endpoints: a health check and
+// whatever pages other packages mount on it
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-alac-bot/logging"
+)
+
+// HealthPath is the liveness endpoint served by every Server
+const HealthPath = "/healthz"
+
+// MetricsPath is where the bot's metrics are mounted in the Prometheus format
+const MetricsPath = "/metrics"
+
+// Server is a small net/http server shared by the bot's web endpoints
+type Server struct {
+	logger logging.Logger
+	mux    *http.ServeMux
+	srv    *http.Server
+
+	mu       sync.RWMutex
+	counters []healthCounter
+}
+
+// healthCounter is a value reported by the health check under a name
+type healthCounter struct {
+	name  string
+	value func() int64
+}
+
+// NewServer creates a Server listening on addr (e.g. ":8080") once started
+func NewServer(addr string, logger logging.Logger) *Server {
+	mux := http.NewServeMux()
+	s := &Server{
+		logger: logger,
+		mux:    mux,
+		srv: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+
+	mux.HandleFunc(HealthPath, s.handleHealth)
+	return s
+}
+
+// Handle mounts a handler on the server, following http.ServeMux patterns
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddHealthCounter makes the health check report value under name, one
+// "name value" line per counter after the status
+func (s *Server) AddHealthCounter(name string, value func() int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = append(s.counters, healthCounter{name: name, value: value})
+}
+
+// Handler returns the server's request router
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start binds the listening address and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("HTTP server listening", logging.String("Address", listener.Addr().String()))
+	go func() {
+		if err := s.srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server stopped", logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// JoinMetrics serves the pages of several metrics handlers as one, in order
+func JoinMetrics(handlers ...http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, handler := range handlers {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleHealth reports that the process is up, followed by the counters
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, counter := range s.counters {
+		fmt.Fprintf(w, "%s %d\n", counter.name, counter.value())
+	}
+}
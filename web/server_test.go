@@ -0,0 +1,72 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-alac-bot/logging"
+)
+
+func TestServer_Health(t *testing.T) {
+	server := NewServer("127.0.0.1:0", logging.Discard())
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != "ok\n" {
+		t.Errorf("Expected body %q, got %q", "ok\n", rec.Body.String())
+	}
+}
+
+func TestServer_Handle(t *testing.T) {
+	server := NewServer("127.0.0.1:0", logging.Discard())
+	server.Handle("/p/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Shutdown(context.Background())
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p/abc", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("Expected mounted handler to serve /p/abc, got status %d", rec.Code)
+	}
+}
+
+func TestServer_HealthCounters(t *testing.T) {
+	server := NewServer("127.0.0.1:0", logging.Discard())
+	drifts := int64(0)
+	server.AddHealthCounter("schema_drift_total", func() int64 { return drifts })
+
+	drifts = 3
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+
+	if want := "ok\nschema_drift_total 3\n"; rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q", want, rec.Body.String())
+	}
+}
+
+func TestJoinMetrics(t *testing.T) {
+	metric := func(line string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			w.Write([]byte(line))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	JoinMetrics(metric("a 1\n"), metric("b 2\n")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, MetricsPath, nil))
+
+	if want := "a 1\nb 2\n"; rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q", want, rec.Body.String())
+	}
+}